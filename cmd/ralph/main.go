@@ -1,25 +1,44 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/cloudosai/ralph-go/internal/config"
+	"github.com/cloudosai/ralph-go/internal/control"
+	"github.com/cloudosai/ralph-go/internal/lockfile"
 	"github.com/cloudosai/ralph-go/internal/loop"
+	"github.com/cloudosai/ralph-go/internal/notify"
 	"github.com/cloudosai/ralph-go/internal/parser"
+	"github.com/cloudosai/ralph-go/internal/plan"
+	"github.com/cloudosai/ralph-go/internal/progress"
+	"github.com/cloudosai/ralph-go/internal/render"
 	"github.com/cloudosai/ralph-go/internal/prompt"
+	"github.com/cloudosai/ralph-go/internal/runlog"
+	"github.com/cloudosai/ralph-go/internal/service"
+	"github.com/cloudosai/ralph-go/internal/specs"
 	"github.com/cloudosai/ralph-go/internal/stats"
+	"github.com/cloudosai/ralph-go/internal/telemetry"
 	"github.com/cloudosai/ralph-go/internal/tmux"
 	"github.com/cloudosai/ralph-go/internal/tui"
+	"github.com/cloudosai/ralph-go/internal/vcs"
 )
 
 func logFilePath() string {
@@ -39,6 +58,22 @@ func isAuthenticationText(text string) bool {
 		strings.Contains(lower, "invalid api key")
 }
 
+// formatContextWarning renders a context-window warning with any reported
+// token figures, e.g. "Context nearly full (190k/200k tokens, 5% left)".
+func formatContextWarning(w *parser.ContextWarning) string {
+	var details []string
+	if w.UsedTokens > 0 && w.LimitTokens > 0 {
+		details = append(details, fmt.Sprintf("%s/%s tokens", stats.FormatTokens(w.UsedTokens), stats.FormatTokens(w.LimitTokens)))
+	}
+	if w.PercentLeft >= 0 {
+		details = append(details, fmt.Sprintf("%d%% left", w.PercentLeft))
+	}
+	if len(details) == 0 {
+		return "Context nearly full"
+	}
+	return fmt.Sprintf("Context nearly full (%s)", strings.Join(details, ", "))
+}
+
 // NoopIterationThreshold is the number of consecutive no-op iterations (zero tool use,
 // cost < $0.01) before Ralph auto-stops the loop to avoid wasting money on exit loops.
 const NoopIterationThreshold = 2
@@ -51,20 +86,86 @@ const noopCostThreshold = 0.01
 // dbContext holds database connection and session metadata for stats tracking.
 type dbContext struct {
 	db        *sql.DB
+	runID     string
 	sessionID string
 	owner     string
 	repo      string
 	branch    string
+	inRepo    bool   // the run started inside a git repository
+	startHead string // HEAD when the run started ("" = no commits yet), for listing the run's commits
+	tracer    *telemetry.Tracer // OpenTelemetry spans for the run (nil = --otel-endpoint not set)
+	notifier  notify.Group      // lifecycle webhooks and Slack/Discord summaries (empty = none set)
+	startSnap stats.Snapshot    // stats when the run started, to report the run's own usage
+	startTime time.Time         // when the run started, for its elapsed time
+	completed int               // iterations completed so far
+	api       *control.Server   // the --listen API, counting iterations and errors for /metrics (nil = not set)
+}
+
+// runCommits returns the commits made since the run started, oldest first,
+// or nil outside a git repository.
+func (c *dbContext) runCommits() []vcs.Commit {
+	if c == nil || !c.inRepo {
+		return nil
+	}
+	commits, err := vcs.CommitsSince("", c.startHead)
+	if err != nil {
+		return nil
+	}
+	return commits
+}
+
+// commitSummary formats commits as a changelog, one "<hash> <subject>" per
+// line.
+func commitSummary(commits []vcs.Commit) string {
+	lines := make([]string, len(commits))
+	for i, c := range commits {
+		lines[i] = c.Hash + " " + c.Subject
+	}
+	return strings.Join(lines, "\n")
+}
+
+// reportCommits records the run's commits in the log and returns the TUI feed
+// message listing them; ok is false when the run has made no commits.
+func reportCommits(dbCtx *dbContext, logFile *runlog.Writer) (msg tui.Message, ok bool) {
+	commits := dbCtx.runCommits()
+	if len(commits) == 0 {
+		return tui.Message{}, false
+	}
+	summary := commitSummary(commits)
+	logFile.Log("commits", summary)
+	return tui.Message{
+		Role:    tui.RoleSystem,
+		Content: fmt.Sprintf("Commits this run (%d):\n%s", len(commits), summary),
+	}, true
+}
+
+// cliCommitLines formats the run's commits for the CLI summary, or returns
+// nil when there are none.
+func cliCommitLines(commits []vcs.Commit) []string {
+	if len(commits) == 0 {
+		return nil
+	}
+	noun := "commits"
+	if len(commits) == 1 {
+		noun = "commit"
+	}
+	lines := []string{fmt.Sprintf("[summary] %d %s this run:", len(commits), noun)}
+	for _, c := range commits {
+		lines = append(lines, "  "+c.Hash+" "+c.Subject)
+	}
+	return lines
 }
 
 // loopTracker tracks per-loop state for DB checkpoint flushing.
 type loopTracker struct {
 	currentLoopID   string
+	currentLoop     int
 	loopStartTime   time.Time
 	loopStartCost   float64
 	loopStartSnap   stats.Snapshot
 	lastFlushedCost float64
 	lastFlushedSnap stats.Snapshot
+	changes         vcs.Changes // what the loop committed, once reported
 }
 
 // expandDBPath returns the full path to the stats database (~/.ralph/ralph.db).
@@ -96,7 +197,8 @@ func migrateDB() {
 
 // initDBContext initializes the database and session context. Best-effort: returns
 // a dbContext with nil db on any error so callers can proceed without stats.
-func initDBContext() *dbContext {
+// runID is recorded on every checkpoint the context writes.
+func initDBContext(runID string) *dbContext {
 	migrateDB()
 	dbPath := expandDBPath()
 	if dbPath == "" {
@@ -120,6 +222,7 @@ func initDBContext() *dbContext {
 
 	return &dbContext{
 		db:        db,
+		runID:     runID,
 		sessionID: sessionID,
 		owner:     owner,
 		repo:      repo,
@@ -134,11 +237,14 @@ func (lt *loopTracker) startNewLoop(dbCtx *dbContext, tokenStats *stats.TokenSta
 	}
 	snap := tokenStats.Snapshot()
 	lt.currentLoopID = fmt.Sprintf("%s-%d", dbCtx.sessionID, loopNum)
+	lt.currentLoop = loopNum
 	lt.loopStartTime = time.Now().UTC()
 	lt.loopStartCost = snap.TotalCostUSD
 	lt.loopStartSnap = snap
 	lt.lastFlushedCost = snap.TotalCostUSD
 	lt.lastFlushedSnap = snap
+	lt.changes = vcs.Changes{}
+	dbCtx.tracer.StartIteration(loopNum)
 }
 
 // flushDelta computes delta stats since last flush and writes a checkpoint row.
@@ -164,6 +270,8 @@ func (lt *loopTracker) flushDelta(dbCtx *dbContext, tokenStats *stats.TokenStats
 		DeltaCacheCreation: snap.CacheCreationTokens - lt.lastFlushedSnap.CacheCreationTokens,
 		DeltaCacheRead:     snap.CacheReadTokens - lt.lastFlushedSnap.CacheReadTokens,
 		Timestamp:          time.Now().UTC().Format(time.RFC3339),
+		RunID:              dbCtx.runID,
+		Iteration:          lt.currentLoop,
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: checkpoint flush failed: %v\n", err)
@@ -174,7 +282,7 @@ func (lt *loopTracker) flushDelta(dbCtx *dbContext, tokenStats *stats.TokenStats
 
 // completeLoop flushes remaining delta and writes the loop_stats summary row.
 func (lt *loopTracker) completeLoop(dbCtx *dbContext, tokenStats *stats.TokenStats) {
-	if dbCtx == nil || dbCtx.db == nil || lt.currentLoopID == "" {
+	if dbCtx == nil || lt.currentLoopID == "" {
 		return
 	}
 	lt.flushDelta(dbCtx, tokenStats)
@@ -184,6 +292,24 @@ func (lt *loopTracker) completeLoop(dbCtx *dbContext, tokenStats *stats.TokenSta
 	loopOutput := snap.OutputTokens - lt.loopStartSnap.OutputTokens
 	loopCacheCreation := snap.CacheCreationTokens - lt.loopStartSnap.CacheCreationTokens
 	loopCacheRead := snap.CacheReadTokens - lt.loopStartSnap.CacheReadTokens
+	dbCtx.tracer.EndIteration(snap.TotalCostUSD-lt.loopStartCost, telemetry.Tokens{
+		Input:         loopInput,
+		Output:        loopOutput,
+		CacheCreation: loopCacheCreation,
+		CacheRead:     loopCacheRead,
+	})
+	dbCtx.completed++
+	dbCtx.api.CountIteration()
+	dbCtx.notifier.Send(notify.Payload{
+		Event:     notify.IterationComplete,
+		Iteration: lt.currentLoop,
+		CostUSD:   snap.TotalCostUSD - lt.loopStartCost,
+		Tokens:    loopInput + loopOutput + loopCacheCreation + loopCacheRead,
+	})
+	if dbCtx.db == nil {
+		lt.currentLoopID = ""
+		return
+	}
 	err := stats.WriteLoopStats(dbCtx.db, stats.LoopStatsParams{
 		LoopID:              lt.currentLoopID,
 		SessionID:           dbCtx.sessionID,
@@ -199,39 +325,973 @@ func (lt *loopTracker) completeLoop(dbCtx *dbContext, tokenStats *stats.TokenSta
 		TotalTokens:         loopInput + loopOutput + loopCacheCreation + loopCacheRead,
 		StartTime:           lt.loopStartTime.Format(time.RFC3339),
 		FinishTime:          now,
+		Hibernations:        snap.Hibernations - lt.loopStartSnap.Hibernations,
+		HibernateNs:         snap.HibernateNs - lt.loopStartSnap.HibernateNs,
+		Commits:             int64(lt.changes.Commits),
+		FilesChanged:        int64(lt.changes.Files),
+		Insertions:          int64(lt.changes.Insertions),
+		Deletions:           int64(lt.changes.Deletions),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: loop stats write failed: %v\n", err)
+	}
+	lt.currentLoopID = ""
+}
+
+// resultOutcome names how an iteration ended for its trace span.
+func resultOutcome(isError bool) string {
+	if isError {
+		return "error"
+	}
+	return "success"
+}
+
+// endTrace ends the run's trace span with outcome and waits briefly for the
+// spans to be exported.
+func endTrace(dbCtx *dbContext, outcome string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := dbCtx.tracer.Shutdown(ctx, outcome); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: OpenTelemetry export failed: %v\n", err)
+	}
+}
+
+// endNotify sends the run_complete webhook with outcome and the run's usage,
+// then waits briefly for queued webhooks to be delivered.
+func endNotify(dbCtx *dbContext, outcome string, tokenStats *stats.TokenStats) {
+	snap := tokenStats.Snapshot()
+	dbCtx.notifier.Send(notify.Payload{
+		Event:          notify.RunComplete,
+		Outcome:        outcome,
+		CostUSD:        snap.TotalCostUSD - dbCtx.startSnap.TotalCostUSD,
+		Tokens:         snap.TotalTokensCount - dbCtx.startSnap.TotalTokensCount,
+		Iterations:     dbCtx.completed,
+		ElapsedSeconds: time.Since(dbCtx.startTime).Seconds(),
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := dbCtx.notifier.Close(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: webhook notification failed: %v\n", err)
+	}
+}
+
+// notifyError sends the error webhook for an iteration error, and counts it
+// for /metrics.
+func notifyError(dbCtx *dbContext, lt *loopTracker, content string) {
+	dbCtx.notifier.Send(notify.Payload{Event: notify.Error, Iteration: lt.currentLoop, Message: content})
+	dbCtx.api.CountError()
+}
+
+// notifyHibernate sends the hibernate webhook when msg is the marker of a
+// rate-limit hibernation starting, not a hook's marker whose output mentions
+// it. Cost pacing is reported as budget_exceeded by checkCostPacing instead.
+func notifyHibernate(dbCtx *dbContext, msg loop.Message) {
+	if strings.Contains(msg.Content, "HIBERNATING") && !isHookMarker(msg.Content) {
+		dbCtx.notifier.Send(notify.Payload{Event: notify.Hibernate, Iteration: msg.Loop, Message: msg.Content})
+	}
+}
+
+// startStatsFlusher saves the project stats to the stats DB every interval, so
+// a hard crash loses at most one interval of usage rather than the whole run.
+// Ticks where nothing changed since the last save are skipped. The returned
+// stop function halts the flusher and waits for an in-flight save; callers
+// still do the final save on exit. A zero interval or missing DB disables it.
+func startStatsFlusher(dbCtx *dbContext, tokenStats *stats.TokenStats, interval time.Duration) (stop func()) {
+	if interval <= 0 || dbCtx == nil || dbCtx.db == nil {
+		return func() {}
+	}
+	key := stats.ProjectKey(dbCtx.owner, dbCtx.repo)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	last := tokenStats.Snapshot() // as loaded from the DB, so already saved
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				snap := tokenStats.Snapshot()
+				if snap == last {
+					continue
+				}
+				if err := stats.SaveProjectStats(dbCtx.db, key, tokenStats); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: periodic stats save failed: %v\n", err)
+					continue
+				}
+				last = snap
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+		})
+	}
+}
+
+// checkCostPacing queries the rolling 60-minute window cost and hibernates the loop
+// if it exceeds maxCostPerHour. Returns whether the budget was exceeded, the
+// current hour's cost, and the wake time (for caller notifications).
+func checkCostPacing(dbCtx *dbContext, maxCostPerHour float64, claudeLoop *loop.Loop) (exceeded bool, hourCost float64, nextHour time.Time) {
+	if maxCostPerHour <= 0 || dbCtx == nil || dbCtx.db == nil {
+		return false, 0, time.Time{}
+	}
+	cost, err := stats.QueryRollingHourCost(dbCtx.db, dbCtx.owner, dbCtx.repo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cost pacing query failed: %v\n", err)
+		return false, 0, time.Time{}
+	}
+	if cost < maxCostPerHour {
+		return false, cost, time.Time{}
+	}
+	next, err := stats.QueryRollingWakeTime(dbCtx.db, dbCtx.owner, dbCtx.repo, maxCostPerHour)
+	if err != nil {
+		next = time.Now().UTC().Add(60 * time.Minute)
+	}
+	if !claudeLoop.IsPacing() {
+		dbCtx.notifier.Send(notify.Payload{
+			Event:   notify.BudgetExceeded,
+			Message: fmt.Sprintf("Cost budget exceeded ($%.4f/$%.2f/hr), pausing until %s", cost, maxCostPerHour, next.Local().Format(time.Kitchen)),
+			CostUSD: cost,
+		})
+	}
+	claudeLoop.Pace(next)
+	return true, cost, next
+}
+
+// programOptions returns the Bubble Tea program options for the TUI. The
+// alternate screen is used by default; --no-alt-screen leaves it off so the
+// final frame stays in the terminal's scrollback after exit.
+func programOptions(cfg *config.Config) []tea.ProgramOption {
+	if cfg.NoAltScreen {
+		return nil
+	}
+	return []tea.ProgramOption{tea.WithAltScreen()}
+}
+
+// prepareResume handles `ralph resume`: it loads the run state from path and
+// makes os.Args the command line the run was started with, followed by the
+// flags given to resume, which take precedence. It returns the state and those
+// flags, or nil when not resuming.
+func prepareResume(path string) (*loop.RunState, []string, error) {
+	if len(os.Args) < 2 || os.Args[1] != "resume" {
+		return nil, nil, nil
+	}
+	state, err := loop.LoadRunState(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if state == nil {
+		return nil, nil, fmt.Errorf("nothing to resume: no run state in %s", path)
+	}
+	if state.Complete {
+		return nil, nil, fmt.Errorf("nothing to resume: the last run completed")
+	}
+	flags := os.Args[2:]
+	os.Args = append(append([]string{os.Args[0]}, state.Args...), flags...)
+	return state, flags, nil
+}
+
+// prepareService handles `ralph service install`: it makes os.Args the
+// command line the service is to run, so it is parsed and checked like any
+// other. It reports whether a service is being installed.
+func prepareService() (bool, error) {
+	if len(os.Args) < 2 || os.Args[1] != "service" {
+		return false, nil
+	}
+	if len(os.Args) < 3 || os.Args[2] != "install" {
+		return false, fmt.Errorf("usage: ralph service install [plan|build|plan-and-build|autoresearch] [flags]")
+	}
+	os.Args = append([]string{os.Args[0]}, os.Args[3:]...)
+	return true, nil
+}
+
+// installService writes a service that runs cfg's command line headless, in
+// --cli mode, from the current directory: a systemd user unit, or a launchd
+// agent on goos darwin. It prints how to start it to out.
+func installService(cfg *config.Config, goos string, out io.Writer) error {
+	switch {
+	case cfg.IsInitMode() || cfg.IsStatsMode() || cfg.IsParseMode() || cfg.IsReplayMode():
+		return fmt.Errorf("ralph service install runs a loop, not ralph %s", cfg.Subcommand)
+	case cfg.SpecSelect || cfg.ConfirmEachLoop || cfg.PlanReview:
+		return fmt.Errorf("--spec-select, --confirm-each-loop and --plan-review wait for input a service can't give")
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("finding the ralph binary: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	dir := workDir()
+	args := append([]string{exe}, runArgs(cfg)...)
+	if !cfg.CLI {
+		args = append(args, "--cli")
+	}
+	spec := service.Spec{
+		Name: service.Name(dir),
+		Args: args,
+		Dir:  dir,
+		Env:  service.Environment(os.Environ()),
+	}
+
+	path := service.Path(goos, home, os.Getenv("XDG_CONFIG_HOME"), spec.Name)
+	var content string
+	if goos == "darwin" {
+		spec.LogPath = filepath.Join(dir, ".ralph", "service.log")
+		if err := os.MkdirAll(filepath.Dir(spec.LogPath), 0755); err != nil {
+			return err
+		}
+		content = service.Launchd(spec)
+	} else {
+		spec.EnvFile = filepath.Join(dir, ".ralph", "service.env")
+		content = service.Systemd(spec)
+	}
+	replaced, err := service.Write(path, content)
+	if err != nil {
+		return err
+	}
+	if replaced {
+		fmt.Fprintf(out, "Replaced %s\n", path)
+	} else {
+		fmt.Fprintf(out, "Created %s\n", path)
+	}
+
+	fmt.Fprintf(out, "\nNext steps:\n")
+	if goos == "darwin" {
+		fmt.Fprintf(out, "  launchctl bootstrap gui/$(id -u) %s   # start it, and at every login\n", path)
+		fmt.Fprintf(out, "  tail -f %s   # follow its output\n", spec.LogPath)
+		fmt.Fprintf(out, "  launchctl bootout gui/$(id -u)/%s   # stop it once its iteration finishes\n", spec.Name)
+		return nil
+	}
+	fmt.Fprintf(out, "  systemctl --user daemon-reload\n")
+	fmt.Fprintf(out, "  systemctl --user enable --now %s   # start it, and at every login\n", spec.Name)
+	fmt.Fprintf(out, "  loginctl enable-linger $USER   # keep it running after you log out\n")
+	fmt.Fprintf(out, "  journalctl --user -u %s -f   # follow its output\n", spec.Name)
+	fmt.Fprintf(out, "Put API keys and other secrets in %s as KEY=value lines.\n", spec.EnvFile)
+	return nil
+}
+
+// flagGiven reports whether args set the named flag.
+func flagGiven(args []string, name string) bool {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if n, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "="); n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// runArgs returns the command line recorded in the run state: the subcommand
+// and flags this run was started with, without the --no-tmux the tmux wrapper
+// adds.
+func runArgs(cfg *config.Config) []string {
+	var args []string
+	if cfg.Subcommand != "" {
+		args = append(args, cfg.Subcommand)
+	}
+	flags := os.Args[1:]
+	if n := len(flags); tmux.WrappedSession() != "" && n > 0 && flags[n-1] == "--no-tmux" {
+		flags = flags[:n-1]
+	}
+	return append(args, flags...)
+}
+
+// parallelArgs returns the command line for loop k of a --parallel run: this
+// run's own, as a single loop outside tmux wrapping and without the control
+// API (the loops would compete for its address), with a run ID of its own.
+// The loop reads this run's config file, which its worktree may not have, and
+// covers the specs picked here, without asking again.
+func parallelArgs(cfg *config.Config, k int) []string {
+	runID := cfg.RunID
+	if len(runID) > 60 {
+		runID = runID[:60]
+	}
+	args := append(runArgs(cfg), "--parallel", "0", "--no-tmux", "--listen=", "--listen-token=", "--run-id", fmt.Sprintf("%s-%d", runID, k))
+	if cfg.ConfigFile != "" {
+		if abs, err := filepath.Abs(cfg.ConfigFile); err == nil {
+			args = append(args, "--config", abs)
+		}
+	}
+	if len(cfg.Specs) > 0 {
+		args = append(args, "--spec-select=false", "--specs", strings.Join(cfg.Specs, ","))
+	}
+	return args
+}
+
+// workerEvent returns the event for a line printed by loop k of a --parallel
+// run: the loop's own JSON event with --log-format json, otherwise the line
+// tagged with the loop number.
+func workerEvent(k int, line string, asJSON bool) render.Event {
+	var e render.Event
+	if asJSON && json.Unmarshal([]byte(line), &e) == nil && e.Type != "" {
+		e.Worker = k
+		return e
+	}
+	return render.Event{Type: "output", Content: line, Worker: k, Text: fmt.Sprintf("[loop %d] %s", k, line)}
+}
+
+// runParallel starts cfg.Parallel copies of this run, each in a new git
+// worktree on its own branch. In the TUI each gets a tmux window of its own;
+// with --cli they run here and their output is interleaved. It returns the
+// exit code: the first failing loop's, or 0.
+func runParallel(cfg *config.Config) int {
+	out := render.New(cfg.LogFormat, os.Stdout, os.Stderr)
+	if !cfg.CLI && !tmux.IsInsideTmux() {
+		out.Errorf("error", "--parallel shows each loop in a tmux window; install tmux or pass --cli")
+		return 1
+	}
+	bin, err := os.Executable()
+	if err != nil {
+		out.Errorf("error", "Cannot determine executable path: %v", err)
+		return 1
+	}
+	name := cfg.RunID
+	if len(name) > 8 {
+		name = name[:8]
+	}
+	trees, err := vcs.AddWorktrees(".", name, cfg.Parallel)
+	if err != nil {
+		out.Errorf("error", "Cannot create worktrees: %v", err)
+		return 1
+	}
+
+	if !cfg.CLI {
+		for i, wt := range trees {
+			argv := append([]string{bin}, parallelArgs(cfg, i+1)...)
+			if err := tmux.NewWindow(fmt.Sprintf("loop-%d", i+1), wt.Dir, argv); err != nil {
+				out.Errorf("error", "Cannot start loop %d: %v", i+1, err)
+				return 1
+			}
+		}
+		out.Printf("parallel", "Started %d loops in tmux windows loop-1 to loop-%d, on branches %s to %s", len(trees), len(trees), trees[0].Branch, trees[len(trees)-1].Branch)
+		return 0
+	}
+
+	cmds := make([]*exec.Cmd, len(trees))
+	for i, wt := range trees {
+		cmds[i] = exec.Command(bin, parallelArgs(cfg, i+1)...)
+		cmds[i].Dir = wt.Dir
+		out.Printf("parallel", "loop %d: %s in %s", i+1, wt.Branch, wt.Dir)
+	}
+	errs := loop.Supervise(cmds, func(line loop.WorkerLine) {
+		e := workerEvent(line.Worker+1, line.Text, out.JSON())
+		if line.Stderr {
+			out.EmitError(e)
+		} else {
+			out.Emit(e)
+		}
 	})
+	code := 0
+	for i, err := range errs {
+		status := "done"
+		if err != nil {
+			status = err.Error()
+			if code == 0 {
+				code = 1
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+					code = exitErr.ExitCode()
+				}
+			}
+		}
+		out.Printf("parallel", "loop %d: %s (%s)", i+1, status, trees[i].Branch)
+	}
+	return code
+}
+
+// selectSpecs settles the spec files the run covers. Names given with --specs
+// must be in the spec folder. With --spec-select and more than one file in
+// the spec folder, the user picks them from its index, and cfg.Specs is set
+// to the ones picked; ok is false when the user cancelled instead.
+func selectSpecs(cfg *config.Config) (ok bool, err error) {
+	if !cfg.SpecSelect && len(cfg.Specs) == 0 {
+		return true, nil
+	}
+	files, err := specs.Index(cfg.SpecFolder)
+	if err != nil {
+		return false, fmt.Errorf("indexing specs: %w", err)
+	}
+	if len(cfg.Specs) > 0 {
+		if _, err := specs.Select(files, cfg.Specs); err != nil {
+			return false, fmt.Errorf("--specs: %w in %s", err, cfg.SpecFolder)
+		}
+		return true, nil
+	}
+	if len(files) < 2 {
+		return true, nil
+	}
+	chosen, ok, err := tui.PickSpecs(cfg.SpecFolder, files)
+	if err != nil || !ok {
+		return false, err
+	}
+	cfg.Specs = specs.Names(chosen)
+	return true, nil
+}
+
+// promptRenderer returns the loops' PromptRenderer, which fills in a prompt
+// template with the run's spec path, goal and branch, the variables set with
+// --var, and the iteration. Unless cfg.TemplatePrompts, it returns prompts as
+// they are.
+func promptRenderer(cfg *config.Config, dbCtx *dbContext) loop.PromptRenderer {
+	render := func(content string, iteration, total int) (string, error) {
+		return content, nil
+	}
+	if cfg.TemplatePrompts() {
+		branch := ""
+		if dbCtx != nil {
+			branch = dbCtx.branch
+		}
+		vars := prompt.NewVars(cfg.GetSpecPath(), cfg.Goal, branch, cfg.Vars)
+		render = func(content string, iteration, total int) (string, error) {
+			return prompt.Render(content, vars, iteration, total)
+		}
+	}
+	if cfg.IterationsPerSpec == 0 {
+		return render
+	}
+	// --iterations-per-spec: each iteration's prompt names the spec it works on
+	queue := specQueue(cfg)
+	return func(content string, iteration, total int) (string, error) {
+		content, err := render(content, iteration, total)
+		if err != nil {
+			return "", err
+		}
+		if spec := queue.Spec(iteration); spec != "" {
+			content += specs.Context(cfg.SpecFolder, []string{spec})
+		}
+		return content, nil
+	}
+}
+
+// queueSpecs sets up the spec queue of --iterations-per-spec: the specs
+// given with --specs or --spec-select, else all of the spec folder's, each
+// getting cfg.IterationsPerSpec iterations.
+func queueSpecs(cfg *config.Config) error {
+	if cfg.IterationsPerSpec == 0 {
+		return nil
+	}
+	if len(cfg.Specs) == 0 {
+		files, err := specs.Index(cfg.SpecFolder)
+		if err != nil {
+			return fmt.Errorf("indexing specs: %w", err)
+		}
+		cfg.Specs = specs.Names(files)
+	}
+	if len(cfg.Specs) == 0 {
+		return fmt.Errorf("--iterations-per-spec: no spec files in %s", cfg.SpecFolder)
+	}
+	cfg.Iterations = cfg.IterationsPerSpec * len(cfg.Specs)
+	return nil
+}
+
+// specQueue returns the spec queue of --iterations-per-spec (zero without
+// it), recording its position in loop.DefaultQueueStatePath.
+func specQueue(cfg *config.Config) loop.SpecQueue {
+	if cfg.IterationsPerSpec == 0 {
+		return loop.SpecQueue{}
+	}
+	return loop.SpecQueue{Specs: cfg.Specs, PerSpec: cfg.IterationsPerSpec, Path: loop.DefaultQueueStatePath}
+}
+
+// specsContext returns the prompt's --specs section. With
+// --iterations-per-spec there is none: promptRenderer names the one spec each
+// iteration works on instead.
+func specsContext(cfg *config.Config) string {
+	if cfg.IterationsPerSpec > 0 {
+		return ""
+	}
+	return specs.Context(cfg.SpecFolder, cfg.Specs)
+}
+
+// queueResumeAt returns the iteration a resumed run of queue starts at: the
+// next one on the spec its saved position is on. Without a saved position,
+// or when the queue changed since, it warns and returns first.
+func queueResumeAt(queue loop.SpecQueue, first int) int {
+	if queue.Path == "" {
+		return first
+	}
+	saved, err := loop.LoadQueueState(queue.Path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return first
+	}
+	if saved == nil {
+		return first
+	}
+	if err := saved.CheckQueue(queue.Specs); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: not resuming the spec queue: %v\n", err)
+		return first
+	}
+	return queue.ResumeAt(saved)
+}
+
+// withRunState has the loop record the run state in loop.DefaultRunStatePath
+// after every iteration, and with --iterations-per-spec the spec queue's
+// position. With resume set it continues the recorded run: from the
+// iteration after the last one completed, or the next one on the spec the
+// queue was on, once any rate limit it was waiting out has reset.
+func withRunState(lc loop.Config, cfg *config.Config, tokenStats *stats.TokenStats, resume *loop.RunState) loop.Config {
+	lc.StatePath = loop.DefaultRunStatePath
+	lc.StateArgs = runArgs(cfg)
+	lc.StateStats = tokenStats.Snapshot
+	lc.StateBranch = cfg.Branch
+	lc.StateSpecs = cfg.Specs
+	lc.SpecQueue = specQueue(cfg)
+	if resume != nil {
+		lc.FirstIteration = queueResumeAt(lc.SpecQueue, resume.Iteration+1)
+		if resume.Hibernating && resume.HibernateUntil.After(lc.StartAt) {
+			lc.StartAt = resume.HibernateUntil
+		}
+	}
+	return lc
+}
+
+// transcripts has the loop record raw agent output under
+// loop.DefaultTranscriptDir, in a directory named for the run and, in
+// plan-and-build, its phase, unless --no-transcripts is set. With --redact
+// the output is redacted as the feed and logs are.
+func transcripts(cfg *config.Config, phase string) loop.Transcripts {
+	if cfg.NoTranscripts {
+		return loop.Transcripts{}
+	}
+	runID := cfg.RunID
+	if phase != "" {
+		runID += "-" + phase
+	}
+	t := loop.Transcripts{
+		Dir:      loop.DefaultTranscriptDir,
+		RunID:    runID,
+		MaxFiles: cfg.TranscriptMaxFiles,
+		MaxBytes: int64(cfg.TranscriptMaxMB) << 20,
+	}
+	if cfg.Redact {
+		// Patterns were checked by Validate, so this cannot fail in practice;
+		// if it does, record nothing rather than unredacted secrets.
+		redactor, err := parser.NewRedactor(cfg.RedactPatterns)
+		if err != nil {
+			return loop.Transcripts{}
+		}
+		t.Redact = redactor.Redact
+	}
+	return t
+}
+
+// agentBackend returns the --agent backend, or nil (the loop's default) for a
+// name Validate would reject.
+func agentBackend(name string) loop.Backend {
+	b, _ := loop.LookupBackend(name)
+	return b
+}
+
+// newJSONParser returns a stream-json parser configured from the flags that
+// shape what it extracts: excluded directories, the tool result size limit and
+// secret redaction.
+func newJSONParser(cfg *config.Config) *parser.Parser {
+	p := parser.NewParser()
+	p.SetExcludeDirs(cfg.ExcludeDirs)
+	p.SetMaxToolResultBytes(cfg.MaxToolResultBytes)
+	if cfg.Redact {
+		// Patterns were checked by Validate, so this cannot fail in practice.
+		redactor, err := parser.NewRedactor(cfg.RedactPatterns)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: redaction disabled: %v\n", err)
+		} else {
+			p.SetRedactor(redactor)
+		}
+	}
+	return p
+}
+
+// scaffoldProject writes the `ralph init` starter files under dir: a sample
+// spec in the spec folder, a starter implementation plan, and a .ralphrc.
+// Existing files are never overwritten; each one is reported and skipped.
+func scaffoldProject(dir string, cfg *config.Config, out io.Writer) error {
+	specTemplate, err := prompt.GetEmbeddedSpecTemplate()
+	if err != nil {
+		return err
+	}
+	planTemplate, err := prompt.GetEmbeddedPlanTemplate()
+	if err != nil {
+		return err
+	}
+	rcTemplate, err := prompt.GetEmbeddedRCTemplate()
+	if err != nil {
+		return err
+	}
+
+	specFolder := filepath.Join(dir, cfg.SpecFolder)
+	if err := os.MkdirAll(specFolder, 0755); err != nil {
+		return fmt.Errorf("creating spec folder %s: %w", cfg.SpecFolder, err)
+	}
+
+	files := []struct {
+		path    string
+		content string
+	}{
+		{filepath.Join(specFolder, "spec.md"), specTemplate},
+		{filepath.Join(dir, cfg.PlanFile), planTemplate},
+		{filepath.Join(dir, config.RCFile), rcTemplate},
+	}
+	for _, f := range files {
+		if _, err := os.Stat(f.path); err == nil {
+			fmt.Fprintf(out, "Skipped %s (already exists)\n", f.path)
+			continue
+		}
+		if err := os.WriteFile(f.path, []byte(f.content), 0644); err != nil {
+			return fmt.Errorf("creating %s: %w", f.path, err)
+		}
+		fmt.Fprintf(out, "Created %s\n", f.path)
+	}
+
+	fmt.Fprintf(out, "\nNext steps:\n")
+	fmt.Fprintf(out, "  1. Describe your goal and acceptance criteria in %s\n", files[0].path)
+	fmt.Fprintf(out, "  2. Adjust the defaults in %s\n", files[2].path)
+	fmt.Fprintf(out, "  3. Run `ralph plan` to build the plan, then `ralph` to start building\n")
+	return nil
+}
+
+// statsBreakdowns maps the `ralph stats` breakdown reports to the grouping
+// they query.
+var statsBreakdowns = map[string]string{
+	"hourly": stats.CostByHour,
+	"daily":  stats.CostByDay,
+	"loops":  stats.CostByLoop,
+}
+
+// statsWindow returns the start of the --since window and its description,
+// or the zero time and "all time" without one.
+func statsWindow(cfg *config.Config) (time.Time, string, error) {
+	if cfg.StatsSince == "" {
+		return time.Time{}, "all time", nil
+	}
+	d, err := config.ParseSince(cfg.StatsSince)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	return time.Now().Add(-d), "last " + cfg.StatsSince, nil
+}
+
+// printStats implements `ralph stats`: the totals report, or a cost breakdown
+// by hour, day or loop.
+func printStats(cfg *config.Config, dbPath string, out io.Writer) error {
+	if by, ok := statsBreakdowns[cfg.StatsCommand]; ok {
+		return printStatsBreakdown(cfg, by, dbPath, out)
+	}
+	return printStatsTotals(cfg, dbPath, out)
+}
+
+// printStatsBreakdown implements `ralph stats hourly|daily|loops`: cost and
+// tokens per UTC hour, UTC day or loop from the checkpoints in the stats
+// database at dbPath, which cover the last 7 days. A missing database prints
+// nothing recorded with a note, as printStatsTotals does.
+func printStatsBreakdown(cfg *config.Config, by, dbPath string, out io.Writer) error {
+	since, window, err := statsWindow(cfg)
+	if err != nil {
+		return err
+	}
+
+	var buckets []stats.CostBucket
+	note := ""
+	if _, err := os.Stat(dbPath); err != nil {
+		note = fmt.Sprintf("no stats database at %s yet", dbPath)
+	} else {
+		db, err := stats.InitDB(dbPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if buckets, err = stats.QueryCostBreakdown(db, by, since); err != nil {
+			return fmt.Errorf("querying stats: %w", err)
+		}
+	}
+
+	if cfg.StatsJSON {
+		report := struct {
+			Since   string             `json:"since,omitempty"`
+			Note    string             `json:"note,omitempty"`
+			By      string             `json:"by"`
+			Buckets []stats.CostBucket `json:"buckets"`
+		}{cfg.StatsSince, note, by, buckets}
+		if report.Buckets == nil {
+			report.Buckets = []stats.CostBucket{}
+		}
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(data))
+		return nil
+	}
+
+	fmt.Fprintf(out, "Ralph cost by %s (%s)\n", by, window)
+	var total float64
+	for _, b := range buckets {
+		fmt.Fprintf(out, "  %-20s %12s %10s tokens\n", b.Key, stats.FormatCost(b.Cost), stats.FormatTokens(b.Tokens))
+		total += b.Cost
+	}
+	if len(buckets) == 0 {
+		fmt.Fprintf(out, "  (nothing recorded)\n")
+	} else {
+		fmt.Fprintf(out, "  %-20s %12s\n", "Total:", stats.FormatCost(total))
+	}
+	if note != "" {
+		fmt.Fprintf(out, "\n(%s)\n", note)
+	}
+	return nil
+}
+
+// printStatsTotals implements `ralph stats total`: it sums cost and tokens
+// over every run recorded in the stats database at dbPath, optionally limited
+// to the --since window. A missing database prints zeros with a note rather
+// than failing, since nothing has been recorded yet.
+func printStatsTotals(cfg *config.Config, dbPath string, out io.Writer) error {
+	if cfg.StatsCommand != "total" {
+		return fmt.Errorf("unknown stats report %q (usage: ralph stats total|hourly|daily|loops [--since 7d] [--json])", cfg.StatsCommand)
+	}
+
+	since, window, err := statsWindow(cfg)
+	if err != nil {
+		return err
+	}
+
+	var totals stats.Totals
+	note := ""
+	if _, err := os.Stat(dbPath); err != nil {
+		note = fmt.Sprintf("no stats database at %s yet", dbPath)
+	} else {
+		db, err := stats.InitDB(dbPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if totals, err = stats.QueryTotals(db, since); err != nil {
+			return fmt.Errorf("querying stats: %w", err)
+		}
+	}
+
+	if cfg.StatsJSON {
+		report := struct {
+			Since string `json:"since,omitempty"`
+			Note  string `json:"note,omitempty"`
+			stats.Totals
+		}{cfg.StatsSince, note, totals}
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(data))
+		return nil
+	}
+
+	fmt.Fprintf(out, "Ralph totals (%s)\n", window)
+	fmt.Fprintf(out, "  %-20s %d\n", "Sessions:", totals.Sessions)
+	fmt.Fprintf(out, "  %-20s %d\n", "Iterations:", totals.Loops)
+	fmt.Fprintf(out, "  %-20s %d\n", "Input tokens:", totals.InputTokens)
+	fmt.Fprintf(out, "  %-20s %d\n", "Output tokens:", totals.OutputTokens)
+	fmt.Fprintf(out, "  %-20s %d\n", "Cache write tokens:", totals.CacheCreationTokens)
+	fmt.Fprintf(out, "  %-20s %d\n", "Cache read tokens:", totals.CacheReadTokens)
+	fmt.Fprintf(out, "  %-20s %d\n", "Total tokens:", totals.TotalTokens)
+	fmt.Fprintf(out, "  %-20s %s\n", "Total cost:", stats.FormatCost(totals.TotalCost))
+	if totals.Hibernations > 0 {
+		fmt.Fprintf(out, "  %-20s %s\n", "Rate limits:", hibernationSummary(totals.Hibernations, time.Duration(totals.HibernateNs)))
+	}
+	if totals.Commits > 0 {
+		changes := vcs.Changes{
+			Commits:    int(totals.Commits),
+			Files:      int(totals.FilesChanged),
+			Insertions: int(totals.Insertions),
+			Deletions:  int(totals.Deletions),
+		}
+		fmt.Fprintf(out, "  %-20s %s\n", "Commits:", changes)
+	}
+	if note != "" {
+		fmt.Fprintf(out, "\n(%s)\n", note)
+	}
+	return nil
+}
+
+// maxFailedLinesShown caps how many unparseable lines `ralph parse` lists.
+const maxFailedLinesShown = 20
+
+// printParseSummary implements `ralph parse --file capture.jsonl`: it runs
+// each captured line through the parser and reports message types, tool
+// uses, tokens and cost, and the lines that failed to parse.
+func printParseSummary(path string, out io.Writer) error {
+	if path == "" {
+		return fmt.Errorf("no capture to parse (usage: ralph parse --file capture.jsonl)")
+	}
+	f, err := os.Open(path)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: loop stats write failed: %v\n", err)
+		return err
 	}
-	lt.currentLoopID = ""
+	defer f.Close()
+	summary, err := parser.SummarizeStream(f)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	fmt.Fprintf(out, "Parsed %s: %d lines, %d failed\n", path, summary.Lines, len(summary.Failed))
+
+	fmt.Fprintln(out, "\nMessages by type:")
+	types := make([]string, 0, len(summary.Types))
+	for t := range summary.Types {
+		types = append(types, string(t))
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		fmt.Fprintf(out, "  %-20s %d\n", t+":", summary.Types[parser.MessageType(t)])
+	}
+
+	if len(summary.ToolUses) > 0 {
+		fmt.Fprintln(out, "\nTool uses:")
+		tools := make([]string, 0, len(summary.ToolUses))
+		for name := range summary.ToolUses {
+			tools = append(tools, name)
+		}
+		sort.Strings(tools)
+		for _, name := range tools {
+			fmt.Fprintf(out, "  %-20s %d\n", name+":", summary.ToolUses[name])
+		}
+	}
+
+	fmt.Fprintln(out, "\nUsage:")
+	fmt.Fprintf(out, "  %-20s %d\n", "Sessions:", len(summary.Sessions))
+	fmt.Fprintf(out, "  %-20s %d\n", "Input tokens:", summary.Usage.InputTokens)
+	fmt.Fprintf(out, "  %-20s %d\n", "Output tokens:", summary.Usage.OutputTokens)
+	fmt.Fprintf(out, "  %-20s %d\n", "Cache write tokens:", summary.Usage.CacheCreationInputTokens)
+	fmt.Fprintf(out, "  %-20s %d\n", "Cache read tokens:", summary.Usage.CacheReadInputTokens)
+	fmt.Fprintf(out, "  %-20s %s\n", "Total cost:", stats.FormatCost(summary.CostUSD))
+
+	if len(summary.Failed) > 0 {
+		fmt.Fprintln(out, "\nFailed lines:")
+		for i, fl := range summary.Failed {
+			if i == maxFailedLinesShown {
+				fmt.Fprintf(out, "  ... and %d more\n", len(summary.Failed)-i)
+				break
+			}
+			fmt.Fprintf(out, "  line %d: %s\n", fl.Line, fl.Text)
+		}
+	}
+	return nil
 }
 
-// checkCostPacing queries the rolling 60-minute window cost and hibernates the loop
-// if it exceeds maxCostPerHour. Returns whether the budget was exceeded, the
-// current hour's cost, and the wake time (for caller notifications).
-func checkCostPacing(dbCtx *dbContext, maxCostPerHour float64, claudeLoop *loop.Loop) (exceeded bool, hourCost float64, nextHour time.Time) {
-	if maxCostPerHour <= 0 || dbCtx == nil || dbCtx.db == nil {
-		return false, 0, time.Time{}
+// replayDelay is the wait before each replayed record at --speed: the
+// default pace divided by the speed, or none at speed 0.
+func replayDelay(speed float64) time.Duration {
+	if speed <= 0 {
+		return 0
 	}
-	cost, err := stats.QueryRollingHourCost(dbCtx.db, dbCtx.owner, dbCtx.repo)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: cost pacing query failed: %v\n", err)
-		return false, 0, time.Time{}
+	return time.Duration(float64(loop.DefaultReplayDelay) / speed)
+}
+
+// runReplay plays the transcripts in cfg.ReplayDir back through the parser
+// and TUI. A replay loop stands in for the agent, so nothing is re-run, and
+// the replay records no stats, checkpoints or notifications.
+func runReplay(cfg *config.Config) error {
+	if cfg.ReplayDir == "" {
+		return fmt.Errorf("ralph replay needs a transcript directory, e.g. ralph replay %s/<run-id>", loop.DefaultTranscriptDir)
 	}
-	if cost < maxCostPerHour {
-		return false, cost, time.Time{}
+	if cfg.ReplaySpeed < 0 {
+		return fmt.Errorf("--speed must not be negative, got %g", cfg.ReplaySpeed)
 	}
-	next, err := stats.QueryRollingWakeTime(dbCtx.db, dbCtx.owner, dbCtx.repo, maxCostPerHour)
+	files, err := loop.TranscriptFiles(cfg.ReplayDir)
 	if err != nil {
-		next = time.Now().UTC().Add(60 * time.Minute)
+		return err
 	}
-	claudeLoop.Hibernate(next)
-	return true, cost, next
+	replay := loop.NewReplay(files, agentBackend(cfg.Agent), replayDelay(cfg.ReplaySpeed))
+	tokenStats := stats.NewTokenStats()
+	dbCtx := &dbContext{runID: filepath.Base(cfg.ReplayDir)}
+
+	msgChan := make(chan tui.Message, 100)
+	doneChan := make(chan struct{})
+	model := tui.NewModelWithChannels(msgChan, doneChan)
+	model.SetStats(tokenStats)
+	model.SetLoopProgress(0, len(files))
+	model.SetLoop(replay)
+	model.SetFooterOnTop(cfg.TUILayout == "top")
+	model.SetMaxContentWidth(cfg.MaxContentWidth)
+	model.SetCompactFeed(cfg.CompactFeed)
+	model.SetStripANSI(cfg.StripANSI)
+	model.SetCurrentMode("Replaying")
+	program := tea.NewProgram(model, programOptions(cfg)...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+	go func() {
+		select {
+		case <-sigChan:
+			cancel()
+			close(doneChan)
+		case <-ctx.Done():
+		}
+	}()
+
+	go processLoopOutput(ctx, replay, newJSONParser(cfg), tokenStats, msgChan, doneChan, program, nil, dbCtx, 0)
+	replay.Start(ctx)
+	if _, err := program.Run(); err != nil {
+		return fmt.Errorf("running TUI: %w", err)
+	}
+	return nil
 }
 
 func main() {
+	// `ralph service install`: check the command line, then write the service
+	installing, err := prepareService()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// `ralph resume`: run the interrupted run's command line again
+	resume, resumeFlags, err := prepareResume(loop.DefaultRunStatePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Parse command-line flags and get configuration
 	cfg := config.ParseFlags()
+	if resume != nil && resume.Total > 0 && !flagGiven(resumeFlags, "iterations") {
+		cfg.Iterations = resume.Total
+	}
+	if resume != nil && resume.Branch != "" && !flagGiven(resumeFlags, "branch") {
+		// Continue on the branch the run created rather than a new one
+		cfg.Branch = resume.Branch
+	}
+	if resume != nil && len(resume.Specs) > 0 && !flagGiven(resumeFlags, "specs") {
+		// Cover the specs the run was started with rather than asking again
+		cfg.Specs, cfg.SpecSelect = resume.Specs, false
+	} else if resume != nil && len(resume.Specs) > 0 {
+		if err := resume.CheckSpecs(cfg.Specs); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: resuming with other specs: %v\n", err)
+		}
+	}
+	if installing {
+		if err := installService(cfg, runtime.GOOS, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Handle --version: print version and exit
 	if cfg.ShowVersion {
@@ -239,6 +1299,46 @@ func main() {
 		return
 	}
 
+	// Handle `ralph init`: scaffold starter files and exit
+	if cfg.IsInitMode() {
+		if err := scaffoldProject(".", cfg, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Handle `ralph stats`: report spend across recorded runs and exit
+	if cfg.IsStatsMode() {
+		stats.SetCostFormat(cfg.CostSymbol, cfg.CostDecimals)
+		migrateDB()
+		if err := printStats(cfg, expandDBPath(), os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Handle `ralph parse`: check a captured stream against the parser and exit
+	if cfg.IsParseMode() {
+		if err := printParseSummary(cfg.ParseFile, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Handle `ralph replay`: play a run's transcripts back in the TUI and exit
+	if cfg.IsReplayMode() {
+		stats.SetCostFormat(cfg.CostSymbol, cfg.CostDecimals)
+		stats.SetPriceRules(cfg.Prices)
+		if err := runReplay(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Handle --show-prompt: print embedded prompt and exit
 	if cfg.ShowPrompt {
 		var showLoader *prompt.Loader
@@ -312,10 +1412,17 @@ func main() {
 
 	// Wrap in tmux if not already inside one (skip in CLI mode)
 	if !cfg.CLI && tmux.ShouldWrap(cfg.NoTmux) {
-		if err := tmux.Wrap(cfg.Subcommand); err != nil {
+		subcommand, args := cfg.Subcommand, os.Args
+		if resume != nil {
+			// The wrapped ralph resumes the run itself
+			subcommand = "resume"
+			os.Args = append([]string{os.Args[0]}, resumeFlags...)
+		}
+		if err := tmux.Wrap(subcommand, cfg.RunID); err != nil {
 			fmt.Fprintf(os.Stderr, "Warning: Could not wrap in tmux: %v\n", err)
 			// Continue without tmux
 		}
+		os.Args = args
 	}
 
 	// Validate configuration
@@ -323,77 +1430,187 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
+	stats.SetCostFormat(cfg.CostSymbol, cfg.CostDecimals)
+	stats.SetPriceRules(cfg.Prices)
+	if ok, err := selectSpecs(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	} else if !ok {
+		fmt.Println("No specs chosen; not starting the run.")
+		return
+	}
+	if err := queueSpecs(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.Parallel > 1 {
+		os.Exit(runParallel(cfg))
+	}
+	if b := agentBackend(cfg.Agent); b != nil && (cfg.MaxCostPerHour > 0 || cfg.CostWarn > 0 || cfg.CostCrit > 0) {
+		switch {
+		case b.CostModel() == loop.CostUnknown:
+			fmt.Fprintf(os.Stderr, "Warning: %s reports no cost, so cost limits and warnings will not trigger\n", b.Name())
+		case b.CostModel() == loop.CostEstimated && len(cfg.Prices) == 0:
+			fmt.Fprintf(os.Stderr, "Warning: %s costs are estimated at Claude Sonnet rates; set its model's with --price, e.g. --price '*=1.25/10'\n", b.Name())
+		}
+	}
 
-	// Load the loop prompt (embedded or from override file)
-	var promptLoader *prompt.Loader
-	if cfg.IsAutoresearchMode() {
-		experimentFile := cfg.AutoresearchFile
-		if experimentFile == "" {
-			experimentFile = "specs/experiment.md"
+	// Refuse to share the working directory with another run
+	lock, err := lockfile.Acquire(lockfile.Name, cfg.Force)
+	if err != nil {
+		if errors.Is(err, lockfile.ErrHeld) {
+			fmt.Fprintf(os.Stderr, "Error: another ralph is already running in this directory (%v). Stop it, or pass --force to start anyway.\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		}
-		experimentContent, readErr := os.ReadFile(experimentFile)
-		if readErr != nil {
-			fmt.Fprintf(os.Stderr, "Error reading experiment file %s: %v\n", experimentFile, readErr)
+		os.Exit(1)
+	}
+	defer lock.Release()
+
+	// Move the run onto a branch of its own before anything records the current one
+	if cfg.Branch != "" {
+		branch := cfg.RunBranch(time.Now())
+		if err := vcs.CheckoutBranch("", branch); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --branch: %v\n", err)
+			lock.Release() // os.Exit skips deferred calls
 			os.Exit(1)
 		}
-		promptLoader = prompt.NewAutoresearchLoader(cfg.LoopPrompt, cfg.Goal, string(experimentContent))
-	} else if cfg.IsPlanMode() {
-		promptLoader = prompt.NewPlanLoader(cfg.LoopPrompt, cfg.Goal, cfg.PlanFile)
-	} else {
-		promptLoader = prompt.NewLoader(cfg.LoopPrompt, cfg.Goal, cfg.PlanFile)
+		cfg.Branch = branch
 	}
-	promptContent, err := promptLoader.Load()
+
+	// Load the loop prompt (embedded or from override file)
+	promptContent, err := loopPrompt(cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading prompt: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
+	// Optional first-iteration prompt, with the same substitutions as the loop prompt
+	var firstPromptContent string
+	if cfg.FirstPrompt != "" {
+		firstPromptContent, err = prompt.NewLoader(cfg.FirstPrompt, cfg.Goal, cfg.PlanFile).Load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading first prompt: %v\n", err)
+			os.Exit(1)
+		}
+		firstPromptContent += specsContext(cfg)
+	}
+
 	// Initialize DB context for stats tracking (best-effort)
-	dbCtx := initDBContext()
+	dbCtx := initDBContext(cfg.RunID)
 	if dbCtx.db != nil {
 		defer dbCtx.db.Close()
 	}
 
+	// Catch mistakes in the prompt templates before the run starts
+	renderPrompt := promptRenderer(cfg, dbCtx)
+	for _, content := range []string{promptContent, firstPromptContent} {
+		if _, err := renderPrompt(content, 1, cfg.Iterations); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			lock.Release() // os.Exit skips deferred calls
+			os.Exit(1)
+		}
+	}
+
+	// Remember where HEAD was so the summary can list the run's commits
+	if head, err := vcs.HeadCommit(""); err == nil {
+		dbCtx.inRepo, dbCtx.startHead = true, head
+	}
+
+	// Trace the run to an OpenTelemetry collector (no-op without --otel-endpoint)
+	dbCtx.tracer = telemetry.New(cfg.OtelEndpoint, map[string]string{
+		"ralph.run_id":   cfg.RunID,
+		"ralph.mode":     cfg.Subcommand,
+		"vcs.repository": strings.Trim(dbCtx.owner+"/"+dbCtx.repo, "/"),
+		"vcs.branch":     dbCtx.branch,
+	})
+
 	// Load existing stats from SQLite
 	tokenStats, err := stats.LoadProjectStats(dbCtx.db, stats.ProjectKey(dbCtx.owner, dbCtx.repo))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: Could not load project stats from DB: %v\n", err)
 		tokenStats = stats.NewTokenStats()
 	}
+	dbCtx.startSnap = tokenStats.Snapshot()
+	dbCtx.startTime = time.Now()
+
+	// Send lifecycle webhooks, and a run summary to Slack and Discord (no-op
+	// without --notify-webhook, --notify-slack or --notify-discord)
+	repoName := dbCtx.owner + "/" + dbCtx.repo
+	dbCtx.notifier = notify.Group{
+		notify.New(cfg.NotifyWebhook, notify.JSON, cfg.NotifyEvents, cfg.RunID, repoName, dbCtx.branch),
+		notify.New(cfg.NotifySlack, notify.Slack, []string{notify.RunComplete}, cfg.RunID, repoName, dbCtx.branch),
+		notify.New(cfg.NotifyDiscord, notify.Discord, []string{notify.RunComplete}, cfg.RunID, repoName, dbCtx.branch),
+	}
+	dbCtx.notifier.Send(notify.Payload{Event: notify.RunStart, Message: workDir()})
+
+	// Persist stats periodically so a crash doesn't lose the whole run
+	stopStatsFlusher := startStatsFlusher(dbCtx, tokenStats, cfg.StatsInterval)
+	defer stopStatsFlusher()
 
-	// Open log file in append mode; fall back to io.Discard on error
-	var logFile io.Writer
+	// Open log file in append mode; a nil log discards everything on error
+	var logFile *runlog.Writer
 	logPath := logFilePath()
 	logFileHandle, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: Could not open log file %s: %v\n", logPath, err)
-		logFile = io.Discard
 	} else {
-		logFile = logFileHandle
 		defer logFileHandle.Close()
-		fmt.Fprintf(logFileHandle, "\n--- ralph run started %s ---\n\n", time.Now().UTC().Format(time.RFC3339))
+		logFile = runlog.NewWriter(logFileHandle, cfg.LogFormat, cfg.RunID)
+		logFile.Start(workDir())
+	}
+
+	// Serve the control API, fed by the run log
+	var api *control.Server
+	if cfg.Listen != "" {
+		if logFile == nil {
+			logFile = runlog.NewWriter(io.Discard, cfg.LogFormat, cfg.RunID)
+		}
+		api = control.New(cfg.RunID, cfg.ListenToken, tokenStats.Snapshot)
+		dbCtx.api = api
+		logFile.SetTap(api.Record)
+		addr, stopAPI, err := api.Listen(cfg.Listen)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			lock.Release() // os.Exit skips deferred calls
+			os.Exit(1)
+		}
+		defer stopAPI()
+		logFile.Log("listen", "Control API on http://"+addr)
 	}
 
 	// CLI mode: run without TUI, output to stdout/stderr, exit when complete
 	if cfg.CLI {
 		var exitCode int
 		if cfg.IsPlanAndBuildMode() {
-			exitCode = runPlanAndBuildCLI(cfg, tokenStats, logFile, dbCtx)
+			exitCode = runPlanAndBuildCLI(cfg, tokenStats, logFile, dbCtx, api)
 		} else {
-			exitCode = runCLI(cfg, promptContent, tokenStats, logFile, dbCtx)
+			exitCode = runCLI(cfg, promptContent, firstPromptContent, tokenStats, logFile, dbCtx, resume, api)
 		}
+		stopStatsFlusher()
 		if err := stats.SaveProjectStats(dbCtx.db, stats.ProjectKey(dbCtx.owner, dbCtx.repo), tokenStats); err != nil {
 			fmt.Fprintf(os.Stderr, "Warning: Could not save project stats to DB: %v\n", err)
 		}
+		endTrace(dbCtx, resultOutcome(exitCode != 0))
+		endNotify(dbCtx, resultOutcome(exitCode != 0), tokenStats)
+		lock.Release() // os.Exit skips deferred calls
 		os.Exit(exitCode)
 	}
 
 	// Plan-and-build mode: run planning (1 iteration) then building (N iterations) in single TUI session
 	if cfg.IsPlanAndBuildMode() {
-		runPlanAndBuild(cfg, tokenStats, logFile, dbCtx)
+		finalModel, failed := runPlanAndBuild(cfg, tokenStats, logFile, dbCtx, api)
+		stopStatsFlusher()
 		if err := stats.SaveProjectStats(dbCtx.db, stats.ProjectKey(dbCtx.owner, dbCtx.repo), tokenStats); err != nil {
 			fmt.Fprintf(os.Stderr, "Warning: Could not save project stats to DB: %v\n", err)
 		}
+		endTrace(dbCtx, resultOutcome(failed))
+		endNotify(dbCtx, resultOutcome(failed), tokenStats)
+		if failed {
+			lock.Release() // os.Exit skips deferred calls
+			os.Exit(1)
+		}
+		closeWrappedSession(finalModel)
 		return
 	}
 
@@ -403,12 +1620,58 @@ func main() {
 
 	// Create the loop configuration
 	loopConfig := loop.Config{
-		Iterations: cfg.Iterations,
-		Prompt:     promptContent,
+		Iterations:      cfg.Iterations,
+		Prompt:          promptContent,
+		FirstPrompt:     firstPromptContent,
+		RenderPrompt:    promptRenderer(cfg, dbCtx),
+		CompactEvery:    cfg.CompactEvery,
+		StallNudgeAfter: cfg.StallNudgeAfter,
+		DoneAfterIdle:   cfg.DoneAfterIdle,
+		DoneSentinel:    cfg.DoneSentinel,
+		RestartOnCrash:  cfg.AgentRestartOnCrash,
+		RedoFresh:       cfg.RedoFresh,
+		DoneMarkers:     cfg.LoopSummary,
+		ThrashAction:    cfg.ThrashAction,
+		NoSleepOnError:  cfg.NoSleepOnError,
+		SuccessCodes:    cfg.AgentSuccessCodes,
+		Backend:         agentBackend(cfg.Agent),
+		Model:           cfg.ModelFor(cfg.IsPlanMode()),
+		FallbackModel:   cfg.FallbackModel,
+		FallbackAfter:   cfg.FallbackAfter,
+		SuccessCmd:      cfg.SuccessCmd,
+		SuccessRetries:  cfg.SuccessRetries,
+		StartAt:         cfg.StartTime(time.Now()),
+		StreamFormat:    cfg.StreamFormat,
+		ConfirmEachLoop: cfg.ConfirmEachLoop,
+		ExcludeDirs:     cfg.ExcludeDirs,
+		PreLoopHook:     cfg.PreLoopHook,
+		PostLoopHook:    cfg.PostLoopHook,
+		HookTimeout:     cfg.HookTimeout,
+		HookMustPass:    cfg.HookMustPass,
+		MaxRetries:      cfg.MaxRetries,
+		TotalRetries:    cfg.TotalRetries,
+		RetryFailed:     cfg.RetryFailed,
+		RetryBackoff:    cfg.RetryBackoff,
+		ActiveHours:     cfg.ActiveHours,
+		Deadline:        cfg.Deadline(time.Now()),
+		AgentTimeout:    cfg.IterationTimeout,
+		NoOutputTimeout: cfg.NoOutputTimeout,
+		Transcripts:     transcripts(cfg, ""),
+		CommitReport:    cfg.CommitReport,
+		WarnNoCommit:    cfg.WarnNoCommit,
+	}
+	loopConfig = withRunState(loopConfig, cfg, tokenStats, resume)
+	if resume != nil && resume.Paused {
+		// Stay paused until the user resumes, as when the run stopped
+		loopConfig.ConfirmStart = true
 	}
 
 	// Create the loop
 	claudeLoop := loop.New(loopConfig)
+	api.SetLoop(claudeLoop)
+	if resume != nil && resume.SessionID != "" {
+		claudeLoop.SetResumeSessionID(resume.SessionID)
+	}
 
 	// Create tmux status bar (no-op if not inside tmux)
 	tmuxBar := tmux.NewStatusBar()
@@ -417,14 +1680,25 @@ func main() {
 	model := tui.NewModelWithChannels(msgChan, doneChan)
 	model.SetStats(tokenStats)
 	model.SetBaseElapsed(time.Duration(tokenStats.TotalElapsedNs))
-	model.SetLoopProgress(0, cfg.Iterations)
+	completedIterations := 0
+	if resume != nil {
+		completedIterations = resume.Iteration
+	}
+	model.SetLoopProgress(completedIterations, cfg.Iterations)
 	model.SetLoop(claudeLoop)
 	model.SetTmuxStatusBar(tmuxBar)
 	model.SetGitContext(dbCtx.repo, dbCtx.branch)
-
-	// Parse implementation plan for task counts
-	completedTasks, totalTasks := parseTaskCounts(cfg.PlanFile)
-	model.SetCompletedTasks(completedTasks, totalTasks)
+	model.SetFooterOnTop(cfg.TUILayout == "top")
+	model.SetMaxContentWidth(cfg.MaxContentWidth)
+	model.SetCompactFeed(cfg.CompactFeed)
+	model.SetStripANSI(cfg.StripANSI)
+	model.SetCostThresholds(cfg.CostWarn, cfg.CostCrit)
+	model.SetScheduled(loopConfig.StartAt)
+	model.SetCloseAfter(cfg.CloseAfter)
+	model.SetWrappedSession(tmux.WrappedSession())
+
+	// Show the implementation plan's task counts and current task
+	model.SetPlanFile(planStatus(loadPlan(cfg.PlanFile)))
 
 	// Set current mode for TUI display
 	if cfg.IsPlanMode() {
@@ -436,23 +1710,25 @@ func main() {
 	}
 
 	// Create the Bubble Tea program (must be after SetLoop so the model copy has the loop reference)
-	program := tea.NewProgram(model, tea.WithAltScreen())
+	program := tea.NewProgram(model, programOptions(cfg)...)
+	stopPlanWatch := watchPlanFile(cfg.PlanFile, program)
+	defer stopPlanWatch()
 
 	// Set up context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Handle OS signals for graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigChan
+	stopSignals := shutdownSignals(func() {
+		program.Send(tui.SendDrain()())
+	}, func() {
 		cancel()
-		close(doneChan)
-	}()
+		closeDone(doneChan)
+	})
+	defer stopSignals()
 
 	// Create the parser
-	jsonParser := parser.NewParser()
+	jsonParser := newJSONParser(cfg)
 
 	// Start the processing goroutine
 	go processLoopOutput(ctx, claudeLoop, jsonParser, tokenStats, msgChan, doneChan, program, logFile, dbCtx, cfg.MaxCostPerHour)
@@ -461,15 +1737,219 @@ func main() {
 	claudeLoop.Start(ctx)
 
 	// Run the TUI (blocks until user quits)
-	if _, err := program.Run(); err != nil {
+	finalModel, err := program.Run()
+	failed := claudeLoop.SuccessFailed()
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error running TUI: %v\n", err)
-		os.Exit(1)
+		failed = true
 	}
 
 	// Save stats on exit
+	stopStatsFlusher()
 	if err := stats.SaveProjectStats(dbCtx.db, stats.ProjectKey(dbCtx.owner, dbCtx.repo), tokenStats); err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: Could not save project stats to DB: %v\n", err)
 	}
+	endTrace(dbCtx, resultOutcome(failed))
+	endNotify(dbCtx, resultOutcome(failed), tokenStats)
+	if failed {
+		lock.Release() // os.Exit skips deferred calls
+		os.Exit(1)
+	}
+	closeWrappedSession(finalModel)
+}
+
+// closeWrappedSession kills the tmux session ralph wrapped itself in when the
+// TUI closed itself after completion, so auto-exiting runs don't leave
+// sessions behind. It ends this process too, so it must run last.
+func closeWrappedSession(final tea.Model) {
+	m, ok := final.(tui.Model)
+	if !ok || !m.AutoClosed() {
+		return
+	}
+	if session := tmux.WrappedSession(); session != "" {
+		tmux.KillSession(session)
+	}
+}
+
+// shutdownSignals handles SIGINT and SIGTERM until stop is called. The first
+// SIGTERM calls drain, to let the running iteration finish and then exit as
+// q does in the TUI; SIGINT, or another SIGTERM, calls abort to exit now.
+func shutdownSignals(drain, abort func()) (stop func()) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		draining := false
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-sigChan:
+				if sig == syscall.SIGTERM && !draining {
+					draining = true
+					drain()
+					continue
+				}
+				abort()
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(sigChan)
+			close(done)
+		})
+	}
+}
+
+// loopPrompt loads the loop prompt of a build, plan or autoresearch run: the
+// --loop-prompt file, else the embedded prompt, with the --specs section.
+// An autoresearch prompt includes the experiment file as it is now.
+func loopPrompt(cfg *config.Config) (string, error) {
+	var promptLoader *prompt.Loader
+	if cfg.IsAutoresearchMode() {
+		experimentFile := cfg.AutoresearchFile
+		if experimentFile == "" {
+			experimentFile = "specs/experiment.md"
+		}
+		experimentContent, err := os.ReadFile(experimentFile)
+		if err != nil {
+			return "", fmt.Errorf("reading experiment file %s: %w", experimentFile, err)
+		}
+		promptLoader = prompt.NewAutoresearchLoader(cfg.LoopPrompt, cfg.Goal, string(experimentContent))
+	} else if cfg.IsPlanMode() {
+		promptLoader = prompt.NewPlanLoader(cfg.LoopPrompt, cfg.Goal, cfg.PlanFile)
+	} else {
+		promptLoader = prompt.NewLoader(cfg.LoopPrompt, cfg.Goal, cfg.PlanFile)
+	}
+	content, err := promptLoader.Load()
+	if err != nil {
+		return "", fmt.Errorf("loading prompt: %w", err)
+	}
+	return content + specsContext(cfg), nil
+}
+
+// reloadPrompt returns a cliTarget reload that loads a plan-and-build
+// phase's prompt with loader, with the --specs section.
+func reloadPrompt(loader *prompt.Loader, cfg *config.Config) func() (string, error) {
+	return func() (string, error) {
+		content, err := loader.Load()
+		if err != nil {
+			return "", err
+		}
+		return content + specs.Context(cfg.SpecFolder, cfg.Specs), nil
+	}
+}
+
+// headlessSignals handles a --cli run's control signals until stop is
+// called: SIGUSR1 pauses the target's loop once its iteration has finished,
+// or at once if a pause is already pending, SIGUSR2 resumes or wakes it, and
+// SIGHUP reloads its prompt for the next iteration. A reloaded prompt whose
+// template fails to render is reported and the loop keeps its prompt.
+func headlessSignals(target *cliTarget, renderPrompt loop.PromptRenderer, out *render.Renderer) (stop func()) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-sigChan:
+				l, reload := target.current()
+				if l == nil {
+					continue
+				}
+				switch sig {
+				case syscall.SIGUSR1:
+					if l.IsPausePending() {
+						out.Printf("signal", "SIGUSR1: pausing now")
+						l.Pause()
+					} else {
+						out.Printf("signal", "SIGUSR1: pausing after this iteration; send it again to interrupt the iteration")
+						l.PauseAfterIteration()
+					}
+				case syscall.SIGUSR2:
+					out.Printf("signal", "SIGUSR2: resuming")
+					if l.IsHibernating() {
+						l.Wake()
+					} else {
+						l.Resume()
+					}
+				case syscall.SIGHUP:
+					if reload == nil {
+						continue
+					}
+					content, err := reload()
+					if err == nil && renderPrompt != nil {
+						_, err = renderPrompt(content, 1, l.GetIterations())
+					}
+					if err != nil {
+						out.Errorf("error", "SIGHUP: keeping the current prompt: %v", err)
+						continue
+					}
+					l.SetPrompt(content)
+					out.Printf("signal", "SIGHUP: reloaded the prompt; it is sent from the next iteration")
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(sigChan)
+			close(done)
+		})
+	}
+}
+
+// cliTarget is the loop a CLI run's signals act on, with how to load its
+// prompt again. Plan-and-build moves from its plan loop to its build loop,
+// and a drain covers both.
+type cliTarget struct {
+	mu       sync.Mutex
+	loop     *loop.Loop
+	reload   func() (string, error)
+	draining bool
+}
+
+// set makes l, whose prompt reload loads, the loop signals act on, draining
+// it at once if drain was called.
+func (d *cliTarget) set(l *loop.Loop, reload func() (string, error)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.loop, d.reload = l, reload
+	if d.draining {
+		l.Drain()
+	}
+}
+
+// current returns the loop signals act on and how to reload its prompt.
+func (d *cliTarget) current() (*loop.Loop, func() (string, error)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.loop, d.reload
+}
+
+// drain drains the current loop and any set later.
+func (d *cliTarget) drain() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.draining = true
+	if d.loop != nil {
+		d.loop.Drain()
+	}
+}
+
+// closeDone closes doneChan unless it is already closed.
+func closeDone(doneChan chan struct{}) {
+	select {
+	case <-doneChan:
+	default:
+		close(doneChan)
+	}
 }
 
 // processLoopOutput reads from the loop's output channel, parses JSON, and updates the TUI
@@ -481,7 +1961,7 @@ func processLoopOutput(
 	msgChan chan<- tui.Message,
 	doneChan chan struct{},
 	program *tea.Program,
-	logFile io.Writer,
+	logFile *runlog.Writer,
 	dbCtx *dbContext,
 	maxCostPerHour float64,
 ) {
@@ -489,20 +1969,18 @@ func processLoopOutput(
 
 	loopOutput := claudeLoop.Output()
 	var loopTotalTokens int64       // per-loop token tracking for tmux status bar
-	var iterEstimate float64        // per-iteration estimated cost from token counts
-	var subagentCostAccum float64   // per-iteration accumulated subagent actual costs for reconciliation
 	var lastResultCost float64      // tracks previous result's cumulative total_cost_usd for delta computation
 	var iterToolUseCount int        // per-iteration tool use count for exit loop detection
 	var noopStreak int              // consecutive no-op iterations for exit loop detection
 	seenMsgIDs := make(map[string]bool) // dedup: CLI emits multiple chunks per message ID with identical usage
 	lt := &loopTracker{}
-	apiBackoff := loop.NewBackoff() // exponential backoff for API 529 errors
+	apiBackoff := claudeLoop.NewBackoff() // exponential backoff for API 529 errors
 
 	// Startup budget check — hibernate before first iteration if budget already exceeded
 	if exceeded, hourCost, nextHour := checkCostPacing(dbCtx, maxCostPerHour, claudeLoop); exceeded {
-		program.Send(tui.SendHibernate(nextHour)())
+		program.Send(tui.SendPaced(nextHour, "cost limit")())
 		msgChan <- tui.Message{
-			Role:    tui.RoleHibernate,
+			Role:    tui.RolePaced,
 			Content: fmt.Sprintf("Cost budget exceeded ($%.4f/$%.2f/hr) at startup, pausing until %s", hourCost, maxCostPerHour, nextHour.Format(time.Kitchen)),
 		}
 	}
@@ -519,9 +1997,9 @@ func processLoopOutput(
 		case <-ticker.C:
 			lt.flushDelta(dbCtx, tokenStats)
 			if exceeded, hourCost, nextHour := checkCostPacing(dbCtx, maxCostPerHour, claudeLoop); exceeded {
-				program.Send(tui.SendHibernate(nextHour)())
+				program.Send(tui.SendPaced(nextHour, "cost limit")())
 				msgChan <- tui.Message{
-					Role:    tui.RoleHibernate,
+					Role:    tui.RolePaced,
 					Content: fmt.Sprintf("Cost budget exceeded ($%.4f/$%.2f/hr), pausing until %s", hourCost, maxCostPerHour, nextHour.Format(time.Kitchen)),
 				}
 			}
@@ -537,7 +2015,7 @@ func processLoopOutput(
 				return
 			}
 
-			processMessage(msg, claudeLoop, jsonParser, tokenStats, msgChan, program, &loopTotalTokens, logFile, &iterEstimate, &subagentCostAccum, &lastResultCost, &iterToolUseCount, &noopStreak, dbCtx, lt, apiBackoff, seenMsgIDs)
+			processMessage(msg, claudeLoop, jsonParser, tokenStats, msgChan, program, &loopTotalTokens, logFile, &lastResultCost, &iterToolUseCount, &noopStreak, dbCtx, lt, apiBackoff, seenMsgIDs)
 		}
 	}
 }
@@ -551,9 +2029,7 @@ func processMessage(
 	msgChan chan<- tui.Message,
 	program *tea.Program,
 	loopTotalTokens *int64,
-	logFile io.Writer,
-	iterEstimate *float64,
-	subagentCostAccum *float64,
+	logFile *runlog.Writer,
 	lastResultCost *float64,
 	iterToolUseCount *int,
 	noopStreak *int,
@@ -564,12 +2040,21 @@ func processMessage(
 ) {
 	switch msg.Type {
 	case "loop_marker":
-		handleLoopMarker(msg, msgChan, program, loopTotalTokens, iterEstimate, subagentCostAccum, iterToolUseCount, dbCtx, lt, tokenStats, seenMsgIDs)
+		logFile.Loop(msg.Loop, msg.Content)
+		handleLoopMarker(msg, msgChan, program, jsonParser, loopTotalTokens, iterToolUseCount, dbCtx, lt, tokenStats, seenMsgIDs)
 		// Reset 529 backoff on successful new loop start (iteration completed without 529)
 		if isNewLoopStart(msg.Content) {
 			apiBackoff.Reset()
 		}
 
+	case "loop_marker_done":
+		summary := loopDoneSummary(msg, lt, tokenStats)
+		logFile.Loop(msg.Loop, summary)
+		msgChan <- tui.Message{
+			Role:    tui.RoleLoopDone,
+			Content: summary,
+		}
+
 	case "output":
 		// Try to parse as JSON first
 		parsed := jsonParser.ParseLine(msg.Content)
@@ -578,13 +2063,24 @@ func processMessage(
 			if sessionID := jsonParser.GetSessionID(parsed); sessionID != "" {
 				claudeLoop.SetSessionID(sessionID)
 			}
-			handleParsedMessage(parsed, claudeLoop, jsonParser, tokenStats, msgChan, program, loopTotalTokens, logFile, iterEstimate, subagentCostAccum, lastResultCost, iterToolUseCount, noopStreak, apiBackoff, seenMsgIDs)
+			handleParsedMessage(parsed, claudeLoop, jsonParser, tokenStats, msgChan, program, loopTotalTokens, logFile, lastResultCost, iterToolUseCount, noopStreak, apiBackoff, seenMsgIDs, dbCtx.tracer)
 		} else {
 			// Check if it's a loop marker in the output stream
 			loopMarker := jsonParser.ParseLoopMarker(msg.Content)
 			if loopMarker != nil {
 				program.Send(tui.SendLoopUpdate(loopMarker.Current, loopMarker.Total)())
 			}
+			// Check for plain-text rate-limit and overload errors (e.g. on stderr)
+			hibernateOnThrottle(jsonParser.DetectThrottleText(msg.Content, time.Now()), claudeLoop, apiBackoff, msgChan, program)
+			// Check for plain-text context-window warnings (e.g. on stderr)
+			if warning := jsonParser.DetectContextWarningText(msg.Content); warning != nil {
+				label := formatContextWarning(warning)
+				program.Send(tui.SendContextWarning(label)())
+				msgChan <- tui.Message{
+					Role:    tui.RoleSystem,
+					Content: "⚠ " + label,
+				}
+			}
 			// Check for plain-text authentication errors (e.g., "Please run claude /login")
 			if isAuthenticationText(msg.Content) {
 				if os.Getenv("ANTHROPIC_API_KEY") != "" {
@@ -603,6 +2099,7 @@ func processMessage(
 		}
 
 	case "error":
+		notifyError(dbCtx, lt, msg.Content)
 		msgChan <- tui.Message{
 			Role:    tui.RoleSystem,
 			Content: fmt.Sprintf("Error: %s", msg.Content),
@@ -614,6 +2111,9 @@ func processMessage(
 			Role:    tui.RoleSystem,
 			Content: msg.Content,
 		}
+		if commits, ok := reportCommits(dbCtx, logFile); ok {
+			msgChan <- commits
+		}
 		// Signal TUI that the loop has completed its current iterations.
 		// The loop stays alive waiting for more iterations (post-completion extension),
 		// so we send doneMsg explicitly rather than relying on channel closure.
@@ -621,16 +2121,49 @@ func processMessage(
 	}
 }
 
+// loopDoneSummary completes a loop_marker_done message with the iteration's
+// cost, e.g. "LOOP 3/20 done — 42s, $0.080000", and the model it ran with
+// when --model chose one. The loop only knows the elapsed time; cost comes
+// from the stats the tracker measures the loop by.
+func loopDoneSummary(msg loop.Message, lt *loopTracker, tokenStats *stats.TokenStats) string {
+	summary := fmt.Sprintf("%s, %s", msg.Content, stats.FormatCost(tokenStats.Snapshot().TotalCostUSD-lt.loopStartCost))
+	if msg.Model != "" {
+		summary += ", " + msg.Model
+	}
+	return summary
+}
+
+// recordHibernation adds a finished rate-limit hibernation to the stats: the
+// loop reports its length on the WAKING marker that ends it.
+func recordHibernation(msg loop.Message, tokenStats *stats.TokenStats) {
+	if msg.Elapsed > 0 {
+		tokenStats.AddHibernation(msg.Elapsed)
+	}
+}
+
+// recordChanges keeps what the loop committed, reported on its GIT marker,
+// for the loop's stats row.
+func recordChanges(msg loop.Message, lt *loopTracker) {
+	if msg.Changes != nil {
+		lt.changes = *msg.Changes
+	}
+}
+
 // handleLoopMarker processes a loop_marker message for TUI mode.
 // Shared by processMessage, processPlanPhase, and processBuildPhase.
-func handleLoopMarker(msg loop.Message, msgChan chan<- tui.Message, program *tea.Program, loopTotalTokens *int64, iterEstimate *float64, subagentCostAccum *float64, iterToolUseCount *int, dbCtx *dbContext, lt *loopTracker, tokenStats *stats.TokenStats, seenMsgIDs map[string]bool) {
+func handleLoopMarker(msg loop.Message, msgChan chan<- tui.Message, program *tea.Program, jsonParser *parser.Parser, loopTotalTokens *int64, iterToolUseCount *int, dbCtx *dbContext, lt *loopTracker, tokenStats *stats.TokenStats, seenMsgIDs map[string]bool) {
 	program.Send(tui.SendLoopUpdate(msg.Loop, msg.Total)())
+	if !msg.Until.IsZero() {
+		program.Send(tui.SendScheduled(msg.Until)())
+	}
+	recordHibernation(msg, tokenStats)
+	recordChanges(msg, lt)
+	notifyHibernate(dbCtx, msg)
 	// Detect new loop iteration start (not STOPPED/COMPLETED/RESUMED/RETRY)
 	if isNewLoopStart(msg.Content) {
 		lt.startNewLoop(dbCtx, tokenStats, msg.Loop)
 		*loopTotalTokens = 0
-		*iterEstimate = 0
-		*subagentCostAccum = 0
+		tokenStats.EndIteration()
 		*iterToolUseCount = 0
 		clear(seenMsgIDs)
 		program.Send(tui.SendLoopStarted()())
@@ -639,14 +2172,24 @@ func handleLoopMarker(msg loop.Message, msgChan chan<- tui.Message, program *tea
 		// Hibernate retry: reset iteration counters but do NOT create a new DB entry
 		// and do NOT reset apiBackoff (callers handle that separately)
 		*loopTotalTokens = 0
-		*iterEstimate = 0
-		*subagentCostAccum = 0
+		tokenStats.EndIteration()
 		*iterToolUseCount = 0
 	}
+	// Record the model the iteration runs with; a retry may switch it
+	if msg.Model != "" && (isNewLoopStart(msg.Content) || isRetryLoopStart(msg.Content)) {
+		program.Send(tui.SendIterationModel(msg.Model)())
+	}
+	// A new attempt starts without the last one's subagents, which a killed
+	// agent never ended
+	if (isNewLoopStart(msg.Content) || isRetryLoopStart(msg.Content)) && jsonParser.ResetSubagents() {
+		program.Send(tui.SendSubagentUpdate(nil)())
+	}
 	// Use stop sign emoji for STOPPED messages
 	role := tui.RoleLoop
-	if strings.Contains(msg.Content, "STOPPED") {
+	if strings.Contains(msg.Content, "STOPPED") && !isHookMarker(msg.Content) {
 		role = tui.RoleLoopStopped
+	} else if (strings.Contains(msg.Content, "PACED") || strings.Contains(msg.Content, "SCHEDULED")) && !isHookMarker(msg.Content) {
+		role = tui.RolePaced
 	}
 	msgChan <- tui.Message{
 		Role:    role,
@@ -664,6 +2207,29 @@ func toTUIPlan(items []parser.PlanItem) []tui.PlanItem {
 	return out
 }
 
+// toTUISubagents converts parser Subagents into the tui package's
+// SubagentItems, estimating the cost of those whose result hasn't reported it.
+func toTUISubagents(subagents []parser.Subagent) []tui.SubagentItem {
+	out := make([]tui.SubagentItem, 0, len(subagents))
+	for _, s := range subagents {
+		u := s.Usage
+		item := tui.SubagentItem{
+			Label:     s.Label(),
+			Depth:     s.Depth,
+			Status:    s.Status,
+			Tokens:    u.InputTokens + u.OutputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens,
+			CostUSD:   s.CostUSD,
+			ToolCalls: s.ToolCalls,
+		}
+		if item.CostUSD == 0 {
+			item.CostUSD = stats.EstimateCostFromTokens(s.Model, u.InputTokens, u.OutputTokens, u.CacheCreationInputTokens, u.CacheReadInputTokens)
+			item.Estimated = item.CostUSD > 0
+		}
+		out = append(out, item)
+	}
+	return out
+}
+
 // handleParsedMessage processes a parsed JSON message from Claude for TUI mode.
 // Shared by standard mode and plan-and-build mode.
 func handleParsedMessage(
@@ -674,14 +2240,13 @@ func handleParsedMessage(
 	msgChan chan<- tui.Message,
 	program *tea.Program,
 	loopTotalTokens *int64,
-	logFile io.Writer,
-	iterEstimate *float64,
-	subagentCostAccum *float64,
+	logFile *runlog.Writer,
 	lastResultCost *float64,
 	iterToolUseCount *int,
 	noopStreak *int,
 	apiBackoff *loop.Backoff,
 	seenMsgIDs map[string]bool,
+	tracer *telemetry.Tracer,
 ) {
 	// Check for rate limit rejection — enter hibernate state
 	if rejected, resetsAt := jsonParser.IsRateLimitRejected(parsed); rejected {
@@ -736,6 +2301,11 @@ func handleParsedMessage(
 		return // Don't process further
 	}
 
+	// Check for rate-limit and overload errors given as error text
+	if hibernateOnThrottle(jsonParser.DetectThrottle(parsed, time.Now()), claudeLoop, apiBackoff, msgChan, program) {
+		return // Don't process further
+	}
+
 	// Check for authentication error — stop loop with helpful message
 	if jsonParser.IsAuthenticationError(parsed) {
 		if os.Getenv("ANTHROPIC_API_KEY") != "" {
@@ -753,6 +2323,32 @@ func handleParsedMessage(
 		return
 	}
 
+	// Check for context-window warnings — flag them so the user can consider compacting
+	if warning := jsonParser.DetectContextWarning(parsed); warning != nil {
+		label := formatContextWarning(warning)
+		program.Send(tui.SendContextWarning(label)())
+		msgChan <- tui.Message{
+			Role:    tui.RoleSystem,
+			Content: "⚠ " + label,
+		}
+	}
+
+	// Track whether the agent's turn ends on a question nobody will answer
+	question := jsonParser.AwaitingInput(parsed)
+
+	// Warn about tool calls the agent keeps repeating
+	for _, thrash := range jsonParser.ObserveThrash(parsed) {
+		content := fmt.Sprintf("⚠ Thrashing detected: %s repeated %d times in %d iterations", thrash.Title, thrash.Count, thrash.Iterations)
+		switch claudeLoop.Thrashing(thrash.Title) {
+		case loop.ThrashNudge:
+			content += " — nudging the agent"
+		case loop.ThrashStop:
+			content += " — stopping"
+		}
+		msgChan <- tui.Message{Role: tui.RoleSystem, Content: content}
+		logFile.Log("thrash", content)
+	}
+
 	// Extract usage information — deduplicate by message ID.
 	// The CLI emits multiple chunks per message ID (one per content block),
 	// each carrying identical cumulative usage. Only process usage once per message.
@@ -776,9 +2372,12 @@ func handleParsedMessage(
 				usage.CacheCreationInputTokens,
 				usage.CacheReadInputTokens,
 			)
-			tokenStats.AddCost(estimate)
-			*iterEstimate += estimate
+			tokenStats.AddEstimate(jsonParser.GetParentToolUseID(parsed), estimate)
 			program.Send(tui.SendStatsUpdate(tokenStats)())
+			// The main agent's messages name the model the iteration runs on
+			if model := jsonParser.GetModel(parsed); model != "" && jsonParser.GetParentToolUseID(parsed) == "" {
+				program.Send(tui.SendIterationModel(model)())
+			}
 			// Also track per-loop tokens for tmux status bar
 			loopTokens := usage.InputTokens + usage.OutputTokens + usage.CacheCreationInputTokens + usage.CacheReadInputTokens
 			*loopTotalTokens += loopTokens
@@ -799,19 +2398,24 @@ func handleParsedMessage(
 				iterActualCost = cost
 			}
 			*lastResultCost = cost
-			// Replace accumulated estimates AND subagent actuals with actual cost
+			// Replace the iteration's estimates AND subagent actuals with actual cost
 			// The main result's total_cost_usd already includes subagent costs
-			tokenStats.ReconcileCost(*iterEstimate+*subagentCostAccum, iterActualCost)
-			*iterEstimate = 0
-			*subagentCostAccum = 0
+			tokenStats.ReconcileIteration(iterActualCost)
 		} else {
-			// Subagent result: add actual cost for real-time visibility, track for later reconciliation
-			tokenStats.AddCost(cost)
-			*subagentCostAccum += cost
+			// Subagent result: its actual cost replaces its estimates for real-time visibility
+			tokenStats.AddSubagentCost(jsonParser.GetParentToolUseID(parsed), cost)
 		}
 		program.Send(tui.SendStatsUpdate(tokenStats)())
 	}
 
+	// Subagent nesting depth, so the feed can indent subagent activity
+	depth := jsonParser.SubagentDepth(parsed)
+
+	// Group subagents under the Task that spawned them, with their share of the cost
+	if jsonParser.ObserveSubagents(parsed) {
+		program.Send(tui.SendSubagentUpdate(toTUISubagents(jsonParser.Subagents()))())
+	}
+
 	// Process message content based on type
 	switch parsed.Type {
 	case parser.MessageTypeSystem:
@@ -828,67 +2432,67 @@ func handleParsedMessage(
 			program.Send(tui.SendPlanUpdate(toTUIPlan(content.Plan))())
 		}
 
-		// Display thinking blocks
-		if content.Thinking != "" {
-			msgChan <- tui.Message{
-				Role:    tui.RoleThinking,
-				Content: content.Thinking,
-			}
-			fmt.Fprintf(logFile, "[thinking] %s\n\n", content.Thinking)
-		}
+		// Display thinking, text and tool uses, scanning text for task
+		// references. Tool uses are ACP-modeled lifecycle rows: each starts
+		// in_progress and is flipped to completed/failed when its
+		// tool_result arrives (see MessageTypeUser). All of them, TodoWrite
+		// included, count for noop detection.
+		*iterToolUseCount += len(content.ToolUses)
+		for _, line := range parser.FormatForDisplay(content) {
+			switch line.Role {
+			case parser.DisplayThinking:
+				msgChan <- tui.Message{
+					Role:    tui.RoleThinking,
+					Content: line.Text,
+					Depth:   depth,
+				}
+				logFile.Log("thinking", line.Text)
 
-		// Display text content and scan for task references
-		for _, text := range content.TextContent {
-			if text != "" {
+			case parser.DisplayAssistant:
 				msgChan <- tui.Message{
 					Role:    tui.RoleAssistant,
-					Content: text,
+					Content: line.Text,
+					Depth:   depth,
 				}
-				fmt.Fprintf(logFile, "[assistant] %s\n\n", text)
-				// Detect IMPLEMENTATION_PLAN.md task references
-				if ref := jsonParser.ExtractTaskReference(text); ref != nil {
+				logFile.Log("assistant", line.Text)
+				// Detect IMPLEMENTATION_PLAN.md task references, falling back
+				// to a summary of what the agent says it is doing
+				if ref := jsonParser.ExtractTaskReference(line.Text); ref != nil {
 					taskLabel := fmt.Sprintf("#%d", ref.Number)
 					if ref.Description != "" {
 						taskLabel = fmt.Sprintf("#%d %s", ref.Number, ref.Description)
 					}
 					program.Send(tui.SendTaskUpdate(taskLabel)())
+				} else if activity := jsonParser.ExtractActivitySummary(line.Text); activity != "" {
+					program.Send(tui.SendActivityUpdate(activity)())
 				}
-			}
-		}
 
-		// Display tool uses as ACP-modeled lifecycle rows and count for noop
-		// detection. Each row starts in_progress and is flipped to
-		// completed/failed when its tool_result arrives (see MessageTypeUser).
-		*iterToolUseCount += len(content.ToolUses)
-		for _, toolUse := range content.ToolUses {
-			// TodoWrite is represented by the plan panel, not a redundant
-			// lifecycle row. It still counts toward iterToolUseCount above so
-			// noop-exit detection is unchanged.
-			if toolUse.Name == "TodoWrite" {
-				continue
-			}
-			toolMsg := toolUse.Title
-			if toolMsg == "" {
-				toolMsg = "Using tool: " + toolUse.Name
-			}
-			if toolUse.Location != "" && !strings.Contains(toolMsg, toolUse.Location) {
-				toolMsg = fmt.Sprintf("%s — %s", toolMsg, toolUse.Location)
-			}
-			msgChan <- tui.Message{
-				Role:      tui.RoleTool,
-				Content:   toolMsg,
-				ToolUseID: toolUse.ID,
-				Kind:      string(toolUse.Kind),
-				Status:    string(parser.ToolStatusInProgress),
+			case parser.DisplayTool:
+				tracer.ToolUse(line.ToolUseID, jsonParser.GetParentToolUseID(parsed), line.ToolName, line.Location)
+				msgChan <- tui.Message{
+					Role:      tui.RoleTool,
+					Content:   line.Text,
+					ToolUseID: line.ToolUseID,
+					Kind:      string(line.Kind),
+					Status:    string(parser.ToolStatusInProgress),
+					Depth:     depth,
+				}
+				if dir := jsonParser.ExcludedEditDir(line.Kind, line.Location); dir != "" {
+					msgChan <- tui.Message{
+						Role:    tui.RoleSystem,
+						Content: fmt.Sprintf("⚠ %s targets excluded directory %s: %s", line.ToolName, dir, line.Location),
+					}
+				}
 			}
 		}
 
 	case parser.MessageTypeUser:
-		// Skip tool result content in TUI mode (file dumps are too verbose).
-		// Flip the matching tool row to completed/failed, and still scan for
+		// Skip tool result content in the TUI feed (file dumps are too verbose);
+		// the latest one goes to the detail pane instead. Flip the matching tool row to completed/failed, and still scan for
 		// task references in the results.
 		content := jsonParser.ExtractContent(parsed)
 		for _, toolResult := range content.ToolResults {
+			tracer.ToolResult(toolResult.ToolUseID, toolResult.IsError)
 			if toolResult.ToolUseID != "" {
 				status := parser.ToolStatusCompleted
 				if toolResult.IsError {
@@ -897,6 +2501,8 @@ func handleParsedMessage(
 				program.Send(tui.SendToolStatusUpdate(toolResult.ToolUseID, string(status))())
 			}
 			if toolResult.Content != "" {
+				// Kept in full for the detail pane ('d'), not the feed
+				program.Send(tui.SendToolResult(toolResult.ToolUseID, toolResult.Content)())
 				if ref := jsonParser.ExtractTaskReference(toolResult.Content); ref != nil {
 					taskLabel := fmt.Sprintf("#%d", ref.Number)
 					if ref.Description != "" {
@@ -913,12 +2519,32 @@ func handleParsedMessage(
 		if iterActualCost > 0 && !jsonParser.IsSubagentMessage(parsed) {
 			msgChan <- tui.Message{
 				Role:    tui.RoleSystem,
-				Content: fmt.Sprintf("Iteration cost: $%.6f", iterActualCost),
+				Content: "Iteration cost: " + stats.FormatCost(iterActualCost),
 			}
 		}
-		// Exit loop detection: check if this main result iteration was a no-op
+		// The agent asked the user something: highlight it and pause so the
+		// user can step in before the next iteration
+		if question != "" {
+			msgChan <- tui.Message{
+				Role:    tui.RoleQuestion,
+				Content: "Agent is waiting for input (paused, press r to resume): " + question,
+			}
+			claudeLoop.Pause()
+		}
+		// An errored result fails the iteration, even when it cost nothing
+		if jsonParser.IsErrorResult(parsed) && !jsonParser.IsSubagentMessage(parsed) {
+			msgChan <- tui.Message{
+				Role:    tui.RoleSystem,
+				Content: "Iteration failed: " + parsed.ErrorSummary(),
+			}
+		}
+		// Exit loop detection: check if this main result iteration was a no-op.
+		// A failed iteration is not a no-op and breaks the streak.
 		if !jsonParser.IsSubagentMessage(parsed) {
-			if *iterToolUseCount == 0 && iterActualCost < noopCostThreshold {
+			tracer.SetOutcome(resultOutcome(jsonParser.IsErrorResult(parsed)))
+			if jsonParser.IsErrorResult(parsed) {
+				*noopStreak = 0
+			} else if *iterToolUseCount == 0 && iterActualCost < noopCostThreshold {
 				*noopStreak++
 				if *noopStreak >= NoopIterationThreshold {
 					msgChan <- tui.Message{
@@ -934,6 +2560,61 @@ func handleParsedMessage(
 	}
 }
 
+// throttleWait decides how long to hibernate for a rate-limit or overload
+// error: until the reset time it gives, or else for the next backoff step.
+// It returns the message describing the wait, and exceeded when the backoff
+// retries have run out and the loop should stop instead.
+func throttleWait(t *parser.Throttle, apiBackoff *loop.Backoff, now time.Time) (until time.Time, content string, exceeded bool) {
+	what := "Rate limited"
+	if t.Overloaded {
+		what = "API overloaded"
+	}
+	if !t.ResetsAt.IsZero() {
+		return t.ResetsAt, fmt.Sprintf("%s until %s", what, t.ResetsAt.Format(time.Kitchen)), false
+	}
+	backoffDuration, retryNum, exceeded := apiBackoff.Next()
+	if exceeded {
+		return time.Time{}, fmt.Sprintf("%s: max retries (%d) exceeded, stopping loop", what, apiBackoff.MaxRetries()), true
+	}
+	until = now.Add(backoffDuration)
+	return until, fmt.Sprintf("%s, retry %d/%d, hibernating %s until %s", what, retryNum, apiBackoff.MaxRetries(), backoffDuration.Round(time.Second), until.Format(time.Kitchen)), false
+}
+
+// hibernateOnThrottle hibernates claudeLoop for a rate-limit or overload
+// error in TUI mode, or stops it when the backoff retries have run out. It
+// does nothing when t is nil or the loop is already hibernating, as it is
+// when stderr and the result report the same error. It reports whether t was
+// acted on.
+func hibernateOnThrottle(t *parser.Throttle, claudeLoop *loop.Loop, apiBackoff *loop.Backoff, msgChan chan<- tui.Message, program *tea.Program) bool {
+	if t == nil || claudeLoop.IsHibernating() {
+		return false
+	}
+	until, content, exceeded := throttleWait(t, apiBackoff, time.Now())
+	msgChan <- tui.Message{Role: tui.RoleHibernate, Content: content}
+	if exceeded {
+		claudeLoop.Stop()
+		return true
+	}
+	claudeLoop.Hibernate(until)
+	program.Send(tui.SendHibernate(until)())
+	return true
+}
+
+// hibernateOnThrottleCLI is hibernateOnThrottle for CLI mode.
+func hibernateOnThrottleCLI(t *parser.Throttle, claudeLoop *loop.Loop, apiBackoff *loop.Backoff, out *render.Renderer) bool {
+	if t == nil || claudeLoop.IsHibernating() {
+		return false
+	}
+	until, content, exceeded := throttleWait(t, apiBackoff, time.Now())
+	out.Printf("hibernate", "%s", content)
+	if exceeded {
+		claudeLoop.Stop()
+		return true
+	}
+	claudeLoop.Hibernate(until)
+	return true
+}
+
 // handleParsedMessageCLI processes a parsed JSON message for CLI mode output.
 // Shared by runCLI and both phases of runPlanAndBuildCLI.
 func handleParsedMessageCLI(
@@ -941,56 +2622,78 @@ func handleParsedMessageCLI(
 	claudeLoop *loop.Loop,
 	jsonParser *parser.Parser,
 	tokenStats *stats.TokenStats,
-	logFile io.Writer,
-	iterEstimate *float64,
-	subagentCostAccum *float64,
+	logFile *runlog.Writer,
+	out *render.Renderer,
 	lastResultCost *float64,
 	iterToolUseCount *int,
 	noopStreak *int,
 	apiBackoff *loop.Backoff,
 	seenMsgIDs map[string]bool,
+	tracer *telemetry.Tracer,
 ) {
 	// Check for rate limit rejection — enter hibernate state
 	if rejected, resetsAt := jsonParser.IsRateLimitRejected(parsed); rejected {
 		claudeLoop.Hibernate(resetsAt)
-		fmt.Printf("[hibernate] Rate limited until %s\n", resetsAt.Format(time.Kitchen))
+		out.Printf("hibernate", "Rate limited until %s", resetsAt.Format(time.Kitchen))
 	}
 	// Check for API 529 (overloaded) error — enter hibernate state with exponential backoff
 	if jsonParser.IsAPIOverloaded(parsed) {
 		backoffDuration, retryNum, exceeded := apiBackoff.Next()
 		if exceeded {
-			fmt.Printf("[hibernate] API overloaded (529): max retries (%d) exceeded, stopping loop\n", apiBackoff.MaxRetries())
+			out.Printf("hibernate", "API overloaded (529): max retries (%d) exceeded, stopping loop", apiBackoff.MaxRetries())
 			claudeLoop.Stop()
 			return
 		}
 		resetsAt := time.Now().Add(backoffDuration)
 		claudeLoop.Hibernate(resetsAt)
-		fmt.Printf("[hibernate] API overloaded (529), retry %d/%d, hibernating %s until %s\n", retryNum, apiBackoff.MaxRetries(), backoffDuration.Round(time.Second), resetsAt.Format(time.Kitchen))
+		out.Printf("hibernate", "API overloaded (529), retry %d/%d, hibernating %s until %s", retryNum, apiBackoff.MaxRetries(), backoffDuration.Round(time.Second), resetsAt.Format(time.Kitchen))
 		return
 	}
 	// Check for API 500 (server error) — enter hibernate state with exponential backoff
 	if jsonParser.IsAPIServerError(parsed) {
 		backoffDuration, retryNum, exceeded := apiBackoff.Next()
 		if exceeded {
-			fmt.Printf("[hibernate] API server error (500): max retries (%d) exceeded, stopping loop\n", apiBackoff.MaxRetries())
+			out.Printf("hibernate", "API server error (500): max retries (%d) exceeded, stopping loop", apiBackoff.MaxRetries())
 			claudeLoop.Stop()
 			return
 		}
 		resetsAt := time.Now().Add(backoffDuration)
 		claudeLoop.Hibernate(resetsAt)
-		fmt.Printf("[hibernate] API server error (500), retry %d/%d, hibernating %s until %s\n", retryNum, apiBackoff.MaxRetries(), backoffDuration.Round(time.Second), resetsAt.Format(time.Kitchen))
+		out.Printf("hibernate", "API server error (500), retry %d/%d, hibernating %s until %s", retryNum, apiBackoff.MaxRetries(), backoffDuration.Round(time.Second), resetsAt.Format(time.Kitchen))
+		return
+	}
+	// Check for rate-limit and overload errors given as error text
+	if hibernateOnThrottleCLI(jsonParser.DetectThrottle(parsed, time.Now()), claudeLoop, apiBackoff, out) {
 		return
 	}
 	// Check for authentication error — stop loop with helpful message
 	if jsonParser.IsAuthenticationError(parsed) {
 		if os.Getenv("ANTHROPIC_API_KEY") != "" {
-			fmt.Fprintf(os.Stderr, "[error] Authentication failed: ANTHROPIC_API_KEY is set but appears to be invalid. Please check your API key.\n")
+			out.Errorf("error", "Authentication failed: ANTHROPIC_API_KEY is set but appears to be invalid. Please check your API key.")
 		} else {
-			fmt.Fprintf(os.Stderr, "[error] Authentication failed: please set ANTHROPIC_API_KEY or run `claude /login`.\n")
+			out.Errorf("error", "Authentication failed: please set ANTHROPIC_API_KEY or run `claude /login`.")
 		}
 		claudeLoop.Stop()
 		return
 	}
+	// Check for context-window warnings
+	if warning := jsonParser.DetectContextWarning(parsed); warning != nil {
+		out.Printf("context", "%s", formatContextWarning(warning))
+	}
+	if question := jsonParser.AwaitingInput(parsed); question != "" {
+		out.Errorf("question", "⚠ Agent is waiting for input nobody can give in CLI mode: %s", question)
+	}
+	for _, thrash := range jsonParser.ObserveThrash(parsed) {
+		content := fmt.Sprintf("Thrashing detected: %s repeated %d times in %d iterations", thrash.Title, thrash.Count, thrash.Iterations)
+		switch claudeLoop.Thrashing(thrash.Title) {
+		case loop.ThrashNudge:
+			content += " — nudging the agent"
+		case loop.ThrashStop:
+			content += " — stopping"
+		}
+		out.Printf("thrash", "%s", content)
+		logFile.Log("thrash", content)
+	}
 	// Track stats — deduplicate by message ID (same fix as TUI mode)
 	if usage := jsonParser.GetUsage(parsed); usage != nil {
 		msgID := jsonParser.GetMessageID(parsed)
@@ -1012,8 +2715,7 @@ func handleParsedMessageCLI(
 				usage.CacheCreationInputTokens,
 				usage.CacheReadInputTokens,
 			)
-			tokenStats.AddCost(estimate)
-			*iterEstimate += estimate
+			tokenStats.AddEstimate(jsonParser.GetParentToolUseID(parsed), estimate)
 		}
 	}
 	// Extract cost from result messages — reconcile estimate with actual.
@@ -1029,28 +2731,17 @@ func handleParsedMessageCLI(
 				iterActualCost = cost
 			}
 			*lastResultCost = cost
-			// Replace accumulated estimates AND subagent actuals with actual cost
-			tokenStats.ReconcileCost(*iterEstimate+*subagentCostAccum, iterActualCost)
-			*iterEstimate = 0
-			*subagentCostAccum = 0
+			// Replace the iteration's estimates AND subagent actuals with actual cost
+			tokenStats.ReconcileIteration(iterActualCost)
 		} else {
-			// Subagent result: add actual cost for real-time visibility, track for later reconciliation
-			tokenStats.AddCost(cost)
-			*subagentCostAccum += cost
+			// Subagent result: its actual cost replaces its estimates for real-time visibility
+			tokenStats.AddSubagentCost(jsonParser.GetParentToolUseID(parsed), cost)
 		}
 	}
 	// Print assistant text and tool use
 	if parsed.Type == parser.MessageTypeAssistant {
 		content := jsonParser.ExtractContent(parsed)
-		if content.Thinking != "" {
-			fmt.Fprintf(logFile, "[thinking] %s\n\n", content.Thinking)
-		}
-		for _, text := range content.TextContent {
-			if text != "" {
-				fmt.Printf("[assistant] %s\n", text)
-				fmt.Fprintf(logFile, "[assistant] %s\n\n", text)
-			}
-		}
+		*iterToolUseCount += len(content.ToolUses)
 		if len(content.Plan) > 0 {
 			completed := 0
 			for _, it := range content.Plan {
@@ -1058,43 +2749,51 @@ func handleParsedMessageCLI(
 					completed++
 				}
 			}
-			fmt.Printf("[plan] %d/%d done\n", completed, len(content.Plan))
+			out.Printf("plan", "%d/%d done", completed, len(content.Plan))
 		}
-		for _, item := range parsed.Message.Content {
-			if item.Type == parser.ContentTypeToolUse {
-				*iterToolUseCount++
-				// TodoWrite is surfaced via the [plan] line above, not a tool row.
-				if item.Name == "TodoWrite" {
-					continue
-				}
-				kind := parser.ClassifyToolKind(item.Name)
-				filePath := parser.ExtractFilePathFromInput(item.Input)
-				if filePath != "" {
-					fmt.Printf("[tool] (%s) %s: %s\n", kind, item.Name, filePath)
-				} else {
-					fmt.Printf("[tool] (%s) %s\n", kind, item.Name)
+		for _, line := range parser.FormatForDisplay(content) {
+			switch line.Role {
+			case parser.DisplayThinking:
+				logFile.Log("thinking", line.Text)
+			case parser.DisplayAssistant:
+				out.Emit(render.FromDisplayLine(line))
+				logFile.Log("assistant", line.Text)
+			case parser.DisplayTool:
+				tracer.ToolUse(line.ToolUseID, jsonParser.GetParentToolUseID(parsed), line.ToolName, line.Location)
+				out.Emit(render.FromDisplayLine(line))
+				if dir := jsonParser.ExcludedEditDir(line.Kind, line.Location); dir != "" {
+					out.Printf("warn", "%s targets excluded directory %s: %s", line.ToolName, dir, line.Location)
 				}
 			}
 		}
 	}
-	// Report tool completion/failure in CLI mode.
+	// Report tool failures in CLI mode.
 	if parsed.Type == parser.MessageTypeUser {
 		content := jsonParser.ExtractContent(parsed)
 		for _, toolResult := range content.ToolResults {
-			if toolResult.IsError {
-				fmt.Printf("[tool] failed\n")
+			tracer.ToolResult(toolResult.ToolUseID, toolResult.IsError)
+		}
+		for _, line := range parser.FormatForDisplay(content) {
+			if line.Role == parser.DisplayToolResult && line.IsError {
+				out.Emit(render.FromDisplayLine(line))
 			}
 		}
 	}
 	if parsed.Type == parser.MessageTypeResult && iterActualCost > 0 && !jsonParser.IsSubagentMessage(parsed) {
-		fmt.Printf("[cost] Iteration cost: $%.6f\n", iterActualCost)
+		out.Emit(render.Event{Type: "cost", Content: "Iteration cost: " + stats.FormatCost(iterActualCost), CostUSD: iterActualCost})
+	}
+	if jsonParser.IsErrorResult(parsed) && !jsonParser.IsSubagentMessage(parsed) {
+		out.Errorf("error", "Iteration failed: %s", parsed.ErrorSummary())
 	}
-	// Exit loop detection for CLI mode
+	// Exit loop detection for CLI mode; a failed iteration is not a no-op
 	if parsed.Type == parser.MessageTypeResult && !jsonParser.IsSubagentMessage(parsed) {
-		if *iterToolUseCount == 0 && iterActualCost < noopCostThreshold {
+		tracer.SetOutcome(resultOutcome(jsonParser.IsErrorResult(parsed)))
+		if jsonParser.IsErrorResult(parsed) {
+			*noopStreak = 0
+		} else if *iterToolUseCount == 0 && iterActualCost < noopCostThreshold {
 			*noopStreak++
 			if *noopStreak >= NoopIterationThreshold {
-				fmt.Printf("[exit] Stopping: agent appears done (%d consecutive no-op iterations)\n", *noopStreak)
+				out.Printf("exit", "Stopping: agent appears done (%d consecutive no-op iterations)", *noopStreak)
 				claudeLoop.Stop()
 			}
 		} else {
@@ -1104,54 +2803,117 @@ func handleParsedMessageCLI(
 }
 
 // runCLI runs ralph in CLI mode: no TUI, output to stdout/stderr, exit on completion.
-func runCLI(cfg *config.Config, promptContent string, tokenStats *stats.TokenStats, logFile io.Writer, dbCtx *dbContext) int {
+func runCLI(cfg *config.Config, promptContent, firstPromptContent string, tokenStats *stats.TokenStats, logFile *runlog.Writer, dbCtx *dbContext, resume *loop.RunState, api *control.Server) int {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	out := render.New(cfg.LogFormat, os.Stdout, os.Stderr)
 
 	// Handle OS signals for graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigChan
-		cancel()
-	}()
+	target := &cliTarget{}
+	stopSignals := shutdownSignals(func() {
+		out.Printf("shutdown", "Finishing the current iteration, then exiting. Press Ctrl+C to exit now.")
+		target.drain()
+	}, cancel)
+	defer stopSignals()
+	renderPrompt := promptRenderer(cfg, dbCtx)
+	defer headlessSignals(target, renderPrompt, out)()
 
 	// Create and start the loop
-	claudeLoop := loop.New(loop.Config{
-		Iterations: cfg.Iterations,
-		Prompt:     promptContent,
-	})
+	claudeLoop := loop.New(withRunState(loop.Config{
+		Iterations:      cfg.Iterations,
+		Prompt:          promptContent,
+		FirstPrompt:     firstPromptContent,
+		RenderPrompt:    renderPrompt,
+		CompactEvery:    cfg.CompactEvery,
+		StallNudgeAfter: cfg.StallNudgeAfter,
+		DoneAfterIdle:   cfg.DoneAfterIdle,
+		DoneSentinel:    cfg.DoneSentinel,
+		RestartOnCrash:  cfg.AgentRestartOnCrash,
+		RedoFresh:       cfg.RedoFresh,
+		DoneMarkers:     cfg.LoopSummary,
+		ThrashAction:    cfg.ThrashAction,
+		NoSleepOnError:  cfg.NoSleepOnError,
+		SuccessCodes:    cfg.AgentSuccessCodes,
+		Backend:         agentBackend(cfg.Agent),
+		Model:           cfg.ModelFor(cfg.IsPlanMode()),
+		FallbackModel:   cfg.FallbackModel,
+		FallbackAfter:   cfg.FallbackAfter,
+		SuccessCmd:      cfg.SuccessCmd,
+		SuccessRetries:  cfg.SuccessRetries,
+		StartAt:         cfg.StartTime(time.Now()),
+		StreamFormat:    cfg.StreamFormat,
+		ConfirmEachLoop: cfg.ConfirmEachLoop,
+		ExcludeDirs:     cfg.ExcludeDirs,
+		PreLoopHook:     cfg.PreLoopHook,
+		PostLoopHook:    cfg.PostLoopHook,
+		HookTimeout:     cfg.HookTimeout,
+		HookMustPass:    cfg.HookMustPass,
+		MaxRetries:      cfg.MaxRetries,
+		TotalRetries:    cfg.TotalRetries,
+		RetryFailed:     cfg.RetryFailed,
+		RetryBackoff:    cfg.RetryBackoff,
+		ActiveHours:     cfg.ActiveHours,
+		Deadline:        cfg.Deadline(time.Now()),
+		AgentTimeout:    cfg.IterationTimeout,
+		NoOutputTimeout: cfg.NoOutputTimeout,
+		Transcripts:     transcripts(cfg, ""),
+		CommitReport:    cfg.CommitReport,
+		WarnNoCommit:    cfg.WarnNoCommit,
+	}, cfg, tokenStats, resume))
+	api.SetLoop(claudeLoop)
+	target.set(claudeLoop, func() (string, error) { return loopPrompt(cfg) })
+	if resume != nil {
+		out.Printf("resume", "Continuing from iteration %d/%d", resume.Iteration+1, cfg.Iterations)
+		if resume.SessionID != "" {
+			claudeLoop.SetResumeSessionID(resume.SessionID)
+		}
+	}
 
-	// Startup budget check — wait until rolling window drops below limit
-	if cfg.MaxCostPerHour > 0 && dbCtx != nil && dbCtx.db != nil {
+	// Report what this run spent (not the project lifetime totals) on exit
+	startTime := time.Now()
+	startSnap := tokenStats.Snapshot()
+	iterationsRun := 0
+	defer func() {
+		out.Plain("summary", cliSummary(iterationsRun, time.Since(startTime), startSnap, tokenStats.Snapshot()))
+		out.Plain("summary", cliRunLine(cfg.RunID, workDir()))
+		commits := dbCtx.runCommits()
+		for _, line := range cliCommitLines(commits) {
+			out.Plain("summary", line)
+		}
+		if len(commits) > 0 {
+			logFile.Log("commits", commitSummary(commits))
+		}
+	}()
+
+	// Startup budget check — wait until rolling window drops below limit.
+	// A zero-iteration run spends nothing, so it never waits.
+	if cfg.Iterations > 0 && cfg.MaxCostPerHour > 0 && dbCtx != nil && dbCtx.db != nil {
 		cost, err := stats.QueryRollingHourCost(dbCtx.db, dbCtx.owner, dbCtx.repo)
 		if err == nil && cost >= cfg.MaxCostPerHour {
 			wakeTime, wakeErr := stats.QueryRollingWakeTime(dbCtx.db, dbCtx.owner, dbCtx.repo, cfg.MaxCostPerHour)
 			if wakeErr != nil {
 				wakeTime = time.Now().UTC().Add(60 * time.Minute)
 			}
-			fmt.Printf("[pacing] Cost budget already exceeded ($%.4f/$%.2f/hr), waiting until %s UTC\n", cost, cfg.MaxCostPerHour, wakeTime.Format(time.Kitchen))
+			out.Printf("pacing", "Cost budget already exceeded ($%.4f/$%.2f/hr), waiting until %s UTC", cost, cfg.MaxCostPerHour, wakeTime.Format(time.Kitchen))
 			select {
 			case <-ctx.Done():
 				return 1
 			case <-time.After(time.Until(wakeTime)):
 			}
-			fmt.Println("[pacing] Cost cycle reset, resuming")
+			out.Printf("pacing", "Cost cycle reset, resuming")
 		}
 	}
 
 	claudeLoop.Start(ctx)
 
-	jsonParser := parser.NewParser()
-	var iterEstimate float64
-	var subagentCostAccum float64
+	jsonParser := newJSONParser(cfg)
 	var lastResultCost float64
 	var iterToolUseCount int
 	var noopStreak int
 	var authFailed bool
 	seenMsgIDs := make(map[string]bool)
 	lt := &loopTracker{}
-	apiBackoff := loop.NewBackoff() // exponential backoff for API 529 errors
+	apiBackoff := claudeLoop.NewBackoff() // exponential backoff for API 529 errors
 
 	mode := "build"
 	if cfg.IsPlanMode() {
@@ -1159,49 +2921,91 @@ func runCLI(cfg *config.Config, promptContent string, tokenStats *stats.TokenSta
 	} else if cfg.IsAutoresearchMode() {
 		mode = "autoresearch"
 	}
-	fmt.Printf("ralph cli: starting %s mode with %d iterations\n", mode, cfg.Iterations)
+	if cfg.Iterations == 0 {
+		out.Plain("start", "ralph cli: nothing to do (0 iterations)")
+	} else {
+		out.Plain("start", fmt.Sprintf("ralph cli: starting %s mode with %d iterations", mode, cfg.Iterations))
+	}
 
 	// Start per-minute checkpoint ticker
 	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
 
+	// Keep the tmux status bar current without the TUI's tick loop
+	statusBar := newCLIStatusBar(tokenStats, cfg.PlanFile)
+	defer statusBar.restore()
+	statusTicker := time.NewTicker(time.Second)
+	defer statusTicker.Stop()
+
+	// Progress lines for editor and IDE integrations (--progress-to)
+	progressOut, err := newCLIProgress(cfg.ProgressTo, tokenStats)
+	if err != nil {
+		out.Errorf("error", "%v", err)
+		return 1
+	}
+	defer progressOut.close()
+
+	confirmLines := confirmInput(cfg.ConfirmEachLoop, os.Stdin)
+
 	loopOutput := claudeLoop.Output()
 	for {
 		select {
 		case <-ctx.Done():
 			lt.completeLoop(dbCtx, tokenStats)
+			progressOut.report(claudeLoop, claudeLoop.GetIterations(), tokenStats, progress.StatusFailed)
 			return 1
 		case <-ticker.C:
 			lt.flushDelta(dbCtx, tokenStats)
 			if exceeded, hourCost, nextHour := checkCostPacing(dbCtx, cfg.MaxCostPerHour, claudeLoop); exceeded {
-				fmt.Printf("[hibernate] Cost budget exceeded ($%.4f/$%.2f/hr), pausing until %s\n", hourCost, cfg.MaxCostPerHour, nextHour.Format(time.Kitchen))
+				out.Printf("paced", "Cost budget exceeded ($%.4f/$%.2f/hr), pausing until %s", hourCost, cfg.MaxCostPerHour, nextHour.Format(time.Kitchen))
 			}
+		case <-statusTicker.C:
+			statusBar.update(claudeLoop.GetIterations(), tokenStats)
+			progressOut.report(claudeLoop, claudeLoop.GetIterations(), tokenStats, "")
+		case line := <-confirmLines:
+			confirmLoop(claudeLoop, line)
 		case msg, ok := <-loopOutput:
 			if !ok {
 				lt.completeLoop(dbCtx, tokenStats)
+				exitCode := 0
 				if authFailed {
-					return 1
+					exitCode = 1
 				}
-				return 0
+				progressOut.report(claudeLoop, claudeLoop.GetIterations(), tokenStats, finalStatus(exitCode))
+				return exitCode
 			}
 
 			switch msg.Type {
 			case "loop_marker":
+				logFile.Loop(msg.Loop, msg.Content)
+				recordHibernation(msg, tokenStats)
+				recordChanges(msg, lt)
+				notifyHibernate(dbCtx, msg)
 				if isNewLoopStart(msg.Content) {
+					iterationsRun++
+					statusBar.current = msg.Loop
+					progressOut.current = msg.Loop
 					lt.startNewLoop(dbCtx, tokenStats, msg.Loop)
-					iterEstimate = 0
-					subagentCostAccum = 0
+					tokenStats.EndIteration()
 					iterToolUseCount = 0
 					seenMsgIDs = make(map[string]bool)
 					apiBackoff.Reset()
 				} else if isRetryLoopStart(msg.Content) {
 					// Hibernate retry: reset iteration counters but do NOT create
 					// a new DB entry and do NOT reset apiBackoff
-					iterEstimate = 0
-					subagentCostAccum = 0
+					tokenStats.EndIteration()
 					iterToolUseCount = 0
 				}
-				fmt.Printf("[loop] %s\n", msg.Content)
+				out.Loop(msg.Loop, msg.Total, msg.Content)
+				progressOut.report(claudeLoop, msg.Total, tokenStats, "")
+				if isConfirmWait(msg.Content) {
+					out.Printf("confirm", "Press Enter to run loop %d, or type a nudge for it and press Enter", msg.Loop)
+				}
+
+			case "loop_marker_done":
+				summary := loopDoneSummary(msg, lt, tokenStats)
+				logFile.Loop(msg.Loop, summary)
+				out.Emit(render.Event{Type: "loop_done", Iteration: msg.Loop, Total: msg.Total, Content: summary, Text: "[loop] " + summary})
 
 			case "output":
 				parsed := jsonParser.ParseLine(msg.Content)
@@ -1209,32 +3013,41 @@ func runCLI(cfg *config.Config, promptContent string, tokenStats *stats.TokenSta
 					if sessionID := jsonParser.GetSessionID(parsed); sessionID != "" {
 						claudeLoop.SetSessionID(sessionID)
 					}
-					handleParsedMessageCLI(parsed, claudeLoop, jsonParser, tokenStats, logFile, &iterEstimate, &subagentCostAccum, &lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, seenMsgIDs)
+					handleParsedMessageCLI(parsed, claudeLoop, jsonParser, tokenStats, logFile, out, &lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, seenMsgIDs, dbCtx.tracer)
+					progressOut.observe(jsonParser, parsed)
+					progressOut.report(claudeLoop, claudeLoop.GetIterations(), tokenStats, "")
 					if jsonParser.IsAuthenticationError(parsed) {
 						authFailed = true
 					}
 				} else if isAuthenticationText(msg.Content) {
 					if os.Getenv("ANTHROPIC_API_KEY") != "" {
-						fmt.Fprintf(os.Stderr, "[error] Authentication failed: ANTHROPIC_API_KEY is set but appears to be invalid. Please check your API key.\n")
+						out.Errorf("error", "Authentication failed: ANTHROPIC_API_KEY is set but appears to be invalid. Please check your API key.")
 					} else {
-						fmt.Fprintf(os.Stderr, "[error] Authentication failed: please set ANTHROPIC_API_KEY or run `claude /login`.\n")
+						out.Errorf("error", "Authentication failed: please set ANTHROPIC_API_KEY or run `claude /login`.")
 					}
 					authFailed = true
 					claudeLoop.Stop()
+				} else if throttle := jsonParser.DetectThrottleText(msg.Content, time.Now()); throttle != nil {
+					hibernateOnThrottleCLI(throttle, claudeLoop, apiBackoff, out)
+				} else if warning := jsonParser.DetectContextWarningText(msg.Content); warning != nil {
+					out.Printf("context", "%s", formatContextWarning(warning))
 				}
 
 			case "error":
-				fmt.Fprintf(os.Stderr, "[error] %s\n", msg.Content)
+				notifyError(dbCtx, lt, msg.Content)
+				out.Errorf("error", "%s", msg.Content)
 
 			case "complete":
 				lt.completeLoop(dbCtx, tokenStats)
-				fmt.Printf("[complete] %s\n", msg.Content)
+				out.Printf("complete", "%s", msg.Content)
 				// In CLI mode, exit on completion instead of waiting
 				cancel()
-				if authFailed {
-					return 1
+				exitCode := 0
+				if authFailed || claudeLoop.SuccessFailed() {
+					exitCode = 1
 				}
-				return 0
+				progressOut.report(claudeLoop, claudeLoop.GetIterations(), tokenStats, finalStatus(exitCode))
+				return exitCode
 			}
 		}
 	}
@@ -1242,22 +3055,59 @@ func runCLI(cfg *config.Config, promptContent string, tokenStats *stats.TokenSta
 
 // runPlanAndBuildCLI runs plan-and-build mode in CLI: planning (1 iteration) then building (N iterations)
 // with output to stdout/stderr and no TUI.
-func runPlanAndBuildCLI(cfg *config.Config, tokenStats *stats.TokenStats, logFile io.Writer, dbCtx *dbContext) int {
+func runPlanAndBuildCLI(cfg *config.Config, tokenStats *stats.TokenStats, logFile *runlog.Writer, dbCtx *dbContext, api *control.Server) int {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	out := render.New(cfg.LogFormat, os.Stdout, os.Stderr)
 
 	// Handle OS signals for graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigChan
-		cancel()
+	target := &cliTarget{}
+	stopSignals := shutdownSignals(func() {
+		out.Printf("shutdown", "Finishing the current iteration, then exiting. Press Ctrl+C to exit now.")
+		target.drain()
+	}, cancel)
+	defer stopSignals()
+	deadline := cfg.Deadline(time.Now()) // --max-duration covers both phases
+	retries := &loop.RetryBudget{}       // so does --total-retries
+
+	jsonParser := newJSONParser(cfg)
+	renderPrompt := promptRenderer(cfg, dbCtx)
+	defer headlessSignals(target, renderPrompt, out)()
+
+	out.Plain("start", "ralph cli: starting plan-and-build mode")
+
+	// Keep the tmux status bar current without the TUI's tick loop; the
+	// iteration count spans both phases.
+	statusBar := newCLIStatusBar(tokenStats, cfg.PlanFile)
+	defer statusBar.restore()
+	statusTicker := time.NewTicker(time.Second)
+	defer statusTicker.Stop()
+	totalIterations := cfg.Iterations + cfg.BuildIterations
+
+	// Progress lines for editor and IDE integrations (--progress-to)
+	progressOut, err := newCLIProgress(cfg.ProgressTo, tokenStats)
+	if err != nil {
+		out.Errorf("error", "%v", err)
+		return 1
+	}
+	defer progressOut.close()
+
+	// Report what this run spent (not the project lifetime totals) on exit
+	startTime := time.Now()
+	startSnap := tokenStats.Snapshot()
+	iterationsRun := 0
+	defer func() {
+		out.Plain("summary", cliSummary(iterationsRun, time.Since(startTime), startSnap, tokenStats.Snapshot()))
+		out.Plain("summary", cliRunLine(cfg.RunID, workDir()))
+		commits := dbCtx.runCommits()
+		for _, line := range cliCommitLines(commits) {
+			out.Plain("summary", line)
+		}
+		if len(commits) > 0 {
+			logFile.Log("commits", commitSummary(commits))
+		}
 	}()
 
-	jsonParser := parser.NewParser()
-
-	fmt.Println("ralph cli: starting plan-and-build mode")
-
 	// Startup budget check — wait until rolling window drops below limit
 	if cfg.MaxCostPerHour > 0 && dbCtx != nil && dbCtx.db != nil {
 		cost, err := stats.QueryRollingHourCost(dbCtx.db, dbCtx.owner, dbCtx.repo)
@@ -1266,41 +3116,69 @@ func runPlanAndBuildCLI(cfg *config.Config, tokenStats *stats.TokenStats, logFil
 			if wakeErr != nil {
 				wakeTime = time.Now().UTC().Add(60 * time.Minute)
 			}
-			fmt.Printf("[pacing] Cost budget already exceeded ($%.4f/$%.2f/hr), waiting until %s UTC\n", cost, cfg.MaxCostPerHour, wakeTime.Format(time.Kitchen))
+			out.Printf("pacing", "Cost budget already exceeded ($%.4f/$%.2f/hr), waiting until %s UTC", cost, cfg.MaxCostPerHour, wakeTime.Format(time.Kitchen))
 			select {
 			case <-ctx.Done():
 				return 1
 			case <-time.After(time.Until(wakeTime)):
 			}
-			fmt.Println("[pacing] Cost cycle reset, resuming")
+			out.Printf("pacing", "Cost cycle reset, resuming")
 		}
 	}
 
 	// Phase 1: Planning
-	fmt.Printf("[phase] Planning (%d iteration)\n", cfg.Iterations)
+	out.Printf("phase", "Planning (%d iteration)", cfg.Iterations)
 
 	planPromptLoader := prompt.NewPlanLoader(cfg.LoopPrompt, cfg.Goal, cfg.PlanFile)
 	planPromptContent, err := planPromptLoader.Load()
+	if err == nil {
+		_, err = renderPrompt(planPromptContent, 1, cfg.Iterations)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "[error] Failed to load plan prompt: %v\n", err)
+		out.Errorf("error", "Failed to load plan prompt: %v", err)
 		return 1
 	}
 
 	planLoop := loop.New(loop.Config{
-		Iterations: cfg.Iterations, // Always 1 for plan phase
-		Prompt:     planPromptContent,
+		Iterations:      cfg.Iterations, // Always 1 for plan phase
+		Prompt:          planPromptContent + specs.Context(cfg.SpecFolder, cfg.Specs),
+		RenderPrompt:    renderPrompt,
+		RestartOnCrash:  cfg.AgentRestartOnCrash,
+		MaxRetries:      cfg.MaxRetries,
+		TotalRetries:    cfg.TotalRetries,
+		RetryBudget:     retries,
+		RetryFailed:     cfg.RetryFailed,
+		RetryBackoff:    cfg.RetryBackoff,
+		ActiveHours:     cfg.ActiveHours,
+		Deadline:        deadline,
+		AgentTimeout:    cfg.IterationTimeout,
+		NoOutputTimeout: cfg.NoOutputTimeout,
+		Transcripts:     transcripts(cfg, "plan"),
+		CommitReport:    cfg.CommitReport,
+		WarnNoCommit:    cfg.WarnNoCommit,
+		RedoFresh:       cfg.RedoFresh,
+		DoneMarkers:     cfg.LoopSummary,
+		ThrashAction:    cfg.ThrashAction,
+		NoSleepOnError:  cfg.NoSleepOnError,
+		SuccessCodes:    cfg.AgentSuccessCodes,
+		Backend:         agentBackend(cfg.Agent),
+		Model:           cfg.ModelFor(true),
+		FallbackModel:   cfg.FallbackModel,
+		FallbackAfter:   cfg.FallbackAfter,
+		StartAt:         cfg.StartTime(time.Now()),
+		StreamFormat:    cfg.StreamFormat,
 	})
+	api.SetLoop(planLoop)
+	target.set(planLoop, reloadPrompt(planPromptLoader, cfg))
 	planLoop.Start(ctx)
 
 	var sessionID string
-	var planIterEstimate float64
-	var planSubagentCostAccum float64
 	var planLastResultCost float64
 	var planIterToolUseCount int
 	var planNoopStreak int
 	planSeenMsgIDs := make(map[string]bool)
 	planLt := &loopTracker{}
-	planBackoff := loop.NewBackoff() // exponential backoff for API 529 errors (plan phase)
+	planBackoff := planLoop.NewBackoff() // exponential backoff for API 529 errors (plan phase)
 
 	// Start per-minute checkpoint ticker for plan phase
 	planTicker := time.NewTicker(time.Minute)
@@ -1313,12 +3191,16 @@ planLoop:
 		case <-ctx.Done():
 			planLt.completeLoop(dbCtx, tokenStats)
 			planTicker.Stop()
+			progressOut.report(planLoop, totalIterations, tokenStats, progress.StatusFailed)
 			return 1
 		case <-planTicker.C:
 			planLt.flushDelta(dbCtx, tokenStats)
 			if exceeded, hourCost, nextHour := checkCostPacing(dbCtx, cfg.MaxCostPerHour, planLoop); exceeded {
-				fmt.Printf("[hibernate] Cost budget exceeded ($%.4f/$%.2f/hr), pausing until %s\n", hourCost, cfg.MaxCostPerHour, nextHour.Format(time.Kitchen))
+				out.Printf("paced", "Cost budget exceeded ($%.4f/$%.2f/hr), pausing until %s", hourCost, cfg.MaxCostPerHour, nextHour.Format(time.Kitchen))
 			}
+		case <-statusTicker.C:
+			statusBar.update(totalIterations, tokenStats)
+			progressOut.report(planLoop, totalIterations, tokenStats, "")
 		case msg, ok := <-planOutput:
 			if !ok {
 				planLt.completeLoop(dbCtx, tokenStats)
@@ -1327,13 +3209,24 @@ planLoop:
 
 			switch msg.Type {
 			case "loop_marker":
+				logFile.Loop(msg.Loop, msg.Content)
+				recordHibernation(msg, tokenStats)
+				recordChanges(msg, planLt)
+				notifyHibernate(dbCtx, msg)
 				if isNewLoopStart(msg.Content) {
+					iterationsRun++
+					statusBar.current = iterationsRun
+					progressOut.current = iterationsRun
 					planLt.startNewLoop(dbCtx, tokenStats, msg.Loop)
-					planIterEstimate = 0
-					planSubagentCostAccum = 0
+					tokenStats.EndIteration()
 					planIterToolUseCount = 0
 				}
-				fmt.Printf("[loop] %s\n", msg.Content)
+				out.Loop(msg.Loop, msg.Total, msg.Content)
+
+			case "loop_marker_done":
+				summary := loopDoneSummary(msg, planLt, tokenStats)
+				logFile.Loop(msg.Loop, summary)
+				out.Emit(render.Event{Type: "loop_done", Iteration: msg.Loop, Total: msg.Total, Content: summary, Text: "[loop] " + summary})
 
 			case "output":
 				parsed := jsonParser.ParseLine(msg.Content)
@@ -1342,22 +3235,29 @@ planLoop:
 						planLoop.SetSessionID(sid)
 						sessionID = sid
 					}
-					handleParsedMessageCLI(parsed, planLoop, jsonParser, tokenStats, logFile, &planIterEstimate, &planSubagentCostAccum, &planLastResultCost, &planIterToolUseCount, &planNoopStreak, planBackoff, planSeenMsgIDs)
+					handleParsedMessageCLI(parsed, planLoop, jsonParser, tokenStats, logFile, out, &planLastResultCost, &planIterToolUseCount, &planNoopStreak, planBackoff, planSeenMsgIDs, dbCtx.tracer)
+					progressOut.observe(jsonParser, parsed)
+					progressOut.report(planLoop, totalIterations, tokenStats, "")
 				} else if isAuthenticationText(msg.Content) {
 					if os.Getenv("ANTHROPIC_API_KEY") != "" {
-						fmt.Fprintf(os.Stderr, "[error] Authentication failed: ANTHROPIC_API_KEY is set but appears to be invalid. Please check your API key.\n")
+						out.Errorf("error", "Authentication failed: ANTHROPIC_API_KEY is set but appears to be invalid. Please check your API key.")
 					} else {
-						fmt.Fprintf(os.Stderr, "[error] Authentication failed: please set ANTHROPIC_API_KEY or run `claude /login`.\n")
+						out.Errorf("error", "Authentication failed: please set ANTHROPIC_API_KEY or run `claude /login`.")
 					}
 					planLoop.Stop()
+				} else if throttle := jsonParser.DetectThrottleText(msg.Content, time.Now()); throttle != nil {
+					hibernateOnThrottleCLI(throttle, planLoop, planBackoff, out)
+				} else if warning := jsonParser.DetectContextWarningText(msg.Content); warning != nil {
+					out.Printf("context", "%s", formatContextWarning(warning))
 				}
 
 			case "error":
-				fmt.Fprintf(os.Stderr, "[error] %s\n", msg.Content)
+				notifyError(dbCtx, planLt, msg.Content)
+				out.Errorf("error", "%s", msg.Content)
 
 			case "complete":
 				planLt.completeLoop(dbCtx, tokenStats)
-				fmt.Printf("[complete] %s\n", msg.Content)
+				out.Printf("complete", "%s", msg.Content)
 				// Get final session ID
 				sessionID = planLoop.GetSessionID()
 				break planLoop
@@ -1372,37 +3272,85 @@ planLoop:
 		return 1
 	default:
 	}
+	// A drain during planning ends the run with the plan phase
+	if planLoop.IsDraining() {
+		progressOut.report(planLoop, totalIterations, tokenStats, progress.StatusComplete)
+		return 0
+	}
 
 	// Phase 2: Building
-	fmt.Printf("[phase] Building (%d iterations)\n", cfg.BuildIterations)
+	out.Printf("phase", "Building (%d iterations)", cfg.BuildIterations)
 
 	buildPromptLoader := prompt.NewLoader(cfg.LoopPrompt, cfg.Goal, cfg.PlanFile)
 	buildPromptContent, err := buildPromptLoader.Load()
+	if err == nil {
+		_, err = renderPrompt(buildPromptContent, 1, cfg.BuildIterations)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "[error] Failed to load build prompt: %v\n", err)
+		out.Errorf("error", "Failed to load build prompt: %v", err)
 		return 1
 	}
 
 	buildLoop := loop.New(loop.Config{
-		Iterations: cfg.BuildIterations,
-		Prompt:     buildPromptContent,
+		Iterations:      cfg.BuildIterations,
+		Prompt:          buildPromptContent + specs.Context(cfg.SpecFolder, cfg.Specs),
+		RenderPrompt:    renderPrompt,
+		CompactEvery:    cfg.CompactEvery,
+		StallNudgeAfter: cfg.StallNudgeAfter,
+		DoneAfterIdle:   cfg.DoneAfterIdle,
+		DoneSentinel:    cfg.DoneSentinel,
+		RestartOnCrash:  cfg.AgentRestartOnCrash,
+		RedoFresh:       cfg.RedoFresh,
+		DoneMarkers:     cfg.LoopSummary,
+		ThrashAction:    cfg.ThrashAction,
+		NoSleepOnError:  cfg.NoSleepOnError,
+		SuccessCodes:    cfg.AgentSuccessCodes,
+		Backend:         agentBackend(cfg.Agent),
+		Model:           cfg.ModelFor(false),
+		FallbackModel:   cfg.FallbackModel,
+		FallbackAfter:   cfg.FallbackAfter,
+		SuccessCmd:      cfg.SuccessCmd,
+		SuccessRetries:  cfg.SuccessRetries,
+		StreamFormat:    cfg.StreamFormat,
+		ConfirmEachLoop: cfg.ConfirmEachLoop,
+		ConfirmStart:    cfg.PlanReview,
+		ExcludeDirs:     cfg.ExcludeDirs,
+		PreLoopHook:     cfg.PreLoopHook,
+		PostLoopHook:    cfg.PostLoopHook,
+		HookTimeout:     cfg.HookTimeout,
+		HookMustPass:    cfg.HookMustPass,
+		MaxRetries:      cfg.MaxRetries,
+		TotalRetries:    cfg.TotalRetries,
+		RetryBudget:     retries,
+		RetryFailed:     cfg.RetryFailed,
+		RetryBackoff:    cfg.RetryBackoff,
+		ActiveHours:     cfg.ActiveHours,
+		Deadline:        deadline,
+		AgentTimeout:    cfg.IterationTimeout,
+		NoOutputTimeout: cfg.NoOutputTimeout,
+		Transcripts:     transcripts(cfg, "build"),
+		CommitReport:    cfg.CommitReport,
+		WarnNoCommit:    cfg.WarnNoCommit,
 	})
+	api.SetLoop(buildLoop)
+	target.set(buildLoop, reloadPrompt(buildPromptLoader, cfg))
 
 	// Set the resume session ID from the plan phase
 	if sessionID != "" {
 		buildLoop.SetResumeSessionID(sessionID)
 	}
 
+	if cfg.PlanReview {
+		out.Printf("review", "%s", planReviewNotice(cfg.PlanFile))
+	}
 	buildLoop.Start(ctx)
 
-	var buildIterEstimate float64
-	var buildSubagentCostAccum float64
 	var buildLastResultCost float64
 	var buildIterToolUseCount int
 	var buildNoopStreak int
 	buildSeenMsgIDs := make(map[string]bool)
 	buildLt := &loopTracker{}
-	buildBackoff := loop.NewBackoff() // exponential backoff for API 529 errors (build phase)
+	buildBackoff := buildLoop.NewBackoff() // exponential backoff for API 529 errors (build phase)
 
 	// Start per-minute checkpoint ticker for build phase
 	buildTicker := time.NewTicker(time.Minute)
@@ -1410,31 +3358,53 @@ planLoop:
 
 	// Process build loop output
 	buildOutput := buildLoop.Output()
+	confirmLines := confirmInput(cfg.ConfirmEachLoop || cfg.PlanReview, os.Stdin)
 	for {
 		select {
 		case <-ctx.Done():
 			buildLt.completeLoop(dbCtx, tokenStats)
+			progressOut.report(buildLoop, cfg.Iterations+buildLoop.GetIterations(), tokenStats, progress.StatusFailed)
 			return 1
 		case <-buildTicker.C:
 			buildLt.flushDelta(dbCtx, tokenStats)
 			if exceeded, hourCost, nextHour := checkCostPacing(dbCtx, cfg.MaxCostPerHour, buildLoop); exceeded {
-				fmt.Printf("[hibernate] Cost budget exceeded ($%.4f/$%.2f/hr), pausing until %s\n", hourCost, cfg.MaxCostPerHour, nextHour.Format(time.Kitchen))
+				out.Printf("paced", "Cost budget exceeded ($%.4f/$%.2f/hr), pausing until %s", hourCost, cfg.MaxCostPerHour, nextHour.Format(time.Kitchen))
 			}
+		case <-statusTicker.C:
+			statusBar.update(cfg.Iterations+buildLoop.GetIterations(), tokenStats)
+			progressOut.report(buildLoop, cfg.Iterations+buildLoop.GetIterations(), tokenStats, "")
+		case line := <-confirmLines:
+			confirmLoop(buildLoop, line)
 		case msg, ok := <-buildOutput:
 			if !ok {
 				buildLt.completeLoop(dbCtx, tokenStats)
+				progressOut.report(buildLoop, cfg.Iterations+buildLoop.GetIterations(), tokenStats, progress.StatusComplete)
 				return 0
 			}
 
 			switch msg.Type {
 			case "loop_marker":
+				logFile.Loop(msg.Loop, msg.Content)
+				recordHibernation(msg, tokenStats)
+				recordChanges(msg, buildLt)
+				notifyHibernate(dbCtx, msg)
 				if isNewLoopStart(msg.Content) {
+					iterationsRun++
+					statusBar.current = iterationsRun
+					progressOut.current = iterationsRun
 					buildLt.startNewLoop(dbCtx, tokenStats, msg.Loop)
-					buildIterEstimate = 0
-					buildSubagentCostAccum = 0
+					tokenStats.EndIteration()
 					buildIterToolUseCount = 0
 				}
-				fmt.Printf("[loop] %s\n", msg.Content)
+				out.Loop(msg.Loop, msg.Total, msg.Content)
+				if isConfirmWait(msg.Content) {
+					out.Printf("confirm", "Press Enter to run loop %d, or type a nudge for it and press Enter", msg.Loop)
+				}
+
+			case "loop_marker_done":
+				summary := loopDoneSummary(msg, buildLt, tokenStats)
+				logFile.Loop(msg.Loop, summary)
+				out.Emit(render.Event{Type: "loop_done", Iteration: msg.Loop, Total: msg.Total, Content: summary, Text: "[loop] " + summary})
 
 			case "output":
 				parsed := jsonParser.ParseLine(msg.Content)
@@ -1442,32 +3412,46 @@ planLoop:
 					if sid := jsonParser.GetSessionID(parsed); sid != "" {
 						buildLoop.SetSessionID(sid)
 					}
-					handleParsedMessageCLI(parsed, buildLoop, jsonParser, tokenStats, logFile, &buildIterEstimate, &buildSubagentCostAccum, &buildLastResultCost, &buildIterToolUseCount, &buildNoopStreak, buildBackoff, buildSeenMsgIDs)
+					handleParsedMessageCLI(parsed, buildLoop, jsonParser, tokenStats, logFile, out, &buildLastResultCost, &buildIterToolUseCount, &buildNoopStreak, buildBackoff, buildSeenMsgIDs, dbCtx.tracer)
+					progressOut.observe(jsonParser, parsed)
+					progressOut.report(buildLoop, cfg.Iterations+buildLoop.GetIterations(), tokenStats, "")
 				} else if isAuthenticationText(msg.Content) {
 					if os.Getenv("ANTHROPIC_API_KEY") != "" {
-						fmt.Fprintf(os.Stderr, "[error] Authentication failed: ANTHROPIC_API_KEY is set but appears to be invalid. Please check your API key.\n")
+						out.Errorf("error", "Authentication failed: ANTHROPIC_API_KEY is set but appears to be invalid. Please check your API key.")
 					} else {
-						fmt.Fprintf(os.Stderr, "[error] Authentication failed: please set ANTHROPIC_API_KEY or run `claude /login`.\n")
+						out.Errorf("error", "Authentication failed: please set ANTHROPIC_API_KEY or run `claude /login`.")
 					}
 					buildLoop.Stop()
+				} else if throttle := jsonParser.DetectThrottleText(msg.Content, time.Now()); throttle != nil {
+					hibernateOnThrottleCLI(throttle, buildLoop, buildBackoff, out)
+				} else if warning := jsonParser.DetectContextWarningText(msg.Content); warning != nil {
+					out.Printf("context", "%s", formatContextWarning(warning))
 				}
 
 			case "error":
-				fmt.Fprintf(os.Stderr, "[error] %s\n", msg.Content)
+				notifyError(dbCtx, buildLt, msg.Content)
+				out.Errorf("error", "%s", msg.Content)
 
 			case "complete":
 				buildLt.completeLoop(dbCtx, tokenStats)
-				fmt.Printf("[complete] %s\n", msg.Content)
+				out.Printf("complete", "%s", msg.Content)
 				cancel()
-				return 0
+				exitCode := 0
+				if buildLoop.SuccessFailed() {
+					exitCode = 1
+				}
+				progressOut.report(buildLoop, cfg.Iterations+buildLoop.GetIterations(), tokenStats, finalStatus(exitCode))
+				return exitCode
 			}
 		}
 	}
 }
 
 // runPlanAndBuild runs plan-and-build mode: planning (1 iteration) then building (N iterations)
-// in a single TUI session with mode display transitions.
-func runPlanAndBuild(cfg *config.Config, tokenStats *stats.TokenStats, logFile io.Writer, dbCtx *dbContext) {
+// in a single TUI session with mode display transitions. It returns the TUI's
+// final model once the user (or --close-after) quits, and whether the run
+// failed.
+func runPlanAndBuild(cfg *config.Config, tokenStats *stats.TokenStats, logFile *runlog.Writer, dbCtx *dbContext, api *control.Server) (tea.Model, bool) {
 	// Set up channels for TUI communication
 	msgChan := make(chan tui.Message, 100)
 	doneChan := make(chan struct{})
@@ -1482,41 +3466,71 @@ func runPlanAndBuild(cfg *config.Config, tokenStats *stats.TokenStats, logFile i
 	model.SetLoopProgress(0, cfg.Iterations)
 	model.SetTmuxStatusBar(tmuxBar)
 	model.SetGitContext(dbCtx.repo, dbCtx.branch)
-
-	// Parse implementation plan for task counts
-	completedTasks, totalTasks := parseTaskCounts(cfg.PlanFile)
-	model.SetCompletedTasks(completedTasks, totalTasks)
+	model.SetFooterOnTop(cfg.TUILayout == "top")
+	model.SetMaxContentWidth(cfg.MaxContentWidth)
+	model.SetCompactFeed(cfg.CompactFeed)
+	model.SetStripANSI(cfg.StripANSI)
+	model.SetCostThresholds(cfg.CostWarn, cfg.CostCrit)
+	model.SetScheduled(cfg.StartTime(time.Now()))
+	model.SetCloseAfter(cfg.CloseAfter)
+	model.SetWrappedSession(tmux.WrappedSession())
+
+	// Show the implementation plan's task counts and current task
+	model.SetPlanFile(planStatus(loadPlan(cfg.PlanFile)))
 
 	// Start in planning mode
 	model.SetCurrentMode("Planning")
 
 	// Create the Bubble Tea program
-	program := tea.NewProgram(model, tea.WithAltScreen())
+	program := tea.NewProgram(model, programOptions(cfg)...)
+	stopPlanWatch := watchPlanFile(cfg.PlanFile, program)
+	defer stopPlanWatch()
 
 	// Set up context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Handle OS signals for graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigChan
+	stopSignals := shutdownSignals(func() {
+		program.Send(tui.SendDrain()())
+	}, func() {
 		cancel()
-		close(doneChan)
-	}()
+		closeDone(doneChan)
+	})
+	defer stopSignals()
 
 	// Create the parser
-	jsonParser := parser.NewParser()
+	jsonParser := newJSONParser(cfg)
 
 	// Start the plan-and-build orchestration goroutine
-	go runPlanAndBuildPhases(ctx, cfg, jsonParser, tokenStats, msgChan, doneChan, program, logFile, dbCtx)
+	result := &planAndBuildResult{}
+	go runPlanAndBuildPhases(ctx, cfg, jsonParser, tokenStats, msgChan, doneChan, program, logFile, dbCtx, api, result)
 
 	// Run the TUI (blocks until user quits)
-	if _, err := program.Run(); err != nil {
+	finalModel, err := program.Run()
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error running TUI: %v\n", err)
-		os.Exit(1)
+		return finalModel, true
 	}
+	return finalModel, result.failed()
+}
+
+// planAndBuildResult records what a plan-and-build TUI run's exit code and
+// run_complete notification need from its phases, which run in their own
+// goroutine.
+type planAndBuildResult struct {
+	buildLoop    atomic.Pointer[loop.Loop] // set before the build phase starts
+	promptFailed atomic.Bool               // a phase's prompt couldn't be loaded
+}
+
+// failed reports whether a phase's prompt couldn't be loaded or the build
+// phase's --success-cmd never passed.
+func (r *planAndBuildResult) failed() bool {
+	if r.promptFailed.Load() {
+		return true
+	}
+	l := r.buildLoop.Load()
+	return l != nil && l.SuccessFailed()
 }
 
 // runPlanAndBuildPhases orchestrates the plan and build phases sequentially
@@ -1528,27 +3542,62 @@ func runPlanAndBuildPhases(
 	msgChan chan<- tui.Message,
 	doneChan chan struct{},
 	program *tea.Program,
-	logFile io.Writer,
+	logFile *runlog.Writer,
 	dbCtx *dbContext,
+	api *control.Server,
+	result *planAndBuildResult,
 ) {
 	defer close(msgChan)
+	renderPrompt := promptRenderer(cfg, dbCtx)
+	deadline := cfg.Deadline(time.Now()) // --max-duration covers both phases
+	retries := &loop.RetryBudget{}       // so does --total-retries
 
 	// Phase 1: Planning
 	planPromptLoader := prompt.NewPlanLoader(cfg.LoopPrompt, cfg.Goal, cfg.PlanFile)
 	planPromptContent, err := planPromptLoader.Load()
+	if err == nil {
+		_, err = renderPrompt(planPromptContent, 1, cfg.Iterations)
+	}
 	if err != nil {
 		msgChan <- tui.Message{
 			Role:    tui.RoleSystem,
 			Content: fmt.Sprintf("Error loading plan prompt: %v", err),
 		}
+		result.promptFailed.Store(true)
 		close(doneChan)
 		return
 	}
 
 	planLoop := loop.New(loop.Config{
-		Iterations: cfg.Iterations, // Always 1 for plan phase
-		Prompt:     planPromptContent,
+		Iterations:      cfg.Iterations, // Always 1 for plan phase
+		Prompt:          planPromptContent + specs.Context(cfg.SpecFolder, cfg.Specs),
+		RenderPrompt:    renderPrompt,
+		RestartOnCrash:  cfg.AgentRestartOnCrash,
+		MaxRetries:      cfg.MaxRetries,
+		TotalRetries:    cfg.TotalRetries,
+		RetryBudget:     retries,
+		RetryFailed:     cfg.RetryFailed,
+		RetryBackoff:    cfg.RetryBackoff,
+		ActiveHours:     cfg.ActiveHours,
+		Deadline:        deadline,
+		AgentTimeout:    cfg.IterationTimeout,
+		NoOutputTimeout: cfg.NoOutputTimeout,
+		Transcripts:     transcripts(cfg, "plan"),
+		CommitReport:    cfg.CommitReport,
+		WarnNoCommit:    cfg.WarnNoCommit,
+		RedoFresh:       cfg.RedoFresh,
+		DoneMarkers:     cfg.LoopSummary,
+		ThrashAction:    cfg.ThrashAction,
+		NoSleepOnError:  cfg.NoSleepOnError,
+		SuccessCodes:    cfg.AgentSuccessCodes,
+		Backend:         agentBackend(cfg.Agent),
+		Model:           cfg.ModelFor(true),
+		FallbackModel:   cfg.FallbackModel,
+		FallbackAfter:   cfg.FallbackAfter,
+		StartAt:         cfg.StartTime(time.Now()),
+		StreamFormat:    cfg.StreamFormat,
 	})
+	api.SetLoop(planLoop)
 
 	// Update TUI with planning phase and set loop reference for hotkey control
 	program.Send(tui.SendModeUpdate("Planning")())
@@ -1567,23 +3616,71 @@ func runPlanAndBuildPhases(
 		return
 	default:
 	}
+	// A drain (q or SIGTERM) during planning ends the run with the plan phase
+	if planLoop.IsDraining() {
+		closeDone(doneChan)
+		return
+	}
 
 	// Phase 2: Building
 	buildPromptLoader := prompt.NewLoader(cfg.LoopPrompt, cfg.Goal, cfg.PlanFile)
 	buildPromptContent, err := buildPromptLoader.Load()
+	if err == nil {
+		_, err = renderPrompt(buildPromptContent, 1, cfg.BuildIterations)
+	}
 	if err != nil {
 		msgChan <- tui.Message{
 			Role:    tui.RoleSystem,
 			Content: fmt.Sprintf("Error loading build prompt: %v", err),
 		}
+		result.promptFailed.Store(true)
 		close(doneChan)
 		return
 	}
 
 	buildLoop := loop.New(loop.Config{
-		Iterations: cfg.BuildIterations,
-		Prompt:     buildPromptContent,
+		Iterations:      cfg.BuildIterations,
+		Prompt:          buildPromptContent + specs.Context(cfg.SpecFolder, cfg.Specs),
+		RenderPrompt:    renderPrompt,
+		CompactEvery:    cfg.CompactEvery,
+		StallNudgeAfter: cfg.StallNudgeAfter,
+		DoneAfterIdle:   cfg.DoneAfterIdle,
+		DoneSentinel:    cfg.DoneSentinel,
+		RestartOnCrash:  cfg.AgentRestartOnCrash,
+		RedoFresh:       cfg.RedoFresh,
+		DoneMarkers:     cfg.LoopSummary,
+		ThrashAction:    cfg.ThrashAction,
+		NoSleepOnError:  cfg.NoSleepOnError,
+		SuccessCodes:    cfg.AgentSuccessCodes,
+		Backend:         agentBackend(cfg.Agent),
+		Model:           cfg.ModelFor(false),
+		FallbackModel:   cfg.FallbackModel,
+		FallbackAfter:   cfg.FallbackAfter,
+		SuccessCmd:      cfg.SuccessCmd,
+		SuccessRetries:  cfg.SuccessRetries,
+		StreamFormat:    cfg.StreamFormat,
+		ConfirmEachLoop: cfg.ConfirmEachLoop,
+		ConfirmStart:    cfg.PlanReview,
+		ExcludeDirs:     cfg.ExcludeDirs,
+		PreLoopHook:     cfg.PreLoopHook,
+		PostLoopHook:    cfg.PostLoopHook,
+		HookTimeout:     cfg.HookTimeout,
+		HookMustPass:    cfg.HookMustPass,
+		MaxRetries:      cfg.MaxRetries,
+		TotalRetries:    cfg.TotalRetries,
+		RetryBudget:     retries,
+		RetryFailed:     cfg.RetryFailed,
+		RetryBackoff:    cfg.RetryBackoff,
+		ActiveHours:     cfg.ActiveHours,
+		Deadline:        deadline,
+		AgentTimeout:    cfg.IterationTimeout,
+		NoOutputTimeout: cfg.NoOutputTimeout,
+		Transcripts:     transcripts(cfg, "build"),
+		CommitReport:    cfg.CommitReport,
+		WarnNoCommit:    cfg.WarnNoCommit,
 	})
+	api.SetLoop(buildLoop)
+	result.buildLoop.Store(buildLoop)
 
 	// Set the resume session ID from the plan phase
 	if sessionID != "" {
@@ -1596,6 +3693,18 @@ func runPlanAndBuildPhases(
 	program.Send(tui.SendLoopStarted()())
 	program.Send(tui.SendLoopRef(buildLoop)())
 
+	// With --plan-review the build loop waits before its first iteration;
+	// show the plan so it can be checked (and edited on disk) first
+	if cfg.PlanReview {
+		msgChan <- tui.Message{
+			Role:    tui.RoleSystem,
+			Content: planReviewNotice(cfg.PlanFile),
+		}
+		if plan, err := os.ReadFile(cfg.PlanFile); err == nil {
+			program.Send(tui.SendDetail(cfg.PlanFile, string(plan))())
+		}
+	}
+
 	// Start the build loop
 	buildLoop.Start(ctx)
 
@@ -1611,26 +3720,24 @@ func processPlanPhase(
 	tokenStats *stats.TokenStats,
 	msgChan chan<- tui.Message,
 	program *tea.Program,
-	logFile io.Writer,
+	logFile *runlog.Writer,
 	dbCtx *dbContext,
 	maxCostPerHour float64,
 ) string {
 	loopOutput := planLoop.Output()
 	var loopTotalTokens int64
-	var iterEstimate float64
-	var subagentCostAccum float64
 	var lastResultCost float64
 	var iterToolUseCount int
 	var noopStreak int
 	seenMsgIDs := make(map[string]bool)
 	lt := &loopTracker{}
-	apiBackoff := loop.NewBackoff() // exponential backoff for API 529 errors
+	apiBackoff := planLoop.NewBackoff() // exponential backoff for API 529 errors
 
 	// Startup budget check — hibernate before first iteration if budget already exceeded
 	if exceeded, hourCost, nextHour := checkCostPacing(dbCtx, maxCostPerHour, planLoop); exceeded {
-		program.Send(tui.SendHibernate(nextHour)())
+		program.Send(tui.SendPaced(nextHour, "cost limit")())
 		msgChan <- tui.Message{
-			Role:    tui.RoleHibernate,
+			Role:    tui.RolePaced,
 			Content: fmt.Sprintf("Cost budget exceeded ($%.4f/$%.2f/hr) at startup, pausing until %s", hourCost, maxCostPerHour, nextHour.Format(time.Kitchen)),
 		}
 	}
@@ -1647,9 +3754,9 @@ func processPlanPhase(
 		case <-ticker.C:
 			lt.flushDelta(dbCtx, tokenStats)
 			if exceeded, hourCost, nextHour := checkCostPacing(dbCtx, maxCostPerHour, planLoop); exceeded {
-				program.Send(tui.SendHibernate(nextHour)())
+				program.Send(tui.SendPaced(nextHour, "cost limit")())
 				msgChan <- tui.Message{
-					Role:    tui.RoleHibernate,
+					Role:    tui.RolePaced,
 					Content: fmt.Sprintf("Cost budget exceeded ($%.4f/$%.2f/hr), pausing until %s", hourCost, maxCostPerHour, nextHour.Format(time.Kitchen)),
 				}
 			}
@@ -1662,18 +3769,27 @@ func processPlanPhase(
 
 			switch msg.Type {
 			case "loop_marker":
-				handleLoopMarker(msg, msgChan, program, &loopTotalTokens, &iterEstimate, &subagentCostAccum, &iterToolUseCount, dbCtx, lt, tokenStats, seenMsgIDs)
+				logFile.Loop(msg.Loop, msg.Content)
+				handleLoopMarker(msg, msgChan, program, jsonParser, &loopTotalTokens, &iterToolUseCount, dbCtx, lt, tokenStats, seenMsgIDs)
 				if isNewLoopStart(msg.Content) {
 					apiBackoff.Reset()
 				}
 
+			case "loop_marker_done":
+				summary := loopDoneSummary(msg, lt, tokenStats)
+				logFile.Loop(msg.Loop, summary)
+				msgChan <- tui.Message{
+					Role:    tui.RoleLoopDone,
+					Content: summary,
+				}
+
 			case "output":
 				parsed := jsonParser.ParseLine(msg.Content)
 				if parsed != nil {
 					if sessionID := jsonParser.GetSessionID(parsed); sessionID != "" {
 						planLoop.SetSessionID(sessionID)
 					}
-					handleParsedMessage(parsed, planLoop, jsonParser, tokenStats, msgChan, program, &loopTotalTokens, logFile, &iterEstimate, &subagentCostAccum, &lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, seenMsgIDs)
+					handleParsedMessage(parsed, planLoop, jsonParser, tokenStats, msgChan, program, &loopTotalTokens, logFile, &lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, seenMsgIDs, dbCtx.tracer)
 				} else if isAuthenticationText(msg.Content) {
 					if os.Getenv("ANTHROPIC_API_KEY") != "" {
 						msgChan <- tui.Message{
@@ -1687,9 +3803,19 @@ func processPlanPhase(
 						}
 					}
 					planLoop.Stop()
+				} else if throttle := jsonParser.DetectThrottleText(msg.Content, time.Now()); throttle != nil {
+					hibernateOnThrottle(throttle, planLoop, apiBackoff, msgChan, program)
+				} else if warning := jsonParser.DetectContextWarningText(msg.Content); warning != nil {
+					label := formatContextWarning(warning)
+					program.Send(tui.SendContextWarning(label)())
+					msgChan <- tui.Message{
+						Role:    tui.RoleSystem,
+						Content: "⚠ " + label,
+					}
 				}
 
 			case "error":
+				notifyError(dbCtx, lt, msg.Content)
 				msgChan <- tui.Message{
 					Role:    tui.RoleSystem,
 					Content: fmt.Sprintf("Error: %s", msg.Content),
@@ -1717,26 +3843,24 @@ func processBuildPhase(
 	msgChan chan<- tui.Message,
 	doneChan chan struct{},
 	program *tea.Program,
-	logFile io.Writer,
+	logFile *runlog.Writer,
 	dbCtx *dbContext,
 	maxCostPerHour float64,
 ) {
 	loopOutput := buildLoop.Output()
 	var loopTotalTokens int64
-	var iterEstimate float64
-	var subagentCostAccum float64
 	var lastResultCost float64
 	var iterToolUseCount int
 	var noopStreak int
 	seenMsgIDs := make(map[string]bool)
 	lt := &loopTracker{}
-	apiBackoff := loop.NewBackoff() // exponential backoff for API 529 errors
+	apiBackoff := buildLoop.NewBackoff() // exponential backoff for API 529 errors
 
 	// Startup budget check — hibernate before first iteration if budget already exceeded
 	if exceeded, hourCost, nextHour := checkCostPacing(dbCtx, maxCostPerHour, buildLoop); exceeded {
-		program.Send(tui.SendHibernate(nextHour)())
+		program.Send(tui.SendPaced(nextHour, "cost limit")())
 		msgChan <- tui.Message{
-			Role:    tui.RoleHibernate,
+			Role:    tui.RolePaced,
 			Content: fmt.Sprintf("Cost budget exceeded ($%.4f/$%.2f/hr) at startup, pausing until %s", hourCost, maxCostPerHour, nextHour.Format(time.Kitchen)),
 		}
 	}
@@ -1753,9 +3877,9 @@ func processBuildPhase(
 		case <-ticker.C:
 			lt.flushDelta(dbCtx, tokenStats)
 			if exceeded, hourCost, nextHour := checkCostPacing(dbCtx, maxCostPerHour, buildLoop); exceeded {
-				program.Send(tui.SendHibernate(nextHour)())
+				program.Send(tui.SendPaced(nextHour, "cost limit")())
 				msgChan <- tui.Message{
-					Role:    tui.RoleHibernate,
+					Role:    tui.RolePaced,
 					Content: fmt.Sprintf("Cost budget exceeded ($%.4f/$%.2f/hr), pausing until %s", hourCost, maxCostPerHour, nextHour.Format(time.Kitchen)),
 				}
 			}
@@ -1772,18 +3896,27 @@ func processBuildPhase(
 
 			switch msg.Type {
 			case "loop_marker":
-				handleLoopMarker(msg, msgChan, program, &loopTotalTokens, &iterEstimate, &subagentCostAccum, &iterToolUseCount, dbCtx, lt, tokenStats, seenMsgIDs)
+				logFile.Loop(msg.Loop, msg.Content)
+				handleLoopMarker(msg, msgChan, program, jsonParser, &loopTotalTokens, &iterToolUseCount, dbCtx, lt, tokenStats, seenMsgIDs)
 				if isNewLoopStart(msg.Content) {
 					apiBackoff.Reset()
 				}
 
+			case "loop_marker_done":
+				summary := loopDoneSummary(msg, lt, tokenStats)
+				logFile.Loop(msg.Loop, summary)
+				msgChan <- tui.Message{
+					Role:    tui.RoleLoopDone,
+					Content: summary,
+				}
+
 			case "output":
 				parsed := jsonParser.ParseLine(msg.Content)
 				if parsed != nil {
 					if sessionID := jsonParser.GetSessionID(parsed); sessionID != "" {
 						buildLoop.SetSessionID(sessionID)
 					}
-					handleParsedMessage(parsed, buildLoop, jsonParser, tokenStats, msgChan, program, &loopTotalTokens, logFile, &iterEstimate, &subagentCostAccum, &lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, seenMsgIDs)
+					handleParsedMessage(parsed, buildLoop, jsonParser, tokenStats, msgChan, program, &loopTotalTokens, logFile, &lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, seenMsgIDs, dbCtx.tracer)
 				} else if isAuthenticationText(msg.Content) {
 					if os.Getenv("ANTHROPIC_API_KEY") != "" {
 						msgChan <- tui.Message{
@@ -1797,9 +3930,19 @@ func processBuildPhase(
 						}
 					}
 					buildLoop.Stop()
+				} else if throttle := jsonParser.DetectThrottleText(msg.Content, time.Now()); throttle != nil {
+					hibernateOnThrottle(throttle, buildLoop, apiBackoff, msgChan, program)
+				} else if warning := jsonParser.DetectContextWarningText(msg.Content); warning != nil {
+					label := formatContextWarning(warning)
+					program.Send(tui.SendContextWarning(label)())
+					msgChan <- tui.Message{
+						Role:    tui.RoleSystem,
+						Content: "⚠ " + label,
+					}
 				}
 
 			case "error":
+				notifyError(dbCtx, lt, msg.Content)
 				msgChan <- tui.Message{
 					Role:    tui.RoleSystem,
 					Content: fmt.Sprintf("Error: %s", msg.Content),
@@ -1811,44 +3954,294 @@ func processBuildPhase(
 					Role:    tui.RoleSystem,
 					Content: msg.Content,
 				}
+				if commits, ok := reportCommits(dbCtx, logFile); ok {
+					msgChan <- commits
+				}
 				program.Send(tui.SendDone()())
 			}
 		}
 	}
 }
 
+// cliStatusBar drives the tmux status-right in CLI mode, where there is no TUI
+// tick loop to do it. Outside tmux the underlying bar is inactive and every
+// method is a no-op.
+type cliStatusBar struct {
+	bar          *tmux.StatusBar
+	repo, branch string
+	planFile     string // re-read on each update for the tasks field
+	start        time.Time
+	startCost    float64
+	current      int // iteration in progress, from the latest loop marker
+}
+
+// newCLIStatusBar takes over the tmux status bar for a CLI run.
+func newCLIStatusBar(tokenStats *stats.TokenStats, planFile string) *cliStatusBar {
+	_, repo, branch := stats.GetGitContext()
+	return &cliStatusBar{
+		bar:       tmux.NewStatusBar(),
+		repo:      repo,
+		branch:    branch,
+		planFile:  planFile,
+		start:     time.Now(),
+		startCost: tokenStats.Snapshot().TotalCostUSD,
+	}
+}
+
+// update redraws the status bar for the given total iteration count.
+func (b *cliStatusBar) update(total int, tokenStats *stats.TokenStats) {
+	if !b.bar.IsActive() {
+		return
+	}
+	cost := tokenStats.Snapshot().TotalCostUSD - b.startCost
+	completed, tasks := parseTaskCounts(b.planFile)
+	b.bar.Update(cliStatusRight(b.repo, b.branch, b.current, total, completed, tasks, cost, time.Since(b.start)))
+}
+
+// restore hands the status bar back to tmux.
+func (b *cliStatusBar) restore() {
+	b.bar.Restore()
+}
+
+// cliStatusRight formats the CLI-mode tmux status: the TUI's loop, tasks and
+// uptime fields, with this run's cost alongside the loop count.
+func cliStatusRight(repo, branch string, current, total, completedTasks, totalTasks int, cost float64, elapsed time.Duration) string {
+	loopDisplay := fmt.Sprintf("%d/%d, cost: %s", current, total, stats.FormatCost(cost))
+	return tmux.FormatStatusRight(repo, branch, loopDisplay, tmux.FormatTasks(completedTasks, totalTasks), stats.FormatDuration(elapsed))
+}
+
+// cliProgress emits --progress-to lines in CLI mode for editor and IDE
+// integrations. Without --progress-to every method is a no-op.
+type cliProgress struct {
+	w           *progress.Writer
+	startCost   float64
+	startTokens int64
+	current     int    // iteration in progress, from the latest loop marker
+	task        string // latest task reference, e.g. "#6"
+}
+
+// newCLIProgress opens the --progress-to target, if any.
+func newCLIProgress(target string, tokenStats *stats.TokenStats) (*cliProgress, error) {
+	w, err := progress.Open(target)
+	if err != nil {
+		return nil, err
+	}
+	snap := tokenStats.Snapshot()
+	return &cliProgress{w: w, startCost: snap.TotalCostUSD, startTokens: snap.TotalTokensCount}, nil
+}
+
+// observe records the last task an agent message refers to.
+func (p *cliProgress) observe(jsonParser *parser.Parser, parsed *parser.ParsedMessage) {
+	if p.w == nil || parsed.Type != parser.MessageTypeAssistant {
+		return
+	}
+	for _, text := range jsonParser.ExtractContent(parsed).TextContent {
+		if ref := jsonParser.ExtractTaskReference(text); ref != nil {
+			p.task = fmt.Sprintf("#%d", ref.Number)
+		}
+	}
+}
+
+// report writes the run's state if it changed. An empty status is taken from
+// the loop: hibernating, paused or running.
+func (p *cliProgress) report(l *loop.Loop, total int, tokenStats *stats.TokenStats, status string) {
+	if p.w == nil {
+		return
+	}
+	if status == "" {
+		switch {
+		case l.IsHibernating():
+			status = progress.StatusHibernating
+		case l.IsPaused():
+			status = progress.StatusPaused
+		default:
+			status = progress.StatusRunning
+		}
+	}
+	snap := tokenStats.Snapshot()
+	p.w.Update(progress.State{
+		Loop:    p.current,
+		Total:   total,
+		CostUSD: snap.TotalCostUSD - p.startCost,
+		Tokens:  snap.TotalTokensCount - p.startTokens,
+		Status:  status,
+		Task:    p.task,
+	})
+}
+
+// close closes a --progress-to file or pipe.
+func (p *cliProgress) close() {
+	p.w.Close()
+}
+
+// finalStatus is the progress status for a CLI run ending with exitCode.
+func finalStatus(exitCode int) string {
+	if exitCode != 0 {
+		return progress.StatusFailed
+	}
+	return progress.StatusComplete
+}
+
+// cliSummary formats the end-of-run summary line printed in CLI mode. start
+// and end are token stats snapshots taken when the run began and ended, so the
+// line reports only what this run used.
+func cliSummary(iterations int, elapsed time.Duration, start, end stats.Snapshot) string {
+	tokens := end.TotalTokensCount - start.TotalTokensCount
+	cost := end.TotalCostUSD - start.TotalCostUSD
+	noun := "iterations"
+	if iterations == 1 {
+		noun = "iteration"
+	}
+	summary := fmt.Sprintf("[summary] %d %s in %s, %s tokens, %s", iterations, noun, stats.FormatDuration(elapsed), stats.FormatTokens(tokens), stats.FormatCost(cost))
+	var run stats.Snapshot
+	run.InputTokens = end.InputTokens - start.InputTokens
+	run.OutputTokens = end.OutputTokens - start.OutputTokens
+	run.CacheReadTokens = end.CacheReadTokens - start.CacheReadTokens
+	run.CacheCreationTokens = end.CacheCreationTokens - start.CacheCreationTokens
+	run.TotalCostUSD = cost
+	// Cost per 1k tokens over this run, and where recent iterations are heading
+	if perK := run.CostPerKTokens(); perK > 0 {
+		summary += fmt.Sprintf(" (%s/1k tokens", stats.FormatCost(perK))
+		if end.EfficiencyTrend != stats.TrendSteady {
+			summary += ", " + end.EfficiencyTrend.String()
+		}
+		summary += ")"
+	}
+	// Cache-hit ratio over this run only
+	if run.HasCacheActivity() {
+		summary += fmt.Sprintf(", %.0f%% cache hit", run.CacheHitRatio()*100)
+	}
+	if n := end.Hibernations - start.Hibernations; n > 0 {
+		summary += fmt.Sprintf(", %s", hibernationSummary(n, time.Duration(end.HibernateNs-start.HibernateNs)))
+	}
+	return summary
+}
+
+// hibernationSummary describes rate-limit hibernations, e.g. "hibernated 3
+// times for 00:47:12 total".
+func hibernationSummary(count int64, total time.Duration) string {
+	noun := "times"
+	if count == 1 {
+		noun = "time"
+	}
+	return fmt.Sprintf("hibernated %d %s for %s total", count, noun, stats.FormatDuration(total))
+}
+
+// cliRunLine formats the summary line identifying the run, so a capture file
+// can be joined with the log and checkpoints recorded under the same ID.
+func cliRunLine(runID, dir string) string {
+	return fmt.Sprintf("[summary] run %s in %s", runID, dir)
+}
+
+// workDir returns the absolute working directory, or "." if it is unknown.
+func workDir() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "."
+	}
+	return dir
+}
+
 // isNewLoopStart returns true if content represents a new loop iteration start
 // (contains "LOOP" but not STOPPED/COMPLETED/RESUMED).
 func isNewLoopStart(content string) bool {
 	return strings.Contains(content, "LOOP") &&
+		!isHookMarker(content) &&
 		!strings.Contains(content, "STOPPED") &&
 		!strings.Contains(content, "COMPLETED") &&
 		!strings.Contains(content, "RESUMED") &&
 		!strings.Contains(content, "RETRY")
 }
 
+// planReviewNotice tells the user the plan is ready for review before the
+// build phase starts (--plan-review).
+func planReviewNotice(planFile string) string {
+	return fmt.Sprintf("Plan ready for review in %s. Edit it if needed, then resume to start building.", planFile)
+}
+
+// isConfirmWait returns true when the loop marker means --confirm-each-loop is
+// waiting for the user before the next iteration.
+func isConfirmWait(content string) bool {
+	return strings.Contains(content, loop.ConfirmMarker) && !isHookMarker(content)
+}
+
+// confirmInput reads lines from r for --confirm-each-loop. When step mode is
+// off it returns nil, a channel that never delivers, and leaves r unread.
+func confirmInput(enabled bool, r io.Reader) <-chan string {
+	if !enabled {
+		return nil
+	}
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
+}
+
+// confirmLoop starts the iteration a step-mode loop is waiting on. Non-empty
+// input is sent to the agent as a nudge ahead of the prompt. Input while the
+// loop is running is ignored. Reports whether the loop was resumed.
+func confirmLoop(l *loop.Loop, line string) bool {
+	if !l.IsPaused() {
+		return false
+	}
+	if nudge := strings.TrimSpace(line); nudge != "" {
+		l.Nudge(nudge + "\n\n")
+	}
+	l.Resume()
+	return true
+}
+
 // isRetryLoopStart returns true when the loop marker indicates a hibernate retry
 // (the iteration is being retried after a 529/500 hibernate, not a fresh start).
 func isRetryLoopStart(content string) bool {
-	return strings.Contains(content, "LOOP") && strings.Contains(content, "RETRY")
+	return strings.Contains(content, "LOOP") && strings.Contains(content, "RETRY") && !isHookMarker(content)
+}
+
+// isHookMarker reports whether a loop marker carries a hook result, whose
+// output may contain any text, including other markers' keywords.
+func isHookMarker(content string) bool {
+	first, _, _ := strings.Cut(content, "\n")
+	return strings.Contains(first, " "+loop.HookMarker+" ")
 }
 
 // parseTaskCounts reads an IMPLEMENTATION_PLAN.md file and returns the number of
-// completed (DONE) tasks and the total number of tasks.
+// completed (DONE or NOT NEEDED) tasks and the total number of tasks.
 func parseTaskCounts(filepath string) (completed, total int) {
-	data, err := os.ReadFile(filepath)
+	return loadPlan(filepath).Counts()
+}
+
+// planWatchInterval is how often the TUI checks the plan file for changes.
+const planWatchInterval = 2 * time.Second
+
+// loadPlan reads the implementation plan; a missing or unreadable one has no
+// tasks.
+func loadPlan(path string) *plan.Plan {
+	p, err := plan.Load(path)
 	if err != nil {
-		return 0, 0
+		return &plan.Plan{}
 	}
+	return p
+}
 
-	for _, line := range strings.Split(string(data), "\n") {
-		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, "## TASK ") {
-			total++
-		}
-		if strings.Contains(trimmed, "**Status: DONE**") || strings.Contains(trimmed, "**Status: NOT NEEDED**") {
-			completed++
-		}
+// planStatus returns the plan's task counts and the label of its current
+// task ("" = none), as the TUI footer shows them.
+func planStatus(p *plan.Plan) (completed, total int, current string) {
+	completed, total = p.Counts()
+	if t := p.Current(); t != nil {
+		current = t.Label()
 	}
-	return completed, total
+	return completed, total, current
+}
+
+// watchPlanFile keeps the TUI's task counts and current task in step with
+// the plan file as the agent edits it. The returned function stops watching.
+func watchPlanFile(path string, program *tea.Program) (stop func()) {
+	return plan.Watch(path, planWatchInterval, func(p *plan.Plan) {
+		program.Send(tui.SendPlanFileUpdate(planStatus(p))())
+	})
 }
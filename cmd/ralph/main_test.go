@@ -1,18 +1,27 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"flag"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/cloudosai/ralph-go/internal/config"
 	"github.com/cloudosai/ralph-go/internal/loop"
 	"github.com/cloudosai/ralph-go/internal/parser"
+	"github.com/cloudosai/ralph-go/internal/progress"
+	"github.com/cloudosai/ralph-go/internal/render"
 	"github.com/cloudosai/ralph-go/internal/stats"
+	"github.com/cloudosai/ralph-go/internal/vcs"
 )
 
 func TestParseTaskCountsNoFile(t *testing.T) {
@@ -138,9 +147,9 @@ Some general notes about the project.
 	if total != 0 {
 		t.Errorf("expected total=0 (no ## TASK headers), got %d", total)
 	}
-	// Status line without a TASK header still counts as completed
-	if completed != 1 {
-		t.Errorf("expected completed=1 (status line exists), got %d", completed)
+	// A status line outside any task belongs to no task
+	if completed != 0 {
+		t.Errorf("expected completed=0 (no task for the status line), got %d", completed)
 	}
 }
 
@@ -287,6 +296,23 @@ func TestHandleLoopMarkerReturnsTrue(t *testing.T) {
 	}
 }
 
+func TestHookMarkersAreNotLoopStarts(t *testing.T) {
+	for _, content := range []string{
+		"======= POST HOOK passed: ./check LOOP (0.1s) =======",
+		"======= POST HOOK failed (exit 1): make test (2s) =======\n======= LOOP 2/5 (RETRY) =======\nSTOPPED WAITING",
+	} {
+		if !isHookMarker(content) {
+			t.Errorf("isHookMarker(%q) = false, want true", content)
+		}
+		if isNewLoopStart(content) || isRetryLoopStart(content) || isConfirmWait(content) {
+			t.Errorf("hook marker %q was treated as a loop marker", content)
+		}
+	}
+	if isHookMarker("======= LOOP 1/5 =======\nPOST HOOK") {
+		t.Error("expected a loop marker not to be a hook marker")
+	}
+}
+
 func TestIsRetryLoopStart(t *testing.T) {
 	tests := []struct {
 		content  string
@@ -335,16 +361,14 @@ func TestExitLoopDetection_ConsecutiveNoops(t *testing.T) {
 	tokenStats := stats.NewTokenStats()
 	apiBackoff := loop.NewBackoff()
 
-	var iterEstimate float64
-	var subagentCostAccum float64
 	var lastResultCost float64
 	var iterToolUseCount int
 	var noopStreak int
 
 	// First no-op iteration result
 	handleParsedMessageCLI(
-		makeNoopResult(0.005), claudeLoop, jsonParser, tokenStats, io.Discard,
-		&iterEstimate, &subagentCostAccum, &lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool),
+		makeNoopResult(0.005), claudeLoop, jsonParser, tokenStats, nil, nil,
+		&lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool), nil,
 	)
 
 	if noopStreak != 1 {
@@ -353,13 +377,11 @@ func TestExitLoopDetection_ConsecutiveNoops(t *testing.T) {
 
 	// Simulate new loop start — reset iterToolUseCount but not noopStreak
 	iterToolUseCount = 0
-	iterEstimate = 0
-	subagentCostAccum = 0
 
 	// Second no-op iteration result — should trigger stop
 	handleParsedMessageCLI(
-		makeNoopResult(0.003), claudeLoop, jsonParser, tokenStats, io.Discard,
-		&iterEstimate, &subagentCostAccum, &lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool),
+		makeNoopResult(0.003), claudeLoop, jsonParser, tokenStats, nil, nil,
+		&lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool), nil,
 	)
 
 	if noopStreak != 2 {
@@ -379,16 +401,14 @@ func TestExitLoopDetection_ProductiveIterationResetsStreak(t *testing.T) {
 	tokenStats := stats.NewTokenStats()
 	apiBackoff := loop.NewBackoff()
 
-	var iterEstimate float64
-	var subagentCostAccum float64
 	var lastResultCost float64
 	var iterToolUseCount int
 	var noopStreak int
 
 	// First no-op iteration
 	handleParsedMessageCLI(
-		makeNoopResult(0.005), claudeLoop, jsonParser, tokenStats, io.Discard,
-		&iterEstimate, &subagentCostAccum, &lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool),
+		makeNoopResult(0.005), claudeLoop, jsonParser, tokenStats, nil, nil,
+		&lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool), nil,
 	)
 	if noopStreak != 1 {
 		t.Fatalf("expected noopStreak=1, got %d", noopStreak)
@@ -396,18 +416,16 @@ func TestExitLoopDetection_ProductiveIterationResetsStreak(t *testing.T) {
 
 	// Simulate new loop start
 	iterToolUseCount = 0
-	iterEstimate = 0
-	subagentCostAccum = 0
 
 	// Productive iteration: assistant message with tool use, then result with higher cost
 	handleParsedMessageCLI(
-		makeAssistantWithToolUse(), claudeLoop, jsonParser, tokenStats, io.Discard,
-		&iterEstimate, &subagentCostAccum, &lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool),
+		makeAssistantWithToolUse(), claudeLoop, jsonParser, tokenStats, nil, nil,
+		&lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool), nil,
 	)
 
 	handleParsedMessageCLI(
-		makeNoopResult(0.50), claudeLoop, jsonParser, tokenStats, io.Discard,
-		&iterEstimate, &subagentCostAccum, &lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool),
+		makeNoopResult(0.50), claudeLoop, jsonParser, tokenStats, nil, nil,
+		&lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool), nil,
 	)
 
 	if noopStreak != 0 {
@@ -415,6 +433,30 @@ func TestExitLoopDetection_ProductiveIterationResetsStreak(t *testing.T) {
 	}
 }
 
+func TestExitLoopDetection_ErroredResultIsNotNoop(t *testing.T) {
+	// A zero-cost errored result is a failed iteration, not a no-op success
+	claudeLoop := loop.New(loop.Config{Iterations: 5, Prompt: "test"})
+	jsonParser := parser.NewParser()
+	tokenStats := stats.NewTokenStats()
+	apiBackoff := loop.NewBackoff()
+
+	var lastResultCost float64
+	var iterToolUseCount int
+	noopStreak := 1
+
+	errored := makeNoopResult(0)
+	errored.IsError = true
+	errored.Subtype = "error_during_execution"
+	handleParsedMessageCLI(
+		errored, claudeLoop, jsonParser, tokenStats, nil, nil,
+		&lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool), nil,
+	)
+
+	if noopStreak != 0 {
+		t.Errorf("expected an errored result to break the no-op streak, got %d", noopStreak)
+	}
+}
+
 func TestExitLoopDetection_HighCostNoToolsIsNotNoop(t *testing.T) {
 	// A high-cost iteration with no tool use (e.g., planning/thinking) should NOT be a noop
 	claudeLoop := loop.New(loop.Config{Iterations: 5, Prompt: "test"})
@@ -422,16 +464,14 @@ func TestExitLoopDetection_HighCostNoToolsIsNotNoop(t *testing.T) {
 	tokenStats := stats.NewTokenStats()
 	apiBackoff := loop.NewBackoff()
 
-	var iterEstimate float64
-	var subagentCostAccum float64
 	var lastResultCost float64
 	var iterToolUseCount int
 	var noopStreak int
 
 	// High cost result with no tool use — this is legitimate thinking work
 	handleParsedMessageCLI(
-		makeNoopResult(0.50), claudeLoop, jsonParser, tokenStats, io.Discard,
-		&iterEstimate, &subagentCostAccum, &lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool),
+		makeNoopResult(0.50), claudeLoop, jsonParser, tokenStats, nil, nil,
+		&lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool), nil,
 	)
 
 	if noopStreak != 0 {
@@ -446,8 +486,6 @@ func TestExitLoopDetection_SubagentResultIgnored(t *testing.T) {
 	tokenStats := stats.NewTokenStats()
 	apiBackoff := loop.NewBackoff()
 
-	var iterEstimate float64
-	var subagentCostAccum float64
 	var lastResultCost float64
 	var iterToolUseCount int
 	var noopStreak int
@@ -460,8 +498,8 @@ func TestExitLoopDetection_SubagentResultIgnored(t *testing.T) {
 	}
 
 	handleParsedMessageCLI(
-		subagentResult, claudeLoop, jsonParser, tokenStats, io.Discard,
-		&iterEstimate, &subagentCostAccum, &lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool),
+		subagentResult, claudeLoop, jsonParser, tokenStats, nil, nil,
+		&lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool), nil,
 	)
 
 	if noopStreak != 0 {
@@ -498,7 +536,7 @@ func TestHandleParsedMessageCLI_AuthError_StopsLoop(t *testing.T) {
 	tokenStats := stats.NewTokenStats()
 	claudeLoop := loop.New(loop.Config{Iterations: 5, Prompt: "test"})
 	apiBackoff := loop.NewBackoff()
-	var iterEstimate, subagentCostAccum, lastResultCost float64
+	var lastResultCost float64
 	var iterToolUseCount, noopStreak int
 
 	line := `{"type":"assistant","is_error":true,"error":"authentication_error"}`
@@ -508,8 +546,8 @@ func TestHandleParsedMessageCLI_AuthError_StopsLoop(t *testing.T) {
 	}
 
 	handleParsedMessageCLI(
-		parsed, claudeLoop, jsonParser, tokenStats, io.Discard,
-		&iterEstimate, &subagentCostAccum, &lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool),
+		parsed, claudeLoop, jsonParser, tokenStats, nil, nil,
+		&lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool), nil,
 	)
 
 	if claudeLoop.IsRunning() {
@@ -602,7 +640,7 @@ func TestHandleParsedMessageCLI_AuthError_WithAPIKey(t *testing.T) {
 	tokenStats := stats.NewTokenStats()
 	claudeLoop := loop.New(loop.Config{Iterations: 5, Prompt: "test"})
 	apiBackoff := loop.NewBackoff()
-	var iterEstimate, subagentCostAccum, lastResultCost float64
+	var lastResultCost float64
 	var iterToolUseCount, noopStreak int
 
 	line := `{"type":"assistant","is_error":true,"error":"authentication_error"}`
@@ -612,8 +650,8 @@ func TestHandleParsedMessageCLI_AuthError_WithAPIKey(t *testing.T) {
 	}
 
 	handleParsedMessageCLI(
-		parsed, claudeLoop, jsonParser, tokenStats, io.Discard,
-		&iterEstimate, &subagentCostAccum, &lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool),
+		parsed, claudeLoop, jsonParser, tokenStats, nil, nil,
+		&lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool), nil,
 	)
 
 	if claudeLoop.IsRunning() {
@@ -629,7 +667,7 @@ func TestHandleParsedMessageCLI_AuthError_WithoutAPIKey(t *testing.T) {
 	tokenStats := stats.NewTokenStats()
 	claudeLoop := loop.New(loop.Config{Iterations: 5, Prompt: "test"})
 	apiBackoff := loop.NewBackoff()
-	var iterEstimate, subagentCostAccum, lastResultCost float64
+	var lastResultCost float64
 	var iterToolUseCount, noopStreak int
 
 	line := `{"type":"assistant","is_error":true,"error":"authentication_error"}`
@@ -639,8 +677,8 @@ func TestHandleParsedMessageCLI_AuthError_WithoutAPIKey(t *testing.T) {
 	}
 
 	handleParsedMessageCLI(
-		parsed, claudeLoop, jsonParser, tokenStats, io.Discard,
-		&iterEstimate, &subagentCostAccum, &lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool),
+		parsed, claudeLoop, jsonParser, tokenStats, nil, nil,
+		&lastResultCost, &iterToolUseCount, &noopStreak, apiBackoff, make(map[string]bool), nil,
 	)
 
 	if claudeLoop.IsRunning() {
@@ -784,3 +822,1041 @@ func TestStartNewLoopNotCalledOnHibernateRetry(t *testing.T) {
 		t.Errorf("expected startNewLoop call count=2 after second fresh loop, got %d", startNewLoopCallCount)
 	}
 }
+
+func TestProgramOptions_AltScreenByDefault(t *testing.T) {
+	cfg := config.NewConfig()
+	if opts := programOptions(cfg); len(opts) != 1 {
+		t.Errorf("expected alt-screen option by default, got %d options", len(opts))
+	}
+}
+
+func TestProgramOptions_NoAltScreen(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.NoAltScreen = true
+	if opts := programOptions(cfg); len(opts) != 0 {
+		t.Errorf("expected no program options with --no-alt-screen, got %d", len(opts))
+	}
+}
+
+func TestNoAltScreenFlag(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	os.Args = []string{"ralph", "--no-alt-screen"}
+	if cfg := config.ParseFlags(); !cfg.NoAltScreen {
+		t.Error("expected NoAltScreen=true with --no-alt-screen")
+	}
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	os.Args = []string{"ralph"}
+	if cfg := config.ParseFlags(); cfg.NoAltScreen {
+		t.Error("expected NoAltScreen=false by default")
+	}
+}
+
+func TestFormatContextWarning(t *testing.T) {
+	tests := []struct {
+		warning  parser.ContextWarning
+		expected string
+	}{
+		{parser.ContextWarning{PercentLeft: -1}, "Context nearly full"},
+		{parser.ContextWarning{UsedTokens: 190000, LimitTokens: 200000, PercentLeft: -1}, "Context nearly full (190k/200k tokens)"},
+		{parser.ContextWarning{PercentLeft: 5}, "Context nearly full (5% left)"},
+		{parser.ContextWarning{UsedTokens: 190000, LimitTokens: 200000, PercentLeft: 5}, "Context nearly full (190k/200k tokens, 5% left)"},
+	}
+	for _, tt := range tests {
+		if got := formatContextWarning(&tt.warning); got != tt.expected {
+			t.Errorf("formatContextWarning(%+v) = %q, want %q", tt.warning, got, tt.expected)
+		}
+	}
+}
+
+// captureStdout runs fn and returns everything it printed to stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	orig := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = orig }()
+
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestRunCLI_ZeroIterations(t *testing.T) {
+	oldArgs := os.Args
+	oldCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldCommandLine
+	}()
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	os.Args = []string{"ralph", "--cli", "--iterations", "0"}
+	t.Chdir(t.TempDir()) // the run state is written to the working directory
+
+	cfg := config.ParseFlags()
+	cfg.SpecFolder = "" // skip spec folder validation
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected --cli --iterations 0 to validate, got %v", err)
+	}
+
+	var exitCode int
+	done := make(chan struct{})
+	out := captureStdout(t, func() {
+		go func() {
+			exitCode = runCLI(cfg, "prompt", "", stats.NewTokenStats(), nil, nil, nil, nil)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("runCLI did not exit for 0 iterations")
+		}
+	})
+
+	if exitCode != 0 {
+		t.Errorf("expected exit code 0, got %d", exitCode)
+	}
+	if !strings.Contains(out, "nothing to do (0 iterations)") {
+		t.Errorf("expected nothing-to-do message, got:\n%s", out)
+	}
+	if !strings.Contains(out, "[summary] 0 iterations in 00:00:00, 0 tokens, $0.000000") {
+		t.Errorf("expected zero summary, got:\n%s", out)
+	}
+}
+
+func TestRunCLI_JSONOutput(t *testing.T) {
+	oldArgs := os.Args
+	oldCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldCommandLine
+	}()
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	os.Args = []string{"ralph", "--cli", "--iterations", "0", "--log-format", "json"}
+	t.Chdir(t.TempDir())
+
+	cfg := config.ParseFlags()
+	cfg.SpecFolder = ""
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	out := captureStdout(t, func() {
+		go func() {
+			runCLI(cfg, "prompt", "", stats.NewTokenStats(), nil, nil, nil, nil)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("runCLI did not exit for 0 iterations")
+		}
+	})
+
+	var types []string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var e render.Event
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("Expected only JSON lines on stdout, got %q: %v", line, err)
+		}
+		types = append(types, e.Type)
+		if e.Type == "summary" && strings.HasPrefix(e.Content, "[summary]") {
+			t.Errorf("Expected the summary tag dropped from JSON content, got %q", e.Content)
+		}
+	}
+	if len(types) == 0 || types[0] != "start" || types[len(types)-1] != "summary" {
+		t.Errorf("Expected start and summary events, got %v", types)
+	}
+}
+
+func TestCLISummary(t *testing.T) {
+	start := stats.NewTokenStats()
+	start.AddUsage(1000, 500, 0, 0)
+	start.AddCost(0.25)
+	startSnap := start.Snapshot()
+
+	start.AddUsage(1500, 500, 0, 0)
+	start.AddCost(0.5)
+
+	got := cliSummary(1, 90*time.Second, startSnap, start.Snapshot())
+	want := "[summary] 1 iteration in 00:01:30, 2k tokens, $0.500000 ($0.250000/1k tokens)"
+	if got != want {
+		t.Errorf("cliSummary() = %q, want %q", got, want)
+	}
+}
+
+func TestCLISummary_EfficiencyTrend(t *testing.T) {
+	s := stats.NewTokenStats()
+	startSnap := s.Snapshot()
+	for _, cost := range []float64{0.01, 0.01, 0.03} {
+		s.AddUsage(1000, 0, 0, 0)
+		s.ReconcileCost(0, cost)
+	}
+
+	got := cliSummary(3, time.Minute, startSnap, s.Snapshot())
+	if !strings.HasSuffix(got, "($0.016667/1k tokens, rising)") {
+		t.Errorf("Expected the run's cost per 1k tokens and its trend in the summary, got %q", got)
+	}
+}
+
+func TestCLISummary_CacheHitRatio(t *testing.T) {
+	s := stats.NewTokenStats()
+	s.AddUsage(0, 0, 1000, 1000) // before this run: 50%
+	startSnap := s.Snapshot()
+	s.AddUsage(100, 100, 100, 900)
+
+	got := cliSummary(1, time.Minute, startSnap, s.Snapshot())
+	if !strings.HasSuffix(got, ", 90% cache hit") {
+		t.Errorf("Expected the run's own cache-hit ratio in the summary, got %q", got)
+	}
+}
+
+func TestCLISummary_Hibernations(t *testing.T) {
+	s := stats.NewTokenStats()
+	s.AddHibernation(time.Hour) // an earlier run
+	startSnap := s.Snapshot()
+
+	if got := cliSummary(1, time.Minute, startSnap, s.Snapshot()); strings.Contains(got, "hibernated") {
+		t.Errorf("Expected no hibernation note for a run without any, got %q", got)
+	}
+
+	s.AddHibernation(20 * time.Minute)
+	s.AddHibernation(27 * time.Minute)
+	got := cliSummary(1, time.Hour, startSnap, s.Snapshot())
+	if !strings.HasSuffix(got, ", hibernated 2 times for 00:47:00 total") {
+		t.Errorf("Expected the run's own hibernations in the summary, got %q", got)
+	}
+	if got := hibernationSummary(1, 90*time.Second); got != "hibernated 1 time for 00:01:30 total" {
+		t.Errorf("hibernationSummary() = %q", got)
+	}
+}
+
+func TestCLICommitLines(t *testing.T) {
+	if lines := cliCommitLines(nil); lines != nil {
+		t.Errorf("Expected no lines without commits, got %q", lines)
+	}
+
+	commits := []vcs.Commit{{Hash: "abc1234", Subject: "Add the parser"}, {Hash: "def5678", Subject: "Fix the build"}}
+	got := strings.Join(cliCommitLines(commits), "\n")
+	want := "[summary] 2 commits this run:\n  abc1234 Add the parser\n  def5678 Fix the build"
+	if got != want {
+		t.Errorf("cliCommitLines() = %q, want %q", got, want)
+	}
+	if got := cliCommitLines(commits[:1])[0]; got != "[summary] 1 commit this run:" {
+		t.Errorf("Expected a singular header, got %q", got)
+	}
+	if got := commitSummary(commits); got != "abc1234 Add the parser\ndef5678 Fix the build" {
+		t.Errorf("commitSummary() = %q", got)
+	}
+}
+
+func TestRunCommitsOutsideRepo(t *testing.T) {
+	var nilCtx *dbContext
+	if commits := nilCtx.runCommits(); commits != nil {
+		t.Errorf("Expected no commits without a run context, got %v", commits)
+	}
+	if commits := (&dbContext{}).runCommits(); commits != nil {
+		t.Errorf("Expected no commits outside a repository, got %v", commits)
+	}
+}
+
+func TestScaffoldProject_CreatesStarterFiles(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewConfig()
+
+	var out strings.Builder
+	if err := scaffoldProject(dir, cfg, &out); err != nil {
+		t.Fatalf("scaffoldProject: %v", err)
+	}
+
+	for _, path := range []string{
+		filepath.Join(dir, "specs", "spec.md"),
+		filepath.Join(dir, config.DefaultPlanFile),
+		filepath.Join(dir, config.RCFile),
+	} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Errorf("expected %s to be created: %v", path, err)
+			continue
+		}
+		if len(data) == 0 {
+			t.Errorf("expected %s to have content", path)
+		}
+	}
+
+	completed, total := parseTaskCounts(filepath.Join(dir, config.DefaultPlanFile))
+	if completed != 0 || total == 0 {
+		t.Errorf("expected starter plan with open tasks, got %d/%d", completed, total)
+	}
+	args, err := config.ReadRCArgs(filepath.Join(dir, config.RCFile))
+	if err != nil || len(args) == 0 {
+		t.Errorf("expected starter .ralphrc with default flags, got %v (err %v)", args, err)
+	}
+	for _, a := range args {
+		// An --iterations in .ralphrc counts as explicit and would override plan mode's default of 1
+		if a == "--iterations" || strings.HasPrefix(a, "--iterations=") {
+			t.Errorf("expected starter .ralphrc not to set --iterations, got %v", args)
+		}
+	}
+	if !strings.Contains(out.String(), "Next steps") {
+		t.Errorf("expected next steps in output, got:\n%s", out.String())
+	}
+}
+
+func TestScaffoldProject_DoesNotOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewConfig()
+	planPath := filepath.Join(dir, config.DefaultPlanFile)
+	if err := os.WriteFile(planPath, []byte("my plan"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := scaffoldProject(dir, cfg, &out); err != nil {
+		t.Fatalf("scaffoldProject: %v", err)
+	}
+
+	data, _ := os.ReadFile(planPath)
+	if string(data) != "my plan" {
+		t.Errorf("expected existing plan to be kept, got %q", data)
+	}
+	if !strings.Contains(out.String(), "Skipped "+planPath) {
+		t.Errorf("expected a skip warning for the existing plan, got:\n%s", out.String())
+	}
+}
+
+func TestPrintParseSummary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	capture := `{"type":"system","subtype":"init","session_id":"s1"}
+{"type":"assistant","message":{"id":"m1","content":[{"type":"tool_use","id":"t1","name":"Edit","input":{}}],"usage":{"input_tokens":12,"output_tokens":3}}}
+not json
+{"type":"result","total_cost_usd":0.5}
+`
+	if err := os.WriteFile(path, []byte(capture), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := printParseSummary(path, &out); err != nil {
+		t.Fatalf("printParseSummary: %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{"4 lines, 1 failed", "assistant:", "Edit:", "Input tokens:        12", "line 3: not json"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected %q in output, got:\n%s", want, got)
+		}
+	}
+
+	if err := printParseSummary("", &out); err == nil || !strings.Contains(err.Error(), "--file") {
+		t.Errorf("Expected a usage error without a file, got %v", err)
+	}
+}
+
+func TestPrintStatsTotals_MissingDB(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.StatsCommand = "total"
+	dbPath := filepath.Join(t.TempDir(), "ralph.db")
+
+	var out strings.Builder
+	if err := printStatsTotals(cfg, dbPath, &out); err != nil {
+		t.Fatalf("printStatsTotals: %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{"all time", "Iterations:", "Total cost:", "no stats database"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected %q in output, got:\n%s", want, got)
+		}
+	}
+	if _, err := os.Stat(dbPath); err == nil {
+		t.Error("Reporting on a missing DB should not create it")
+	}
+}
+
+func TestPrintStatsTotals_JSON(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "ralph.db")
+	db, err := stats.InitDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats.WriteLoopStats(db, stats.LoopStatsParams{
+		LoopID: "x-1", SessionID: "abcdef", TotalCost: 0.5, TotalTokens: 42,
+		StartTime: time.Now().Format(time.RFC3339),
+	})
+	db.Close()
+
+	cfg := config.NewConfig()
+	cfg.StatsCommand = "total"
+	cfg.StatsSince = "7d"
+	cfg.StatsJSON = true
+
+	var out strings.Builder
+	if err := printStatsTotals(cfg, dbPath, &out); err != nil {
+		t.Fatalf("printStatsTotals: %v", err)
+	}
+	var report struct {
+		Since       string  `json:"since"`
+		Loops       int     `json:"loops"`
+		TotalCost   float64 `json:"total_cost"`
+		TotalTokens int64   `json:"total_tokens"`
+	}
+	if err := json.Unmarshal([]byte(out.String()), &report); err != nil {
+		t.Fatalf("Expected JSON output, got %q: %v", out.String(), err)
+	}
+	if report.Since != "7d" || report.Loops != 1 || report.TotalCost != 0.5 || report.TotalTokens != 42 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+
+	cfg.StatsCommand = "bogus"
+	if err := printStatsTotals(cfg, dbPath, &out); err == nil {
+		t.Error("Expected an error for an unknown stats report")
+	}
+}
+
+func TestPrintStats_Breakdown(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "ralph.db")
+	cfg := config.NewConfig()
+	cfg.StatsCommand = "loops"
+
+	var out strings.Builder
+	if err := printStats(cfg, dbPath, &out); err != nil {
+		t.Fatalf("printStats on a missing DB: %v", err)
+	}
+	if got := out.String(); !strings.Contains(got, "nothing recorded") || !strings.Contains(got, "no stats database") {
+		t.Errorf("Expected an empty report with a note, got:\n%s", got)
+	}
+
+	db, err := stats.InitDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats.FlushCheckpoint(db, stats.CheckpointParams{
+		LoopID: "abc-2", SessionID: "abc", Iteration: 2, DeltaCost: 0.25, DeltaOutputTokens: 7,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	})
+	db.Close()
+
+	out.Reset()
+	if err := printStats(cfg, dbPath, &out); err != nil {
+		t.Fatalf("printStats: %v", err)
+	}
+	if got := out.String(); !strings.Contains(got, "cost by loop") || !strings.Contains(got, "abc-2") {
+		t.Errorf("Expected the loop in the report, got:\n%s", got)
+	}
+
+	cfg.StatsCommand = "daily"
+	cfg.StatsJSON = true
+	out.Reset()
+	if err := printStats(cfg, dbPath, &out); err != nil {
+		t.Fatalf("printStats --json: %v", err)
+	}
+	var report struct {
+		By      string             `json:"by"`
+		Buckets []stats.CostBucket `json:"buckets"`
+	}
+	if err := json.Unmarshal([]byte(out.String()), &report); err != nil {
+		t.Fatalf("Expected JSON output, got %q: %v", out.String(), err)
+	}
+	if report.By != stats.CostByDay || len(report.Buckets) != 1 || report.Buckets[0].Cost != 0.25 || report.Buckets[0].Tokens != 7 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+}
+
+func TestCLIStatusRight(t *testing.T) {
+	got := cliStatusRight("ralph", "main", 2, 5, 0, 0, 0.125, 90*time.Second)
+	want := "[ralph | main | loop: 2/5, cost: " + stats.FormatCost(0.125) + ", uptime: 00:01:30]"
+	if got != want {
+		t.Errorf("cliStatusRight() = %q, want %q", got, want)
+	}
+
+	got = cliStatusRight("ralph", "main", 2, 5, 8, 14, 0.125, 90*time.Second)
+	want = "[ralph | main | loop: 2/5, cost: " + stats.FormatCost(0.125) + ", tasks: 8/14 (57%), uptime: 00:01:30]"
+	if got != want {
+		t.Errorf("cliStatusRight() = %q, want %q", got, want)
+	}
+}
+
+func TestCLIProgress(t *testing.T) {
+	var buf bytes.Buffer
+	tokenStats := stats.NewTokenStats()
+	tokenStats.AddUsage(1000, 0, 0, 0) // before this run
+	p := &cliProgress{w: progress.NewWriter(&buf), startTokens: tokenStats.TotalTokens()}
+	l := loop.New(loop.Config{Iterations: 4})
+	jsonParser := parser.NewParser()
+
+	p.current = 2
+	p.observe(jsonParser, jsonParser.ParseLine(`{"type":"assistant","message":{"content":[{"type":"text","text":"Working on TASK 6: add the flag"}]}}`))
+	tokenStats.AddUsage(300, 200, 0, 0)
+	tokenStats.AddCost(0.5)
+	p.report(l, 4, tokenStats, "")
+	p.report(l, 4, tokenStats, finalStatus(1))
+
+	want := "RALPH_PROGRESS loop=2/4 cost=0.5000 tokens=500 status=running task=#6\n" +
+		"RALPH_PROGRESS loop=2/4 cost=0.5000 tokens=500 status=failed task=#6\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Unexpected progress lines:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Without --progress-to nothing is written and nothing panics
+	off, err := newCLIProgress("", tokenStats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	off.observe(jsonParser, jsonParser.ParseLine(`{"type":"assistant","message":{"content":[{"type":"text","text":"TASK 1"}]}}`))
+	off.report(l, 4, tokenStats, "")
+	off.close()
+}
+
+func TestCLIStatusBar_NoopOutsideTmux(t *testing.T) {
+	t.Setenv("TMUX", "")
+	bar := newCLIStatusBar(stats.NewTokenStats(), "")
+	if bar.bar.IsActive() {
+		t.Fatal("Expected an inactive status bar outside tmux")
+	}
+	// Must not panic or shell out when inactive
+	bar.update(3, stats.NewTokenStats())
+	bar.restore()
+}
+
+func TestStartStatsFlusher(t *testing.T) {
+	db, err := stats.InitDB(filepath.Join(t.TempDir(), "ralph.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	dbCtx := &dbContext{db: db, owner: "o", repo: "r"}
+	key := stats.ProjectKey("o", "r")
+
+	tokenStats := stats.NewTokenStats()
+	stop := startStatsFlusher(dbCtx, tokenStats, 10*time.Millisecond)
+	defer stop()
+
+	tokenStats.AddUsage(100, 20, 0, 0)
+	tokenStats.AddCost(0.5)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		saved, err := stats.LoadProjectStats(db, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.Snapshot().InputTokens == 100 && saved.Snapshot().TotalCostUSD == 0.5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected stats to be flushed periodically, got %+v", saved.Snapshot())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// With nothing changed, the flusher must not rewrite the row.
+	if _, err := db.Exec(`DELETE FROM project_stats`); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	stop()
+	saved, err := stats.LoadProjectStats(db, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Snapshot().InputTokens != 0 {
+		t.Error("Expected unchanged stats to skip the periodic write")
+	}
+}
+
+func TestStartStatsFlusher_Disabled(t *testing.T) {
+	// Zero interval or no DB: stop is a harmless no-op
+	startStatsFlusher(&dbContext{}, stats.NewTokenStats(), time.Second)()
+	startStatsFlusher(nil, stats.NewTokenStats(), 0)()
+}
+
+func TestConfirmInput(t *testing.T) {
+	if confirmInput(false, strings.NewReader("go\n")) != nil {
+		t.Error("Expected no input channel when step mode is off")
+	}
+
+	lines := confirmInput(true, strings.NewReader("\nfix the tests first\n"))
+	var got []string
+	for line := range lines {
+		got = append(got, line)
+	}
+	if len(got) != 2 || got[0] != "" || got[1] != "fix the tests first" {
+		t.Errorf("Expected an empty line then the nudge, got %q", got)
+	}
+}
+
+func TestConfirmLoop(t *testing.T) {
+	// cat echoes the prompt written to stdin, nudge included
+	l := loop.New(loop.Config{
+		Iterations:      2,
+		Prompt:          "PROMPT",
+		SleepDuration:   time.Millisecond,
+		ConfirmEachLoop: true,
+		CommandBuilder: func(ctx context.Context, prompt string) *exec.Cmd {
+			return exec.CommandContext(ctx, "cat")
+		},
+	})
+	if confirmLoop(l, "") {
+		t.Error("Expected input to be ignored while the loop is not waiting")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+	var output []string
+	for msg := range l.Output() {
+		switch msg.Type {
+		case "loop_marker":
+			if isConfirmWait(msg.Content) {
+				if isNewLoopStart(msg.Content) {
+					t.Errorf("Confirmation marker %q must not count as a loop start", msg.Content)
+				}
+				if !confirmLoop(l, "  check the plan  ") {
+					t.Error("Expected confirmLoop to resume a waiting loop")
+				}
+			}
+		case "output":
+			output = append(output, msg.Content)
+		case "complete":
+			cancel()
+		}
+	}
+
+	want := []string{"PROMPT", "check the plan", "", "PROMPT"}
+	if strings.Join(output, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected the typed nudge ahead of the second prompt, got %q", output)
+	}
+}
+
+func TestPrepareResume(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	os.Args = []string{"ralph", "--cli"}
+	if state, _, err := prepareResume(path); state != nil || err != nil {
+		t.Fatalf("Expected no resume without the subcommand, got %v, %v", state, err)
+	}
+
+	os.Args = []string{"ralph", "resume"}
+	if _, _, err := prepareResume(path); err == nil || !strings.Contains(err.Error(), "nothing to resume") {
+		t.Errorf("Expected an error without a run state, got %v", err)
+	}
+
+	if err := loop.SaveRunState(path, &loop.RunState{Args: []string{"plan", "--iterations", "3"}, Iteration: 1, Total: 3}); err != nil {
+		t.Fatal(err)
+	}
+	os.Args = []string{"ralph", "resume", "--cli"}
+	state, flags, err := prepareResume(path)
+	if err != nil || state == nil || state.Iteration != 1 {
+		t.Fatalf("Expected the saved state, got %+v, %v", state, err)
+	}
+	if got := strings.Join(os.Args, " "); got != "ralph plan --iterations 3 --cli" {
+		t.Errorf("Expected the saved command line followed by resume's flags, got %q", got)
+	}
+	if len(flags) != 1 || flags[0] != "--cli" {
+		t.Errorf("Expected resume's own flags, got %q", flags)
+	}
+
+	if err := loop.SaveRunState(path, &loop.RunState{Args: []string{"--cli"}, Iteration: 3, Total: 3, Complete: true}); err != nil {
+		t.Fatal(err)
+	}
+	os.Args = []string{"ralph", "resume"}
+	if _, _, err := prepareResume(path); err == nil || !strings.Contains(err.Error(), "completed") {
+		t.Errorf("Expected a completed run to have nothing to resume, got %v", err)
+	}
+}
+
+func TestFlagGiven(t *testing.T) {
+	args := []string{"--cli", "-iterations=4", "plan"}
+	if !flagGiven(args, "iterations") || !flagGiven(args, "cli") {
+		t.Error("Expected --cli and -iterations=4 to be found")
+	}
+	if flagGiven(args, "plan") || flagGiven(args, "iter") {
+		t.Error("Expected only flags with the exact name to match")
+	}
+}
+
+func TestParallelArgs(t *testing.T) {
+	saved := os.Args
+	defer func() { os.Args = saved }()
+	os.Args = []string{"ralph", "--cli", "--parallel", "3"}
+	cfg := &config.Config{Subcommand: "build", RunID: "abcdef12-run"}
+
+	got := strings.Join(parallelArgs(cfg, 2), " ")
+	if want := "build --cli --parallel 3 --parallel 0 --no-tmux --listen= --listen-token= --run-id abcdef12-run-2"; got != want {
+		t.Errorf("parallelArgs = %q, want %q", got, want)
+	}
+
+	// Loops read the run's config file from the main checkout
+	cfg.ConfigFile = "/work/ralph.toml"
+	if got := strings.Join(parallelArgs(cfg, 1), " "); !strings.HasSuffix(got, "--run-id abcdef12-run-1 --config /work/ralph.toml") {
+		t.Errorf("Expected the config file to be passed on, got %q", got)
+	}
+
+	// Loops cover the specs picked with --spec-select rather than each asking
+	cfg.Specs = []string{"auth.md", "api/users.md"}
+	if got := strings.Join(parallelArgs(cfg, 1), " "); !strings.HasSuffix(got, "--config /work/ralph.toml --spec-select=false --specs auth.md,api/users.md") {
+		t.Errorf("Expected the chosen specs to be passed on, got %q", got)
+	}
+}
+
+func TestWorkerEvent(t *testing.T) {
+	e := workerEvent(2, "[assistant] hello", false)
+	if e.Worker != 2 || e.Text != "[loop 2] [assistant] hello" {
+		t.Errorf("Unexpected text worker event: %+v", e)
+	}
+
+	e = workerEvent(3, `{"time":"2026-01-01T00:00:00Z","type":"tool","iteration":4,"content":"Read main.go","tool":"Read"}`, true)
+	if e.Worker != 3 || e.Type != "tool" || e.Iteration != 4 || e.Tool != "Read" || e.Time.IsZero() {
+		t.Errorf("Expected the loop's own JSON event tagged with its worker, got %+v", e)
+	}
+
+	e = workerEvent(1, "panic: boom", true)
+	if e.Worker != 1 || e.Type != "output" || e.Content != "panic: boom" {
+		t.Errorf("Expected a non-JSON line wrapped in an output event, got %+v", e)
+	}
+}
+
+func TestThrottleWait(t *testing.T) {
+	now := time.Date(2026, 3, 4, 14, 0, 0, 0, time.Local)
+	b := loop.NewBackoffWithOptions(loop.WithMaxRetries(1))
+
+	// A reset time from the error wins over backoff
+	reset := now.Add(90 * time.Minute)
+	until, content, exceeded := throttleWait(&parser.Throttle{ResetsAt: reset}, b, now)
+	if exceeded || !until.Equal(reset) || content != "Rate limited until 3:30PM" {
+		t.Errorf("Expected to wait for the reset, got %v %q %v", until, content, exceeded)
+	}
+
+	until, content, exceeded = throttleWait(&parser.Throttle{Overloaded: true}, b, now)
+	if exceeded || !until.After(now) || !strings.HasPrefix(content, "API overloaded, retry 1/1, hibernating") {
+		t.Errorf("Expected the first backoff step, got %v %q %v", until, content, exceeded)
+	}
+
+	_, content, exceeded = throttleWait(&parser.Throttle{}, b, now)
+	if !exceeded || content != "Rate limited: max retries (1) exceeded, stopping loop" {
+		t.Errorf("Expected retries to run out, got %q %v", content, exceeded)
+	}
+}
+
+func TestTranscripts(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.RunID = "run-1"
+	cfg.TranscriptMaxMB = 2
+
+	tr := transcripts(cfg, "build")
+	if tr.Dir != loop.DefaultTranscriptDir || tr.RunID != "run-1-build" {
+		t.Errorf("Expected the build phase's directory under %s, got %s/%s", loop.DefaultTranscriptDir, tr.Dir, tr.RunID)
+	}
+	if tr.MaxFiles != config.DefaultTranscriptMaxFiles || tr.MaxBytes != 2<<20 {
+		t.Errorf("Expected the retention limits from the flags, got %d files and %d bytes", tr.MaxFiles, tr.MaxBytes)
+	}
+	if tr := transcripts(cfg, ""); tr.RunID != "run-1" {
+		t.Errorf("Expected the run's own directory outside plan-and-build, got %s", tr.RunID)
+	}
+
+	cfg.NoTranscripts = true
+	if tr := transcripts(cfg, ""); tr.Dir != "" {
+		t.Errorf("Expected --no-transcripts to turn recording off, got %+v", tr)
+	}
+}
+
+func TestReplayDelay(t *testing.T) {
+	if d := replayDelay(1); d != loop.DefaultReplayDelay {
+		t.Errorf("Expected the default pace at speed 1, got %s", d)
+	}
+	if d := replayDelay(4); d != loop.DefaultReplayDelay/4 {
+		t.Errorf("Expected a quarter of the default pace at speed 4, got %s", d)
+	}
+	if d := replayDelay(0); d != 0 {
+		t.Errorf("Expected no delay at speed 0, got %s", d)
+	}
+}
+
+func TestSelectSpecs(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "auth.md"), []byte("auth"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.NewConfig()
+	cfg.SpecFolder = dir
+	cfg.Specs = []string{"billing.md"}
+	if _, err := selectSpecs(cfg); err == nil || !strings.Contains(err.Error(), `"billing.md"`) {
+		t.Errorf("Expected a --specs file missing from the folder to be an error, got %v", err)
+	}
+	cfg.Specs = []string{"auth.md"}
+	if ok, err := selectSpecs(cfg); !ok || err != nil {
+		t.Errorf("Expected a --specs file in the folder to be accepted, got %v, %v", ok, err)
+	}
+
+	// With a single spec there is nothing to pick, so no picker is shown
+	cfg.Specs, cfg.SpecSelect = nil, true
+	if ok, err := selectSpecs(cfg); !ok || err != nil || len(cfg.Specs) != 0 {
+		t.Errorf("Expected a single spec to need no picking, got %v, %v, %q", ok, err, cfg.Specs)
+	}
+}
+
+func TestPromptRenderer(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Goal = "ship it"
+	cfg.Vars = map[string]string{"team": "payments"}
+	render := promptRenderer(cfg, &dbContext{branch: "main"})
+	got, err := render("{{.SpecPath}} {{.Goal}} {{.Branch}} {{.team}} {{.Iteration}}/{{.TotalIterations}}", 3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := config.DefaultSpecFolder + " ship it main payments 3/4"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	// Without --var or --prompt-template, {{ is sent as written
+	cfg.Vars = nil
+	plain := "Go templates look like {{.Name}}"
+	if got, err := promptRenderer(cfg, nil)(plain, 1, 1); err != nil || got != plain {
+		t.Errorf("Expected the prompt unchanged, got %q, %v", got, err)
+	}
+	cfg.PromptTemplate = true
+	if _, err := promptRenderer(cfg, nil)(plain, 1, 1); err == nil {
+		t.Error("Expected --prompt-template to run the prompt as a template")
+	}
+}
+
+// TestResumeSpecQueue tests that `ralph resume` of an --iterations-per-spec
+// run starts on the spec the queue was on, and that the prompt names it
+func TestResumeSpecQueue(t *testing.T) {
+	t.Chdir(t.TempDir()) // the queue state is written to the working directory
+	if err := os.MkdirAll("specs", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.md", "b.md", "c.md"} {
+		if err := os.WriteFile(filepath.Join("specs", name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := config.NewConfig()
+	cfg.SpecFolder = "specs"
+	cfg.IterationsPerSpec = 2
+	if err := queueSpecs(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(cfg.Specs, ",") != "a.md,b.md,c.md" || cfg.Iterations != 6 {
+		t.Fatalf("Expected all 3 specs queued for 6 iterations, got %q for %d", cfg.Specs, cfg.Iterations)
+	}
+
+	// The run was interrupted after 5 iterations, on c.md
+	queue := specQueue(cfg)
+	if err := loop.SaveQueueState(queue.Path, queue.State(5)); err != nil {
+		t.Fatal(err)
+	}
+	lc := withRunState(loop.Config{}, cfg, stats.NewTokenStats(), &loop.RunState{Iteration: 5, Total: 6})
+	if lc.FirstIteration != 6 {
+		t.Errorf("Expected the resumed run to start at iteration 6, got %d", lc.FirstIteration)
+	}
+	got, err := promptRenderer(cfg, nil)("work", lc.FirstIteration, cfg.Iterations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "specs/c.md") || strings.Contains(got, "specs/a.md") {
+		t.Errorf("Expected the prompt to name only c.md, got %q", got)
+	}
+
+	// Resumed with 3 iterations per spec, it is still c.md's second iteration
+	cfg.IterationsPerSpec = 3
+	if lc := withRunState(loop.Config{}, cfg, stats.NewTokenStats(), &loop.RunState{Iteration: 5, Total: 6}); lc.FirstIteration != 8 {
+		t.Errorf("Expected the resumed run to start at iteration 8, got %d", lc.FirstIteration)
+	}
+
+	// A changed queue is not resumed from its saved position
+	cfg.IterationsPerSpec, cfg.Specs = 2, []string{"b.md", "c.md"}
+	if lc := withRunState(loop.Config{}, cfg, stats.NewTokenStats(), &loop.RunState{Iteration: 5, Total: 6}); lc.FirstIteration != 6 {
+		t.Errorf("Expected a changed queue to resume at the next iteration, got %d", lc.FirstIteration)
+	}
+}
+
+func TestToTUISubagents(t *testing.T) {
+	items := toTUISubagents([]parser.Subagent{
+		{Description: "Explore", Depth: 1, Status: parser.SubagentRunning, Model: "claude-sonnet-4-5", Usage: parser.Usage{InputTokens: 1000, OutputTokens: 500}},
+		{Type: "Plan", Depth: 2, Status: parser.SubagentCompleted, CostUSD: 0.3, Usage: parser.Usage{InputTokens: 10, CacheReadInputTokens: 90}},
+	})
+	if len(items) != 2 {
+		t.Fatalf("Expected 2 items, got %+v", items)
+	}
+	if items[0].Label != "Explore" || items[0].Tokens != 1500 || !items[0].Estimated || items[0].CostUSD <= 0 {
+		t.Errorf("Expected an estimated cost for a subagent without a reported one, got %+v", items[0])
+	}
+	if items[1].Label != "Plan" || items[1].Depth != 2 || items[1].Tokens != 100 || items[1].Estimated || items[1].CostUSD != 0.3 {
+		t.Errorf("Expected the reported cost kept, got %+v", items[1])
+	}
+}
+
+// TestShutdownSignals tests that a first SIGTERM drains and a second aborts.
+func TestShutdownSignals(t *testing.T) {
+	drained := make(chan struct{}, 2)
+	aborted := make(chan struct{}, 2)
+	stop := shutdownSignals(func() { drained <- struct{}{} }, func() { aborted <- struct{}{} })
+	defer stop()
+
+	wait := func(ch chan struct{}, what string) {
+		t.Helper()
+		select {
+		case <-ch:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected %s", what)
+		}
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	wait(drained, "the first SIGTERM to drain")
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	wait(aborted, "the second SIGTERM to abort")
+	if len(drained) != 0 {
+		t.Error("Expected the second SIGTERM not to drain again")
+	}
+}
+
+// TestCLITargetSet tests that a loop set after a drain is drained at once.
+func TestCLITargetSet(t *testing.T) {
+	var target cliTarget
+	target.drain()
+	l := loop.New(loop.Config{Iterations: 1, Prompt: "test"})
+	target.set(l, nil)
+	if !l.IsDraining() {
+		t.Error("Expected a loop set after drain to be draining")
+	}
+}
+
+// TestHeadlessSignals tests that SIGHUP reloads the prompt for the next
+// iteration and SIGUSR2 resumes a waiting loop.
+func TestHeadlessSignals(t *testing.T) {
+	var mu sync.Mutex
+	var prompts []string
+	l := loop.New(loop.Config{
+		Iterations:      2,
+		Prompt:          "old prompt",
+		ConfirmEachLoop: true,
+		SleepDuration:   time.Millisecond,
+		CommandBuilder: func(ctx context.Context, prompt string) *exec.Cmd {
+			mu.Lock()
+			prompts = append(prompts, prompt)
+			mu.Unlock()
+			return exec.CommandContext(ctx, "true")
+		},
+	})
+	reloaded := make(chan struct{}, 1)
+	target := &cliTarget{}
+	target.set(l, func() (string, error) {
+		reloaded <- struct{}{}
+		return "new prompt", nil
+	})
+	stop := headlessSignals(target, nil, render.New("text", io.Discard, io.Discard))
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+	for msg := range l.Output() {
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, loop.ConfirmMarker) {
+			break
+		}
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reloaded:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected SIGHUP to reload the prompt")
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+	for msg := range l.Output() {
+		if msg.Type == "complete" {
+			break
+		}
+	}
+	l.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(prompts) != 2 || prompts[0] != "old prompt" || prompts[1] != "new prompt" {
+		t.Errorf("Expected the reloaded prompt from iteration 2, got %q", prompts)
+	}
+}
+
+// TestInstallService tests that `ralph service install` writes a unit that
+// runs the rest of the command line headless from the current directory.
+func TestInstallService(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	if err := os.MkdirAll(filepath.Join(dir, "specs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "specs", "spec.md"), []byte("spec"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	os.Args = []string{"ralph", "service", "install", "build", "--iterations", "3"}
+	installing, err := prepareService()
+	if err != nil || !installing {
+		t.Fatalf("Expected a service install, got %v, %v", installing, err)
+	}
+	cfg := config.ParseFlags()
+	var out bytes.Buffer
+	if err := installService(cfg, "linux", &out); err != nil {
+		t.Fatal(err)
+	}
+
+	name := "ralph-" + filepath.Base(dir)
+	unit, err := os.ReadFile(filepath.Join(dir, "config", "systemd", "user", name+".service"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(unit), " build --iterations 3 --cli\n") {
+		t.Errorf("Expected the unit to run the command line with --cli, got:\n%s", unit)
+	}
+	if !strings.Contains(string(unit), "WorkingDirectory="+dir+"\n") {
+		t.Errorf("Expected the unit to run in %s, got:\n%s", dir, unit)
+	}
+	if !strings.Contains(out.String(), "systemctl --user enable --now "+name) {
+		t.Errorf("Expected how to start the service, got:\n%s", out.String())
+	}
+
+	cfg.Subcommand = "stats"
+	if err := installService(cfg, "linux", &out); err == nil {
+		t.Error("Expected installing ralph stats as a service to fail")
+	}
+	os.Args = []string{"ralph", "service", "start"}
+	if _, err := prepareService(); err == nil {
+		t.Error("Expected an unknown service command to fail")
+	}
+}
@@ -3,59 +3,203 @@ package config
 import (
 	"flag"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudosai/ralph-go/internal/control"
+	"github.com/cloudosai/ralph-go/internal/loop"
+	"github.com/cloudosai/ralph-go/internal/prompt"
+	"github.com/cloudosai/ralph-go/internal/stats"
+	"github.com/google/uuid"
 )
 
 // Default values for configuration
 const (
-	DefaultIterations     = 5
-	DefaultPlanIterations = 1
-	DefaultSpecFolder     = "specs/"
+	DefaultIterations         = 5
+	DefaultPlanIterations     = 1
+	DefaultSpecFolder         = "specs/"
+	DefaultTUILayout          = "bottom"
+	DefaultLogFormat          = "text"
+	DefaultStreamFormat       = "jsonl"
+	DefaultAgent              = "claude"
+	DefaultThrashAction       = "warn"
+	DefaultCostSymbol         = "$"
+	DefaultCostDecimals       = 6
+	MaxCostDecimals           = 10
+	DefaultMaxToolResultBytes = 16384
+	DefaultStatsInterval      = 30 * time.Second
+	DefaultHookTimeout        = 10 * time.Minute
+	DefaultMaxRetries         = 8                // matches loop.DefaultMaxRetries
+	DefaultRetryBackoff       = 10 * time.Second // matches loop.DefaultRetryBackoff
+	DefaultTranscriptMaxFiles = 1000
+	DefaultTranscriptMaxMB    = 500
+	MinContentWidth           = 40               // narrowest --max-content-width the TUI can lay out
 )
 
 // Version is set at build time via -ldflags
 var Version = "v2026.6.14"
 
+// runIDPattern limits --run-id to characters that are safe in file names and
+// tmux session names.
+var runIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// varNamePattern matches a --var name, usable in a prompt template as {{.name}}.
+var varNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 // DefaultPlanFile is the default implementation plan filename
 const DefaultPlanFile = "IMPLEMENTATION_PLAN.md"
 
+// AutoBranch is the --branch value that names the run's branch for its start
+// time, e.g. ralph/20261016-150405.
+const AutoBranch = "auto"
+
+// RCFile is the per-project file of default flags, read from the working
+// directory by ParseFlags and created by `ralph init`.
+const RCFile = ".ralphrc"
+
 // Config holds the configuration for the ralph-go application
 type Config struct {
 	Iterations       int
 	BuildIterations  int // iterations for build phase in plan-and-build mode
+	DefaultIterationsBy map[string]int // --default-iterations overrides by mode: "plan", "build", "plan-and-build" (its build phase)
 	SpecFile         string
 	SpecFolder       string
+	SpecSelect       bool     // pick the spec files the run covers before it starts
+	Specs            []string // spec files in SpecFolder the run covers (empty = all)
+	IterationsPerSpec int     // work through the specs one at a time, this many iterations each (0 = all at once)
 	LoopPrompt       string
+	FirstPrompt      string // path to a prompt used for iteration 1 only
 	Goal             string
+	Vars             map[string]string // --var values for the prompt template, by name
+	PromptTemplate   bool              // run prompts as Go templates even without --var
 	PlanFile         string
 	AutoresearchFile string // path to custom experiment file for autoresearch mode
 	ShowPrompt       bool
 	ShowVersion      bool
 	NoTmux           bool
+	Parallel         int  // run this many loops side by side, each in its own git worktree (0 or 1 = one loop here)
+	Branch           string // create and check out this git branch at the start of the run ("" = stay on the current branch; AutoBranch = ralph/<timestamp>)
+	Force            bool   // start even if another ralph holds the lock in this directory
+	NoAltScreen      bool   // run the TUI inline instead of on the alternate screen
+	TUILayout        string // footer position relative to the activity panel: "top" or "bottom"
+	MaxContentWidth  int    // cap the TUI activity panel width, centered (0 = full width)
+	CompactFeed      bool   // no blank lines between TUI feed messages
+	StripANSI        bool   // remove ANSI escape sequences from agent output shown in the TUI
+	CloseAfter       time.Duration // quit the TUI this long after the run completes (0 = stay open)
 	CLI             bool
+	ProgressTo      string  // --cli: write RALPH_PROGRESS lines to "stderr" or this file or named pipe ("" = off)
 	MaxCostPerHour  float64 // maximum USD cost per rolling hour (0 = no limit)
-	Subcommand      string  // "plan", "build", "plan-and-build", "autoresearch", or "" (default: build mode)
+	Prices          []stats.PriceRule // --price rules for estimating costs from token usage, later ones winning
+	CostWarn        float64 // run cost (USD) that turns the TUI total orange and flashes a notice (0 = off)
+	CostCrit        float64 // run cost (USD) that turns the TUI total red and flashes a notice (0 = off)
+	CostSymbol      string  // currency symbol shown before costs (values stay USD)
+	CostDecimals    int     // decimal places shown for costs
+	CompactEvery    int     // request context compaction every Nth iteration (0 = never)
+	StallNudgeAfter int     // nudge after N consecutive iterations without changes, then stop (0 = off)
+	DoneAfterIdle   int     // end the run after N consecutive iterations without a Write or Edit (0 = off)
+	DoneSentinel    string  // end the run once the agent writes this text ("" = off)
+	ThrashAction    string  // on a tool call repeated over and over: "warn", "nudge" or "stop"
+	Agent           string   // agent CLI to drive, by its loop backend name (see loop.BackendNames)
+	Model           string   // model the agent runs with, passed as --model ("" = the agent's default)
+	PlanModel       string   // model for plan iterations: the plan subcommand and plan-and-build's plan phase ("" = Model)
+	FallbackModel   string   // model an iteration's retries switch to once it has failed FallbackAfter times ("" = none)
+	FallbackAfter   int      // failed attempts of an iteration before its retries switch to FallbackModel
+	AgentRestartOnCrash bool // restart a crashed agent once per iteration with --resume
+	AgentSuccessCodes []int  // agent exit codes that count as a successful run (0 always does)
+	RedoFresh       bool     // the TUI's R (redo) key starts a fresh session instead of resuming
+	StreamFormat    string   // framing of the agent's stdout: "jsonl", "sse" or "concat"
+	LoopSummary     bool     // after each iteration, show a LOOP n/m done line with its elapsed time and cost
+	ConfirmEachLoop bool     // pause before each iteration after the first until the user confirms
+	PlanReview      bool     // plan-and-build: pause after planning until the user confirms the plan
+	PreLoopHook     string   // shell command run in the working directory before each iteration ("" = none)
+	PostLoopHook    string   // shell command run in the working directory after each iteration ("" = none)
+	HookTimeout     time.Duration // limit on each hook run (0 = the default)
+	HookMustPass    bool     // stop the run when a pre- or post-loop hook fails
+	SuccessCmd      string   // shell command that must pass before the run counts as complete ("" = none)
+	SuccessRetries  int      // extra iterations to fix a failing SuccessCmd before giving up
+	CommitReport    bool     // report each iteration's commits and diff stat in the feed and stats
+	WarnNoCommit    bool     // warn about iterations that made no commit but left changes
+	MaxRetries      int      // consecutive API error retries per iteration (0 = the default)
+	NoSleepOnError  bool     // retry API errors immediately, without backing off
+	TotalRetries    int      // retries allowed across the whole run (0 = unlimited)
+	RetryFailed     int      // retries of an iteration whose agent exits with an error (0 = off)
+	RetryBackoff    time.Duration // delay before the first retry of a failed iteration, doubling per attempt
+	StartDelay      time.Duration // wait this long before the first iteration (0 = start now)
+	StartAt         string   // wait until this clock time ("15:04") before the first iteration ("" = start now)
+	ActiveHours     loop.Window // start iterations only inside this daily window (zero = any time)
+	MaxDuration     time.Duration // start no iteration once the run has lasted this long; it then completes (0 = no limit)
+	IterationTimeout time.Duration // kill an agent run that takes longer than this, failing the iteration (0 = no limit)
+	NoOutputTimeout time.Duration // kill an agent that writes no output for this long and restart it once (0 = off)
+	ExcludeDirs     []string // directories the agent should not modify (warned on, ignored by git checks)
+	MaxToolResultBytes int   // trim displayed/logged tool results beyond this many bytes (0 = no limit)
+	Redact          bool     // strip secrets from the feed, logs and transcripts (built-in patterns plus RedactPatterns)
+	RedactPatterns  []string // extra regexes to redact, from repeated --redact-pattern flags
+	StatsInterval   time.Duration // how often stats are saved during a run (0 = only on exit)
+	NoTranscripts   bool     // don't record each iteration's raw agent output under .ralph/transcripts
+	TranscriptMaxFiles int   // transcripts kept across all runs, oldest deleted first (0 = unlimited)
+	TranscriptMaxMB    int   // total megabytes of transcripts kept across all runs (0 = unlimited)
+	OtelEndpoint    string  // OTLP/HTTP collector to send run and iteration trace spans to ("" = off)
+	Listen          string  // address to serve the HTTP control API on, e.g. ":7777" ("" = off)
+	ListenToken     string  // bearer token the control API requires ("" = none)
+	NotifyWebhook   string   // URL to POST lifecycle event payloads to ("" = off)
+	NotifyEvents    []string // events NotifyWebhook gets (empty = all)
+	NotifySlack     string   // Slack incoming webhook to post a run summary to ("" = off)
+	NotifyDiscord   string   // Discord webhook to post a run summary to ("" = off)
+	ConfigFile      string   // config file read (ralph.toml, .ralph.yaml or --config; "" = none)
+	Profile         string   // config file profile applied, e.g. "nightly" ("" = base settings only)
+	StatsCommand    string  // `ralph stats` report to print: total, hourly, daily or loops
+	StatsSince      string  // `ralph stats` window, e.g. "7d" or "12h" ("" = all time)
+	StatsJSON       bool    // print `ralph stats` output as JSON
+	ParseFile       string  // `ralph parse`: captured stream-json output to check
+	ReplayDir       string  // `ralph replay`: a run's transcript directory to play back
+	ReplaySpeed     float64 // `ralph replay` playback speed multiplier (0 = no delay)
+	Subcommand      string  // "plan", "build", "plan-and-build", "autoresearch", "init", "stats", "parse", "replay", or "" (default: build mode)
+	LogFormat       string  // run log (and --cli output) format: "text" or "json" (one JSON object per line)
+	RunID           string  // unique ID for this run, tagged on logs, summaries and checkpoints (generated if not set)
 }
 
 // NewConfig returns a new Config with default values
 func NewConfig() *Config {
 	return &Config{
-		Iterations: DefaultIterations,
-		SpecFile:   "",
-		SpecFolder: DefaultSpecFolder,
-		LoopPrompt: "",
-		PlanFile:   DefaultPlanFile,
+		Iterations:   DefaultIterations,
+		SpecFile:     "",
+		SpecFolder:   DefaultSpecFolder,
+		LoopPrompt:   "",
+		PlanFile:     DefaultPlanFile,
+		TUILayout:    DefaultTUILayout,
+		LogFormat:    DefaultLogFormat,
+		StreamFormat: DefaultStreamFormat,
+		Agent:        DefaultAgent,
+		ThrashAction: DefaultThrashAction,
+		CostSymbol:   DefaultCostSymbol,
+		CostDecimals: DefaultCostDecimals,
+		MaxToolResultBytes: DefaultMaxToolResultBytes,
+		StatsInterval:      DefaultStatsInterval,
+		TranscriptMaxFiles: DefaultTranscriptMaxFiles,
+		TranscriptMaxMB:    DefaultTranscriptMaxMB,
+		HookTimeout:        DefaultHookTimeout,
+		MaxRetries:         DefaultMaxRetries,
+		RetryBackoff:       DefaultRetryBackoff,
+		FallbackAfter:      1,
+		ReplaySpeed:        1,
+		CommitReport:       true,
+		StripANSI:          true,
+		AgentSuccessCodes:  []int{0},
 	}
 }
 
-// DetectSubcommand checks os.Args for a subcommand ("plan" or "build") before flag parsing.
+// DetectSubcommand checks os.Args for a subcommand ("plan", "build", etc.) before flag parsing.
 // If found, it removes the subcommand from os.Args so flag.Parse() works correctly.
 // Returns the detected subcommand or "".
 func DetectSubcommand() string {
 	if len(os.Args) > 1 {
 		switch os.Args[1] {
-		case "plan", "build", "plan-and-build", "autoresearch":
+		case "plan", "build", "plan-and-build", "autoresearch", "init", "stats", "parse", "replay":
 			sub := os.Args[1]
 			os.Args = append(os.Args[:1], os.Args[2:]...)
 			return sub
@@ -64,8 +208,32 @@ func DetectSubcommand() string {
 	return ""
 }
 
+// ReadRCArgs reads default flags from an rc file: whitespace-separated flag
+// arguments, any number per line, with blank lines and # comments ignored.
+// A missing file yields no arguments and no error.
+func ReadRCArgs(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		args = append(args, strings.Fields(line)...)
+	}
+	return args, nil
+}
+
 // ParseFlags parses command-line flags and returns a Config.
 // It defines the flags, parses them, and returns the resulting configuration.
+// Settings from a config file (ConfigFiles), RCFile and RALPH_* environment
+// variables are applied first, in that order, so the command line overrides
+// them.
 func ParseFlags() *Config {
 	cfg := NewConfig()
 
@@ -73,20 +241,192 @@ func ParseFlags() *Config {
 	cfg.Subcommand = DetectSubcommand()
 
 	flag.IntVar(&cfg.Iterations, "iterations", DefaultIterations, "Number of loop iterations")
+	flag.Func("default-iterations", "Default --iterations for a mode, as mode=N with mode plan, build or plan-and-build (its build phase); repeat for several modes, e.g. in .ralphrc", func(v string) error {
+		mode, n, ok := strings.Cut(v, "=")
+		mode = strings.TrimSpace(mode)
+		if !ok || (mode != "plan" && mode != "build" && mode != "plan-and-build") {
+			return fmt.Errorf("want plan=N, build=N or plan-and-build=N, got %q", v)
+		}
+		iterations, err := strconv.Atoi(strings.TrimSpace(n))
+		if err != nil || iterations <= 0 {
+			return fmt.Errorf("%s iterations must be a positive number, got %q", mode, n)
+		}
+		if cfg.DefaultIterationsBy == nil {
+			cfg.DefaultIterationsBy = map[string]int{}
+		}
+		cfg.DefaultIterationsBy[mode] = iterations
+		return nil
+	})
 	flag.StringVar(&cfg.SpecFile, "spec-file", "", "Specific spec file to use (overrides spec-folder)")
 	flag.StringVar(&cfg.SpecFolder, "spec-folder", DefaultSpecFolder, "Folder containing spec files")
+	flag.BoolVar(&cfg.SpecSelect, "spec-select", false, "Before the run, pick which spec files in the spec folder it covers (when there is more than one)")
+	flag.Func("specs", "Comma-separated spec files in the spec folder the run covers, e.g. auth.md,api/users.md (default all)", func(v string) error {
+		cfg.Specs = nil
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				cfg.Specs = append(cfg.Specs, name)
+			}
+		}
+		return nil
+	})
+	flag.IntVar(&cfg.IterationsPerSpec, "iterations-per-spec", 0, "Work through the specs one at a time, in order, giving each this many iterations (overrides --iterations; 0 = all specs at once)")
 	flag.StringVar(&cfg.LoopPrompt, "loop-prompt", "", "Path to loop prompt override (defaults to embedded prompt.md)")
+	flag.StringVar(&cfg.FirstPrompt, "first-prompt", "", "Path to a prompt used for the first iteration only (later iterations use the loop prompt)")
 	flag.StringVar(&cfg.Goal, "goal", "", "Ultimate goal sentence to guide the agent")
+	flag.BoolVar(&cfg.PromptTemplate, "prompt-template", false, "Run prompts as Go templates, filling in {{.SpecPath}}, {{.Iteration}}, {{.TotalIterations}}, {{.Goal}} and {{.Branch}} (on with any --var)")
+	flag.Func("var", "Set a variable for the prompt template as name=value, used as {{.name}}, and run prompts as templates; repeat for several (built in: {{.SpecPath}}, {{.Iteration}}, {{.TotalIterations}}, {{.Goal}}, {{.Branch}})", func(v string) error {
+		name, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return fmt.Errorf("expected name=value, got %q", v)
+		}
+		if !varNamePattern.MatchString(name) {
+			return fmt.Errorf("%q is not a valid name (letters, digits and _, not starting with a digit)", name)
+		}
+		for _, builtin := range prompt.BuiltinVars {
+			if name == builtin {
+				return fmt.Errorf("%s is built in and can't be set", name)
+			}
+		}
+		if cfg.Vars == nil {
+			cfg.Vars = map[string]string{}
+		}
+		cfg.Vars[name] = value
+		return nil
+	})
 	flag.StringVar(&cfg.PlanFile, "plan-file", DefaultPlanFile, "Implementation plan filename")
 	flag.BoolVar(&cfg.ShowPrompt, "show-prompt", false, "Print the embedded loop prompt and exit")
 	flag.BoolVar(&cfg.ShowVersion, "version", false, "Print version and exit")
 	flag.BoolVar(&cfg.NoTmux, "no-tmux", false, "Run without tmux wrapper")
+	flag.IntVar(&cfg.Parallel, "parallel", 0, "Run N loops side by side, each in its own git worktree and branch under .ralph/worktrees (a tmux window each, or interleaved --cli output)")
+	flag.StringVar(&cfg.Branch, "branch", "", "Create and check out this git branch at the start of the run, so the agent's commits stay off the current branch (\"auto\" = ralph/<timestamp>)")
+	flag.BoolVar(&cfg.Force, "force", false, "Start even if another ralph is running in this directory (.ralph.lock)")
+	flag.BoolVar(&cfg.NoAltScreen, "no-alt-screen", false, "Run the TUI without the alternate screen so output stays in scrollback")
+	flag.StringVar(&cfg.TUILayout, "tui-layout", DefaultTUILayout, "Footer position in the TUI: top or bottom")
+	flag.IntVar(&cfg.MaxContentWidth, "max-content-width", 0, "Cap the TUI activity panel at this many columns, centered on wide terminals (0 = full width)")
+	flag.BoolVar(&cfg.StripANSI, "strip-ansi", true, "Remove ANSI color and cursor codes from agent output shown in the TUI (--strip-ansi=false keeps them)")
+	flag.BoolVar(&cfg.CompactFeed, "compact-feed", false, "Drop the blank lines between TUI feed messages; a dim divider marks role changes instead")
+	flag.DurationVar(&cfg.CloseAfter, "close-after", 0, "Close the TUI this long after the run completes, e.g. 10s (0 = stay open)")
+	flag.StringVar(&cfg.Agent, "agent", DefaultAgent, "Agent CLI to drive: "+strings.Join(loop.BackendNames(), ", "))
+	flag.StringVar(&cfg.Model, "model", "", "Model the agent runs with, passed to it as --model, e.g. sonnet (default: the agent's own)")
+	flag.StringVar(&cfg.PlanModel, "plan-model", "", "Model for plan iterations, in plan and plan-and-build, e.g. a cheaper one than --model (default: --model)")
+	flag.StringVar(&cfg.FallbackModel, "fallback-model", "", "Model that --retry-failed retries of an iteration switch to once it has failed --fallback-after times, e.g. opus")
+	flag.IntVar(&cfg.FallbackAfter, "fallback-after", 1, "Failed attempts of an iteration before its retries switch to --fallback-model")
+	flag.BoolVar(&cfg.AgentRestartOnCrash, "agent-restart-on-crash", false, "Restart the agent once (with --resume) if it exits without a result")
+	flag.Func("agent-success-codes", "Comma-separated agent exit codes that count as success, e.g. 0,2 for a backend that exits 2 on warnings (default 0)", func(v string) error {
+		cfg.AgentSuccessCodes = nil
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			code, err := strconv.Atoi(part)
+			if err != nil {
+				return fmt.Errorf("exit codes must be numbers, got %q", part)
+			}
+			cfg.AgentSuccessCodes = append(cfg.AgentSuccessCodes, code)
+		}
+		if len(cfg.AgentSuccessCodes) == 0 {
+			return fmt.Errorf("list at least one exit code")
+		}
+		return nil
+	})
+	flag.StringVar(&cfg.StreamFormat, "stream-format", DefaultStreamFormat, "How the agent's output is framed: jsonl, sse (data: lines) or concat (back-to-back JSON)")
+	flag.BoolVar(&cfg.LoopSummary, "loop-summary", false, "After each iteration, show a line with its elapsed time and cost")
+	flag.BoolVar(&cfg.RedoFresh, "redo-fresh", false, "Redo an iteration (R in the TUI) in a fresh session instead of resuming its session")
+	flag.BoolVar(&cfg.PlanReview, "plan-review", false, "In plan-and-build, pause after planning so you can review the plan before building")
+	flag.StringVar(&cfg.LogFormat, "log-format", DefaultLogFormat, "Run log format, and with --cli the output format: text or json (one JSON object per line)")
+	flag.StringVar(&cfg.OtelEndpoint, "otel-endpoint", "", "OTLP/HTTP collector to send OpenTelemetry spans to, one per run, per iteration and per tool call, e.g. http://localhost:4318")
+	flag.StringVar(&cfg.Listen, "listen", "", "Serve an HTTP API to pause, resume, stop and resize the run and read its stats, events and Prometheus metrics on this address, e.g. :7777")
+	flag.StringVar(&cfg.ListenToken, "listen-token", "", "Bearer token the --listen API requires in an Authorization header")
+	flag.StringVar(&cfg.NotifyWebhook, "notify-webhook", "", "URL to POST a JSON payload to on run start, iteration complete, error, hibernate, budget exceeded and run complete")
+	flag.Func("notify-events", "Comma-separated events --notify-webhook gets: run_start, iteration_complete, error, hibernate, budget_exceeded, run_complete (default all)", func(v string) error {
+		cfg.NotifyEvents = nil
+		for _, e := range strings.Split(v, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				cfg.NotifyEvents = append(cfg.NotifyEvents, e)
+			}
+		}
+		return nil
+	})
+	flag.StringVar(&cfg.NotifySlack, "notify-slack", "", "Slack incoming webhook URL to post a summary to (cost, tokens, iterations, elapsed) when the run finishes or fails")
+	flag.StringVar(&cfg.NotifyDiscord, "notify-discord", "", "Discord webhook URL to post a summary to (cost, tokens, iterations, elapsed) when the run finishes or fails")
+	flag.StringVar(&cfg.ConfigFile, "config", "", "Read settings from this ralph.toml or .ralph.yaml instead of the one in the working directory")
+	flag.StringVar(&cfg.Profile, "profile", "", "Apply the named profile from the config file, e.g. nightly for its [profile.nightly] settings")
+	flag.StringVar(&cfg.RunID, "run-id", "", "ID to tag this run's logs, summary and checkpoints with (default: a generated UUID)")
+	flag.StringVar(&cfg.PreLoopHook, "pre-loop-hook", "", "Shell command to run before each loop, e.g. 'git pull --rebase'; its exit code and output are shown")
+	flag.StringVar(&cfg.PostLoopHook, "post-loop-hook", "", "Shell command to run after each loop, e.g. 'make test'; its exit code and output are shown")
+	flag.DurationVar(&cfg.HookTimeout, "hook-timeout", DefaultHookTimeout, "Kill a loop hook that runs longer than this")
+	flag.BoolVar(&cfg.HookMustPass, "hook-must-pass", false, "Stop the run when a pre- or post-loop hook fails")
+	flag.StringVar(&cfg.SuccessCmd, "success-cmd", "", "Shell command that must pass for the run to count as complete, e.g. 'make test'; --cli exits 1 if it fails")
+	flag.IntVar(&cfg.SuccessRetries, "success-retries", 0, "When --success-cmd fails, run up to this many extra iterations asking the agent to fix it")
+	flag.BoolVar(&cfg.CommitReport, "commit-report", true, "After each iteration, report its commits and diff stat (files changed, insertions, deletions) in the feed and stats; outside git, the lines its edits changed (--commit-report=false turns it off)")
+	flag.BoolVar(&cfg.WarnNoCommit, "warn-no-commit", false, "Warn when an iteration makes no commit but leaves uncommitted changes")
+	flag.DurationVar(&cfg.StartDelay, "start-delay", 0, "Wait this long before the first iteration, e.g. 2h")
+	flag.StringVar(&cfg.StartAt, "start-at", "", "Wait until this local time (HH:MM, 24-hour) before the first iteration, e.g. 03:00")
+	flag.DurationVar(&cfg.MaxDuration, "max-duration", 0, "End the run once it has lasted this long, e.g. 8h: the running iteration finishes, then the run completes (0 = no limit)")
+	flag.DurationVar(&cfg.IterationTimeout, "iteration-timeout", 0, "Kill an agent that runs longer than this in one iteration, e.g. 30m, and fail the iteration (retried with --retry-failed; 0 = no limit)")
+	flag.DurationVar(&cfg.NoOutputTimeout, "no-output-timeout", 0, "Kill an agent that writes no output for this long, e.g. 10m, and restart it once, resuming its session (0 = off)")
+	flag.Func("active-hours", "Start iterations only inside this daily local time window (HH:MM-HH:MM), e.g. 22:00-06:00 for off-peak hours; outside it the run waits for the window to open", func(v string) error {
+		w, err := loop.ParseWindow(v)
+		if err != nil {
+			return err
+		}
+		cfg.ActiveHours = w
+		return nil
+	})
+	flag.IntVar(&cfg.MaxRetries, "max-retries", DefaultMaxRetries, "Consecutive API error retries allowed within one iteration (0 = the default)")
+	flag.BoolVar(&cfg.NoSleepOnError, "no-sleep-on-error", false, "Retry API errors (529/500) immediately instead of backing off, up to --max-retries; for fast local loops")
+	flag.IntVar(&cfg.TotalRetries, "total-retries", 0, "Retries allowed across the whole run, plan-and-build's two phases together, including rate limit waits, crash restarts and failed iteration retries (0 = unlimited)")
+	flag.IntVar(&cfg.RetryFailed, "retry-failed", 0, "Retry an iteration whose agent exits with an error (network blip, API error) up to this many times (0 = move on)")
+	flag.DurationVar(&cfg.RetryBackoff, "retry-backoff", DefaultRetryBackoff, "Wait this long before the first --retry-failed retry, doubling with each attempt (±20% jitter, at most 10m)")
+	flag.BoolVar(&cfg.ConfirmEachLoop, "confirm-each-loop", false, "Pause before each loop until you press r/Enter (TUI) or Enter (CLI)")
+	flag.Func("exclude-dirs", "Comma-separated directories the agent should not modify, e.g. node_modules,dist", func(v string) error {
+		cfg.ExcludeDirs = nil
+		for _, dir := range strings.Split(v, ",") {
+			if dir = strings.TrimSpace(dir); dir != "" {
+				cfg.ExcludeDirs = append(cfg.ExcludeDirs, dir)
+			}
+		}
+		return nil
+	})
+	flag.BoolVar(&cfg.Redact, "redact", false, "Strip secrets (API keys and tokens in the built-in formats) from the feed, logs and transcripts")
+	flag.Func("redact-pattern", "Regex of another secret to strip, implies --redact; repeat for more", func(v string) error {
+		cfg.Redact = true
+		cfg.RedactPatterns = append(cfg.RedactPatterns, v)
+		return nil
+	})
 	flag.BoolVar(&cfg.CLI, "cli", false, "Run without TUI, output to stdout/stderr, exit when complete")
+	flag.StringVar(&cfg.ProgressTo, "progress-to", "", "With --cli: write a RALPH_PROGRESS key=value line on each state change to stderr or to this file or named pipe, for editor integrations")
 	flag.Float64Var(&cfg.MaxCostPerHour, "max-cost-per-hour", 0, "Maximum USD cost per rolling hour (0 = no limit)")
+	flag.Func("price", "Price a model for cost estimates as MODEL=INPUT/OUTPUT[/CACHE_WRITE/CACHE_READ] in USD per million tokens, e.g. gpt-5=1.25/10; MODEL matches part of the model name, * any model; repeat for several", func(v string) error {
+		rule, err := stats.ParsePriceRule(v)
+		if err != nil {
+			return err
+		}
+		cfg.Prices = append(cfg.Prices, rule)
+		return nil
+	})
+	flag.Float64Var(&cfg.CostWarn, "cost-warn", 0, "Run cost in USD at which the TUI flashes a warning and shows the total in orange (0 = off)")
+	flag.Float64Var(&cfg.CostCrit, "cost-crit", 0, "Run cost in USD at which the TUI flashes a warning and shows the total in red (0 = off)")
+	flag.StringVar(&cfg.CostSymbol, "cost-symbol", DefaultCostSymbol, "Symbol shown before costs (values are always USD)")
+	flag.IntVar(&cfg.CostDecimals, "cost-decimals", DefaultCostDecimals, fmt.Sprintf("Decimal places shown for costs (0-%d)", MaxCostDecimals))
+	flag.DurationVar(&cfg.StatsInterval, "stats-interval", DefaultStatsInterval, "How often to save usage stats during a run, e.g. 30s (0 = only on exit)")
+	flag.BoolVar(&cfg.NoTranscripts, "no-transcripts", false, "Don't record each iteration's raw agent output to .ralph/transcripts/<run-id>/<iteration>.jsonl")
+	flag.IntVar(&cfg.TranscriptMaxFiles, "transcript-max-files", DefaultTranscriptMaxFiles, "Transcripts kept across all runs; the oldest are deleted first (0 = unlimited)")
+	flag.IntVar(&cfg.TranscriptMaxMB, "transcript-max-mb", DefaultTranscriptMaxMB, "Total size in MB of the transcripts kept across all runs; the oldest are deleted first (0 = unlimited)")
+	flag.StringVar(&cfg.StatsSince, "since", "", "With ralph stats: only count runs within this window, e.g. 7d or 12h")
+	flag.BoolVar(&cfg.StatsJSON, "json", false, "With ralph stats: print JSON instead of a table")
+	flag.StringVar(&cfg.ParseFile, "file", "", "With ralph parse: captured stream-json output to run through the parser")
+	flag.Float64Var(&cfg.ReplaySpeed, "speed", 1, "With ralph replay: playback speed, e.g. 4 for four times as fast (0 = no delay)")
+	flag.IntVar(&cfg.CompactEvery, "compact-every", 0, "Ask the agent to compact its context every N iterations (0 = never)")
+	flag.IntVar(&cfg.MaxToolResultBytes, "max-tool-result-bytes", DefaultMaxToolResultBytes, "Trim tool results shown and logged beyond this many bytes (0 = no limit)")
+	flag.IntVar(&cfg.StallNudgeAfter, "stall-nudge-after", 0, "Nudge the agent after N iterations without repository changes, stop if it stays stuck (0 = off)")
+	flag.IntVar(&cfg.DoneAfterIdle, "done-after-idle", 0, "End the run after N consecutive iterations without a Write or Edit tool call (0 = off)")
+	flag.StringVar(&cfg.DoneSentinel, "done-sentinel", "", "End the run after an iteration in which the agent writes this text, e.g. RALPH_DONE (empty = off)")
+	flag.StringVar(&cfg.ThrashAction, "thrash-action", DefaultThrashAction, "When the agent keeps repeating the same tool call: warn, nudge (tell it in the next prompt) or stop")
 
 	// Custom usage function to display flags with -- prefix
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [plan|build|plan-and-build|autoresearch] [flags]\n\nSubcommands:\n  plan\t\t\tRun in planning mode (uses plan prompt instead of build prompt)\n  build\t\t\tRun in build mode (default if no subcommand specified)\n  plan-and-build\tRun planning (1 iter) then building (default 5 iters)\n  autoresearch\t\tRun optimization loop (looks for specs/experiment.md)\n\nFlags:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [plan|build|plan-and-build|autoresearch|init|stats|resume|replay|service install] [flags]\n\nSubcommands:\n  plan\t\t\tRun in planning mode (uses plan prompt instead of build prompt)\n  build\t\t\tRun in build mode (default if no subcommand specified)\n  plan-and-build\tRun planning (1 iter) then building (default 5 iters)\n  autoresearch\t\tRun optimization loop (looks for specs/experiment.md)\n  init\t\t\tScaffold specs/, a starter plan and a .ralphrc in the current directory\n  stats total\t\tSum cost and tokens across all recorded runs (--since, --json)\n  stats hourly|daily|loops\tBreak the last week's cost down by hour, day or loop\n  resume\t\t\tContinue an interrupted run from .ralph/state.json\n  replay <dir>\t\tPlay a run's transcripts back in the TUI (--speed)\n  service install\tWrite a systemd unit (launchd agent on macOS) running the rest of the command line headless\n\nFlags:\n", os.Args[0])
 		flag.VisitAll(func(f *flag.Flag) {
 			// Format: --flag-name type
 			//     description (default: value)
@@ -106,7 +446,46 @@ func ParseFlags() *Config {
 		})
 	}
 
-	flag.Parse()
+	// Settings apply in order, so later ones win: the config file (with the
+	// --profile chosen on the command line, in the environment or in RCFile),
+	// RCFile, RALPH_* environment variables, then the command line
+	rcArgs, err := ReadRCArgs(RCFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not read %s: %v\n", RCFile, err)
+	}
+	profile := FlagArg(os.Args[1:], "profile")
+	if profile == "" {
+		profile = os.Getenv(EnvPrefix + "PROFILE")
+	}
+	if profile == "" {
+		profile = FlagArg(rcArgs, "profile")
+	}
+	cfg.ConfigFile = FlagArg(os.Args[1:], "config")
+	if cfg.ConfigFile == "" {
+		cfg.ConfigFile = os.Getenv(EnvPrefix + "CONFIG")
+	}
+	if cfg.ConfigFile == "" {
+		cfg.ConfigFile = FindConfigFile(".")
+	}
+	var args []string
+	if cfg.ConfigFile != "" {
+		fileArgs, err := ReadConfigFileArgs(flag.CommandLine, cfg.ConfigFile, profile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		args = append(args, fileArgs...)
+	} else if profile != "" {
+		fmt.Fprintf(os.Stderr, "Error: --profile %s needs a %s defining it\n", profile, strings.Join(ConfigFiles, " or "))
+		os.Exit(2)
+	}
+	args = append(args, rcArgs...)
+	args = append(args, EnvArgs(flag.CommandLine, os.Getenv)...)
+	flag.CommandLine.Parse(append(args, os.Args[1:]...))
+
+	if cfg.RunID == "" {
+		cfg.RunID = uuid.NewString()
+	}
 
 	// Check if --iterations was explicitly set
 	iterationsExplicit := false
@@ -116,10 +495,14 @@ func ParseFlags() *Config {
 		}
 	})
 
-	// In plan mode, default to 1 iteration unless the user explicitly set --iterations
-	if cfg.IsPlanMode() {
-		if !iterationsExplicit {
-			cfg.Iterations = DefaultPlanIterations
+	// In plan mode, default to 1 iteration unless the user explicitly set --iterations.
+	// --default-iterations replaces a mode's default, never an explicit --iterations.
+	if !iterationsExplicit {
+		switch {
+		case cfg.IsPlanMode():
+			cfg.Iterations = cfg.defaultIterations("plan", DefaultPlanIterations)
+		case cfg.IsBuildMode():
+			cfg.Iterations = cfg.defaultIterations("build", DefaultIterations)
 		}
 	}
 
@@ -128,19 +511,51 @@ func ParseFlags() *Config {
 		cfg.AutoresearchFile = flag.Arg(0)
 	}
 
-	// In plan-and-build mode, plan is always 1 iteration, --iterations applies to build phase
+	// In stats mode, the positional argument names the report
+	if cfg.IsStatsMode() && flag.NArg() > 0 {
+		cfg.StatsCommand = flag.Arg(0)
+	}
+
+	// In parse mode, a positional argument can name the capture instead of --file
+	if cfg.IsParseMode() && cfg.ParseFile == "" && flag.NArg() > 0 {
+		cfg.ParseFile = flag.Arg(0)
+	}
+
+	// In replay mode, the positional argument is the transcript directory
+	if cfg.IsReplayMode() && flag.NArg() > 0 {
+		cfg.ReplayDir = flag.Arg(0)
+	}
+
+	// In plan-and-build mode, --iterations applies to the build phase; the plan
+	// phase runs plan mode's default (1 unless overridden)
 	if cfg.IsPlanAndBuildMode() {
 		if iterationsExplicit {
 			cfg.BuildIterations = cfg.Iterations
 		} else {
-			cfg.BuildIterations = DefaultIterations
+			cfg.BuildIterations = cfg.defaultIterations("plan-and-build", DefaultIterations)
 		}
-		cfg.Iterations = DefaultPlanIterations // Plan phase is always 1
+		cfg.Iterations = cfg.defaultIterations("plan", DefaultPlanIterations)
 	}
 
 	return cfg
 }
 
+// TemplatePrompts reports whether prompts are run as Go templates: only with
+// --prompt-template or a --var, so a prompt that shows {{ in, say, a code
+// sample is sent as written.
+func (c *Config) TemplatePrompts() bool {
+	return c.PromptTemplate || len(c.Vars) > 0
+}
+
+// defaultIterations returns the --default-iterations override for mode, or
+// fallback when there is none.
+func (c *Config) defaultIterations(mode string, fallback int) int {
+	if n, ok := c.DefaultIterationsBy[mode]; ok {
+		return n
+	}
+	return fallback
+}
+
 // IsPlanMode returns true if the "plan" subcommand was specified
 func (c *Config) IsPlanMode() bool {
 	return c.Subcommand == "plan"
@@ -156,6 +571,104 @@ func (c *Config) IsAutoresearchMode() bool {
 	return c.Subcommand == "autoresearch"
 }
 
+// IsStatsMode returns true if the "stats" subcommand was specified
+func (c *Config) IsStatsMode() bool {
+	return c.Subcommand == "stats"
+}
+
+// startAtLayout is the clock time format of --start-at.
+const startAtLayout = "15:04"
+
+// StartTime returns when the first iteration may start: StartDelay after now,
+// or the next StartAt clock time after now in now's location (tomorrow if it
+// has already passed today). It is the zero time when neither is set.
+func (c *Config) StartTime(now time.Time) time.Time {
+	if c.StartAt != "" {
+		clock, err := time.Parse(startAtLayout, c.StartAt)
+		if err != nil {
+			return time.Time{}
+		}
+		start := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+		if !start.After(now) {
+			start = start.AddDate(0, 0, 1)
+		}
+		return start
+	}
+	if c.StartDelay > 0 {
+		return now.Add(c.StartDelay)
+	}
+	return time.Time{}
+}
+
+// Deadline returns when a run started at now stops starting iterations:
+// MaxDuration after its first iteration may start (see StartTime). It is the
+// zero time when MaxDuration is not set.
+func (c *Config) Deadline(now time.Time) time.Time {
+	if c.MaxDuration <= 0 {
+		return time.Time{}
+	}
+	start := c.StartTime(now)
+	if start.IsZero() {
+		start = now
+	}
+	return start.Add(c.MaxDuration)
+}
+
+// ModelFor returns the model for a run's iterations: PlanModel, when set,
+// for plan iterations and Model otherwise.
+func (c *Config) ModelFor(plan bool) string {
+	if plan && c.PlanModel != "" {
+		return c.PlanModel
+	}
+	return c.Model
+}
+
+// ParseSince parses a --since window: a number of days ("7d") or any
+// time.ParseDuration value ("12h", "90m"). The window must be positive.
+func ParseSince(s string) (time.Duration, error) {
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid --since %q: want e.g. 7d or 12h", s)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, fmt.Errorf("invalid --since %q: want e.g. 7d or 12h", s)
+		}
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("--since must be positive, got %q", s)
+	}
+	return d, nil
+}
+
+// RunBranch returns the branch --branch names for a run started at start:
+// Branch itself, or ralph/<timestamp> for AutoBranch.
+func (c *Config) RunBranch(start time.Time) string {
+	if c.Branch == AutoBranch {
+		return "ralph/" + start.Format("20060102-150405")
+	}
+	return c.Branch
+}
+
+// IsParseMode returns true if the "parse" subcommand was specified
+func (c *Config) IsParseMode() bool {
+	return c.Subcommand == "parse"
+}
+
+// IsReplayMode returns true if the "replay" subcommand was specified
+func (c *Config) IsReplayMode() bool {
+	return c.Subcommand == "replay"
+}
+
+// IsInitMode returns true if the "init" subcommand was specified
+func (c *Config) IsInitMode() bool {
+	return c.Subcommand == "init"
+}
+
 // IsBuildMode returns true for bare `ralph` or the explicit "build" subcommand.
 func (c *Config) IsBuildMode() bool {
 	return c.Subcommand == "" || c.Subcommand == "build"
@@ -185,15 +698,249 @@ func SpecFolderEmptyOrMissing(path string) bool {
 
 // Validate checks if the configuration is valid.
 // It validates:
-// - Iterations must be greater than 0
+// - Iterations must be greater than 0 (CLI mode also accepts 0 as a no-op run)
+// - Parallel, CompactEvery, StallNudgeAfter, DoneAfterIdle, MaxToolResultBytes, StatsInterval, TranscriptMaxFiles, TranscriptMaxMB, CloseAfter, StartDelay, MaxDuration, IterationTimeout and NoOutputTimeout must not be negative
+// - Branch can't be combined with Parallel > 1
+// - IterationsPerSpec must not be negative, and can't be combined with plan-and-build or Parallel > 1
+// - StartAt, if set, must be an HH:MM clock time, and not combined with StartDelay
+// - SuccessRetries must not be negative and requires SuccessCmd
+// - FallbackModel requires RetryFailed, and FallbackAfter must then be at least 1
+// - WarnNoCommit requires CommitReport
+// - ProgressTo requires CLI
+// - Agent, if set, must name a registered loop backend
+// - AgentSuccessCodes must be exit codes from 0 to 255
+// - CostDecimals must be between 0 and MaxCostDecimals
+// - CostWarn and CostCrit must not be negative, and CostCrit must exceed CostWarn when both are set
+// - TUILayout, if set, must be "top" or "bottom"
+// - ThrashAction, if set, must be "warn", "nudge" or "stop"
+// - OtelEndpoint, if set, must be an http or https URL
+// - Listen, if set, must be a host:port address, and a loopback one without ListenToken; ListenToken requires Listen
+// - NotifyWebhook, NotifySlack and NotifyDiscord, if set, must be http or https URLs; NotifyEvents must be known events and requires NotifyWebhook
+// - RedactPatterns must be valid regular expressions
+// - RunID, if set, must be letters, digits, '-' or '_' (at most 64)
 // - If spec-file is provided, it must exist
 // - If spec-folder is provided (and spec-file is not), it must exist (unless using custom loop-prompt)
 // - If loop-prompt is provided, it must exist
+// - If first-prompt is provided, it must exist (and is not valid with plan-and-build)
+// - PlanReview is only valid with plan-and-build
 func (c *Config) Validate() error {
-	if c.Iterations <= 0 {
+	if c.Iterations < 0 || (c.Iterations == 0 && !c.CLI) {
 		return fmt.Errorf("--iterations must be greater than 0, got %d", c.Iterations)
 	}
 
+	if c.Parallel < 0 {
+		return fmt.Errorf("--parallel must not be negative, got %d", c.Parallel)
+	}
+	if c.Branch != "" && c.Parallel > 1 {
+		return fmt.Errorf("--branch can't be used with --parallel (each loop works on a branch of its own)")
+	}
+	if c.IterationsPerSpec < 0 {
+		return fmt.Errorf("--iterations-per-spec must not be negative, got %d", c.IterationsPerSpec)
+	}
+	if c.IterationsPerSpec > 0 && (c.IsPlanAndBuildMode() || c.Parallel > 1) {
+		return fmt.Errorf("--iterations-per-spec can't be used with plan-and-build or --parallel")
+	}
+
+	if c.CompactEvery < 0 {
+		return fmt.Errorf("--compact-every must not be negative, got %d", c.CompactEvery)
+	}
+
+	if c.StallNudgeAfter < 0 {
+		return fmt.Errorf("--stall-nudge-after must not be negative, got %d", c.StallNudgeAfter)
+	}
+
+	if c.DoneAfterIdle < 0 {
+		return fmt.Errorf("--done-after-idle must not be negative, got %d", c.DoneAfterIdle)
+	}
+
+	if c.MaxToolResultBytes < 0 {
+		return fmt.Errorf("--max-tool-result-bytes must not be negative, got %d", c.MaxToolResultBytes)
+	}
+
+	if c.StatsInterval < 0 {
+		return fmt.Errorf("--stats-interval must not be negative, got %s", c.StatsInterval)
+	}
+
+	if c.TranscriptMaxFiles < 0 {
+		return fmt.Errorf("--transcript-max-files must not be negative, got %d", c.TranscriptMaxFiles)
+	}
+
+	if c.TranscriptMaxMB < 0 {
+		return fmt.Errorf("--transcript-max-mb must not be negative, got %d", c.TranscriptMaxMB)
+	}
+
+	if c.CloseAfter < 0 {
+		return fmt.Errorf("--close-after must not be negative, got %s", c.CloseAfter)
+	}
+
+	if c.HookTimeout < 0 {
+		return fmt.Errorf("--hook-timeout must not be negative, got %s", c.HookTimeout)
+	}
+
+	if c.MaxDuration < 0 {
+		return fmt.Errorf("--max-duration must not be negative, got %s", c.MaxDuration)
+	}
+
+	if c.IterationTimeout < 0 {
+		return fmt.Errorf("--iteration-timeout must not be negative, got %s", c.IterationTimeout)
+	}
+
+	if c.NoOutputTimeout < 0 {
+		return fmt.Errorf("--no-output-timeout must not be negative, got %s", c.NoOutputTimeout)
+	}
+
+	if c.MaxRetries < 0 {
+		return fmt.Errorf("--max-retries must not be negative, got %d", c.MaxRetries)
+	}
+
+	if c.RetryFailed < 0 {
+		return fmt.Errorf("--retry-failed must not be negative, got %d", c.RetryFailed)
+	}
+	if c.RetryBackoff < 0 {
+		return fmt.Errorf("--retry-backoff must not be negative, got %s", c.RetryBackoff)
+	}
+	if c.TotalRetries < 0 {
+		return fmt.Errorf("--total-retries must not be negative, got %d", c.TotalRetries)
+	}
+	if c.FallbackModel != "" && c.RetryFailed == 0 {
+		return fmt.Errorf("--fallback-model requires --retry-failed: only retries of a failed iteration switch models")
+	}
+	if c.FallbackModel != "" && c.FallbackAfter < 1 {
+		return fmt.Errorf("--fallback-after must be at least 1, got %d", c.FallbackAfter)
+	}
+
+	if c.StartDelay < 0 {
+		return fmt.Errorf("--start-delay must not be negative, got %s", c.StartDelay)
+	}
+
+	if c.StartAt != "" {
+		if c.StartDelay != 0 {
+			return fmt.Errorf("--start-delay and --start-at cannot be used together")
+		}
+		if _, err := time.Parse(startAtLayout, c.StartAt); err != nil {
+			return fmt.Errorf("--start-at must be a 24-hour clock time like 03:00, got %q", c.StartAt)
+		}
+	}
+
+	if c.WarnNoCommit && !c.CommitReport {
+		return fmt.Errorf("--warn-no-commit requires --commit-report")
+	}
+
+	if c.HookMustPass && c.PreLoopHook == "" && c.PostLoopHook == "" {
+		return fmt.Errorf("--hook-must-pass requires --pre-loop-hook or --post-loop-hook")
+	}
+
+	for _, code := range c.AgentSuccessCodes {
+		if code < 0 || code > 255 {
+			return fmt.Errorf("--agent-success-codes must be exit codes from 0 to 255, got %d", code)
+		}
+	}
+
+	if c.ProgressTo != "" && !c.CLI {
+		return fmt.Errorf("--progress-to requires --cli")
+	}
+
+	if c.SuccessRetries < 0 {
+		return fmt.Errorf("--success-retries must not be negative, got %d", c.SuccessRetries)
+	}
+
+	if c.SuccessRetries > 0 && c.SuccessCmd == "" {
+		return fmt.Errorf("--success-retries requires --success-cmd")
+	}
+
+	if c.CostDecimals < 0 || c.CostDecimals > MaxCostDecimals {
+		return fmt.Errorf("--cost-decimals must be between 0 and %d, got %d", MaxCostDecimals, c.CostDecimals)
+	}
+
+	if c.CostWarn < 0 {
+		return fmt.Errorf("--cost-warn must not be negative, got %g", c.CostWarn)
+	}
+	if c.CostCrit < 0 {
+		return fmt.Errorf("--cost-crit must not be negative, got %g", c.CostCrit)
+	}
+	if c.CostWarn > 0 && c.CostCrit > 0 && c.CostCrit <= c.CostWarn {
+		return fmt.Errorf("--cost-crit (%g) must be greater than --cost-warn (%g)", c.CostCrit, c.CostWarn)
+	}
+
+	for _, p := range c.RedactPatterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("--redact-pattern %q is not a valid regex: %v", p, err)
+		}
+	}
+
+	if c.RunID != "" && !runIDPattern.MatchString(c.RunID) {
+		return fmt.Errorf("--run-id must be 1-64 letters, digits, '-' or '_', got %q", c.RunID)
+	}
+
+	if c.MaxContentWidth != 0 && c.MaxContentWidth < MinContentWidth {
+		return fmt.Errorf("--max-content-width must be 0 (full width) or at least %d, got %d", MinContentWidth, c.MaxContentWidth)
+	}
+
+	if c.LogFormat != "" && c.LogFormat != "text" && c.LogFormat != "json" {
+		return fmt.Errorf("--log-format must be text or json, got %q", c.LogFormat)
+	}
+
+	if c.StreamFormat != "" && c.StreamFormat != "jsonl" && c.StreamFormat != "sse" && c.StreamFormat != "concat" {
+		return fmt.Errorf("--stream-format must be jsonl, sse or concat, got %q", c.StreamFormat)
+	}
+
+	if _, ok := loop.LookupBackend(c.Agent); c.Agent != "" && !ok {
+		return fmt.Errorf("--agent must be one of %s, got %q", strings.Join(loop.BackendNames(), ", "), c.Agent)
+	}
+
+	if c.Listen != "" {
+		if _, err := control.ListenAddr(c.Listen, c.ListenToken); err != nil {
+			return err
+		}
+	}
+	if c.ListenToken != "" && c.Listen == "" {
+		return fmt.Errorf("--listen-token requires --listen")
+	}
+
+	for _, hook := range []struct{ flag, url string }{
+		{"--notify-webhook", c.NotifyWebhook},
+		{"--notify-slack", c.NotifySlack},
+		{"--notify-discord", c.NotifyDiscord},
+	} {
+		if hook.url == "" {
+			continue
+		}
+		if u, err := url.Parse(hook.url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s must be an http:// or https:// URL, got %q", hook.flag, hook.url)
+		}
+	}
+	if len(c.NotifyEvents) > 0 && c.NotifyWebhook == "" {
+		return fmt.Errorf("--notify-events requires --notify-webhook")
+	}
+	for _, e := range c.NotifyEvents {
+		switch e {
+		case "run_start", "iteration_complete", "error", "hibernate", "budget_exceeded", "run_complete":
+		default:
+			return fmt.Errorf("--notify-events must list run_start, iteration_complete, error, hibernate, budget_exceeded or run_complete, got %q", e)
+		}
+	}
+
+	if c.OtelEndpoint != "" {
+		if u, err := url.Parse(c.OtelEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("--otel-endpoint must be an http:// or https:// URL, got %q", c.OtelEndpoint)
+		}
+	}
+
+	if c.ThrashAction != "" && c.ThrashAction != "warn" && c.ThrashAction != "nudge" && c.ThrashAction != "stop" {
+		return fmt.Errorf("--thrash-action must be warn, nudge or stop, got %q", c.ThrashAction)
+	}
+
+	if c.TUILayout != "" && c.TUILayout != "top" && c.TUILayout != "bottom" {
+		return fmt.Errorf("--tui-layout must be top or bottom, got %q", c.TUILayout)
+	}
+
+	if (c.SpecSelect || len(c.Specs) > 0) && (c.SpecFile != "" || c.IsAutoresearchMode()) {
+		return fmt.Errorf("--spec-select and --specs choose files in the spec folder, so they can't be used with --spec-file or autoresearch")
+	}
+	if c.SpecSelect && len(c.Specs) > 0 {
+		return fmt.Errorf("--spec-select and --specs can't be used together")
+	}
+
 	if c.SpecFile != "" {
 		if err := c.validateFileExists(c.SpecFile, "--spec-file"); err != nil {
 			return err
@@ -212,6 +959,19 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if c.FirstPrompt != "" {
+		if c.IsPlanAndBuildMode() {
+			return fmt.Errorf("--first-prompt cannot be used with plan-and-build, which already plans first")
+		}
+		if err := c.validateFileExists(c.FirstPrompt, "--first-prompt"); err != nil {
+			return err
+		}
+	}
+
+	if c.PlanReview && !c.IsPlanAndBuildMode() {
+		return fmt.Errorf("--plan-review only applies to plan-and-build")
+	}
+
 	return nil
 }
 
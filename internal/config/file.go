@@ -0,0 +1,380 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ConfigFiles are the project config files ParseFlags looks for in the
+// working directory, in order; the first one found is read. Their settings
+// are flag names with their values, e.g. `iterations = 10` in ralph.toml or
+// `iterations: 10` in .ralph.yaml.
+var ConfigFiles = []string{"ralph.toml", ".ralph.yaml", ".ralph.yml"}
+
+// EnvPrefix starts the environment variables that set flags: RALPH_ and the
+// flag name in upper case with - as _, e.g. RALPH_MAX_COST_PER_HOUR.
+const EnvPrefix = "RALPH_"
+
+// repeatableFlags are the flags that add a value each time they are given,
+// rather than taking a comma-separated list. A list setting for one of them
+// becomes one flag per item.
+var repeatableFlags = map[string]bool{
+	"default-iterations": true,
+	"price":              true,
+	"redact-pattern":     true,
+	"var":                true,
+}
+
+// ProfileTable is the config file table holding named profiles:
+// [profile.nightly] in ralph.toml, or a nightly block under profile: in
+// .ralph.yaml.
+const ProfileTable = "profile"
+
+// setting is one key from a config file.
+type setting struct {
+	path   []string // tables or blocks, then the key, e.g. ["notify", "webhook"]
+	values []string // the value, or a list's items
+	list   bool
+	header bool // a table or block header, which sets nothing itself
+	line   int
+}
+
+// key returns the flag name s sets and the profile it belongs to ("" for
+// the base settings): [profile.nightly.notify] webhook is --notify-webhook
+// in profile nightly.
+func (s setting) key() (name, profile string) {
+	path := s.path
+	if len(path) > 1 && path[0] == ProfileTable {
+		profile, path = path[1], path[2:]
+	}
+	return strings.ReplaceAll(strings.Join(path, "-"), "_", "-"), profile
+}
+
+// FindConfigFile returns the first of ConfigFiles that exists in dir, or ""
+// when there is none.
+func FindConfigFile(dir string) string {
+	for _, name := range ConfigFiles {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// ReadConfigFileArgs reads a ralph.toml or .ralph.yaml config file and
+// returns its settings as arguments for fs, e.g. `max-cost-per-hour = 5`
+// becomes "--max-cost-per-hour=5". Keys may use _ for -, and a [table] (or
+// an indented YAML block) prefixes its keys, so webhook under [notify] sets
+// --notify-webhook. Keys that name no flag in fs are an error.
+//
+// Settings under [profile.<name>] apply only when profile is name, after the
+// base settings so they override them. A top-level profile key picks the
+// profile when profile is "". Naming a profile the file does not define is
+// an error.
+func ReadConfigFileArgs(fs *flag.FlagSet, path, profile string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var settings []setting
+	if strings.HasSuffix(path, ".toml") {
+		settings, err = parseTOML(string(data))
+	} else {
+		settings, err = parseYAML(string(data))
+	}
+	name := filepath.Base(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	var base, profiled []string
+	defined := map[string]bool{}
+	for _, s := range settings {
+		key, in := s.key()
+		if in != "" {
+			defined[in] = true
+		}
+		if s.header || key == "" {
+			continue
+		}
+		if key == "profile" && in == "" {
+			if profile == "" && len(s.values) == 1 {
+				profile = s.values[0]
+			}
+			continue
+		}
+		if fs.Lookup(key) == nil {
+			return nil, fmt.Errorf("%s:%d: unknown setting %q", name, s.line, key)
+		}
+		var args []string
+		switch {
+		case s.list && repeatableFlags[key]:
+			for _, v := range s.values {
+				args = append(args, "--"+key+"="+v)
+			}
+		default:
+			args = append(args, "--"+key+"="+strings.Join(s.values, ","))
+		}
+		switch in {
+		case "":
+			base = append(base, args...)
+		case profile:
+			profiled = append(profiled, args...)
+		}
+	}
+	if profile != "" && !defined[profile] {
+		return nil, fmt.Errorf("%s: no profile %q (define it as [%s.%s])", name, profile, ProfileTable, profile)
+	}
+	return append(base, profiled...), nil
+}
+
+// FlagArg returns the value of the last --name flag in args, or "". It lets
+// ParseFlags find --config and --profile before the flags are parsed.
+func FlagArg(args []string, name string) string {
+	value := ""
+	for i, a := range args {
+		n, v, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if !strings.HasPrefix(a, "-") || n != name {
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			v = args[i+1]
+		}
+		value = v
+	}
+	return value
+}
+
+// EnvArgs returns arguments for the flags in fs set by environment variables
+// (see EnvPrefix), looked up with getenv. Variables that are unset or empty
+// are skipped.
+func EnvArgs(fs *flag.FlagSet, getenv func(string) string) []string {
+	var args []string
+	fs.VisitAll(func(f *flag.Flag) {
+		name := EnvPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if v := getenv(name); v != "" {
+			args = append(args, "--"+f.Name+"="+v)
+		}
+	})
+	return args
+}
+
+// parseTOML parses the subset of TOML a flat config needs: key = value
+// pairs, [table] and [table.sub] headers, # comments, and strings, numbers,
+// booleans and single-line arrays of them.
+func parseTOML(data string) ([]setting, error) {
+	var settings []setting
+	var table []string
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: malformed table header %q", i+1, line)
+			}
+			table = nil
+			for _, part := range strings.Split(strings.Trim(line, "[] "), ".") {
+				table = append(table, strings.TrimSpace(part))
+			}
+			settings = append(settings, setting{path: table, header: true, line: i + 1})
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("line %d: want key = value, got %q", i+1, line)
+		}
+		s := setting{path: append(append([]string{}, table...), strings.TrimSpace(key)), line: i + 1}
+		value = strings.TrimSpace(value)
+		var err error
+		if strings.HasPrefix(value, "[") {
+			s.list = true
+			s.values, err = parseArray(value, parseTOMLValue)
+		} else {
+			var v string
+			v, err = parseTOMLValue(value)
+			s.values = []string{v}
+		}
+		if err != nil {
+			k, _ := s.key()
+			return nil, fmt.Errorf("line %d: %s: %w", i+1, k, err)
+		}
+		settings = append(settings, s)
+	}
+	return settings, nil
+}
+
+// parseTOMLValue parses a TOML string, number or boolean.
+func parseTOMLValue(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`) || strings.HasPrefix(v, `'`):
+		return unquote(v)
+	case v == "true" || v == "false":
+		return v, nil
+	}
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(v, "_", ""), 64); err == nil {
+		return strings.ReplaceAll(v, "_", ""), nil
+	}
+	return "", fmt.Errorf("want a quoted string, number or true/false, got %q", v)
+}
+
+// parseYAML parses the subset of YAML a flat config needs: key: value pairs,
+// indented blocks of keys, # comments, quoted or plain scalars, and lists as
+// [a, b] or "- item" lines.
+func parseYAML(data string) ([]setting, error) {
+	type block struct {
+		indent int
+		key    string
+	}
+	var settings []setting
+	var blocks []block // the blocks enclosing the current line
+	var open *setting  // a key with no value: a block, or a list of "- item" lines
+	closeOpen := func() {
+		if open != nil {
+			open.header = open.values == nil
+			settings = append(settings, *open)
+			open = nil
+		}
+	}
+	for i, raw := range strings.Split(data, "\n") {
+		line := strings.TrimRight(stripComment(raw), " \t\r")
+		if strings.TrimSpace(line) == "" || line == "---" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		line = strings.TrimSpace(line)
+
+		if item, ok := strings.CutPrefix(line, "- "); ok || line == "-" {
+			if open == nil {
+				return nil, fmt.Errorf("line %d: list item outside a list", i+1)
+			}
+			v, err := unquote(strings.TrimSpace(item))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			open.values = append(open.values, v)
+			continue
+		}
+		closeOpen()
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("line %d: want key: value, got %q", i+1, line)
+		}
+		for len(blocks) > 0 && blocks[len(blocks)-1].indent >= indent {
+			blocks = blocks[:len(blocks)-1]
+		}
+		var path []string
+		for _, b := range blocks {
+			path = append(path, b.key)
+		}
+		key = strings.TrimSpace(key)
+		s := setting{path: append(path, key), line: i + 1}
+		value = strings.TrimSpace(value)
+		switch {
+		case value == "":
+			blocks = append(blocks, block{indent, key})
+			s.list = true
+			open = &s
+			continue
+		case strings.HasPrefix(value, "["):
+			s.list = true
+			var err error
+			if s.values, err = parseArray(value, unquote); err != nil {
+				k, _ := s.key()
+				return nil, fmt.Errorf("line %d: %s: %w", i+1, k, err)
+			}
+		default:
+			v, err := unquote(value)
+			if err != nil {
+				k, _ := s.key()
+				return nil, fmt.Errorf("line %d: %s: %w", i+1, k, err)
+			}
+			s.values = []string{v}
+		}
+		settings = append(settings, s)
+	}
+	closeOpen()
+	return settings, nil
+}
+
+// parseArray parses a single-line [a, b, c] array, parsing each item with
+// item.
+func parseArray(v string, item func(string) (string, error)) ([]string, error) {
+	if !strings.HasSuffix(v, "]") {
+		return nil, fmt.Errorf("arrays must close on the same line, got %q", v)
+	}
+	inner := strings.TrimSpace(v[1 : len(v)-1])
+	values := []string{}
+	if inner == "" {
+		return values, nil
+	}
+	for _, part := range splitOutsideQuotes(inner, ',') {
+		if part = strings.TrimSpace(part); part == "" {
+			continue // trailing comma
+		}
+		s, err := item(part)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, s)
+	}
+	return values, nil
+}
+
+// unquote strips a double-quoted (with escapes) or single-quoted (literal)
+// string's quotes; other values are returned as they are.
+func unquote(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		s, err := strconv.Unquote(v)
+		if err != nil {
+			return "", fmt.Errorf("malformed string %s", v)
+		}
+		return s, nil
+	case strings.HasPrefix(v, `'`):
+		if len(v) < 2 || !strings.HasSuffix(v, `'`) {
+			return "", fmt.Errorf("malformed string %s", v)
+		}
+		return v[1 : len(v)-1], nil
+	}
+	return v, nil
+}
+
+// stripComment removes a # comment from line, leaving # inside quotes.
+func stripComment(line string) string {
+	parts := splitOutsideQuotes(line, '#')
+	return parts[0]
+}
+
+// splitOutsideQuotes splits s at each sep that is not inside quotes.
+func splitOutsideQuotes(s string, sep rune) []string {
+	var parts []string
+	var quote rune
+	start := 0
+	escaped := false
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
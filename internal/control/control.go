@@ -0,0 +1,350 @@
+// Package control serves the --listen HTTP API for steering a running ralph
+// from scripts or another machine:
+//
+//	GET  /status          run state, iteration and stats as JSON
+//	GET  /messages?n=50   the most recent run log entries
+//	GET  /events          run log entries as they happen (server-sent events)
+//	GET  /metrics         status and stats for Prometheus
+//	POST /pause           pause once the current iteration finishes; again, or
+//	                      with ?now=true, interrupt it and pause now
+//	POST /resume          resume a paused or completed loop, or wake a hibernating one
+//	POST /stop            stop the loop
+//	POST /iterations?n=N  set the total iterations (not below the current one)
+//
+// Errors are returned as {"error": "..."} with a 4xx or 5xx status.
+package control
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudosai/ralph-go/internal/loop"
+	"github.com/cloudosai/ralph-go/internal/runlog"
+	"github.com/cloudosai/ralph-go/internal/stats"
+)
+
+// maxRecent is how many run log entries /messages can return.
+const maxRecent = 500
+
+// subscriberBuffer is how many entries an /events client may fall behind
+// before it misses some.
+const subscriberBuffer = 64
+
+// Status is the /status response.
+type Status struct {
+	RunID          string         `json:"run_id"`
+	State          string         `json:"state"` // "starting", "running", "pausing", "paused", "hibernating", "complete" or "stopped"
+	Iteration      int            `json:"iteration"`
+	Total          int            `json:"total"`
+	HibernateUntil *time.Time     `json:"hibernate_until,omitempty"`
+	Stats          stats.Snapshot `json:"stats"`
+}
+
+// Server is the control API for one run. It follows the run through the run
+// log entries given to Record and acts on the loop given to SetLoop. A nil
+// *Server ignores everything, so callers never need to check whether
+// --listen is set.
+type Server struct {
+	runID    string
+	token    string
+	snapshot func() stats.Snapshot
+
+	mu          sync.Mutex
+	loop        *loop.Loop
+	iteration   int
+	recent      []runlog.Entry
+	subscribers map[chan runlog.Entry]struct{}
+	iterations  int // completed, see CountIteration
+	errors      int // see CountError
+}
+
+// New returns a Server for runID reporting the stats snapshot returns. With
+// a token, requests must carry "Authorization: Bearer <token>".
+func New(runID, token string, snapshot func() stats.Snapshot) *Server {
+	return &Server{
+		runID:       runID,
+		token:       token,
+		snapshot:    snapshot,
+		subscribers: make(map[chan runlog.Entry]struct{}),
+	}
+}
+
+// SetLoop makes l the loop the API controls, e.g. when plan-and-build moves
+// on to its build phase.
+func (s *Server) SetLoop(l *loop.Loop) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loop = l
+}
+
+// Record notes a run log entry for /messages and /events.
+func (s *Server) Record(e runlog.Entry) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e.Iteration > 0 {
+		s.iteration = e.Iteration
+	}
+	s.recent = append(s.recent, e)
+	if len(s.recent) > maxRecent {
+		s.recent = s.recent[len(s.recent)-maxRecent:]
+	}
+	for ch := range s.subscribers {
+		select {
+		case ch <- e:
+		default: // the client is too slow; it misses this entry
+		}
+	}
+}
+
+// Listen serves the API on addr, e.g. ":7777", until stop is called. It
+// returns the address actually listened on. An address without a host listens
+// on 127.0.0.1 only; one reachable from other machines needs a token.
+func (s *Server) Listen(addr string) (bound string, stop func(), err error) {
+	addr, err = ListenAddr(addr, s.token)
+	if err != nil {
+		return "", nil, err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", nil, fmt.Errorf("--listen: %w", err)
+	}
+	srv := &http.Server{Handler: s.Handler()}
+	go srv.Serve(ln)
+	return ln.Addr().String(), func() { srv.Close() }, nil
+}
+
+// ListenAddr returns the address --listen serves on: addr, on 127.0.0.1 when
+// it names no host. Anyone who can reach the API can stop the run, so an
+// address other than a loopback one is refused without a token.
+func ListenAddr(addr, token string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("--listen must be a host:port address such as :7777, got %q", addr)
+	}
+	if host == "" {
+		return net.JoinHostPort("127.0.0.1", port), nil
+	}
+	if token == "" && !isLoopback(host) {
+		return "", fmt.Errorf("--listen %s is reachable from other machines: set --listen-token, or listen on 127.0.0.1", addr)
+	}
+	return addr, nil
+}
+
+// isLoopback reports whether host, a name or an IP address, is this machine
+// only.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Handler returns the API's HTTP handler.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", s.handleStatus)
+	mux.HandleFunc("GET /messages", s.handleMessages)
+	mux.HandleFunc("GET /events", s.handleEvents)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	mux.HandleFunc("POST /pause", s.withLoop(pause))
+	mux.HandleFunc("POST /resume", s.withLoop(func(l *loop.Loop, r *http.Request) error {
+		if l.IsHibernating() {
+			l.Wake()
+		} else {
+			l.Resume()
+		}
+		return nil
+	}))
+	mux.HandleFunc("POST /stop", s.withLoop(func(l *loop.Loop, r *http.Request) error {
+		l.Stop()
+		return nil
+	}))
+	mux.HandleFunc("POST /iterations", s.withLoop(s.setIterations))
+	return s.authorize(mux)
+}
+
+// authorize rejects requests without the bearer token, when one is set.
+// Without one, it rejects requests a browser sends on behalf of a web page
+// (they carry an Origin), so a page can't steer a run on localhost.
+func (s *Server) authorize(next http.Handler) http.Handler {
+	want := []byte("Bearer " + s.token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			writeError(w, http.StatusUnauthorized, "missing or wrong bearer token")
+			return
+		}
+		if s.token == "" && r.Header.Get("Origin") != "" {
+			writeError(w, http.StatusForbidden, "browser requests need --listen-token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withLoop adapts a loop action to a handler that replies with the status
+// after it. It fails with 503 before the loop has started.
+func (s *Server) withLoop(action func(*loop.Loop, *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		l := s.loop
+		s.mu.Unlock()
+		if l == nil {
+			writeError(w, http.StatusServiceUnavailable, "the loop has not started yet")
+			return
+		}
+		if err := action(l, r); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, s.status())
+	}
+}
+
+// pause handles /pause. Like the TUI's p key, it lets the current iteration
+// finish unless a pause is already pending or ?now=true is given.
+func pause(l *loop.Loop, r *http.Request) error {
+	now := false
+	if v := r.URL.Query().Get("now"); v != "" {
+		var err error
+		if now, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("now must be true or false, got %q", v)
+		}
+	}
+	if now || l.IsPausePending() {
+		l.Pause()
+	} else {
+		l.PauseAfterIteration()
+	}
+	return nil
+}
+
+// setIterations handles /iterations?n=N. Like the TUI's - key, it cannot go
+// below the iteration that is running.
+func (s *Server) setIterations(l *loop.Loop, r *http.Request) error {
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n < 1 {
+		return fmt.Errorf("n must be a positive number of iterations, got %q", r.URL.Query().Get("n"))
+	}
+	s.mu.Lock()
+	current := s.iteration
+	s.mu.Unlock()
+	if n < current {
+		return fmt.Errorf("n must not be below the current iteration %d, got %d", current, n)
+	}
+	l.SetIterations(n)
+	return nil
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.status())
+}
+
+// status reports the run as it is now.
+func (s *Server) status() Status {
+	s.mu.Lock()
+	l, iteration := s.loop, s.iteration
+	s.mu.Unlock()
+	st := Status{RunID: s.runID, State: "starting", Iteration: iteration}
+	if s.snapshot != nil {
+		st.Stats = s.snapshot()
+	}
+	if l == nil {
+		return st
+	}
+	st.Total = l.GetIterations()
+	switch {
+	case l.IsHibernating():
+		st.State = "hibernating"
+		until := l.GetHibernateUntil()
+		st.HibernateUntil = &until
+	case l.IsPaused():
+		st.State = "paused"
+	case l.IsPausePending():
+		st.State = "pausing"
+	case l.IsCompletedWaiting():
+		st.State = "complete"
+	case l.IsRunning():
+		st.State = "running"
+	default:
+		st.State = "stopped"
+	}
+	return st
+}
+
+// handleMessages returns the last n run log entries (default 50), oldest
+// first.
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	n := 50
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("n must be a positive number of messages, got %q", v))
+			return
+		}
+	}
+	s.mu.Lock()
+	recent := s.recent[max(len(s.recent)-n, 0):]
+	entries := append([]runlog.Entry{}, recent...)
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// handleEvents streams run log entries as server-sent events until the
+// client goes away.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	ch := make(chan runlog.Entry, subscriberBuffer)
+	s.mu.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.subscribers, ch)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-ch:
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
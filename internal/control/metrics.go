@@ -0,0 +1,84 @@
+package control
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// states are the values of Status.State, each reported by /metrics as a
+// ralph_state sample that is 1 for the current state and 0 for the others.
+var states = []string{"starting", "running", "pausing", "paused", "hibernating", "complete", "stopped"}
+
+// sample is one value of a metric, with its labels in exposition form, e.g.
+// `{type="input"}` ("" = none).
+type sample struct {
+	labels string
+	value  float64
+}
+
+// CountIteration notes a completed iteration for /metrics.
+func (s *Server) CountIteration() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.iterations++
+}
+
+// CountError notes an iteration error for /metrics.
+func (s *Server) CountError() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors++
+}
+
+// handleMetrics serves the run's status and stats in the Prometheus text
+// exposition format. Like /status, cost, token and hibernation counts are
+// the project's recorded totals, this run included.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	st := s.status()
+	s.mu.Lock()
+	iterations, errors := s.iterations, s.errors
+	s.mu.Unlock()
+
+	stateSamples := make([]sample, len(states))
+	for i, state := range states {
+		stateSamples[i] = sample{fmt.Sprintf("{state=%q}", state), 0}
+		if state == st.State {
+			stateSamples[i].value = 1
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetric(w, "ralph_info", "gauge", "The run being reported, by run ID.",
+		sample{fmt.Sprintf("{run_id=%q}", s.runID), 1})
+	writeMetric(w, "ralph_state", "gauge", "Whether the run is in each state.", stateSamples...)
+	writeMetric(w, "ralph_iteration", "gauge", "The iteration running or last run.", sample{"", float64(st.Iteration)})
+	writeMetric(w, "ralph_iterations", "gauge", "The iterations planned for the run.", sample{"", float64(st.Total)})
+	writeMetric(w, "ralph_iterations_completed_total", "counter", "Iterations completed by the run.", sample{"", float64(iterations)})
+	writeMetric(w, "ralph_errors_total", "counter", "Iteration errors in the run.", sample{"", float64(errors)})
+	writeMetric(w, "ralph_cost_usd_total", "counter", "Agent cost in USD recorded for the project.", sample{"", st.Stats.TotalCostUSD})
+	writeMetric(w, "ralph_tokens_total", "counter", "Tokens recorded for the project, by type.",
+		sample{`{type="input"}`, float64(st.Stats.InputTokens)},
+		sample{`{type="output"}`, float64(st.Stats.OutputTokens)},
+		sample{`{type="cache_creation"}`, float64(st.Stats.CacheCreationTokens)},
+		sample{`{type="cache_read"}`, float64(st.Stats.CacheReadTokens)})
+	writeMetric(w, "ralph_hibernations_total", "counter", "Rate-limit hibernations recorded for the project.", sample{"", float64(st.Stats.Hibernations)})
+	writeMetric(w, "ralph_hibernate_seconds_total", "counter", "Time spent in rate-limit hibernations recorded for the project.",
+		sample{"", time.Duration(st.Stats.HibernateNs).Seconds()})
+}
+
+// writeMetric writes a metric's HELP and TYPE lines followed by its samples.
+func writeMetric(w io.Writer, name, kind, help string, samples ...sample) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, s.labels, strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+}
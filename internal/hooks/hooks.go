@@ -0,0 +1,95 @@
+// Package hooks runs user-supplied shell commands around loop iterations.
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds a hook when no timeout is configured.
+const DefaultTimeout = 10 * time.Minute
+
+// maxOutputLines is how many trailing lines of hook output Tail keeps.
+const maxOutputLines = 20
+
+// HookResult is the outcome of one hook run.
+type HookResult struct {
+	Command  string
+	ExitCode int    // -1 if the command could not be started or was killed
+	Output   string // combined stdout and stderr
+	Duration time.Duration
+	TimedOut bool
+	Err      error // set when the command did not exit 0
+}
+
+// Passed reports whether the hook exited 0 within its timeout.
+func (r HookResult) Passed() bool {
+	return r.Err == nil
+}
+
+// Status describes the outcome in a few words, e.g. "passed" or "failed (exit 2)".
+func (r HookResult) Status() string {
+	switch {
+	case r.Passed():
+		return "passed"
+	case r.TimedOut:
+		return "timed out"
+	case r.ExitCode >= 0:
+		return fmt.Sprintf("failed (exit %d)", r.ExitCode)
+	default:
+		return fmt.Sprintf("failed (%v)", r.Err)
+	}
+}
+
+// Tail returns the last lines of the hook's output, trimmed of surrounding
+// whitespace, with a note of how many earlier lines were dropped.
+func (r HookResult) Tail() string {
+	out := strings.TrimSpace(r.Output)
+	if out == "" {
+		return ""
+	}
+	lines := strings.Split(out, "\n")
+	if len(lines) <= maxOutputLines {
+		return out
+	}
+	dropped := len(lines) - maxOutputLines
+	return fmt.Sprintf("…(%d earlier lines)\n%s", dropped, strings.Join(lines[dropped:], "\n"))
+}
+
+// Run executes command with sh -c in dir ("" = the current directory) and
+// waits up to timeout (0 = DefaultTimeout) for it to finish. A hook that
+// outlives its timeout, or ctx, is killed and reported as failed.
+func Run(ctx context.Context, command, dir string, timeout time.Duration) HookResult {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = dir
+	// Don't wait forever on background children still holding the output pipe
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	res := HookResult{
+		Command:  command,
+		ExitCode: -1,
+		Output:   string(out),
+		Duration: time.Since(start),
+		Err:      err,
+	}
+	if cmd.ProcessState != nil {
+		res.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		res.TimedOut = true
+		res.Err = fmt.Errorf("hook timed out after %s", timeout)
+	}
+	return res
+}
@@ -0,0 +1,94 @@
+// Package lockfile keeps two ralph runs from sharing a working directory.
+package lockfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Name is the lock file ralph creates in the working directory.
+const Name = ".ralph.lock"
+
+// ErrHeld is returned by Acquire when a live process holds the lock.
+var ErrHeld = errors.New("lock held by a running process")
+
+// Lock is a held PID lock file. A nil *Lock releases nothing.
+type Lock struct {
+	path string
+	pid  int
+}
+
+// Acquire creates the lock file at path holding this process's PID. A lock
+// left by a process that has exited is stale and taken over. A lock held by
+// a live process fails with ErrHeld unless force is set.
+func Acquire(path string, force bool) (*Lock, error) {
+	pid := os.Getpid()
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, werr := fmt.Fprintf(f, "%d\n", pid)
+			if cerr := f.Close(); werr == nil {
+				werr = cerr
+			}
+			if werr != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("writing lock %s: %w", path, werr)
+			}
+			return &Lock{path: path, pid: pid}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("creating lock %s: %w", path, err)
+		}
+
+		holder, _ := readPID(path)
+		if holder != pid && alive(holder) && !force {
+			return nil, fmt.Errorf("%w: pid %d holds %s", ErrHeld, holder, path)
+		}
+		// Stale, ours, or overridden: clear it and try again
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("removing stale lock %s: %w", path, err)
+		}
+	}
+	return nil, fmt.Errorf("%w: %s was re-created while taking it over", ErrHeld, path)
+}
+
+// Release removes the lock file if this lock still owns it; a lock taken
+// over with force by another run is left alone.
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	if holder, err := readPID(l.path); err != nil || holder != l.pid {
+		return nil
+	}
+	if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// alive reports whether a process with the given PID exists.
+func alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// readPID returns the PID recorded in the lock file at path.
+func readPID(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("lock %s does not hold a PID: %w", path, err)
+	}
+	return pid, nil
+}
@@ -0,0 +1,361 @@
+package loop
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// CostModel says where a backend's iteration cost comes from.
+type CostModel int
+
+const (
+	// CostReported: result messages carry the iteration's total_cost_usd.
+	CostReported CostModel = iota
+	// CostEstimated: the agent reports token usage, which is priced to
+	// estimate the cost.
+	CostEstimated
+	// CostUnknown: the agent reports neither cost nor usage.
+	CostUnknown
+)
+
+// String returns the cost model's name.
+func (c CostModel) String() string {
+	switch c {
+	case CostReported:
+		return "reported"
+	case CostEstimated:
+		return "estimated"
+	default:
+		return "unknown"
+	}
+}
+
+// Backend is an agent CLI the loop can drive. Its output is translated into
+// Claude stream-json records, so everything downstream of the loop (parser,
+// TUI, stats) handles every backend the same way.
+type Backend interface {
+	// Name is the --agent value selecting the backend.
+	Name() string
+	// BuildCommand creates the agent command for one iteration. The prompt
+	// is also written to the command's stdin.
+	BuildCommand(ctx context.Context, prompt string) *exec.Cmd
+	// ParseLine translates one record of the agent's stdout into zero or
+	// more stream-json records.
+	ParseLine(line string) []string
+	// SupportsResume reports whether the agent continues a session given
+	// "--resume <session ID>".
+	SupportsResume() bool
+	// CostModel says how the agent's cost is known.
+	CostModel() CostModel
+}
+
+// backends is the registry of agent backends, keyed by name.
+var backends = registerBackends(ClaudeBackend{}, CursorAgentBackend{}, CodexBackend{}, AiderBackend{})
+
+func registerBackends(list ...Backend) map[string]Backend {
+	m := make(map[string]Backend, len(list))
+	for _, b := range list {
+		m[b.Name()] = b
+	}
+	return m
+}
+
+// LookupBackend returns the backend registered under name.
+func LookupBackend(name string) (Backend, bool) {
+	b, ok := backends[name]
+	return b, ok
+}
+
+// BackendNames returns the registered backend names, sorted.
+func BackendNames() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ClaudeBackend drives the Claude CLI, whose stream-json output needs no
+// translation.
+type ClaudeBackend struct{}
+
+func (ClaudeBackend) Name() string { return "claude" }
+
+func (ClaudeBackend) BuildCommand(ctx context.Context, prompt string) *exec.Cmd {
+	return DefaultCommandBuilder(ctx, prompt)
+}
+
+func (ClaudeBackend) ParseLine(line string) []string { return []string{line} }
+func (ClaudeBackend) SupportsResume() bool           { return true }
+func (ClaudeBackend) CostModel() CostModel           { return CostReported }
+
+// CursorAgentBackend drives cursor-agent. Its stream-json matches Claude's
+// except for tool calls, which arrive as tool_call started/completed events.
+type CursorAgentBackend struct{}
+
+func (CursorAgentBackend) Name() string { return "cursor-agent" }
+
+func (CursorAgentBackend) BuildCommand(ctx context.Context, prompt string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "cursor-agent",
+		"--print",
+		"--output-format", "stream-json",
+		"--force",
+		prompt,
+	)
+	cmd.Env = isolatedTmuxEnv()
+	return cmd
+}
+
+// cursorToolNames maps cursor-agent tool call keys to the Claude tool names
+// the parser classifies.
+var cursorToolNames = map[string]string{
+	"readToolCall":   "Read",
+	"writeToolCall":  "Write",
+	"editToolCall":   "Edit",
+	"deleteToolCall": "Delete",
+	"shellToolCall":  "Bash",
+	"grepToolCall":   "Grep",
+	"globToolCall":   "Glob",
+	"lsToolCall":     "LS",
+	"todoToolCall":   "TodoWrite",
+}
+
+// cursorArgNames maps cursor-agent tool arguments to their Claude names.
+var cursorArgNames = map[string]string{
+	"path":        "file_path",
+	"globPattern": "pattern",
+}
+
+func (CursorAgentBackend) ParseLine(line string) []string {
+	var event struct {
+		Type      string                     `json:"type"`
+		Subtype   string                     `json:"subtype"`
+		CallID    string                     `json:"call_id"`
+		SessionID string                     `json:"session_id"`
+		ToolCall  map[string]json.RawMessage `json:"tool_call"`
+	}
+	if err := json.Unmarshal([]byte(line), &event); err != nil {
+		return []string{line}
+	}
+	switch event.Type {
+	case "tool_call":
+	case "thinking":
+		return nil // streamed deltas; the assistant message carries the text
+	default:
+		return []string{line}
+	}
+	for key, raw := range event.ToolCall {
+		var call struct {
+			Args   map[string]interface{}            `json:"args"`
+			Result map[string]map[string]interface{} `json:"result"`
+		}
+		json.Unmarshal(raw, &call)
+		switch event.Subtype {
+		case "started":
+			name, ok := cursorToolNames[key]
+			if !ok {
+				name = strings.TrimSuffix(key, "ToolCall")
+			}
+			input := make(map[string]interface{}, len(call.Args))
+			for k, v := range call.Args {
+				if alias, ok := cursorArgNames[k]; ok {
+					k = alias
+				}
+				input[k] = v
+			}
+			return []string{toolUseRecord(event.SessionID, event.CallID, name, input)}
+		case "completed":
+			content, isError := cursorToolResult(call.Result)
+			return []string{toolResultRecord(event.SessionID, event.CallID, content, isError)}
+		}
+	}
+	return nil
+}
+
+// cursorToolResult returns the text of a completed cursor-agent tool call:
+// its content or output on success, its message on failure.
+func cursorToolResult(result map[string]map[string]interface{}) (string, bool) {
+	for outcome, fields := range result {
+		for _, key := range []string{"content", "stdout", "message", "error"} {
+			if s, ok := fields[key].(string); ok && s != "" {
+				return s, outcome != "success"
+			}
+		}
+		b, _ := json.Marshal(fields)
+		return string(b), outcome != "success"
+	}
+	return "", false
+}
+
+func (CursorAgentBackend) SupportsResume() bool { return true }
+func (CursorAgentBackend) CostModel() CostModel { return CostUnknown }
+
+// CodexBackend drives `codex exec --json`, translating its thread, item and
+// turn events.
+type CodexBackend struct{}
+
+func (CodexBackend) Name() string { return "codex" }
+
+func (CodexBackend) BuildCommand(ctx context.Context, prompt string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "codex", "exec",
+		"--json",
+		"--dangerously-bypass-approvals-and-sandbox",
+		"-", // read the prompt from stdin
+	)
+	cmd.Env = isolatedTmuxEnv()
+	return cmd
+}
+
+func (CodexBackend) ParseLine(line string) []string {
+	var event struct {
+		Type     string `json:"type"`
+		ThreadID string `json:"thread_id"`
+		Item     struct {
+			ID               string `json:"id"`
+			Type             string `json:"type"`
+			Text             string `json:"text"`
+			Command          string `json:"command"`
+			AggregatedOutput string `json:"aggregated_output"`
+			ExitCode         *int   `json:"exit_code"`
+			Changes          []struct {
+				Path string `json:"path"`
+			} `json:"changes"`
+		} `json:"item"`
+		Usage struct {
+			InputTokens       int64 `json:"input_tokens"`
+			CachedInputTokens int64 `json:"cached_input_tokens"`
+			OutputTokens      int64 `json:"output_tokens"`
+		} `json:"usage"`
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(line), &event); err != nil {
+		return textRecords(line)
+	}
+	item := event.Item
+	switch event.Type {
+	case "thread.started":
+		return []string{marshalRecord(map[string]interface{}{"type": "system", "subtype": "init", "session_id": event.ThreadID})}
+	case "item.started":
+		if item.Type == "command_execution" {
+			return []string{toolUseRecord("", item.ID, "Bash", map[string]interface{}{"command": item.Command})}
+		}
+	case "item.completed":
+		switch item.Type {
+		case "agent_message":
+			return []string{assistantRecord("", map[string]interface{}{"type": "text", "text": item.Text})}
+		case "reasoning":
+			return []string{assistantRecord("", map[string]interface{}{"type": "thinking", "thinking": item.Text})}
+		case "command_execution":
+			failed := item.ExitCode != nil && *item.ExitCode != 0
+			return []string{toolResultRecord("", item.ID, item.AggregatedOutput, failed)}
+		case "file_change":
+			var records []string
+			for i, change := range item.Changes {
+				id := fmt.Sprintf("%s_%d", item.ID, i)
+				records = append(records,
+					toolUseRecord("", id, "Edit", map[string]interface{}{"file_path": change.Path}),
+					toolResultRecord("", id, "updated "+change.Path, false))
+			}
+			return records
+		}
+	case "turn.completed":
+		usage := marshalRecord(map[string]interface{}{
+			"type": "assistant",
+			"message": map[string]interface{}{
+				"content": []interface{}{},
+				"usage": map[string]int64{
+					"input_tokens":            event.Usage.InputTokens - event.Usage.CachedInputTokens,
+					"cache_read_input_tokens": event.Usage.CachedInputTokens,
+					"output_tokens":           event.Usage.OutputTokens,
+				},
+			},
+		})
+		return []string{usage, marshalRecord(map[string]interface{}{"type": "result", "subtype": "success"})}
+	case "turn.failed":
+		return []string{marshalRecord(map[string]interface{}{
+			"type": "result", "subtype": "error_during_execution", "is_error": true, "result": event.Error.Message,
+		})}
+	}
+	return nil
+}
+
+func (CodexBackend) SupportsResume() bool { return false }
+func (CodexBackend) CostModel() CostModel { return CostEstimated }
+
+// AiderBackend drives aider, whose output is plain text: each line is shown
+// as assistant text, and "Applied edit to <file>" as an edit.
+type AiderBackend struct{}
+
+func (AiderBackend) Name() string { return "aider" }
+
+func (AiderBackend) BuildCommand(ctx context.Context, prompt string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "aider",
+		"--yes-always",
+		"--no-pretty",
+		"--no-stream",
+		"--message", prompt,
+	)
+	cmd.Env = isolatedTmuxEnv()
+	return cmd
+}
+
+func (AiderBackend) ParseLine(line string) []string {
+	if path, ok := strings.CutPrefix(strings.TrimSpace(line), "Applied edit to "); ok {
+		return []string{
+			toolUseRecord("", "edit:"+path, "Edit", map[string]interface{}{"file_path": path}),
+			toolResultRecord("", "edit:"+path, line, false),
+		}
+	}
+	return textRecords(line)
+}
+
+func (AiderBackend) SupportsResume() bool { return false }
+func (AiderBackend) CostModel() CostModel { return CostUnknown }
+
+// textRecords wraps a non-blank line of plain output as assistant text.
+func textRecords(line string) []string {
+	if strings.TrimSpace(line) == "" {
+		return nil
+	}
+	return []string{assistantRecord("", map[string]interface{}{"type": "text", "text": line})}
+}
+
+func assistantRecord(sessionID string, content ...interface{}) string {
+	return marshalRecord(withSession(sessionID, map[string]interface{}{
+		"type":    "assistant",
+		"message": map[string]interface{}{"content": content},
+	}))
+}
+
+func toolUseRecord(sessionID, id, name string, input map[string]interface{}) string {
+	return assistantRecord(sessionID, map[string]interface{}{"type": "tool_use", "id": id, "name": name, "input": input})
+}
+
+func toolResultRecord(sessionID, id, content string, isError bool) string {
+	return marshalRecord(withSession(sessionID, map[string]interface{}{
+		"type": "user",
+		"message": map[string]interface{}{"content": []interface{}{map[string]interface{}{
+			"type": "tool_result", "tool_use_id": id, "content": content, "is_error": isError,
+		}}},
+	}))
+}
+
+// withSession sets record's session_id when there is one.
+func withSession(sessionID string, record map[string]interface{}) map[string]interface{} {
+	if sessionID != "" {
+		record["session_id"] = sessionID
+	}
+	return record
+}
+
+func marshalRecord(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -13,6 +13,7 @@ const (
 	DefaultMaxBackoff     = 10 * time.Minute
 	DefaultMaxRetries     = 8
 	DefaultJitterFraction = 0.2 // ±20% jitter
+	DefaultRetryBackoff   = 10 * time.Second // first delay before retrying a failed iteration
 )
 
 // Backoff tracks exponential backoff state for API 529 (overloaded) errors.
@@ -0,0 +1,68 @@
+package loop
+
+import (
+	"fmt"
+
+	"github.com/cloudosai/ralph-go/internal/vcs"
+)
+
+// GitMarker tags the loop_marker reporting what an iteration committed (see
+// Config.CommitReport).
+const GitMarker = "GIT"
+
+// commitBase records HEAD before an iteration for commitReport. ok is false
+// when reports are off or the working directory is not a git repository.
+func (l *Loop) commitBase() (head string, ok bool) {
+	if !l.config.CommitReport {
+		return "", false
+	}
+	head, err := vcs.HeadCommit("")
+	return head, err == nil
+}
+
+// reportChanges sends iteration i's commit report when base was read
+// (inRepo), and otherwise, with Config.CommitReport, the report of the lines
+// its edits changed.
+func (l *Loop) reportChanges(i int, base string, inRepo bool) {
+	switch {
+	case inRepo:
+		l.commitReport(i, base)
+	case l.config.CommitReport:
+		l.editReport(i, l.lastOutput)
+	}
+}
+
+// commitReport sends a GIT loop_marker with what iteration i committed since
+// base, outside Config.ExcludeDirs, its Changes attached. An iteration that made no commit is reported,
+// as a warning, only with Config.WarnNoCommit and only when it left changes
+// uncommitted: one with nothing to change is not warned about.
+func (l *Loop) commitReport(i int, base string) {
+	head, err := vcs.HeadCommit("")
+	if err != nil {
+		return
+	}
+	// With HEAD where it was, skip the diff unless there is a warning to give
+	if !vcs.LastCommitChanged(base, head) {
+		if !l.config.WarnNoCommit {
+			return
+		}
+		if forgot, err := vcs.ForgotToCommit("", base, head, l.config.ExcludeDirs); err != nil || !forgot {
+			return
+		}
+	}
+	changes, err := vcs.ChangesSince("", base, l.config.ExcludeDirs)
+	if err != nil || (changes.Commits == 0 && !l.config.WarnNoCommit) {
+		return
+	}
+	content := fmt.Sprintf("======= %s: %s =======", GitMarker, changes)
+	if changes.Commits == 0 {
+		content = fmt.Sprintf("======= %s WARNING: %s =======", GitMarker, changes)
+	}
+	l.output <- Message{
+		Type:    "loop_marker",
+		Content: content,
+		Loop:    i,
+		Total:   l.GetIterations(),
+		Changes: &changes,
+	}
+}
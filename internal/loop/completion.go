@@ -0,0 +1,112 @@
+package loop
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// editTools are the tool calls that change files. An iteration without any of
+// them counts towards Config.DoneAfterIdle.
+var editTools = map[string]bool{
+	"Write":        true,
+	"Edit":         true,
+	"MultiEdit":    true,
+	"NotebookEdit": true,
+}
+
+// iterationOutput is what the stream readers noticed in an iteration's
+// output. The stdout and stderr readers share it.
+type iterationOutput struct {
+	sawResult atomic.Bool // a result message went by
+	edited    atomic.Bool // the agent called one of editTools
+	sentinel  atomic.Bool // the agent's text contained Config.DoneSentinel
+	edits     editCounts  // lines the agent's edits changed, for editReport
+}
+
+// observe notes the completion signals and edit line counts in one
+// stream-json record.
+func (o *iterationOutput) observe(record, sentinel string) {
+	if strings.Contains(record, `"type":"result"`) {
+		o.sawResult.Store(true)
+	}
+	if !strings.Contains(record, `"tool_use"`) && !strings.Contains(record, `"tool_result"`) && (sentinel == "" || !strings.Contains(record, sentinel)) {
+		return
+	}
+	var msg struct {
+		Type          string          `json:"type"`
+		Result        string          `json:"result"`
+		ToolUseResult json.RawMessage `json:"tool_use_result"`
+		Message       struct {
+			Content []struct {
+				Type      string          `json:"type"`
+				ID        string          `json:"id"`
+				Name      string          `json:"name"`
+				Text      string          `json:"text"`
+				Input     json.RawMessage `json:"input"`
+				ToolUseID string          `json:"tool_use_id"`
+				Content   json.RawMessage `json:"content"`
+			} `json:"content"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(record), &msg); err != nil {
+		return
+	}
+	// Only the agent's own words count; a file it reads may quote the sentinel
+	if sentinel != "" && msg.Type == "result" && strings.Contains(msg.Result, sentinel) {
+		o.sentinel.Store(true)
+	}
+	if msg.Type == "user" {
+		for _, c := range msg.Message.Content {
+			if c.Type == "tool_result" {
+				o.edits.finish(c.ToolUseID, msg.ToolUseResult, c.Content)
+			}
+		}
+		return
+	}
+	if msg.Type != "assistant" {
+		return
+	}
+	for _, c := range msg.Message.Content {
+		switch c.Type {
+		case "tool_use":
+			if editTools[c.Name] {
+				o.edited.Store(true)
+				var input struct {
+					FilePath     string `json:"file_path"`
+					NotebookPath string `json:"notebook_path"`
+				}
+				json.Unmarshal(c.Input, &input)
+				o.edits.start(c.ID, input.FilePath+input.NotebookPath)
+			}
+		case "text":
+			if sentinel != "" && strings.Contains(c.Text, sentinel) {
+				o.sentinel.Store(true)
+			}
+		}
+	}
+}
+
+// agentDone applies the completion heuristics to the iteration that just
+// finished: the agent wrote Config.DoneSentinel, or it went
+// Config.DoneAfterIdle iterations in a row without editing a file. It
+// returns why the run should end, or "" to go on. idle is the run's count of
+// consecutive iterations without an edit.
+func (l *Loop) agentDone(out *iterationOutput, idle *int) string {
+	if out == nil {
+		return ""
+	}
+	if out.edited.Load() {
+		*idle = 0
+	} else {
+		*idle++
+	}
+	if l.config.DoneSentinel != "" && out.sentinel.Load() {
+		return fmt.Sprintf("AGENT SAID %s", l.config.DoneSentinel)
+	}
+	if l.config.DoneAfterIdle > 0 && *idle >= l.config.DoneAfterIdle {
+		return fmt.Sprintf("NO EDITS IN %d ITERATIONS", *idle)
+	}
+	return ""
+}
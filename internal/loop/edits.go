@@ -0,0 +1,122 @@
+package loop
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cloudosai/ralph-go/internal/parser"
+	"github.com/cloudosai/ralph-go/internal/vcs"
+)
+
+// EditMarker tags the loop_marker reporting the lines an iteration's edits
+// changed, sent in place of the GIT one outside a git repository (see
+// Config.CommitReport).
+const EditMarker = "EDITS"
+
+// editCounts adds up the lines an iteration's edit tool calls changed, as
+// their results report them (see parser.ParseEditResult). The stdout and
+// stderr readers share it.
+type editCounts struct {
+	mu      sync.Mutex
+	pending map[string]string // edit calls awaiting their result: tool use ID → file
+	files   map[string]bool   // files an edit with line counts changed
+	added   int
+	removed int
+}
+
+// start notes edit tool call id on file, to count once its result comes.
+func (e *editCounts) start(id, file string) {
+	if id == "" {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.pending == nil {
+		e.pending = make(map[string]string)
+	}
+	e.pending[id] = file
+}
+
+// finish counts the result of tool call id when it is an edit: the
+// structured result the agent attached to the message when there is one,
+// else the result's content.
+func (e *editCounts) finish(id string, structured, content json.RawMessage) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	file, ok := e.pending[id]
+	if !ok {
+		return
+	}
+	delete(e.pending, id)
+	result := strings.TrimSpace(string(structured))
+	if !strings.HasPrefix(result, "{") {
+		result = resultText(content)
+	}
+	added, removed, ok := parser.ParseEditResult(result)
+	if !ok {
+		return
+	}
+	if e.files == nil {
+		e.files = make(map[string]bool)
+	}
+	e.files[file] = true
+	e.added += added
+	e.removed += removed
+}
+
+// changes returns what the counted edits changed; ok is false when no edit
+// result gave line counts.
+func (e *editCounts) changes() (c vcs.Changes, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.files) == 0 {
+		return vcs.Changes{}, false
+	}
+	return vcs.Changes{Files: len(e.files), Insertions: e.added, Deletions: e.removed}, true
+}
+
+// resultText returns a tool result's content, which is either a string or a
+// list of text blocks.
+func resultText(content json.RawMessage) string {
+	var s string
+	if json.Unmarshal(content, &s) == nil {
+		return s
+	}
+	var blocks []struct {
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(content, &blocks) != nil {
+		return ""
+	}
+	texts := make([]string, len(blocks))
+	for i, b := range blocks {
+		texts[i] = b.Text
+	}
+	return strings.Join(texts, "\n")
+}
+
+// editReport sends an EDITS loop_marker with the lines iteration i's edits
+// changed, from out, its Changes attached. It stands in for commitReport
+// where git can't say what changed.
+func (l *Loop) editReport(i int, out *iterationOutput) {
+	if out == nil {
+		return
+	}
+	changes, ok := out.edits.changes()
+	if !ok {
+		return
+	}
+	files := "files"
+	if changes.Files == 1 {
+		files = "file"
+	}
+	l.output <- Message{
+		Type:    "loop_marker",
+		Content: fmt.Sprintf("======= %s: %d %s edited, +%d -%d =======", EditMarker, changes.Files, files, changes.Insertions, changes.Deletions),
+		Loop:    i,
+		Total:   l.GetIterations(),
+		Changes: &changes,
+	}
+}
@@ -0,0 +1,115 @@
+package loop
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Stream formats: how the agent's stdout is split into JSON records before
+// parsing (Config.StreamFormat, --stream-format).
+const (
+	StreamFormatJSONL  = "jsonl"  // one JSON object per line (the Claude CLI)
+	StreamFormatSSE    = "sse"    // server-sent events: JSON in "data:" fields
+	StreamFormatConcat = "concat" // JSON objects back to back, newlines optional
+)
+
+// maxRecordBytes bounds one line or event, large enough for tool results that
+// carry whole files.
+const maxRecordBytes = 10 * 1024 * 1024
+
+// SplitStream reads r until EOF and calls emit with each record in format
+// ("" means StreamFormatJSONL). In JSONL every line is a record, blank ones
+// included. It stops at the first read or framing error and returns it,
+// leaving the rest of r unread.
+func SplitStream(r io.Reader, format string, emit func(string)) error {
+	switch format {
+	case StreamFormatSSE:
+		return splitSSE(r, emit)
+	case StreamFormatConcat:
+		return splitConcat(r, emit)
+	default:
+		return splitLines(r, emit)
+	}
+}
+
+// newRecordScanner returns a line scanner with a buffer big enough for
+// maxRecordBytes lines.
+func newRecordScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRecordBytes)
+	return scanner
+}
+
+func splitLines(r io.Reader, emit func(string)) error {
+	scanner := newRecordScanner(r)
+	for scanner.Scan() {
+		emit(scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// splitSSE emits the data of each event, joining multi-line data with
+// newlines and compacting JSON onto one line. Comments, other fields and the
+// "[DONE]" sentinel are dropped; lines that are not SSE at all pass through
+// unchanged.
+func splitSSE(r io.Reader, emit func(string)) error {
+	scanner := newRecordScanner(r)
+	var data []string
+	flush := func() {
+		if len(data) > 0 {
+			if event := strings.Join(data, "\n"); event != "[DONE]" {
+				emit(compactJSON(event))
+			}
+			data = data[:0]
+		}
+	}
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		switch {
+		case line == "":
+			flush()
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		case strings.HasPrefix(line, ":"),
+			strings.HasPrefix(line, "event:"),
+			strings.HasPrefix(line, "id:"),
+			strings.HasPrefix(line, "retry:"):
+		default:
+			flush()
+			emit(line)
+		}
+	}
+	flush()
+	return scanner.Err()
+}
+
+// splitConcat decodes consecutive JSON values and emits each compacted onto
+// one line, so downstream parsing sees the same records as with JSONL.
+func splitConcat(r io.Reader, emit func(string)) error {
+	dec := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("invalid JSON in concat stream: %w", err)
+		}
+		emit(compactJSON(string(raw)))
+	}
+}
+
+// compactJSON strips insignificant whitespace from s if it is valid JSON,
+// so records match what the Claude CLI writes; anything else is returned as is.
+func compactJSON(s string) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(s)); err != nil {
+		return s
+	}
+	return buf.String()
+}
@@ -0,0 +1,49 @@
+package loop
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudosai/ralph-go/internal/hooks"
+)
+
+// HookMarker tags the loop_marker reporting a hook's result. Hook markers carry
+// the hook's output after the first line, so they must never be mistaken for
+// an iteration start.
+const HookMarker = "HOOK"
+
+// hookMarker formats the loop_marker for a hook run: a status line followed by
+// the tail of the hook's output.
+func hookMarker(phase string, res hooks.HookResult) string {
+	content := fmt.Sprintf("======= %s %s %s: %s (%s) =======",
+		phase, HookMarker, res.Status(), res.Command, res.Duration.Round(100*time.Millisecond))
+	if tail := res.Tail(); tail != "" {
+		content += "\n" + tail
+	}
+	return content
+}
+
+// runHook runs a hook command before or after iteration i (phase "PRE" or
+// "POST") in the working directory and reports its result as a loop_marker.
+// With HookMustPass set, a failed hook also reports that the run is stopping.
+// It returns whether the hook passed.
+func (l *Loop) runHook(ctx context.Context, phase, command string, i int) bool {
+	res := hooks.Run(ctx, command, "", l.config.HookTimeout)
+	total := l.GetIterations()
+	l.output <- Message{
+		Type:    "loop_marker",
+		Content: hookMarker(phase, res),
+		Loop:    i,
+		Total:   total,
+	}
+	if !res.Passed() && l.config.HookMustPass {
+		l.output <- Message{
+			Type:    "loop_marker",
+			Content: fmt.Sprintf("======= %s %s FAILED, STOPPING =======", phase, HookMarker),
+			Loop:    i,
+			Total:   total,
+		}
+	}
+	return res.Passed()
+}
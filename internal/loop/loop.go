@@ -1,9 +1,9 @@
-// Package loop implements the Claude CLI execution loop.
+// Package loop implements the agent CLI execution loop.
 package loop
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -13,12 +13,19 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/cloudosai/ralph-go/internal/stats"
+	"github.com/cloudosai/ralph-go/internal/vcs"
 )
 
 // CommandBuilder is a function that creates an exec.Cmd for running Claude.
 // This allows for dependency injection in tests.
 type CommandBuilder func(ctx context.Context, prompt string) *exec.Cmd
 
+// PromptRenderer fills in a prompt's template for the iteration it is sent
+// for, out of total.
+type PromptRenderer func(prompt string, iteration, total int) (string, error)
+
 // DefaultCommandBuilder creates the standard claude CLI command.
 func DefaultCommandBuilder(ctx context.Context, prompt string) *exec.Cmd {
 	cmd := exec.CommandContext(ctx, "claude",
@@ -73,26 +80,110 @@ func isolatedTmuxEnv() []string {
 type Config struct {
 	Iterations     int
 	Prompt         string         // The prompt content to send to Claude
-	CommandBuilder CommandBuilder // Optional custom command builder (for testing)
+	FirstPrompt    string         // Prompt for iteration 1 only ("" = use Prompt)
+	RenderPrompt   PromptRenderer // Fills in the prompt's template for each iteration (nil = send it as is)
+	Backend        Backend        // Agent CLI to drive (default ClaudeBackend)
+	Model          string         // Model passed to the agent with --model ("" = its default)
+	FallbackModel  string         // Model for an iteration's retries once it has failed FallbackAfter times ("" = keep Model)
+	FallbackAfter  int            // Failed attempts of an iteration before FallbackModel takes over (0 = 1)
+	CommandBuilder CommandBuilder // Optional custom command builder (default Backend.BuildCommand; for testing)
 	SleepDuration  time.Duration  // Duration to sleep between iterations (default: 1s)
+	CompactEvery   int            // Request context compaction every Nth iteration (0 = never)
+	// StallNudgeAfter nudges the agent after this many consecutive iterations
+	// without progress, and stops the run if the nudged iteration makes none
+	// either (0 = off).
+	StallNudgeAfter int
+	ProgressProbe   ProgressProbe         // Work fingerprint for stall detection (default: NewGitProgressProbe(ExcludeDirs))
+	ExcludeDirs     []string              // Directories ignored by git-based progress detection and commit reports
+	RestartOnCrash  bool                  // Restart a crashed agent once per iteration, resuming its session
+	ConfirmEachLoop bool                  // Pause before every iteration after the first until Resume is called
+	ConfirmStart    bool                  // Pause before the first iteration until Resume is called
+	PreLoopHook     string                // Shell command run before each iteration ("" = none)
+	PostLoopHook    string                // Shell command run after each iteration ("" = none)
+	HookTimeout     time.Duration         // Limit on each hook run (0 = hooks.DefaultTimeout)
+	HookMustPass    bool                  // Stop the run when a hook fails
+	MaxRetries      int                   // Consecutive API error retries allowed per iteration (0 = DefaultMaxRetries)
+	NoSleepOnError  bool                  // Retry API errors immediately instead of backing off
+	SuccessCodes    []int                 // Agent exit codes besides 0 that count as success
+	TotalRetries    int                   // Retries allowed across the whole run: rate limit and API error waits, crash restarts and failed iteration retries (0 = unlimited)
+	RetryBudget     *RetryBudget          // Counts TotalRetries across the run's loops, e.g. plan and build (nil = this loop's own)
+	RetryFailed     int                   // Retries of an iteration whose agent exits with an error (0 = off)
+	RetryBackoff    time.Duration         // Delay before the first retry of a failed iteration, doubling per attempt (0 = DefaultRetryBackoff)
+	RedoFresh       bool                  // RedoIteration starts a fresh session instead of resuming the last one
+	StreamFormat    string                // How the agent's stdout is framed: StreamFormatJSONL (default), StreamFormatSSE or StreamFormatConcat
+	DoneMarkers     bool                  // Send a loop_marker_done message with the elapsed time after each iteration
+	StartAt         time.Time             // Hold the first iteration until this time (zero = start now)
+	ActiveHours     Window                // Start iterations only inside this daily window (zero = any time)
+	Deadline        time.Time             // Start no iteration after this time; the run then completes (zero = no limit)
+	AgentTimeout    time.Duration         // Kill an agent run that takes longer than this; the iteration fails (0 = no limit)
+	NoOutputTimeout time.Duration         // Kill an agent that writes no output for this long and restart it once, resuming its session (0 = off)
+	ThrashAction    string                // What Thrashing does: ThrashWarn ("" too), ThrashNudge or ThrashStop
+	SuccessCmd      string                // Shell command run when the iterations are done; its exit code is the run's success ("" = none)
+	SuccessRetries  int                   // Extra iterations, one at a time, to fix a failing SuccessCmd
+	StatePath       string                // Write the RunState here after every iteration ("" = off)
+	StateArgs       []string              // Command line recorded in the RunState, for `ralph resume`
+	StateStats      func() stats.Snapshot // Stats recorded in the RunState (nil = none)
+	StateBranch     string                // Branch the run works on, recorded in the RunState ("" = none)
+	StateSpecs      []string              // Spec files the run covers, recorded in the RunState (empty = all)
+	FirstIteration  int                   // Iteration to start at when resuming a run; earlier ones are done (0 = 1)
+	SpecQueue       SpecQueue             // Specs the run works through one at a time, its position recorded after every iteration (zero = off)
+	DoneAfterIdle   int                   // End the run after this many consecutive iterations without a file edit (0 = off)
+	DoneSentinel    string                // End the run after an iteration whose agent text contains this ("" = off)
+	Transcripts     Transcripts           // Record each iteration's raw agent output (zero = off)
+	CommitReport    bool                  // Report each iteration's commits and diff stat as a GIT loop_marker, or outside a git repository its edits' line counts as an EDITS one
+	WarnNoCommit    bool                  // With CommitReport, also warn about iterations that made no commit but left changes
 }
 
+// ErrAgentCrashed is returned (wrapped) when the agent process exits with an
+// error before emitting a result message. An agent that reports an error
+// result and then exits non-zero has not crashed; it finished with an error.
+var ErrAgentCrashed = errors.New("agent exited without a result")
+
+// ErrIterationTimeout is returned (wrapped) when the agent is killed for
+// running past Config.AgentTimeout.
+var ErrIterationTimeout = errors.New("iteration timed out")
+
+// CompactionPrompt is injected ahead of the prompt on iterations where
+// context compaction is requested (see Config.CompactEvery).
+const CompactionPrompt = "Before continuing, compact your context: summarize the progress so far, " +
+	"record anything worth keeping in the implementation plan, and drop details you no longer need.\n\n"
+
+// ConfirmMarker tags the loop_marker sent when ConfirmEachLoop is waiting for
+// the user to start the next iteration.
+const ConfirmMarker = "WAITING"
+
 // Message represents output from the loop.
 type Message struct {
-	Type    string // "loop_marker", "output", "error", "complete"
+	Type    string // "loop_marker", "loop_marker_done", "output", "error", "complete"
 	Content string
 	Loop    int
 	Total   int
+	// Elapsed is how long the iteration took, retries included, on a
+	// loop_marker_done, and how long a rate-limit hibernation lasted on the
+	// WAKING marker that ends it.
+	Elapsed time.Duration
+	// Changes is what the iteration committed, on a GIT loop_marker (see
+	// Config.CommitReport).
+	Changes *vcs.Changes
+	// Model is the model the iteration runs with, on the loop_marker that
+	// starts it and its loop_marker_done ("" = the agent's default).
+	Model string
+	// Until is when the wait ends on a SCHEDULED loop_marker sent outside
+	// Config.ActiveHours.
+	Until time.Time
 }
 
 // Loop manages the Claude CLI execution loop.
 type Loop struct {
 	config           Config
-	mu               sync.Mutex // protects running, paused, config.Iterations, sessionID, resumeSessionID, completedWaiting, hibernate state
+	mu               sync.Mutex // protects running, paused, pausePending, config.Iterations, config.Prompt, config.Deadline, sessionID, resumeSessionID, completedWaiting, hibernate state
 	output           chan Message
 	cancel           context.CancelFunc
 	running          bool
 	paused           bool
+	pausePending     bool // PauseAfterIteration was called; the loop pauses before the next iteration
+	draining         bool // Drain was called; the run ends once the running iteration finishes
+	busy             bool // an iteration is under way, from its pre-loop hook to its commit report (run goroutine sets it)
 	completedWaiting bool // loop finished all iterations but stays alive waiting for more
 	resumeCh         chan struct{}
 	iterationCancel  context.CancelFunc // cancels current iteration only
@@ -101,17 +192,36 @@ type Loop struct {
 	hibernating      bool               // whether loop is hibernating due to rate limit
 	hibernateUntil   time.Time          // when rate limit resets
 	hibernateCh      chan struct{}      // channel to signal manual wake
+	pacing           bool               // the current hibernate is cost pacing, not a retry
+	nudge            string             // one-shot text prepended to the next iteration's prompt
+	redo             bool               // RedoIteration was called; the run goroutine consumes it on resume
+	awaiting         bool               // the run goroutine is blocked in awaitResume
+	remediations     int                // iterations added after Config.SuccessCmd failed (run goroutine only)
+	successFailed    bool               // Config.SuccessCmd failed when it last ran
+	lastOutput       *iterationOutput   // what the latest executeIteration saw in the output (run goroutine only)
+	model            string             // model the running iteration's agent is given (run goroutine only)
+	replay           []string           // transcripts played back instead of running the agent (see NewReplay)
+	replayDelay      time.Duration      // wait before each replayed record
 }
 
 // New creates a new Loop with the given configuration.
 func New(cfg Config) *Loop {
 	// Set defaults
+	if cfg.Backend == nil {
+		cfg.Backend = ClaudeBackend{}
+	}
 	if cfg.CommandBuilder == nil {
-		cfg.CommandBuilder = DefaultCommandBuilder
+		cfg.CommandBuilder = cfg.Backend.BuildCommand
 	}
 	if cfg.SleepDuration == 0 {
 		cfg.SleepDuration = 1 * time.Second
 	}
+	if cfg.StallNudgeAfter > 0 && cfg.ProgressProbe == nil {
+		cfg.ProgressProbe = NewGitProgressProbe(cfg.ExcludeDirs)
+	}
+	if cfg.RetryBudget == nil {
+		cfg.RetryBudget = &RetryBudget{}
+	}
 	return &Loop{
 		config:      cfg,
 		output:      make(chan Message, 100),
@@ -132,6 +242,10 @@ func (l *Loop) Start(ctx context.Context) {
 	l.running = true
 	l.mu.Unlock()
 
+	if l.replay != nil {
+		go l.runReplay(ctx)
+		return
+	}
 	go l.run(ctx)
 }
 
@@ -145,6 +259,38 @@ func (l *Loop) Stop() {
 	l.mu.Unlock()
 }
 
+// Drain ends the run gracefully: the running iteration finishes, its agent,
+// post-loop hook and commit report included, and its state is saved, but no
+// new iteration starts. With no iteration under way, e.g. while paused or
+// hibernating, it stops the loop at once. The output channel closes when the
+// run has ended.
+func (l *Loop) Drain() {
+	l.mu.Lock()
+	l.draining = true
+	idle := !l.busy
+	l.mu.Unlock()
+	if idle {
+		l.Stop()
+	}
+}
+
+// IsDraining returns whether Drain was called.
+func (l *Loop) IsDraining() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.draining
+}
+
+// drained announces that a drained run ends after iteration completed.
+func (l *Loop) drained(completed int) {
+	l.output <- Message{
+		Type:    "loop_marker",
+		Content: fmt.Sprintf("======= SHUTTING DOWN AFTER ITERATION %d =======", completed),
+		Loop:    completed,
+		Total:   l.GetIterations(),
+	}
+}
+
 // IsRunning returns whether the loop is currently running.
 func (l *Loop) IsRunning() bool {
 	l.mu.Lock()
@@ -159,13 +305,23 @@ func (l *Loop) IsPaused() bool {
 	return l.paused
 }
 
+// IsPausePending returns whether the loop will pause once the current
+// iteration finishes (see PauseAfterIteration).
+func (l *Loop) IsPausePending() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.pausePending
+}
+
 // Pause immediately interrupts the current iteration and pauses the loop.
 // Captures the current session ID so the next resume can use --resume.
+// It also escalates a pending PauseAfterIteration.
 func (l *Loop) Pause() {
 	l.mu.Lock()
 	shouldPause := !l.paused && l.running
 	if shouldPause {
 		l.paused = true
+		l.pausePending = false
 		l.resumeSessionID = l.sessionID
 	}
 	l.mu.Unlock()
@@ -175,9 +331,43 @@ func (l *Loop) Pause() {
 	}
 }
 
-// Resume resumes a paused loop, or wakes a completed-waiting loop to run new iterations.
+// PauseAfterIteration pauses the loop once the current iteration has
+// finished, its post-loop hook and commit report included, rather than
+// interrupting it as Pause does. The next iteration starts a fresh session
+// when resumed. A replay has no iteration to finish, so it pauses at once.
+func (l *Loop) PauseAfterIteration() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.paused || !l.running || l.completedWaiting {
+		return
+	}
+	if l.replay != nil {
+		l.paused = true
+		return
+	}
+	l.pausePending = true
+}
+
+// takePendingPause turns a pending PauseAfterIteration into a pause, at an
+// iteration boundary.
+func (l *Loop) takePendingPause() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.pausePending {
+		l.pausePending = false
+		l.paused = true
+	}
+}
+
+// Resume resumes a paused loop, or wakes a completed-waiting loop to run new
+// iterations. A pending PauseAfterIteration is cancelled.
 func (l *Loop) Resume() {
 	l.mu.Lock()
+	if l.pausePending {
+		l.pausePending = false
+		l.mu.Unlock()
+		return
+	}
 	if l.paused {
 		l.paused = false
 		l.mu.Unlock()
@@ -191,11 +381,66 @@ func (l *Loop) Resume() {
 	}
 }
 
+// RedoIteration asks a paused or completed loop to run its last iteration
+// again instead of moving on, resuming that iteration's session unless
+// Config.RedoFresh is set. It is a no-op while an iteration is running and
+// reports whether the redo was queued.
+func (l *Loop) RedoIteration() bool {
+	l.mu.Lock()
+	if !l.running || (!l.awaiting && !l.completedWaiting) {
+		l.mu.Unlock()
+		return false
+	}
+	l.redo = true
+	l.resumeSessionID = l.sessionID
+	if l.config.RedoFresh {
+		l.resumeSessionID = ""
+	}
+	l.paused = false
+	l.mu.Unlock()
+	l.resumeCh <- struct{}{}
+	return true
+}
+
+// takeRedo consumes a pending RedoIteration request.
+func (l *Loop) takeRedo() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	redo := l.redo
+	l.redo = false
+	return redo
+}
+
+// redoMarker announces that iteration i is about to run again.
+func (l *Loop) redoMarker(i int) {
+	total := l.GetIterations()
+	l.output <- Message{
+		Type:    "loop_marker",
+		Content: fmt.Sprintf("======= REDO ITERATION %d/%d =======", i, total),
+		Loop:    i,
+		Total:   total,
+	}
+}
+
 // Hibernate enters hibernate state and waits until the specified time.
 // If already hibernating, only extends if new time is later.
 // Cancels the current iteration to interrupt it immediately.
 // Captures the current session ID so the retried iteration can use --resume.
 func (l *Loop) Hibernate(until time.Time) {
+	l.hibernate(until, false)
+}
+
+// Pace hibernates like Hibernate, but for a self-imposed spending limit
+// rather than a failure, so the retried iteration does not count against
+// Config.TotalRetries.
+func (l *Loop) Pace(until time.Time) {
+	l.hibernate(until, true)
+}
+
+func (l *Loop) hibernate(until time.Time, pacing bool) {
+	if l.replay != nil {
+		return
+	}
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	// If already hibernating, only extend if new time is later
@@ -204,6 +449,7 @@ func (l *Loop) Hibernate(until time.Time) {
 	}
 	l.hibernating = true
 	l.hibernateUntil = until
+	l.pacing = pacing
 	// Capture session ID for resume (mirrors Pause logic)
 	l.resumeSessionID = l.sessionID
 	// Cancel current iteration to stop processing
@@ -232,6 +478,14 @@ func (l *Loop) IsHibernating() bool {
 	return l.hibernating
 }
 
+// IsPacing returns whether the loop is hibernating for cost pacing (see Pace)
+// rather than a rate limit.
+func (l *Loop) IsPacing() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.hibernating && l.pacing
+}
+
 // GetHibernateUntil returns the time when the hibernate period ends.
 func (l *Loop) GetHibernateUntil() time.Time {
 	l.mu.Lock()
@@ -263,6 +517,23 @@ func (l *Loop) GetIterations() int {
 	return l.config.Iterations
 }
 
+// Deadline returns when the run stops starting iterations (see
+// Config.Deadline); zero when it has no time limit or has reached it.
+// Thread-safe: can be called from any goroutine.
+func (l *Loop) Deadline() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.config.Deadline
+}
+
+// SetPrompt replaces Config.Prompt from the next iteration on.
+// Thread-safe: can be called from any goroutine.
+func (l *Loop) SetPrompt(prompt string) {
+	l.mu.Lock()
+	l.config.Prompt = prompt
+	l.mu.Unlock()
+}
+
 // SetSessionID stores the latest session ID from Claude CLI output.
 // Thread-safe: can be called from any goroutine (typically the output processing goroutine).
 func (l *Loop) SetSessionID(id string) {
@@ -288,6 +559,194 @@ func (l *Loop) SetResumeSessionID(id string) {
 	l.mu.Unlock()
 }
 
+// Nudge queues text to be prepended to the prompt of the next iteration only.
+// Multiple nudges before an iteration starts are sent together, in order.
+// Thread-safe: can be called from any goroutine.
+func (l *Loop) Nudge(text string) {
+	l.mu.Lock()
+	l.nudge += text
+	l.mu.Unlock()
+}
+
+// takeNudge returns and clears the pending nudge text.
+func (l *Loop) takeNudge() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	nudge := l.nudge
+	l.nudge = ""
+	return nudge
+}
+
+// NewBackoff returns an API error backoff that allows Config.MaxRetries
+// consecutive retries per iteration. With Config.NoSleepOnError its delays
+// are zero, so each retry starts right away.
+func (l *Loop) NewBackoff() *Backoff {
+	var opts []BackoffOption
+	if l.config.MaxRetries > 0 {
+		opts = append(opts, WithMaxRetries(l.config.MaxRetries))
+	}
+	if l.config.NoSleepOnError {
+		opts = append(opts, WithInitialBackoff(0), WithMaxBackoff(0))
+	}
+	return NewBackoffWithOptions(opts...)
+}
+
+// newFailureBackoff returns the backoff between retries of a failed
+// iteration (Config.RetryFailed): Config.RetryBackoff, doubling with
+// each attempt up to DefaultMaxBackoff, or no delay with
+// Config.NoSleepOnError.
+func (l *Loop) newFailureBackoff() *Backoff {
+	initial := l.config.RetryBackoff
+	if initial <= 0 {
+		initial = DefaultRetryBackoff
+	}
+	opts := []BackoffOption{WithInitialBackoff(initial), WithMaxRetries(l.config.RetryFailed)}
+	if l.config.NoSleepOnError {
+		opts = append(opts, WithInitialBackoff(0), WithMaxBackoff(0))
+	}
+	return NewBackoffWithOptions(opts...)
+}
+
+// RetryBudget counts the retries a run has spent from Config.TotalRetries.
+// Loops given the same one, like plan-and-build's plan and build loops, share
+// one budget. The zero value has spent none.
+type RetryBudget struct {
+	mu   sync.Mutex
+	used int
+}
+
+// spend uses one retry unless limit are already used, and returns how many
+// are used.
+func (b *RetryBudget) spend(limit int) (used int, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used >= limit {
+		return b.used, false
+	}
+	b.used++
+	return b.used, true
+}
+
+// useRetry spends one retry of iteration i from the run-wide budget
+// (Config.TotalRetries). Once the budget is spent it reports that the run is
+// stopping and returns false.
+func (l *Loop) useRetry(i int) bool {
+	if l.config.TotalRetries <= 0 {
+		return true
+	}
+	used, ok := l.config.RetryBudget.spend(l.config.TotalRetries)
+	if !ok {
+		l.output <- Message{
+			Type:    "loop_marker",
+			Content: fmt.Sprintf("======= RETRY BUDGET EXHAUSTED (%d RETRIES), STOPPING =======", used),
+			Loop:    i,
+			Total:   l.GetIterations(),
+		}
+		return false
+	}
+	return true
+}
+
+// waitForConfirm pauses the loop before iteration i and blocks until Resume is
+// called. It returns false if ctx is cancelled while waiting.
+func (l *Loop) waitForConfirm(ctx context.Context, i int) bool {
+	l.mu.Lock()
+	l.paused = true
+	l.pausePending = false // this wait is the pause it asked for
+	l.mu.Unlock()
+	l.output <- Message{
+		Type:    "loop_marker",
+		Content: fmt.Sprintf("======= %s: press r/Enter to run loop %d/%d =======", ConfirmMarker, i, l.GetIterations()),
+		Loop:    i,
+		Total:   l.GetIterations(),
+	}
+	return l.awaitResume(ctx)
+}
+
+// awaitResume blocks until Resume or RedoIteration is called, reporting false
+// if ctx ends first. RedoIteration only acts while the loop waits here.
+func (l *Loop) awaitResume(ctx context.Context) bool {
+	l.mu.Lock()
+	l.awaiting = true
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.awaiting = false
+		l.mu.Unlock()
+	}()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-l.resumeCh:
+		return true
+	}
+}
+
+// waitForStart holds the run until Config.StartAt. The wait is a paced
+// hibernate, so it shows as one and Wake (r in the TUI) starts the run early.
+// It returns false if ctx is cancelled first.
+func (l *Loop) waitForStart(ctx context.Context) bool {
+	start := l.config.StartAt
+	if !time.Now().Before(start) {
+		return true
+	}
+	l.hibernate(start, true)
+	l.output <- Message{
+		Type:    "loop_marker",
+		Content: fmt.Sprintf("======= SCHEDULED: STARTING AT %s =======", start.Format("Mon 15:04")),
+		Total:   l.GetIterations(),
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-l.hibernateCh:
+		// Manual wake
+	case <-time.After(time.Until(start)):
+	}
+	l.mu.Lock()
+	l.hibernating = false
+	l.pacing = false
+	l.mu.Unlock()
+	return true
+}
+
+// waitForWindow holds iteration i until Config.ActiveHours opens, when it is
+// started outside them. Like waitForStart the wait is a paced hibernate, but
+// the iteration keeps the session it would have had, and Wake (r in the TUI)
+// runs it at once. It returns false if ctx is cancelled first.
+func (l *Loop) waitForWindow(ctx context.Context, i int) bool {
+	now := time.Now()
+	next := l.config.ActiveHours.Next(now)
+	if l.replay != nil || !next.After(now) {
+		return true
+	}
+	l.mu.Lock()
+	l.hibernating = true
+	l.hibernateUntil = next
+	l.pacing = true
+	l.mu.Unlock()
+	l.saveState(i-1, false)
+	l.output <- Message{
+		Type:    "loop_marker",
+		Content: fmt.Sprintf("======= SCHEDULED: OUTSIDE ACTIVE HOURS %s, NEXT WINDOW AT %s =======", l.config.ActiveHours, next.Format("Mon 15:04")),
+		Loop:    i,
+		Total:   l.GetIterations(),
+		Until:   next,
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-l.hibernateCh:
+		// Manual wake
+	case <-time.After(time.Until(next)):
+	}
+	l.mu.Lock()
+	l.hibernating = false
+	l.pacing = false
+	l.mu.Unlock()
+	return true
+}
+
 // run executes the main loop logic.
 // After completing all iterations, the goroutine stays alive waiting for more
 // iterations to be added (via SetIterations + Resume). This enables the
@@ -300,8 +759,49 @@ func (l *Loop) run(ctx context.Context) {
 		l.mu.Unlock()
 	}()
 
-	i := 1
-	isHibernateRetry := false
+	if !l.waitForStart(ctx) {
+		return
+	}
+
+	first := max(1, l.config.FirstIteration)
+	i := first
+	retryLabel := ""                  // marks the next iteration start as a retry, e.g. "RETRY 2/3"
+	failures := l.newFailureBackoff() // retries of the current iteration after agent failures
+	stalled := 0                      // consecutive iterations without progress
+	stallNudged := false              // whether the current stall has already been nudged
+	idle := 0                         // consecutive iterations without a file edit
+	confirmed := first                // highest iteration the user has confirmed (the first runs unasked)
+	if l.config.ConfirmStart {
+		confirmed = first - 1
+	}
+	l.saveState(first-1, false)
+	preHooked := 0 // highest iteration the pre-loop hook has run for
+	var iterStart time.Time
+	var commitBase string // HEAD before the current iteration, for CommitReport
+	var inRepo bool       // commitBase was read, so the iteration's commits can be reported
+	// pastDeadline ends the run before iteration i once Config.Deadline has
+	// passed; the loop then completes normally. It applies once, so
+	// iterations added afterwards run.
+	pastDeadline := func(i int) bool {
+		l.mu.Lock()
+		deadline := l.config.Deadline
+		over := !deadline.IsZero() && !time.Now().Before(deadline)
+		if over {
+			l.config.Deadline = time.Time{}
+		}
+		l.mu.Unlock()
+		if !over {
+			return false
+		}
+		l.output <- Message{
+			Type:    "loop_marker",
+			Content: fmt.Sprintf("======= TIME LIMIT REACHED AFTER %d ITERATIONS, STOPPING =======", i-1),
+			Loop:    i - 1,
+			Total:   i - 1,
+		}
+		l.SetIterations(i - 1)
+		return true
+	}
 	for {
 		// Inner loop: run iterations until we catch up with GetIterations()
 		for ; i <= l.GetIterations(); i++ {
@@ -310,8 +810,34 @@ func (l *Loop) run(ctx context.Context) {
 				return
 			default:
 			}
+			l.mu.Lock()
+			l.busy = false
+			draining := l.draining
+			l.mu.Unlock()
+			if draining {
+				l.drained(i - 1)
+				return
+			}
+
+			// In step mode, wait for the user before each new iteration
+			// (or only the first, with ConfirmStart). Retries of an iteration
+			// already confirmed don't ask again.
+			if i > confirmed && (l.config.ConfirmEachLoop || i == first) {
+				confirmed = i
+				if !l.waitForConfirm(ctx, i) {
+					return
+				}
+				// Redo the previous iteration, then ask again before this one
+				if l.takeRedo() && i > 1 {
+					confirmed = i - 1
+					l.redoMarker(i - 1)
+					i -= 2
+					continue
+				}
+			}
 
 			// Check if paused and wait for resume
+			l.takePendingPause()
 			l.mu.Lock()
 			paused := l.paused
 			l.mu.Unlock()
@@ -323,47 +849,176 @@ func (l *Loop) run(ctx context.Context) {
 					Loop:    i,
 					Total:   total,
 				}
-				select {
-				case <-ctx.Done():
+				l.saveState(i-1, false)
+				if !l.awaitResume(ctx) {
 					return
-				case <-l.resumeCh:
-					total = l.GetIterations()
-					l.output <- Message{
-						Type:    "loop_marker",
-						Content: "======= LOOP RESUMED =======",
-						Loop:    i,
-						Total:   total,
-					}
 				}
+				// Paused between iterations: a redo repeats the one just finished
+				if l.takeRedo() && i > 1 {
+					l.redoMarker(i - 1)
+					i -= 2
+					continue
+				}
+				total = l.GetIterations()
+				l.output <- Message{
+					Type:    "loop_marker",
+					Content: "======= LOOP RESUMED =======",
+					Loop:    i,
+					Total:   total,
+				}
+			}
+
+			// Past the time limit, or once it passes waiting for the active
+			// hours, end the run before this iteration
+			if pastDeadline(i) {
+				i--
+				continue
+			}
+			if !l.waitForWindow(ctx, i) {
+				return
+			}
+			if pastDeadline(i) {
+				i--
+				continue
 			}
 
+			// From here Drain waits for the iteration, unless it came first
+			l.mu.Lock()
+			draining = l.draining
+			l.busy = !draining
+			l.mu.Unlock()
+			if draining {
+				l.drained(i - 1)
+				return
+			}
+
+			// Run the pre-loop hook once per iteration, not again on retries
+			if l.config.PreLoopHook != "" && i > preHooked {
+				preHooked = i
+				passed := l.runHook(ctx, "PRE", l.config.PreLoopHook, i)
+				if ctx.Err() != nil {
+					return
+				}
+				if !passed && l.config.HookMustPass {
+					// End the run before this iteration; the loop then completes
+					// normally, and adding iterations retries it, hook included
+					l.SetIterations(i - 1)
+					preHooked = i - 1
+					i--
+					continue
+				}
+			}
+
+			// Pick the model, switching to the fallback once the iteration
+			// has failed often enough
+			model := l.modelFor(failures.ConsecutiveHits())
+			if model != l.model && retryLabel != "" {
+				l.output <- Message{
+					Type:    "loop_marker",
+					Content: fmt.Sprintf("======= SWITCHING TO MODEL %s AFTER %d FAILURES =======", model, failures.ConsecutiveHits()),
+					Loop:    i,
+					Total:   l.GetIterations(),
+					Model:   model,
+				}
+			}
+			l.model = model
+
 			// Send loop marker
 			total := l.GetIterations()
 			markerContent := fmt.Sprintf("======= LOOP %d/%d =======", i, total)
-			if isHibernateRetry {
-				markerContent = fmt.Sprintf("======= LOOP %d/%d (RETRY) =======", i, total)
-				isHibernateRetry = false
+			if retryLabel != "" {
+				markerContent = fmt.Sprintf("======= LOOP %d/%d (%s) =======", i, total, retryLabel)
+				retryLabel = ""
+			} else {
+				iterStart = time.Now()
+				commitBase, inRepo = l.commitBase()
 			}
 			l.output <- Message{
 				Type:    "loop_marker",
 				Content: markerContent,
 				Loop:    i,
 				Total:   total,
+				Model:   model,
+			}
+
+			// Every Nth iteration, ask the agent to compact its context
+			if l.config.CompactEvery > 0 && i%l.config.CompactEvery == 0 {
+				l.Nudge(CompactionPrompt)
+				l.output <- Message{
+					Type:    "loop_marker",
+					Content: "======= COMPACTION REQUESTED =======",
+					Loop:    i,
+					Total:   total,
+				}
+			}
+
+			// Fingerprint the work before the iteration for stall detection
+			var before string
+			if l.config.StallNudgeAfter > 0 {
+				before = l.config.ProgressProbe()
 			}
 
-			// Create a cancellable context for this iteration
+			// Create a cancellable context for this iteration, limited to
+			// Config.AgentTimeout
 			iterCtx, iterCancel := context.WithCancel(ctx)
+			if l.config.AgentTimeout > 0 {
+				iterCtx, iterCancel = context.WithTimeout(ctx, l.config.AgentTimeout)
+			}
 			l.iterationCancel = iterCancel
 
 			// Execute Claude CLI
 			err := l.executeIteration(iterCtx, i)
+
+			// Restart a crashed agent once, resuming its session so the
+			// iteration keeps its context
+			if errors.Is(err, ErrAgentCrashed) && l.config.RestartOnCrash && iterCtx.Err() == nil && l.useRetry(i) {
+				l.output <- Message{
+					Type:    "loop_marker",
+					Content: "======= AGENT CRASHED, RESTARTING =======",
+					Loop:    i,
+					Total:   l.GetIterations(),
+				}
+				l.mu.Lock()
+				l.resumeSessionID = l.sessionID
+				l.mu.Unlock()
+				err = l.executeIteration(iterCtx, i)
+			}
+
+			// Restart an agent that went quiet once, resuming its session
+			if errors.Is(err, ErrAgentStalled) && iterCtx.Err() == nil && l.useRetry(i) {
+				l.output <- Message{
+					Type:    "loop_marker",
+					Content: fmt.Sprintf("======= AGENT STALLED: NO OUTPUT FOR %s, RESTARTING =======", l.config.NoOutputTimeout),
+					Loop:    i,
+					Total:   l.GetIterations(),
+				}
+				l.mu.Lock()
+				l.resumeSessionID = l.sessionID
+				l.mu.Unlock()
+				err = l.executeIteration(iterCtx, i)
+			}
+			if errors.Is(iterCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+				err = fmt.Errorf("%w after %s", ErrIterationTimeout, l.config.AgentTimeout)
+			}
 			iterCancel() // clean up
 			l.iterationCancel = nil
 
 			// If we were paused (interrupted), don't report as error
 			l.mu.Lock()
+			draining = l.draining
 			paused = l.paused
+			interrupted := paused || l.hibernating
+			if interrupted {
+				l.busy = false // a wait follows, which Drain ends
+			}
 			l.mu.Unlock()
+			// An interrupted iteration isn't finished, so a drain ends the
+			// run without it rather than waiting to retry it
+			if draining && interrupted {
+				l.saveState(i-1, false)
+				l.drained(i - 1)
+				return
+			}
 			if paused {
 				total := l.GetIterations()
 				l.output <- Message{
@@ -372,10 +1027,13 @@ func (l *Loop) run(ctx context.Context) {
 					Loop:    i,
 					Total:   total,
 				}
-				select {
-				case <-ctx.Done():
+				l.saveState(i-1, false)
+				if !l.awaitResume(ctx) {
 					return
-				case <-l.resumeCh:
+				}
+				if l.takeRedo() {
+					l.redoMarker(i)
+				} else {
 					total = l.GetIterations()
 					l.output <- Message{
 						Type:    "loop_marker",
@@ -391,14 +1049,32 @@ func (l *Loop) run(ctx context.Context) {
 
 			// Check if hibernating (rate limited) and wait for auto-resume or manual wake
 			if l.IsHibernating() {
+				l.mu.Lock()
+				pacing := l.pacing
+				l.mu.Unlock()
+				if !pacing && !l.useRetry(i) {
+					l.mu.Lock()
+					l.hibernating = false
+					l.mu.Unlock()
+					// End the run without this iteration; the loop then completes normally
+					l.SetIterations(i - 1)
+					i--
+					continue
+				}
+				marker := "======= HIBERNATING ======="
+				if pacing {
+					marker = "======= PACED ======="
+				}
 				total := l.GetIterations()
 				l.output <- Message{
 					Type:    "loop_marker",
-					Content: "======= HIBERNATING =======",
+					Content: marker,
 					Loop:    i,
 					Total:   total,
 				}
+				l.saveState(i-1, false)
 				hibernateUntil := l.GetHibernateUntil()
+				slept := time.Now()
 				select {
 				case <-ctx.Done():
 					return
@@ -410,18 +1086,56 @@ func (l *Loop) run(ctx context.Context) {
 					l.hibernating = false
 					l.mu.Unlock()
 				}
+				l.mu.Lock()
+				l.pacing = false
+				l.mu.Unlock()
+				// Report how long a rate-limit hibernation lasted; pacing is
+				// self-imposed and not counted
+				var hibernated time.Duration
+				if !pacing {
+					hibernated = time.Since(slept)
+				}
 				total = l.GetIterations()
 				l.output <- Message{
 					Type:    "loop_marker",
 					Content: "======= WAKING =======",
 					Loop:    i,
 					Total:   total,
+					Elapsed: hibernated,
 				}
 				// Retry this iteration
-				isHibernateRetry = true
+				retryLabel = "RETRY"
+				i--
+				continue
+			}
+
+			// Retry an iteration whose agent failed, e.g. on a network blip,
+			// after a delay that doubles with each attempt
+			if err != nil && ctx.Err() == nil && !draining && failures.ConsecutiveHits() < l.config.RetryFailed && l.useRetry(i) {
+				delay, attempt, _ := failures.Next()
+				total := l.GetIterations()
+				l.output <- Message{
+					Type:    "error",
+					Content: err.Error(),
+					Loop:    i,
+					Total:   total,
+				}
+				l.output <- Message{
+					Type:    "loop_marker",
+					Content: fmt.Sprintf("======= ITERATION FAILED, RETRY %d/%d IN %s =======", attempt, l.config.RetryFailed, delay.Round(time.Second)),
+					Loop:    i,
+					Total:   total,
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+				retryLabel = fmt.Sprintf("RETRY %d/%d", attempt, l.config.RetryFailed)
 				i--
 				continue
 			}
+			failures.Reset()
 
 			if err != nil {
 				total := l.GetIterations()
@@ -432,6 +1146,83 @@ func (l *Loop) run(ctx context.Context) {
 					Total:   total,
 				}
 			}
+			l.saveState(i, false)
+
+			if l.config.DoneMarkers {
+				total := l.GetIterations()
+				elapsed := time.Since(iterStart).Round(time.Second)
+				l.output <- Message{
+					Type:    "loop_marker_done",
+					Content: fmt.Sprintf("LOOP %d/%d done — %s", i, total, elapsed),
+					Loop:    i,
+					Total:   total,
+					Elapsed: elapsed,
+					Model:   l.model,
+				}
+			}
+
+			if l.config.PostLoopHook != "" {
+				if ctx.Err() != nil {
+					return
+				}
+				if !l.runHook(ctx, "POST", l.config.PostLoopHook, i) && l.config.HookMustPass {
+					l.reportChanges(i, commitBase, inRepo)
+					// End the run after this iteration; the loop then completes normally
+					l.SetIterations(i)
+					continue
+				}
+			}
+
+			// Report what the iteration committed, the post-loop hook's commits included
+			l.reportChanges(i, commitBase, inRepo)
+
+			// End the run when the agent appears done; a failed iteration is not
+			if err != nil {
+				idle = 0
+			} else if reason := l.agentDone(l.lastOutput, &idle); reason != "" {
+				l.output <- Message{
+					Type:    "loop_marker",
+					Content: fmt.Sprintf("======= DONE: %s, STOPPING =======", reason),
+					Loop:    i,
+					Total:   l.GetIterations(),
+				}
+				// End the run after this iteration; the loop then completes normally
+				l.SetIterations(i)
+				continue
+			}
+
+			// Nudge a stalled agent once; stop if the nudge did not help either
+			if l.config.StallNudgeAfter > 0 {
+				after := l.config.ProgressProbe()
+				if before == "" || after != before {
+					stalled = 0
+					stallNudged = false
+				} else {
+					stalled++
+				}
+				total := l.GetIterations()
+				if stallNudged && stalled > l.config.StallNudgeAfter {
+					l.output <- Message{
+						Type:    "loop_marker",
+						Content: fmt.Sprintf("======= STALLED: NO CHANGES IN %d ITERATIONS, STOPPING =======", stalled),
+						Loop:    i,
+						Total:   total,
+					}
+					// End the run after this iteration; the loop then completes normally
+					l.SetIterations(i)
+					continue
+				}
+				if !stallNudged && stalled >= l.config.StallNudgeAfter && i < total {
+					l.Nudge(StallNudgePrompt)
+					stallNudged = true
+					l.output <- Message{
+						Type:    "loop_marker",
+						Content: fmt.Sprintf("======= STALL NUDGE: NO CHANGES IN %d ITERATIONS =======", stalled),
+						Loop:    i,
+						Total:   total,
+					}
+				}
+			}
 
 			// Sleep between iterations (except for the last one)
 			if i < l.GetIterations() {
@@ -443,9 +1234,28 @@ func (l *Loop) run(ctx context.Context) {
 			}
 		}
 
-		// All current iterations complete — send completion marker
+		// All current iterations complete — check the work before calling it done
 		completedCount := i - 1
+		l.mu.Lock()
+		l.busy = false
+		draining := l.draining
+		l.mu.Unlock()
+		if draining {
+			l.drained(completedCount)
+			return
+		}
+		if l.config.SuccessCmd != "" {
+			if l.checkSuccess(ctx, completedCount) {
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+
+		// Send completion marker
 		total := l.GetIterations()
+		l.saveState(completedCount, true)
 		l.output <- Message{
 			Type:    "complete",
 			Content: fmt.Sprintf("======= COMPLETED %d ITERATIONS =======", total),
@@ -453,9 +1263,11 @@ func (l *Loop) run(ctx context.Context) {
 			Total:   total,
 		}
 
-		// Enter waiting state: stay alive for potential new iterations
+		// Enter waiting state: stay alive for potential new iterations.
+		// There is no next iteration for a pending pause to stop before.
 		l.mu.Lock()
 		l.completedWaiting = true
+		l.pausePending = false
 		l.mu.Unlock()
 
 		select {
@@ -470,6 +1282,13 @@ func (l *Loop) run(ctx context.Context) {
 			l.mu.Unlock()
 		}
 
+		// Redo the last iteration; it was already confirmed
+		if l.takeRedo() && completedCount > 0 {
+			i = completedCount
+			l.redoMarker(i)
+			continue
+		}
+
 		// Check if new iterations were actually added
 		newTotal := l.GetIterations()
 		if completedCount >= newTotal {
@@ -477,7 +1296,8 @@ func (l *Loop) run(ctx context.Context) {
 			continue
 		}
 
-		// Resume with new iterations
+		// Resume with new iterations; pressing resume already confirmed the next one
+		confirmed = i
 		l.output <- Message{
 			Type:    "loop_marker",
 			Content: "======= LOOP RESUMED =======",
@@ -488,19 +1308,66 @@ func (l *Loop) run(ctx context.Context) {
 	}
 }
 
+// modelFor returns the model for an attempt at an iteration that has failed
+// failed times: Config.FallbackModel once it has failed Config.FallbackAfter
+// times, else Config.Model.
+func (l *Loop) modelFor(failed int) string {
+	if l.config.FallbackModel != "" && failed >= max(1, l.config.FallbackAfter) {
+		return l.config.FallbackModel
+	}
+	return l.config.Model
+}
+
+// promptFor returns the prompt for the given iteration: FirstPrompt, when set,
+// for iteration 1 and Prompt for every other iteration.
+func (l *Loop) promptFor(iteration int) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if iteration == 1 && l.config.FirstPrompt != "" {
+		return l.config.FirstPrompt
+	}
+	return l.config.Prompt
+}
+
 // executeIteration runs a single Claude CLI iteration.
 func (l *Loop) executeIteration(ctx context.Context, iteration int) error {
+	// Prepare prompt with iteration-specific substitutions
+	prompt := l.promptFor(iteration)
+	if l.config.RenderPrompt != nil {
+		rendered, err := l.config.RenderPrompt(prompt, iteration, l.GetIterations())
+		if err != nil {
+			return err
+		}
+		prompt = rendered
+	}
+	promptToSend := strings.ReplaceAll(prompt, "$loop_iteration", strconv.Itoa(iteration))
+	promptToSend = strings.ReplaceAll(promptToSend, "$loop_total", strconv.Itoa(l.GetIterations()))
+	promptToSend = l.takeNudge() + promptToSend
+
+	// Kill an agent that goes quiet for Config.NoOutputTimeout
+	agentCtx := ctx
+	var stall context.CancelCauseFunc
+	if l.config.NoOutputTimeout > 0 {
+		agentCtx, stall = context.WithCancelCause(ctx)
+		defer stall(nil)
+	}
+
 	// Build the command using the configured builder
-	cmd := l.config.CommandBuilder(ctx, l.config.Prompt)
+	cmd := l.config.CommandBuilder(agentCtx, promptToSend)
+	agent := l.config.Backend.Name()
 
 	// If resuming after pause, add --resume flag with the captured session ID
 	l.mu.Lock()
 	resumeID := l.resumeSessionID
 	l.resumeSessionID = "" // consume it
 	l.mu.Unlock()
-	if resumeID != "" {
+	if resumeID != "" && l.config.Backend.SupportsResume() {
 		cmd.Args = append(cmd.Args, "--resume", resumeID)
 	}
+	// Every backend's CLI takes --model
+	if l.model != "" {
+		cmd.Args = append(cmd.Args, "--model", l.model)
+	}
 
 	// Set up stdin with the prompt
 	stdin, err := cmd.StdinPipe()
@@ -522,12 +1389,13 @@ func (l *Loop) executeIteration(ctx context.Context, iteration int) error {
 
 	// Start the command
 	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start claude: %w", err)
+		return fmt.Errorf("failed to start %s: %w", agent, err)
+	}
+	var agentOut io.Reader = stdout
+	watched := make(chan struct{})
+	if stall != nil {
+		agentOut = watchOutput(stdout, watched, l.config.NoOutputTimeout, func() { stall(ErrAgentStalled) })
 	}
-
-	// Prepare prompt with iteration-specific substitutions
-	promptToSend := strings.ReplaceAll(l.config.Prompt, "$loop_iteration", strconv.Itoa(iteration))
-	promptToSend = strings.ReplaceAll(promptToSend, "$loop_total", strconv.Itoa(l.GetIterations()))
 
 	// Write prompt to stdin
 	go func() {
@@ -535,58 +1403,124 @@ func (l *Loop) executeIteration(ctx context.Context, iteration int) error {
 		io.WriteString(stdin, promptToSend)
 	}()
 
+	// Record the raw stdout; a transcript that can't be written is reported
+	// and skipped rather than failing the iteration
+	transcript, err := l.config.Transcripts.Open(iteration)
+	if err != nil {
+		l.transcriptError(err, iteration)
+	}
+
 	// Wait for both streamOutput goroutines to finish before returning,
 	// so they don't race against channel close in run()
 	var wg sync.WaitGroup
+	out := &iterationOutput{}
+	l.lastOutput = out
 	wg.Add(2)
 
 	// Read stdout in a goroutine
 	go func() {
 		defer wg.Done()
-		l.streamOutput(stdout, iteration)
+		l.streamOutput(agentOut, l.config.StreamFormat, l.config.Backend.ParseLine, transcript, iteration, out)
 	}()
 
 	// Read stderr in a goroutine
 	go func() {
 		defer wg.Done()
-		l.streamOutput(stderr, iteration)
+		l.streamOutput(stderr, StreamFormatJSONL, nil, nil, iteration, out)
 	}()
 
 	// Wait for stream readers to finish processing all output BEFORE cmd.Wait(),
 	// because cmd.Wait() closes the pipes. Per Go docs: "it is incorrect to call
 	// Wait before all reads from the pipe have completed."
 	wg.Wait()
+	close(watched)
+
+	if transcript != nil {
+		if err := transcript.Close(); err != nil {
+			l.transcriptError(err, iteration)
+		}
+		if err := l.config.Transcripts.Prune(transcript.Name()); err != nil {
+			l.transcriptError(err, iteration)
+		}
+	}
 
 	// Wait for command to complete (process already exited at this point)
-	if err := cmd.Wait(); err != nil {
+	err = cmd.Wait()
+	if ctx.Err() == nil && errors.Is(context.Cause(agentCtx), ErrAgentStalled) {
+		return fmt.Errorf("%s %w: no output for %s", agent, ErrAgentStalled, l.config.NoOutputTimeout)
+	}
+	if err != nil {
 		// Don't return error for context cancellation
 		if ctx.Err() != nil {
 			return nil
 		}
-		return fmt.Errorf("claude command failed: %w", err)
+		// Some backends exit non-zero on a successful run
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && l.isSuccessCode(exitErr.ExitCode()) {
+			return nil
+		}
+		if !out.sawResult.Load() {
+			return fmt.Errorf("%s command failed: %w: %w", agent, ErrAgentCrashed, err)
+		}
+		return fmt.Errorf("%s command failed: %w", agent, err)
 	}
 
 	return nil
 }
 
-// streamOutput reads from a reader and sends lines to the output channel.
-func (l *Loop) streamOutput(r io.Reader, iteration int) {
-	scanner := bufio.NewScanner(r)
-	// Use a 10MB max buffer to handle very large Claude CLI responses
-	// (tool results with full file contents, long assistant messages, etc.)
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 10*1024*1024)
+// transcriptError reports a failure to record iteration's transcript.
+func (l *Loop) transcriptError(err error, iteration int) {
+	l.output <- Message{
+		Type:    "error",
+		Content: err.Error(),
+		Loop:    iteration,
+		Total:   l.GetIterations(),
+	}
+}
 
-	for scanner.Scan() {
-		l.output <- Message{
-			Type:    "output",
-			Content: scanner.Text(),
-			Loop:    iteration,
-			Total:   l.GetIterations(),
+// isSuccessCode reports whether an agent exit code is listed in
+// Config.SuccessCodes.
+func (l *Loop) isSuccessCode(code int) bool {
+	for _, c := range l.config.SuccessCodes {
+		if c == code {
+			return true
 		}
 	}
-	// Report scanner errors (e.g., lines exceeding buffer limit)
-	if err := scanner.Err(); err != nil {
+	return false
+}
+
+// streamOutput splits a reader into records per format (see SplitStream),
+// translates each with parse when it is set (see Backend.ParseLine) and sends
+// them to the output channel, noting what they show in out. Each raw record
+// is also written, one per line and redacted per Transcripts.Redact, to
+// transcript when it is not nil.
+func (l *Loop) streamOutput(r io.Reader, format string, parse func(string) []string, transcript *os.File, iteration int, out *iterationOutput) {
+	err := SplitStream(r, format, func(raw string) {
+		if transcript != nil {
+			line := raw
+			if l.config.Transcripts.Redact != nil {
+				line = l.config.Transcripts.Redact(raw)
+			}
+			io.WriteString(transcript, line+"\n")
+		}
+		records := []string{raw}
+		if parse != nil {
+			records = parse(raw)
+		}
+		for _, record := range records {
+			out.observe(record, l.config.DoneSentinel)
+			l.output <- Message{
+				Type:    "output",
+				Content: record,
+				Loop:    iteration,
+				Total:   l.GetIterations(),
+			}
+		}
+	})
+	// Report stream errors (e.g., lines exceeding buffer limit), then drain
+	// the rest so the agent doesn't block on a full pipe and hang the iteration
+	if err != nil {
+		io.Copy(io.Discard, r)
 		l.output <- Message{
 			Type:    "error",
 			Content: fmt.Sprintf("output stream error: %v", err),
@@ -0,0 +1,85 @@
+package loop
+
+import (
+	"bufio"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// WorkerLine is a line printed by one of the commands Supervise runs.
+type WorkerLine struct {
+	Worker int // index of the command in the slice given to Supervise
+	Text   string
+	Stderr bool // the line came from stderr
+}
+
+// Supervise runs cmds side by side, typically a ralph per git worktree
+// (--parallel), hands every line they print to onLine, and waits for all of
+// them. onLine is never called concurrently. It returns each command's
+// outcome in order: nil, or the error it failed to start or exited with.
+func Supervise(cmds []*exec.Cmd, onLine func(WorkerLine)) []error {
+	var mu sync.Mutex
+	emit := func(line WorkerLine) {
+		mu.Lock()
+		defer mu.Unlock()
+		onLine(line)
+	}
+
+	errs := make([]error, len(cmds))
+	var wg sync.WaitGroup
+	for i, cmd := range cmds {
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		stderr, err := cmd.StderrPipe()
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if err := cmd.Start(); err != nil {
+			errs[i] = err
+			continue
+		}
+		wg.Add(1)
+		go func(i int, cmd *exec.Cmd) {
+			defer wg.Done()
+			// Drain both pipes before Wait, which closes them
+			var streams sync.WaitGroup
+			streams.Add(2)
+			for _, s := range []struct {
+				r     io.Reader
+				isErr bool
+			}{{stdout, false}, {stderr, true}} {
+				go func(r io.Reader, isErr bool) {
+					defer streams.Done()
+					readLines(r, func(text string) {
+						emit(WorkerLine{Worker: i, Text: text, Stderr: isErr})
+					})
+				}(s.r, s.isErr)
+			}
+			streams.Wait()
+			errs[i] = cmd.Wait()
+		}(i, cmd)
+	}
+	wg.Wait()
+	return errs
+}
+
+// readLines calls fn with each line read from r, without its line ending,
+// until r is exhausted. Lines of any length are passed whole.
+func readLines(r io.Reader, fn func(string)) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			fn(strings.TrimRight(line, "\r\n"))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package loop
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os/exec"
+
+	"github.com/cloudosai/ralph-go/internal/vcs"
+)
+
+// StallNudgePrompt is injected ahead of the prompt after Config.StallNudgeAfter
+// consecutive iterations that left the work tree unchanged.
+const StallNudgePrompt = "You made no changes to the repository in the last iterations. " +
+	"Re-read the implementation plan and make concrete progress this iteration, " +
+	"or signal completion if there is nothing left to do.\n\n"
+
+// ProgressProbe returns a fingerprint of the agent's work. Two equal
+// fingerprints taken before and after an iteration mean it made no progress;
+// an empty fingerprint means progress could not be determined.
+type ProgressProbe func() string
+
+// NewGitProgressProbe returns a probe that fingerprints the git work tree: the
+// HEAD commit, the diff of uncommitted changes against it, and the list of
+// untracked files. Commits, edits and new files all change the fingerprint,
+// except under excludeDirs, which are left out of the diff and file list.
+// Outside a git repository the probe returns "", so stall detection never
+// fires there.
+func NewGitProgressProbe(excludeDirs []string) ProgressProbe {
+	pathspec := vcs.ExcludePathspec(excludeDirs)
+	return func() string {
+		h := sha256.New()
+		for _, args := range [][]string{
+			{"rev-parse", "HEAD"},
+			append([]string{"diff", "HEAD"}, pathspec...),
+			append([]string{"ls-files", "--others", "--exclude-standard"}, pathspec...),
+		} {
+			out, err := exec.Command("git", args...).Output()
+			if err != nil {
+				return ""
+			}
+			h.Write(out)
+		}
+		return hex.EncodeToString(h.Sum(nil))
+	}
+}
@@ -0,0 +1,160 @@
+package loop
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultQueueStatePath is where a multi-spec run records its position.
+const DefaultQueueStatePath = ".ralph/queue.json"
+
+// QueueState is the persisted position of a multi-spec run: the ordered spec
+// queue, the index of the spec being worked on, and the iterations completed
+// per spec. It lets an interrupted run resume at the spec it was on.
+type QueueState struct {
+	Specs     []string       `json:"specs"`
+	Index     int            `json:"index"`
+	Progress  map[string]int `json:"progress"` // spec path → iterations completed
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// SaveQueueState writes the queue state to path, creating parent directories
+// as needed. The file is replaced atomically so an interrupted write never
+// leaves a truncated state behind.
+func SaveQueueState(path string, s *QueueState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating queue state directory: %w", err)
+	}
+	s.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding queue state: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("writing queue state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing queue state: %w", err)
+	}
+	return nil
+}
+
+// LoadQueueState reads the queue state from path. A missing file returns
+// (nil, nil): there is nothing to resume.
+func LoadQueueState(path string) (*QueueState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading queue state: %w", err)
+	}
+	var s QueueState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parsing queue state %s: %w", path, err)
+	}
+	if s.Progress == nil {
+		s.Progress = map[string]int{}
+	}
+	if s.Index < 0 || s.Index > len(s.Specs) {
+		return nil, fmt.Errorf("queue state %s: index %d out of range for %d specs", path, s.Index, len(s.Specs))
+	}
+	return &s, nil
+}
+
+// CheckQueue reports whether the saved queue matches specs, the queue about to
+// run. A mismatch (specs added, removed or reordered) returns an error
+// describing the first difference, so callers can warn before resuming.
+func (s *QueueState) CheckQueue(specs []string) error {
+	if len(s.Specs) != len(specs) {
+		return fmt.Errorf("spec queue changed: saved %d specs, now %d", len(s.Specs), len(specs))
+	}
+	for i := range specs {
+		if s.Specs[i] != specs[i] {
+			return fmt.Errorf("spec queue changed at position %d: saved %s, now %s", i+1, s.Specs[i], specs[i])
+		}
+	}
+	return nil
+}
+
+// Current returns the spec at the saved position, or "" once the queue is done.
+func (s *QueueState) Current() string {
+	if s.Index >= len(s.Specs) {
+		return ""
+	}
+	return s.Specs[s.Index]
+}
+
+// SpecQueue works through a run's specs one at a time, PerSpec iterations
+// each: iterations 1 to PerSpec are on Specs[0], the next PerSpec on
+// Specs[1], and so on (see Config.SpecQueue).
+type SpecQueue struct {
+	Specs   []string // spec files, in the order they are worked on
+	PerSpec int      // iterations each spec gets
+	Path    string   // write the QueueState here after every iteration ("" = off)
+}
+
+// Index returns the position in the queue of the spec iteration works on,
+// len(Specs) past the end of the queue.
+func (q SpecQueue) Index(iteration int) int {
+	if q.PerSpec <= 0 || iteration < 1 {
+		return 0
+	}
+	return min((iteration-1)/q.PerSpec, len(q.Specs))
+}
+
+// Spec returns the spec iteration works on, or "" past the end of the queue.
+func (q SpecQueue) Spec(iteration int) string {
+	if i := q.Index(iteration); i < len(q.Specs) {
+		return q.Specs[i]
+	}
+	return ""
+}
+
+// State returns the queue's position after completed iterations.
+func (q SpecQueue) State(completed int) *QueueState {
+	s := &QueueState{Specs: q.Specs, Progress: map[string]int{}}
+	if q.PerSpec <= 0 {
+		return s
+	}
+	s.Index = min(completed/q.PerSpec, len(q.Specs))
+	for i, spec := range q.Specs {
+		switch {
+		case i < s.Index:
+			s.Progress[spec] = q.PerSpec
+		case i == s.Index:
+			s.Progress[spec] = completed % q.PerSpec
+		}
+	}
+	return s
+}
+
+// ResumeAt returns the iteration a run resuming from s starts at: the next
+// one on the spec it was on.
+func (q SpecQueue) ResumeAt(s *QueueState) int {
+	done := min(s.Progress[s.Current()], q.PerSpec-1)
+	return s.Index*q.PerSpec + max(done, 0) + 1
+}
+
+// saveQueueState records the spec queue's position after completed
+// iterations, when Config.SpecQueue.Path is set. A failure is reported on
+// the output channel; the run goes on.
+func (l *Loop) saveQueueState(completed int) {
+	q := l.config.SpecQueue
+	if q.Path == "" || q.PerSpec <= 0 {
+		return
+	}
+	if err := SaveQueueState(q.Path, q.State(completed)); err != nil {
+		l.output <- Message{
+			Type:    "error",
+			Content: err.Error(),
+			Loop:    completed,
+			Total:   l.GetIterations(),
+		}
+	}
+}
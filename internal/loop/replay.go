@@ -0,0 +1,130 @@
+package loop
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultReplayDelay is how long a replay waits before each recorded record
+// at normal speed. Transcripts don't record when each record arrived, so
+// replays are paced evenly.
+const DefaultReplayDelay = 200 * time.Millisecond
+
+// TranscriptFiles returns the transcripts in a run's transcript directory
+// (see Transcripts) in iteration order. A directory without any is an error.
+func TranscriptFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type transcript struct {
+		path      string
+		iteration int
+	}
+	var found []transcript
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".jsonl")
+		if e.IsDir() || !ok {
+			continue
+		}
+		n, err := strconv.Atoi(name)
+		if err != nil || n < 1 {
+			continue
+		}
+		found = append(found, transcript{filepath.Join(dir, e.Name()), n})
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no transcripts in %s (want <iteration>.jsonl files)", dir)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].iteration < found[j].iteration })
+	files := make([]string, len(found))
+	for i, t := range found {
+		files[i] = t.path
+	}
+	return files, nil
+}
+
+// NewReplay returns a Loop that plays files, recorded transcripts, back as
+// its output instead of running the agent: one iteration per file, each
+// record translated by backend (nil = ClaudeBackend) and sent after delay
+// (0 = at once). It stands in for a live Loop, so the output can be watched
+// as the run was. Pause holds the playback until Resume; Hibernate is
+// ignored, since the recorded run already waited.
+func NewReplay(files []string, backend Backend, delay time.Duration) *Loop {
+	l := New(Config{Iterations: len(files), Backend: backend})
+	l.replay = files
+	l.replayDelay = delay
+	return l
+}
+
+// runReplay plays the replay's transcripts, then waits for ctx to end so the
+// output stays open for review, as a completed run does.
+func (l *Loop) runReplay(ctx context.Context) {
+	defer close(l.output)
+	defer func() {
+		l.mu.Lock()
+		l.running = false
+		l.mu.Unlock()
+	}()
+
+	total := len(l.replay)
+	for i, path := range l.replay {
+		iteration := i + 1
+		l.output <- Message{
+			Type:    "loop_marker",
+			Content: fmt.Sprintf("======= LOOP %d/%d =======", iteration, total),
+			Loop:    iteration,
+			Total:   total,
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			l.output <- Message{Type: "error", Content: err.Error(), Loop: iteration, Total: total}
+			continue
+		}
+		// Once ctx ends the rest of the file is skipped over unsent
+		parse := func(raw string) []string {
+			if !l.replayWait(ctx, iteration) {
+				return nil
+			}
+			return l.config.Backend.ParseLine(raw)
+		}
+		l.streamOutput(f, StreamFormatJSONL, parse, nil, iteration, &iterationOutput{})
+		f.Close()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+
+	l.output <- Message{
+		Type:    "complete",
+		Content: fmt.Sprintf("======= REPLAYED %d ITERATIONS =======", total),
+		Loop:    total,
+		Total:   total,
+	}
+	<-ctx.Done()
+}
+
+// replayWait holds the next record for the replay delay, then for as long as
+// the replay is paused. It returns false once ctx ends.
+func (l *Loop) replayWait(ctx context.Context, iteration int) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(l.replayDelay):
+	}
+	if !l.IsPaused() {
+		return true
+	}
+	l.output <- Message{Type: "loop_marker", Content: "======= LOOP STOPPED =======", Loop: iteration, Total: len(l.replay)}
+	if !l.awaitResume(ctx) {
+		return false
+	}
+	l.output <- Message{Type: "loop_marker", Content: "======= LOOP RESUMED =======", Loop: iteration, Total: len(l.replay)}
+	return true
+}
@@ -0,0 +1,129 @@
+package loop
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudosai/ralph-go/internal/stats"
+)
+
+// DefaultRunStatePath is where a run records its state for `ralph resume`.
+const DefaultRunStatePath = ".ralph/state.json"
+
+// RunState is the persisted state of a run, written after every iteration
+// (see Config.StatePath). It lets a run that crashed, or whose machine
+// rebooted, continue from the iteration it was on.
+type RunState struct {
+	Args           []string       `json:"args"`                 // subcommand and flags the run was started with
+	Iteration      int            `json:"iteration"`            // iterations completed
+	Total          int            `json:"total"`                // iterations planned
+	SessionID      string         `json:"session_id,omitempty"` // latest agent session, resumed by the next iteration
+	Branch         string         `json:"branch,omitempty"`     // branch the run created for its work (--branch)
+	Specs          []string       `json:"specs,omitempty"`      // spec files the run covers (--specs, or picked with --spec-select)
+	Paused         bool           `json:"paused"`               // the user paused the run
+	Hibernating    bool           `json:"hibernating"`          // waiting out a rate limit
+	HibernateUntil time.Time      `json:"hibernate_until"`      // when the rate limit resets
+	Stats          stats.Snapshot `json:"stats"`
+	Complete       bool           `json:"complete"` // the run finished; there is nothing to resume
+	UpdatedAt      time.Time      `json:"updated_at"`
+}
+
+// SaveRunState writes the run state to path, creating parent directories as
+// needed. The file is replaced atomically so an interrupted write never
+// leaves a truncated state behind.
+func SaveRunState(path string, s *RunState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating run state directory: %w", err)
+	}
+	s.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding run state: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("writing run state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing run state: %w", err)
+	}
+	return nil
+}
+
+// LoadRunState reads the run state from path. A missing file returns
+// (nil, nil): there is nothing to resume.
+func LoadRunState(path string) (*RunState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading run state: %w", err)
+	}
+	var s RunState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parsing run state %s: %w", path, err)
+	}
+	if s.Iteration < 0 || s.Total < 0 {
+		return nil, fmt.Errorf("run state %s: iteration %d/%d out of range", path, s.Iteration, s.Total)
+	}
+	return &s, nil
+}
+
+// CheckSpecs reports whether specs, the spec files a resumed run is about to
+// cover, are the ones the run was started with. A mismatch (specs added,
+// removed or reordered) returns an error describing the first difference, so
+// callers can warn before resuming.
+func (s *RunState) CheckSpecs(specs []string) error {
+	if len(s.Specs) != len(specs) {
+		return fmt.Errorf("specs changed: the run covered %d, now %d", len(s.Specs), len(specs))
+	}
+	for i := range specs {
+		if s.Specs[i] != specs[i] {
+			return fmt.Errorf("specs changed at position %d: the run covered %s, now %s", i+1, s.Specs[i], specs[i])
+		}
+	}
+	return nil
+}
+
+// saveState records the run state after completed iterations, when
+// Config.StatePath is set, and the spec queue's position. A failure is
+// reported on the output channel; the run goes on.
+func (l *Loop) saveState(completed int, complete bool) {
+	l.saveQueueState(completed)
+	if l.config.StatePath == "" {
+		return
+	}
+	l.mu.Lock()
+	s := &RunState{
+		Args:           l.config.StateArgs,
+		Iteration:      completed,
+		Total:          l.config.Iterations,
+		SessionID:      l.sessionID,
+		Branch:         l.config.StateBranch,
+		Specs:          l.config.StateSpecs,
+		Paused:         l.paused,
+		Hibernating:    l.hibernating,
+		HibernateUntil: l.hibernateUntil,
+		Complete:       complete,
+	}
+	l.mu.Unlock()
+	if !s.Hibernating {
+		s.HibernateUntil = time.Time{}
+	}
+	if l.config.StateStats != nil {
+		s.Stats = l.config.StateStats()
+	}
+	if err := SaveRunState(l.config.StatePath, s); err != nil {
+		l.output <- Message{
+			Type:    "error",
+			Content: err.Error(),
+			Loop:    completed,
+			Total:   s.Total,
+		}
+	}
+}
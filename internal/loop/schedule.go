@@ -0,0 +1,69 @@
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// windowLayout is the clock time format of a Window's ends.
+const windowLayout = "15:04"
+
+// Window is a daily time-of-day window in local time, e.g. 22:00-06:00, that
+// iterations may start in. One whose end is before its start runs past
+// midnight. The zero Window is unset: iterations may start at any time.
+type Window struct {
+	Start int // minutes after midnight the window opens
+	End   int // minutes after midnight it closes
+}
+
+// ParseWindow parses a window written as HH:MM-HH:MM, e.g. "22:00-06:00".
+func ParseWindow(s string) (Window, error) {
+	from, to, ok := strings.Cut(s, "-")
+	start, err1 := time.Parse(windowLayout, strings.TrimSpace(from))
+	end, err2 := time.Parse(windowLayout, strings.TrimSpace(to))
+	if !ok || err1 != nil || err2 != nil {
+		return Window{}, fmt.Errorf("want HH:MM-HH:MM in 24-hour time, e.g. 22:00-06:00, got %q", s)
+	}
+	w := Window{Start: start.Hour()*60 + start.Minute(), End: end.Hour()*60 + end.Minute()}
+	if w.IsZero() {
+		return Window{}, fmt.Errorf("the window must not start and end at the same time, got %q", s)
+	}
+	return w, nil
+}
+
+// IsZero reports whether w is unset.
+func (w Window) IsZero() bool {
+	return w.Start == w.End
+}
+
+// String returns w as HH:MM-HH:MM.
+func (w Window) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+}
+
+// Contains reports whether t, in its own location, is inside w. An unset
+// window contains every time.
+func (w Window) Contains(t time.Time) bool {
+	if w.IsZero() {
+		return true
+	}
+	m := t.Hour()*60 + t.Minute()
+	if w.Start < w.End {
+		return m >= w.Start && m < w.End
+	}
+	return m >= w.Start || m < w.End
+}
+
+// Next returns the first time at or after t that is inside w: t itself if
+// w contains it, else when w next opens.
+func (w Window) Next(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+	open := time.Date(t.Year(), t.Month(), t.Day(), w.Start/60, w.Start%60, 0, 0, t.Location())
+	if open.Before(t) {
+		open = time.Date(t.Year(), t.Month(), t.Day()+1, w.Start/60, w.Start%60, 0, 0, t.Location())
+	}
+	return open
+}
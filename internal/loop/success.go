@@ -0,0 +1,59 @@
+package loop
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudosai/ralph-go/internal/hooks"
+)
+
+// SuccessFixPrompt is injected ahead of the prompt of a remediation iteration
+// after Config.SuccessCmd failed; the verbs are the command and the tail of
+// its output.
+const SuccessFixPrompt = "The run's success check `%s` failed:\n\n%s\n\n" +
+	"Fix what makes it fail before doing anything else.\n\n"
+
+// checkSuccess runs Config.SuccessCmd once the iterations are done, after
+// iteration i, and reports its result as a "SUCCESS HOOK" loop_marker. When
+// it fails and remediations are left, it queues SuccessFixPrompt, adds one
+// iteration and returns true so the run goes on. Otherwise the result is
+// recorded for SuccessFailed.
+func (l *Loop) checkSuccess(ctx context.Context, i int) bool {
+	res := hooks.Run(ctx, l.config.SuccessCmd, "", l.config.HookTimeout)
+	if ctx.Err() != nil {
+		return false
+	}
+	total := l.GetIterations()
+	l.output <- Message{
+		Type:    "loop_marker",
+		Content: hookMarker("SUCCESS", res),
+		Loop:    i,
+		Total:   total,
+	}
+
+	l.mu.Lock()
+	l.successFailed = !res.Passed()
+	l.mu.Unlock()
+	if res.Passed() || l.remediations >= l.config.SuccessRetries {
+		return false
+	}
+
+	l.remediations++
+	l.Nudge(fmt.Sprintf(SuccessFixPrompt, l.config.SuccessCmd, res.Tail()))
+	l.SetIterations(total + 1)
+	l.output <- Message{
+		Type:    "loop_marker",
+		Content: fmt.Sprintf("======= SUCCESS CHECK FAILED: REMEDIATION %d/%d =======", l.remediations, l.config.SuccessRetries),
+		Loop:    i,
+		Total:   total + 1,
+	}
+	return true
+}
+
+// SuccessFailed reports whether Config.SuccessCmd failed the last time it
+// ran, after any remediation iterations. It is false when no check ran.
+func (l *Loop) SuccessFailed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.successFailed
+}
@@ -0,0 +1,30 @@
+package loop
+
+import "fmt"
+
+// Thrash actions: what the loop does when the agent keeps repeating the same
+// tool call (Config.ThrashAction, --thrash-action).
+const (
+	ThrashWarn  = "warn"  // only report it
+	ThrashNudge = "nudge" // also tell the agent in the next iteration's prompt
+	ThrashStop  = "stop"  // stop the run
+)
+
+// ThrashNudgePrompt is injected ahead of the next prompt when the agent was
+// caught repeating a tool call; %s is the call's title.
+const ThrashNudgePrompt = "You repeated the same tool call (%s) many times in the last iterations " +
+	"without it getting you anywhere. Stop repeating it: use what it already told you, " +
+	"or try a different approach.\n\n"
+
+// Thrashing applies Config.ThrashAction to a tool call the agent keeps
+// repeating and returns the action taken ("" or ThrashWarn when it was only
+// reported). Thread-safe: can be called from any goroutine.
+func (l *Loop) Thrashing(title string) string {
+	switch l.config.ThrashAction {
+	case ThrashNudge:
+		l.Nudge(fmt.Sprintf(ThrashNudgePrompt, title))
+	case ThrashStop:
+		l.Stop()
+	}
+	return l.config.ThrashAction
+}
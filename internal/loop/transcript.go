@@ -0,0 +1,113 @@
+package loop
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// DefaultTranscriptDir is where runs record their agent's raw output, a
+// directory per run.
+const DefaultTranscriptDir = ".ralph/transcripts"
+
+// Transcripts has the loop record each iteration's raw agent output to
+// Dir/<RunID>/<iteration>.jsonl so a run can be audited later. Retries of an
+// iteration append to its transcript. After every iteration the oldest
+// transcripts, across all runs in Dir, are deleted until at most MaxFiles
+// remain, totalling at most MaxBytes.
+type Transcripts struct {
+	Dir      string // where transcripts go ("" = off)
+	RunID    string // names this run's directory under Dir
+	MaxFiles int    // transcripts kept across all runs (0 = unlimited)
+	MaxBytes int64  // total size of the transcripts kept (0 = unlimited)
+
+	// Redact, when set, strips secrets from each record before it is written
+	Redact func(string) string
+}
+
+// Path returns the transcript file for iteration.
+func (t Transcripts) Path(iteration int) string {
+	return filepath.Join(t.Dir, t.RunID, strconv.Itoa(iteration)+".jsonl")
+}
+
+// Open opens iteration's transcript for appending, creating Dir with a
+// .gitignore that keeps transcripts out of `git status` (and so out of stall
+// detection). It returns nil when transcripts are off.
+func (t Transcripts) Open(iteration int) (*os.File, error) {
+	if t.Dir == "" {
+		return nil, nil
+	}
+	path := t.Path(iteration)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("creating transcript directory: %w", err)
+	}
+	ignore := filepath.Join(t.Dir, ".gitignore")
+	if _, err := os.Stat(ignore); os.IsNotExist(err) {
+		if err := os.WriteFile(ignore, []byte("*\n"), 0644); err != nil {
+			return nil, fmt.Errorf("creating transcript directory: %w", err)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening transcript: %w", err)
+	}
+	return f, nil
+}
+
+// Prune deletes the oldest transcripts in Dir until MaxFiles and MaxBytes
+// are met, then any run directories left empty. The transcript at keep is
+// never deleted, so the latest iteration survives even when it alone is over
+// MaxBytes.
+func (t Transcripts) Prune(keep string) error {
+	if t.Dir == "" || (t.MaxFiles <= 0 && t.MaxBytes <= 0) {
+		return nil
+	}
+	type transcript struct {
+		path string
+		info fs.FileInfo
+	}
+	var files []transcript
+	var total int64
+	err := filepath.WalkDir(t.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".jsonl" {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, transcript{path, info})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("pruning transcripts: %w", err)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].info.ModTime().Equal(files[j].info.ModTime()) {
+			return files[i].info.ModTime().Before(files[j].info.ModTime())
+		}
+		return files[i].path < files[j].path
+	})
+
+	count := len(files)
+	for _, f := range files {
+		if (t.MaxFiles <= 0 || count <= t.MaxFiles) && (t.MaxBytes <= 0 || total <= t.MaxBytes) {
+			break
+		}
+		if f.path == keep {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			return fmt.Errorf("pruning transcripts: %w", err)
+		}
+		count--
+		total -= f.info.Size()
+		// Only succeeds once the run's last transcript is gone
+		os.Remove(filepath.Dir(f.path))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package loop
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ErrAgentStalled is returned (wrapped) when the agent is killed for writing
+// no output for Config.NoOutputTimeout.
+var ErrAgentStalled = errors.New("agent stalled")
+
+// activityReader wraps an agent's stdout, noting when it last wrote anything.
+type activityReader struct {
+	r    io.Reader
+	last *atomic.Int64 // UnixNano of the latest read that returned data
+}
+
+func (a activityReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if n > 0 {
+		a.last.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+// watchOutput returns r, an agent's stdout, wrapped to call kill once the
+// agent has written nothing to it for timeout. The watch ends when done is
+// closed.
+func watchOutput(r io.Reader, done <-chan struct{}, timeout time.Duration, kill func()) io.Reader {
+	a := activityReader{r: r, last: new(atomic.Int64)}
+	a.last.Store(time.Now().UnixNano())
+	go watchActivity(done, a.last, timeout, kill)
+	return a
+}
+
+// watchActivity calls kill once last, noted by an activityReader, is timeout
+// ago. It returns after calling kill or when done is closed.
+func watchActivity(done <-chan struct{}, last *atomic.Int64, timeout time.Duration, kill func()) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+			quiet := time.Since(time.Unix(0, last.Load()))
+			if quiet >= timeout {
+				kill()
+				return
+			}
+			timer.Reset(timeout - quiet)
+		}
+	}
+}
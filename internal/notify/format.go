@@ -0,0 +1,166 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudosai/ralph-go/internal/stats"
+)
+
+// Colors for the Slack attachment bar and Discord embed.
+const (
+	colorSuccess = 0x2EB67D
+	colorError   = 0xE01E5A
+	colorInfo    = 0x4A90D9
+)
+
+// field is one labelled value in a formatted message.
+type field struct {
+	name, value string
+}
+
+// encode returns the request body for p in the notifier's format.
+func (n *Notifier) encode(p Payload) ([]byte, error) {
+	switch n.format {
+	case Slack:
+		return json.Marshal(slackMessage(p))
+	case Discord:
+		return json.Marshal(discordMessage(p))
+	default:
+		return json.Marshal(p)
+	}
+}
+
+// title is the one-line headline for p, e.g.
+// "✅ ralph run finished: owner/repo (main)".
+func title(p Payload) string {
+	var what string
+	switch p.Event {
+	case RunStart:
+		what = "ℹ️ ralph run started"
+	case IterationComplete:
+		what = fmt.Sprintf("ℹ️ ralph iteration %d complete", p.Iteration)
+	case Error:
+		what = "⚠️ ralph hit an error"
+	case Hibernate:
+		what = "⏸️ ralph is hibernating"
+	case BudgetExceeded:
+		what = "💸 ralph is over its hourly budget"
+	case RunComplete:
+		if p.Outcome == "error" {
+			what = "❌ ralph run failed"
+		} else {
+			what = "✅ ralph run finished"
+		}
+	default:
+		what = "ralph " + p.Event
+	}
+	if p.Repo != "" {
+		what += ": " + p.Repo
+		if p.Branch != "" {
+			what += " (" + p.Branch + ")"
+		}
+	}
+	return what
+}
+
+// fields lists p's values worth showing, leaving out those not set.
+func fields(p Payload) []field {
+	var fs []field
+	if p.CostUSD != 0 {
+		fs = append(fs, field{"Cost", stats.FormatCost(p.CostUSD)})
+	}
+	if p.Tokens != 0 {
+		fs = append(fs, field{"Tokens", stats.FormatTokens(p.Tokens)})
+	}
+	if p.Iterations != 0 {
+		fs = append(fs, field{"Iterations", fmt.Sprint(p.Iterations)})
+	} else if p.Iteration != 0 && p.Event != IterationComplete {
+		fs = append(fs, field{"Iteration", fmt.Sprint(p.Iteration)})
+	}
+	if p.ElapsedSeconds != 0 {
+		fs = append(fs, field{"Elapsed", (time.Duration(p.ElapsedSeconds) * time.Second).String()})
+	}
+	fs = append(fs, field{"Run", p.RunID})
+	return fs
+}
+
+// color is the accent color for p.
+func color(p Payload) int {
+	switch {
+	case p.Event == Error || p.Event == BudgetExceeded || (p.Event == RunComplete && p.Outcome == "error"):
+		return colorError
+	case p.Event == RunComplete:
+		return colorSuccess
+	default:
+		return colorInfo
+	}
+}
+
+// summary is p as plain text: the title, then the fields on one line, then
+// the message.
+func summary(p Payload) string {
+	var parts []string
+	for _, f := range fields(p) {
+		parts = append(parts, f.name+": "+f.value)
+	}
+	text := title(p) + "\n" + strings.Join(parts, " · ")
+	if p.Message != "" {
+		text += "\n" + p.Message
+	}
+	return text
+}
+
+// slackMessage formats p for a Slack incoming webhook: a colored attachment
+// with the fields side by side, and plain text for notifications.
+func slackMessage(p Payload) map[string]interface{} {
+	var fs []map[string]interface{}
+	for _, f := range fields(p) {
+		fs = append(fs, map[string]interface{}{"title": f.name, "value": f.value, "short": true})
+	}
+	attachment := map[string]interface{}{
+		"color":    fmt.Sprintf("#%06X", color(p)),
+		"title":    title(p),
+		"fields":   fs,
+		"fallback": summary(p),
+		"ts":       p.Time.Unix(),
+	}
+	if p.Message != "" {
+		attachment["text"] = truncate(p.Message, 3000)
+	}
+	return map[string]interface{}{
+		"text":        title(p),
+		"attachments": []interface{}{attachment},
+	}
+}
+
+// discordMessage formats p for a Discord webhook: one embed with the fields
+// inline.
+func discordMessage(p Payload) map[string]interface{} {
+	var fs []map[string]interface{}
+	for _, f := range fields(p) {
+		fs = append(fs, map[string]interface{}{"name": f.name, "value": f.value, "inline": true})
+	}
+	embed := map[string]interface{}{
+		"title":     truncate(title(p), 256),
+		"color":     color(p),
+		"fields":    fs,
+		"timestamp": p.Time.Format(time.RFC3339),
+	}
+	if p.Message != "" {
+		embed["description"] = truncate(p.Message, 4096)
+	}
+	return map[string]interface{}{"embeds": []interface{}{embed}}
+}
+
+// truncate shortens s to at most n runes, the most a Slack or Discord field
+// takes.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
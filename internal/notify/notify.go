@@ -0,0 +1,154 @@
+// Package notify POSTs a JSON payload to a webhook when something happens in
+// a run: it starts, an iteration completes, an error or rate-limit
+// hibernation occurs, the cost budget is exceeded, or the run completes.
+// The payload is ralph's own JSON, or a message formatted for a Slack or
+// Discord incoming webhook. Payloads are sent in order in the background, so
+// a slow webhook never holds up the run. With no webhook configured New
+// returns nil, and a nil *Notifier ignores every call, so callers need no
+// checks of their own.
+package notify
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudosai/ralph-go/internal/poster"
+)
+
+// Events a webhook can be sent for (--notify-events).
+const (
+	RunStart          = "run_start"
+	IterationComplete = "iteration_complete"
+	Error             = "error"
+	Hibernate         = "hibernate"
+	BudgetExceeded    = "budget_exceeded"
+	RunComplete       = "run_complete"
+)
+
+// Events lists every event, in the order a run meets them.
+var Events = []string{RunStart, IterationComplete, Error, Hibernate, BudgetExceeded, RunComplete}
+
+// queueSize is how many payloads can wait on a slow webhook. Send drops a
+// payload when the queue is full: a missed notification costs less than a
+// stalled iteration.
+const queueSize = 64
+
+// Payload is the JSON body POSTed for an event. Fields that do not apply to
+// the event are left out.
+type Payload struct {
+	Event          string    `json:"event"`
+	Time           time.Time `json:"time"`
+	RunID          string    `json:"run_id"`
+	Repo           string    `json:"repo,omitempty"`   // owner/name
+	Branch         string    `json:"branch,omitempty"` // branch the run started on
+	Iteration      int       `json:"iteration,omitempty"`
+	Message        string    `json:"message,omitempty"`         // error text, loop marker, ...
+	CostUSD        float64   `json:"cost_usd,omitempty"`        // the iteration's or run's cost, or the hourly spend over budget
+	Tokens         int64     `json:"tokens,omitempty"`          // the iteration's or run's tokens
+	Outcome        string    `json:"outcome,omitempty"`         // run_complete: "success" or "error"
+	Iterations     int       `json:"iterations,omitempty"`      // run_complete: iterations completed
+	ElapsedSeconds float64   `json:"elapsed_seconds,omitempty"` // run_complete: how long the run took
+}
+
+// Format is how a payload is encoded for the webhook.
+type Format int
+
+const (
+	JSON    Format = iota // Payload as JSON, for your own receiver
+	Slack                 // a Slack incoming webhook message
+	Discord               // a Discord webhook message
+)
+
+// Notifier sends a run's event payloads to one webhook, in the order Send
+// queued them. Send and Close may be called from any goroutine.
+type Notifier struct {
+	format Format
+	events map[string]bool
+	base   Payload // run fields stamped on every payload
+	now    func() time.Time
+	poster *poster.Poster
+
+	mu  sync.Mutex // guards err
+	err error      // first payload that could not be encoded
+}
+
+// New returns a Notifier POSTing the given events (all of them when events
+// is empty) to url in format, with the run's ID, repository and branch on
+// every payload. It returns nil when url is empty, which turns notifications
+// off.
+func New(url string, format Format, events []string, runID, repo, branch string) *Notifier {
+	if url == "" {
+		return nil
+	}
+	if len(events) == 0 {
+		events = Events
+	}
+	n := &Notifier{
+		format: format,
+		events: make(map[string]bool, len(events)),
+		base:   Payload{RunID: runID, Repo: strings.Trim(repo, "/"), Branch: branch},
+		now:    time.Now,
+		poster: poster.New(url, queueSize),
+	}
+	for _, e := range events {
+		n.events[e] = true
+	}
+	return n
+}
+
+// Send queues p for the webhook if its event is enabled, stamping the time
+// and the run's fields on it.
+func (n *Notifier) Send(p Payload) {
+	if n == nil || !n.events[p.Event] {
+		return
+	}
+	p.Time = n.now().UTC()
+	p.RunID, p.Repo, p.Branch = n.base.RunID, n.base.Repo, n.base.Branch
+	body, err := n.encode(p)
+	if err != nil {
+		n.mu.Lock()
+		if n.err == nil {
+			n.err = err
+		}
+		n.mu.Unlock()
+		return
+	}
+	n.poster.Queue(poster.Request{What: p.Event + " webhook", Body: body})
+}
+
+// Close stops accepting payloads and waits until the queued ones are sent or
+// ctx is done. It returns the first delivery error.
+func (n *Notifier) Close(ctx context.Context) error {
+	if n == nil {
+		return nil
+	}
+	err := n.poster.Close(ctx)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return errors.Join(n.err, err)
+}
+
+// Group is a set of notifiers sent the same payloads, e.g. --notify-webhook
+// and --notify-slack together. Nil notifiers in it are skipped.
+type Group []*Notifier
+
+// Send queues p on every notifier in the group.
+func (g Group) Send(p Payload) {
+	for _, n := range g {
+		n.Send(p)
+	}
+}
+
+// Close closes every notifier in the group and returns the first error.
+func (g Group) Close(ctx context.Context) error {
+	var first error
+	for _, n := range g {
+		if err := n.Close(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,89 @@
+package parser
+
+import "strings"
+
+// maxResultPreviewRunes caps the tool result preview on a display line.
+const maxResultPreviewRunes = 120
+
+// DisplayRole says what a DisplayLine shows.
+type DisplayRole string
+
+const (
+	DisplayThinking   DisplayRole = "thinking"
+	DisplayAssistant  DisplayRole = "assistant"
+	DisplayTool       DisplayRole = "tool"
+	DisplayToolResult DisplayRole = "tool_result"
+)
+
+// DisplayLine is one normalized line of a message as the TUI feed and the
+// CLI show it.
+type DisplayLine struct {
+	Role      DisplayRole
+	Text      string   // thinking or assistant text, the tool call's label, or the result preview
+	ToolName  string   // tool lines: the tool called
+	Kind      ToolKind // tool lines: the call's kind
+	Location  string   // tool lines: the file, pattern or command it targets
+	ToolUseID string   // tool and tool_result lines: the call's ID
+	IsError   bool     // tool_result lines: the call failed
+}
+
+// FormatForDisplay turns extracted content into display lines: thinking
+// first, then assistant text, tool calls and tool results, each kept in
+// message order. Empty text is dropped, and so are TodoWrite calls, which
+// the plan represents instead. A tool call reads as its title, followed by
+// its location when the title does not already show it; a tool result reads
+// as the first non-blank line of its content.
+func FormatForDisplay(content *ParsedContent) []DisplayLine {
+	if content == nil {
+		return nil
+	}
+	var lines []DisplayLine
+	if content.Thinking != "" {
+		lines = append(lines, DisplayLine{Role: DisplayThinking, Text: content.Thinking})
+	}
+	for _, text := range content.TextContent {
+		if text != "" {
+			lines = append(lines, DisplayLine{Role: DisplayAssistant, Text: text})
+		}
+	}
+	for _, toolUse := range content.ToolUses {
+		if toolUse.Name == "TodoWrite" {
+			continue
+		}
+		label := toolUse.Title
+		if label == "" {
+			label = "Using tool: " + toolUse.Name
+		}
+		if toolUse.Location != "" && !strings.Contains(label, toolUse.Location) {
+			label += " — " + toolUse.Location
+		}
+		lines = append(lines, DisplayLine{
+			Role:      DisplayTool,
+			Text:      label,
+			ToolName:  toolUse.Name,
+			Kind:      toolUse.Kind,
+			Location:  toolUse.Location,
+			ToolUseID: toolUse.ID,
+		})
+	}
+	for _, result := range content.ToolResults {
+		lines = append(lines, DisplayLine{
+			Role:      DisplayToolResult,
+			Text:      resultPreview(result.Content),
+			ToolUseID: result.ToolUseID,
+			IsError:   result.IsError,
+		})
+	}
+	return lines
+}
+
+// resultPreview returns the first non-blank line of a tool result, capped at
+// maxResultPreviewRunes.
+func resultPreview(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return truncateRunes(line, maxResultPreviewRunes)
+		}
+	}
+	return ""
+}
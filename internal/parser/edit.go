@@ -0,0 +1,91 @@
+package parser
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Summary phrasings of an edit's size, e.g. "Updated main.go with 3 additions
+// and 1 removal", "2 insertions(+), 1 deletion(-)" or "Added 4 lines,
+// removed 2 lines". Each matches a count then the word for its direction.
+var (
+	editAddedPattern   = regexp.MustCompile(`(?i)(\d+)\s+(?:additions?|insertions?(?:\(\+\))?|lines?\s+added)|added\s+(\d+)\s+lines?`)
+	editRemovedPattern = regexp.MustCompile(`(?i)(\d+)\s+(?:removals?|deletions?(?:\(-\))?|lines?\s+removed)|removed\s+(\d+)\s+lines?`)
+)
+
+// editPatch is the structured form of an edit result: hunks whose lines are
+// prefixed "+" (added), "-" (removed) or " " (context).
+type editPatch struct {
+	StructuredPatch []struct {
+		Lines []string `json:"lines"`
+	} `json:"structuredPatch"`
+}
+
+// ParseEditResult reports how many lines an Edit tool result added and
+// removed. It understands a JSON result carrying a structuredPatch, a unified
+// diff, and one-line summaries such as "with 3 additions and 1 removal".
+// ok is false when the result gives no line counts, e.g. a plain "has been
+// updated" confirmation or an error.
+func ParseEditResult(result string) (added, removed int, ok bool) {
+	result = strings.TrimSpace(result)
+	if result == "" {
+		return 0, 0, false
+	}
+
+	if strings.HasPrefix(result, "{") {
+		var patch editPatch
+		if err := json.Unmarshal([]byte(result), &patch); err != nil || patch.StructuredPatch == nil {
+			return 0, 0, false
+		}
+		for _, hunk := range patch.StructuredPatch {
+			a, r := countDiffLines(hunk.Lines)
+			added += a
+			removed += r
+		}
+		return added, removed, true
+	}
+
+	if strings.HasPrefix(result, "@@") || strings.Contains(result, "\n@@") {
+		added, removed = countDiffLines(strings.Split(result, "\n"))
+		return added, removed, true
+	}
+
+	a, aok := firstCount(editAddedPattern, result)
+	r, rok := firstCount(editRemovedPattern, result)
+	if !aok && !rok {
+		return 0, 0, false
+	}
+	return a, r, true
+}
+
+// countDiffLines counts added and removed lines in unified diff lines,
+// skipping the "+++"/"---" file headers.
+func countDiffLines(lines []string) (added, removed int) {
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		case strings.HasPrefix(line, "+"):
+			added++
+		case strings.HasPrefix(line, "-"):
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// firstCount returns the number captured by re's first match in s.
+func firstCount(re *regexp.Regexp, s string) (int, bool) {
+	m := re.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+	for _, group := range m[1:] {
+		if group != "" {
+			n, err := strconv.Atoi(group)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
@@ -2,10 +2,13 @@ package parser
 
 import (
 	"encoding/json"
+	"fmt"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // APIError represents a structured API error object (e.g., from 500 responses)
@@ -105,6 +108,78 @@ func ClassifyToolKind(name string) ToolKind {
 	}
 }
 
+// SetMaxToolResultBytes caps the size of tool result content returned by
+// ExtractContent; n <= 0 disables trimming. Token accounting is unaffected:
+// usage comes from the API's counts, not from the content.
+func (p *Parser) SetMaxToolResultBytes(n int) {
+	p.maxToolResultBytes = n
+}
+
+// trimToolResult cuts text to the configured byte limit, backing up to a
+// rune boundary, and notes how many bytes were dropped.
+func (p *Parser) trimToolResult(text string) string {
+	limit := p.maxToolResultBytes
+	if limit <= 0 || len(text) <= limit {
+		return text
+	}
+	for limit > 0 && !utf8.RuneStart(text[limit]) {
+		limit--
+	}
+	return fmt.Sprintf("%s…(truncated %d bytes)", text[:limit], len(text)-limit)
+}
+
+// SetRedactor sets the redactor applied to everything ExtractContent returns
+// for display or logging: text, thinking, tool inputs and tool results.
+func (p *Parser) SetRedactor(r *Redactor) {
+	p.redactor = r
+}
+
+// redact strips secrets from content on its way out of the parser.
+func (p *Parser) redact(content string) string {
+	return p.redactor.Redact(content)
+}
+
+// SetExcludeDirs sets the directories that ExcludedEditDir guards.
+func (p *Parser) SetExcludeDirs(dirs []string) {
+	p.excludeDirs = dirs
+}
+
+// ExcludedEditDir returns the excluded directory that a modifying tool call
+// (edit/delete/move) targets, or "" if the call is allowed. A directory
+// matches as a run of whole path components anywhere in the path, so
+// "node_modules" catches both "node_modules/x.js" and "/repo/web/node_modules/x.js".
+func (p *Parser) ExcludedEditDir(kind ToolKind, path string) string {
+	switch kind {
+	case ToolKindEdit, ToolKindDelete, ToolKindMove:
+	default:
+		return ""
+	}
+	if path == "" {
+		return ""
+	}
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(path)), "/")
+	for _, dir := range p.excludeDirs {
+		want := strings.Split(strings.Trim(filepath.ToSlash(filepath.Clean(dir)), "/"), "/")
+		if len(want) == 0 || want[0] == "" || want[0] == "." {
+			continue
+		}
+		// Only directories count: the last path component is the file itself
+		for i := 0; i+len(want) < len(parts); i++ {
+			match := true
+			for j, w := range want {
+				if parts[i+j] != w {
+					match = false
+					break
+				}
+			}
+			if match {
+				return dir
+			}
+		}
+	}
+	return ""
+}
+
 // buildToolTitle produces a short, human-readable label for a tool call,
 // e.g. "Read config.go", "Bash: go build ./...", or a Task description.
 func buildToolTitle(name string, kind ToolKind, input map[string]interface{}) string {
@@ -121,15 +196,15 @@ func buildToolTitle(name string, kind ToolKind, input map[string]interface{}) st
 		}
 	case ToolKindSearch:
 		if pattern, ok := input["pattern"].(string); ok && pattern != "" {
-			return name + " " + truncate(pattern, 50)
+			return name + " " + truncateRunes(pattern, 50)
 		}
 	case ToolKindExecute:
 		if cmd, ok := input["command"].(string); ok && cmd != "" {
-			return name + ": " + truncate(cmd, 50)
+			return name + ": " + truncateRunes(cmd, 50)
 		}
 	case ToolKindFetch:
 		if url := firstString(input, "url", "query"); url != "" {
-			return name + " " + truncate(url, 50)
+			return name + " " + truncateRunes(url, 50)
 		}
 	}
 	return name
@@ -145,9 +220,10 @@ func firstString(input map[string]interface{}, keys ...string) string {
 	return ""
 }
 
-// truncate shortens s to at most n runes, appending an ellipsis if cut.
+// truncateRunes shortens s to at most n runes, appending an ellipsis if cut.
 // It counts runes (not bytes) so multibyte UTF-8 is never split mid-rune.
-func truncate(s string, n int) string {
+// Every display truncation in the parser goes through it.
+func truncateRunes(s string, n int) string {
 	r := []rune(s)
 	if len(r) <= n {
 		return s
@@ -202,6 +278,8 @@ type ParsedMessage struct {
 	IsError         bool              `json:"is_error,omitempty"`
 	ErrorRaw        json.RawMessage   `json:"error,omitempty"`
 	RateLimitInfo   *RateLimitInfo    `json:"rate_limit_info,omitempty"`
+	Result          string            `json:"result,omitempty"` // Final text of a result message
+	Subtype         string            `json:"subtype,omitempty"` // Result subtype, e.g. "success" or "error_max_turns"
 	RawJSON         string         `json:"-"` // Original JSON for debugging
 }
 
@@ -249,6 +327,16 @@ type TaskReference struct {
 	Description string // Optional description (e.g., "Track IMPLEMENTATION_PLAN.md Phase/Task")
 }
 
+// ContextWarning describes a warning that the agent's context window is
+// nearly (or already) full. Token figures are filled in when the warning text
+// reports them and left at zero otherwise.
+type ContextWarning struct {
+	Text        string // The matched warning text
+	UsedTokens  int64  // Tokens in use, if reported
+	LimitTokens int64  // Context window size, if reported
+	PercentLeft int    // Percentage of context remaining, or -1 if not reported
+}
+
 // Parser handles parsing of Claude's stream-json output
 type Parser struct {
 	systemReminderRegex *regexp.Regexp
@@ -256,16 +344,59 @@ type Parser struct {
 	thinkingRegex       *regexp.Regexp
 	taskRegex           *regexp.Regexp
 	taskWithDescRegex   *regexp.Regexp
+	contextWarnRegex    *regexp.Regexp
+	contextSumRegex     *regexp.Regexp
+	contextRatioRegex   *regexp.Regexp
+	contextPercentRegex *regexp.Regexp
+	activityMarkerRegex *regexp.Regexp
+	activityIntentRegex *regexp.Regexp
+	activityFillerRegex *regexp.Regexp
+	sentenceEndRegex    *regexp.Regexp
+	excludeDirs         []string // directories the agent should not modify (see SetExcludeDirs)
+	maxToolResultBytes  int      // trim tool result content beyond this many bytes (0 = no limit)
+	toolDepths          map[string]int // tool_use ID → subagent depth of the message that issued it
+	opts                Options
+	redactor            *Redactor // strips secrets from extracted content (nil = off)
+	pendingQuestion     string    // main agent's last text, if it asked a question (see AwaitingInput)
+	thrashWindow        []map[string]int // tool call counts for recent iterations, oldest first (see ObserveThrash)
+	subagents           []*Subagent          // the current iteration's subagents, in spawn order (see ObserveSubagents)
+	subagentIDs         map[string]*Subagent // subagents by spawning Task ID
+	subagentMsgIDs      map[string]bool      // subagent message IDs whose usage is counted
+	subagentsEnded      bool                 // the main agent's result ended the iteration
+}
+
+// Options tunes how a Parser extracts content. The zero value gives the
+// default behavior of NewParser.
+type Options struct {
+	// KeepThinkingInText keeps text blocks that contain <thinking> in
+	// TextContent (with the tags removed) in addition to setting Thinking,
+	// instead of dropping them from TextContent.
+	KeepThinkingInText bool
 }
 
 // NewParser creates a new Parser instance
 func NewParser() *Parser {
+	return NewParserWithOptions(Options{})
+}
+
+// NewParserWithOptions creates a new Parser instance with the given options.
+func NewParserWithOptions(opts Options) *Parser {
 	return &Parser{
+		opts:                opts,
 		systemReminderRegex: regexp.MustCompile(`(?s)<system-reminder>.*?</system-reminder>`),
 		loopMarkerRegex:     regexp.MustCompile(`LOOP (\d+)/(\d+)`),
 		thinkingRegex:       regexp.MustCompile(`(?s)<thinking>(.*?)</thinking>`),
 		taskRegex:           regexp.MustCompile(`(?i)TASK\s+(\d+)`),
 		taskWithDescRegex:   regexp.MustCompile(`(?i)TASK\s+(\d+)\s*:\s*([^\[\n]+)`),
+		contextWarnRegex:    regexp.MustCompile(`(?i)context low\b|context left until auto-?compact|prompt is too long|exceeds? (the )?context (window|limit)|approaching (the )?context (window|limit)|context (window|limit) (is )?(nearly|almost) (full|reached|exhausted)`),
+		contextSumRegex:     regexp.MustCompile(`(\d[\d,]*)\s*\+\s*(\d[\d,]*)\s*>\s*(\d[\d,]*)`),
+		contextRatioRegex:   regexp.MustCompile(`(?i)(\d[\d,]*)\s*(?:tokens\s*)?(?:/|of)\s*(\d[\d,]*)`),
+		contextPercentRegex: regexp.MustCompile(`(?i)(\d+)%\s*(?:remaining|left)|auto-?compact:\s*(\d+)%`),
+		activityMarkerRegex: regexp.MustCompile(`(?im)^\s*(?:[-*]\s+)?(?:TODO|WIP)\s*:\s*(.+)$`),
+		activityIntentRegex: regexp.MustCompile(`(?i)^(?:(?:now|next|first|then)[,]?\s+)*(?:I'll|I will|I'm going to|I am going to|let me|let's|I need to)\s+(?:now\s+|also\s+|go ahead and\s+)?(.+)`),
+		activityFillerRegex: regexp.MustCompile(`(?i)^(?:(?:great|good|perfect|ok(?:ay)?|alright|excellent|done)[!.,]*\s+)+`),
+		sentenceEndRegex:    regexp.MustCompile(`[.!?:;](?:\s|$)|\n`),
+		toolDepths:          make(map[string]int),
 	}
 }
 
@@ -361,12 +492,16 @@ func (p *Parser) ExtractContent(msg *ParsedMessage) *ParsedContent {
 	for _, item := range msg.Message.Content {
 		switch item.Type {
 		case ContentTypeText:
-			text := p.StripSystemReminders(item.Text)
+			text := p.redact(p.StripSystemReminders(item.Text))
 			if text != "" {
 				// Check for thinking
 				thinking := p.ExtractThinking(text)
 				if thinking != "" {
 					content.Thinking = thinking
+					if p.opts.KeepThinkingInText {
+						unwrapped := strings.TrimSpace(p.thinkingRegex.ReplaceAllString(text, "$1"))
+						content.TextContent = append(content.TextContent, unwrapped)
+					}
 				} else {
 					content.TextContent = append(content.TextContent, text)
 				}
@@ -374,7 +509,7 @@ func (p *Parser) ExtractContent(msg *ParsedMessage) *ParsedContent {
 
 		case ContentTypeThinking:
 			if item.ThinkingText != "" {
-				content.Thinking = item.ThinkingText
+				content.Thinking = p.redact(item.ThinkingText)
 			}
 
 		case ContentTypeToolUse:
@@ -384,20 +519,19 @@ func (p *Parser) ExtractContent(msg *ParsedMessage) *ParsedContent {
 					inputJSON = string(jsonBytes)
 				}
 			}
+			// Redact before truncating so a cut can't hide part of a secret.
 			// Truncate to 150 characters like Python version
-			if len(inputJSON) > 150 {
-				inputJSON = inputJSON[:150]
-			}
+			inputJSON = truncateRunes(p.redact(inputJSON), 150)
 			location := ExtractFilePathFromInput(item.Input)
 			kind := ClassifyToolKind(item.Name)
 			content.ToolUses = append(content.ToolUses, ToolUse{
 				Name:      item.Name,
 				InputJSON: inputJSON,
-				FilePath:  location,
+				FilePath:  p.redact(location),
 				ID:        item.ID,
 				Kind:      kind,
-				Title:     buildToolTitle(item.Name, kind, item.Input),
-				Location:  location,
+				Title:     p.redact(buildToolTitle(item.Name, kind, item.Input)),
+				Location:  p.redact(location),
 			})
 			// A TodoWrite call carries the agent's full plan; synthesize the
 			// ACP-style plan entries from its input. Last call in a turn wins
@@ -416,7 +550,7 @@ func (p *Parser) ExtractContent(msg *ParsedMessage) *ParsedContent {
 					resultText = string(jsonBytes)
 				}
 			}
-			resultText = p.StripSystemReminders(resultText)
+			resultText = p.trimToolResult(p.redact(p.StripSystemReminders(resultText)))
 			// Always record a result that carries a tool_use_id so the TUI can
 			// update the matching tool row's status, even when the (stripped)
 			// content is empty.
@@ -479,11 +613,11 @@ func (p *Parser) GetSessionID(msg *ParsedMessage) string {
 	return msg.SessionID
 }
 
-// IsRateLimitRejected checks if message is a rate limit rejection.
-// Returns (true, resetTime) if rejected, (false, zero) otherwise.
-// Handles two patterns:
-// - Pattern 1: type: "rate_limit_event" with rate_limit_info.status == "rejected"
-// - Pattern 2: is_error: true with error: "rate_limit"
+// IsRateLimitRejected checks if message is a rate limit rejection: its
+// rate_limit_info.status is "rejected", as in a "rate_limit_event" or an
+// is_error message carrying the info.
+// Returns (true, resetTime) if rejected, (false, zero) otherwise. Rate limits
+// reported as error text are found by DetectThrottle.
 func (p *Parser) IsRateLimitRejected(msg *ParsedMessage) (bool, time.Time) {
 	if msg == nil || msg.RateLimitInfo == nil {
 		return false, time.Time{}
@@ -516,6 +650,31 @@ func (msg *ParsedMessage) GetError() string {
 	return ""
 }
 
+// IsErrorResult reports whether msg is a result message with is_error set:
+// the iteration failed, whatever it cost. Errored results often carry no
+// cost, so they must not be mistaken for cheap successful iterations.
+func (p *Parser) IsErrorResult(msg *ParsedMessage) bool {
+	return msg != nil && msg.Type == MessageTypeResult && msg.IsError
+}
+
+// ErrorSummary describes why an errored result failed: its error, else its
+// result text, else its subtype.
+func (msg *ParsedMessage) ErrorSummary() string {
+	if msg == nil {
+		return ""
+	}
+	if e := msg.GetError(); e != "" {
+		return e
+	}
+	if r := strings.TrimSpace(msg.Result); r != "" {
+		return r
+	}
+	if msg.Subtype != "" {
+		return msg.Subtype
+	}
+	return "unknown error"
+}
+
 // IsAPIOverloaded checks if message indicates an API 529 (overloaded) error.
 // Returns true if the message has is_error set and the error string contains
 // "529" or "overloaded" (case-insensitive).
@@ -563,6 +722,56 @@ func (p *Parser) IsAuthenticationError(msg *ParsedMessage) bool {
 		strings.Contains(errLower, "login")
 }
 
+// DetectContextWarning checks the error and result text of a message for a
+// context-window warning. Returns nil if the message carries no such warning.
+// Assistant text is deliberately not scanned: the agent discussing context
+// windows in its own output is not a warning from the CLI.
+func (p *Parser) DetectContextWarning(msg *ParsedMessage) *ContextWarning {
+	if msg == nil {
+		return nil
+	}
+	if w := p.DetectContextWarningText(msg.GetError()); w != nil {
+		return w
+	}
+	if msg.Type == MessageTypeResult || msg.Type == MessageTypeSystem {
+		return p.DetectContextWarningText(msg.Result)
+	}
+	return nil
+}
+
+// DetectContextWarningText checks a plain-text line (e.g. non-JSON stderr
+// output) for a context-window warning and extracts any reported token
+// figures. Returns nil if the text is not a context warning.
+func (p *Parser) DetectContextWarningText(text string) *ContextWarning {
+	text = strings.TrimSpace(text)
+	if text == "" || !p.contextWarnRegex.MatchString(text) {
+		return nil
+	}
+	w := &ContextWarning{Text: text, PercentLeft: -1}
+	// "input length and max_tokens exceed context limit: 190000 + 21333 > 200000"
+	if m := p.contextSumRegex.FindStringSubmatch(text); m != nil {
+		w.UsedTokens = parseTokenCount(m[1]) + parseTokenCount(m[2])
+		w.LimitTokens = parseTokenCount(m[3])
+	} else if m := p.contextRatioRegex.FindStringSubmatch(text); m != nil {
+		w.UsedTokens = parseTokenCount(m[1])
+		w.LimitTokens = parseTokenCount(m[2])
+	}
+	if m := p.contextPercentRegex.FindStringSubmatch(text); m != nil {
+		if m[1] != "" {
+			w.PercentLeft = parseInt(m[1])
+		} else {
+			w.PercentLeft = parseInt(m[2])
+		}
+	}
+	return w
+}
+
+// parseTokenCount parses a token figure such as "190,000". parseInt skips
+// non-digit characters, so thousands separators are ignored.
+func parseTokenCount(s string) int64 {
+	return int64(parseInt(s))
+}
+
 // GetMessageID returns the inner message ID (e.g., "msg_01PBx...") if present.
 // The CLI emits multiple chunks for the same message ID (one per content block),
 // each carrying identical cumulative usage. Callers can use this to deduplicate.
@@ -582,6 +791,40 @@ func (p *Parser) IsSubagentMessage(msg *ParsedMessage) bool {
 	return *msg.ParentToolUseID != ""
 }
 
+// GetParentToolUseID returns the ID of the tool call that spawned the
+// subagent msg comes from, or "" for the main agent.
+func (p *Parser) GetParentToolUseID(msg *ParsedMessage) string {
+	if !p.IsSubagentMessage(msg) {
+		return ""
+	}
+	return *msg.ParentToolUseID
+}
+
+// SubagentDepth returns how deeply msg is nested under subagents: 0 for the
+// main agent, 1 for a subagent it spawned, 2 for a subagent of that one, and
+// so on. Depth follows parent_tool_use_id back to the message that issued the
+// spawning tool call, so every tool_use seen here is recorded for later
+// messages. A parent that was never seen yields depth 0.
+func (p *Parser) SubagentDepth(msg *ParsedMessage) int {
+	if msg == nil {
+		return 0
+	}
+	depth := 0
+	if p.IsSubagentMessage(msg) {
+		if parent, ok := p.toolDepths[*msg.ParentToolUseID]; ok {
+			depth = parent + 1
+		}
+	}
+	if msg.Message != nil {
+		for _, item := range msg.Message.Content {
+			if item.Type == ContentTypeToolUse && item.ID != "" {
+				p.toolDepths[item.ID] = depth
+			}
+		}
+	}
+	return depth
+}
+
 // GetTaskToolUseIDs returns the IDs of any "Task" tool_use content items in the message.
 // These IDs correspond to subagents being spawned.
 func (p *Parser) GetTaskToolUseIDs(msg *ParsedMessage) []string {
@@ -615,10 +858,7 @@ func ExtractFilePathFromInput(input map[string]interface{}) string {
 	}
 	// Try command (Bash) - truncate to first 50 chars
 	if cmd, ok := input["command"].(string); ok && cmd != "" {
-		if len(cmd) > 50 {
-			return cmd[:50] + "..."
-		}
-		return cmd
+		return truncateRunes(cmd, 50)
 	}
 	// Try description (Task)
 	if desc, ok := input["description"].(string); ok && desc != "" {
@@ -671,6 +911,53 @@ func normalizePlanStatus(s string) PlanItemStatus {
 	}
 }
 
+// maxActivitySummaryLen caps the length of ExtractActivitySummary results so
+// they fit the footer's Current Task line.
+const maxActivitySummaryLen = 60
+
+// ExtractActivitySummary returns a short description of what the agent is
+// doing, for use as the current task when no explicit task reference exists.
+// It prefers an explicit "TODO:" / "WIP:" line, then falls back to a leading
+// statement of intent ("Now I'll implement the parser..." → "Implement the
+// parser"). Returns "" when the text has neither.
+func (p *Parser) ExtractActivitySummary(text string) string {
+	if m := p.activityMarkerRegex.FindStringSubmatch(text); m != nil {
+		return p.shortenActivity(m[1])
+	}
+
+	// Only the opening of the message is considered: intent stated mid-way
+	// through a long reply is usually commentary, not the current activity.
+	first := p.activityFillerRegex.ReplaceAllString(strings.TrimSpace(text), "")
+	if loc := p.sentenceEndRegex.FindStringIndex(first); loc != nil {
+		first = first[:loc[0]]
+	}
+	first = strings.NewReplacer("**", "", "`", "", "__", "").Replace(first)
+	m := p.activityIntentRegex.FindStringSubmatch(strings.TrimSpace(first))
+	if m == nil {
+		return ""
+	}
+	return p.shortenActivity(m[1])
+}
+
+// shortenActivity trims an activity string to its first sentence, capitalizes
+// it, and truncates it to maxActivitySummaryLen runes.
+func (p *Parser) shortenActivity(s string) string {
+	s = strings.NewReplacer("**", "", "`", "", "__", "").Replace(s)
+	if loc := p.sentenceEndRegex.FindStringIndex(s); loc != nil {
+		s = s[:loc[0]]
+	}
+	s = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(s), ".…"))
+	runes := []rune(s)
+	if len(runes) < 3 {
+		return ""
+	}
+	runes[0] = unicode.ToUpper(runes[0])
+	if len(runes) > maxActivitySummaryLen {
+		return strings.TrimSpace(string(runes[:maxActivitySummaryLen-1])) + "…"
+	}
+	return string(runes)
+}
+
 // ExtractTaskReference scans text for references to IMPLEMENTATION_PLAN.md tasks
 // (e.g., "TASK 6", "Task 3: Some Description"). Returns the last match found,
 // or nil if no task reference is detected.
@@ -0,0 +1,63 @@
+package parser
+
+import "strings"
+
+// maxQuestionRunes caps the question text AwaitingInput returns.
+const maxQuestionRunes = 300
+
+// IsQuestion reports whether text reads as a question put to the user: its
+// last non-blank character, ignoring closing markdown emphasis, quotes and
+// brackets, is a question mark.
+func IsQuestion(text string) bool {
+	text = strings.TrimRight(strings.TrimSpace(text), "*_`\"')]")
+	return strings.HasSuffix(text, "?")
+}
+
+// AwaitingInput tracks the main agent's turn and reports when it ended on a
+// question: its last text asked something and no tool call followed. In
+// non-interactive mode nobody answers, so the agent would stall. Call it on
+// every message; it returns the final paragraph of the question when msg is
+// the main agent's result, and "" otherwise.
+func (p *Parser) AwaitingInput(msg *ParsedMessage) string {
+	if msg == nil || p.IsSubagentMessage(msg) {
+		return ""
+	}
+	switch msg.Type {
+	case MessageTypeAssistant:
+		if msg.Message == nil {
+			return ""
+		}
+		for _, item := range msg.Message.Content {
+			switch item.Type {
+			case ContentTypeToolUse:
+				p.pendingQuestion = ""
+			case ContentTypeText:
+				p.pendingQuestion = ""
+				if IsQuestion(item.Text) {
+					p.pendingQuestion = item.Text
+				}
+			}
+		}
+	case MessageTypeResult:
+		question := p.pendingQuestion
+		p.pendingQuestion = ""
+		if msg.IsError {
+			return ""
+		}
+		return lastParagraph(question)
+	}
+	return ""
+}
+
+// lastParagraph returns the final blank-line-separated paragraph of text,
+// trimmed to maxQuestionRunes.
+func lastParagraph(text string) string {
+	text = strings.TrimSpace(text)
+	if i := strings.LastIndex(text, "\n\n"); i >= 0 {
+		text = strings.TrimSpace(text[i:])
+	}
+	if r := []rune(text); len(r) > maxQuestionRunes {
+		text = "…" + string(r[len(r)-maxQuestionRunes:])
+	}
+	return text
+}
@@ -0,0 +1,54 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// RedactedText replaces every secret matched by a Redactor.
+const RedactedText = "***"
+
+// DefaultRedactPatterns match common credential formats. They are always
+// applied by a Redactor, ahead of any user-supplied patterns.
+var DefaultRedactPatterns = []string{
+	`sk-ant-[A-Za-z0-9_-]{10,}`,                                     // Anthropic API keys
+	`sk-[A-Za-z0-9_-]{20,}`,                                         // OpenAI-style API keys
+	`gh[pousr]_[A-Za-z0-9]{20,}`,                                    // GitHub tokens
+	`github_pat_[A-Za-z0-9_]{20,}`,                                  // GitHub fine-grained tokens
+	`(?:AKIA|ASIA)[0-9A-Z]{16}`,                                     // AWS access key IDs
+	`xox[abprs]-[A-Za-z0-9-]{10,}`,                                  // Slack tokens
+	`(?i)bearer\s+[A-Za-z0-9._~+/-]{20,}=*`,                         // HTTP bearer credentials
+	`eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`, // JWTs
+	// PEM private key blocks
+	`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`,
+}
+
+// Redactor strips secrets from text before it is displayed or logged.
+// A nil *Redactor leaves text unchanged.
+type Redactor struct {
+	patterns []*regexp.Regexp
+}
+
+// NewRedactor compiles the default patterns plus extra user patterns.
+func NewRedactor(extra []string) (*Redactor, error) {
+	r := &Redactor{}
+	for _, p := range append(append([]string{}, DefaultRedactPatterns...), extra...) {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %w", p, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// Redact replaces every match of the redactor's patterns with RedactedText.
+func (r *Redactor) Redact(s string) string {
+	if r == nil || s == "" {
+		return s
+	}
+	for _, re := range r.patterns {
+		s = re.ReplaceAllLiteralString(s, RedactedText)
+	}
+	return s
+}
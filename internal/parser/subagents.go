@@ -0,0 +1,177 @@
+package parser
+
+// Subagent statuses.
+const (
+	SubagentRunning   = "running"
+	SubagentCompleted = "completed"
+	SubagentFailed    = "failed"
+)
+
+// Subagent is a subagent spawned in the current iteration, with the usage
+// and cost of its own messages. These are part of, not in addition to, the
+// iteration's totals: the main agent's result cost includes them.
+type Subagent struct {
+	ID          string  // ID of the Task tool call that spawned it
+	ParentID    string  // ID of the spawning subagent's Task call ("" = the main agent)
+	Description string  // the Task's description ("" = none given)
+	Type        string  // the Task's subagent_type, e.g. "Explore" ("" = none given)
+	Model       string  // model its messages report
+	Depth       int     // nesting depth: 1 for one the main agent spawned
+	Usage       Usage   // tokens its messages used, each message counted once
+	CostUSD     float64 // cost its result reported (0 = none yet; estimate from Usage)
+	ToolCalls   int
+	Status      string // SubagentRunning, SubagentCompleted or SubagentFailed
+}
+
+// Label returns how the subagent is shown: its description, else its type.
+func (s Subagent) Label() string {
+	switch {
+	case s.Description != "":
+		return s.Description
+	case s.Type != "":
+		return s.Type
+	}
+	return "subagent"
+}
+
+// ObserveSubagents tracks the subagents of the current iteration: Task tool
+// calls spawn them, their messages (those with a parent_tool_use_id) add to
+// their usage, tool calls and cost, and the Task's tool result ends them. A
+// subagent first seen through its own messages is tracked from then on. The
+// first message after the main agent's result starts a new iteration with
+// none; call ResetSubagents when an iteration starts without one. It reports
+// whether Subagents changed. Call it on every message.
+func (p *Parser) ObserveSubagents(msg *ParsedMessage) bool {
+	if msg == nil {
+		return false
+	}
+	changed := false
+	if p.subagentsEnded {
+		changed = p.ResetSubagents()
+	}
+	if p.subagentIDs == nil {
+		p.subagentIDs = make(map[string]*Subagent)
+		p.subagentMsgIDs = make(map[string]bool)
+	}
+
+	var owner *Subagent
+	if parentID := p.GetParentToolUseID(msg); parentID != "" {
+		owner = p.subagentIDs[parentID]
+		if owner == nil {
+			owner = p.addSubagent(Subagent{ID: parentID, Depth: 1, Status: SubagentRunning})
+			changed = true
+		}
+	}
+
+	switch msg.Type {
+	case MessageTypeAssistant:
+		if msg.Message == nil {
+			break
+		}
+		for _, item := range msg.Message.Content {
+			if item.Type != ContentTypeToolUse {
+				continue
+			}
+			if owner != nil {
+				owner.ToolCalls++
+				changed = true
+			}
+			if item.Name != "Task" || item.ID == "" || p.subagentIDs[item.ID] != nil {
+				continue
+			}
+			s := Subagent{ID: item.ID, Depth: 1, Status: SubagentRunning}
+			s.Description, _ = item.Input["description"].(string)
+			s.Type, _ = item.Input["subagent_type"].(string)
+			s.Description = p.redact(s.Description)
+			if owner != nil {
+				s.ParentID, s.Depth = owner.ID, owner.Depth+1
+			}
+			p.addSubagent(s)
+			changed = true
+		}
+		if owner != nil {
+			if usage := msg.Message.Usage; usage != nil && (msg.Message.ID == "" || !p.subagentMsgIDs[msg.Message.ID]) {
+				if msg.Message.ID != "" {
+					p.subagentMsgIDs[msg.Message.ID] = true
+				}
+				owner.Usage.InputTokens += usage.InputTokens
+				owner.Usage.OutputTokens += usage.OutputTokens
+				owner.Usage.CacheCreationInputTokens += usage.CacheCreationInputTokens
+				owner.Usage.CacheReadInputTokens += usage.CacheReadInputTokens
+				changed = true
+			}
+			if msg.Message.Model != "" {
+				owner.Model = msg.Message.Model
+			}
+		}
+	case MessageTypeUser:
+		if msg.Message == nil {
+			break
+		}
+		for _, item := range msg.Message.Content {
+			if item.Type != ContentTypeToolResult {
+				continue
+			}
+			if s := p.subagentIDs[item.ToolUseID]; s != nil && s.Status == SubagentRunning {
+				s.Status = SubagentCompleted
+				if item.IsError {
+					s.Status = SubagentFailed
+				}
+				changed = true
+			}
+		}
+	case MessageTypeResult:
+		if owner != nil {
+			if cost := p.GetCost(msg); cost > 0 {
+				owner.CostUSD = cost
+				changed = true
+			}
+			break
+		}
+		p.subagentsEnded = true
+	}
+	return changed
+}
+
+// ResetSubagents forgets the current iteration's subagents. Call it on the
+// LOOP marker that starts an iteration, or restarts one, since an agent that
+// was killed or crashed never sends the result that would end them. It
+// reports whether Subagents changed.
+func (p *Parser) ResetSubagents() bool {
+	changed := len(p.subagents) > 0
+	p.subagents, p.subagentIDs, p.subagentMsgIDs = nil, nil, nil
+	p.subagentsEnded = false
+	return changed
+}
+
+// addSubagent starts tracking s and returns it.
+func (p *Parser) addSubagent(s Subagent) *Subagent {
+	tracked := &s
+	p.subagents = append(p.subagents, tracked)
+	p.subagentIDs[s.ID] = tracked
+	return tracked
+}
+
+// Subagents returns the current iteration's subagents as a tree in
+// depth-first order: each is followed by the subagents it spawned, in the
+// order they were spawned.
+func (p *Parser) Subagents() []Subagent {
+	children := make(map[string][]*Subagent)
+	for _, s := range p.subagents {
+		parent := s.ParentID
+		if p.subagentIDs[parent] == nil {
+			parent = ""
+		}
+		children[parent] = append(children[parent], s)
+	}
+	list := make([]Subagent, 0, len(p.subagents))
+	var walk func(parent string)
+	walk = func(parent string) {
+		for _, s := range children[parent] {
+			list = append(list, *s)
+			walk(s.ID)
+		}
+	}
+	walk("")
+	return list
+}
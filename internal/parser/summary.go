@@ -0,0 +1,78 @@
+package parser
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// maxFailedLineRunes caps the excerpt kept for each line that failed to parse.
+const maxFailedLineRunes = 80
+
+// FailedLine is a captured stream line the parser could not read.
+type FailedLine struct {
+	Line int    // 1-based line number in the capture
+	Text string // the start of the line
+}
+
+// StreamSummary describes a captured agent stream, as `ralph parse` reports it.
+type StreamSummary struct {
+	Lines    int                 // non-blank lines read
+	Types    map[MessageType]int // messages by type
+	ToolUses map[string]int      // tool_use calls by tool name, subagents included
+	Sessions []string            // session IDs in order of first appearance
+	Usage    Usage               // tokens summed over assistant messages, once per message ID
+	CostUSD  float64             // summed over result messages
+	Failed   []FailedLine        // lines that were not valid stream JSON
+}
+
+// SummarizeStream runs every line of a captured stream-json output through a
+// Parser and tallies what it understood, so parser gaps show up against real
+// captures. Blank lines and ralph's own "=======" markers are skipped.
+func SummarizeStream(r io.Reader) (*StreamSummary, error) {
+	p := NewParser()
+	s := &StreamSummary{
+		Types:    map[MessageType]int{},
+		ToolUses: map[string]int{},
+	}
+	seenIDs := map[string]bool{}
+	seenSessions := map[string]bool{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "=======") {
+			continue
+		}
+		s.Lines++
+		msg := p.ParseLine(line)
+		if msg == nil {
+			s.Failed = append(s.Failed, FailedLine{Line: lineNum, Text: truncateRunes(line, maxFailedLineRunes)})
+			continue
+		}
+
+		s.Types[p.GetMessageType(msg)]++
+		if id := msg.SessionID; id != "" && !seenSessions[id] {
+			seenSessions[id] = true
+			s.Sessions = append(s.Sessions, id)
+		}
+		for _, tu := range p.ExtractContent(msg).ToolUses {
+			s.ToolUses[tu.Name]++
+		}
+		// Streamed assistant chunks repeat their message's usage
+		if usage := p.GetUsage(msg); usage != nil {
+			if id := p.GetMessageID(msg); id == "" || !seenIDs[id] {
+				seenIDs[id] = true
+				s.Usage.InputTokens += usage.InputTokens
+				s.Usage.OutputTokens += usage.OutputTokens
+				s.Usage.CacheCreationInputTokens += usage.CacheCreationInputTokens
+				s.Usage.CacheReadInputTokens += usage.CacheReadInputTokens
+			}
+		}
+		s.CostUSD += p.GetCost(msg)
+	}
+	return s, scanner.Err()
+}
@@ -0,0 +1,86 @@
+package parser
+
+// Thrash detection defaults: a call repeated ThrashRepeats times within the
+// last ThrashWindow iterations counts as thrashing.
+const (
+	ThrashRepeats = 5
+	ThrashWindow  = 3
+)
+
+// Thrash describes a tool call the agent keeps repeating.
+type Thrash struct {
+	Title      string // the call's display title, e.g. "Read config.go"
+	Count      int    // times it was made within the window
+	Iterations int    // iterations the window spans so far
+}
+
+// ObserveThrash tracks read-only tool calls (reads, searches, commands,
+// fetches) keyed by tool name and target, and reports the calls that have
+// just been repeated ThrashRepeats times within the last ThrashWindow
+// iterations. Each call is reported once when it crosses the threshold. The
+// main agent's result message ends an iteration. Call it on every message.
+func (p *Parser) ObserveThrash(msg *ParsedMessage) []Thrash {
+	if msg == nil {
+		return nil
+	}
+	if len(p.thrashWindow) == 0 {
+		p.thrashWindow = []map[string]int{{}}
+	}
+	switch msg.Type {
+	case MessageTypeAssistant:
+		if msg.Message == nil {
+			return nil
+		}
+		var found []Thrash
+		current := p.thrashWindow[len(p.thrashWindow)-1]
+		for _, item := range msg.Message.Content {
+			if item.Type != ContentTypeToolUse {
+				continue
+			}
+			kind := ClassifyToolKind(item.Name)
+			switch kind {
+			case ToolKindRead, ToolKindSearch, ToolKindExecute, ToolKindFetch:
+			default:
+				continue
+			}
+			target := thrashTarget(item.Input)
+			if target == "" {
+				continue
+			}
+			key := item.Name + "\x00" + target
+			current[key]++
+			count := 0
+			for _, iteration := range p.thrashWindow {
+				count += iteration[key]
+			}
+			if count == ThrashRepeats {
+				found = append(found, Thrash{
+					Title:      p.redact(buildToolTitle(item.Name, kind, item.Input)),
+					Count:      count,
+					Iterations: len(p.thrashWindow),
+				})
+			}
+		}
+		return found
+	case MessageTypeResult:
+		if p.IsSubagentMessage(msg) {
+			return nil
+		}
+		p.thrashWindow = append(p.thrashWindow, map[string]int{})
+		if len(p.thrashWindow) > ThrashWindow {
+			p.thrashWindow = p.thrashWindow[len(p.thrashWindow)-ThrashWindow:]
+		}
+	}
+	return nil
+}
+
+// thrashTarget is what a tool call acts on: its file, pattern or full
+// command. Unlike ExtractFilePathFromInput it does not truncate commands,
+// so two commands sharing a long prefix stay distinct.
+func thrashTarget(input map[string]interface{}) string {
+	target := ExtractFilePathFromInput(input)
+	if cmd, ok := input["command"].(string); ok && target == truncateRunes(cmd, 50) {
+		return cmd
+	}
+	return target
+}
@@ -0,0 +1,121 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// rateLimitPattern matches rate-limit and usage-limit errors, e.g.
+	// "Claude AI usage limit reached|1760000000", "rate_limit_error",
+	// "rate limited" or "API Error: 429 Too Many Requests".
+	rateLimitPattern = regexp.MustCompile(`(?i)\brate[ _-]?limit(?:ed|_error|s)?\b|usage limit reached|too many requests|\berror\b.*\b429\b`)
+	// overloadedPattern matches API overload errors, e.g.
+	// `API Error: 529 {"type":"overloaded_error"}`.
+	overloadedPattern = regexp.MustCompile(`(?i)\boverloaded(?:_error)?\b|\berror\b.*\b529\b`)
+
+	// resetEpochPattern is the reset time the Claude CLI appends to its
+	// usage-limit message: "Claude AI usage limit reached|1760000000".
+	resetEpochPattern = regexp.MustCompile(`\|(\d{9,11})\b`)
+	// resetClockPattern is a clock reset time: "resets 3pm", "resets at 15:30".
+	resetClockPattern = regexp.MustCompile(`(?i)\bresets?(?: at)? (\d{1,2})(?::(\d{2}))? ?([ap]m)?\b`)
+	// retryAfterPattern is a relative reset time: "retry after 30 seconds",
+	// "try again in 5 minutes", "Retry-After: 120".
+	retryAfterPattern = regexp.MustCompile(`(?i)\b(?:retry|try again)[ -](?:after|in):? ?(\d+) ?(s|secs?|seconds?|m|mins?|minutes?|h|hours?)?\b`)
+)
+
+// Throttle is a rate-limit or API overload error found in the agent's
+// output, which is worth waiting out rather than retrying at once.
+type Throttle struct {
+	Overloaded bool      // the API is overloaded (529) rather than rate limiting
+	ResetsAt   time.Time // when the error says to try again (zero = it doesn't say)
+	Text       string    // the error
+}
+
+// DetectThrottle checks an errored message for a rate-limit or overload
+// error given as text: an is_error result whose error or result text reports
+// one, or an error message. Rate-limit events and 529 errors are reported by
+// IsRateLimitRejected and IsAPIOverloaded. now is used to resolve relative
+// reset times. Returns nil when msg reports neither.
+func (p *Parser) DetectThrottle(msg *ParsedMessage, now time.Time) *Throttle {
+	if msg == nil || (!msg.IsError && msg.Type != MessageTypeAPIError) {
+		return nil
+	}
+	if t := p.DetectThrottleText(msg.GetError(), now); t != nil {
+		return t
+	}
+	if len(msg.ErrorRaw) > 0 {
+		// An error object whose type names the limit, e.g. rate_limit_error
+		if t := p.DetectThrottleText(string(msg.ErrorRaw), now); t != nil {
+			return t
+		}
+	}
+	return p.DetectThrottleText(msg.Result, now)
+}
+
+// DetectThrottleText checks a plain-text line, e.g. the agent's stderr, for
+// a rate-limit or overload error and the reset time it gives, if any. now is
+// used to resolve relative reset times. Returns nil if the text reports
+// neither.
+func (p *Parser) DetectThrottleText(text string, now time.Time) *Throttle {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	t := &Throttle{Text: text}
+	switch {
+	case rateLimitPattern.MatchString(text):
+	case overloadedPattern.MatchString(text):
+		t.Overloaded = true
+	default:
+		return nil
+	}
+	t.ResetsAt = resetTime(text, now)
+	return t
+}
+
+// resetTime extracts when a rate limit resets from its error text, or
+// returns the zero time when the text doesn't say or the time has passed.
+func resetTime(text string, now time.Time) time.Time {
+	var at time.Time
+	if m := resetEpochPattern.FindStringSubmatch(text); m != nil {
+		secs, _ := strconv.ParseInt(m[1], 10, 64)
+		at = time.Unix(secs, 0)
+	} else if m := retryAfterPattern.FindStringSubmatch(text); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		unit := time.Second
+		switch strings.ToLower(m[2])[:min(len(m[2]), 1)] {
+		case "m":
+			unit = time.Minute
+		case "h":
+			unit = time.Hour
+		}
+		at = now.Add(time.Duration(n) * unit)
+	} else if m := resetClockPattern.FindStringSubmatch(text); m != nil {
+		hour, _ := strconv.Atoi(m[1])
+		minute, _ := strconv.Atoi(m[2])
+		switch strings.ToLower(m[3]) {
+		case "am":
+			if hour == 12 {
+				hour = 0
+			}
+		case "pm":
+			if hour < 12 {
+				hour += 12
+			}
+		}
+		if hour > 23 || minute > 59 {
+			return time.Time{}
+		}
+		at = time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+	}
+	if !at.After(now) {
+		return time.Time{}
+	}
+	return at
+}
@@ -0,0 +1,209 @@
+// Package plan reads the implementation plan the agent works through
+// (IMPLEMENTATION_PLAN.md by default): its numbered tasks, the phases they
+// are grouped under, and each task's status marker.
+//
+//	# Phase 1: Foundations
+//
+//	## TASK 1: Add the parser
+//	**Status: DONE**
+//
+// A task is a heading "TASK <n>", at any level, optionally followed by a
+// title. Its status is the first "Status: <status>" in its section, or on the
+// heading itself; a task without one is TODO. A heading starting with
+// "Phase" names the phase of the tasks after it.
+package plan
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Task statuses. Markers are matched case-insensitively, with '_' or '-'
+// read as a space, so "in_progress" is StatusInProgress.
+const (
+	StatusTodo       = "TODO"
+	StatusInProgress = "IN PROGRESS"
+	StatusDone       = "DONE"
+	StatusNotNeeded  = "NOT NEEDED"
+)
+
+var (
+	// taskPattern matches a task heading: "## TASK 3: Add the parser".
+	taskPattern = regexp.MustCompile(`^#{1,6}\s+(?i:task)\s+(\d+)\b\s*[:.)]?\s*(.*)$`)
+	// phasePattern matches a phase heading: "# Phase 1: Foundations".
+	phasePattern = regexp.MustCompile(`^#{1,6}\s+((?i:phase)\b.*)$`)
+	// statusPattern matches a status marker: "**Status: DONE**".
+	statusPattern = regexp.MustCompile(`(?i)\*{0,2}status:\s*([a-z][a-z _-]*[a-z])\s*\*{0,2}`)
+)
+
+// Task is one task in the plan.
+type Task struct {
+	Number int
+	Title  string // "" when the heading has none
+	Phase  string // heading of the phase it is under ("" = none)
+	Status string // StatusTodo, StatusInProgress, StatusDone, StatusNotNeeded or as written
+}
+
+// Completed reports whether the task needs no more work: it is DONE (or
+// COMPLETE, COMPLETED) or NOT NEEDED.
+func (t Task) Completed() bool {
+	switch t.Status {
+	case StatusDone, "COMPLETE", "COMPLETED", StatusNotNeeded:
+		return true
+	}
+	return false
+}
+
+// Label returns the task as the footer's Current Task shows it, e.g.
+// "#3 Add the parser".
+func (t Task) Label() string {
+	label := "#" + strconv.Itoa(t.Number)
+	if t.Title != "" {
+		label += " " + t.Title
+	}
+	return label
+}
+
+// Plan is a parsed implementation plan.
+type Plan struct {
+	Tasks []Task // in file order
+}
+
+// Parse reads the tasks and phases in text.
+func Parse(text string) *Plan {
+	p := &Plan{}
+	phase := ""
+	var current *Task // the task whose status marker is still to come
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if m := taskPattern.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			p.Tasks = append(p.Tasks, Task{Number: n, Phase: phase, Status: StatusTodo})
+			current = &p.Tasks[len(p.Tasks)-1]
+			title := m[2]
+			if s := statusPattern.FindStringSubmatchIndex(title); s != nil {
+				current.Status = normalize(title[s[2]:s[3]])
+				title = title[:s[0]]
+			}
+			current.Title = strings.TrimRight(title, " \t-–—:|")
+			if current.Status != StatusTodo {
+				current = nil
+			}
+			continue
+		}
+		if m := phasePattern.FindStringSubmatch(line); m != nil {
+			phase, current = m[1], nil
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			// Any other heading ends the task's section
+			current = nil
+			continue
+		}
+		if m := statusPattern.FindStringSubmatch(line); m != nil && current != nil {
+			current.Status = normalize(m[1])
+			current = nil // only the first marker counts
+		}
+	}
+	return p
+}
+
+// Load reads and parses the plan at path.
+func Load(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(string(data)), nil
+}
+
+// Counts returns how many tasks are completed, and how many there are.
+func (p *Plan) Counts() (completed, total int) {
+	for _, t := range p.Tasks {
+		if t.Completed() {
+			completed++
+		}
+	}
+	return completed, len(p.Tasks)
+}
+
+// Current returns the task being worked on: the first IN PROGRESS one, or
+// else the first that is not completed. It returns nil when every task is
+// completed or there are none.
+func (p *Plan) Current() *Task {
+	var next *Task
+	for i := range p.Tasks {
+		t := &p.Tasks[i]
+		if t.Status == StatusInProgress {
+			return t
+		}
+		if next == nil && !t.Completed() {
+			next = t
+		}
+	}
+	return next
+}
+
+// Watch polls the plan at path every interval and calls changed with it
+// re-read whenever the file's size or modification time changes. A plan
+// that is removed, or cannot be read, is reported as empty. The returned
+// stop function halts the watcher and waits for an in-flight call.
+func Watch(path string, interval time.Duration, changed func(*Plan)) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	last := fingerprint(path)
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				fp := fingerprint(path)
+				if fp == last {
+					continue
+				}
+				last = fp
+				p, err := Load(path)
+				if err != nil {
+					p = &Plan{}
+				}
+				changed(p)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+		})
+	}
+}
+
+// fileState is what Watch compares to notice a change.
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+func fingerprint(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{true, info.Size(), info.ModTime()}
+}
+
+// normalize upper-cases a status marker and reads '_' and '-' as spaces.
+func normalize(status string) string {
+	status = strings.NewReplacer("_", " ", "-", " ").Replace(strings.ToUpper(status))
+	return strings.Join(strings.Fields(status), " ")
+}
@@ -0,0 +1,120 @@
+// Package poster POSTs JSON bodies to a URL in the background, one at a
+// time in the order they were queued, so a slow endpoint never holds up the
+// run. Webhook notifications and OpenTelemetry span exports both go through
+// it.
+package poster
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Request is one body to POST, with What naming it in errors, e.g.
+// "run_start webhook".
+type Request struct {
+	What string
+	Body []byte
+}
+
+// Poster sends queued requests to one URL. Its methods are safe for
+// concurrent use.
+type Poster struct {
+	url    string
+	client *http.Client
+
+	mu     sync.Mutex // guards the fields below
+	queue  chan Request
+	done   chan struct{}
+	closed bool  // Close has run; later requests are refused
+	err    error // first failed request
+}
+
+// New returns a Poster for url that holds up to queueSize requests waiting
+// to be sent.
+func New(url string, queueSize int) *Poster {
+	p := &Poster{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan Request, queueSize),
+		done:   make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Queue queues r to be sent. It returns false, dropping r, when the queue is
+// full or the Poster is closed.
+func (p *Poster) Queue(r Request) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	select {
+	case p.queue <- r:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops accepting requests, queues final, waiting for room rather than
+// dropping them, then waits until every queued request is sent or ctx is
+// done. It returns the first failed request's error.
+func (p *Poster) Close(ctx context.Context, final ...Request) error {
+	p.mu.Lock()
+	closing := !p.closed
+	p.closed = true
+	p.mu.Unlock()
+
+	if closing {
+		for _, r := range final {
+			select {
+			case p.queue <- r:
+			case <-ctx.Done():
+				close(p.queue)
+				return fmt.Errorf("sending to %s: %w", p.url, ctx.Err())
+			}
+		}
+		close(p.queue)
+	}
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return fmt.Errorf("sending to %s: %w", p.url, ctx.Err())
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// run sends each queued request in turn until the queue is closed, keeping
+// the first failure for Close to report.
+func (p *Poster) run() {
+	defer close(p.done)
+	for r := range p.queue {
+		if err := p.post(r); err != nil {
+			p.mu.Lock()
+			if p.err == nil {
+				p.err = err
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+func (p *Poster) post(r Request) error {
+	resp, err := p.client.Post(p.url, "application/json", bytes.NewReader(r.Body))
+	if err != nil {
+		return fmt.Errorf("sending %s: %w", r.What, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("sending %s to %s: %s", r.What, p.url, resp.Status)
+	}
+	return nil
+}
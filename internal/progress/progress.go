@@ -0,0 +1,103 @@
+// Package progress writes compact, machine-parseable progress lines for
+// editor and IDE integrations that want a run's state without the full
+// stream. Each line is Prefix followed by space-separated key=value fields,
+// always in the same order:
+//
+//	RALPH_PROGRESS loop=3/20 cost=1.2300 tokens=450000 status=running task=#6
+//
+// Values never contain spaces; a missing task is "-".
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Prefix starts every progress line.
+const Prefix = "RALPH_PROGRESS"
+
+// Run statuses reported in the status field.
+const (
+	StatusRunning     = "running"
+	StatusPaused      = "paused"
+	StatusHibernating = "hibernating"
+	StatusComplete    = "complete"
+	StatusFailed      = "failed"
+)
+
+// State is what a progress line reports.
+type State struct {
+	Loop, Total int     // iteration in progress and iterations planned
+	CostUSD     float64 // this run's cost so far
+	Tokens      int64   // this run's tokens so far
+	Status      string  // one of the Status constants
+	Task        string  // current plan task, e.g. "#6" ("" = none seen yet)
+}
+
+// Format returns the progress line for s, without a trailing newline.
+func Format(s State) string {
+	task := s.Task
+	if task == "" {
+		task = "-"
+	}
+	return fmt.Sprintf("%s loop=%d/%d cost=%.4f tokens=%d status=%s task=%s",
+		Prefix, s.Loop, s.Total, s.CostUSD, s.Tokens, s.Status, task)
+}
+
+// Writer emits a progress line whenever the state changes. A nil *Writer
+// writes nothing.
+type Writer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	last   string
+}
+
+// Open returns a Writer for target: "stderr", or the path of a file or named
+// pipe to append to. A named pipe blocks the open until a reader attaches.
+// It returns nil for an empty target.
+func Open(target string) (*Writer, error) {
+	switch target {
+	case "":
+		return nil, nil
+	case "stderr":
+		return NewWriter(os.Stderr), nil
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening progress output %s: %w", target, err)
+	}
+	w := NewWriter(f)
+	w.closer = f
+	return w, nil
+}
+
+// NewWriter returns a Writer emitting to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Update writes the line for s unless it matches the last one written.
+func (w *Writer) Update(s State) {
+	if w == nil {
+		return
+	}
+	line := Format(s)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if line == w.last {
+		return
+	}
+	w.last = line
+	fmt.Fprintln(w.w, line)
+}
+
+// Close closes the file or pipe Open created.
+func (w *Writer) Close() error {
+	if w == nil || w.closer == nil {
+		return nil
+	}
+	return w.closer.Close()
+}
@@ -4,22 +4,55 @@ package prompt
 import (
 	"embed"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-//go:embed assets/prompt.md assets/plan_prompt.md assets/autoresearch_prompt.md assets/autoresearch_template.md assets/spec_template.md
+//go:embed assets/prompt.md assets/plan_prompt.md assets/autoresearch_prompt.md assets/autoresearch_template.md assets/spec_template.md assets/plan_template.md assets/ralphrc_template
 var embeddedFS embed.FS
 
+// promptFS is where the loop prompts are read from; tests swap it to simulate
+// a build whose embedded assets are missing.
+var promptFS fs.FS = embeddedFS
+
 const embeddedPromptPath = "assets/prompt.md"
 const embeddedPlanPromptPath = "assets/plan_prompt.md"
 const embeddedAutoresearchPromptPath = "assets/autoresearch_prompt.md"
 const embeddedAutoresearchTemplatePath = "assets/autoresearch_template.md"
 const embeddedSpecTemplatePath = "assets/spec_template.md"
+const embeddedPlanTemplatePath = "assets/plan_template.md"
+const embeddedRCTemplatePath = "assets/ralphrc_template"
 
 const defaultPlanFile = "IMPLEMENTATION_PLAN.md"
 
+// FallbackPrompt is the minimal build prompt used when the embedded prompt
+// cannot be read, so a misbuilt binary still runs (degraded) instead of
+// refusing to start.
+const FallbackPrompt = `Study the specs in the specs/ directory and @IMPLEMENTATION_PLAN.md.
+
+Implement the single highest priority task from IMPLEMENTATION_PLAN.md, make sure the tests pass, update the plan with your progress, then commit your changes with git.
+
+ULTIMATE GOAL: $ultimate_goal_placeholder_sentence. Keep this goal in mind throughout implementation.
+`
+
+// FallbackPlanPrompt is the minimal plan prompt used when the embedded plan
+// prompt cannot be read.
+const FallbackPlanPrompt = `Study the specs in the specs/ directory and the existing source code.
+
+Compare them and write or update IMPLEMENTATION_PLAN.md as a prioritized list of tasks still to be done. Plan only; do not implement anything.
+
+ULTIMATE GOAL: $ultimate_goal_placeholder_sentence. Keep this goal in mind throughout planning.
+`
+
+// FallbackAutoresearchPrompt is the minimal autoresearch prompt used when the
+// embedded autoresearch prompt cannot be read.
+const FallbackAutoresearchPrompt = `Run one optimization experiment as described below: make a change, measure it, keep it if the metric improves and revert it otherwise, and record the result.
+
+$experiment_content
+`
+
 // Loader provides methods for loading the loop prompt
 type Loader struct {
 	overridePath      string
@@ -28,6 +61,7 @@ type Loader struct {
 	goal              string
 	planFile          string
 	experimentContent string
+	usedFallback      bool
 }
 
 // NewLoader creates a new prompt Loader.
@@ -78,12 +112,24 @@ func (l *Loader) Load() (string, error) {
 
 	if l.overridePath != "" {
 		content, err = l.loadFromFile(l.overridePath)
-	} else if l.autoresearchMode {
-		content, err = l.loadEmbeddedAutoresearch()
-	} else if l.planMode {
-		content, err = l.loadEmbeddedPlan()
 	} else {
-		content, err = l.loadEmbedded()
+		var fallback string
+		if l.autoresearchMode {
+			content, err = l.loadEmbeddedAutoresearch()
+			fallback = FallbackAutoresearchPrompt
+		} else if l.planMode {
+			content, err = l.loadEmbeddedPlan()
+			fallback = FallbackPlanPrompt
+		} else {
+			content, err = l.loadEmbedded()
+			fallback = FallbackPrompt
+		}
+		// A broken build should degrade, not refuse to start
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: %v\nWARNING: running with a minimal fallback prompt; reinstall ralph to restore the full prompt\n", err)
+			content, err = fallback, nil
+			l.usedFallback = true
+		}
 	}
 
 	if err != nil {
@@ -99,9 +145,23 @@ func (l *Loader) Load() (string, error) {
 	return content, nil
 }
 
+// UsedFallback returns true if the last Load fell back to a minimal built-in
+// prompt because the embedded one could not be read.
+func (l *Loader) UsedFallback() bool {
+	return l.usedFallback
+}
+
+// SetEmbeddedFSForTest replaces the filesystem the embedded loop prompts are
+// read from and returns a function that restores it.
+func SetEmbeddedFSForTest(fsys fs.FS) (restore func()) {
+	prev := promptFS
+	promptFS = fsys
+	return func() { promptFS = prev }
+}
+
 // loadEmbedded returns the embedded default prompt
 func (l *Loader) loadEmbedded() (string, error) {
-	content, err := embeddedFS.ReadFile(embeddedPromptPath)
+	content, err := fs.ReadFile(promptFS, embeddedPromptPath)
 	if err != nil {
 		return "", fmt.Errorf("failed to read embedded prompt: %w", err)
 	}
@@ -125,7 +185,7 @@ func (l *Loader) loadFromFile(path string) (string, error) {
 
 // loadEmbeddedPlan returns the embedded plan prompt
 func (l *Loader) loadEmbeddedPlan() (string, error) {
-	content, err := embeddedFS.ReadFile(embeddedPlanPromptPath)
+	content, err := fs.ReadFile(promptFS, embeddedPlanPromptPath)
 	if err != nil {
 		return "", fmt.Errorf("failed to read embedded plan prompt: %w", err)
 	}
@@ -209,9 +269,28 @@ func GetEmbeddedSpecTemplate() (string, error) {
 	return string(content), nil
 }
 
+// GetEmbeddedPlanTemplate returns the starter implementation plan written by
+// `ralph init`.
+func GetEmbeddedPlanTemplate() (string, error) {
+	content, err := embeddedFS.ReadFile(embeddedPlanTemplatePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read embedded plan template: %w", err)
+	}
+	return string(content), nil
+}
+
+// GetEmbeddedRCTemplate returns the starter .ralphrc written by `ralph init`.
+func GetEmbeddedRCTemplate() (string, error) {
+	content, err := embeddedFS.ReadFile(embeddedRCTemplatePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read embedded .ralphrc template: %w", err)
+	}
+	return string(content), nil
+}
+
 // loadEmbeddedAutoresearch returns the embedded autoresearch prompt
 func (l *Loader) loadEmbeddedAutoresearch() (string, error) {
-	content, err := embeddedFS.ReadFile(embeddedAutoresearchPromptPath)
+	content, err := fs.ReadFile(promptFS, embeddedAutoresearchPromptPath)
 	if err != nil {
 		return "", fmt.Errorf("failed to read embedded autoresearch prompt: %w", err)
 	}
@@ -0,0 +1,50 @@
+package prompt
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// BuiltinVars are the variables every loop prompt template can use, e.g.
+// {{.Iteration}}. Variables set with --var can't take these names.
+var BuiltinVars = []string{"SpecPath", "Iteration", "TotalIterations", "Goal", "Branch"}
+
+// Vars are the values a loop prompt template is executed with, by name.
+// Iteration and TotalIterations are filled in by Render.
+type Vars map[string]interface{}
+
+// NewVars returns the variables for a run's prompt templates: the built-in
+// SpecPath, Goal and Branch, plus the user's own from --var.
+func NewVars(specPath, goal, branch string, user map[string]string) Vars {
+	vars := Vars{"SpecPath": specPath, "Goal": goal, "Branch": branch}
+	for k, v := range user {
+		vars[k] = v
+	}
+	return vars
+}
+
+// Render executes content, a loop prompt, as a Go text/template with vars and
+// the iteration it is sent for. A variable the prompt uses but vars doesn't
+// have is an error, so a misspelt name isn't sent as "<no value>". A prompt
+// without "{{" is returned as is.
+func Render(content string, vars Vars, iteration, total int) (string, error) {
+	if !strings.Contains(content, "{{") {
+		return content, nil
+	}
+	tmpl, err := template.New("prompt").Option("missingkey=error").Parse(content)
+	if err != nil {
+		return "", fmt.Errorf("parsing prompt template: %w", err)
+	}
+	data := make(Vars, len(vars)+2)
+	for k, v := range vars {
+		data[k] = v
+	}
+	data["Iteration"] = iteration
+	data["TotalIterations"] = total
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("executing prompt template: %w", err)
+	}
+	return b.String(), nil
+}
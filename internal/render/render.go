@@ -0,0 +1,167 @@
+// Package render writes the events of a CLI run (assistant text, tool calls,
+// cost updates, loop markers, ...) to stdout and stderr, either as the
+// bracketed text lines people read:
+//
+//	[tool] (read) Read main.go
+//
+// or, with --log-format json, as one JSON object per line on stdout for jq
+// and log collectors:
+//
+//	{"time":"...","type":"tool","iteration":3,"content":"Read main.go","tool":"Read","kind":"read","location":"main.go"}
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudosai/ralph-go/internal/parser"
+)
+
+// Output formats accepted by --log-format.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Event is one thing that happened in a run.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`                // "assistant", "tool", "tool_result", "cost", "loop", "error", ...
+	Iteration int       `json:"iteration,omitempty"` // 0 before the first iteration starts
+	Total     int       `json:"total,omitempty"`     // loop events: iterations planned
+	Content   string    `json:"content"`
+	Tool      string    `json:"tool,omitempty"`        // tool and tool_result events
+	Kind      string    `json:"kind,omitempty"`        // tool events: the call's kind
+	Location  string    `json:"location,omitempty"`    // tool events: the file, pattern or command
+	ToolUseID string    `json:"tool_use_id,omitempty"` // tool and tool_result events
+	IsError   bool      `json:"is_error,omitempty"`
+	CostUSD   float64   `json:"cost_usd,omitempty"` // cost events
+	Worker    int       `json:"worker,omitempty"`   // --parallel: the loop (1 to N) the event came from
+
+	// Text replaces the "[type] content" line in text output ("" = default).
+	Text string `json:"-"`
+}
+
+// FromDisplayLine returns the event for a parser display line: assistant or
+// thinking text, a tool call, or a tool result.
+func FromDisplayLine(line parser.DisplayLine) Event {
+	switch line.Role {
+	case parser.DisplayTool:
+		return Event{
+			Type:      "tool",
+			Content:   line.Text,
+			Tool:      line.ToolName,
+			Kind:      string(line.Kind),
+			Location:  line.Location,
+			ToolUseID: line.ToolUseID,
+			Text:      fmt.Sprintf("[tool] (%s) %s", line.Kind, line.Text),
+		}
+	case parser.DisplayToolResult:
+		e := Event{Type: "tool_result", Content: line.Text, ToolUseID: line.ToolUseID, IsError: line.IsError}
+		if line.IsError {
+			e.Text = "[tool] failed"
+			if line.Text != "" {
+				e.Text += ": " + line.Text
+			}
+		}
+		return e
+	}
+	return Event{Type: string(line.Role), Content: line.Text}
+}
+
+// Renderer writes events in one format. Events are tagged with the iteration
+// of the last loop event. A nil *Renderer discards everything, like a nil
+// runlog.Writer.
+type Renderer struct {
+	mu        sync.Mutex
+	out       io.Writer
+	errOut    io.Writer
+	format    string
+	iteration int
+	now       func() time.Time
+}
+
+// New returns a Renderer writing format to out, and text errors to errOut.
+// JSON output goes to out only, so one stream carries the whole run.
+func New(format string, out, errOut io.Writer) *Renderer {
+	return &Renderer{out: out, errOut: errOut, format: format, now: time.Now}
+}
+
+// JSON reports whether r writes JSON lines.
+func (r *Renderer) JSON() bool {
+	return r != nil && r.format == FormatJSON
+}
+
+// Emit writes e to stdout.
+func (r *Renderer) Emit(e Event) {
+	r.emit(e, false)
+}
+
+// EmitError writes e to stderr in text output, and to stdout as JSON.
+func (r *Renderer) EmitError(e Event) {
+	r.emit(e, true)
+}
+
+// Printf emits an event of type typ with formatted content.
+func (r *Renderer) Printf(typ, format string, args ...interface{}) {
+	r.Emit(Event{Type: typ, Content: fmt.Sprintf(format, args...)})
+}
+
+// Errorf emits an error-stream event of type typ with formatted content.
+func (r *Renderer) Errorf(typ, format string, args ...interface{}) {
+	r.EmitError(Event{Type: typ, Content: fmt.Sprintf(format, args...)})
+}
+
+// Plain emits a line shown as-is in text output, such as the run summary,
+// and as an event of type typ in JSON, without the line's own "[typ] " tag.
+func (r *Renderer) Plain(typ, line string) {
+	r.Emit(Event{Type: typ, Content: strings.TrimPrefix(line, "["+typ+"] "), Text: line})
+}
+
+// Loop emits a loop marker and tags later events with its iteration.
+func (r *Renderer) Loop(iteration, total int, marker string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	if iteration > 0 {
+		r.iteration = iteration
+	}
+	r.mu.Unlock()
+	r.Emit(Event{Type: "loop", Iteration: iteration, Total: total, Content: marker})
+}
+
+func (r *Renderer) emit(e Event, isErr bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e.Iteration == 0 {
+		e.Iteration = r.iteration
+	}
+	if r.format == FormatJSON {
+		if e.Time.IsZero() {
+			e.Time = r.now().UTC()
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			return
+		}
+		fmt.Fprintln(r.out, string(data))
+		return
+	}
+	line := e.Text
+	if line == "" {
+		line = fmt.Sprintf("[%s] %s", e.Type, e.Content)
+	}
+	w := r.out
+	if isErr {
+		w = r.errOut
+	}
+	fmt.Fprintln(w, line)
+}
@@ -0,0 +1,130 @@
+// Package runlog writes the per-run activity log as text or JSON lines.
+package runlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log formats accepted by --log-format.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Entry is one message in the run log.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	RunID     string    `json:"run_id"`
+	Iteration int       `json:"iteration,omitempty"` // 0 before the first iteration starts
+	Type      string    `json:"type"`                // "run_start", "loop", "assistant", "thinking", ...
+	Content   string    `json:"content"`
+}
+
+// FormatEntry renders e as one log record in format: a JSON object per line
+// for FormatJSON, otherwise a timestamped text line followed by a blank line
+// so multi-line content stays readable.
+func FormatEntry(format string, e Entry) string {
+	if format == FormatJSON {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return ""
+		}
+		return string(data) + "\n"
+	}
+	loop := "-"
+	if e.Iteration > 0 {
+		loop = fmt.Sprintf("#%d", e.Iteration)
+	}
+	return fmt.Sprintf("%s %s [%s] %s\n\n", e.Time.UTC().Format(time.RFC3339), loop, e.Type, e.Content)
+}
+
+// Writer appends entries for one run to a log. A nil *Writer discards
+// everything, so callers never need to check whether logging is enabled.
+type Writer struct {
+	mu        sync.Mutex
+	w         io.Writer
+	format    string
+	runID     string
+	iteration int
+	now       func() time.Time
+	tap       func(Entry)
+}
+
+// NewWriter returns a Writer appending entries for runID to w in format.
+func NewWriter(w io.Writer, format, runID string) *Writer {
+	return &Writer{w: w, format: format, runID: runID, now: time.Now}
+}
+
+// Start records the start of the run in dir. Text logs get a separator line
+// so consecutive runs in one file are easy to tell apart.
+func (l *Writer) Start(dir string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.format == FormatText {
+		fmt.Fprintf(l.w, "\n--- ralph run %s started %s in %s ---\n\n", l.runID, l.now().UTC().Format(time.RFC3339), dir)
+		return
+	}
+	l.write("run_start", dir)
+}
+
+// Loop records a loop marker and tags later entries with its iteration.
+func (l *Writer) Loop(iteration int, marker string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if iteration > 0 {
+		l.iteration = iteration
+	}
+	l.write("loop", marker)
+}
+
+// Log records one message of the given type.
+func (l *Writer) Log(typ, content string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.write(typ, content)
+}
+
+// SetTap has fn called with every entry written from now on, e.g. to feed
+// the --listen API.
+func (l *Writer) SetTap(fn func(Entry)) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tap = fn
+}
+
+// write formats and appends an entry; the caller holds l.mu.
+func (l *Writer) write(typ, content string) {
+	e := Entry{
+		Time:      l.now(),
+		RunID:     l.runID,
+		Iteration: l.iteration,
+		Type:      typ,
+		Content:   strings.TrimRight(content, "\n"),
+	}
+	io.WriteString(l.w, FormatEntry(l.format, e))
+	if l.tap != nil {
+		l.tap(e)
+	}
+}
+
+// SetClockForTest replaces the clock used to timestamp entries.
+func (l *Writer) SetClockForTest(now func() time.Time) {
+	l.now = now
+}
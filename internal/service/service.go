@@ -0,0 +1,191 @@
+// Package service writes the files that run ralph headless as a background
+// service: a systemd user unit on Linux and a launchd agent on macOS.
+package service
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// StopTimeout is how long the service manager waits, after SIGTERM, for ralph
+// to finish its running iteration before killing it.
+const StopTimeout = "30min"
+
+// stopTimeoutSeconds is StopTimeout for launchd, which wants seconds.
+const stopTimeoutSeconds = 1800
+
+// RestartDelay is how long the service manager waits before starting a run
+// that failed again.
+const RestartDelay = 30
+
+// Spec describes a ralph service.
+type Spec struct {
+	Name    string   // service name, e.g. "ralph-myproject"
+	Args    []string // command line: the ralph binary's path, then its arguments
+	Dir     string   // working directory, where the run's .ralphrc and .ralph/ are
+	Env     []string // environment as KEY=value, e.g. PATH, so the agent CLI is found
+	EnvFile string   // systemd only: optional KEY=value file for secrets such as API keys ("" = none)
+	LogPath string   // launchd only: where the run's output goes ("" = discarded)
+}
+
+// Name returns the service name for a run in dir: "ralph-" and the folder's
+// name, with characters service managers dislike replaced by "-".
+func Name(dir string) string {
+	base := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '-'
+	}, filepath.Base(dir))
+	base = strings.Trim(base, "-.")
+	if base == "" {
+		return "ralph"
+	}
+	return "ralph-" + base
+}
+
+// Path returns where the service file for name goes on goos, for the user
+// whose home folder is home: a systemd user unit, or on darwin a launchd
+// agent. configHome is $XDG_CONFIG_HOME ("" = home/.config).
+func Path(goos, home, configHome, name string) string {
+	if goos == "darwin" {
+		return filepath.Join(home, "Library", "LaunchAgents", name+".plist")
+	}
+	if configHome == "" {
+		configHome = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configHome, "systemd", "user", name+".service")
+}
+
+// Environment returns the variables from environ, as KEY=value, that a
+// service needs to run like the shell it was installed from: PATH, so the
+// agent CLI and git are found, and ralph's own RALPH_* settings.
+func Environment(environ []string) []string {
+	var env []string
+	for _, kv := range environ {
+		if strings.HasPrefix(kv, "PATH=") || strings.HasPrefix(kv, "RALPH_") {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// Systemd returns a systemd user unit for s. It restarts a run that fails,
+// lets a stopped run finish its iteration (ralph drains on SIGTERM) and maps
+// `systemctl reload` to SIGHUP, which reloads the loop prompt.
+func Systemd(s Spec) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Written by `ralph service install` for the run in %s.\n", s.Dir)
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=ralph loop in %s\n", systemdSpecifiers(s.Dir))
+	b.WriteString("After=network-online.target\n")
+	b.WriteString("Wants=network-online.target\n")
+	b.WriteString("\n[Service]\n")
+	b.WriteString("Type=simple\n")
+	fmt.Fprintf(&b, "WorkingDirectory=%s\n", systemdQuote(s.Dir))
+	for _, kv := range s.Env {
+		fmt.Fprintf(&b, "Environment=%s\n", systemdQuote(kv))
+	}
+	if s.EnvFile != "" {
+		fmt.Fprintf(&b, "EnvironmentFile=-%s\n", systemdSpecifiers(s.EnvFile))
+	}
+	quoted := make([]string, len(s.Args))
+	for i, arg := range s.Args {
+		quoted[i] = systemdQuote(strings.ReplaceAll(arg, "$", "$$"))
+	}
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(quoted, " "))
+	b.WriteString("ExecReload=/bin/kill -HUP $MAINPID\n")
+	b.WriteString("Restart=on-failure\n")
+	fmt.Fprintf(&b, "RestartSec=%d\n", RestartDelay)
+	b.WriteString("KillMode=mixed\n")
+	fmt.Fprintf(&b, "TimeoutStopSec=%s\n", StopTimeout)
+	b.WriteString("\n[Install]\n")
+	b.WriteString("WantedBy=default.target\n")
+	return b.String()
+}
+
+// systemdSpecifiers escapes the specifiers (%) in s, which systemd expands
+// in most unit file settings.
+func systemdSpecifiers(s string) string {
+	return strings.ReplaceAll(s, "%", "%%")
+}
+
+// systemdQuote quotes arg for a unit file line: specifiers are escaped, and
+// an argument with spaces, quotes or backslashes is double-quoted. Only
+// ExecStart expands variables, so escaping $ is left to its caller.
+func systemdQuote(arg string) string {
+	arg = systemdSpecifiers(arg)
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+// Launchd returns a launchd agent for s, with s.Name as its label. It starts
+// at login, restarts a run that fails and, like the systemd unit, gives a
+// stopped run time to finish its iteration.
+func Launchd(s Spec) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`)
+	plistKey(&b, "Label", s.Name)
+	b.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, arg := range s.Args {
+		fmt.Fprintf(&b, "\t\t<string>%s</string>\n", xmlEscape(arg))
+	}
+	b.WriteString("\t</array>\n")
+	plistKey(&b, "WorkingDirectory", s.Dir)
+	if len(s.Env) > 0 {
+		b.WriteString("\t<key>EnvironmentVariables</key>\n\t<dict>\n")
+		for _, kv := range s.Env {
+			k, v, _ := strings.Cut(kv, "=")
+			fmt.Fprintf(&b, "\t\t<key>%s</key>\n\t\t<string>%s</string>\n", xmlEscape(k), xmlEscape(v))
+		}
+		b.WriteString("\t</dict>\n")
+	}
+	b.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n")
+	b.WriteString("\t<key>KeepAlive</key>\n\t<dict>\n\t\t<key>SuccessfulExit</key>\n\t\t<false/>\n\t</dict>\n")
+	fmt.Fprintf(&b, "\t<key>ThrottleInterval</key>\n\t<integer>%d</integer>\n", RestartDelay)
+	fmt.Fprintf(&b, "\t<key>ExitTimeOut</key>\n\t<integer>%d</integer>\n", stopTimeoutSeconds)
+	if s.LogPath != "" {
+		plistKey(&b, "StandardOutPath", s.LogPath)
+		plistKey(&b, "StandardErrorPath", s.LogPath)
+	}
+	b.WriteString("</dict>\n</plist>\n")
+	return b.String()
+}
+
+// plistKey writes a plist key with a string value.
+func plistKey(b *strings.Builder, key, value string) {
+	fmt.Fprintf(b, "\t<key>%s</key>\n\t<string>%s</string>\n", key, xmlEscape(value))
+}
+
+// xmlEscape escapes s for XML character data.
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// Write writes content to path, creating its folder. It reports whether a
+// file was already there and has been replaced.
+func Write(path, content string) (replaced bool, err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, fmt.Errorf("creating %s: %w", filepath.Dir(path), err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		replaced = true
+	}
+	// The environment can carry RALPH_* settings; keep it to the user
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		return false, fmt.Errorf("writing %s: %w", path, err)
+	}
+	return replaced, nil
+}
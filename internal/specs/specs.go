@@ -0,0 +1,117 @@
+// Package specs indexes the spec files in a spec folder, picks the set a run
+// covers, and describes that set for the loop prompt.
+package specs
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// File is one spec file in a spec folder.
+type File struct {
+	Name    string // path relative to the spec folder, slash-separated, e.g. "api/auth.md"
+	Path    string // path as given to Index joined with Name, e.g. "specs/api/auth.md"
+	Size    int64
+	ModTime time.Time
+}
+
+// Index returns the files under dir, in subfolders too, sorted by name.
+// Hidden files and folders (".name") are skipped.
+func Index(dir string) ([]File, error) {
+	var files []File
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, File{
+			Name:    filepath.ToSlash(rel),
+			Path:    p,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Select returns the files named in names, in index order. A name that is not
+// in files is an error.
+func Select(files []File, names []string) ([]File, error) {
+	want := make(map[string]bool, len(names))
+	for _, name := range names {
+		want[filepath.ToSlash(filepath.Clean(name))] = true
+	}
+	var selected []File
+	for _, f := range files {
+		if want[f.Name] {
+			selected = append(selected, f)
+			delete(want, f.Name)
+		}
+	}
+	for _, name := range names {
+		if want[filepath.ToSlash(filepath.Clean(name))] {
+			return nil, fmt.Errorf("spec %q not found", name)
+		}
+	}
+	return selected, nil
+}
+
+// Names returns the names of files.
+func Names(files []File) []string {
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// Context returns the prompt section limiting a run to the named spec files
+// in folder, to be appended to the loop prompt. It is "" when names is empty,
+// as then every spec applies.
+func Context(folder string, names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n\nSPECS: this run covers only the spec files listed below. Study these and leave the other specs alone:\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "- %s\n", path.Join(filepath.ToSlash(folder), name))
+	}
+	return b.String()
+}
+
+// FormatSize renders a file size compactly, e.g. "812 B", "4.2 KB", "1.3 MB".
+func FormatSize(n int64) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%d B", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+	}
+}
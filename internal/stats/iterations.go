@@ -0,0 +1,107 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// maxIterationRecords caps how many iterations IterationStats remembers; the
+// oldest are dropped first.
+const maxIterationRecords = 1000
+
+// IterationRecord is what one loop iteration used.
+type IterationRecord struct {
+	Iteration int
+	Tokens    int64
+	CostUSD   float64
+	Duration  time.Duration
+	ToolUses  int
+	Model     string // model the agent ran with ("" = not known)
+	Running   bool   // the iteration has not finished; its figures are live
+}
+
+// IterationStats collects a record per loop iteration. Tokens and cost are
+// the change in the run's Snapshot between the iteration's start and finish.
+// All methods are safe for concurrent use.
+type IterationStats struct {
+	mu      sync.Mutex
+	records []IterationRecord
+	start   Snapshot  // run totals when the running iteration started
+	started time.Time // when the running iteration started
+	running bool
+}
+
+// NewIterationStats creates an empty collector.
+func NewIterationStats() *IterationStats {
+	return &IterationStats{}
+}
+
+// Start begins a record for iteration, finishing the running one first.
+func (s *IterationStats) Start(iteration int, snap Snapshot, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finish(snap, now)
+	s.records = append(s.records, IterationRecord{Iteration: iteration, Running: true})
+	if len(s.records) > maxIterationRecords {
+		s.records = s.records[len(s.records)-maxIterationRecords:]
+	}
+	s.start = snap
+	s.started = now
+	s.running = true
+}
+
+// AddToolUses counts n tool calls against the running iteration.
+func (s *IterationStats) AddToolUses(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		s.records[len(s.records)-1].ToolUses += n
+	}
+}
+
+// SetModel records the model the running iteration's agent runs with.
+func (s *IterationStats) SetModel(model string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		s.records[len(s.records)-1].Model = model
+	}
+}
+
+// Finish closes the running iteration at the run totals in snap.
+func (s *IterationStats) Finish(snap Snapshot, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finish(snap, now)
+}
+
+func (s *IterationStats) finish(snap Snapshot, now time.Time) {
+	if !s.running {
+		return
+	}
+	s.records[len(s.records)-1] = s.measure(snap, now)
+	s.records[len(s.records)-1].Running = false
+	s.running = false
+}
+
+// measure returns the running iteration's record as of snap and now.
+func (s *IterationStats) measure(snap Snapshot, now time.Time) IterationRecord {
+	r := s.records[len(s.records)-1]
+	r.Tokens = snap.TotalTokensCount - s.start.TotalTokensCount
+	r.CostUSD = snap.TotalCostUSD - s.start.TotalCostUSD
+	r.Duration = now.Sub(s.started)
+	return r
+}
+
+// Records returns every remembered iteration, oldest first. A running
+// iteration is measured against the run totals in snap at now.
+func (s *IterationStats) Records(snap Snapshot, now time.Time) []IterationRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]IterationRecord, len(s.records))
+	copy(records, s.records)
+	if s.running {
+		records[len(records)-1] = s.measure(snap, now)
+	}
+	return records
+}
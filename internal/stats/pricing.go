@@ -0,0 +1,74 @@
+package stats
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PriceRule prices the models whose identifier contains Match, ignoring case.
+// A Match of "*" prices every model, including usage that names none, as
+// some backends' does.
+type PriceRule struct {
+	Match   string
+	Pricing ModelPricing
+}
+
+// priceRules, guarded by priceMu, are the rules set with SetPriceRules.
+var (
+	priceMu    sync.RWMutex
+	priceRules []PriceRule
+)
+
+// SetPriceRules replaces the rules PricingForModel checks before the built-in
+// tiers. Later rules win over earlier ones, so a --price on the command line
+// overrides one in .ralphrc.
+func SetPriceRules(rules []PriceRule) {
+	priceMu.Lock()
+	defer priceMu.Unlock()
+	priceRules = append([]PriceRule(nil), rules...)
+}
+
+// ParsePriceRule parses a --price setting: MODEL=INPUT/OUTPUT in USD per
+// million tokens, optionally followed by /CACHE_WRITE/CACHE_READ, e.g.
+// "gpt-5=1.25/10/0/0.125". Without cache rates, cache writes cost 1.25x and
+// cache reads 0.1x the input rate, as for Claude models.
+func ParsePriceRule(s string) (PriceRule, error) {
+	match, rates, ok := strings.Cut(s, "=")
+	match = strings.TrimSpace(match)
+	if !ok || match == "" {
+		return PriceRule{}, fmt.Errorf("want MODEL=INPUT/OUTPUT[/CACHE_WRITE/CACHE_READ] in USD per million tokens, got %q", s)
+	}
+	fields := strings.Split(rates, "/")
+	if len(fields) != 2 && len(fields) != 4 {
+		return PriceRule{}, fmt.Errorf("want INPUT/OUTPUT or INPUT/OUTPUT/CACHE_WRITE/CACHE_READ rates for %s, got %q", match, rates)
+	}
+	perToken := make([]float64, len(fields))
+	for i, f := range fields {
+		rate, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil || rate < 0 {
+			return PriceRule{}, fmt.Errorf("rates for %s must be non-negative numbers, got %q", match, f)
+		}
+		perToken[i] = rate / 1_000_000
+	}
+	p := ModelPricing{Input: perToken[0], Output: perToken[1], CacheCreation: perToken[0] * 1.25, CacheRead: perToken[0] * 0.1}
+	if len(perToken) == 4 {
+		p.CacheCreation, p.CacheRead = perToken[2], perToken[3]
+	}
+	return PriceRule{Match: match, Pricing: p}, nil
+}
+
+// rulePricing returns the pricing of the last rule matching model.
+func rulePricing(model string) (ModelPricing, bool) {
+	priceMu.RLock()
+	defer priceMu.RUnlock()
+	m := strings.ToLower(model)
+	for i := len(priceRules) - 1; i >= 0; i-- {
+		r := priceRules[i]
+		if r.Match == "*" || (m != "" && strings.Contains(m, strings.ToLower(r.Match))) {
+			return r.Pricing, true
+		}
+	}
+	return ModelPricing{}, false
+}
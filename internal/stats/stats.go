@@ -27,6 +27,8 @@ type tokenCounters struct {
 	TotalCostUSD        float64 `json:"total_cost"`
 	TotalTokensCount    int64   `json:"total_tokens"`
 	TotalElapsedNs      int64   `json:"elapsed_ns"`
+	Hibernations        int64   `json:"hibernations"` // rate-limit hibernations waited out
+	HibernateNs         int64   `json:"hibernate_ns"` // total time spent in them
 }
 
 // TokenStats tracks token usage and costs.
@@ -35,6 +37,15 @@ type tokenCounters struct {
 type TokenStats struct {
 	mu sync.RWMutex `json:"-"`
 	tokenCounters
+	iterTokens int64     // tokens added since the iteration started
+	efficiency []float64 // cost per 1k tokens of the last EfficiencyHistory reconciled iterations, oldest first
+
+	// The current iteration's costs that are in TotalCostUSD but not yet
+	// final, by owner: "" for the main agent, else the ID of the Task tool
+	// call that spawned the subagent. ReconcileIteration replaces them all
+	// with the main agent's reported cost.
+	iterEstimates map[string]float64 // costs estimated from token usage
+	iterReported  map[string]float64 // costs subagents' results reported, already part of the main agent's
 }
 
 // NewTokenStats creates a new empty TokenStats instance
@@ -51,6 +62,7 @@ func (t *TokenStats) AddUsage(input, output, cacheCreation, cacheRead int64) {
 	t.CacheCreationTokens += cacheCreation
 	t.CacheReadTokens += cacheRead
 	t.TotalTokensCount = t.InputTokens + t.OutputTokens + t.CacheCreationTokens + t.CacheReadTokens
+	t.iterTokens += input + output + cacheCreation + cacheRead
 }
 
 // ModelPricing holds per-token USD list prices for a Claude model tier.
@@ -83,10 +95,14 @@ var (
 // was made model-aware.
 var DefaultPricing = pricingSonnet
 
-// PricingForModel returns the price set for a Claude model identifier (e.g.
-// "claude-opus-4-8"), matching by tier substring. Empty or unrecognized
-// identifiers fall back to DefaultPricing.
+// PricingForModel returns the price set for a model identifier (e.g.
+// "claude-opus-4-8"): that of the rules set with SetPriceRules, when one
+// matches, else the Claude tier it names. Empty or unrecognized identifiers
+// fall back to DefaultPricing.
 func PricingForModel(model string) ModelPricing {
+	if p, ok := rulePricing(model); ok {
+		return p
+	}
 	m := strings.ToLower(model)
 	switch {
 	case strings.Contains(m, "opus"):
@@ -121,12 +137,158 @@ func (t *TokenStats) AddCost(costUSD float64) {
 }
 
 // ReconcileCost replaces an estimated cost delta with the actual cost.
-// It subtracts the estimated amount and adds the actual amount.
+// It subtracts the estimated amount and adds the actual amount. Called once
+// per iteration, it also records the iteration's cost per 1k tokens for
+// EfficiencyTrend. Costs added with AddEstimate and AddSubagentCost are
+// reconciled by ReconcileIteration instead.
 func (t *TokenStats) ReconcileCost(estimatedDelta, actualCost float64) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	t.TotalCostUSD -= estimatedDelta
 	t.TotalCostUSD += actualCost
+	t.recordEfficiency(actualCost)
+}
+
+// AddEstimate adds a cost estimated from one message's token usage to the
+// total, for the current iteration's owner: "" for the main agent, else the
+// ID of the Task tool call that spawned the subagent the message is from.
+// It stays an estimate until AddSubagentCost or ReconcileIteration replaces
+// it.
+func (t *TokenStats) AddEstimate(owner string, costUSD float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.iterEstimates == nil {
+		t.iterEstimates = make(map[string]float64)
+	}
+	t.iterEstimates[owner] += costUSD
+	t.TotalCostUSD += costUSD
+}
+
+// AddSubagentCost records the cost a subagent's result reported: it replaces
+// the estimates added for owner, and any cost owner reported before, so the
+// subagent's messages aren't counted twice. The main agent's reported cost
+// already includes it, so ReconcileIteration takes it out again.
+func (t *TokenStats) AddSubagentCost(owner string, costUSD float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.iterReported == nil {
+		t.iterReported = make(map[string]float64)
+	}
+	t.TotalCostUSD -= t.iterEstimates[owner] + t.iterReported[owner]
+	delete(t.iterEstimates, owner)
+	t.iterReported[owner] = costUSD
+	t.TotalCostUSD += costUSD
+}
+
+// ReconcileIteration ends the current iteration with actualCost, the cost
+// the main agent's result reported for it: every estimate and subagent cost
+// added since the iteration started is replaced by actualCost in one step,
+// so a Snapshot never sees them both. It also records the iteration's cost
+// per 1k tokens for EfficiencyTrend.
+func (t *TokenStats) ReconcileIteration(actualCost float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, c := range t.iterEstimates {
+		t.TotalCostUSD -= c
+	}
+	for _, c := range t.iterReported {
+		t.TotalCostUSD -= c
+	}
+	t.TotalCostUSD += actualCost
+	t.recordEfficiency(actualCost)
+}
+
+// EndIteration ends the current iteration without a reported cost, e.g.
+// when it was interrupted: its estimates and subagent costs stay in the
+// total as the best known cost of the work done.
+func (t *TokenStats) EndIteration() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.iterTokens = 0
+	t.iterEstimates, t.iterReported = nil, nil
+}
+
+// recordEfficiency records the iteration's cost per 1k tokens and starts a
+// new iteration. t.mu must be held.
+func (t *TokenStats) recordEfficiency(actualCost float64) {
+	if t.iterTokens > 0 && actualCost > 0 {
+		t.efficiency = append(t.efficiency, actualCost/float64(t.iterTokens)*1000)
+		if len(t.efficiency) > EfficiencyHistory {
+			t.efficiency = t.efficiency[len(t.efficiency)-EfficiencyHistory:]
+		}
+	}
+	t.iterTokens = 0
+	t.iterEstimates, t.iterReported = nil, nil
+}
+
+// EfficiencyHistory is how many reconciled iterations EfficiencyTrend looks
+// back over.
+const EfficiencyHistory = 5
+
+// EfficiencyTrendRatio is how far the latest iteration's cost per 1k tokens
+// must move from the average of the ones before it to count as a trend.
+const EfficiencyTrendRatio = 0.1
+
+// Trend is the direction of the cost per 1k tokens across recent iterations.
+type Trend int
+
+const (
+	TrendSteady  Trend = iota // too few iterations, or within EfficiencyTrendRatio
+	TrendRising               // costing more per token, e.g. as cache hits drop
+	TrendFalling              // costing less per token
+)
+
+// String returns "steady", "rising" or "falling".
+func (t Trend) String() string {
+	switch t {
+	case TrendRising:
+		return "rising"
+	case TrendFalling:
+		return "falling"
+	default:
+		return "steady"
+	}
+}
+
+// Arrow returns the trend as an arrow for compact displays.
+func (t Trend) Arrow() string {
+	switch t {
+	case TrendRising:
+		return "↑"
+	case TrendFalling:
+		return "↓"
+	default:
+		return "→"
+	}
+}
+
+// EfficiencyTrend compares the latest reconciled iteration's cost per 1k
+// tokens with the average of up to EfficiencyHistory-1 before it. It is
+// TrendSteady until three iterations have been reconciled.
+func (t *TokenStats) EfficiencyTrend() Trend {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return efficiencyTrend(t.efficiency)
+}
+
+func efficiencyTrend(history []float64) Trend {
+	if len(history) < 3 {
+		return TrendSteady
+	}
+	latest := history[len(history)-1]
+	var sum float64
+	for _, v := range history[:len(history)-1] {
+		sum += v
+	}
+	avg := sum / float64(len(history)-1)
+	switch {
+	case latest > avg*(1+EfficiencyTrendRatio):
+		return TrendRising
+	case latest < avg*(1-EfficiencyTrendRatio):
+		return TrendFalling
+	default:
+		return TrendSteady
+	}
 }
 
 // TotalTokens returns the sum of all token counts
@@ -136,6 +298,14 @@ func (t *TokenStats) TotalTokens() int64 {
 	return t.InputTokens + t.OutputTokens + t.CacheCreationTokens + t.CacheReadTokens
 }
 
+// AddHibernation records a rate-limit hibernation that lasted d.
+func (t *TokenStats) AddHibernation(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Hibernations++
+	t.HibernateNs += int64(d)
+}
+
 // SetTotalElapsedNs sets the total elapsed time in nanoseconds (thread-safe)
 func (t *TokenStats) SetTotalElapsedNs(ns int64) {
 	t.mu.Lock()
@@ -149,6 +319,63 @@ func (t *TokenStats) SetTotalElapsedNs(ns int64) {
 // without copying a lock (which go vet's copylocks check forbids).
 type Snapshot struct {
 	tokenCounters
+	EfficiencyTrend Trend // TokenStats.EfficiencyTrend when the snapshot was taken
+}
+
+// LowCacheHitRatio is the cache-hit ratio below which caching is considered
+// to be working poorly (flagged in the TUI footer).
+const LowCacheHitRatio = 0.5
+
+// cacheHitRatio returns CacheReadTokens / (CacheReadTokens + CacheCreationTokens),
+// or 0 when no cache tokens have been recorded.
+func (c tokenCounters) cacheHitRatio() float64 {
+	total := c.CacheReadTokens + c.CacheCreationTokens
+	if total <= 0 {
+		return 0
+	}
+	return float64(c.CacheReadTokens) / float64(total)
+}
+
+// CacheHitRatio returns the share of cache tokens that were reads rather than
+// writes, from 0 to 1. It is 0 when no cache tokens have been recorded; check
+// HasCacheActivity to tell that apart from a 0% hit rate.
+func (t *TokenStats) CacheHitRatio() float64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.cacheHitRatio()
+}
+
+// CacheHitRatio is TokenStats.CacheHitRatio for a snapshot.
+func (s Snapshot) CacheHitRatio() float64 {
+	return s.cacheHitRatio()
+}
+
+// costPerKTokens returns TotalCostUSD per 1000 tokens, or 0 when no tokens
+// have been recorded.
+func (c tokenCounters) costPerKTokens() float64 {
+	total := c.InputTokens + c.OutputTokens + c.CacheCreationTokens + c.CacheReadTokens
+	if total <= 0 {
+		return 0
+	}
+	return c.TotalCostUSD / float64(total) * 1000
+}
+
+// CostPerKTokens returns the cost in USD per 1000 tokens (all kinds, cache
+// included), or 0 when no tokens have been recorded.
+func (t *TokenStats) CostPerKTokens() float64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.costPerKTokens()
+}
+
+// CostPerKTokens is TokenStats.CostPerKTokens for a snapshot.
+func (s Snapshot) CostPerKTokens() float64 {
+	return s.costPerKTokens()
+}
+
+// HasCacheActivity returns true if any cache reads or writes were recorded.
+func (s Snapshot) HasCacheActivity() bool {
+	return s.CacheReadTokens+s.CacheCreationTokens > 0
 }
 
 // Snapshot returns a consistent point-in-time copy of the stats for reading
@@ -158,7 +385,7 @@ func (t *TokenStats) Snapshot() Snapshot {
 	defer t.mu.RUnlock()
 	c := t.tokenCounters
 	c.TotalTokensCount = c.InputTokens + c.OutputTokens + c.CacheCreationTokens + c.CacheReadTokens
-	return Snapshot{tokenCounters: c}
+	return Snapshot{tokenCounters: c, EfficiencyTrend: efficiencyTrend(t.efficiency)}
 }
 
 // FormatTokens formats a token count into a human-readable string
@@ -178,6 +405,51 @@ func FormatTokens(count int64) string {
 	}
 }
 
+// maxCostDecimals bounds the precision accepted by SetCostFormat.
+const maxCostDecimals = 10
+
+// Cost display settings used by FormatCost (see SetCostFormat).
+var (
+	costFormatMu sync.RWMutex
+	costSymbol   = "$"
+	costDecimals = 6
+)
+
+// SetCostFormat sets the symbol and number of decimals FormatCost uses. It only
+// changes how costs are displayed; the underlying values are always USD.
+func SetCostFormat(symbol string, decimals int) {
+	costFormatMu.Lock()
+	defer costFormatMu.Unlock()
+	costSymbol = symbol
+	costDecimals = min(max(decimals, 0), maxCostDecimals)
+}
+
+// FormatCost formats a USD cost for display using the configured symbol and
+// precision, e.g. 0.123456 → "$0.123456" with the defaults.
+func FormatCost(usd float64) string {
+	costFormatMu.RLock()
+	defer costFormatMu.RUnlock()
+	return fmt.Sprintf("%s%.*f", costSymbol, costDecimals, usd)
+}
+
+// FormatDuration formats an elapsed duration as HH:MM:SS, prefixed with a day
+// count once it reaches 24h.
+// e.g., 3725s → "01:02:05", 26h → "1d 02:00:00". Negative durations format as zero.
+func FormatDuration(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	total := int64(d / time.Second)
+	days := total / 86400
+	hours := total / 3600 % 24
+	minutes := total / 60 % 60
+	seconds := total % 60
+	if days > 0 {
+		return fmt.Sprintf("%dd %02d:%02d:%02d", days, hours, minutes, seconds)
+	}
+	return fmt.Sprintf("%02d:%02d:%02d", hours, minutes, seconds)
+}
+
 
 // GenerateSessionID returns a 6-char lowercase hex string from crypto/rand.
 func GenerateSessionID() (string, error) {
@@ -256,14 +528,16 @@ func InitDB(path string) (*sql.DB, error) {
 	// connection lets Go's pool serialize writes instead of hitting SQLITE_BUSY.
 	db.SetMaxOpenConns(1)
 
-	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+	// Set the busy timeout first so that switching to WAL waits out other
+	// ralphs opening the database at the same moment (--parallel)
+	if _, err := db.Exec("PRAGMA busy_timeout=5000"); err != nil {
 		db.Close()
-		return nil, fmt.Errorf("setting WAL mode: %w", err)
+		return nil, fmt.Errorf("setting busy timeout: %w", err)
 	}
 
-	if _, err := db.Exec("PRAGMA busy_timeout=5000"); err != nil {
+	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
 		db.Close()
-		return nil, fmt.Errorf("setting busy timeout: %w", err)
+		return nil, fmt.Errorf("setting WAL mode: %w", err)
 	}
 
 	const createCheckpoints = `CREATE TABLE IF NOT EXISTS checkpoints (
@@ -278,19 +552,37 @@ func InitDB(path string) (*sql.DB, error) {
 		delta_output_tokens INTEGER,
 		delta_cache_creation INTEGER,
 		delta_cache_read    INTEGER,
-		timestamp           TEXT NOT NULL
+		timestamp           TEXT NOT NULL,
+		run_id              TEXT,
+		iteration           INTEGER
 	)`
 	if _, err := db.Exec(createCheckpoints); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("creating checkpoints table: %w", err)
 	}
 
+	// Databases created before run IDs were recorded lack the column
+	if err := ensureColumn(db, "checkpoints", "run_id", "TEXT"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("adding checkpoints.run_id: %w", err)
+	}
+	if err := ensureColumn(db, "checkpoints", "iteration", "INTEGER"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("adding checkpoints.iteration: %w", err)
+	}
+
 	const createIndex = `CREATE INDEX IF NOT EXISTS idx_checkpoints_ts ON checkpoints(timestamp)`
 	if _, err := db.Exec(createIndex); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("creating timestamp index: %w", err)
 	}
 
+	const createLoopIndex = `CREATE INDEX IF NOT EXISTS idx_checkpoints_loop ON checkpoints(session_id, iteration)`
+	if _, err := db.Exec(createLoopIndex); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating loop index: %w", err)
+	}
+
 	const createLoopStats = `CREATE TABLE IF NOT EXISTS loop_stats (
 		loop_id               TEXT PRIMARY KEY,
 		session_id            TEXT NOT NULL,
@@ -305,7 +597,13 @@ func InitDB(path string) (*sql.DB, error) {
 		cache_read_tokens     INTEGER,
 		total_tokens          INTEGER,
 		start_time            TEXT,
-		finish_time           TEXT
+		finish_time           TEXT,
+		hibernations          INTEGER DEFAULT 0,
+		hibernate_ns          INTEGER DEFAULT 0,
+		commits               INTEGER DEFAULT 0,
+		files_changed         INTEGER DEFAULT 0,
+		insertions            INTEGER DEFAULT 0,
+		deletions             INTEGER DEFAULT 0
 	)`
 	if _, err := db.Exec(createLoopStats); err != nil {
 		db.Close()
@@ -320,13 +618,32 @@ func InitDB(path string) (*sql.DB, error) {
 		cache_read_tokens     INTEGER DEFAULT 0,
 		total_cost            REAL DEFAULT 0,
 		total_tokens          INTEGER DEFAULT 0,
-		elapsed_ns            INTEGER DEFAULT 0
+		elapsed_ns            INTEGER DEFAULT 0,
+		hibernations          INTEGER DEFAULT 0,
+		hibernate_ns          INTEGER DEFAULT 0
 	)`
 	if _, err := db.Exec(createProjectStats); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("creating project_stats table: %w", err)
 	}
 
+	// Databases created before hibernations were recorded lack the columns
+	for _, table := range []string{"loop_stats", "project_stats"} {
+		for _, column := range []string{"hibernations", "hibernate_ns"} {
+			if err := ensureColumn(db, table, column, "INTEGER DEFAULT 0"); err != nil {
+				db.Close()
+				return nil, fmt.Errorf("adding %s.%s: %w", table, column, err)
+			}
+		}
+	}
+	// ... and before commits were
+	for _, column := range []string{"commits", "files_changed", "insertions", "deletions"} {
+		if err := ensureColumn(db, "loop_stats", column, "INTEGER DEFAULT 0"); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("adding loop_stats.%s: %w", column, err)
+		}
+	}
+
 	// Prune old checkpoint rows
 	if _, err := db.Exec("DELETE FROM checkpoints WHERE timestamp < datetime('now', '-7 days')"); err != nil {
 		db.Close()
@@ -336,6 +653,30 @@ func InitDB(path string) (*sql.DB, error) {
 	return db, nil
 }
 
+// ensureColumn adds column to table with the given type unless it exists.
+func ensureColumn(db *sql.DB, table, column, decl string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl))
+	return err
+}
+
 // SaveProjectStats persists cumulative token stats for a project key.
 func SaveProjectStats(db *sql.DB, projectKey string, s *TokenStats) error {
 	if db == nil {
@@ -343,10 +684,10 @@ func SaveProjectStats(db *sql.DB, projectKey string, s *TokenStats) error {
 	}
 	snap := s.Snapshot()
 	_, err := db.Exec(
-		`INSERT OR REPLACE INTO project_stats (project_key, input_tokens, output_tokens, cache_creation_tokens, cache_read_tokens, total_cost, total_tokens, elapsed_ns)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		`INSERT OR REPLACE INTO project_stats (project_key, input_tokens, output_tokens, cache_creation_tokens, cache_read_tokens, total_cost, total_tokens, elapsed_ns, hibernations, hibernate_ns)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		projectKey, snap.InputTokens, snap.OutputTokens, snap.CacheCreationTokens, snap.CacheReadTokens,
-		snap.TotalCostUSD, snap.TotalTokensCount, snap.TotalElapsedNs,
+		snap.TotalCostUSD, snap.TotalTokensCount, snap.TotalElapsedNs, snap.Hibernations, snap.HibernateNs,
 	)
 	return err
 }
@@ -358,12 +699,13 @@ func LoadProjectStats(db *sql.DB, projectKey string) (*TokenStats, error) {
 		return NewTokenStats(), nil
 	}
 	row := db.QueryRow(
-		`SELECT input_tokens, output_tokens, cache_creation_tokens, cache_read_tokens, total_cost, total_tokens, elapsed_ns
+		`SELECT input_tokens, output_tokens, cache_creation_tokens, cache_read_tokens, total_cost, total_tokens, elapsed_ns,
+			COALESCE(hibernations, 0), COALESCE(hibernate_ns, 0)
 		 FROM project_stats WHERE project_key = ?`, projectKey,
 	)
 	s := NewTokenStats()
 	err := row.Scan(&s.InputTokens, &s.OutputTokens, &s.CacheCreationTokens, &s.CacheReadTokens,
-		&s.TotalCostUSD, &s.TotalTokensCount, &s.TotalElapsedNs)
+		&s.TotalCostUSD, &s.TotalTokensCount, &s.TotalElapsedNs, &s.Hibernations, &s.HibernateNs)
 	if err == sql.ErrNoRows {
 		return NewTokenStats(), nil
 	}
@@ -400,6 +742,8 @@ type CheckpointParams struct {
 	DeltaCacheCreation int64
 	DeltaCacheRead    int64
 	Timestamp         string
+	RunID             string
+	Iteration         int // loop number within the session
 }
 
 // FlushCheckpoint inserts a checkpoint row into the database.
@@ -409,11 +753,11 @@ func FlushCheckpoint(db *sql.DB, p CheckpointParams) error {
 		return nil
 	}
 	_, err := db.Exec(
-		`INSERT INTO checkpoints (loop_id, session_id, owner, repo, branch, delta_cost, delta_input_tokens, delta_output_tokens, delta_cache_creation, delta_cache_read, timestamp)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		`INSERT INTO checkpoints (loop_id, session_id, owner, repo, branch, delta_cost, delta_input_tokens, delta_output_tokens, delta_cache_creation, delta_cache_read, timestamp, run_id, iteration)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		p.LoopID, p.SessionID, p.Owner, p.Repo, p.Branch,
 		p.DeltaCost, p.DeltaInputTokens, p.DeltaOutputTokens, p.DeltaCacheCreation, p.DeltaCacheRead,
-		p.Timestamp,
+		p.Timestamp, p.RunID, p.Iteration,
 	)
 	return err
 }
@@ -434,6 +778,12 @@ type LoopStatsParams struct {
 	TotalTokens         int64
 	StartTime           string
 	FinishTime          string
+	Hibernations        int64 // rate-limit hibernations during the loop
+	HibernateNs         int64 // time spent in them
+	Commits             int64 // commits the loop made
+	FilesChanged        int64 // files they changed
+	Insertions          int64 // lines they added
+	Deletions           int64 // lines they removed
 }
 
 // WriteLoopStats inserts or replaces a loop_stats row.
@@ -443,15 +793,22 @@ func WriteLoopStats(db *sql.DB, p LoopStatsParams) error {
 		return nil
 	}
 	_, err := db.Exec(
-		`INSERT OR REPLACE INTO loop_stats (loop_id, session_id, owner, repo, branch, description, total_cost, input_tokens, output_tokens, cache_creation_tokens, cache_read_tokens, total_tokens, start_time, finish_time)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		`INSERT OR REPLACE INTO loop_stats (loop_id, session_id, owner, repo, branch, description, total_cost, input_tokens, output_tokens, cache_creation_tokens, cache_read_tokens, total_tokens, start_time, finish_time, hibernations, hibernate_ns, commits, files_changed, insertions, deletions)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		p.LoopID, p.SessionID, p.Owner, p.Repo, p.Branch, p.Description,
 		p.TotalCost, p.InputTokens, p.OutputTokens, p.CacheCreationTokens, p.CacheReadTokens, p.TotalTokens,
-		p.StartTime, p.FinishTime,
+		p.StartTime, p.FinishTime, p.Hibernations, p.HibernateNs,
+		p.Commits, p.FilesChanged, p.Insertions, p.Deletions,
 	)
 	return err
 }
 
+// rollingWindowStart is the SQL start of the rolling 60-minute window, in the
+// RFC3339 UTC form checkpoints are stamped with. A checkpoint is in the window
+// while its timestamp is after the start, so it ages out exactly 60 minutes
+// after it was written.
+const rollingWindowStart = `strftime('%Y-%m-%dT%H:%M:%SZ', 'now', '-60 minutes')`
+
 // QueryRollingHourCost returns the sum of delta_cost for the rolling 60-minute window.
 // If owner and repo are non-empty, the query is scoped to that project.
 // Returns (0, nil) if db is nil.
@@ -464,7 +821,7 @@ func QueryRollingHourCost(db *sql.DB, owner, repo string) (float64, error) {
 	if owner != "" && repo != "" {
 		err := db.QueryRow(
 			`SELECT COALESCE(SUM(delta_cost), 0) FROM checkpoints
-			 WHERE timestamp >= strftime('%Y-%m-%dT%H:%M:%S', 'now', '-60 minutes')
+			 WHERE timestamp > `+rollingWindowStart+`
 			   AND owner = ? AND repo = ?`,
 			owner, repo,
 		).Scan(&cost)
@@ -473,7 +830,7 @@ func QueryRollingHourCost(db *sql.DB, owner, repo string) (float64, error) {
 
 	err := db.QueryRow(
 		`SELECT COALESCE(SUM(delta_cost), 0) FROM checkpoints
-		 WHERE timestamp >= strftime('%Y-%m-%dT%H:%M:%S', 'now', '-60 minutes')`,
+		 WHERE timestamp > `+rollingWindowStart,
 	).Scan(&cost)
 	return cost, err
 }
@@ -481,7 +838,7 @@ func QueryRollingHourCost(db *sql.DB, owner, repo string) (float64, error) {
 // QueryRollingWakeTime returns the earliest time at which the rolling 60-minute window
 // cost sum will drop below limit. It walks checkpoints oldest-first, subtracting each
 // row's delta_cost from the total. When the remaining cost drops below limit, the wake
-// time is that row's timestamp + 60 minutes. If no single row's aging-out is sufficient,
+// time is that row's timestamp + 60 minutes, the moment it ages out. If no single row's aging-out is sufficient,
 // returns time.Now().Add(60 * time.Minute) as a fallback.
 // Returns (time.Time{}, nil) if db is nil.
 func QueryRollingWakeTime(db *sql.DB, owner, repo string, limit float64) (time.Time, error) {
@@ -493,13 +850,13 @@ func QueryRollingWakeTime(db *sql.DB, owner, repo string, limit float64) (time.T
 	var args []interface{}
 	if owner != "" && repo != "" {
 		query = `SELECT delta_cost, timestamp FROM checkpoints
-				 WHERE timestamp >= strftime('%Y-%m-%dT%H:%M:%S', 'now', '-60 minutes')
+				 WHERE timestamp > `+rollingWindowStart+`
 				   AND owner = ? AND repo = ?
 				 ORDER BY timestamp ASC`
 		args = []interface{}{owner, repo}
 	} else {
 		query = `SELECT delta_cost, timestamp FROM checkpoints
-				 WHERE timestamp >= strftime('%Y-%m-%dT%H:%M:%S', 'now', '-60 minutes')
+				 WHERE timestamp > `+rollingWindowStart+`
 				 ORDER BY timestamp ASC`
 	}
 
@@ -544,3 +901,118 @@ func QueryRollingWakeTime(db *sql.DB, owner, repo string, limit float64) (time.T
 	return time.Now().UTC().Add(60 * time.Minute), nil
 }
 
+// Cost breakdown groupings for QueryCostBreakdown.
+const (
+	CostByHour = "hour"
+	CostByDay  = "day"
+	CostByLoop = "loop"
+)
+
+// CostBucket is the cost and tokens checkpointed in one hour, day or loop.
+type CostBucket struct {
+	Key       string  `json:"key"`                  // UTC "2006-01-02 15:00" or "2006-01-02", or the loop ID
+	SessionID string  `json:"session_id,omitempty"` // loop buckets only
+	Iteration int     `json:"iteration,omitempty"`  // loop buckets only
+	Cost      float64 `json:"cost"`
+	Tokens    int64   `json:"tokens"`
+}
+
+// QueryCostBreakdown sums checkpoints by hour, day or loop (CostByHour,
+// CostByDay, CostByLoop), oldest first. When since is non-zero only
+// checkpoints at or after it are counted; checkpoints older than 7 days are
+// pruned, so the breakdown covers at most the last week.
+// Returns nil (not an error) if db is nil.
+func QueryCostBreakdown(db *sql.DB, by string, since time.Time) ([]CostBucket, error) {
+	if db == nil {
+		return nil, nil
+	}
+
+	var key, group string
+	switch by {
+	case CostByHour:
+		key, group = `strftime('%Y-%m-%d %H:00', timestamp)`, "1"
+	case CostByDay:
+		key, group = `date(timestamp)`, "1"
+	case CostByLoop:
+		key, group = `loop_id`, "loop_id, session_id, iteration"
+	default:
+		return nil, fmt.Errorf("unknown cost breakdown %q", by)
+	}
+	query := `SELECT ` + key + `, session_id, COALESCE(iteration, 0),
+			COALESCE(SUM(delta_cost), 0),
+			COALESCE(SUM(delta_input_tokens + delta_output_tokens + delta_cache_creation + delta_cache_read), 0)
+		 FROM checkpoints`
+	var args []interface{}
+	if !since.IsZero() {
+		query += ` WHERE timestamp >= ?`
+		args = append(args, since.UTC().Format(time.RFC3339))
+	}
+	query += ` GROUP BY ` + group + ` ORDER BY MIN(timestamp) ASC`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []CostBucket
+	for rows.Next() {
+		var b CostBucket
+		if err := rows.Scan(&b.Key, &b.SessionID, &b.Iteration, &b.Cost, &b.Tokens); err != nil {
+			return nil, err
+		}
+		if by != CostByLoop {
+			b.SessionID, b.Iteration = "", 0
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
+
+// Totals aggregates recorded loops across every run in the stats database.
+type Totals struct {
+	Sessions            int     `json:"sessions"`
+	Loops               int     `json:"loops"`
+	TotalCost           float64 `json:"total_cost"`
+	InputTokens         int64   `json:"input_tokens"`
+	OutputTokens        int64   `json:"output_tokens"`
+	CacheCreationTokens int64   `json:"cache_creation_tokens"`
+	CacheReadTokens     int64   `json:"cache_read_tokens"`
+	TotalTokens         int64   `json:"total_tokens"`
+	Hibernations        int64   `json:"hibernations"`
+	HibernateNs         int64   `json:"hibernate_ns"`
+	Commits             int64   `json:"commits"`
+	FilesChanged        int64   `json:"files_changed"`
+	Insertions          int64   `json:"insertions"`
+	Deletions           int64   `json:"deletions"`
+}
+
+// QueryTotals sums the loop_stats rows of all runs, across all projects. When
+// since is non-zero only loops started at or after it are counted. loop_stats
+// is used rather than checkpoints because checkpoints are pruned after 7 days.
+// Returns zeroed totals (not an error) if db is nil.
+func QueryTotals(db *sql.DB, since time.Time) (Totals, error) {
+	var t Totals
+	if db == nil {
+		return t, nil
+	}
+
+	query := `SELECT COUNT(DISTINCT session_id), COUNT(*),
+			COALESCE(SUM(total_cost), 0), COALESCE(SUM(input_tokens), 0), COALESCE(SUM(output_tokens), 0),
+			COALESCE(SUM(cache_creation_tokens), 0), COALESCE(SUM(cache_read_tokens), 0), COALESCE(SUM(total_tokens), 0),
+			COALESCE(SUM(hibernations), 0), COALESCE(SUM(hibernate_ns), 0),
+			COALESCE(SUM(commits), 0), COALESCE(SUM(files_changed), 0), COALESCE(SUM(insertions), 0), COALESCE(SUM(deletions), 0)
+		 FROM loop_stats`
+	var args []interface{}
+	if !since.IsZero() {
+		// start_time is RFC3339 with the local offset; julianday normalizes it.
+		query += ` WHERE julianday(start_time) >= julianday(?)`
+		args = append(args, since.UTC().Format(time.RFC3339))
+	}
+
+	err := db.QueryRow(query, args...).Scan(&t.Sessions, &t.Loops,
+		&t.TotalCost, &t.InputTokens, &t.OutputTokens,
+		&t.CacheCreationTokens, &t.CacheReadTokens, &t.TotalTokens, &t.Hibernations, &t.HibernateNs,
+		&t.Commits, &t.FilesChanged, &t.Insertions, &t.Deletions)
+	return t, err
+}
@@ -0,0 +1,410 @@
+// Package telemetry exports a run as OpenTelemetry traces: one span for the
+// run with a child span per iteration carrying its cost, tokens and outcome,
+// and under it a span per tool call, lasting until the tool's result. Each
+// iteration's spans are sent together, as one OTLP/HTTP JSON request, with
+// the standard library, so tracing adds no dependencies; a nil *Tracer,
+// returned when no endpoint is configured, does nothing.
+package telemetry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudosai/ralph-go/internal/poster"
+)
+
+// ServiceName is the service.name resource attribute of exported spans.
+const ServiceName = "ralph"
+
+// maxToolSpans caps the tool-call spans recorded in one iteration.
+const maxToolSpans = 256
+
+// exportQueueSize bounds the batches of spans waiting to be sent. Beyond
+// it an iteration's spans wait to go with the next iteration's rather than
+// holding up the run.
+const exportQueueSize = 64
+
+// maxPendingSpans caps the spans waiting for room in the export queue.
+// Beyond it tool calls are dropped, and counted; iteration and run spans
+// never are.
+const maxPendingSpans = 4096
+
+// Span status codes (OTLP Status.code).
+const (
+	statusUnset = 0
+	statusOK    = 1
+	statusError = 2
+)
+
+// Tracer records a run's spans and exports them to an OTLP/HTTP endpoint in
+// the background. Its methods are safe for concurrent use and do nothing on
+// a nil *Tracer.
+type Tracer struct {
+	resource []keyValue
+	traceID  string
+	poster   *poster.Poster
+
+	mu       sync.Mutex // guards the fields below
+	run      *span
+	runCost  float64 // summed over ended iterations
+	iters    int     // iterations started
+	iter     *span
+	outcome  string           // outcome of the current iteration, set by SetOutcome
+	tools    map[string]*span // the current iteration's tool calls awaiting a result, by tool use ID
+	toolUses int              // tool calls in the current iteration
+	batch    []span           // ended spans not yet queued for export
+	dropped  int              // tool calls not recorded while the export queue was full
+	closed   bool             // Shutdown has run; later spans are dropped
+	err      error            // first batch that could not be encoded
+}
+
+// New starts a run span exported to endpoint, an OTLP/HTTP collector such as
+// "http://localhost:4318" (spans go to its /v1/traces path). resource adds
+// attributes describing the run, e.g. its ID and repository. It returns nil
+// when endpoint is empty, which turns tracing off.
+func New(endpoint string, resource map[string]string) *Tracer {
+	if endpoint == "" {
+		return nil
+	}
+	t := &Tracer{
+		resource: []keyValue{stringAttr("service.name", ServiceName)},
+		traceID:  newID(16),
+		poster:   poster.New(TracesURL(endpoint), exportQueueSize),
+	}
+	for k, v := range resource {
+		if v != "" {
+			t.resource = append(t.resource, stringAttr(k, v))
+		}
+	}
+	t.run = t.newSpan("ralph run", "")
+	return t
+}
+
+// TracesURL returns the OTLP/HTTP traces URL for a collector endpoint.
+func TracesURL(endpoint string) string {
+	endpoint = strings.TrimRight(endpoint, "/")
+	if strings.HasSuffix(endpoint, "/v1/traces") {
+		return endpoint
+	}
+	return endpoint + "/v1/traces"
+}
+
+// StartIteration opens the span for iteration n, ending any iteration left
+// open without recording its cost.
+func (t *Tracer) StartIteration(n int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.iter != nil {
+		t.endIteration(0, Tokens{})
+	}
+	t.iter = t.newSpan(fmt.Sprintf("iteration %d", n), t.run.SpanID)
+	t.iter.Attributes = append(t.iter.Attributes, intAttr("ralph.iteration", int64(n)))
+	t.outcome = ""
+	t.tools = make(map[string]*span)
+	t.toolUses = 0
+	t.iters++
+}
+
+// ToolUse opens a span for tool call id, with the file, pattern or command
+// it targets, under the current iteration's span, or under the span of the
+// tool call parentID when a subagent that call spawned made it. The span
+// lasts until ToolResult reports id's result; a call without an id ends at
+// once.
+func (t *Tracer) ToolUse(id, parentID, name, target string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.iter == nil {
+		return
+	}
+	t.toolUses++
+	if t.toolUses > maxToolSpans {
+		return
+	}
+	if len(t.batch) >= maxPendingSpans {
+		t.dropped++
+		return
+	}
+	parent := t.iter.SpanID
+	if p, ok := t.tools[parentID]; ok {
+		parent = p.SpanID
+	}
+	s := t.newSpan("tool "+name, parent)
+	s.Attributes = append(s.Attributes, stringAttr("tool.name", name))
+	if target != "" {
+		s.Attributes = append(s.Attributes, stringAttr("tool.target", target))
+	}
+	if id == "" {
+		t.finish(s, "")
+		return
+	}
+	s.Attributes = append(s.Attributes, stringAttr("tool.use_id", id))
+	t.tools[id] = s
+}
+
+// ToolResult ends the span of tool call id, marking it failed when isError.
+func (t *Tracer) ToolResult(id string, isError bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.tools[id]
+	if !ok {
+		return
+	}
+	delete(t.tools, id)
+	outcome := "success"
+	if isError {
+		outcome = "error"
+	}
+	t.finish(s, outcome)
+}
+
+// SetOutcome records how the current iteration ended, e.g. "success" or
+// "error". An "error" outcome marks the span as failed.
+func (t *Tracer) SetOutcome(outcome string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.outcome = outcome
+	t.mu.Unlock()
+}
+
+// Tokens is an iteration's token usage.
+type Tokens struct {
+	Input, Output, CacheCreation, CacheRead int64
+}
+
+// EndIteration closes the current iteration's span with its cost in USD and
+// token usage, and queues it for export with its tool calls' spans.
+func (t *Tracer) EndIteration(costUSD float64, tokens Tokens) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.endIteration(costUSD, tokens)
+}
+
+func (t *Tracer) endIteration(costUSD float64, tokens Tokens) {
+	if t.iter == nil {
+		return
+	}
+	// Tool calls still without a result end with the iteration
+	for _, tool := range t.tools {
+		t.finish(tool, "")
+	}
+	t.tools = nil
+	s := t.iter
+	t.iter = nil
+	t.runCost += costUSD
+	outcome := t.outcome
+	if outcome == "" {
+		outcome = "unknown"
+	}
+	s.Attributes = append(s.Attributes,
+		doubleAttr("ralph.cost_usd", costUSD),
+		intAttr("ralph.tokens.input", tokens.Input),
+		intAttr("ralph.tokens.output", tokens.Output),
+		intAttr("ralph.tokens.cache_creation", tokens.CacheCreation),
+		intAttr("ralph.tokens.cache_read", tokens.CacheRead),
+		intAttr("ralph.tool_uses", int64(t.toolUses)),
+		stringAttr("ralph.outcome", outcome),
+	)
+	t.finish(s, outcome)
+	t.flush()
+}
+
+// Shutdown ends any open iteration and the run span with the run's outcome,
+// then waits until queued spans are sent or ctx is done. It returns the
+// first export error, and reports tool calls dropped while the export queue
+// was full.
+func (t *Tracer) Shutdown(ctx context.Context, outcome string) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.endIteration(0, Tokens{})
+	t.run.Attributes = append(t.run.Attributes,
+		intAttr("ralph.iterations", int64(t.iters)),
+		doubleAttr("ralph.cost_usd", t.runCost),
+		stringAttr("ralph.outcome", outcome),
+	)
+	t.finish(t.run, outcome)
+	last, encErr := t.encode(t.batch)
+	t.batch = nil
+	t.closed = true
+	t.mu.Unlock()
+
+	// The last batch, with the run span, waits for room in the queue
+	var final []poster.Request
+	if encErr == nil {
+		final = append(final, last)
+	}
+	err := t.poster.Close(ctx, final...)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	err = errors.Join(t.err, encErr, err)
+	if t.dropped > 0 {
+		return errors.Join(err, fmt.Errorf("dropped %d tool call spans while the collector fell behind", t.dropped))
+	}
+	return err
+}
+
+// finish stamps the end time and status on s and adds it to the batch sent
+// when the iteration ends. t.mu must be held.
+func (t *Tracer) finish(s *span, outcome string) {
+	if t.closed {
+		return
+	}
+	s.EndTimeUnixNano = nanos(time.Now())
+	switch outcome {
+	case "error":
+		s.Status = status{Code: statusError, Message: outcome}
+	case "", "unknown":
+		s.Status = status{Code: statusUnset}
+	default:
+		s.Status = status{Code: statusOK}
+	}
+	t.batch = append(t.batch, *s)
+}
+
+// flush queues the batched spans for export as one request. While the queue
+// is full they stay batched, to go with the next iteration's. t.mu must be
+// held.
+func (t *Tracer) flush() {
+	if t.closed || len(t.batch) == 0 {
+		return
+	}
+	r, err := t.encode(t.batch)
+	if err != nil {
+		if t.err == nil {
+			t.err = err
+		}
+		t.batch = nil
+		return
+	}
+	if t.poster.Queue(r) {
+		t.batch = nil
+	}
+}
+
+func (t *Tracer) newSpan(name, parent string) *span {
+	return &span{
+		TraceID:           t.traceID,
+		SpanID:            newID(8),
+		ParentSpanID:      parent,
+		Name:              name,
+		Kind:              1, // SPAN_KIND_INTERNAL
+		StartTimeUnixNano: nanos(time.Now()),
+	}
+}
+
+// encode returns the export request for spans.
+func (t *Tracer) encode(spans []span) (poster.Request, error) {
+	body, err := json.Marshal(exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: t.resource},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: ServiceName}, Spans: spans}},
+	}}})
+	if err != nil {
+		return poster.Request{}, fmt.Errorf("encoding spans: %w", err)
+	}
+	return poster.Request{What: "spans", Body: body}, nil
+}
+
+// newID returns n random bytes hex-encoded, as OTLP JSON encodes trace and
+// span IDs.
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func nanos(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// OTLP/HTTP JSON encoding of ExportTraceServiceRequest.
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type span struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            status     `json:"status"`
+}
+
+type status struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+// anyValue holds exactly one of its fields; 64-bit integers are strings in
+// OTLP JSON.
+type anyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func stringAttr(key, v string) keyValue {
+	return keyValue{Key: key, Value: anyValue{StringValue: &v}}
+}
+
+func intAttr(key string, v int64) keyValue {
+	s := strconv.FormatInt(v, 10)
+	return keyValue{Key: key, Value: anyValue{IntValue: &s}}
+}
+
+func doubleAttr(key string, v float64) keyValue {
+	return keyValue{Key: key, Value: anyValue{DoubleValue: &v}}
+}
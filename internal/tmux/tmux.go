@@ -4,7 +4,24 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 	"syscall"
+	"unicode/utf8"
+)
+
+// StatusRightLength is the status-right length ralph sets while it owns the
+// status bar; FormatStatusRight keeps its output within it.
+const StatusRightLength = 200
+
+// Per-field caps, in characters, for FormatStatusRight. With the separators
+// they add up to less than StatusRightLength.
+const (
+	maxRepoField   = 32
+	maxBranchField = 40
+	maxLoopField   = 60
+	maxTasksField  = 16
+	maxTimeField   = 16
 )
 
 // StatusBar manages the tmux status-right bar for ralph.
@@ -25,7 +42,7 @@ func NewStatusBar() *StatusBar {
 	if path := FindBinary(); path != "" {
 		exec.Command(path, "set-option", "status-left", "").Run()
 		exec.Command(path, "set-option", "status-left-length", "0").Run()
-		exec.Command(path, "set-option", "status-right-length", "200").Run()
+		exec.Command(path, "set-option", "status-right-length", strconv.Itoa(StatusRightLength)).Run()
 	}
 	return sb
 }
@@ -62,10 +79,74 @@ func (s *StatusBar) Restore() {
 	exec.Command(path, "set-option", "-u", "status-left-length").Run()
 }
 
-// FormatStatusRight builds the tmux status bar content string.
-func FormatStatusRight(repo, branch, loopDisplay, timeDisplay string) string {
-	return fmt.Sprintf("[%s | %s | loop: %s, uptime: %s]",
-		repo, branch, loopDisplay, timeDisplay)
+// FormatStatusRight builds the tmux status bar content string. Each field is
+// truncated on its own, so a long branch name can't push the loop and uptime
+// past StatusRightLength. The tasks field is left out when tasksDisplay is
+// empty, e.g. when there is no plan file.
+func FormatStatusRight(repo, branch, loopDisplay, tasksDisplay, timeDisplay string) string {
+	tasks := ""
+	if tasksDisplay != "" {
+		tasks = ", tasks: " + truncateField(tasksDisplay, maxTasksField)
+	}
+	return fmt.Sprintf("[%s | %s | loop: %s%s, uptime: %s]",
+		truncateField(repo, maxRepoField),
+		truncateField(branch, maxBranchField),
+		truncateField(loopDisplay, maxLoopField),
+		tasks,
+		truncateField(timeDisplay, maxTimeField))
+}
+
+// FormatTasks formats plan progress for FormatStatusRight, e.g. "8/14 (57%)".
+// It returns "" when there are no tasks.
+func FormatTasks(completed, total int) string {
+	if total <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d (%d%%)", completed, total, completed*100/total)
+}
+
+// truncateField shortens s to at most max characters, ending in "…". It cuts
+// at the last space, '/' or '-' when that keeps at least half of the room, so
+// words aren't split where it can be helped.
+func truncateField(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	if max <= 1 {
+		return string(runes[:max])
+	}
+	cut := max - 1
+	prefix := string(runes[:cut+1])
+	if i := strings.LastIndexAny(prefix, " /-"); i >= 0 {
+		if at := utf8.RuneCountInString(prefix[:i]); at >= cut/2 {
+			cut = at
+		}
+	}
+	return strings.TrimRight(string(runes[:cut]), " ") + "…"
+}
+
+// SessionEnv names the environment variable Wrap sets to the session it
+// created, so the wrapped process knows it owns that session.
+const SessionEnv = "RALPH_TMUX_SESSION"
+
+// WrappedSession returns the tmux session ralph created for itself with Wrap,
+// or "" when running in a session the user started (or outside tmux).
+func WrappedSession() string {
+	if !IsInsideTmux() {
+		return ""
+	}
+	return os.Getenv(SessionEnv)
+}
+
+// KillSession kills the named tmux session. Killing the session ralph runs in
+// ends ralph too, so call it last.
+func KillSession(name string) error {
+	path := FindBinary()
+	if path == "" {
+		return fmt.Errorf("tmux not found in PATH")
+	}
+	return exec.Command(path, "kill-session", "-t", name).Run()
 }
 
 // IsInsideTmux returns true if the current process is running inside a tmux session.
@@ -96,9 +177,21 @@ func ShouldWrap(noTmux bool) bool {
 	return true
 }
 
-// pickSessionName returns a unique tmux session name starting with "ralph".
-func pickSessionName(tmuxPath string) string {
-	base := "ralph"
+// SessionName returns the tmux session name for a run: "ralph" suffixed with
+// the first 8 characters of runID, or plain "ralph" without one.
+func SessionName(runID string) string {
+	if runID == "" {
+		return "ralph"
+	}
+	if len(runID) > 8 {
+		runID = runID[:8]
+	}
+	return "ralph-" + runID
+}
+
+// pickSessionName returns a unique tmux session name starting with SessionName(runID).
+func pickSessionName(tmuxPath, runID string) string {
+	base := SessionName(runID)
 	// Check if session already exists
 	if err := exec.Command(tmuxPath, "has-session", "-t", base).Run(); err != nil {
 		// Session doesn't exist, use the base name
@@ -114,12 +207,27 @@ func pickSessionName(tmuxPath string) string {
 	return fmt.Sprintf("%s-%d", base, os.Getpid())
 }
 
+// NewWindow opens a window named name in the current tmux session, running
+// argv in dir. The window closes when the command exits.
+func NewWindow(name, dir string, argv []string) error {
+	tmuxPath := FindBinary()
+	if tmuxPath == "" {
+		return fmt.Errorf("tmux not found in PATH")
+	}
+	args := append([]string{"new-window", "-n", name, "-c", dir, "--"}, argv...)
+	if out, err := exec.Command(tmuxPath, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("tmux new-window: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
 // Wrap re-execs the current process inside a new tmux session.
 // It replaces the current process via syscall.Exec, so this function
 // does not return on success.
 // subcommand is prepended to the args if non-empty (to restore a subcommand
-// that was stripped from os.Args during flag parsing).
-func Wrap(subcommand string) error {
+// that was stripped from os.Args during flag parsing). runID names the session
+// and is passed on with --run-id so the wrapped process keeps the same ID.
+func Wrap(subcommand, runID string) error {
 	tmuxPath := FindBinary()
 	if tmuxPath == "" {
 		return fmt.Errorf("tmux not found in PATH")
@@ -130,7 +238,7 @@ func Wrap(subcommand string) error {
 		return fmt.Errorf("cannot determine executable path: %w", err)
 	}
 
-	sessionName := pickSessionName(tmuxPath)
+	sessionName := pickSessionName(tmuxPath, runID)
 
 	// Reconstruct args with --no-tmux added to prevent recursive wrapping.
 	// Prepend the subcommand if one was stripped from os.Args by DetectSubcommand().
@@ -139,10 +247,15 @@ func Wrap(subcommand string) error {
 		ralphArgs = append(ralphArgs, subcommand)
 	}
 	ralphArgs = append(ralphArgs, os.Args[1:]...)
+	if runID != "" {
+		ralphArgs = append(ralphArgs, "--run-id", runID)
+	}
 	ralphArgs = append(ralphArgs, "--no-tmux")
 
-	// Build: tmux new-session -s <name> -- <ralph> [args...]
-	args := []string{"tmux", "new-session", "-s", sessionName, "--"}
+	// Build: tmux new-session -s <name> -- env RALPH_TMUX_SESSION=<name> <ralph> [args...]
+	// The variable goes through env(1) because an already-running tmux server
+	// would not pass it on from this client's environment.
+	args := []string{"tmux", "new-session", "-s", sessionName, "--", "env", SessionEnv + "=" + sessionName}
 	args = append(args, ralphBin)
 	args = append(args, ralphArgs...)
 
@@ -0,0 +1,146 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/cloudosai/ralph-go/internal/specs"
+)
+
+// SpecPicker lists the spec files in a spec folder, with their sizes and
+// modification dates, and lets the user choose which ones a run covers.
+// Every file starts chosen. Up/down (or k/j) move, space toggles a file, a
+// toggles them all, enter confirms and q or esc cancels.
+type SpecPicker struct {
+	folder    string
+	files     []specs.File
+	chosen    []bool
+	cursor    int
+	confirmed bool
+	notice    string // shown under the list, e.g. when enter is pressed with nothing chosen
+}
+
+// NewSpecPicker returns a picker over files, the spec files in folder.
+func NewSpecPicker(folder string, files []specs.File) SpecPicker {
+	chosen := make([]bool, len(files))
+	for i := range chosen {
+		chosen[i] = true
+	}
+	return SpecPicker{folder: folder, files: files, chosen: chosen}
+}
+
+// Init implements tea.Model.
+func (p SpecPicker) Init() tea.Cmd {
+	return nil
+}
+
+// Update implements tea.Model.
+func (p SpecPicker) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	key, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return p, nil
+	}
+	p.notice = ""
+	switch key.String() {
+	case "up", "k":
+		if p.cursor > 0 {
+			p.cursor--
+		}
+	case "down", "j":
+		if p.cursor < len(p.files)-1 {
+			p.cursor++
+		}
+	case " ", "x":
+		if len(p.chosen) > 0 {
+			p.chosen[p.cursor] = !p.chosen[p.cursor]
+		}
+	case "a":
+		all := true
+		for _, c := range p.chosen {
+			all = all && c
+		}
+		for i := range p.chosen {
+			p.chosen[i] = !all
+		}
+	case "enter":
+		if len(p.Chosen()) == 0 {
+			p.notice = "Choose at least one spec, or press q to cancel"
+			return p, nil
+		}
+		p.confirmed = true
+		return p, tea.Quit
+	case "q", "esc", "ctrl+c":
+		return p, tea.Quit
+	}
+	return p, nil
+}
+
+// View implements tea.Model.
+func (p SpecPicker) View() string {
+	if p.confirmed {
+		return ""
+	}
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(colorBlue)
+	cursorStyle := lipgloss.NewStyle().Bold(true).Foreground(colorPurple)
+	detailStyle := lipgloss.NewStyle().Foreground(colorDimGray)
+	helpStyle := lipgloss.NewStyle().Foreground(colorDimGray)
+
+	nameWidth := 0
+	for _, f := range p.files {
+		nameWidth = max(nameWidth, len(f.Name))
+	}
+
+	var b strings.Builder
+	b.WriteString(titleStyle.Render(fmt.Sprintf("Choose the specs in %s for this run", p.folder)))
+	b.WriteString("\n\n")
+	for i, f := range p.files {
+		box := "[ ]"
+		if p.chosen[i] {
+			box = "[x]"
+		}
+		line := fmt.Sprintf("%s %-*s", box, nameWidth, f.Name)
+		if i == p.cursor {
+			line = cursorStyle.Render("> " + line)
+		} else {
+			line = "  " + line
+		}
+		detail := fmt.Sprintf("  %9s  %s", specs.FormatSize(f.Size), f.ModTime.Format("2006-01-02 15:04"))
+		b.WriteString(line + detailStyle.Render(detail) + "\n")
+	}
+	if p.notice != "" {
+		b.WriteString("\n" + lipgloss.NewStyle().Foreground(colorOrange).Render(p.notice) + "\n")
+	}
+	b.WriteString("\n" + helpStyle.Render("↑/↓ move • space toggle • a all/none • enter start • q cancel") + "\n")
+	return b.String()
+}
+
+// Chosen returns the chosen files, in list order.
+func (p SpecPicker) Chosen() []specs.File {
+	var chosen []specs.File
+	for i, f := range p.files {
+		if p.chosen[i] {
+			chosen = append(chosen, f)
+		}
+	}
+	return chosen
+}
+
+// Confirmed reports whether the user confirmed the choice rather than
+// cancelling.
+func (p SpecPicker) Confirmed() bool {
+	return p.confirmed
+}
+
+// PickSpecs shows a SpecPicker over files, the spec files in folder, inline
+// in the terminal and returns the files chosen. ok is false when the user
+// cancelled.
+func PickSpecs(folder string, files []specs.File) (chosen []specs.File, ok bool, err error) {
+	final, err := tea.NewProgram(NewSpecPicker(folder, files)).Run()
+	if err != nil {
+		return nil, false, err
+	}
+	p := final.(SpecPicker)
+	return p.Chosen(), p.Confirmed(), nil
+}
@@ -2,8 +2,10 @@ package tui
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
@@ -58,6 +60,7 @@ var (
 	colorLightGray = lipgloss.Color("#C0CAF5")
 colorRed       = lipgloss.Color("#F7768E")
 	colorOrange    = lipgloss.Color("#FF9E64")
+	colorYellow    = lipgloss.Color("#E0AF68")
 )
 
 // MessageRole represents the type of message sender
@@ -70,8 +73,11 @@ const (
 	RoleSystem      MessageRole = "system"
 	RoleLoop        MessageRole = "loop"
 	RoleLoopStopped MessageRole = "loop_stopped"
+	RoleLoopDone    MessageRole = "loop_done" // an iteration's elapsed time and cost (--loop-summary)
 	RoleHibernate   MessageRole = "hibernate"
+	RolePaced       MessageRole = "paced" // waiting out a self-imposed spending limit
 	RoleThinking    MessageRole = "thinking"
+	RoleQuestion    MessageRole = "question" // the agent ended its turn asking for input
 )
 
 // Message represents a single activity message in the feed.
@@ -86,6 +92,16 @@ type Message struct {
 	Status    string        // ACP tool status: in_progress/completed/failed/pending
 	StartedAt time.Time     // when an in_progress tool row was added (TUI clock)
 	Elapsed   time.Duration // wall-clock duration once the tool completed/failed
+	Depth     int           // subagent nesting depth (0 = main agent); rendered as indentation
+}
+
+// maxIndentDepth caps subagent indentation so deeply nested messages keep
+// enough of the pane width to stay readable.
+const maxIndentDepth = 4
+
+// depthIndent returns the leading indentation for a message at depth.
+func depthIndent(depth int) string {
+	return strings.Repeat("  ", min(max(depth, 0), maxIndentDepth))
 }
 
 // PlanItem mirrors parser.PlanItem with plain-string status so the tui package
@@ -96,6 +112,18 @@ type PlanItem struct {
 	Status  string // "pending" | "in_progress" | "completed"
 }
 
+// SubagentItem mirrors parser.Subagent for the subagent panel, with its cost
+// worked out, keeping the tui package free of a parser import like PlanItem.
+type SubagentItem struct {
+	Label     string  // description, or subagent type
+	Depth     int     // nesting depth: 1 for one the main agent spawned
+	Status    string  // "running" | "completed" | "failed"
+	Tokens    int64   // all tokens its messages used
+	CostUSD   float64 // its share of the iteration cost
+	Estimated bool    // CostUSD is estimated from Tokens rather than reported
+	ToolCalls int
+}
+
 // spinnerFrames animates in_progress tool rows, advanced once per tick.
 var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
 
@@ -111,6 +139,22 @@ func formatToolDuration(d time.Duration) string {
 	return fmt.Sprintf("%dm%ds", int(d.Minutes()), int(d.Seconds())%60)
 }
 
+// formatResumeIn renders a coarse countdown for the status banner, rounded
+// up so it never reads "0m" while time remains, e.g. "12m", "1h5m", "40s".
+func formatResumeIn(d time.Duration) string {
+	if d <= 0 {
+		return "0s"
+	}
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int(d.Round(time.Second).Seconds()))
+	}
+	mins := int((d + time.Minute - 1) / time.Minute)
+	if mins < 60 {
+		return fmt.Sprintf("%dm", mins)
+	}
+	return fmt.Sprintf("%dh%dm", mins/60, mins%60)
+}
+
 // toolStatusGlyph returns the leading lifecycle glyph for a tool row.
 func toolStatusGlyph(status string) string {
 	switch status {
@@ -169,10 +213,16 @@ func (m Message) GetIcon() string {
 		return "🚀"
 	case RoleLoopStopped:
 		return "🛑"
+	case RoleLoopDone:
+		return "🏁"
 	case RoleHibernate:
 		return "💤"
+	case RolePaced:
+		return "⏳"
 	case RoleThinking:
 		return "💭"
+	case RoleQuestion:
+		return "❓"
 	default:
 		return "📝"
 	}
@@ -202,10 +252,16 @@ func (m Message) GetStyle() lipgloss.Style {
 		return lipgloss.NewStyle().Bold(true).Foreground(colorPurple)
 	case RoleLoopStopped:
 		return lipgloss.NewStyle().Bold(true).Foreground(colorRed)
+	case RoleLoopDone:
+		return lipgloss.NewStyle().Foreground(colorPurple)
 	case RoleHibernate:
 		return lipgloss.NewStyle().Bold(true).Foreground(colorOrange)
+	case RolePaced:
+		return lipgloss.NewStyle().Bold(true).Foreground(colorYellow)
 	case RoleThinking:
 		return lipgloss.NewStyle().Italic(true).Foreground(colorDimGray)
+	case RoleQuestion:
+		return lipgloss.NewStyle().Bold(true).Foreground(colorOrange)
 	default:
 		return lipgloss.NewStyle().Foreground(colorDimGray)
 	}
@@ -227,14 +283,20 @@ type Model struct {
 	currentLoop    int
 	totalLoops     int
 	currentTask    string // Current task (e.g., "#6 Change the lib/gold into lib/silver")
+	taskExplicit   bool   // currentTask came from a task reference or the plan this iteration
 	completedTasks int    // Number of completed tasks from plan
 	totalTasks     int    // Total number of tasks from plan
+	planTracked    bool   // the task counts and current task follow the plan file's tasks (see SendPlanFileUpdate)
 	plan           []PlanItem // Agent's TodoWrite-authored plan (ACP plan panel)
+	subagents      []SubagentItem // the iteration's subagents as a tree, depth-first (subagent panel)
+	subagentsFolded bool          // show only the subagents the main agent spawned ('a' toggles)
 	currentMode    string // Current mode display ("Planning", "Building", or "")
 	startTime      time.Time
 	baseElapsed    time.Duration // elapsed time from previous sessions
 	timerPaused    bool          // whether elapsed time tracking is paused
 	pausedElapsed  time.Duration // elapsed time when paused (for display)
+	softPausing    bool          // 'p' asked the loop to pause after the current iteration; timers freeze when it does
+	draining       bool          // 'q' or SIGTERM asked the loop to finish the current iteration; the TUI quits when it has
 	// Per-loop tracking for tmux status bar (spec: stats should be about current loop)
 	loopTotalTokens   int64         // tokens accumulated in the current loop iteration
 	loopStartTime     time.Time     // when the current loop iteration started
@@ -245,14 +307,89 @@ type Model struct {
 	toolViewport      viewport.Model // right pane (1:1): tool-use rows + plan panel
 	activityHeight    int
 	footerHeight      int
+	footerOnTop       bool // render the footer above the activity panel (--tui-layout top)
+	maxContentWidth   int  // cap on the activity panel width, centered in wider terminals (0 = full width)
+	compactFeed       bool // drop blank lines between feed messages (--compact-feed)
+	stripANSI         bool // remove ANSI escape sequences from incoming content (--strip-ansi)
+	resultsCollapsed  bool // fold runs of tool results in the thinking pane ('c' toggles)
+	detailOpen        bool           // show the detail pane below the panes ('d' toggles)
+	detailViewport    viewport.Model // bottom pane: the latest tool result, scrolled independently
+	detailTitle       string         // heading of the detail pane content
+	detailContent     string         // latest tool result (or plan under review), capped at maxDetailBytes
+	iterationsOpen    bool                  // the detail pane shows the per-iteration table ('i' toggles)
+	iterations        *stats.IterationStats // tokens, cost, duration and tool calls per loop iteration
+	closeAfter        time.Duration // quit this long after completion (0 = stay open)
+	closeGen          int           // invalidates pending close timers when a new one is scheduled
+	autoClosed        bool          // the TUI quit on its own via closeAfter
+	wrappedSession    string        // tmux session ralph created for itself ("" = none)
 	msgChan           <-chan Message
 	doneChan          <-chan struct{}
 	loop              *loop.Loop
 	tmuxBar           tmuxBarUpdater
 	hibernating       bool      // whether loop is hibernating due to rate limit
 	hibernateUntil    time.Time // when rate limit resets
+	paced             bool      // the hibernate is cost pacing, not a rate limit
+	pacedReason       string    // why the loop is paced, e.g. "cost limit"
+	scheduled         bool      // the hibernate is the wait for a scheduled start (--start-delay/--start-at)
+	contextWarning    string    // context-window warning for the current iteration ("" = none)
 	repoName          string    // git repo name for tmux status bar
 	branchName        string    // git branch name for tmux status bar
+	arrivals          arrivalRing // recent message arrival times, for the activity rate
+	msgRate           int         // messages in the last minute, recomputed on tick (-1 = not yet measured)
+	costWarn          float64     // run cost that turns the total orange and flashes a notification (0 = off)
+	costCrit          float64     // run cost that turns the total red and flashes a notification (0 = off)
+	costLevel         int         // highest cost threshold crossed so far: 0 none, 1 warn, 2 crit
+	notifications     []notification // transient lines flashed at the top of the layout
+}
+
+// notificationTTL is how long a notification stays at the top of the layout.
+const notificationTTL = 5 * time.Second
+
+// notification is a transient line shown at the top of the layout until it
+// expires.
+type notification struct {
+	text  string
+	color lipgloss.Color
+	until time.Time
+}
+
+// maxDetailBytes caps how much of the latest tool result is kept for the
+// detail pane.
+const maxDetailBytes = 64 << 10
+
+// minDetailActivityHeight is the smallest activity area that still fits the
+// detail pane below the thinking and tool panes.
+const minDetailActivityHeight = 10
+
+// arrivalRingSize bounds how many message arrivals are remembered for the
+// activity rate; a busier minute than this reads as the cap.
+const arrivalRingSize = 512
+
+// arrivalRing is a fixed-size ring buffer of message arrival times.
+type arrivalRing struct {
+	times []time.Time
+	next  int // index overwritten by the next add once the ring is full
+}
+
+// add records an arrival, overwriting the oldest once the ring is full.
+func (r *arrivalRing) add(t time.Time) {
+	if len(r.times) < arrivalRingSize {
+		r.times = append(r.times, t)
+		return
+	}
+	r.times[r.next] = t
+	r.next = (r.next + 1) % arrivalRingSize
+}
+
+// countSince returns how many recorded arrivals are at or after cutoff.
+func (r arrivalRing) countSince(cutoff time.Time) int {
+	n := 0
+	for _, t := range r.times {
+		if !t.Before(cutoff) {
+			n++
+		}
+	}
+	return n
 }
 
 // NewModel creates and returns a new initialized Model
@@ -266,12 +403,14 @@ func NewModel() Model {
 		messages:       []Message{},
 		maxMessages:    100000,
 		stats:          stats.NewTokenStats(),
+		iterations:     stats.NewIterationStats(),
 		currentLoop:    0,
 		totalLoops:     0,
 		startTime:      now,
 		loopStartTime:  now,
 		activityHeight: 0,
 		footerHeight:   11,
+		msgRate:        -1,
 	}
 }
 
@@ -315,12 +454,152 @@ func (m *Model) SetGitContext(repo, branch string) {
 	m.branchName = branch
 }
 
+// SetFooterOnTop controls whether the footer renders above the activity panel
+// instead of below it. Heights are unaffected; only the join order changes.
+func (m *Model) SetFooterOnTop(top bool) {
+	m.footerOnTop = top
+}
+
+// SetMaxContentWidth caps the activity panel at n columns, centered in wider
+// terminals. The footer still spans the full width. Zero means no cap.
+func (m *Model) SetMaxContentWidth(n int) {
+	m.maxContentWidth = n
+	if m.viewportReady {
+		m.resizePanes()
+	}
+}
+
+// SetCompactFeed drops the blank line between feed messages. The thinking
+// pane instead draws a dim divider where the speaker's role changes.
+func (m *Model) SetCompactFeed(compact bool) {
+	m.compactFeed = compact
+	m.refreshPanes(true, true)
+}
+
+// SetStripANSI removes ANSI escape sequences (colors from the tools the agent
+// runs) from feed messages and the detail pane as they arrive, so they cannot
+// corrupt the layout. Messages already in the feed are left as they are.
+func (m *Model) SetStripANSI(strip bool) {
+	m.stripANSI = strip
+}
+
+// contentWidth returns the width of the activity panel: the terminal width,
+// capped at maxContentWidth when that is set.
+func (m Model) contentWidth() int {
+	if m.maxContentWidth > 0 && m.maxContentWidth < m.width {
+		return m.maxContentWidth
+	}
+	return m.width
+}
+
+// SetCloseAfter makes the TUI quit on its own d after the run completes.
+// Zero (the default) keeps it open so more loops can be added.
+func (m *Model) SetCloseAfter(d time.Duration) {
+	m.closeAfter = d
+}
+
+// SetScheduled shows the run as scheduled to start at until, counting down in
+// the banner, footer and tmux status bar until the first iteration begins.
+// A time that has already passed is ignored.
+func (m *Model) SetScheduled(until time.Time) {
+	if !until.After(timeNow()) {
+		return
+	}
+	m.scheduled = true
+	m.hibernating = true
+	m.hibernateUntil = until
+}
+
+// SetCostThresholds sets the run costs (USD) at which the total cost turns
+// orange (warn) and red (crit), each flashing a notification when crossed.
+// Zero disables a threshold.
+func (m *Model) SetCostThresholds(warn, crit float64) {
+	m.costWarn = warn
+	m.costCrit = crit
+}
+
+// notify flashes text at the top of the layout for notificationTTL.
+func (m *Model) notify(text string, color lipgloss.Color) {
+	m.notifications = append(m.notifications, notification{text: text, color: color, until: timeNow().Add(notificationTTL)})
+}
+
+// checkCostThresholds notifies once when the run cost first crosses the warn
+// or crit threshold, and drops expired notifications.
+func (m *Model) checkCostThresholds() {
+	now := timeNow()
+	active := m.notifications[:0]
+	for _, n := range m.notifications {
+		if now.Before(n.until) {
+			active = append(active, n)
+		}
+	}
+	m.notifications = active
+
+	if m.stats == nil {
+		return
+	}
+	cost := m.stats.Snapshot().TotalCostUSD
+	switch level := m.costLevelFor(cost); {
+	case level <= m.costLevel:
+	case level == 2:
+		m.costLevel = level
+		m.notify("⚠ cost passed "+stats.FormatCost(m.costCrit), colorRed)
+	default:
+		m.costLevel = level
+		m.notify("⚠ cost passed "+stats.FormatCost(m.costWarn), colorOrange)
+	}
+}
+
+// costLevelFor returns which cost threshold cost has reached: 0 none, 1 warn,
+// 2 crit.
+func (m Model) costLevelFor(cost float64) int {
+	switch {
+	case m.costCrit > 0 && cost >= m.costCrit:
+		return 2
+	case m.costWarn > 0 && cost >= m.costWarn:
+		return 1
+	}
+	return 0
+}
+
+// SetWrappedSession records the tmux session ralph wrapped itself in. On
+// completion without closeAfter, the feed explains how to continue or exit so
+// the session isn't left running unnoticed.
+func (m *Model) SetWrappedSession(name string) {
+	m.wrappedSession = name
+}
+
+// AutoClosed returns true if the TUI quit on its own after completion
+// (closeAfter) rather than at the user's request.
+func (m Model) AutoClosed() bool {
+	return m.autoClosed
+}
+
 // SetCompletedTasks sets the completed/total task counts from the implementation plan
 func (m *Model) SetCompletedTasks(completed, total int) {
 	m.completedTasks = completed
 	m.totalTasks = total
 }
 
+// SetPlanFile sets the initial task counts and current task from the plan
+// file, as SendPlanFileUpdate does during the run.
+func (m *Model) SetPlanFile(completed, total int, current string) {
+	m.applyPlanFile(completed, total, current)
+}
+
+// applyPlanFile has the task counts and current task follow the plan file,
+// or, when it has no tasks, go back to following the agent.
+func (m *Model) applyPlanFile(completed, total int, current string) {
+	m.planTracked = total > 0
+	if !m.planTracked {
+		return
+	}
+	m.completedTasks = completed
+	m.totalTasks = total
+	m.currentTask = current
+	m.taskExplicit = true
+}
+
 // SetCurrentMode sets the current mode display ("Planning", "Building", or "")
 func (m *Model) SetCurrentMode(mode string) {
 	m.currentMode = mode
@@ -347,8 +626,43 @@ func (m Model) getLoopElapsed() time.Duration {
 	return m.loopBaseElapsed + timeNow().Sub(m.loopStartTime)
 }
 
+// iterationUnderWay reports whether the loop is running an iteration, rather
+// than being paused, hibernating or done, so quitting would interrupt it.
+func (m Model) iterationUnderWay() bool {
+	return m.loop != nil && !m.completed && m.loop.IsRunning() && !m.loop.IsPaused() &&
+		!m.loop.IsHibernating() && !m.loop.IsCompletedWaiting()
+}
+
+// startDrain asks the loop to finish the current iteration and end the run;
+// the TUI quits once it has (see doneMsg).
+func (m *Model) startDrain() {
+	m.draining = true
+	m.loop.Drain()
+	m.AddMessage(Message{
+		Role:    RoleSystem,
+		Content: "Finishing the current iteration, then quitting. Press q again to quit now.",
+	})
+	m.refreshPanes(true, true)
+}
+
+// freezeTimers stops both the total and per-loop elapsed time, e.g. on pause.
+func (m *Model) freezeTimers() {
+	if !m.timerPaused {
+		m.pausedElapsed = m.baseElapsed + timeNow().Sub(m.startTime)
+		m.timerPaused = true
+	}
+	if !m.loopTimerPaused {
+		m.loopPausedElapsed = m.loopBaseElapsed + timeNow().Sub(m.loopStartTime)
+		m.loopTimerPaused = true
+	}
+}
+
 // AddMessage adds a message to the activity feed
 func (m *Model) AddMessage(msg Message) {
+	m.arrivals.add(timeNow())
+	if m.stripANSI {
+		msg.Content = stripANSI(msg.Content)
+	}
 	if msg.Role == RoleTool && msg.Status == "in_progress" {
 		if msg.StartedAt.IsZero() {
 			msg.StartedAt = timeNow()
@@ -387,6 +701,12 @@ type taskUpdateMsg struct {
 	task string
 }
 
+// activityUpdateMsg is sent with a summary of the agent's current activity,
+// shown as the current task only when no explicit task is known
+type activityUpdateMsg struct {
+	activity string
+}
+
 // toolStatusUpdateMsg is sent to flip an existing tool row's lifecycle status
 // (e.g. in_progress → completed/failed) by matching its tool_use ID.
 type toolStatusUpdateMsg struct {
@@ -394,6 +714,19 @@ type toolStatusUpdateMsg struct {
 	status    string
 }
 
+// toolResultMsg carries the full content of the latest tool result, for the
+// detail pane
+type toolResultMsg struct {
+	toolUseID string
+	content   string
+}
+
+// detailMsg opens the detail pane on arbitrary content (e.g. the plan under review)
+type detailMsg struct {
+	title   string
+	content string
+}
+
 // modeUpdateMsg is sent to update the current mode display
 type modeUpdateMsg struct {
 	mode string
@@ -404,15 +737,33 @@ type planUpdateMsg struct {
 	items []PlanItem
 }
 
+// subagentUpdateMsg replaces the subagent panel's list
+type subagentUpdateMsg struct {
+	items []SubagentItem
+}
+
 // completedTasksUpdateMsg is sent to update the completed/total task counts
 type completedTasksUpdateMsg struct {
 	completed int
 	total     int
 }
 
+// planFileUpdateMsg is sent when the plan file changes, with its task counts
+// and current task
+type planFileUpdateMsg struct {
+	completed int
+	total     int
+	current   string
+}
+
 // loopStartedMsg is sent when a new loop iteration begins (resets per-loop stats)
 type loopStartedMsg struct{}
 
+// iterationModelMsg is sent when the running iteration's model is known
+type iterationModelMsg struct {
+	model string
+}
+
 // loopStatsUpdateMsg is sent to update per-loop token count
 type loopStatsUpdateMsg struct {
 	totalTokens int64
@@ -421,16 +772,41 @@ type loopStatsUpdateMsg struct {
 // doneMsg is sent when processing is complete
 type doneMsg struct{}
 
+// closeAfterMsg fires --close-after once a completed run has sat idle; gen
+// matches the Model's closeGen when no newer completion has superseded it.
+type closeAfterMsg struct {
+	gen int
+}
+
 // hibernateMsg is sent when rate limit is detected
 type hibernateMsg struct {
 	until time.Time
 }
 
+// pacedMsg is sent when the loop waits for a spending window to clear
+type pacedMsg struct {
+	until  time.Time
+	reason string
+}
+
+// scheduledMsg is sent when the loop waits for its active hours to open
+type scheduledMsg struct {
+	until time.Time
+}
+
+// contextWarningMsg is sent when the agent reports its context window is nearly full
+type contextWarningMsg struct {
+	text string
+}
+
 // loopRefMsg is sent to update the loop reference (e.g., when transitioning between plan and build phases)
 type loopRefMsg struct {
 	loop *loop.Loop
 }
 
+// drainMsg asks the TUI to finish the current iteration and then quit, as 'q' does
+type drainMsg struct{}
+
 // Init initializes the model
 func (m Model) Init() tea.Cmd {
 	cmds := []tea.Cmd{tea.ClearScreen, tickCmd()}
@@ -482,6 +858,131 @@ func splitPaneWidths(width int) (left, right int) {
 	return left, right
 }
 
+// paneHeights splits the activity area between the thinking/tool panes and,
+// when it is shown, the detail pane. Heights are box heights inside the
+// rounded border; detail is 0 when the detail pane is hidden.
+func (m Model) paneHeights() (panes, detail int) {
+	if !m.detailOpen || m.activityHeight < minDetailActivityHeight {
+		return m.activityHeight, 0
+	}
+	panes = m.activityHeight / 2
+	// Both boxes share the area; the detail box's own border takes 2 rows
+	return panes, m.activityHeight - panes - 2
+}
+
+// resizePanes sizes the viewports to the current window and detail toggle.
+func (m *Model) resizePanes() {
+	// Split the activity area 2:1 — a wide "thinking" pane and a narrow
+	// "tool use" pane (see splitPaneWidths). The inner viewport width is the
+	// box width minus its rounded border (+2) and horizontal padding (+2).
+	leftStyleWidth, rightStyleWidth := splitPaneWidths(m.contentWidth())
+	panesHeight, detailHeight := m.paneHeights()
+	vpHeight := max(panesHeight-2, 1)
+	m.thinkingViewport.Width = max(leftStyleWidth-4, 1)
+	m.thinkingViewport.Height = vpHeight
+	m.toolViewport.Width = max(rightStyleWidth-4, 1)
+	m.toolViewport.Height = vpHeight
+	// The detail pane spans the full width below the split panes
+	m.detailViewport.Width = max(m.contentWidth()-6, 1)
+	m.detailViewport.Height = max(detailHeight-2, 1)
+}
+
+// renderDetailContent renders the detail pane content, wrapped to the pane
+// width.
+func (m Model) renderDetailContent() string {
+	if m.iterationsOpen {
+		return m.renderIterations()
+	}
+	if m.detailContent == "" {
+		return lipgloss.NewStyle().Foreground(colorDimGray).Render("No tool results yet")
+	}
+	header := lipgloss.NewStyle().Bold(true).Foreground(colorPurple).Render(m.detailTitle)
+	body := lipgloss.NewStyle().Width(m.detailViewport.Width).Render(m.detailContent)
+	return header + "\n" + body
+}
+
+// statsSnapshot returns the run totals, or zeros before stats are attached.
+func (m Model) statsSnapshot() stats.Snapshot {
+	if m.stats == nil {
+		return stats.Snapshot{}
+	}
+	return m.stats.Snapshot()
+}
+
+// renderIterations renders the per-iteration table for the detail pane: one
+// row per loop iteration, the running one measured live, and a total row.
+// Once an iteration's model is known, a Model column shows each one's.
+func (m Model) renderIterations() string {
+	header := lipgloss.NewStyle().Bold(true).Foreground(colorPurple).Render("Iterations")
+	records := m.iterations.Records(m.statsSnapshot(), timeNow())
+	if len(records) == 0 {
+		return header + "\n" + lipgloss.NewStyle().Foreground(colorDimGray).Render("No iterations yet")
+	}
+	const rowFormat = "%6s  %10s  %10s  %10s  %6s"
+	withModel := false
+	for _, r := range records {
+		withModel = withModel || r.Model != ""
+	}
+	row := func(iteration, tokens, cost, duration, tools, model string) string {
+		line := fmt.Sprintf(rowFormat, iteration, tokens, cost, duration, tools)
+		if withModel && model != "" {
+			line += "  " + model
+		}
+		return line
+	}
+	lines := []string{
+		header,
+		lipgloss.NewStyle().Foreground(colorDimGray).Render(row("#", "Tokens", "Cost", "Duration", "Tools", "Model")),
+	}
+	var total stats.IterationRecord
+	for _, r := range records {
+		duration := stats.FormatDuration(r.Duration)
+		if r.Running {
+			duration += "…"
+		}
+		lines = append(lines, row(fmt.Sprintf("%d", r.Iteration), stats.FormatTokens(r.Tokens), stats.FormatCost(r.CostUSD), duration, fmt.Sprintf("%d", r.ToolUses), r.Model))
+		total.Tokens += r.Tokens
+		total.CostUSD += r.CostUSD
+		total.Duration += r.Duration
+		total.ToolUses += r.ToolUses
+	}
+	lines = append(lines, lipgloss.NewStyle().Bold(true).Render(row("Total", stats.FormatTokens(total.Tokens), stats.FormatCost(total.CostUSD), stats.FormatDuration(total.Duration), fmt.Sprintf("%d", total.ToolUses), "")))
+	return strings.Join(lines, "\n")
+}
+
+// ansiPattern matches ANSI escape sequences: CSI (colors, cursor movement),
+// OSC (titles, hyperlinks) terminated by BEL or ST, and two-byte escapes.
+var ansiPattern = regexp.MustCompile(`\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[@-_])`)
+
+// stripANSI removes ANSI escape sequences from s.
+func stripANSI(s string) string {
+	if !strings.Contains(s, "\x1b") {
+		return s
+	}
+	return ansiPattern.ReplaceAllString(s, "")
+}
+
+// setDetail replaces the detail pane content, keeping at most maxDetailBytes,
+// and shows it from the top if the pane is open.
+func (m *Model) setDetail(title, content string) {
+	if m.stripANSI {
+		content = stripANSI(content)
+	}
+	if len(content) > maxDetailBytes {
+		cut := maxDetailBytes
+		for cut > 0 && !utf8.RuneStart(content[cut]) {
+			cut--
+		}
+		content = fmt.Sprintf("%s\n…(%d more bytes)", content[:cut], len(content)-cut)
+	}
+	m.detailTitle = title
+	m.detailContent = content
+	if m.detailOpen && m.viewportReady && !m.iterationsOpen {
+		m.detailViewport.SetContent(m.renderDetailContent())
+		m.detailViewport.GotoTop()
+	}
+}
+
 // refreshPanes re-renders both viewports' content. followThinking / followTool
 // control whether each pane snaps to its latest line afterward: the tool pane
 // auto-follows the latest activity, while the thinking pane only follows when a
@@ -493,6 +994,9 @@ func (m *Model) refreshPanes(followThinking, followTool bool) {
 	}
 	m.thinkingViewport.SetContent(m.renderThinkingContent())
 	m.toolViewport.SetContent(m.renderToolContent())
+	if m.detailOpen {
+		m.detailViewport.SetContent(m.renderDetailContent())
+	}
 	if followThinking {
 		m.thinkingViewport.GotoBottom()
 	}
@@ -518,25 +1022,16 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 		}
 
-		// Split the activity area 2:1 — a wide "thinking" pane and a narrow
-		// "tool use" pane (see splitPaneWidths). The inner viewport width is the
-		// box width minus its rounded border (+2) and horizontal padding (+2).
-		leftStyleWidth, rightStyleWidth := splitPaneWidths(m.width)
-		leftVpWidth := max(leftStyleWidth-4, 1)
-		rightVpWidth := max(rightStyleWidth-4, 1)
-		vpHeight := max(m.activityHeight-2, 1)
-
-		// Initialize or update both viewports
+		// Initialize or update the viewports
 		if !m.viewportReady {
-			m.thinkingViewport = viewport.New(leftVpWidth, vpHeight)
-			m.toolViewport = viewport.New(rightVpWidth, vpHeight)
+			m.thinkingViewport = viewport.New(1, 1)
+			m.toolViewport = viewport.New(1, 1)
+			m.detailViewport = viewport.New(1, 1)
 			m.viewportReady = true
+			m.resizePanes()
 			m.refreshPanes(true, true)
 		} else {
-			m.thinkingViewport.Width = leftVpWidth
-			m.thinkingViewport.Height = vpHeight
-			m.toolViewport.Width = rightVpWidth
-			m.toolViewport.Height = vpHeight
+			m.resizePanes()
 			// Re-wrap content to the new widths; keep the thinking pane's scroll
 			// position but re-pin the auto-following tool pane to the latest row.
 			m.refreshPanes(false, true)
@@ -545,47 +1040,51 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case tea.KeyMsg:
 		switch msg.String() {
-		case "q", "ctrl+c":
-			// Persist total elapsed time to stats before quitting
-			if m.stats != nil {
-				var totalElapsed time.Duration
-				if m.timerPaused {
-					totalElapsed = m.pausedElapsed
-				} else {
-					totalElapsed = m.baseElapsed + timeNow().Sub(m.startTime)
-				}
-				m.stats.SetTotalElapsedNs(totalElapsed.Nanoseconds())
+		case "q":
+			// Let a running iteration finish before quitting; a second q
+			// (or one with nothing running) quits now
+			if !m.draining && m.iterationUnderWay() {
+				m.startDrain()
+				return m, nil
 			}
-			// Restore tmux status bar to its original state
-			if m.tmuxBar != nil {
-				m.tmuxBar.Restore()
-			}
-			m.quitting = true
-			return m, tea.Quit
+			return m, m.quit()
+		case "ctrl+c":
+			return m, m.quit()
 		case "p":
-			// Pause the loop - freeze elapsed time (both total and per-loop)
+			// Pause the loop once the current iteration finishes. A second
+			// press (or one with no iteration to finish) interrupts it now -
+			// freeze elapsed time (both total and per-loop)
 			if m.loop != nil {
-				if !m.timerPaused {
-					m.pausedElapsed = m.baseElapsed + timeNow().Sub(m.startTime)
-					m.timerPaused = true
-				}
-				if !m.loopTimerPaused {
-					m.loopPausedElapsed = m.loopBaseElapsed + timeNow().Sub(m.loopStartTime)
-					m.loopTimerPaused = true
+				if !m.loop.IsPausePending() {
+					m.loop.PauseAfterIteration()
+					if m.loop.IsPausePending() {
+						m.softPausing = true
+						m.AddMessage(Message{
+							Role:    RoleSystem,
+							Content: "Pausing after this iteration. Press p again to interrupt it now, or r to keep going.",
+						})
+						m.refreshPanes(true, true)
+						return m, nil
+					}
 				}
+				m.softPausing = false
+				m.freezeTimers()
 				m.loop.Pause()
 			}
 			return m, nil
-		case "r", "s":
+		case "r", "s", "enter":
 			// Resume the loop - resume elapsed time from where we paused (both total and per-loop)
 			// Also handles resuming after completion when new loops were added via '+'
 			// 's' key is the "start" shortcut shown when completed with pending loops
+			// Enter confirms the next loop in --confirm-each-loop step mode
 			// When hibernating, 'r' wakes from hibernate early
 			if m.loop != nil {
 				// Handle hibernate wake first
 				if m.loop.IsHibernating() {
 					m.loop.Wake()
 					m.hibernating = false
+					m.paced = false
+					m.scheduled = false
 					// Resume timers when waking from hibernate
 					if m.timerPaused {
 						m.baseElapsed = m.pausedElapsed
@@ -613,9 +1112,28 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				if m.completed && m.totalLoops > m.currentLoop {
 					m.completed = false
 				}
+				// Resuming before a pending pause takes effect cancels it
+				m.softPausing = false
 				m.loop.Resume()
 			}
 			return m, nil
+		case "R":
+			// Redo the last iteration instead of moving on; only while paused
+			// or completed, never over a running iteration
+			if m.loop != nil && m.loop.RedoIteration() {
+				if m.timerPaused {
+					m.baseElapsed = m.pausedElapsed
+					m.startTime = timeNow()
+					m.timerPaused = false
+				}
+				if m.loopTimerPaused {
+					m.loopBaseElapsed = m.loopPausedElapsed
+					m.loopStartTime = timeNow()
+					m.loopTimerPaused = false
+				}
+				m.completed = false
+			}
+			return m, nil
 		case "+":
 			// Add a loop iteration (works even after completion to enable extending loops)
 			if m.loop != nil {
@@ -623,6 +1141,33 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.loop.SetIterations(m.totalLoops)
 			}
 			return m, nil
+		case "c":
+			// Toggle folding of tool-result runs; only the thinking pane changes,
+			// so keep the user's scroll position there.
+			m.resultsCollapsed = !m.resultsCollapsed
+			m.refreshPanes(false, true)
+			return m, nil
+		case "a":
+			// Fold or unfold nested subagents in the subagent panel
+			m.subagentsFolded = !m.subagentsFolded
+			m.refreshPanes(false, true)
+			return m, nil
+		case "d":
+			// Toggle the detail pane; it opens at the top of the latest result
+			m.detailOpen = !m.detailOpen || m.iterationsOpen
+			m.iterationsOpen = false
+			m.resizePanes()
+			m.refreshPanes(false, true)
+			m.detailViewport.GotoTop()
+			return m, nil
+		case "i":
+			// Toggle the per-iteration table in the detail pane
+			m.iterationsOpen = !m.iterationsOpen
+			m.detailOpen = m.iterationsOpen
+			m.resizePanes()
+			m.refreshPanes(false, true)
+			m.detailViewport.GotoTop()
+			return m, nil
 		case "-":
 			// Subtract a loop iteration (floor: can't go below current loop)
 			if m.loop != nil && m.totalLoops > m.currentLoop {
@@ -643,11 +1188,28 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		// preserves the user's scroll position (no GotoBottom here).
 		m.refreshPanes(false, true)
 		m.updateTmuxStatusBar()
+		m.checkCostThresholds()
+		// A pause asked for with 'p' took effect at the iteration boundary
+		if m.softPausing && m.loop != nil {
+			if m.loop.IsPaused() {
+				m.freezeTimers()
+			}
+			m.softPausing = m.loop.IsPausePending()
+		}
+		// Messages per minute, frozen while paused, hibernating or done so
+		// the idle time doesn't read as the agent stalling.
+		if !m.timerPaused && !m.hibernating && !m.completed {
+			now := timeNow()
+			m.msgRate = m.arrivals.countSince(now.Add(-time.Minute))
+		}
 		return m, tickCmd()
 
 	case newMessageMsg:
 		incoming := Message(msg)
 		m.AddMessage(incoming)
+		if incoming.Role == RoleTool {
+			m.iterations.AddToolUses(1)
+		}
 		// Only auto-follow the thinking pane when the new message is narrative
 		// (it renders there). A tool row changes only the tool pane, so snapping
 		// the thinking pane to the bottom would needlessly discard the user's
@@ -669,7 +1231,17 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case taskUpdateMsg:
+		if m.planTracked {
+			return m, nil
+		}
 		m.currentTask = msg.task
+		m.taskExplicit = true
+		return m, nil
+
+	case activityUpdateMsg:
+		if !m.taskExplicit && !m.planTracked {
+			m.currentTask = msg.activity
+		}
 		return m, nil
 
 	case toolStatusUpdateMsg:
@@ -694,6 +1266,27 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.refreshPanes(false, true)
 		return m, nil
 
+	case toolResultMsg:
+		title := "Tool result"
+		for i := len(m.messages) - 1; i >= 0; i-- {
+			if m.messages[i].Role == RoleTool && m.messages[i].ToolUseID == msg.toolUseID {
+				title += ": " + m.messages[i].Content
+				break
+			}
+		}
+		m.setDetail(title, msg.content)
+		return m, nil
+
+	case detailMsg:
+		// Open the pane first so setDetail renders into it
+		if !m.detailOpen {
+			m.detailOpen = true
+			m.resizePanes()
+		}
+		m.iterationsOpen = false
+		m.setDetail(msg.title, msg.content)
+		return m, nil
+
 	case modeUpdateMsg:
 		m.currentMode = msg.mode
 		return m, nil
@@ -702,6 +1295,11 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		// Full-list replace. Derive the footer counters from the plan so the
 		// panel and footer share a single source of truth.
 		m.plan = msg.items
+		if m.planTracked {
+			// The footer follows the plan file instead
+			m.refreshPanes(false, true)
+			return m, nil
+		}
 		completed, current := 0, ""
 		for _, it := range msg.items {
 			switch it.Status {
@@ -717,31 +1315,60 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.totalTasks = len(msg.items)
 		if current != "" {
 			m.currentTask = current
+			m.taskExplicit = true
 		}
 		m.refreshPanes(false, true)
 		return m, nil
 
+	case subagentUpdateMsg:
+		m.subagents = msg.items
+		m.refreshPanes(false, true)
+		return m, nil
+
 	case completedTasksUpdateMsg:
 		m.completedTasks = msg.completed
 		m.totalTasks = msg.total
 		return m, nil
 
+	case planFileUpdateMsg:
+		m.applyPlanFile(msg.completed, msg.total, msg.current)
+		return m, nil
+
 	case loopStartedMsg:
+		// A scheduled run has started
+		if m.scheduled {
+			m.scheduled = false
+			m.hibernating = false
+		}
 		// New loop iteration started — reset per-loop timer and tokens
 		m.loopStartTime = timeNow()
 		m.loopBaseElapsed = 0
 		m.loopTimerPaused = false
 		m.loopPausedElapsed = 0
 		m.loopTotalTokens = 0
+		m.iterations.Start(m.currentLoop, m.statsSnapshot(), m.loopStartTime)
+		// A fresh iteration starts with a fresh context window
+		m.contextWarning = ""
+		// Keep showing the last task until the new iteration names one, but
+		// let activity summaries replace it
+		m.taskExplicit = false
 		return m, nil
 
 	case loopStatsUpdateMsg:
 		m.loopTotalTokens = msg.totalTokens
 		return m, nil
 
+	case iterationModelMsg:
+		m.iterations.SetModel(msg.model)
+		if m.iterationsOpen {
+			m.refreshPanes(false, false)
+		}
+		return m, nil
+
 	case doneMsg:
 		// Processing is done — freeze both timers and mark as completed
 		m.completed = true
+		m.iterations.Finish(m.statsSnapshot(), timeNow())
 		if !m.timerPaused {
 			m.pausedElapsed = m.baseElapsed + timeNow().Sub(m.startTime)
 			m.timerPaused = true
@@ -750,26 +1377,113 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.loopPausedElapsed = m.loopBaseElapsed + timeNow().Sub(m.loopStartTime)
 			m.loopTimerPaused = true
 		}
+		// A drained run has finished its last iteration: quit as asked
+		if m.draining {
+			return m, m.quit()
+		}
+		if m.closeAfter == 0 && m.wrappedSession != "" {
+			m.AddMessage(Message{
+				Role:    RoleSystem,
+				Content: fmt.Sprintf("Run complete. Press + then s to run more loops, or q to quit and close tmux session %q (--close-after exits automatically).", m.wrappedSession),
+			})
+			m.refreshPanes(true, true)
+		}
+		if m.closeAfter > 0 {
+			m.closeGen++
+			gen := m.closeGen
+			return m, tea.Tick(m.closeAfter, func(time.Time) tea.Msg {
+				return closeAfterMsg{gen: gen}
+			})
+		}
+		return m, nil
+
+	case closeAfterMsg:
+		// Only close if the run is still complete and no later completion
+		// rescheduled the timer; resuming with added loops cancels it.
+		if m.completed && msg.gen == m.closeGen {
+			m.autoClosed = true
+			return m, m.quit()
+		}
 		return m, nil
 
 	case hibernateMsg:
 		m.hibernating = true
 		m.hibernateUntil = msg.until
+		m.paced = false
+		m.scheduled = false
+		return m, nil
+
+	case pacedMsg:
+		m.hibernating = true
+		m.hibernateUntil = msg.until
+		m.paced = true
+		m.scheduled = false
+		m.pacedReason = msg.reason
+		return m, nil
+
+	case scheduledMsg:
+		m.hibernating = true
+		m.hibernateUntil = msg.until
+		m.paced = false
+		m.scheduled = true
+		return m, nil
+
+	case contextWarningMsg:
+		m.contextWarning = msg.text
 		return m, nil
 
 	case loopRefMsg:
 		m.loop = msg.loop
+		// A drain covers the whole run, whichever phase it is in
+		if m.draining && m.loop != nil {
+			m.loop.Drain()
+		}
+		return m, nil
+
+	case drainMsg:
+		if m.draining {
+			return m, nil
+		}
+		if !m.iterationUnderWay() {
+			return m, m.quit()
+		}
+		m.startDrain()
 		return m, nil
 	}
 
-	// Handle viewport scrolling — scroll keys drive the thinking pane (the tool
-	// pane auto-follows the latest activity).
-	m.thinkingViewport, cmd = m.thinkingViewport.Update(msg)
+	// Handle viewport scrolling — scroll keys drive the thinking pane, or the
+	// detail pane while it is open (the tool pane auto-follows the latest activity).
+	if m.detailOpen {
+		m.detailViewport, cmd = m.detailViewport.Update(msg)
+	} else {
+		m.thinkingViewport, cmd = m.thinkingViewport.Update(msg)
+	}
 	cmds = append(cmds, cmd)
 
 	return m, tea.Batch(cmds...)
 }
 
+// quit persists the total elapsed time, restores the tmux status bar, and
+// returns the command that exits the program.
+func (m *Model) quit() tea.Cmd {
+	// Persist total elapsed time to stats before quitting
+	if m.stats != nil {
+		var totalElapsed time.Duration
+		if m.timerPaused {
+			totalElapsed = m.pausedElapsed
+		} else {
+			totalElapsed = m.baseElapsed + timeNow().Sub(m.startTime)
+		}
+		m.stats.SetTotalElapsedNs(totalElapsed.Nanoseconds())
+	}
+	// Restore tmux status bar to its original state
+	if m.tmuxBar != nil {
+		m.tmuxBar.Restore()
+	}
+	m.quitting = true
+	return tea.Quit
+}
+
 // toolElapsed returns the formatted elapsed time for a tool row: a live
 // running time while in_progress, the final duration once resolved, or "" if
 // no start time was recorded.
@@ -833,15 +1547,89 @@ func (m Model) renderPlanPanel() string {
 	return strings.Join(lines, "\n")
 }
 
+// renderSubagentPanel renders the iteration's subagents as a tree, each with
+// its tokens and cost: a spinner while running, ✓ done, ✗ failed. The header
+// sums them and notes that they are part of the iteration's totals, not extra.
+// Folded, only the subagents the main agent spawned are listed. Returns ""
+// when there are none.
+func (m Model) renderSubagentPanel() string {
+	if len(m.subagents) == 0 {
+		return ""
+	}
+	dimStyle := lipgloss.NewStyle().Foreground(colorDimGray)
+	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(colorPurple)
+	runningStyle := lipgloss.NewStyle().Bold(true).Foreground(colorPurple)
+	failedStyle := lipgloss.NewStyle().Foreground(colorRed)
+
+	var tokens int64
+	var cost float64
+	running, nested := 0, 0
+	for _, s := range m.subagents {
+		tokens += s.Tokens
+		cost += s.CostUSD
+		if s.Status == "running" {
+			running++
+		}
+		if s.Depth > 1 {
+			nested++
+		}
+	}
+
+	header := fmt.Sprintf("🤖 Subagents (%d", len(m.subagents))
+	if running > 0 {
+		header += fmt.Sprintf(", %d running", running)
+	}
+	header += ")"
+	lines := []string{
+		headerStyle.Render(header),
+		dimStyle.Render(fmt.Sprintf("   %s tok · %s, included in the iteration", stats.FormatTokens(tokens), stats.FormatCost(cost))),
+	}
+	for _, s := range m.subagents {
+		if m.subagentsFolded && s.Depth > 1 {
+			continue
+		}
+		var glyph, label string
+		switch s.Status {
+		case "completed":
+			glyph, label = "✓", s.Label
+		case "failed":
+			glyph, label = "✗", failedStyle.Render(s.Label)
+		default:
+			glyph, label = spinnerFrames[m.spinnerFrame%len(spinnerFrames)], runningStyle.Render(s.Label)
+		}
+		costText := stats.FormatCost(s.CostUSD)
+		if s.Estimated {
+			costText = "~" + costText
+		}
+		detailText := fmt.Sprintf(" · %s tok · %s", stats.FormatTokens(s.Tokens), costText)
+		if s.ToolCalls > 0 {
+			detailText += fmt.Sprintf(" · %d tools", s.ToolCalls)
+		}
+		detail := dimStyle.Render(detailText)
+		lines = append(lines, "  "+depthIndent(s.Depth-1)+glyph+" "+label+detail)
+	}
+	if m.subagentsFolded && nested > 0 {
+		lines = append(lines, dimStyle.Render(fmt.Sprintf("   ▸ %d nested — press a to expand", nested)))
+	}
+	return strings.Join(lines, "\n")
+}
+
 // renderNarrativeLine renders one non-tool message for the thinking pane as a
 // hanging-indent block: the role icon sits in a fixed gutter and the styled
 // content is word-wrapped to the remaining width, so long thinking/assistant
-// text is shown in full instead of being clipped to a single line.
+// text is shown in full instead of being clipped to a single line. Subagent
+// messages are indented by their depth.
 func renderNarrativeLine(msg Message, width int) string {
-	bodyWidth := max(width-3, 1)
+	indent := depthIndent(msg.Depth)
+	bodyWidth := max(width-3-len(indent), 1)
 	body := msg.GetStyle().Width(bodyWidth).Render(msg.Content)
 	gutter := lipgloss.NewStyle().Width(3).Render(msg.GetIcon())
-	return lipgloss.JoinHorizontal(lipgloss.Top, gutter, body)
+	line := lipgloss.JoinHorizontal(lipgloss.Top, gutter, body)
+	if indent == "" {
+		return line
+	}
+	// Subagent messages hang under their parent: indent every wrapped line.
+	return lipgloss.NewStyle().PaddingLeft(len(indent)).Render(line)
 }
 
 // renderThinkingContent renders the left (2/3) pane: the thinking/assistant
@@ -860,18 +1648,46 @@ func (m Model) renderThinkingContent() string {
 	if width < 1 {
 		// Viewport not sized yet: mirror the left-pane inner width math so the
 		// fallback wraps to the same width the pane will use, not the full row.
-		left, _ := splitPaneWidths(m.width)
+		left, _ := splitPaneWidths(m.contentWidth())
 		width = max(left-4, 1)
 	}
 
 	var lines []string
+	collapsed := 0 // tool results folded into the pending summary line
+	flushCollapsed := func() {
+		if collapsed == 0 {
+			return
+		}
+		noun := "tool results"
+		if collapsed == 1 {
+			noun = "tool result"
+		}
+		lines = append(lines, dimStyle.Render(fmt.Sprintf("▸ %d %s — press c to expand", collapsed, noun)))
+		if !m.compactFeed {
+			lines = append(lines, "")
+		}
+		collapsed = 0
+	}
+	var lastRole MessageRole
 	for _, msg := range m.messages {
 		if msg.Role == RoleTool {
-			continue // tool rows render in the right pane
+			continue // tool rows render in the right pane; they don't break a run
+		}
+		if m.resultsCollapsed && msg.Role == RoleUser {
+			collapsed++
+			continue
 		}
+		flushCollapsed()
+		if m.compactFeed && lastRole != "" && msg.Role != lastRole {
+			lines = append(lines, dimStyle.Render(strings.Repeat("╌", width)))
+		}
+		lastRole = msg.Role
 		lines = append(lines, renderNarrativeLine(msg, width))
-		lines = append(lines, "") // blank line between messages
+		if !m.compactFeed {
+			lines = append(lines, "") // blank line between messages
+		}
 	}
+	flushCollapsed()
 
 	// Thinking/waiting indicator: when the loop is live but nothing is
 	// executing, the model is deciding its next step. Animate dots so the
@@ -891,11 +1707,17 @@ func (m Model) renderThinkingContent() string {
 	return strings.Join(lines, "\n")
 }
 
-// renderToolContent renders the right (1/3) pane: the agent's plan panel pinned
-// at the top followed by the tool-use rows, each rendered exactly as before the
+// renderToolContent renders the right (1/3) pane: the agent's plan panel and
+// the subagent panel pinned at the top followed by the tool-use rows, each rendered exactly as before the
 // split (status glyph + kind icon + title + dim elapsed time).
 func (m Model) renderToolContent() string {
 	planPanel := m.renderPlanPanel()
+	if subagentPanel := m.renderSubagentPanel(); subagentPanel != "" {
+		if planPanel != "" {
+			planPanel += "\n\n"
+		}
+		planPanel += subagentPanel
+	}
 	dimStyle := lipgloss.NewStyle().Foreground(colorDimGray)
 
 	var lines []string
@@ -920,8 +1742,10 @@ func (m Model) renderToolContent() string {
 			// Status-less tool message: icon + styled content.
 			line = fmt.Sprintf("%s %s", msg.GetIcon(), msg.GetStyle().Render(msg.Content))
 		}
-		lines = append(lines, line)
-		lines = append(lines, "") // blank line between rows
+		lines = append(lines, depthIndent(msg.Depth)+line)
+		if !m.compactFeed {
+			lines = append(lines, "") // blank line between rows
+		}
 	}
 
 	content := strings.Join(lines, "\n")
@@ -960,6 +1784,7 @@ func (m Model) View() string {
 func (m Model) renderLayout() string {
 	// Check if loop is paused or completed
 	isPaused := m.loop != nil && m.loop.IsPaused()
+	isPausing := m.loop != nil && m.loop.IsPausePending()
 	isHibernating := m.loop != nil && m.loop.IsHibernating()
 
 	// Choose colors based on state
@@ -968,34 +1793,67 @@ func (m Model) renderLayout() string {
 	if m.completed {
 		borderColor = colorGreen
 		statusText = "COMPLETED"
+	} else if isHibernating && m.scheduled {
+		borderColor = colorYellow
+		statusText = fmt.Sprintf("SCHEDULED — starting in %s", formatResumeIn(time.Until(m.hibernateUntil)))
+	} else if isHibernating && m.paced {
+		borderColor = colorYellow
+		statusText = fmt.Sprintf("PACED — resuming in %s", formatResumeIn(time.Until(m.hibernateUntil)))
+		if m.pacedReason != "" {
+			statusText += fmt.Sprintf(" (%s)", m.pacedReason)
+		}
 	} else if isHibernating {
 		borderColor = colorOrange
 		statusText = "RATE LIMITED"
 	} else if isPaused {
 		borderColor = colorRed
 		statusText = "STOPPED"
+	} else if m.draining {
+		borderColor = colorYellow
+		statusText = "QUITTING AFTER THIS ITERATION"
+	} else if isPausing {
+		borderColor = colorYellow
+		statusText = "PAUSING AFTER THIS ITERATION"
+	}
+	if m.contextWarning != "" && !m.completed {
+		statusText += " · CONTEXT NEARLY FULL"
 	}
 
 	// Split the activity area 2:1 — a wide "thinking" pane and a narrow
 	// "tool use" pane (see splitPaneWidths); each box's +2 rounded border makes
-	// the joined row fill the terminal exactly.
-	leftStyleWidth, rightStyleWidth := splitPaneWidths(m.width)
+	// the joined row fill the content width exactly.
+	contentWidth := m.contentWidth()
+	leftStyleWidth, rightStyleWidth := splitPaneWidths(contentWidth)
 
+	panesHeight, detailHeight := m.paneHeights()
 	paneStyle := lipgloss.NewStyle().
 		Border(lipgloss.RoundedBorder()).
 		BorderForeground(borderColor).
 		Padding(1, 2).
-		Height(m.activityHeight)
+		Height(panesHeight)
 
 	thinkingPane := paneStyle.Width(leftStyleWidth).Render(m.thinkingViewport.View())
 	toolPane := paneStyle.Width(rightStyleWidth).Render(m.toolViewport.View())
 	panes := lipgloss.JoinHorizontal(lipgloss.Top, thinkingPane, toolPane)
+	if detailHeight > 0 {
+		detailPane := paneStyle.Width(contentWidth - 2).Height(detailHeight).Render(m.detailViewport.View())
+		panes = lipgloss.JoinVertical(lipgloss.Left, panes, detailPane)
+	}
 
-	// Centered status title at top
+	// Centered status title at top; an active notification flashes in its place
+	titleColor := borderColor
+	if len(m.notifications) > 0 {
+		texts := make([]string, len(m.notifications))
+		for i, n := range m.notifications {
+			texts[i] = n.text
+		}
+		statusText = strings.Join(texts, "  ·  ")
+		titleColor = m.notifications[len(m.notifications)-1].color
+	}
 	statusTitle := lipgloss.NewStyle().
 		Bold(true).
-		Foreground(borderColor).
-		Width(m.width - 2).
+		Foreground(titleColor).
+		Width(contentWidth - 2).
 		Align(lipgloss.Center).
 		Render(statusText)
 
@@ -1005,11 +1863,22 @@ func (m Model) renderLayout() string {
 		statusTitle,
 		panes,
 	)
+	// A capped activity panel sits centered above/below the full-width footer
+	if contentWidth < m.width {
+		activityPanel = lipgloss.PlaceHorizontal(m.width, lipgloss.Center, activityPanel)
+	}
 
 	// Render footer panels
 	footerContent := m.renderFooter()
 
 	// Join activity and footer
+	if m.footerOnTop {
+		return lipgloss.JoinVertical(
+			lipgloss.Left,
+			footerContent,
+			activityPanel,
+		)
+	}
 	return lipgloss.JoinVertical(
 		lipgloss.Left,
 		activityPanel,
@@ -1041,6 +1910,12 @@ func (m Model) renderFooter() string {
 	costStyle := lipgloss.NewStyle().
 		Bold(true).
 		Foreground(colorGreen)
+	switch m.costLevel {
+	case 1:
+		costStyle = costStyle.Foreground(colorOrange)
+	case 2:
+		costStyle = costStyle.Foreground(colorRed)
+	}
 
 	titleStyle := lipgloss.NewStyle().
 		Bold(true).
@@ -1049,6 +1924,34 @@ func (m Model) renderFooter() string {
 	// Take a consistent snapshot of stats for display (avoids races with writer goroutine)
 	snap := m.stats.Snapshot()
 
+	// Activity rate: messages over the last minute
+	rateDisplay := " -"
+	if m.msgRate >= 0 {
+		rateDisplay = fmt.Sprintf(" ~%d msg/min", m.msgRate)
+	}
+
+	// Cache-hit ratio rides on the Cache Read row, flagged when caching is poor
+	cacheReadDisplay := valueStyle.Render(fmt.Sprintf(" %s", stats.FormatTokens(snap.CacheReadTokens)))
+	if snap.HasCacheActivity() {
+		ratio := snap.CacheHitRatio()
+		ratioStyle := valueStyle
+		if ratio < stats.LowCacheHitRatio {
+			ratioStyle = lipgloss.NewStyle().Foreground(colorOrange)
+		}
+		cacheReadDisplay += ratioStyle.Render(fmt.Sprintf(" (%.0f%% hit)", ratio*100))
+	}
+
+	// Cost per 1k tokens and its trend ride on the Total Cost row, flagged
+	// when recent iterations cost more per token
+	costDisplay := costStyle.Render(" " + stats.FormatCost(snap.TotalCostUSD))
+	if perK := snap.CostPerKTokens(); perK > 0 {
+		trendStyle := valueStyle
+		if snap.EfficiencyTrend == stats.TrendRising {
+			trendStyle = lipgloss.NewStyle().Foreground(colorOrange)
+		}
+		costDisplay += trendStyle.Render(fmt.Sprintf(" (%s/1k %s)", stats.FormatCost(perK), snap.EfficiencyTrend.Arrow()))
+	}
+
 	// Usage & Cost panel
 	usageCostContent := lipgloss.JoinVertical(
 		lipgloss.Left,
@@ -1057,8 +1960,9 @@ func (m Model) renderFooter() string {
 		lipgloss.JoinHorizontal(lipgloss.Left, labelStyle.Render("Input:"), valueStyle.Render(fmt.Sprintf(" %s", stats.FormatTokens(snap.InputTokens)))),
 		lipgloss.JoinHorizontal(lipgloss.Left, labelStyle.Render("Output:"), valueStyle.Render(fmt.Sprintf(" %s", stats.FormatTokens(snap.OutputTokens)))),
 		lipgloss.JoinHorizontal(lipgloss.Left, labelStyle.Render("Cache Write:"), valueStyle.Render(fmt.Sprintf(" %s", stats.FormatTokens(snap.CacheCreationTokens)))),
-		lipgloss.JoinHorizontal(lipgloss.Left, labelStyle.Render("Cache Read:"), valueStyle.Render(fmt.Sprintf(" %s", stats.FormatTokens(snap.CacheReadTokens)))),
-		lipgloss.JoinHorizontal(lipgloss.Left, labelStyle.Render("Total Cost:"), costStyle.Render(fmt.Sprintf(" $%.6f", snap.TotalCostUSD))),
+		lipgloss.JoinHorizontal(lipgloss.Left, labelStyle.Render("Cache Read:"), cacheReadDisplay),
+		lipgloss.JoinHorizontal(lipgloss.Left, labelStyle.Render("Total Cost:"), costDisplay),
+		lipgloss.JoinHorizontal(lipgloss.Left, labelStyle.Render("Activity:"), valueStyle.Render(rateDisplay)),
 	)
 	usageCostPanel := panelStyle.Render(usageCostContent)
 
@@ -1068,14 +1972,17 @@ func (m Model) renderFooter() string {
 		loopDisplay = fmt.Sprintf("#%d/%d", m.currentLoop, m.totalLoops)
 	}
 
-	elapsed := m.getElapsed()
-	hours := int(elapsed.Hours())
-	minutes := int(elapsed.Minutes()) % 60
-	seconds := int(elapsed.Seconds()) % 60
-	timeDisplay := fmt.Sprintf("%02d:%02d:%02d", hours, minutes, seconds)
+	timeDisplay := stats.FormatDuration(m.getElapsed())
+	// Count down the time the run has left under --max-duration
+	if m.loop != nil && !m.completed {
+		if deadline := m.loop.Deadline(); !deadline.IsZero() {
+			timeDisplay += fmt.Sprintf(" (%s left)", formatResumeIn(deadline.Sub(timeNow())))
+		}
+	}
 
 	// Status display
 	isPaused := m.loop != nil && m.loop.IsPaused()
+	isPausing := m.loop != nil && m.loop.IsPausePending()
 	isHibernating := m.loop != nil && m.loop.IsHibernating()
 	statusText := "Running"
 	statusStyle := valueStyle.Foreground(colorGreen)
@@ -1092,9 +1999,22 @@ func (m Model) renderFooter() string {
 		secs := int(remaining.Seconds()) % 60
 		statusText = fmt.Sprintf("Rate Limited 💤 %02d:%02d", mins, secs)
 		statusStyle = valueStyle.Foreground(colorOrange)
+		if m.paced {
+			statusText = fmt.Sprintf("Paced ⏳ %02d:%02d", mins, secs)
+			statusStyle = valueStyle.Foreground(colorYellow)
+		} else if m.scheduled {
+			statusText = fmt.Sprintf("Scheduled ⏰ %02d:%02d", mins, secs)
+			statusStyle = valueStyle.Foreground(colorYellow)
+		}
 	} else if isPaused {
 		statusText = "Stopped"
 		statusStyle = valueStyle.Foreground(colorRed)
+	} else if m.draining {
+		statusText = "Quitting"
+		statusStyle = valueStyle.Foreground(colorYellow)
+	} else if isPausing {
+		statusText = "Pausing"
+		statusStyle = valueStyle.Foreground(colorYellow)
 	}
 
 	// Current Mode display
@@ -1137,10 +2057,28 @@ func (m Model) renderFooter() string {
 
 	quitKey := highlightStyle.Render("(q)")
 	quitLabel := highlightStyle.Render("uit")
+	if m.draining {
+		quitLabel = highlightStyle.Render(" quit now")
+	}
 	pauseKey := dimStyle.Render("(p)ause")
 	resumeKey := dimStyle.Render("(r)esume")
 	loopsKey := highlightStyle.Render("(+)/(-)")
 	loopsLabel := highlightStyle.Render(" # of loops")
+	collapseKey := highlightStyle.Render("(c)")
+	collapseLabel := highlightStyle.Render("ollapse results")
+	if m.resultsCollapsed {
+		collapseLabel = highlightStyle.Render(" expand results")
+	}
+	detailKey := highlightStyle.Render("(d)")
+	detailLabel := highlightStyle.Render("etail")
+	if m.detailOpen && !m.iterationsOpen {
+		detailLabel = highlightStyle.Render(" hide detail")
+	}
+	iterationsKey := highlightStyle.Render("(i)")
+	iterationsLabel := highlightStyle.Render("terations")
+	if m.iterationsOpen {
+		iterationsLabel = highlightStyle.Render(" hide iterations")
+	}
 
 	// Illuminate resume/start depending on state
 	hasPendingLoops := m.completed && m.totalLoops > m.currentLoop
@@ -1151,15 +2089,32 @@ func (m Model) renderFooter() string {
 		resumeKey = highlightStyle.Render("(s)tart")
 	} else if isPaused {
 		resumeKey = highlightStyle.Render("(r)esume")
+	} else if isPausing {
+		pauseKey = highlightStyle.Render("(p) interrupt now")
+		resumeKey = highlightStyle.Render("(r) keep going")
 	} else if !m.completed {
 		pauseKey = highlightStyle.Render("(p)ause")
 	}
+	redoKey := ""
+	if !isHibernating && (isPaused || m.completed) {
+		redoKey = "   " + highlightStyle.Render("(R)edo")
+	}
+	subagentsKey := ""
+	for _, s := range m.subagents {
+		if s.Depth > 1 {
+			subagentsKey = "   " + highlightStyle.Render("(a) fold subagents")
+			if m.subagentsFolded {
+				subagentsKey = "   " + highlightStyle.Render("(a) unfold subagents")
+			}
+			break
+		}
+	}
 
 	hotkeyBar := lipgloss.NewStyle().
 		Width(m.width - 2).
 		Align(lipgloss.Left).
 		PaddingLeft(1).
-		Render(fmt.Sprintf("%s%s   %s   %s   %s%s", quitKey, quitLabel, resumeKey, pauseKey, loopsKey, loopsLabel))
+		Render(fmt.Sprintf("%s%s   %s   %s%s   %s%s   %s%s   %s%s   %s%s%s", quitKey, quitLabel, resumeKey, pauseKey, redoKey, loopsKey, loopsLabel, collapseKey, collapseLabel, detailKey, detailLabel, iterationsKey, iterationsLabel, subagentsKey))
 
 	return lipgloss.JoinVertical(
 		lipgloss.Left,
@@ -1184,7 +2139,12 @@ func (m Model) updateTmuxStatusBar() {
 		mins := int(remaining.Minutes())
 		secs := int(remaining.Seconds()) % 60
 		hibernateDisplay := fmt.Sprintf("RATE LIMITED 💤 %02d:%02d", mins, secs)
-		m.tmuxBar.Update(tmux.FormatStatusRight(m.repoName, m.branchName, hibernateDisplay, ""))
+		if m.paced {
+			hibernateDisplay = fmt.Sprintf("PACED ⏳ %02d:%02d", mins, secs)
+		} else if m.scheduled {
+			hibernateDisplay = fmt.Sprintf("SCHEDULED ⏰ %02d:%02d", mins, secs)
+		}
+		m.tmuxBar.Update(tmux.FormatStatusRight(m.repoName, m.branchName, hibernateDisplay, tmux.FormatTasks(m.completedTasks, m.totalTasks), ""))
 		return
 	}
 
@@ -1194,13 +2154,9 @@ func (m Model) updateTmuxStatusBar() {
 	}
 
 	// Total session uptime
-	elapsed := m.getElapsed()
-	hours := int(elapsed.Hours())
-	minutes := int(elapsed.Minutes()) % 60
-	seconds := int(elapsed.Seconds()) % 60
-	timeDisplay := fmt.Sprintf("%02d:%02d:%02d", hours, minutes, seconds)
+	timeDisplay := stats.FormatDuration(m.getElapsed())
 
-	m.tmuxBar.Update(tmux.FormatStatusRight(m.repoName, m.branchName, loopDisplay, timeDisplay))
+	m.tmuxBar.Update(tmux.FormatStatusRight(m.repoName, m.branchName, loopDisplay, tmux.FormatTasks(m.completedTasks, m.totalTasks), timeDisplay))
 }
 
 // SendMessage is a helper command to send a message to the TUI
@@ -1231,6 +2187,14 @@ func SendTaskUpdate(task string) tea.Cmd {
 	}
 }
 
+// SendActivityUpdate is a helper command to show an activity summary as the
+// current task when no explicit task reference has been seen this iteration
+func SendActivityUpdate(activity string) tea.Cmd {
+	return func() tea.Msg {
+		return activityUpdateMsg{activity: activity}
+	}
+}
+
 // SendToolStatusUpdate is a helper command to update a tool row's lifecycle
 // status (completed/failed) by its tool_use ID.
 func SendToolStatusUpdate(toolUseID, status string) tea.Cmd {
@@ -1239,6 +2203,21 @@ func SendToolStatusUpdate(toolUseID, status string) tea.Cmd {
 	}
 }
 
+// SendToolResult is a helper command to show the latest tool result in the detail pane
+func SendToolResult(toolUseID, content string) tea.Cmd {
+	return func() tea.Msg {
+		return toolResultMsg{toolUseID: toolUseID, content: content}
+	}
+}
+
+// SendDetail is a helper command to open the detail pane on content, such as
+// the plan file awaiting review
+func SendDetail(title, content string) tea.Cmd {
+	return func() tea.Msg {
+		return detailMsg{title: title, content: content}
+	}
+}
+
 // SendPlanUpdate is a helper command to replace the agent's plan (the panel +
 // footer counters are derived from it).
 func SendPlanUpdate(items []PlanItem) tea.Cmd {
@@ -1247,6 +2226,13 @@ func SendPlanUpdate(items []PlanItem) tea.Cmd {
 	}
 }
 
+// SendSubagentUpdate creates a command to replace the subagent panel's list
+func SendSubagentUpdate(items []SubagentItem) tea.Cmd {
+	return func() tea.Msg {
+		return subagentUpdateMsg{items: items}
+	}
+}
+
 // SendModeUpdate is a helper command to update the current mode display
 func SendModeUpdate(mode string) tea.Cmd {
 	return func() tea.Msg {
@@ -1261,6 +2247,15 @@ func SendCompletedTasksUpdate(completed, total int) tea.Cmd {
 	}
 }
 
+// SendPlanFileUpdate is a helper command to show the plan file's task counts
+// and current task. Once it has tasks they are shown instead of the task
+// references and activity guessed from the agent's output.
+func SendPlanFileUpdate(completed, total int, current string) tea.Cmd {
+	return func() tea.Msg {
+		return planFileUpdateMsg{completed: completed, total: total, current: current}
+	}
+}
+
 // SendLoopStarted is a helper command to signal a new loop iteration has begun
 func SendLoopStarted() tea.Cmd {
 	return func() tea.Msg {
@@ -1268,6 +2263,14 @@ func SendLoopStarted() tea.Cmd {
 	}
 }
 
+// SendIterationModel is a helper command to record the model the running
+// iteration's agent runs with
+func SendIterationModel(model string) tea.Cmd {
+	return func() tea.Msg {
+		return iterationModelMsg{model: model}
+	}
+}
+
 // SendLoopStatsUpdate is a helper command to update per-loop token count
 func SendLoopStatsUpdate(totalTokens int64) tea.Cmd {
 	return func() tea.Msg {
@@ -1289,6 +2292,40 @@ func SendHibernate(until time.Time) tea.Cmd {
 	}
 }
 
+// SendPaced is a helper command to signal that the loop is waiting for a
+// spending window to clear until the given time; reason says which limit,
+// e.g. "cost limit"
+func SendPaced(until time.Time, reason string) tea.Cmd {
+	return func() tea.Msg {
+		return pacedMsg{until: until, reason: reason}
+	}
+}
+
+// SendScheduled is a helper command to signal that the loop is waiting until
+// the given time for its active hours (--active-hours) to open
+func SendScheduled(until time.Time) tea.Cmd {
+	return func() tea.Msg {
+		return scheduledMsg{until: until}
+	}
+}
+
+// SendContextWarning is a helper command to flag that the agent's context
+// window is nearly full for the current iteration
+func SendContextWarning(text string) tea.Cmd {
+	return func() tea.Msg {
+		return contextWarningMsg{text: text}
+	}
+}
+
+// SendDrain is a helper command asking the TUI to let the current iteration
+// finish and then quit, e.g. on SIGTERM. With no iteration running it quits
+// at once.
+func SendDrain() tea.Cmd {
+	return func() tea.Msg {
+		return drainMsg{}
+	}
+}
+
 // SendLoopRef is a helper command to update the loop reference in the TUI model.
 // Used in plan-and-build mode to swap the loop when transitioning between phases.
 func SendLoopRef(l *loop.Loop) tea.Cmd {
@@ -1319,6 +2356,21 @@ func (m *Model) SetMaxMessagesForTest(n int) {
 	m.maxMessages = n
 }
 
+// ThinkingContentForTest returns the rendered thinking-pane content.
+func (m Model) ThinkingContentForTest() string {
+	return m.renderThinkingContent()
+}
+
+// ToolContentForTest returns the rendered tool-pane content.
+func (m Model) ToolContentForTest() string {
+	return m.renderToolContent()
+}
+
+// DetailContentForTest returns the rendered detail pane content.
+func (m Model) DetailContentForTest() string {
+	return m.renderDetailContent()
+}
+
 // MessageCountForTest returns the current number of messages in the activity feed.
 func (m *Model) MessageCountForTest() int {
 	return len(m.messages)
@@ -0,0 +1,193 @@
+// Package vcs inspects the git work tree the agent runs in.
+package vcs
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// emptyTree is git's well-known hash of the empty tree, the base to diff
+// against when the repository had no commits yet.
+const emptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// shortStatPattern matches the counts in `git diff --shortstat` output:
+// " 3 files changed, 12 insertions(+), 4 deletions(-)".
+var shortStatPattern = regexp.MustCompile(`(\d+) (file|insertion|deletion)`)
+
+// git runs a git command in dir and returns its trimmed stdout.
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(ee.Stderr)))
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// HeadCommit returns the commit hash HEAD points at in dir, or "" in a
+// repository with no commits yet.
+func HeadCommit(dir string) (string, error) {
+	if _, err := git(dir, "rev-parse", "--git-dir"); err != nil {
+		return "", err
+	}
+	head, err := git(dir, "rev-parse", "--verify", "--quiet", "HEAD")
+	if err != nil {
+		// --verify --quiet fails silently when HEAD is unborn
+		return "", nil
+	}
+	return head, nil
+}
+
+// ExcludePathspec returns the pathspec, "--" included, that limits a git
+// command to the work tree under the working directory but for excludeDirs.
+func ExcludePathspec(excludeDirs []string) []string {
+	pathspec := []string{"--", "."}
+	for _, dir := range excludeDirs {
+		pathspec = append(pathspec, ":(exclude)"+dir)
+	}
+	return pathspec
+}
+
+// HasUncommittedChanges reports whether the work tree in dir has staged,
+// unstaged or untracked (but not ignored) changes outside excludeDirs.
+func HasUncommittedChanges(dir string, excludeDirs []string) (bool, error) {
+	out, err := git(dir, append([]string{"status", "--porcelain"}, ExcludePathspec(excludeDirs)...)...)
+	if err != nil {
+		return false, err
+	}
+	return out != "", nil
+}
+
+// LastCommitChanged reports whether HEAD moved between two HeadCommit
+// readings taken before and after an iteration.
+func LastCommitChanged(before, after string) bool {
+	return before != after
+}
+
+// ForgotToCommit reports whether an iteration left work behind without
+// committing it: HEAD did not move but the tree is dirty. An iteration that
+// had nothing to change leaves a clean tree and is not reported, nor is one
+// that only changed excludeDirs.
+func ForgotToCommit(dir, before, after string, excludeDirs []string) (bool, error) {
+	if LastCommitChanged(before, after) {
+		return false, nil
+	}
+	return HasUncommittedChanges(dir, excludeDirs)
+}
+
+// Commit is one commit in a CommitsSince listing.
+type Commit struct {
+	Hash    string // abbreviated hash
+	Subject string // first line of the commit message
+}
+
+// CommitsSince lists the commits reachable from HEAD but not from ref, oldest
+// first: the commits made since ref was recorded with HeadCommit. An empty
+// ref (HEAD was unborn) lists every commit. A repository that still has no
+// commits returns none.
+func CommitsSince(dir, ref string) ([]Commit, error) {
+	head, err := HeadCommit(dir)
+	if err != nil || head == "" {
+		return nil, err
+	}
+	rng := "HEAD"
+	if ref != "" {
+		rng = ref + "..HEAD"
+	}
+	out, err := git(dir, "log", "--reverse", "--format=%h%x1f%s", rng)
+	if err != nil {
+		return nil, err
+	}
+	var commits []Commit
+	for _, line := range strings.Split(out, "\n") {
+		if hash, subject, ok := strings.Cut(line, "\x1f"); ok {
+			commits = append(commits, Commit{Hash: hash, Subject: subject})
+		}
+	}
+	return commits, nil
+}
+
+// Changes is what was committed since a HeadCommit reading: how many commits,
+// and their combined diff stat.
+type Changes struct {
+	Commits     int
+	Files       int  // files changed
+	Insertions  int  // lines added
+	Deletions   int  // lines removed
+	Uncommitted bool // the work tree also has changes that were not committed
+}
+
+// String summarizes c, e.g. "2 commits, 3 files changed, +12 -4", or "no
+// commit" (noting uncommitted changes) when nothing was committed.
+func (c Changes) String() string {
+	if c.Commits == 0 {
+		if c.Uncommitted {
+			return "no commit, uncommitted changes left"
+		}
+		return "no commit"
+	}
+	commits, files := "commits", "files"
+	if c.Commits == 1 {
+		commits = "commit"
+	}
+	if c.Files == 1 {
+		files = "file"
+	}
+	return fmt.Sprintf("%d %s, %d %s changed, +%d -%d", c.Commits, commits, c.Files, files, c.Insertions, c.Deletions)
+}
+
+// ChangesSince reports the commits made in dir since ref was recorded with
+// HeadCommit ("" = HEAD was unborn), their diff stat, and whether changes
+// were left uncommitted. Changes under excludeDirs are left out of the diff
+// stat and the uncommitted check.
+func ChangesSince(dir, ref string, excludeDirs []string) (Changes, error) {
+	commits, err := CommitsSince(dir, ref)
+	if err != nil {
+		return Changes{}, err
+	}
+	c := Changes{Commits: len(commits)}
+	if c.Commits > 0 {
+		base := ref
+		if base == "" {
+			base = emptyTree
+		}
+		out, err := git(dir, append([]string{"diff", "--shortstat", base, "HEAD"}, ExcludePathspec(excludeDirs)...)...)
+		if err != nil {
+			return Changes{}, err
+		}
+		for _, m := range shortStatPattern.FindAllStringSubmatch(out, -1) {
+			n, _ := strconv.Atoi(m[1])
+			switch m[2] {
+			case "file":
+				c.Files = n
+			case "insertion":
+				c.Insertions = n
+			case "deletion":
+				c.Deletions = n
+			}
+		}
+	}
+	if c.Uncommitted, err = HasUncommittedChanges(dir, excludeDirs); err != nil {
+		return Changes{}, err
+	}
+	return c, nil
+}
+
+// CheckoutBranch checks out branch in dir, creating it at HEAD unless it
+// already exists. Uncommitted changes are carried over, as with `git
+// checkout`.
+func CheckoutBranch(dir, branch string) error {
+	args := []string{"checkout", "-q", "-b", branch}
+	if _, err := git(dir, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch); err == nil {
+		args = []string{"checkout", "-q", branch}
+	}
+	_, err := git(dir, args...)
+	return err
+}
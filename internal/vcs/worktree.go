@@ -0,0 +1,60 @@
+package vcs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WorktreeDir is where AddWorktrees checks out worktrees, relative to the
+// repository root. A .gitignore inside it keeps them out of `git status`.
+const WorktreeDir = ".ralph/worktrees"
+
+// Worktree is a checkout made by AddWorktrees.
+type Worktree struct {
+	Dir    string // absolute path of the checkout
+	Branch string // branch created for it
+}
+
+// AddWorktrees checks out n new worktrees of the repository containing dir,
+// each on its own branch started from HEAD: WorktreeDir/<name>-<k> on branch
+// ralph/<name>-<k>, for k from 1 to n. They are left in place afterwards so
+// their branches can be reviewed and merged; `git worktree remove` deletes
+// one. If any checkout fails, the ones already made are removed again.
+func AddWorktrees(dir, name string, n int) ([]Worktree, error) {
+	head, err := HeadCommit(dir)
+	if err != nil {
+		return nil, err
+	}
+	if head == "" {
+		return nil, fmt.Errorf("worktrees need a commit to start from; commit something first")
+	}
+	root, err := git(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Join(root, WorktreeDir)
+	if err := os.MkdirAll(base, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(base, ".gitignore"), []byte("*\n"), 0644); err != nil {
+		return nil, err
+	}
+
+	var trees []Worktree
+	for k := 1; k <= n; k++ {
+		wt := Worktree{
+			Dir:    filepath.Join(base, fmt.Sprintf("%s-%d", name, k)),
+			Branch: fmt.Sprintf("ralph/%s-%d", name, k),
+		}
+		if _, err := git(root, "worktree", "add", "-q", "-b", wt.Branch, wt.Dir, head); err != nil {
+			for _, made := range trees {
+				git(root, "worktree", "remove", "--force", made.Dir)
+				git(root, "branch", "-D", made.Branch)
+			}
+			return nil, err
+		}
+		trees = append(trees, wt)
+	}
+	return trees, nil
+}
@@ -0,0 +1,179 @@
+package tests
+
+import (
+	"context"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudosai/ralph-go/internal/loop"
+	"github.com/cloudosai/ralph-go/internal/parser"
+)
+
+func TestBackendRegistry(t *testing.T) {
+	if got, want := loop.BackendNames(), []string{"aider", "claude", "codex", "cursor-agent"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("BackendNames() = %v, want %v", got, want)
+	}
+	costModels := map[string]loop.CostModel{
+		"claude":       loop.CostReported,
+		"cursor-agent": loop.CostUnknown,
+		"codex":        loop.CostEstimated,
+		"aider":        loop.CostUnknown,
+	}
+	for name, want := range costModels {
+		b, ok := loop.LookupBackend(name)
+		if !ok || b.Name() != name {
+			t.Fatalf("Expected backend %q to be registered", name)
+		}
+		if b.CostModel() != want {
+			t.Errorf("%s cost model = %v, want %v", name, b.CostModel(), want)
+		}
+	}
+	if _, ok := loop.LookupBackend("gpt-engineer"); ok {
+		t.Error("Expected an unknown backend name to be rejected")
+	}
+}
+
+// parseRecords parses translated records the way the TUI and CLI do.
+func parseRecords(t *testing.T, records []string) []*parser.ParsedMessage {
+	t.Helper()
+	p := parser.NewParser()
+	var msgs []*parser.ParsedMessage
+	for _, record := range records {
+		msg := p.ParseLine(record)
+		if msg == nil {
+			t.Fatalf("Expected a stream-json record, got %q", record)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestCursorAgentBackendTranslatesToolCalls(t *testing.T) {
+	b := loop.CursorAgentBackend{}
+	p := parser.NewParser()
+
+	assistant := `{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Reading it"}]},"session_id":"chat-1"}`
+	if got := b.ParseLine(assistant); !reflect.DeepEqual(got, []string{assistant}) {
+		t.Errorf("Expected assistant messages to pass through, got %q", got)
+	}
+
+	msgs := parseRecords(t, b.ParseLine(`{"type":"tool_call","subtype":"started","call_id":"call-1","tool_call":{"readToolCall":{"args":{"path":"main.go"}}},"session_id":"chat-1"}`))
+	uses := p.ExtractContent(msgs[0]).ToolUses
+	if len(uses) != 1 || uses[0].Name != "Read" || uses[0].Location != "main.go" || uses[0].ID != "call-1" {
+		t.Fatalf("Expected a Read of main.go, got %+v", uses)
+	}
+	if msgs[0].SessionID != "chat-1" {
+		t.Errorf("Expected the session ID to be kept, got %q", msgs[0].SessionID)
+	}
+
+	msgs = parseRecords(t, b.ParseLine(`{"type":"tool_call","subtype":"completed","call_id":"call-2","tool_call":{"shellToolCall":{"args":{"command":"go test"},"result":{"failure":{"message":"exit status 1"}}}}}`))
+	results := p.ExtractContent(msgs[0]).ToolResults
+	if len(results) != 1 || results[0].ToolUseID != "call-2" || !results[0].IsError || results[0].Content != "exit status 1" {
+		t.Errorf("Expected a failed result for call-2, got %+v", results)
+	}
+}
+
+func TestCodexBackendTranslatesEvents(t *testing.T) {
+	b := loop.CodexBackend{}
+	p := parser.NewParser()
+
+	msgs := parseRecords(t, b.ParseLine(`{"type":"thread.started","thread_id":"thread-9"}`))
+	if msgs[0].Type != parser.MessageTypeSystem || msgs[0].SessionID != "thread-9" {
+		t.Errorf("Expected an init message for thread-9, got %+v", msgs[0])
+	}
+
+	msgs = parseRecords(t, b.ParseLine(`{"type":"item.started","item":{"id":"item_1","type":"command_execution","command":"go test ./...","status":"in_progress"}}`))
+	if uses := p.ExtractContent(msgs[0]).ToolUses; len(uses) != 1 || uses[0].Name != "Bash" || uses[0].ID != "item_1" {
+		t.Errorf("Expected a Bash call, got %+v", uses)
+	}
+	msgs = parseRecords(t, b.ParseLine(`{"type":"item.completed","item":{"id":"item_1","type":"command_execution","aggregated_output":"FAIL","exit_code":1}}`))
+	if results := p.ExtractContent(msgs[0]).ToolResults; len(results) != 1 || !results[0].IsError {
+		t.Errorf("Expected a failed result, got %+v", results)
+	}
+
+	msgs = parseRecords(t, b.ParseLine(`{"type":"item.completed","item":{"id":"item_2","type":"agent_message","text":"All done"}}`))
+	if text := p.ExtractContent(msgs[0]).TextContent; len(text) != 1 || text[0] != "All done" {
+		t.Errorf("Expected the agent message as text, got %q", text)
+	}
+
+	msgs = parseRecords(t, b.ParseLine(`{"type":"turn.completed","usage":{"input_tokens":1000,"cached_input_tokens":800,"output_tokens":50}}`))
+	if len(msgs) != 2 || msgs[1].Type != parser.MessageTypeResult {
+		t.Fatalf("Expected usage then a result, got %d messages", len(msgs))
+	}
+	if usage := p.GetUsage(msgs[0]); usage == nil || usage.InputTokens != 200 || usage.CacheReadInputTokens != 800 || usage.OutputTokens != 50 {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+
+	if got := b.ParseLine(`{"type":"turn.started"}`); len(got) != 0 {
+		t.Errorf("Expected events without a stream-json counterpart to be dropped, got %q", got)
+	}
+}
+
+func TestAiderBackendTranslatesText(t *testing.T) {
+	b := loop.AiderBackend{}
+	p := parser.NewParser()
+
+	msgs := parseRecords(t, b.ParseLine("I'll add the flag to config.go"))
+	if text := p.ExtractContent(msgs[0]).TextContent; len(text) != 1 || text[0] != "I'll add the flag to config.go" {
+		t.Errorf("Expected the line as assistant text, got %q", text)
+	}
+
+	msgs = parseRecords(t, b.ParseLine("Applied edit to internal/config/config.go"))
+	if uses := p.ExtractContent(msgs[0]).ToolUses; len(uses) != 1 || uses[0].Name != "Edit" || uses[0].Location != "internal/config/config.go" {
+		t.Errorf("Expected an Edit of config.go, got %+v", uses)
+	}
+
+	if got := b.ParseLine("   "); len(got) != 0 {
+		t.Errorf("Expected blank lines to be dropped, got %q", got)
+	}
+}
+
+// renamingBackend runs the mock agent, renames its session in the output and
+// cannot resume.
+type renamingBackend struct{ loop.ClaudeBackend }
+
+func (renamingBackend) BuildCommand(ctx context.Context, prompt string) *exec.Cmd {
+	return mockCommandBuilder(ctx, prompt)
+}
+
+func (renamingBackend) ParseLine(line string) []string {
+	return []string{strings.ReplaceAll(line, "fresh-session-001", "renamed-session")}
+}
+
+func (renamingBackend) SupportsResume() bool { return false }
+
+// TestLoopUsesBackend tests that the loop builds its command from the
+// backend, translates its output and skips --resume when the backend cannot
+// resume
+func TestLoopUsesBackend(t *testing.T) {
+	l := loop.New(loop.Config{
+		Iterations:    1,
+		Prompt:        "test",
+		Backend:       renamingBackend{},
+		SleepDuration: 10 * time.Millisecond,
+	})
+	l.SetResumeSessionID("resume-me")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+
+	var renamed, resumed bool
+	for msg := range l.Output() {
+		if msg.Type == "output" {
+			renamed = renamed || strings.Contains(msg.Content, "renamed-session")
+			resumed = resumed || strings.Contains(msg.Content, "resume-me")
+		}
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+	if !renamed {
+		t.Error("Expected the agent's output to go through the backend's ParseLine")
+	}
+	if resumed {
+		t.Error("Expected no --resume for a backend that cannot resume")
+	}
+}
@@ -604,7 +604,8 @@ func TestBDD_AutoresearchMode_PauseShowsStoppedWhileResearching(t *testing.T) {
 	defer cancel()
 	l.Start(ctx)
 
-	// When: user pauses the loop
+	// When: user pauses the loop, interrupting the iteration (p twice)
+	m, _ = pressKey(m, 'p')
 	m, _ = pressKey(m, 'p')
 
 	// Then: status shows STOPPED but mode still shows "Researching"
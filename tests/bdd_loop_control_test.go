@@ -674,3 +674,23 @@ func TestBDD_UserControlsLoopExecution_LoopControlsKeyBindingSummary(t *testing.
 		t.Error("Hotkey bar should always show '# of loops' label")
 	}
 }
+
+// TestBDD_UserControlsLoopExecution_RedoHintOnlyWhenIdle tests that the (R)edo
+// hint appears once the loop is completed, but not while it runs, and that
+// pressing R on a loop that is not waiting leaves it alone.
+func TestBDD_UserControlsLoopExecution_RedoHintOnlyWhenIdle(t *testing.T) {
+	m, _ := setupReadyModelWithLoop(2, 2)
+	if viewContains(m, "(R)edo") {
+		t.Error("Hotkey bar should not offer (R)edo while running")
+	}
+
+	m, _ = pressKey(m, 'R')
+	if viewNotContains(m, "RUNNING") {
+		t.Error("Pressing R while running should not change the status")
+	}
+
+	m, _ = sendTuiMsg(m, tui.SendDone())
+	if !viewContains(m, "(R)edo") {
+		t.Error("Hotkey bar should offer (R)edo once the run completed")
+	}
+}
@@ -1,9 +1,12 @@
 package tests
 
 import (
+	"context"
+	"strings"
 	"testing"
 	"time"
 
+	tea "github.com/charmbracelet/bubbletea"
 	"github.com/cloudosai/ralph-go/internal/loop"
 	"github.com/cloudosai/ralph-go/internal/tui"
 )
@@ -542,6 +545,168 @@ func TestBDD_UserHandlesRateLimits_LoopProgressVisibleDuringHibernate(t *testing
 	}
 }
 
+// --- Scenario: Cost pacing shows PACED, not RATE LIMITED ---
+
+// setupPacedModel creates a ready model with a loop waiting out the cost limit.
+func setupPacedModel(current, total int, paceDuration time.Duration) (tui.Model, *loop.Loop) {
+	m, l := setupReadyModelWithLoop(current, total)
+	until := time.Now().Add(paceDuration)
+	l.Pace(until)
+	m, _ = sendTuiMsg(m, tui.SendPaced(until, "cost limit"))
+	return m, l
+}
+
+func TestBDD_UserHandlesRateLimits_PacedShowsResumeTimeAndReason(t *testing.T) {
+	// Given: a loop paced for 12 minutes by the cost limit
+	m, _ := setupPacedModel(2, 5, 12*time.Minute)
+
+	// Then: the banner explains the wait, distinct from a rate limit
+	if !viewContains(m, "PACED — resuming in 12m (cost limit)") {
+		t.Error("Expected banner 'PACED — resuming in 12m (cost limit)'")
+	}
+	if viewContains(m, "RATE LIMITED") {
+		t.Error("Should NOT show 'RATE LIMITED' while paced")
+	}
+	// And: the footer counts down with its own glyph
+	if !viewContains(m, "Paced ⏳ 11:") && !viewContains(m, "Paced ⏳ 12:") {
+		t.Error("Expected footer countdown 'Paced ⏳ MM:SS'")
+	}
+}
+
+func TestBDD_UserHandlesRateLimits_RateLimitDuringPacingShowsRateLimited(t *testing.T) {
+	// Given: a paced loop
+	m, l := setupPacedModel(2, 5, 5*time.Minute)
+
+	// When: a real rate limit arrives with a later reset
+	until := time.Now().Add(10 * time.Minute)
+	l.Hibernate(until)
+	m, _ = sendTuiMsg(m, tui.SendHibernate(until))
+
+	// Then: RATE LIMITED replaces PACED
+	if !viewContains(m, "RATE LIMITED") || viewContains(m, "PACED") {
+		t.Error("Expected 'RATE LIMITED' to replace 'PACED'")
+	}
+}
+
+func TestBDD_UserHandlesRateLimits_WakeFromPacing(t *testing.T) {
+	// Given: a paced loop
+	m, l := setupPacedModel(2, 5, 5*time.Minute)
+
+	// When: the user presses 'r'
+	m, _ = pressKey(m, 'r')
+
+	// Then: the loop wakes and the banner no longer shows PACED
+	if l.IsHibernating() || l.IsPacing() {
+		t.Error("Expected the loop to wake from pacing")
+	}
+	if viewContains(m, "PACED") {
+		t.Error("Expected 'PACED' to clear after waking")
+	}
+}
+
+// --- Scenario: A scheduled run counts down to its start ---
+
+func TestBDD_UserHandlesRateLimits_ScheduledStartCountsDown(t *testing.T) {
+	// Given: a run scheduled to start in two hours
+	until := time.Now().Add(2 * time.Hour)
+	l := loop.New(loop.Config{Iterations: 3, Prompt: "test", CommandBuilder: mockCommandBuilder, StartAt: until})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l.Start(ctx)
+	for msg := range l.Output() {
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "SCHEDULED") {
+			break
+		}
+	}
+
+	m := tui.NewModel()
+	m.SetLoop(l)
+	m.SetLoopProgress(0, 3)
+	m.SetScheduled(until)
+	m, _ = updateModel(m, tea.WindowSizeMsg{Width: 120, Height: 40})
+
+	// Then: the banner and footer count down to the start
+	if !viewContains(m, "SCHEDULED — starting in 2h0m") {
+		t.Error("Expected banner 'SCHEDULED — starting in 2h0m'")
+	}
+	if !viewContains(m, "Scheduled ⏰ 119:") && !viewContains(m, "Scheduled ⏰ 120:") {
+		t.Error("Expected footer countdown 'Scheduled ⏰ MM:SS'")
+	}
+	if viewContains(m, "RATE LIMITED") || viewContains(m, "PACED") {
+		t.Error("A scheduled start is neither a rate limit nor pacing")
+	}
+
+	// When: the user presses 'r' to start now
+	m, _ = pressKey(m, 'r')
+
+	// Then: the first iteration starts
+	if viewContains(m, "SCHEDULED") {
+		t.Error("Expected 'SCHEDULED' to clear once started")
+	}
+	started := false
+	for msg := range l.Output() {
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "LOOP 1/3") {
+			started = true
+			cancel()
+		}
+	}
+	if !started {
+		t.Error("Expected the first iteration to start after waking")
+	}
+}
+
+// --- Scenario: A run outside its active hours counts down to the window ---
+
+func TestBDD_UserHandlesRateLimits_ActiveHoursCountDown(t *testing.T) {
+	// Given: a run whose active hours open in two hours
+	now := time.Now()
+	open := now.Hour()*60 + now.Minute() + 120
+	window := loop.Window{Start: open % (24 * 60), End: (open + 60) % (24 * 60)}
+	l := loop.New(loop.Config{Iterations: 3, Prompt: "test", CommandBuilder: mockCommandBuilder, ActiveHours: window})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l.Start(ctx)
+	var until time.Time
+	for msg := range l.Output() {
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "SCHEDULED") {
+			until = msg.Until
+			break
+		}
+	}
+
+	m := tui.NewModel()
+	m.SetLoop(l)
+	m.SetLoopProgress(0, 3)
+	m, _ = updateModel(m, tea.WindowSizeMsg{Width: 120, Height: 40})
+	m, _ = updateModel(m, tui.SendScheduled(until)())
+
+	// Then: the banner and footer count down to the window
+	if !viewContains(m, "SCHEDULED — starting in") {
+		t.Error("Expected banner 'SCHEDULED — starting in ...'")
+	}
+	if !viewContains(m, "Scheduled ⏰") {
+		t.Error("Expected footer countdown 'Scheduled ⏰ MM:SS'")
+	}
+
+	// When: the user presses 'r' to run now
+	m, _ = pressKey(m, 'r')
+
+	// Then: the iteration starts outside the window
+	if viewContains(m, "SCHEDULED") {
+		t.Error("Expected 'SCHEDULED' to clear once woken")
+	}
+	started := false
+	for msg := range l.Output() {
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "LOOP 1/3") {
+			started = true
+			cancel()
+		}
+	}
+	if !started {
+		t.Error("Expected the iteration to start after waking")
+	}
+}
+
 // --- Helper ---
 
 // extractFooterSection extracts a substring around a keyword for diagnostic output.
@@ -9,7 +9,9 @@ package tests
 //
 // updateTmuxStatusBar() is called on every tick and formats the content as:
 //
-//	[repo | branch | loop: N/M, uptime: HH:MM:SS]
+//	[repo | branch | loop: N/M, tasks: D/T (P%), uptime: HH:MM:SS]
+//
+// The tasks field is left out when the plan has no tasks.
 //
 // During hibernation it shows:
 //
@@ -113,6 +115,33 @@ func TestBDD_TmuxStatusBar_RepoBranchShownOnTick(t *testing.T) {
 	}
 }
 
+// --- Scenario 3b: Plan progress shown ---
+
+// TestBDD_TmuxStatusBar_TasksShownOnTick
+//
+// Given: a model whose plan has 3 of 4 tasks done
+// When: a tick occurs
+// Then: the tmux bar content includes the task progress and percentage
+func TestBDD_TmuxStatusBar_TasksShownOnTick(t *testing.T) {
+	m, fakeBar := setupModelWithFakeBar(1, 3)
+
+	triggerTick(m)
+	if strings.Contains(fakeBar.LastContent, "tasks:") {
+		t.Errorf("Expected no tasks field without a plan, got: %q", fakeBar.LastContent)
+	}
+
+	// Given: plan progress set
+	m.SetCompletedTasks(3, 4)
+
+	// When: tick
+	triggerTick(m)
+
+	// Then: task progress shown
+	if !strings.Contains(fakeBar.LastContent, "tasks: 3/4 (75%)") {
+		t.Errorf("Expected 'tasks: 3/4 (75%%)' in tmux bar, got: %q", fakeBar.LastContent)
+	}
+}
+
 // --- Scenario 4: Session uptime shown ---
 
 // TestBDD_TmuxStatusBar_ElapsedTimeShownOnTick
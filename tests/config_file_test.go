@@ -0,0 +1,266 @@
+package tests
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cloudosai/ralph-go/internal/config"
+)
+
+// configFlagSet defines a few of ralph's flags for the config file tests.
+func configFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("ralph", flag.ContinueOnError)
+	fs.Int("iterations", 5, "")
+	fs.String("spec-folder", "specs", "")
+	fs.Float64("max-cost-per-hour", 0, "")
+	fs.Bool("no-tmux", false, "")
+	fs.String("notify-webhook", "", "")
+	fs.String("notify-events", "", "")
+	fs.Bool("redact", false, "")
+	fs.String("redact-pattern", "", "")
+	fs.String("price", "", "")
+	fs.String("var", "", "")
+	return fs
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfigFileArgsTOML(t *testing.T) {
+	path := writeConfigFile(t, "ralph.toml", `
+# ralph settings
+iterations = 10
+spec_folder = "my specs" # quoted, with a space
+max-cost-per-hour = 2.5
+no_tmux = true
+redact_pattern = ['sk-[a-z]+', "tok_#\\d+"]
+
+[notify]
+webhook = "https://hooks.example.com/ralph"
+events = ["error", "run_complete"]
+`)
+	args, err := config.ReadConfigFileArgs(configFlagSet(), path, "")
+	if err != nil {
+		t.Fatalf("ReadConfigFileArgs: %v", err)
+	}
+	want := []string{
+		"--iterations=10",
+		"--spec-folder=my specs",
+		"--max-cost-per-hour=2.5",
+		"--no-tmux=true",
+		"--redact-pattern=sk-[a-z]+",
+		`--redact-pattern=tok_#\d+`,
+		"--notify-webhook=https://hooks.example.com/ralph",
+		"--notify-events=error,run_complete",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("Got %q, want %q", args, want)
+	}
+}
+
+func TestReadConfigFileArgsYAML(t *testing.T) {
+	path := writeConfigFile(t, ".ralph.yaml", `---
+iterations: 10
+spec_folder: "my specs"
+redact_pattern:
+  - sk-[a-z]+
+  - 'tok_\d+'
+notify:
+  webhook: https://hooks.example.com/ralph  # comment
+  events: [error, run_complete]
+max_cost_per_hour: 2.5
+`)
+	args, err := config.ReadConfigFileArgs(configFlagSet(), path, "")
+	if err != nil {
+		t.Fatalf("ReadConfigFileArgs: %v", err)
+	}
+	want := []string{
+		"--iterations=10",
+		"--spec-folder=my specs",
+		"--redact-pattern=sk-[a-z]+",
+		`--redact-pattern=tok_\d+`,
+		"--notify-webhook=https://hooks.example.com/ralph",
+		"--notify-events=error,run_complete",
+		"--max-cost-per-hour=2.5",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("Got %q, want %q", args, want)
+	}
+}
+
+func TestReadConfigFileArgsPrice(t *testing.T) {
+	path := writeConfigFile(t, "ralph.toml", `price = ["gpt-5=1.25/10", "*=2/8/0/0.2"]`+"\n")
+	args, err := config.ReadConfigFileArgs(configFlagSet(), path, "")
+	if err != nil {
+		t.Fatalf("ReadConfigFileArgs: %v", err)
+	}
+	want := []string{"--price=gpt-5=1.25/10", "--price=*=2/8/0/0.2"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("Got %q, want %q", args, want)
+	}
+}
+
+func TestReadConfigFileArgsVar(t *testing.T) {
+	path := writeConfigFile(t, ".ralph.yaml", "var:\n  - team=core\n  - ticket=RAL-1\n")
+	args, err := config.ReadConfigFileArgs(configFlagSet(), path, "")
+	if err != nil {
+		t.Fatalf("ReadConfigFileArgs: %v", err)
+	}
+	want := []string{"--var=team=core", "--var=ticket=RAL-1"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("Got %q, want %q", args, want)
+	}
+}
+
+func TestReadConfigFileArgsErrors(t *testing.T) {
+	for name, tc := range map[string]struct{ file, content, want string }{
+		"unknown key":     {"ralph.toml", "iteratons = 3\n", `ralph.toml:1: unknown setting "iteratons"`},
+		"unknown table":   {"ralph.toml", "[notfy]\nwebhook = \"x\"\n", `unknown setting "notfy-webhook"`},
+		"unquoted string": {"ralph.toml", "spec_folder = specs\n", "line 1: spec-folder: want a quoted string"},
+		"missing =":       {"ralph.toml", "iterations\n", "line 1: want key = value"},
+		"open array":      {"ralph.toml", "notify_events = [\"error\",\n", "arrays must close on the same line"},
+		"stray item":      {".ralph.yaml", "- error\n", "line 1: list item outside a list"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := config.ReadConfigFileArgs(configFlagSet(), writeConfigFile(t, tc.file, tc.content), "")
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestFindConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	if got := config.FindConfigFile(dir); got != "" {
+		t.Errorf("Expected no config file, got %q", got)
+	}
+	os.WriteFile(filepath.Join(dir, ".ralph.yaml"), nil, 0644)
+	os.WriteFile(filepath.Join(dir, "ralph.toml"), nil, 0644)
+	if got := config.FindConfigFile(dir); got != filepath.Join(dir, "ralph.toml") {
+		t.Errorf("Expected ralph.toml to win, got %q", got)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	fs := configFlagSet()
+	path := writeConfigFile(t, "ralph.toml", "iterations = 10\nspec_folder = \"from-file\"\nmax_cost_per_hour = 1\n")
+	fileArgs, err := config.ReadConfigFileArgs(fs, path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"RALPH_ITERATIONS":        "20",
+		"RALPH_MAX_COST_PER_HOUR": "3",
+		"RALPH_UNRELATED":         "x",
+	}
+	envArgs := config.EnvArgs(fs, func(k string) string { return env[k] })
+
+	args := append(append(fileArgs, envArgs...), "--iterations", "30")
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"iterations":        "30",        // command line over env over file
+		"max-cost-per-hour": "3",         // env over file
+		"spec-folder":       "from-file", // file over default
+	} {
+		if got := fs.Lookup(name).Value.String(); got != want {
+			t.Errorf("--%s = %s, want %s", name, got, want)
+		}
+	}
+}
+
+const profilesTOML = `
+iterations = 5
+max_cost_per_hour = 10
+
+[profile.nightly]
+iterations = 50
+
+[profile.nightly.notify]
+webhook = "https://hooks.example.com/nightly"
+
+[profile.cheap]
+max_cost_per_hour = 1
+`
+
+func TestReadConfigFileArgsProfiles(t *testing.T) {
+	path := writeConfigFile(t, "ralph.toml", profilesTOML)
+	for profile, want := range map[string][]string{
+		"":        {"--iterations=5", "--max-cost-per-hour=10"},
+		"nightly": {"--iterations=5", "--max-cost-per-hour=10", "--iterations=50", "--notify-webhook=https://hooks.example.com/nightly"},
+		"cheap":   {"--iterations=5", "--max-cost-per-hour=10", "--max-cost-per-hour=1"},
+	} {
+		args, err := config.ReadConfigFileArgs(configFlagSet(), path, profile)
+		if err != nil {
+			t.Fatalf("Profile %q: %v", profile, err)
+		}
+		if !reflect.DeepEqual(args, want) {
+			t.Errorf("Profile %q: got %q, want %q", profile, args, want)
+		}
+	}
+
+	// A top-level profile key picks the profile when none is given
+	path = writeConfigFile(t, "ralph.toml", "profile = \"cheap\"\n"+profilesTOML)
+	args, err := config.ReadConfigFileArgs(configFlagSet(), path, "")
+	if want := []string{"--iterations=5", "--max-cost-per-hour=10", "--max-cost-per-hour=1"}; err != nil || !reflect.DeepEqual(args, want) {
+		t.Errorf("Default profile: got %q, %v, want %q", args, err, want)
+	}
+	if args, _ := config.ReadConfigFileArgs(configFlagSet(), path, "nightly"); len(args) != 4 {
+		t.Errorf("Expected --profile to override the default profile, got %q", args)
+	}
+
+	_, err = config.ReadConfigFileArgs(configFlagSet(), path, "weekly")
+	if err == nil || !strings.Contains(err.Error(), `no profile "weekly"`) {
+		t.Errorf("Expected an undefined profile to be an error, got %v", err)
+	}
+}
+
+func TestReadConfigFileArgsProfilesYAML(t *testing.T) {
+	path := writeConfigFile(t, ".ralph.yaml", `
+iterations: 5
+profile:
+  cheap:
+    max_cost_per_hour: 1
+  empty:
+`)
+	args, err := config.ReadConfigFileArgs(configFlagSet(), path, "cheap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"--iterations=5", "--max-cost-per-hour=1"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("Got %q, want %q", args, want)
+	}
+	if _, err := config.ReadConfigFileArgs(configFlagSet(), path, "empty"); err != nil {
+		t.Errorf("Expected a profile with no settings to be allowed, got %v", err)
+	}
+}
+
+func TestFlagArg(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"--iterations", "3"}, ""},
+		{[]string{"--profile", "nightly"}, "nightly"},
+		{[]string{"-profile=cheap", "--iterations", "3"}, "cheap"},
+		{[]string{"--profile=cheap", "--profile", "nightly"}, "nightly"},
+		{[]string{"--profiles", "x"}, ""},
+	} {
+		if got := config.FlagArg(tc.args, "profile"); got != tc.want {
+			t.Errorf("FlagArg(%q) = %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
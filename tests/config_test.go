@@ -2,11 +2,15 @@ package tests
 
 import (
 	"flag"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/cloudosai/ralph-go/internal/config"
+	"github.com/cloudosai/ralph-go/internal/loop"
 )
 
 func TestNewConfig(t *testing.T) {
@@ -632,6 +636,92 @@ func TestParseFlagsPlanModeDefaultsTo1Iteration(t *testing.T) {
 	}
 }
 
+func TestParseFlagsActiveHours(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph", "--active-hours", "22:00-06:00"}
+
+	cfg := config.ParseFlags()
+
+	if want := (loop.Window{Start: 22 * 60, End: 6 * 60}); cfg.ActiveHours != want {
+		t.Errorf("Expected active hours 22:00-06:00, got %s", cfg.ActiveHours)
+	}
+	if err := flag.CommandLine.Set("active-hours", "late"); err == nil {
+		t.Error("Expected --active-hours late to be rejected")
+	}
+}
+
+func TestParseFlagsPrice(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph", "--price", "gpt-5=1.25/10", "--price", "*=2/8/0/0.2"}
+
+	cfg := config.ParseFlags()
+
+	if len(cfg.Prices) != 2 || cfg.Prices[0].Match != "gpt-5" || cfg.Prices[1].Match != "*" {
+		t.Errorf("Expected both price rules in order, got %+v", cfg.Prices)
+	}
+	if err := flag.CommandLine.Set("price", "gpt-5=cheap"); err == nil {
+		t.Error("Expected --price gpt-5=cheap to be rejected")
+	}
+}
+
+func TestConfigModelFor(t *testing.T) {
+	cfg := config.NewConfig()
+	if got := cfg.ModelFor(true); got != "" {
+		t.Errorf("Expected the agent's default model, got %q", got)
+	}
+	cfg.Model = "opus"
+	if got := cfg.ModelFor(true); got != "opus" {
+		t.Errorf("Expected plan iterations to use --model without --plan-model, got %q", got)
+	}
+	cfg.PlanModel = "haiku"
+	if plan, build := cfg.ModelFor(true), cfg.ModelFor(false); plan != "haiku" || build != "opus" {
+		t.Errorf("Expected haiku to plan and opus to build, got %q and %q", plan, build)
+	}
+
+	cfg.FallbackModel = "opus"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "--retry-failed") {
+		t.Errorf("Expected --fallback-model without --retry-failed to be rejected, got %v", err)
+	}
+	cfg.RetryFailed, cfg.FallbackAfter = 2, 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "--fallback-after") {
+		t.Errorf("Expected --fallback-after 0 to be rejected, got %v", err)
+	}
+}
+
+func TestConfigDeadline(t *testing.T) {
+	now := time.Date(2026, 3, 10, 14, 30, 0, 0, time.Local)
+	cfg := config.NewConfig()
+	if !cfg.Deadline(now).IsZero() {
+		t.Error("Expected no deadline without --max-duration")
+	}
+	cfg.MaxDuration = 8 * time.Hour
+	if got := cfg.Deadline(now); !got.Equal(now.Add(8 * time.Hour)) {
+		t.Errorf("Expected the deadline 8h from now, got %v", got)
+	}
+	cfg.StartDelay = time.Hour
+	if got := cfg.Deadline(now); !got.Equal(now.Add(9 * time.Hour)) {
+		t.Errorf("Expected the deadline 8h from the delayed start, got %v", got)
+	}
+	cfg.MaxDuration, cfg.StartDelay = -time.Hour, 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "--max-duration") {
+		t.Errorf("Expected a negative --max-duration to be rejected, got %v", err)
+	}
+}
+
 func TestParseFlagsPlanModeExplicitIterationsHonored(t *testing.T) {
 	origArgs := os.Args
 	origCommandLine := flag.CommandLine
@@ -791,6 +881,52 @@ func TestPlanAndBuildExplicitIterations(t *testing.T) {
 	}
 }
 
+// TestParseFlags_DefaultIterationsOverrides tests that --default-iterations
+// sets each mode's default and that an explicit --iterations still wins
+func TestParseFlags_DefaultIterationsOverrides(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	overrides := []string{"--default-iterations", "plan=2", "--default-iterations", "build=20", "--default-iterations", "plan-and-build=8"}
+	tests := []struct {
+		name      string
+		args      []string
+		wantIters int
+		wantBuild int
+	}{
+		{"default mode", nil, 20, 0},
+		{"build", []string{"build"}, 20, 0},
+		{"plan", []string{"plan"}, 2, 0},
+		{"plan-and-build", []string{"plan-and-build"}, 2, 8},
+		{"explicit build", []string{"build", "--iterations", "3"}, 3, 0},
+		{"explicit plan", []string{"plan", "--iterations", "3"}, 3, 0},
+		{"explicit plan-and-build", []string{"plan-and-build", "--iterations", "3"}, 2, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+			args := append([]string{"ralph"}, tt.args...)
+			os.Args = append(args, overrides...)
+			cfg := config.ParseFlags()
+			if cfg.Iterations != tt.wantIters || cfg.BuildIterations != tt.wantBuild {
+				t.Errorf("Expected iterations %d, build %d; got %d, %d", tt.wantIters, tt.wantBuild, cfg.Iterations, cfg.BuildIterations)
+			}
+		})
+	}
+
+	// Modes without an override keep the built-in defaults
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph", "plan-and-build", "--default-iterations", "build=20"}
+	cfg := config.ParseFlags()
+	if cfg.Iterations != config.DefaultPlanIterations || cfg.BuildIterations != config.DefaultIterations {
+		t.Errorf("Expected built-in plan-and-build defaults, got %d, %d", cfg.Iterations, cfg.BuildIterations)
+	}
+}
+
 func TestBuildIterationsFieldDefault(t *testing.T) {
 	cfg := config.NewConfig()
 	if cfg.BuildIterations != 0 {
@@ -1003,3 +1139,1029 @@ func containsHelper(s, substr string) bool {
 	}
 	return false
 }
+
+func TestValidate_NegativeCompactEvery(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.LoopPrompt = ""
+	cfg.SpecFile = ""
+	cfg.SpecFolder = ""
+	cfg.CompactEvery = -1
+
+	err := cfg.Validate()
+	if err == nil || !contains(err.Error(), "--compact-every") {
+		t.Errorf("Expected --compact-every validation error, got %v", err)
+	}
+}
+
+func TestValidate_NegativeStatsInterval(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.StatsInterval = -time.Second
+
+	err := cfg.Validate()
+	if err == nil || !contains(err.Error(), "--stats-interval") {
+		t.Errorf("Expected --stats-interval validation error, got %v", err)
+	}
+}
+
+func TestValidate_NegativeCloseAfter(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.CloseAfter = -time.Second
+
+	err := cfg.Validate()
+	if err == nil || !contains(err.Error(), "--close-after") {
+		t.Errorf("Expected --close-after validation error, got %v", err)
+	}
+}
+
+func TestValidate_FirstPrompt(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.FirstPrompt = filepath.Join(t.TempDir(), "missing.md")
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--first-prompt") {
+		t.Errorf("Expected --first-prompt validation error for a missing file, got %v", err)
+	}
+
+	cfg.FirstPrompt = filepath.Join(t.TempDir(), "first.md")
+	if err := os.WriteFile(cfg.FirstPrompt, []byte("plan first"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected existing --first-prompt to validate, got %v", err)
+	}
+
+	cfg.Subcommand = "plan-and-build"
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "plan-and-build") {
+		t.Errorf("Expected --first-prompt to be rejected with plan-and-build, got %v", err)
+	}
+}
+
+func TestValidate_NegativeMaxToolResultBytes(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.MaxToolResultBytes = -1
+
+	err := cfg.Validate()
+	if err == nil || !contains(err.Error(), "--max-tool-result-bytes") {
+		t.Errorf("Expected --max-tool-result-bytes validation error, got %v", err)
+	}
+}
+
+func TestValidate_ZeroIterationsAllowedInCLI(t *testing.T) {
+	cfg := &config.Config{
+		Iterations: 0,
+		CLI:        true,
+		SpecFolder: "", // Skip folder validation
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected zero iterations to be valid in CLI mode, got %v", err)
+	}
+
+	cfg.Iterations = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for negative iterations in CLI mode, got nil")
+	}
+}
+
+func TestValidate_NegativeStallNudgeAfter(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.StallNudgeAfter = -1
+
+	err := cfg.Validate()
+	if err == nil || !contains(err.Error(), "--stall-nudge-after") {
+		t.Errorf("Expected --stall-nudge-after validation error, got %v", err)
+	}
+}
+
+func TestValidate_NegativeDoneAfterIdle(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.DoneAfterIdle = -1
+
+	err := cfg.Validate()
+	if err == nil || !contains(err.Error(), "--done-after-idle") {
+		t.Errorf("Expected --done-after-idle validation error, got %v", err)
+	}
+}
+
+func TestValidate_TUILayout(t *testing.T) {
+	for _, layout := range []string{"top", "bottom"} {
+		cfg := config.NewConfig()
+		cfg.SpecFolder = ""
+		cfg.TUILayout = layout
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Expected --tui-layout %s to be valid, got %v", layout, err)
+		}
+	}
+
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.TUILayout = "left"
+	err := cfg.Validate()
+	if err == nil || !contains(err.Error(), "--tui-layout") {
+		t.Errorf("Expected --tui-layout validation error, got %v", err)
+	}
+}
+
+func TestTUILayoutDefault(t *testing.T) {
+	cfg := config.NewConfig()
+	if cfg.TUILayout != "bottom" {
+		t.Errorf("Expected default TUILayout to be bottom, got %q", cfg.TUILayout)
+	}
+}
+
+func TestValidate_CostDecimals(t *testing.T) {
+	tests := []struct {
+		decimals int
+		wantErr  bool
+	}{
+		{-1, true},
+		{0, false},
+		{config.DefaultCostDecimals, false},
+		{config.MaxCostDecimals, false},
+		{config.MaxCostDecimals + 1, true},
+	}
+	for _, tt := range tests {
+		cfg := config.NewConfig()
+		cfg.SpecFolder = ""
+		cfg.CostDecimals = tt.decimals
+		err := cfg.Validate()
+		if tt.wantErr && (err == nil || !contains(err.Error(), "--cost-decimals")) {
+			t.Errorf("Expected --cost-decimals error for %d, got %v", tt.decimals, err)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("Expected %d decimals to be valid, got %v", tt.decimals, err)
+		}
+	}
+}
+
+func TestCostFormatFlags(t *testing.T) {
+	oldArgs := os.Args
+	oldCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldCommandLine
+	}()
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	os.Args = []string{"ralph", "--cost-symbol", "€", "--cost-decimals", "2"}
+
+	cfg := config.ParseFlags()
+	if cfg.CostSymbol != "€" || cfg.CostDecimals != 2 {
+		t.Errorf("Expected cost format €/2, got %q/%d", cfg.CostSymbol, cfg.CostDecimals)
+	}
+}
+
+func TestInitSubcommandDetected(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph", "init"}
+
+	cfg := config.ParseFlags()
+	if !cfg.IsInitMode() {
+		t.Fatalf("Expected init mode to be detected, got Subcommand %q", cfg.Subcommand)
+	}
+	if cfg.IsBuildMode() {
+		t.Error("Init mode should not be build mode")
+	}
+}
+
+func TestStatsSubcommandDetected(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph", "stats", "--since", "7d", "--json", "total"}
+
+	cfg := config.ParseFlags()
+	if !cfg.IsStatsMode() {
+		t.Fatalf("Expected stats mode to be detected, got Subcommand %q", cfg.Subcommand)
+	}
+	if cfg.StatsCommand != "total" || cfg.StatsSince != "7d" || !cfg.StatsJSON {
+		t.Errorf("Expected total/7d/json, got %q/%q/%v", cfg.StatsCommand, cfg.StatsSince, cfg.StatsJSON)
+	}
+}
+
+func TestReplaySubcommandDetected(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph", "replay", "--speed", "4", ".ralph/transcripts/run-1"}
+
+	cfg := config.ParseFlags()
+	if !cfg.IsReplayMode() || cfg.IsBuildMode() {
+		t.Fatalf("Expected replay mode to be detected, got Subcommand %q", cfg.Subcommand)
+	}
+	if cfg.ReplayDir != ".ralph/transcripts/run-1" || cfg.ReplaySpeed != 4 {
+		t.Errorf("Expected the transcript directory at speed 4, got %q at %g", cfg.ReplayDir, cfg.ReplaySpeed)
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"7d", 7 * 24 * time.Hour},
+		{"1d", 24 * time.Hour},
+		{"12h", 12 * time.Hour},
+		{"90m", 90 * time.Minute},
+	}
+	for _, tt := range tests {
+		got, err := config.ParseSince(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSince(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "d", "week", "0d", "-3h"} {
+		if _, err := config.ParseSince(bad); err == nil {
+			t.Errorf("ParseSince(%q) should fail", bad)
+		}
+	}
+}
+
+func TestRunIDFlag(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph"}
+	first := config.ParseFlags()
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	second := config.ParseFlags()
+	if first.RunID == "" || first.RunID == second.RunID {
+		t.Errorf("Expected a fresh generated run ID per run, got %q and %q", first.RunID, second.RunID)
+	}
+	if err := first.Validate(); err != nil && contains(err.Error(), "--run-id") {
+		t.Errorf("Generated run ID %q should be valid: %v", first.RunID, err)
+	}
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph", "--run-id", "nightly_01"}
+	if cfg := config.ParseFlags(); cfg.RunID != "nightly_01" {
+		t.Errorf("Expected --run-id to be kept, got %q", cfg.RunID)
+	}
+}
+
+func TestValidate_RunID(t *testing.T) {
+	for _, id := range []string{"../etc", "a/b", "has space", ".hidden", "-dash", strings.Repeat("x", 65)} {
+		cfg := config.NewConfig()
+		cfg.SpecFolder = ""
+		cfg.RunID = id
+		if err := cfg.Validate(); err == nil || !contains(err.Error(), "--run-id") {
+			t.Errorf("Expected --run-id %q to be rejected, got %v", id, err)
+		}
+	}
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.RunID = "3f2c9a1e-7b4d-4c21-9e0a-5d6f7a8b9c0d"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected a UUID run ID to be valid, got %v", err)
+	}
+}
+
+func TestValidate_LogFormat(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	if cfg.LogFormat != config.DefaultLogFormat {
+		t.Errorf("Expected default log format %q, got %q", config.DefaultLogFormat, cfg.LogFormat)
+	}
+	for _, f := range []string{"text", "json"} {
+		cfg.LogFormat = f
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Expected --log-format %s to be valid, got %v", f, err)
+		}
+	}
+	cfg.LogFormat = "xml"
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--log-format") {
+		t.Errorf("Expected --log-format xml to be rejected, got %v", err)
+	}
+}
+
+func TestValidate_StreamFormat(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	if cfg.StreamFormat != config.DefaultStreamFormat {
+		t.Errorf("Expected default stream format %q, got %q", config.DefaultStreamFormat, cfg.StreamFormat)
+	}
+	for _, f := range []string{"jsonl", "sse", "concat"} {
+		cfg.StreamFormat = f
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Expected --stream-format %s to be valid, got %v", f, err)
+		}
+	}
+	cfg.StreamFormat = "xml"
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--stream-format") {
+		t.Errorf("Expected --stream-format xml to be rejected, got %v", err)
+	}
+}
+
+func TestValidate_CostThresholds(t *testing.T) {
+	tests := []struct {
+		warn, crit float64
+		wantErr    string
+	}{
+		{0, 0, ""},
+		{3, 0, ""},
+		{0, 5, ""},
+		{3, 5, ""},
+		{-1, 0, "--cost-warn"},
+		{0, -1, "--cost-crit"},
+		{5, 3, "must be greater than --cost-warn"},
+	}
+	for _, tt := range tests {
+		cfg := config.NewConfig()
+		cfg.SpecFolder = ""
+		cfg.CostWarn, cfg.CostCrit = tt.warn, tt.crit
+		err := cfg.Validate()
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("warn %g, crit %g: expected valid, got %v", tt.warn, tt.crit, err)
+		}
+		if tt.wantErr != "" && (err == nil || !contains(err.Error(), tt.wantErr)) {
+			t.Errorf("warn %g, crit %g: expected error containing %q, got %v", tt.warn, tt.crit, tt.wantErr, err)
+		}
+	}
+}
+
+func TestValidate_OtelEndpoint(t *testing.T) {
+	for endpoint, ok := range map[string]bool{
+		"":                         true,
+		"http://localhost:4318":    true,
+		"https://otel.example.com": true,
+		"localhost:4318":           false,
+		"ftp://otel.example.com":   false,
+	} {
+		cfg := config.NewConfig()
+		cfg.SpecFolder = ""
+		cfg.OtelEndpoint = endpoint
+		err := cfg.Validate()
+		if ok && err != nil {
+			t.Errorf("Expected --otel-endpoint %q to be valid, got %v", endpoint, err)
+		}
+		if !ok && (err == nil || !contains(err.Error(), "--otel-endpoint")) {
+			t.Errorf("Expected --otel-endpoint %q to be rejected, got %v", endpoint, err)
+		}
+	}
+}
+
+func TestValidate_ThrashAction(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	if cfg.ThrashAction != config.DefaultThrashAction {
+		t.Errorf("Expected default thrash action %q, got %q", config.DefaultThrashAction, cfg.ThrashAction)
+	}
+	for _, a := range []string{"warn", "nudge", "stop"} {
+		cfg.ThrashAction = a
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Expected --thrash-action %s to be valid, got %v", a, err)
+		}
+	}
+	cfg.ThrashAction = "ignore"
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--thrash-action") {
+		t.Errorf("Expected --thrash-action ignore to be rejected, got %v", err)
+	}
+}
+
+func TestValidate_MaxContentWidth(t *testing.T) {
+	for _, w := range []int{-1, 1, config.MinContentWidth - 1} {
+		cfg := config.NewConfig()
+		cfg.SpecFolder = ""
+		cfg.MaxContentWidth = w
+		if err := cfg.Validate(); err == nil || !contains(err.Error(), "--max-content-width") {
+			t.Errorf("Expected --max-content-width %d to be rejected, got %v", w, err)
+		}
+	}
+	for _, w := range []int{0, config.MinContentWidth, 120} {
+		cfg := config.NewConfig()
+		cfg.SpecFolder = ""
+		cfg.MaxContentWidth = w
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Expected --max-content-width %d to be valid, got %v", w, err)
+		}
+	}
+}
+
+func TestValidate_PlanReviewRequiresPlanAndBuild(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.PlanReview = true
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--plan-review") {
+		t.Errorf("Expected --plan-review to be rejected outside plan-and-build, got %v", err)
+	}
+
+	cfg.Subcommand = "plan-and-build"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected --plan-review to be valid with plan-and-build, got %v", err)
+	}
+}
+
+func TestPostLoopHookFlags(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph", "--pre-loop-hook", "git pull", "--post-loop-hook", "make test", "--hook-must-pass", "--hook-timeout", "2m"}
+	cfg := config.ParseFlags()
+	if cfg.PreLoopHook != "git pull" {
+		t.Errorf("Expected pre-loop hook %q, got %q", "git pull", cfg.PreLoopHook)
+	}
+	if cfg.PostLoopHook != "make test" || !cfg.HookMustPass || cfg.HookTimeout != 2*time.Minute {
+		t.Errorf("Unexpected hook config: %q, %v, %s", cfg.PostLoopHook, cfg.HookMustPass, cfg.HookTimeout)
+	}
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph"}
+	cfg = config.ParseFlags()
+	if cfg.PreLoopHook != "" || cfg.PostLoopHook != "" || cfg.HookMustPass || cfg.HookTimeout != config.DefaultHookTimeout {
+		t.Errorf("Unexpected hook defaults: %q, %v, %s", cfg.PostLoopHook, cfg.HookMustPass, cfg.HookTimeout)
+	}
+}
+
+func TestValidate_HookMustPassRequiresHook(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.HookMustPass = true
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--hook-must-pass") {
+		t.Errorf("Expected --hook-must-pass without a hook to be rejected, got %v", err)
+	}
+
+	cfg.PreLoopHook = "git pull"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected --hook-must-pass with a pre-loop hook to be valid, got %v", err)
+	}
+
+	cfg.PreLoopHook = ""
+	cfg.PostLoopHook = "make test"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected --hook-must-pass with a post-loop hook to be valid, got %v", err)
+	}
+
+	cfg.HookTimeout = -time.Second
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--hook-timeout") {
+		t.Errorf("Expected a negative --hook-timeout to be rejected, got %v", err)
+	}
+}
+
+func TestValidate_SuccessRetries(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.SuccessRetries = 2
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--success-cmd") {
+		t.Errorf("Expected --success-retries without --success-cmd to be rejected, got %v", err)
+	}
+
+	cfg.SuccessCmd = "make test"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected --success-retries with --success-cmd to be valid, got %v", err)
+	}
+
+	cfg.SuccessRetries = -1
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--success-retries") {
+		t.Errorf("Expected a negative --success-retries to be rejected, got %v", err)
+	}
+}
+
+func TestRetryFlags(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph", "--max-retries", "3", "--total-retries", "10", "--retry-failed", "2", "--retry-backoff", "30s"}
+	cfg := config.ParseFlags()
+	if cfg.MaxRetries != 3 || cfg.TotalRetries != 10 {
+		t.Errorf("Expected 3 per-iteration and 10 total retries, got %d and %d", cfg.MaxRetries, cfg.TotalRetries)
+	}
+	if cfg.RetryFailed != 2 || cfg.RetryBackoff != 30*time.Second {
+		t.Errorf("Expected 2 failed iteration retries from 30s, got %d from %s", cfg.RetryFailed, cfg.RetryBackoff)
+	}
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph"}
+	cfg = config.ParseFlags()
+	if cfg.MaxRetries != config.DefaultMaxRetries || cfg.TotalRetries != 0 || cfg.NoSleepOnError || cfg.RetryFailed != 0 || cfg.RetryBackoff != config.DefaultRetryBackoff {
+		t.Errorf("Unexpected retry defaults: %d, %d and %v", cfg.MaxRetries, cfg.TotalRetries, cfg.NoSleepOnError)
+	}
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph", "--no-sleep-on-error"}
+	if cfg = config.ParseFlags(); !cfg.NoSleepOnError {
+		t.Error("Expected --no-sleep-on-error to be set")
+	}
+}
+
+func TestValidate_RetryLimits(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.MaxRetries = -1
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--max-retries") {
+		t.Errorf("Expected a negative --max-retries to be rejected, got %v", err)
+	}
+
+	cfg.MaxRetries = config.DefaultMaxRetries
+	cfg.TotalRetries = -1
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--total-retries") {
+		t.Errorf("Expected a negative --total-retries to be rejected, got %v", err)
+	}
+
+	cfg.TotalRetries = 0
+	cfg.RetryFailed = -1
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--retry-failed") {
+		t.Errorf("Expected a negative --retry-failed to be rejected, got %v", err)
+	}
+
+	cfg.RetryFailed = 0
+	cfg.RetryBackoff = -time.Second
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--retry-backoff") {
+		t.Errorf("Expected a negative --retry-backoff to be rejected, got %v", err)
+	}
+}
+
+func TestValidate_TranscriptLimits(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.TranscriptMaxFiles = -1
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--transcript-max-files") {
+		t.Errorf("Expected a negative --transcript-max-files to be rejected, got %v", err)
+	}
+
+	cfg.TranscriptMaxFiles = config.DefaultTranscriptMaxFiles
+	cfg.TranscriptMaxMB = -1
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--transcript-max-mb") {
+		t.Errorf("Expected a negative --transcript-max-mb to be rejected, got %v", err)
+	}
+}
+
+func TestBranch(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	start := time.Date(2026, 10, 16, 15, 4, 5, 0, time.UTC)
+	if got := cfg.RunBranch(start); got != "" {
+		t.Errorf("Expected no branch by default, got %q", got)
+	}
+	cfg.Branch = "feature/agent"
+	if got := cfg.RunBranch(start); got != "feature/agent" {
+		t.Errorf("Expected the named branch, got %q", got)
+	}
+	cfg.Branch = config.AutoBranch
+	if got := cfg.RunBranch(start); got != "ralph/20261016-150405" {
+		t.Errorf("Expected a timestamped branch for auto, got %q", got)
+	}
+
+	cfg.Parallel = 2
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--branch") {
+		t.Errorf("Expected --branch with --parallel to be rejected, got %v", err)
+	}
+}
+
+func TestSpecSelection(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph", "--specs", "auth.md, api/users.md,"}
+	cfg := config.ParseFlags()
+	if len(cfg.Specs) != 2 || cfg.Specs[0] != "auth.md" || cfg.Specs[1] != "api/users.md" {
+		t.Errorf("Expected two specs, got %q", cfg.Specs)
+	}
+
+	cfg = config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.SpecSelect = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected --spec-select to be accepted, got %v", err)
+	}
+	cfg.Specs = []string{"auth.md"}
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "can't be used together") {
+		t.Errorf("Expected --spec-select with --specs to be rejected, got %v", err)
+	}
+	cfg.SpecSelect = false
+	cfg.SpecFile = "spec.md"
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--spec-file") {
+		t.Errorf("Expected --specs with --spec-file to be rejected, got %v", err)
+	}
+}
+
+func TestVarFlag(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph", "--var", "team=payments", "--var", "url=http://x/?a=b"}
+	cfg := config.ParseFlags()
+	if len(cfg.Vars) != 2 || cfg.Vars["team"] != "payments" || cfg.Vars["url"] != "http://x/?a=b" {
+		t.Errorf("Expected two variables, got %v", cfg.Vars)
+	}
+
+	for _, bad := range []string{"team", "1team=x", "my-team=x", "Goal=x"} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		flag.CommandLine = fs
+		os.Args = []string{"ralph"}
+		config.ParseFlags()
+		if err := fs.Set("var", bad); err == nil {
+			t.Errorf("Expected --var %s to be rejected", bad)
+		}
+	}
+}
+
+func TestValidate_WarnNoCommit(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	if !cfg.CommitReport {
+		t.Error("Expected commit reports on by default")
+	}
+	cfg.WarnNoCommit = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected --warn-no-commit to be accepted, got %v", err)
+	}
+	cfg.CommitReport = false
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--warn-no-commit requires --commit-report") {
+		t.Errorf("Expected --warn-no-commit without --commit-report to be rejected, got %v", err)
+	}
+}
+
+func TestRedactFlag(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph", "--redact"}
+	cfg := config.ParseFlags()
+	if !cfg.Redact || len(cfg.RedactPatterns) != 0 {
+		t.Errorf("Expected --redact to enable the built-in patterns only, got %v, %q", cfg.Redact, cfg.RedactPatterns)
+	}
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph", "--redact-pattern", `corp-[0-9]+`}
+	cfg = config.ParseFlags()
+	if !cfg.Redact {
+		t.Error("Expected --redact-pattern to enable redaction")
+	}
+	if len(cfg.RedactPatterns) != 1 || cfg.RedactPatterns[0] != `corp-[0-9]+` {
+		t.Errorf("Expected one custom pattern, got %q", cfg.RedactPatterns)
+	}
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph"}
+	if cfg = config.ParseFlags(); cfg.Redact {
+		t.Error("Expected redaction off by default")
+	}
+}
+
+// TestRedactFromConfigFileAndEnv tests that redact = true in ralph.toml and
+// RALPH_REDACT=1 turn redaction on rather than adding "true" or "1" as a
+// pattern
+func TestRedactFromConfigFileAndEnv(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+	os.Args = []string{"ralph"}
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+	toml := "redact = true\nredact_pattern = [\"corp-[0-9]+\"]\n"
+	if err := os.WriteFile(filepath.Join(dir, "ralph.toml"), []byte(toml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := config.ParseFlags()
+	if !cfg.Redact || len(cfg.RedactPatterns) != 1 || cfg.RedactPatterns[0] != "corp-[0-9]+" {
+		t.Errorf("Expected redaction with one custom pattern from ralph.toml, got %v, %q", cfg.Redact, cfg.RedactPatterns)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "ralph.toml")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RALPH_REDACT", "1")
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg = config.ParseFlags()
+	if !cfg.Redact || len(cfg.RedactPatterns) != 0 {
+		t.Errorf("Expected RALPH_REDACT=1 to enable the built-in patterns only, got %v, %q", cfg.Redact, cfg.RedactPatterns)
+	}
+}
+
+func TestValidate_InvalidRedactPattern(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.Redact = true
+	cfg.RedactPatterns = []string{"(unclosed"}
+
+	err := cfg.Validate()
+	if err == nil || !contains(err.Error(), "--redact") {
+		t.Errorf("Expected --redact validation error, got %v", err)
+	}
+}
+
+func TestReadRCArgs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".ralphrc")
+	content := "# defaults\n--iterations 7   # inline comment\n\n--cli --spec-folder docs/\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	args, err := config.ReadRCArgs(path)
+	if err != nil {
+		t.Fatalf("ReadRCArgs: %v", err)
+	}
+	want := []string{"--iterations", "7", "--cli", "--spec-folder", "docs/"}
+	if len(args) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, args)
+	}
+	for i := range want {
+		if args[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want, args)
+			break
+		}
+	}
+
+	args, err = config.ReadRCArgs(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || args != nil {
+		t.Errorf("Expected no args and no error for a missing file, got %v, %v", args, err)
+	}
+}
+
+func TestParseFlags_RCFileDefaultsAndOverride(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	origDir, _ := os.Getwd()
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+		os.Chdir(origDir)
+	}()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, config.RCFile), []byte("--iterations 7\n--cli\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph"}
+	cfg := config.ParseFlags()
+	if cfg.Iterations != 7 || !cfg.CLI {
+		t.Errorf("Expected .ralphrc defaults (7 iterations, cli), got %d, %v", cfg.Iterations, cfg.CLI)
+	}
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph", "--iterations", "3"}
+	cfg = config.ParseFlags()
+	if cfg.Iterations != 3 {
+		t.Errorf("Expected command line to override .ralphrc, got %d iterations", cfg.Iterations)
+	}
+}
+
+func TestAgentSuccessCodesFlag(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph"}
+	if cfg := config.ParseFlags(); len(cfg.AgentSuccessCodes) != 1 || cfg.AgentSuccessCodes[0] != 0 {
+		t.Errorf("Expected only 0 as a success code by default, got %v", cfg.AgentSuccessCodes)
+	}
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph", "--agent-success-codes", "0, 2,"}
+	cfg := config.ParseFlags()
+	if len(cfg.AgentSuccessCodes) != 2 || cfg.AgentSuccessCodes[0] != 0 || cfg.AgentSuccessCodes[1] != 2 {
+		t.Errorf("Expected [0 2], got %v", cfg.AgentSuccessCodes)
+	}
+}
+
+func TestValidate_AgentSuccessCodes(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected the default success codes to be valid, got %v", err)
+	}
+
+	cfg.AgentSuccessCodes = []int{0, 256}
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--agent-success-codes") {
+		t.Errorf("Expected an out-of-range exit code to be rejected, got %v", err)
+	}
+
+	cfg.AgentSuccessCodes = []int{-1}
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--agent-success-codes") {
+		t.Errorf("Expected a negative exit code to be rejected, got %v", err)
+	}
+}
+
+func TestValidate_Agent(t *testing.T) {
+	for _, agent := range loop.BackendNames() {
+		cfg := config.NewConfig()
+		cfg.SpecFolder = ""
+		cfg.Agent = agent
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Expected --agent %s to be valid, got %v", agent, err)
+		}
+	}
+
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.Agent = "gpt-engineer"
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--agent") || !contains(err.Error(), "cursor-agent") {
+		t.Errorf("Expected an unknown agent to be rejected with the known ones listed, got %v", err)
+	}
+}
+
+func TestValidate_ProgressToRequiresCLI(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.ProgressTo = "stderr"
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--progress-to") {
+		t.Errorf("Expected --progress-to without --cli to be rejected, got %v", err)
+	}
+
+	cfg.CLI = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected --progress-to with --cli to be valid, got %v", err)
+	}
+}
+
+func TestExcludeDirsFlag(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph"}
+	if cfg := config.ParseFlags(); len(cfg.ExcludeDirs) != 0 {
+		t.Errorf("Expected no excluded dirs by default, got %v", cfg.ExcludeDirs)
+	}
+
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	os.Args = []string{"ralph", "--exclude-dirs", "node_modules, dist,,"}
+	cfg := config.ParseFlags()
+	if len(cfg.ExcludeDirs) != 2 || cfg.ExcludeDirs[0] != "node_modules" || cfg.ExcludeDirs[1] != "dist" {
+		t.Errorf("Expected [node_modules dist], got %v", cfg.ExcludeDirs)
+	}
+}
+
+func TestStartTime(t *testing.T) {
+	now := time.Date(2026, 3, 22, 14, 30, 0, 0, time.Local)
+
+	cfg := config.NewConfig()
+	if start := cfg.StartTime(now); !start.IsZero() {
+		t.Errorf("Expected no start time without a schedule, got %v", start)
+	}
+
+	cfg.StartDelay = 2 * time.Hour
+	if start := cfg.StartTime(now); !start.Equal(now.Add(2 * time.Hour)) {
+		t.Errorf("Expected --start-delay 2h to start at 16:30, got %v", start)
+	}
+
+	cfg.StartDelay = 0
+	cfg.StartAt = "23:15"
+	if start := cfg.StartTime(now); !start.Equal(time.Date(2026, 3, 22, 23, 15, 0, 0, time.Local)) {
+		t.Errorf("Expected --start-at 23:15 to start later today, got %v", start)
+	}
+	cfg.StartAt = "03:00"
+	if start := cfg.StartTime(now); !start.Equal(time.Date(2026, 3, 23, 3, 0, 0, 0, time.Local)) {
+		t.Errorf("Expected --start-at 03:00 to start tomorrow, got %v", start)
+	}
+	cfg.StartAt = "14:30"
+	if start := cfg.StartTime(now); !start.Equal(now.AddDate(0, 0, 1)) {
+		t.Errorf("Expected --start-at at the current minute to start tomorrow, got %v", start)
+	}
+}
+
+func TestValidate_StartSchedule(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		delay time.Duration
+		at    string
+		want  string
+	}{
+		{"negative delay", -time.Minute, "", "--start-delay"},
+		{"both", time.Hour, "03:00", "cannot be used together"},
+		{"not a clock time", 0, "3am", "--start-at"},
+		{"out of range", 0, "24:30", "--start-at"},
+	} {
+		cfg := config.NewConfig()
+		cfg.SpecFolder = ""
+		cfg.StartDelay = tc.delay
+		cfg.StartAt = tc.at
+		if err := cfg.Validate(); err == nil || !contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error mentioning %q, got %v", tc.name, tc.want, err)
+		}
+	}
+
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.StartAt = "3:05"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected --start-at 3:05 to be valid, got %v", err)
+	}
+}
+
+func TestValidate_Listen(t *testing.T) {
+	for _, addr := range []string{":7777", "127.0.0.1:7777", "[::1]:0"} {
+		cfg := config.NewConfig()
+		cfg.SpecFolder = ""
+		cfg.Listen = addr
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("--listen %q: unexpected error %v", addr, err)
+		}
+	}
+
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.Listen = "7777"
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--listen") {
+		t.Errorf("Expected --listen validation error, got %v", err)
+	}
+
+	cfg = config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.ListenToken = "s3cret"
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--listen-token requires --listen") {
+		t.Errorf("Expected --listen-token to require --listen, got %v", err)
+	}
+}
+
+func TestValidate_NotifyWebhook(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SpecFolder = ""
+	cfg.NotifyWebhook = "https://hooks.example.com/ralph"
+	cfg.NotifyEvents = []string{"error", "run_complete"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	cfg.NotifyWebhook = "hooks.example.com"
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--notify-webhook") {
+		t.Errorf("Expected --notify-webhook validation error, got %v", err)
+	}
+
+	cfg.NotifyWebhook = "https://hooks.example.com/ralph"
+	cfg.NotifySlack = "hooks.slack.com/services/T0/B0/x"
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--notify-slack") {
+		t.Errorf("Expected --notify-slack validation error, got %v", err)
+	}
+	cfg.NotifySlack = ""
+
+	cfg.NotifyWebhook = "https://hooks.example.com/ralph"
+	cfg.NotifyEvents = []string{"error", "loop_done"}
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "loop_done") {
+		t.Errorf("Expected an unknown event to be rejected, got %v", err)
+	}
+
+	cfg.NotifyWebhook = ""
+	cfg.NotifyEvents = []string{"error"}
+	if err := cfg.Validate(); err == nil || !contains(err.Error(), "--notify-events requires --notify-webhook") {
+		t.Errorf("Expected --notify-events to require --notify-webhook, got %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudosai/ralph-go/internal/control"
+	"github.com/cloudosai/ralph-go/internal/loop"
+	"github.com/cloudosai/ralph-go/internal/runlog"
+	"github.com/cloudosai/ralph-go/internal/stats"
+)
+
+// controlRequest sends a request to h and decodes the JSON reply into v.
+func controlRequest(t *testing.T, h http.Handler, method, target string, v interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: invalid JSON %q: %v", method, target, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestControlStatus(t *testing.T) {
+	ts := stats.NewTokenStats()
+	ts.AddUsage(100, 50, 0, 0)
+	ts.AddCost(0.5)
+	srv := control.New("run-1", "", ts.Snapshot)
+	h := srv.Handler()
+
+	var st control.Status
+	if code := controlRequest(t, h, "GET", "/status", &st); code != http.StatusOK || st.State != "starting" || st.RunID != "run-1" {
+		t.Errorf("Expected a starting run before the loop is set, got %d %+v", code, st)
+	}
+	var errBody map[string]string
+	if code := controlRequest(t, h, "POST", "/pause", &errBody); code != http.StatusServiceUnavailable || errBody["error"] == "" {
+		t.Errorf("Expected 503 controlling a loop that has not started, got %d %v", code, errBody)
+	}
+
+	srv.SetLoop(loop.New(loop.Config{Iterations: 5}))
+	srv.Record(runlog.Entry{Type: "loop", Iteration: 2, Content: "======= LOOP 2/5 ======="})
+	controlRequest(t, h, "GET", "/status", &st)
+	if st.State != "stopped" || st.Iteration != 2 || st.Total != 5 || st.Stats.TotalTokensCount != 150 || st.Stats.TotalCostUSD != 0.5 {
+		t.Errorf("Unexpected status: %+v", st)
+	}
+	if code := controlRequest(t, h, "GET", "/stop", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected actions to need POST, got %d", code)
+	}
+}
+
+func TestControlIterations(t *testing.T) {
+	l := loop.New(loop.Config{Iterations: 5})
+	srv := control.New("run-1", "", nil)
+	srv.SetLoop(l)
+	srv.Record(runlog.Entry{Type: "loop", Iteration: 3})
+	h := srv.Handler()
+
+	var st control.Status
+	if code := controlRequest(t, h, "POST", "/iterations?n=8", &st); code != http.StatusOK || st.Total != 8 || l.GetIterations() != 8 {
+		t.Errorf("Expected 8 iterations, got %d %+v", code, st)
+	}
+	for _, n := range []string{"2", "0", "x", ""} {
+		var errBody map[string]string
+		if code := controlRequest(t, h, "POST", "/iterations?n="+n, &errBody); code != http.StatusBadRequest || errBody["error"] == "" {
+			t.Errorf("n=%q: expected 400, got %d %v", n, code, errBody)
+		}
+	}
+	if l.GetIterations() != 8 {
+		t.Errorf("Rejected requests changed the iterations to %d", l.GetIterations())
+	}
+}
+
+func TestControlPause(t *testing.T) {
+	l := loop.New(loop.Config{Iterations: 2, Prompt: "test", CommandBuilder: mockMediumSlowCommandBuilder, SleepDuration: 10 * time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+	for msg := range l.Output() {
+		if msg.Type == "output" && strings.Contains(msg.Content, `"type":"system"`) {
+			break
+		}
+	}
+	go func() {
+		for range l.Output() {
+		}
+	}()
+	srv := control.New("run-1", "", nil)
+	srv.SetLoop(l)
+	h := srv.Handler()
+
+	var errBody map[string]string
+	if code := controlRequest(t, h, "POST", "/pause?now=soon", &errBody); code != http.StatusBadRequest || errBody["error"] == "" {
+		t.Errorf("Expected 400 for a bad now, got %d %v", code, errBody)
+	}
+	var st control.Status
+	if controlRequest(t, h, "POST", "/pause", &st); st.State != "pausing" {
+		t.Errorf("Expected the first pause to wait for the iteration, got %q", st.State)
+	}
+	if controlRequest(t, h, "POST", "/pause", &st); st.State != "paused" {
+		t.Errorf("Expected a second pause to interrupt the iteration, got %q", st.State)
+	}
+	if controlRequest(t, h, "POST", "/resume", &st); st.State != "running" {
+		t.Errorf("Expected resume to run the loop again, got %q", st.State)
+	}
+	if controlRequest(t, h, "POST", "/pause?now=true", &st); st.State != "paused" {
+		t.Errorf("Expected now=true to pause at once, got %q", st.State)
+	}
+}
+
+func TestControlMessages(t *testing.T) {
+	srv := control.New("run-1", "", nil)
+	for _, c := range []string{"one", "two", "three"} {
+		srv.Record(runlog.Entry{Type: "assistant", Content: c})
+	}
+	var entries []runlog.Entry
+	if code := controlRequest(t, srv.Handler(), "GET", "/messages?n=2", &entries); code != http.StatusOK {
+		t.Fatalf("GET /messages: %d", code)
+	}
+	if len(entries) != 2 || entries[0].Content != "two" || entries[1].Content != "three" {
+		t.Errorf("Expected the last 2 messages oldest first, got %+v", entries)
+	}
+}
+
+func TestControlMetrics(t *testing.T) {
+	ts := stats.NewTokenStats()
+	ts.AddUsage(100, 50, 20, 30)
+	ts.AddCost(0.25)
+	ts.AddHibernation(90 * time.Second)
+	srv := control.New("run-1", "", ts.Snapshot)
+	srv.SetLoop(loop.New(loop.Config{Iterations: 5}))
+	srv.Record(runlog.Entry{Type: "loop", Iteration: 3})
+	srv.CountIteration()
+	srv.CountIteration()
+	srv.CountError()
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("GET /metrics: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE ralph_cost_usd_total counter\nralph_cost_usd_total 0.25\n",
+		`ralph_info{run_id="run-1"} 1`,
+		`ralph_state{state="stopped"} 1`,
+		`ralph_state{state="running"} 0`,
+		"ralph_iteration 3\n",
+		"ralph_iterations 5\n",
+		"ralph_iterations_completed_total 2\n",
+		"ralph_errors_total 1\n",
+		`ralph_tokens_total{type="input"} 100`,
+		`ralph_tokens_total{type="cache_read"} 30`,
+		"ralph_hibernations_total 1\n",
+		"ralph_hibernate_seconds_total 90\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in /metrics, got:\n%s", want, body)
+		}
+	}
+
+	// A nil server, as without --listen, ignores the counts
+	var none *control.Server
+	none.CountIteration()
+	none.CountError()
+}
+
+func TestControlToken(t *testing.T) {
+	h := control.New("run-1", "s3cret", nil).Handler()
+	if code := controlRequest(t, h, "GET", "/status", nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the token, got %d", code)
+	}
+	req := httptest.NewRequest("GET", "/status", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 with the token, got %d", rec.Code)
+	}
+}
+
+func TestControlEvents(t *testing.T) {
+	srv := control.New("run-1", "", nil)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	// The subscription is registered before the headers are sent
+	srv.Record(runlog.Entry{Type: "assistant", Iteration: 1, Content: "hello"})
+	lines := make(chan string)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+		close(lines)
+	}()
+	var got []string
+	timeout := time.After(5 * time.Second)
+	for len(got) < 2 {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatalf("Stream ended early after %q", got)
+			}
+			got = append(got, line)
+		case <-timeout:
+			t.Fatalf("Timed out waiting for the event, got %q", got)
+		}
+	}
+	if got[0] != "event: assistant" || !strings.HasPrefix(got[1], "data: {") || !strings.Contains(got[1], `"content":"hello"`) {
+		t.Errorf("Unexpected event lines %q", got)
+	}
+}
+
+func TestControlListenAddr(t *testing.T) {
+	for _, tt := range []struct {
+		addr, token, want string
+		ok                bool
+	}{
+		{":7777", "", "127.0.0.1:7777", true},
+		{"127.0.0.1:7777", "", "127.0.0.1:7777", true},
+		{"localhost:7777", "", "localhost:7777", true},
+		{"[::1]:7777", "", "[::1]:7777", true},
+		{"0.0.0.0:7777", "", "", false},
+		{"192.168.1.5:7777", "", "", false},
+		{"0.0.0.0:7777", "s3cret", "0.0.0.0:7777", true},
+		{"7777", "", "", false},
+	} {
+		got, err := control.ListenAddr(tt.addr, tt.token)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ListenAddr(%q, %q) = %q, %v; want %q, ok=%v", tt.addr, tt.token, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestControlRejectsBrowserRequests tests that without a token, requests a
+// web page makes (with an Origin) can't steer the run
+func TestControlRejectsBrowserRequests(t *testing.T) {
+	h := control.New("run-1", "", nil).Handler()
+	req := httptest.NewRequest("POST", "/stop", nil)
+	req.Header.Set("Origin", "https://example.com")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a browser request, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cloudosai/ralph-go/internal/loop"
+)
+
+func splitAll(t *testing.T, format, input string) []string {
+	t.Helper()
+	var records []string
+	if err := loop.SplitStream(strings.NewReader(input), format, func(r string) {
+		records = append(records, r)
+	}); err != nil {
+		t.Fatalf("SplitStream(%s) failed: %v", format, err)
+	}
+	return records
+}
+
+func TestSplitStreamJSONL(t *testing.T) {
+	input := `{"type":"system","session_id":"s1"}` + "\n" + `{"type":"result","total_cost_usd":0.01}` + "\n"
+	want := []string{`{"type":"system","session_id":"s1"}`, `{"type":"result","total_cost_usd":0.01}`}
+	for _, format := range []string{loop.StreamFormatJSONL, ""} {
+		if got := splitAll(t, format, input); !reflect.DeepEqual(got, want) {
+			t.Errorf("format %q: got %q, want %q", format, got, want)
+		}
+	}
+}
+
+func TestSplitStreamSSE(t *testing.T) {
+	input := ": keep-alive\n" +
+		"event: message\n" +
+		"data: {\"type\": \"system\", \"session_id\": \"s1\"}\n" +
+		"\n" +
+		"id: 2\r\n" +
+		"data: {\"type\":\"assistant\",\r\n" +
+		"data:  \"message\":{\"content\":[]}}\r\n" +
+		"\r\n" +
+		"data: {\"type\":\"result\"}\n" +
+		"\n" +
+		"data: [DONE]\n"
+	want := []string{
+		`{"type":"system","session_id":"s1"}`,
+		`{"type":"assistant","message":{"content":[]}}`,
+		`{"type":"result"}`,
+	}
+	if got := splitAll(t, loop.StreamFormatSSE, input); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSplitStreamSSEPassesThroughPlainLines(t *testing.T) {
+	got := splitAll(t, loop.StreamFormatSSE, "Error: connection reset\n")
+	if want := []string{"Error: connection reset"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSplitStreamConcat(t *testing.T) {
+	input := `{"type":"system","session_id":"s1"}{"type":"assistant",` + "\n" +
+		`  "message": {"content": [{"type": "text", "text": "hi\nthere"}]}}` + "\n\n" +
+		`{"type":"result"}`
+	want := []string{
+		`{"type":"system","session_id":"s1"}`,
+		`{"type":"assistant","message":{"content":[{"type":"text","text":"hi\nthere"}]}}`,
+		`{"type":"result"}`,
+	}
+	if got := splitAll(t, loop.StreamFormatConcat, input); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSplitStreamConcatReportsInvalidJSON(t *testing.T) {
+	var records []string
+	err := loop.SplitStream(strings.NewReader(`{"type":"system"} not json`), loop.StreamFormatConcat, func(r string) {
+		records = append(records, r)
+	})
+	if err == nil {
+		t.Fatal("Expected an error for trailing garbage")
+	}
+	if len(records) != 1 {
+		t.Errorf("Expected the valid record before the garbage, got %q", records)
+	}
+}
@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudosai/ralph-go/internal/hooks"
+)
+
+func TestHookRunCapturesOutputAndExitCode(t *testing.T) {
+	res := hooks.Run(context.Background(), "echo out; echo err >&2; exit 3", "", time.Minute)
+	if res.Passed() {
+		t.Fatal("Expected a hook exiting 3 to fail")
+	}
+	if res.ExitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", res.ExitCode)
+	}
+	if !strings.Contains(res.Output, "out") || !strings.Contains(res.Output, "err") {
+		t.Errorf("Expected stdout and stderr in output, got %q", res.Output)
+	}
+	if res.Status() != "failed (exit 3)" {
+		t.Errorf("Unexpected status %q", res.Status())
+	}
+}
+
+func TestHookRunPassesInDir(t *testing.T) {
+	dir := t.TempDir()
+	res := hooks.Run(context.Background(), "touch ran", dir, time.Minute)
+	if !res.Passed() || res.ExitCode != 0 || res.Status() != "passed" {
+		t.Fatalf("Expected hook to pass, got %+v", res)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ran")); err != nil {
+		t.Errorf("Expected hook to run in %s: %v", dir, err)
+	}
+}
+
+func TestHookRunTimesOut(t *testing.T) {
+	start := time.Now()
+	res := hooks.Run(context.Background(), "sleep 10", "", 100*time.Millisecond)
+	if res.Passed() || !res.TimedOut {
+		t.Fatalf("Expected the hook to time out, got %+v", res)
+	}
+	if res.Status() != "timed out" {
+		t.Errorf("Unexpected status %q", res.Status())
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Timed-out hook took %s to return", time.Since(start))
+	}
+}
+
+func TestHookResultTailKeepsLastLines(t *testing.T) {
+	var b strings.Builder
+	for i := 1; i <= 25; i++ {
+		b.WriteString(strings.Repeat("x", i) + "\n")
+	}
+	tail := hooks.HookResult{Output: b.String()}.Tail()
+	lines := strings.Split(tail, "\n")
+	if len(lines) != 21 {
+		t.Fatalf("Expected a note plus 20 lines, got %d: %q", len(lines), tail)
+	}
+	if lines[0] != "…(5 earlier lines)" {
+		t.Errorf("Unexpected note %q", lines[0])
+	}
+	if lines[20] != strings.Repeat("x", 25) {
+		t.Errorf("Expected the last line kept, got %q", lines[20])
+	}
+	if got := (hooks.HookResult{Output: "  \n"}).Tail(); got != "" {
+		t.Errorf("Expected empty tail for blank output, got %q", got)
+	}
+}
@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cloudosai/ralph-go/internal/lockfile"
+)
+
+func lockPID(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading lock: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatalf("lock holds %q, not a PID", data)
+	}
+	return pid
+}
+
+func TestLockAcquireAndRelease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), lockfile.Name)
+	lock, err := lockfile.Acquire(path, false)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	if pid := lockPID(t, path); pid != os.Getpid() {
+		t.Errorf("Expected the lock to hold our PID %d, got %d", os.Getpid(), pid)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Expected Release to remove the lock file")
+	}
+
+	var none *lockfile.Lock
+	if err := none.Release(); err != nil {
+		t.Errorf("Expected releasing a nil lock to be a no-op, got %v", err)
+	}
+}
+
+func TestLockHeldByLiveProcess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), lockfile.Name)
+	// Our parent (the go tool) is alive for the whole test
+	os.WriteFile(path, []byte(strconv.Itoa(os.Getppid())+"\n"), 0644)
+
+	if _, err := lockfile.Acquire(path, false); !errors.Is(err, lockfile.ErrHeld) {
+		t.Fatalf("Expected ErrHeld while a live process holds the lock, got %v", err)
+	}
+	if pid := lockPID(t, path); pid != os.Getppid() {
+		t.Errorf("A refused Acquire must not touch the lock, got PID %d", pid)
+	}
+
+	lock, err := lockfile.Acquire(path, true)
+	if err != nil {
+		t.Fatalf("Expected force to take the lock over, got %v", err)
+	}
+	defer lock.Release()
+	if pid := lockPID(t, path); pid != os.Getpid() {
+		t.Errorf("Expected the forced lock to hold our PID, got %d", pid)
+	}
+}
+
+func TestLockStaleIsTakenOver(t *testing.T) {
+	// A process that has already exited
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot run true: %v", err)
+	}
+	dead := cmd.Process.Pid
+
+	for name, content := range map[string]string{
+		"dead process": strconv.Itoa(dead) + "\n",
+		"garbage":      "not a pid",
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), lockfile.Name)
+			os.WriteFile(path, []byte(content), 0644)
+			lock, err := lockfile.Acquire(path, false)
+			if err != nil {
+				t.Fatalf("Expected a stale lock to be taken over, got %v", err)
+			}
+			defer lock.Release()
+			if pid := lockPID(t, path); pid != os.Getpid() {
+				t.Errorf("Expected our PID in the lock, got %d", pid)
+			}
+		})
+	}
+}
+
+func TestLockReleaseLeavesTakenOverLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), lockfile.Name)
+	lock, err := lockfile.Acquire(path, false)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	// Another run forced its way in
+	os.WriteFile(path, []byte(strconv.Itoa(os.Getppid())+"\n"), 0644)
+
+	lock.Release()
+	if pid := lockPID(t, path); pid != os.Getppid() {
+		t.Errorf("Release must leave another run's lock alone, got PID %d", pid)
+	}
+}
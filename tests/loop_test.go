@@ -8,7 +8,11 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -122,6 +126,25 @@ func TestHelperProcess(t *testing.T) {
 		}
 		os.Stdout.WriteString(`{"type":"system","session_id":"capture-session","subtype":"init"}` + "\n")
 		os.Stdout.WriteString(`{"type":"result","total_cost_usd":0.001}` + "\n")
+	case "claude-crash-once":
+		// Crashes (exits non-zero without a result) on the first run, then
+		// succeeds. CRASH_STATE_PATH records the first run and the args used.
+		statePath := os.Getenv("CRASH_STATE_PATH")
+		if _, err := os.Stat(statePath); os.IsNotExist(err) {
+			os.WriteFile(statePath, []byte("crashed"), 0644)
+			os.Stdout.WriteString(`{"type":"system","session_id":"crash-session","subtype":"init"}` + "\n")
+			os.Exit(2)
+		}
+		os.WriteFile(statePath, []byte(strings.Join(args[1:], " ")), 0644)
+		os.Stdout.WriteString(`{"type":"result","total_cost_usd":0.001}` + "\n")
+	case "claude-error-result":
+		// Reports an error result, then exits non-zero: an error, not a crash
+		os.Stdout.WriteString(`{"type":"result","is_error":true,"result":"tool failed"}` + "\n")
+		os.Exit(1)
+	case "claude-exit-warning":
+		// Completes normally but exits 2, as some backends do on warnings
+		os.Stdout.WriteString(`{"type":"result","total_cost_usd":0.001}` + "\n")
+		os.Exit(2)
 	case "echo":
 		// Simple echo command for basic testing
 		os.Stdout.WriteString(strings.Join(args[1:], " ") + "\n")
@@ -1346,6 +1369,202 @@ func TestPauseResumeRetriesSameIteration(t *testing.T) {
 	}
 }
 
+// TestPauseAfterIteration tests that PauseAfterIteration lets the running
+// iteration finish, pauses before the next one, and that resuming moves on
+// to the next iteration instead of retrying.
+func TestPauseAfterIteration(t *testing.T) {
+	l := loop.New(loop.Config{
+		Iterations:     3,
+		Prompt:         "test",
+		CommandBuilder: mockMediumSlowCommandBuilder,
+		SleepDuration:  10 * time.Millisecond,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	l.Start(ctx)
+	output := l.Output()
+
+	// Wait for iteration 1 to start executing, then ask for a pause
+	for msg := range output {
+		if msg.Type == "output" && strings.Contains(msg.Content, `"type":"system"`) {
+			break
+		}
+	}
+	l.PauseAfterIteration()
+	if !l.IsPausePending() || l.IsPaused() {
+		t.Fatalf("Expected a pending pause while the iteration runs, got pending=%v paused=%v", l.IsPausePending(), l.IsPaused())
+	}
+
+	finished := false
+	for msg := range output {
+		if msg.Type == "output" && strings.Contains(msg.Content, `"type":"result"`) {
+			finished = true
+		}
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "STOPPED") {
+			if msg.Loop != 2 {
+				t.Errorf("Expected the pause before iteration 2, got %d", msg.Loop)
+			}
+			break
+		}
+	}
+	if !finished {
+		t.Error("Expected iteration 1 to finish before the pause")
+	}
+	if !l.IsPaused() || l.IsPausePending() {
+		t.Errorf("Expected the loop paused, got pending=%v paused=%v", l.IsPausePending(), l.IsPaused())
+	}
+
+	l.Resume()
+	for msg := range output {
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "/") {
+			if msg.Loop != 2 || strings.Contains(msg.Content, "RETRY") {
+				t.Errorf("Expected iteration 2 after resuming, got %q", msg.Content)
+			}
+			break
+		}
+	}
+}
+
+// TestPauseEscalatesPendingPause tests that Pause interrupts an iteration a
+// pause is already pending for, so it is retried on resume, and that Resume
+// cancels a pending pause.
+func TestPauseEscalatesPendingPause(t *testing.T) {
+	l := loop.New(loop.Config{
+		Iterations:     2,
+		Prompt:         "test",
+		CommandBuilder: mockMediumSlowCommandBuilder,
+		SleepDuration:  10 * time.Millisecond,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	l.Start(ctx)
+	output := l.Output()
+
+	for msg := range output {
+		if msg.Type == "output" && strings.Contains(msg.Content, `"type":"system"`) {
+			break
+		}
+	}
+	l.PauseAfterIteration()
+	l.Pause()
+	if l.IsPausePending() || !l.IsPaused() {
+		t.Fatalf("Expected Pause to take over the pending pause, got pending=%v paused=%v", l.IsPausePending(), l.IsPaused())
+	}
+	for msg := range output {
+		if msg.Type == "output" && strings.Contains(msg.Content, `"type":"result"`) {
+			t.Error("Expected the iteration to be interrupted before its result")
+		}
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "STOPPED") {
+			if msg.Loop != 1 {
+				t.Errorf("Expected iteration 1 interrupted, got %d", msg.Loop)
+			}
+			break
+		}
+	}
+
+	// Resume, then ask for a pause and change our mind: the run completes
+	l.Resume()
+	for msg := range output {
+		if msg.Type == "output" && strings.Contains(msg.Content, `"type":"system"`) {
+			break
+		}
+	}
+	l.PauseAfterIteration()
+	l.Resume()
+	if l.IsPausePending() {
+		t.Error("Expected Resume to cancel the pending pause")
+	}
+	for msg := range output {
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "STOPPED") {
+			t.Errorf("Expected no pause after cancelling it, got %q", msg.Content)
+		}
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+}
+
+// TestDrainFinishesIteration tests that Drain lets the running iteration
+// finish and save its state, then ends the run instead of starting another.
+func TestDrainFinishesIteration(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	l := loop.New(loop.Config{
+		Iterations:     3,
+		Prompt:         "test",
+		CommandBuilder: mockMediumSlowCommandBuilder,
+		SleepDuration:  10 * time.Millisecond,
+		StatePath:      statePath,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	l.Start(ctx)
+	output := l.Output()
+
+	for msg := range output {
+		if msg.Type == "output" && strings.Contains(msg.Content, `"type":"system"`) {
+			break
+		}
+	}
+	l.Drain()
+	if !l.IsDraining() {
+		t.Error("Expected IsDraining after Drain")
+	}
+
+	finished, shutdown := false, false
+	for msg := range output {
+		switch {
+		case msg.Type == "output" && strings.Contains(msg.Content, `"type":"result"`):
+			finished = true
+		case msg.Type == "loop_marker" && strings.Contains(msg.Content, "SHUTTING DOWN AFTER ITERATION 1"):
+			shutdown = true
+		case msg.Type == "loop_marker" && msg.Loop > 1:
+			t.Errorf("Expected no iteration after the drain, got %q", msg.Content)
+		case msg.Type == "complete":
+			t.Errorf("Expected a drained run not to complete, got %q", msg.Content)
+		}
+	}
+	if !finished || !shutdown {
+		t.Errorf("Expected iteration 1 to finish and the run to shut down, got finished=%v shutdown=%v", finished, shutdown)
+	}
+	state, err := loop.LoadRunState(statePath)
+	if err != nil {
+		t.Fatalf("LoadRunState: %v", err)
+	}
+	if state.Iteration != 1 || state.Complete {
+		t.Errorf("Expected iteration 1 recorded for resume, got %+v", state)
+	}
+}
+
+// TestDrainIdleLoop tests that Drain ends a loop with no iteration under way,
+// here one waiting to confirm its first iteration, at once.
+func TestDrainIdleLoop(t *testing.T) {
+	l := loop.New(loop.Config{
+		Iterations:     3,
+		Prompt:         "test",
+		CommandBuilder: mockCommandBuilder,
+		ConfirmStart:   true,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	l.Start(ctx)
+	output := l.Output()
+	for msg := range output {
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, loop.ConfirmMarker) {
+			break
+		}
+	}
+
+	l.Drain()
+	for msg := range output {
+		if msg.Type == "output" {
+			t.Errorf("Expected no iteration to run after the drain, got %q", msg.Content)
+		}
+	}
+	if ctx.Err() != nil {
+		t.Error("Expected the loop to end before the timeout")
+	}
+}
+
 // TestFreshLoopAfterStop tests that creating a new loop after stopping starts fresh.
 // This simulates the "quit, come back, don't resume" scenario from the spec.
 func TestFreshLoopAfterStop(t *testing.T) {
@@ -2082,7 +2301,7 @@ func TestHibernateRetryDecrementIteration(t *testing.T) {
 	}
 
 	// Hibernate briefly while the iteration is still running (medium-slow takes ~200ms)
-	l.Hibernate(time.Now().Add(50 * time.Millisecond))
+	l.Hibernate(time.Now().Add(300 * time.Millisecond))
 
 	// Verify: hibernate/wake cycle occurs, loop retries and completes all iterations
 	loopStartPattern := regexp.MustCompile(`LOOP \d+/\d+`)
@@ -2124,6 +2343,183 @@ func TestHibernateRetryDecrementIteration(t *testing.T) {
 	}
 }
 
+// TestTotalRetriesStopsRun tests that once the run-wide retry budget is spent,
+// the next hibernate ends the run instead of retrying the iteration again.
+func TestTotalRetriesStopsRun(t *testing.T) {
+	cfg := loop.Config{
+		Iterations:     3,
+		Prompt:         "test",
+		CommandBuilder: mockMediumSlowCommandBuilder,
+		SleepDuration:  10 * time.Millisecond,
+		TotalRetries:   1,
+	}
+
+	l := loop.New(cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	l.Start(ctx)
+
+	// Hibernate each time an iteration starts running; only the first
+	// hibernate fits in the budget
+	hibernates := 0
+	exhausted := false
+	completed := false
+	for msg := range l.Output() {
+		if msg.Type == "output" && strings.Contains(msg.Content, `"type":"system"`) && hibernates < 2 {
+			hibernates++
+			l.Hibernate(time.Now().Add(20 * time.Millisecond))
+		}
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "RETRY BUDGET EXHAUSTED") {
+			exhausted = true
+			if msg.Loop != 1 {
+				t.Errorf("Expected the budget to run out in iteration 1, got %d", msg.Loop)
+			}
+		}
+		if msg.Type == "complete" {
+			completed = true
+			cancel()
+		}
+	}
+
+	if !exhausted {
+		t.Error("Expected a RETRY BUDGET EXHAUSTED marker")
+	}
+	if !completed {
+		t.Error("Expected the run to complete after the budget ran out")
+	}
+	if l.GetIterations() != 0 {
+		t.Errorf("Expected the run to end before the interrupted iteration, got %d iterations", l.GetIterations())
+	}
+}
+
+// TestRetryBudgetSharedBetweenLoops tests that loops given the same
+// RetryBudget, like plan-and-build's phases, spend from one budget.
+func TestRetryBudgetSharedBetweenLoops(t *testing.T) {
+	budget := &loop.RetryBudget{}
+	run := func() (exhausted bool) {
+		l := loop.New(loop.Config{
+			Iterations:     1,
+			Prompt:         "test",
+			CommandBuilder: mockMediumSlowCommandBuilder,
+			SleepDuration:  10 * time.Millisecond,
+			TotalRetries:   1,
+			RetryBudget:    budget,
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		l.Start(ctx)
+
+		// Hibernate the first attempt only
+		hibernated := false
+		for msg := range l.Output() {
+			if msg.Type == "output" && strings.Contains(msg.Content, `"type":"system"`) && !hibernated {
+				hibernated = true
+				l.Hibernate(time.Now().Add(20 * time.Millisecond))
+			}
+			if msg.Type == "loop_marker" && strings.Contains(msg.Content, "RETRY BUDGET EXHAUSTED") {
+				exhausted = true
+			}
+			if msg.Type == "complete" {
+				cancel()
+			}
+		}
+		return exhausted
+	}
+
+	if run() {
+		t.Error("Expected the first loop's retry to fit in the budget")
+	}
+	if !run() {
+		t.Error("Expected the second loop to find the shared budget spent")
+	}
+}
+
+// TestPaceDoesNotSpendRetries tests that waiting out cost pacing emits a
+// PACED marker and leaves the run-wide retry budget untouched.
+func TestPaceDoesNotSpendRetries(t *testing.T) {
+	cfg := loop.Config{
+		Iterations:     1,
+		Prompt:         "test",
+		CommandBuilder: mockMediumSlowCommandBuilder,
+		SleepDuration:  10 * time.Millisecond,
+		TotalRetries:   1,
+	}
+
+	l := loop.New(cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	l.Start(ctx)
+
+	// Pace the first attempt, then rate limit the second: the rate limit
+	// is the only retry charged against the budget
+	starts := 0
+	paced := false
+	hibernated := false
+	for msg := range l.Output() {
+		if msg.Type == "output" && strings.Contains(msg.Content, `"type":"system"`) {
+			starts++
+			switch starts {
+			case 1:
+				l.Pace(time.Now().Add(20 * time.Millisecond))
+			case 2:
+				l.Hibernate(time.Now().Add(20 * time.Millisecond))
+			}
+		}
+		if msg.Type == "loop_marker" {
+			paced = paced || strings.Contains(msg.Content, "PACED")
+			hibernated = hibernated || strings.Contains(msg.Content, "HIBERNATING")
+			if strings.Contains(msg.Content, "RETRY BUDGET EXHAUSTED") {
+				t.Error("Did not expect pacing to spend the retry budget")
+			}
+		}
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+
+	if !paced {
+		t.Error("Expected a PACED marker")
+	}
+	if !hibernated {
+		t.Error("Expected a HIBERNATING marker for the rate limit")
+	}
+	if l.IsPacing() {
+		t.Error("Expected pacing to clear after waking")
+	}
+}
+
+// TestLoopNewBackoffUsesMaxRetries tests that the per-iteration backoff limit
+// comes from Config.MaxRetries, falling back to the default.
+func TestLoopNewBackoffUsesMaxRetries(t *testing.T) {
+	if got := loop.New(loop.Config{MaxRetries: 3}).NewBackoff().MaxRetries(); got != 3 {
+		t.Errorf("Expected 3 max retries, got %d", got)
+	}
+	if got := loop.New(loop.Config{}).NewBackoff().MaxRetries(); got != loop.DefaultMaxRetries {
+		t.Errorf("Expected the default %d max retries, got %d", loop.DefaultMaxRetries, got)
+	}
+}
+
+// TestLoopNewBackoffNoSleepOnError tests that NoSleepOnError zeroes the
+// backoff delays while keeping the retry cap.
+func TestLoopNewBackoffNoSleepOnError(t *testing.T) {
+	b := loop.New(loop.Config{MaxRetries: 2, NoSleepOnError: true}).NewBackoff()
+	for i := 1; i <= 2; i++ {
+		d, retry, exceeded := b.Next()
+		if d != 0 || retry != i || exceeded {
+			t.Errorf("Retry %d: expected an immediate retry, got %s (retry %d, exceeded %v)", i, d, retry, exceeded)
+		}
+	}
+	if _, _, exceeded := b.Next(); !exceeded {
+		t.Error("Expected the retry cap to still apply")
+	}
+
+	if d, _, _ := loop.New(loop.Config{}).NewBackoff().Next(); d == 0 {
+		t.Error("Expected a backoff delay without NoSleepOnError")
+	}
+}
+
 // TestHibernateRetryEmitsRetryMarker tests that after a hibernate+retry cycle,
 // the retried iteration emits a loop_marker containing "(RETRY)" in its content.
 func TestHibernateRetryEmitsRetryMarker(t *testing.T) {
@@ -2149,7 +2545,7 @@ func TestHibernateRetryEmitsRetryMarker(t *testing.T) {
 	}
 
 	// Hibernate briefly while the iteration is still running
-	l.Hibernate(time.Now().Add(50 * time.Millisecond))
+	l.Hibernate(time.Now().Add(300 * time.Millisecond))
 
 	// Collect all loop_marker messages after hibernate
 	retryMarkerFound := false
@@ -2183,3 +2579,2176 @@ func TestHibernateRetryEmitsRetryMarker(t *testing.T) {
 		t.Error("Expected a normal LOOP marker after the RETRY marker (second iteration)")
 	}
 }
+
+func TestLoopCompactEvery(t *testing.T) {
+	dir := t.TempDir()
+
+	// Capture each iteration's stdin to its own file (iterations run sequentially)
+	calls := 0
+	stdinCaptureBuilder := func(ctx context.Context, prompt string) *exec.Cmd {
+		calls++
+		capturePath := filepath.Join(dir, fmt.Sprintf("iter-%d.txt", calls))
+		cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=TestHelperProcess", "--", "claude-stdin-capture")
+		cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1", "STDIN_CAPTURE_PATH="+capturePath)
+		return cmd
+	}
+
+	cfg := loop.Config{
+		Iterations:     4,
+		Prompt:         "Build the feature",
+		CommandBuilder: stdinCaptureBuilder,
+		SleepDuration:  1 * time.Millisecond,
+		CompactEvery:   2,
+	}
+
+	l := loop.New(cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	l.Start(ctx)
+
+	var compactionMarkers []int
+	for msg := range l.Output() {
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "COMPACTION REQUESTED") {
+			compactionMarkers = append(compactionMarkers, msg.Loop)
+		}
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+
+	if fmt.Sprint(compactionMarkers) != "[2 4]" {
+		t.Errorf("Expected compaction markers on iterations 2 and 4, got %v", compactionMarkers)
+	}
+
+	for i := 1; i <= 4; i++ {
+		captured, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("iter-%d.txt", i)))
+		if err != nil {
+			t.Fatalf("Failed to read captured stdin for iteration %d: %v", i, err)
+		}
+		wantCompaction := i%2 == 0
+		gotCompaction := strings.HasPrefix(string(captured), loop.CompactionPrompt)
+		if gotCompaction != wantCompaction {
+			t.Errorf("Iteration %d: compaction prompt injected = %v, want %v", i, gotCompaction, wantCompaction)
+		}
+		if !strings.HasSuffix(string(captured), "Build the feature") {
+			t.Errorf("Iteration %d: expected original prompt to follow, got %q", i, captured)
+		}
+	}
+}
+
+func TestLoopNudgeIsOneShot(t *testing.T) {
+	dir := t.TempDir()
+
+	calls := 0
+	stdinCaptureBuilder := func(ctx context.Context, prompt string) *exec.Cmd {
+		calls++
+		capturePath := filepath.Join(dir, fmt.Sprintf("iter-%d.txt", calls))
+		cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=TestHelperProcess", "--", "claude-stdin-capture")
+		cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1", "STDIN_CAPTURE_PATH="+capturePath)
+		return cmd
+	}
+
+	l := loop.New(loop.Config{
+		Iterations:     2,
+		Prompt:         "prompt",
+		CommandBuilder: stdinCaptureBuilder,
+		SleepDuration:  1 * time.Millisecond,
+	})
+	l.Nudge("first. ")
+	l.Nudge("second. ")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+	for msg := range l.Output() {
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+
+	first, _ := os.ReadFile(filepath.Join(dir, "iter-1.txt"))
+	second, _ := os.ReadFile(filepath.Join(dir, "iter-2.txt"))
+	if string(first) != "first. second. prompt" {
+		t.Errorf("Expected queued nudges before the first prompt, got %q", first)
+	}
+	if string(second) != "prompt" {
+		t.Errorf("Expected nudge to be consumed after one iteration, got %q", second)
+	}
+}
+
+// TestLoopThrashingActions tests that Thrashing reports the configured action
+// and that nudge queues ThrashNudgePrompt for the next iteration
+func TestLoopThrashingActions(t *testing.T) {
+	if got := loop.New(loop.Config{}).Thrashing("Read a.go"); got != "" {
+		t.Errorf("Expected no action by default, got %q", got)
+	}
+
+	dir := t.TempDir()
+	calls := 0
+	stdinCaptureBuilder := func(ctx context.Context, prompt string) *exec.Cmd {
+		calls++
+		capturePath := filepath.Join(dir, fmt.Sprintf("iter-%d.txt", calls))
+		cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=TestHelperProcess", "--", "claude-stdin-capture")
+		cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1", "STDIN_CAPTURE_PATH="+capturePath)
+		return cmd
+	}
+	l := loop.New(loop.Config{
+		Iterations:     1,
+		Prompt:         "prompt",
+		CommandBuilder: stdinCaptureBuilder,
+		SleepDuration:  1 * time.Millisecond,
+		ThrashAction:   loop.ThrashNudge,
+	})
+	if got := l.Thrashing("Read a.go"); got != loop.ThrashNudge {
+		t.Errorf("Expected %q, got %q", loop.ThrashNudge, got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+	for msg := range l.Output() {
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+	first, _ := os.ReadFile(filepath.Join(dir, "iter-1.txt"))
+	if want := fmt.Sprintf(loop.ThrashNudgePrompt, "Read a.go") + "prompt"; string(first) != want {
+		t.Errorf("Expected the thrash nudge before the prompt, got %q", first)
+	}
+}
+
+func TestLoopFirstPromptOnlyForFirstIteration(t *testing.T) {
+	dir := t.TempDir()
+
+	calls := 0
+	stdinCaptureBuilder := func(ctx context.Context, prompt string) *exec.Cmd {
+		calls++
+		capturePath := filepath.Join(dir, fmt.Sprintf("iter-%d.txt", calls))
+		cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=TestHelperProcess", "--", "claude-stdin-capture")
+		cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1", "STDIN_CAPTURE_PATH="+capturePath)
+		return cmd
+	}
+
+	l := loop.New(loop.Config{
+		Iterations:     3,
+		Prompt:         "execute task $loop_iteration/$loop_total",
+		FirstPrompt:    "create the plan ($loop_iteration/$loop_total)",
+		CommandBuilder: stdinCaptureBuilder,
+		SleepDuration:  1 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+	for msg := range l.Output() {
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+
+	want := []string{"create the plan (1/3)", "execute task 2/3", "execute task 3/3"}
+	for i, w := range want {
+		got, _ := os.ReadFile(filepath.Join(dir, fmt.Sprintf("iter-%d.txt", i+1)))
+		if string(got) != w {
+			t.Errorf("Iteration %d: expected prompt %q, got %q", i+1, w, got)
+		}
+	}
+}
+
+func TestLoopRendersPromptTemplate(t *testing.T) {
+	dir := t.TempDir()
+
+	calls := 0
+	stdinCaptureBuilder := func(ctx context.Context, prompt string) *exec.Cmd {
+		calls++
+		capturePath := filepath.Join(dir, fmt.Sprintf("iter-%d.txt", calls))
+		cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=TestHelperProcess", "--", "claude-stdin-capture")
+		cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1", "STDIN_CAPTURE_PATH="+capturePath)
+		return cmd
+	}
+
+	l := loop.New(loop.Config{
+		Iterations: 2,
+		Prompt:     "iteration {{.Iteration}} of {{.TotalIterations}} ($loop_iteration)",
+		RenderPrompt: func(prompt string, iteration, total int) (string, error) {
+			return strings.NewReplacer("{{.Iteration}}", strconv.Itoa(iteration), "{{.TotalIterations}}", strconv.Itoa(total)).Replace(prompt), nil
+		},
+		CommandBuilder: stdinCaptureBuilder,
+		SleepDuration:  1 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+	for msg := range l.Output() {
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+
+	for i, w := range []string{"iteration 1 of 2 (1)", "iteration 2 of 2 (2)"} {
+		got, _ := os.ReadFile(filepath.Join(dir, fmt.Sprintf("iter-%d.txt", i+1)))
+		if string(got) != w {
+			t.Errorf("Iteration %d: expected prompt %q, got %q", i+1, w, got)
+		}
+	}
+}
+
+// stallTestRun runs a loop with stall detection and a scripted progress probe,
+// returning the loop markers it emitted and the captured prompt per iteration.
+func stallTestRun(t *testing.T, iterations, nudgeAfter int, probe loop.ProgressProbe) ([]string, []string) {
+	t.Helper()
+	dir := t.TempDir()
+	calls := 0
+	stdinCaptureBuilder := func(ctx context.Context, prompt string) *exec.Cmd {
+		calls++
+		capturePath := filepath.Join(dir, fmt.Sprintf("iter-%d.txt", calls))
+		cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=TestHelperProcess", "--", "claude-stdin-capture")
+		cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1", "STDIN_CAPTURE_PATH="+capturePath)
+		return cmd
+	}
+
+	l := loop.New(loop.Config{
+		Iterations:      iterations,
+		Prompt:          "prompt",
+		CommandBuilder:  stdinCaptureBuilder,
+		SleepDuration:   1 * time.Millisecond,
+		StallNudgeAfter: nudgeAfter,
+		ProgressProbe:   probe,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+
+	var markers []string
+	for msg := range l.Output() {
+		if msg.Type == "loop_marker" {
+			markers = append(markers, msg.Content)
+		}
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+
+	var prompts []string
+	for i := 1; i <= calls; i++ {
+		data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("iter-%d.txt", i)))
+		if err != nil {
+			t.Fatalf("Failed to read captured stdin for iteration %d: %v", i, err)
+		}
+		prompts = append(prompts, string(data))
+	}
+	return markers, prompts
+}
+
+func TestLoopStallNudgeThenStop(t *testing.T) {
+	// The work tree never changes
+	markers, prompts := stallTestRun(t, 10, 2, func() string { return "unchanged" })
+
+	if len(prompts) != 3 {
+		t.Fatalf("Expected the run to stop after 3 iterations, got %d", len(prompts))
+	}
+	if strings.HasPrefix(prompts[1], loop.StallNudgePrompt) {
+		t.Error("Did not expect a nudge before the stall threshold")
+	}
+	if !strings.HasPrefix(prompts[2], loop.StallNudgePrompt) {
+		t.Errorf("Expected the third iteration to be nudged, got %q", prompts[2])
+	}
+
+	joined := strings.Join(markers, "\n")
+	if !strings.Contains(joined, "STALL NUDGE: NO CHANGES IN 2 ITERATIONS") {
+		t.Errorf("Expected a stall nudge marker, got:\n%s", joined)
+	}
+	if !strings.Contains(joined, "STALLED: NO CHANGES IN 3 ITERATIONS, STOPPING") {
+		t.Errorf("Expected a stall stop marker, got:\n%s", joined)
+	}
+}
+
+func TestLoopStallNudgeResetsOnProgress(t *testing.T) {
+	// The work tree changes only during the nudged iteration
+	var mu sync.Mutex
+	probes := 0
+	probe := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		probes++
+		// Probes come in before/after pairs; iteration 3's "after" (probe 6) differs
+		if probes >= 6 {
+			return "changed"
+		}
+		return "unchanged"
+	}
+	markers, prompts := stallTestRun(t, 4, 2, probe)
+
+	if len(prompts) != 4 {
+		t.Fatalf("Expected all 4 iterations to run after progress resumed, got %d", len(prompts))
+	}
+	for _, m := range markers {
+		if strings.Contains(m, "STALLED") {
+			t.Errorf("Did not expect the run to stop, got marker %q", m)
+		}
+	}
+}
+
+func TestLoopStallDetectionSkipsUnknownProgress(t *testing.T) {
+	// An empty fingerprint (e.g. not a git repository) never counts as a stall
+	markers, prompts := stallTestRun(t, 4, 1, func() string { return "" })
+
+	if len(prompts) != 4 {
+		t.Fatalf("Expected all 4 iterations to run, got %d", len(prompts))
+	}
+	for _, m := range markers {
+		if strings.Contains(m, "STALL") {
+			t.Errorf("Did not expect stall markers, got %q", m)
+		}
+	}
+}
+
+// scriptedBackend runs the mock agent and replaces its assistant message with
+// the records scripted for the iteration (the last script repeats).
+type scriptedBackend struct {
+	loop.ClaudeBackend
+	mu      sync.Mutex
+	calls   int
+	scripts [][]string
+}
+
+func (b *scriptedBackend) BuildCommand(ctx context.Context, prompt string) *exec.Cmd {
+	b.mu.Lock()
+	b.calls++
+	b.mu.Unlock()
+	return mockCommandBuilder(ctx, prompt)
+}
+
+func (b *scriptedBackend) ParseLine(line string) []string {
+	if !strings.Contains(line, "test assistant message") {
+		return []string{line}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.scripts[min(b.calls, len(b.scripts))-1]
+}
+
+// doneTestRun runs a loop with completion detection and returns its loop
+// markers and how many iterations ran.
+func doneTestRun(t *testing.T, cfg loop.Config, scripts ...[]string) ([]string, int) {
+	t.Helper()
+	backend := &scriptedBackend{scripts: scripts}
+	cfg.Prompt = "prompt"
+	cfg.Backend = backend
+	cfg.SleepDuration = time.Millisecond
+	l := loop.New(cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+
+	var markers []string
+	for msg := range l.Output() {
+		if msg.Type == "loop_marker" {
+			markers = append(markers, msg.Content)
+		}
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+	return markers, backend.calls
+}
+
+const (
+	editRecord = `{"type":"assistant","message":{"content":[{"type":"tool_use","id":"t1","name":"Edit","input":{"file_path":"main.go"}}]}}`
+	readRecord = `{"type":"assistant","message":{"content":[{"type":"tool_use","id":"t2","name":"Read","input":{"file_path":"main.go"}}]}}`
+	doneRecord = `{"type":"assistant","message":{"content":[{"type":"text","text":"All tasks complete. RALPH_DONE"}]}}`
+)
+
+func TestLoopDoneAfterIdle(t *testing.T) {
+	// Edits in the first two iterations, then only reads
+	markers, calls := doneTestRun(t, loop.Config{Iterations: 10, DoneAfterIdle: 2},
+		[]string{editRecord}, []string{editRecord}, []string{readRecord})
+
+	if calls != 4 {
+		t.Fatalf("Expected the run to end after 2 iterations without edits (4 in all), got %d", calls)
+	}
+	joined := strings.Join(markers, "\n")
+	if !strings.Contains(joined, "DONE: NO EDITS IN 2 ITERATIONS, STOPPING") {
+		t.Errorf("Expected a done marker giving the reason, got:\n%s", joined)
+	}
+}
+
+func TestLoopDoneSentinel(t *testing.T) {
+	markers, calls := doneTestRun(t, loop.Config{Iterations: 10, DoneSentinel: "RALPH_DONE"},
+		[]string{editRecord}, []string{editRecord, doneRecord})
+
+	if calls != 2 {
+		t.Fatalf("Expected the run to end after the sentinel in iteration 2, got %d iterations", calls)
+	}
+	if joined := strings.Join(markers, "\n"); !strings.Contains(joined, "DONE: AGENT SAID RALPH_DONE, STOPPING") {
+		t.Errorf("Expected a done marker naming the sentinel, got:\n%s", joined)
+	}
+}
+
+func TestLoopDoneDetectionOff(t *testing.T) {
+	// Neither heuristic is set: reads and the sentinel do not end the run
+	markers, calls := doneTestRun(t, loop.Config{Iterations: 3}, []string{readRecord, doneRecord})
+
+	if calls != 3 {
+		t.Fatalf("Expected all 3 iterations to run, got %d", calls)
+	}
+	for _, m := range markers {
+		if strings.Contains(m, "DONE:") {
+			t.Errorf("Did not expect a done marker, got %q", m)
+		}
+	}
+}
+
+// crashTestRun runs one iteration of helper with cfg's retry and restart
+// settings, and returns the loop's messages and the helper's crash state.
+func crashTestRun(t *testing.T, helper string, cfg loop.Config) ([]loop.Message, string) {
+	t.Helper()
+	statePath := filepath.Join(t.TempDir(), "state")
+	cfg.CommandBuilder = func(ctx context.Context, prompt string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=TestHelperProcess", "--", helper)
+		cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1", "CRASH_STATE_PATH="+statePath)
+		return cmd
+	}
+	cfg.Iterations = 1
+	cfg.Prompt = "prompt"
+	cfg.SleepDuration = 1 * time.Millisecond
+	l := loop.New(cfg)
+	l.SetSessionID("crash-session")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+
+	var msgs []loop.Message
+	for msg := range l.Output() {
+		msgs = append(msgs, msg)
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+	state, _ := os.ReadFile(statePath)
+	return msgs, string(state)
+}
+
+func TestLoopRestartOnCrash(t *testing.T) {
+	msgs, state := crashTestRun(t, "claude-crash-once", loop.Config{RestartOnCrash: true})
+
+	restarted := false
+	for _, msg := range msgs {
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "AGENT CRASHED, RESTARTING") {
+			restarted = true
+		}
+		if msg.Type == "error" {
+			t.Errorf("Expected the restarted iteration to succeed, got error %q", msg.Content)
+		}
+	}
+	if !restarted {
+		t.Error("Expected a restart marker")
+	}
+	if state != "--resume crash-session" {
+		t.Errorf("Expected the restart to resume the crashed session, got args %q", state)
+	}
+}
+
+func TestLoopCrashWithoutRestart(t *testing.T) {
+	msgs, _ := crashTestRun(t, "claude-crash-once", loop.Config{})
+
+	var errMsg string
+	for _, msg := range msgs {
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "RESTARTING") {
+			t.Error("Did not expect a restart without RestartOnCrash")
+		}
+		if msg.Type == "error" {
+			errMsg = msg.Content
+		}
+	}
+	if !strings.Contains(errMsg, loop.ErrAgentCrashed.Error()) {
+		t.Errorf("Expected the error to report a crash, got %q", errMsg)
+	}
+}
+
+func TestLoopErrorResultIsNotACrash(t *testing.T) {
+	msgs, _ := crashTestRun(t, "claude-error-result", loop.Config{RestartOnCrash: true})
+
+	var errMsg string
+	for _, msg := range msgs {
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "RESTARTING") {
+			t.Error("Did not expect a restart after an error result")
+		}
+		if msg.Type == "error" {
+			errMsg = msg.Content
+		}
+	}
+	if errMsg == "" || strings.Contains(errMsg, loop.ErrAgentCrashed.Error()) {
+		t.Errorf("Expected a non-crash error, got %q", errMsg)
+	}
+}
+
+// TestLoopSuccessCodes tests that an agent exit code listed in SuccessCodes
+// ends the iteration without an error, and an unlisted one still fails it
+func TestLoopSuccessCodes(t *testing.T) {
+	run := func(codes []int) (errMsg string) {
+		builder := func(ctx context.Context, prompt string) *exec.Cmd {
+			cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=TestHelperProcess", "--", "claude-exit-warning")
+			cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1")
+			return cmd
+		}
+		l := loop.New(loop.Config{
+			Iterations:     1,
+			Prompt:         "prompt",
+			CommandBuilder: builder,
+			SleepDuration:  1 * time.Millisecond,
+			SuccessCodes:   codes,
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		l.Start(ctx)
+		for msg := range l.Output() {
+			switch msg.Type {
+			case "error":
+				errMsg = msg.Content
+			case "complete":
+				cancel()
+			}
+		}
+		return errMsg
+	}
+
+	if errMsg := run([]int{0, 2}); errMsg != "" {
+		t.Errorf("Expected exit code 2 to count as success, got error %q", errMsg)
+	}
+	if errMsg := run(nil); !strings.Contains(errMsg, "exit status 2") {
+		t.Errorf("Expected exit code 2 to fail without SuccessCodes, got %q", errMsg)
+	}
+}
+
+func TestGitProgressProbeIgnoresExcludedDirs(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "--allow-empty", "-m", "init")
+
+	origDir, _ := os.Getwd()
+	defer os.Chdir(origDir)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	probe := loop.NewGitProgressProbe([]string{"dist"})
+	before := probe()
+	if before == "" {
+		t.Fatal("Expected a fingerprint inside a git repository")
+	}
+
+	os.MkdirAll(filepath.Join(dir, "dist"), 0755)
+	os.WriteFile(filepath.Join(dir, "dist", "bundle.js"), []byte("x"), 0644)
+	if after := probe(); after != before {
+		t.Error("Expected changes under an excluded directory not to count as progress")
+	}
+
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0644)
+	if after := probe(); after == before {
+		t.Error("Expected a new file outside excluded directories to count as progress")
+	}
+}
+
+func TestQueueStateSaveLoadRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".ralph", "queue.json")
+
+	state := &loop.QueueState{
+		Specs:    []string{"specs/a.md", "specs/b.md", "specs/c.md"},
+		Index:    1,
+		Progress: map[string]int{"specs/a.md": 5, "specs/b.md": 2},
+	}
+	if err := loop.SaveQueueState(path, state); err != nil {
+		t.Fatalf("SaveQueueState: %v", err)
+	}
+
+	loaded, err := loop.LoadQueueState(path)
+	if err != nil {
+		t.Fatalf("LoadQueueState: %v", err)
+	}
+	if loaded.Current() != "specs/b.md" {
+		t.Errorf("Expected to resume at specs/b.md, got %q", loaded.Current())
+	}
+	if loaded.Progress["specs/b.md"] != 2 {
+		t.Errorf("Expected per-spec progress to round-trip, got %v", loaded.Progress)
+	}
+	if loaded.UpdatedAt.IsZero() {
+		t.Error("Expected UpdatedAt to be set on save")
+	}
+	if err := loaded.CheckQueue([]string{"specs/a.md", "specs/b.md", "specs/c.md"}); err != nil {
+		t.Errorf("Expected unchanged queue to match, got %v", err)
+	}
+}
+
+func TestLoadQueueStateMissingFile(t *testing.T) {
+	state, err := loop.LoadQueueState(filepath.Join(t.TempDir(), "missing.json"))
+	if state != nil || err != nil {
+		t.Errorf("Expected (nil, nil) for a missing state file, got (%v, %v)", state, err)
+	}
+}
+
+func TestLoadQueueStateInvalid(t *testing.T) {
+	dir := t.TempDir()
+	garbled := filepath.Join(dir, "garbled.json")
+	os.WriteFile(garbled, []byte("{not json"), 0644)
+	if _, err := loop.LoadQueueState(garbled); err == nil {
+		t.Error("Expected an error for malformed state")
+	}
+
+	outOfRange := filepath.Join(dir, "range.json")
+	os.WriteFile(outOfRange, []byte(`{"specs":["a.md"],"index":3}`), 0644)
+	if _, err := loop.LoadQueueState(outOfRange); err == nil {
+		t.Error("Expected an error for an out-of-range index")
+	}
+}
+
+func TestQueueStateCheckQueueMismatch(t *testing.T) {
+	state := &loop.QueueState{Specs: []string{"a.md", "b.md"}}
+
+	if err := state.CheckQueue([]string{"a.md"}); err == nil || !strings.Contains(err.Error(), "saved 2 specs, now 1") {
+		t.Errorf("Expected a length mismatch, got %v", err)
+	}
+	if err := state.CheckQueue([]string{"b.md", "a.md"}); err == nil || !strings.Contains(err.Error(), "position 1") {
+		t.Errorf("Expected a reorder mismatch at position 1, got %v", err)
+	}
+
+	done := &loop.QueueState{Specs: []string{"a.md"}, Index: 1}
+	if done.Current() != "" {
+		t.Errorf("Expected no current spec once the queue is done, got %q", done.Current())
+	}
+}
+
+// TestLoopWritesQueueState tests that a loop working through a spec queue
+// records the spec it is on and each spec's iterations after every iteration
+func TestLoopWritesQueueState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	l := loop.New(loop.Config{
+		Iterations:     5,
+		Prompt:         "test",
+		CommandBuilder: mockCommandBuilder,
+		SleepDuration:  10 * time.Millisecond,
+		SpecQueue:      loop.SpecQueue{Specs: []string{"a.md", "b.md", "c.md"}, PerSpec: 2, Path: path},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+
+	var beforeFourth *loop.QueueState
+	for msg := range l.Output() {
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "LOOP 4/5") {
+			beforeFourth, _ = loop.LoadQueueState(path)
+		}
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+
+	if beforeFourth == nil || beforeFourth.Current() != "b.md" || beforeFourth.Progress["a.md"] != 2 || beforeFourth.Progress["b.md"] != 1 {
+		t.Errorf("Expected b.md to have had 1 iteration before iteration 4, got %+v", beforeFourth)
+	}
+	final, err := loop.LoadQueueState(path)
+	if err != nil || final == nil {
+		t.Fatalf("Expected a final queue state, got %v", err)
+	}
+	if final.Index != 2 || final.Current() != "c.md" || final.Progress["c.md"] != 1 {
+		t.Errorf("Expected c.md to have had 1 of its iterations, got %+v", final)
+	}
+}
+
+// TestSpecQueueResumeAt tests that a resumed spec queue starts at the next
+// iteration on the spec it was on, even with another --iterations-per-spec
+func TestSpecQueueResumeAt(t *testing.T) {
+	specs := []string{"a.md", "b.md", "c.md", "d.md"}
+	saved := loop.SpecQueue{Specs: specs, PerSpec: 3}.State(7)
+	if saved.Current() != "c.md" || saved.Progress["c.md"] != 1 {
+		t.Fatalf("Expected 7 iterations of 3 per spec to stop on c.md, got %+v", saved)
+	}
+
+	queue := loop.SpecQueue{Specs: specs, PerSpec: 3}
+	if got := queue.ResumeAt(saved); got != 8 || queue.Spec(got) != "c.md" {
+		t.Errorf("Expected to resume at iteration 8 on c.md, got %d on %q", got, queue.Spec(got))
+	}
+	queue.PerSpec = 5
+	if got := queue.ResumeAt(saved); got != 12 || queue.Spec(got) != "c.md" {
+		t.Errorf("Expected to resume at iteration 12 on c.md with 5 per spec, got %d on %q", got, queue.Spec(got))
+	}
+	if done := queue.State(20); done.Current() != "" || queue.Spec(21) != "" {
+		t.Errorf("Expected the queue to be done after 20 iterations, got %+v", done)
+	}
+}
+
+// TestConfirmEachLoopWaitsBeforeEachIteration tests that step mode runs the
+// first iteration straight away, then pauses before every later one until
+// Resume is called.
+func TestConfirmEachLoopWaitsBeforeEachIteration(t *testing.T) {
+	cfg := loop.Config{
+		Iterations:      3,
+		Prompt:          "test",
+		CommandBuilder:  mockCommandBuilder,
+		SleepDuration:   10 * time.Millisecond,
+		ConfirmEachLoop: true,
+	}
+
+	l := loop.New(cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	l.Start(ctx)
+
+	var waits []int
+	started := 0
+	for msg := range l.Output() {
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, loop.ConfirmMarker) {
+			if !l.IsPaused() {
+				t.Error("Loop should be paused while waiting for confirmation")
+			}
+			if started != msg.Loop-1 {
+				t.Errorf("Expected to wait before loop %d after %d loops started, got %d", msg.Loop, msg.Loop-1, started)
+			}
+			waits = append(waits, msg.Loop)
+			l.Resume()
+		} else if msg.Type == "loop_marker" && strings.Contains(msg.Content, "LOOP ") &&
+			!strings.Contains(msg.Content, "RESUMED") && !strings.Contains(msg.Content, "STOPPED") {
+			started++
+		}
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+
+	if len(waits) != 2 || waits[0] != 2 || waits[1] != 3 {
+		t.Errorf("Expected confirmation waits before loops 2 and 3, got %v", waits)
+	}
+	if started != 3 {
+		t.Errorf("Expected 3 loops to start, got %d", started)
+	}
+}
+
+// TestConfirmStartWaitsBeforeFirstIterationOnly tests that ConfirmStart
+// holds the first iteration until Resume and then runs the rest unasked.
+func TestConfirmStartWaitsBeforeFirstIterationOnly(t *testing.T) {
+	l := loop.New(loop.Config{
+		Iterations:     2,
+		Prompt:         "test",
+		CommandBuilder: mockCommandBuilder,
+		SleepDuration:  10 * time.Millisecond,
+		ConfirmStart:   true,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	l.Start(ctx)
+
+	var waits []int
+	sawOutput := false
+	for msg := range l.Output() {
+		switch msg.Type {
+		case "loop_marker":
+			if strings.Contains(msg.Content, loop.ConfirmMarker) {
+				if sawOutput {
+					t.Error("Expected the wait before any iteration ran")
+				}
+				waits = append(waits, msg.Loop)
+				l.Resume()
+			}
+		case "output":
+			sawOutput = true
+		case "complete":
+			cancel()
+		}
+	}
+
+	if len(waits) != 1 || waits[0] != 1 {
+		t.Errorf("Expected a single wait before loop 1, got %v", waits)
+	}
+}
+
+// TestPostLoopHookRunsAfterEachIteration tests that the post-loop hook runs
+// once per iteration and reports its result as a marker.
+func TestPostLoopHookRunsAfterEachIteration(t *testing.T) {
+	l := loop.New(loop.Config{
+		Iterations:     2,
+		Prompt:         "test",
+		CommandBuilder: mockCommandBuilder,
+		SleepDuration:  10 * time.Millisecond,
+		PostLoopHook:   "echo checked",
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	l.Start(ctx)
+
+	var hookLoops []int
+	for msg := range l.Output() {
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, loop.HookMarker) {
+			if !strings.Contains(msg.Content, "POST HOOK passed: echo checked") || !strings.HasSuffix(msg.Content, "\nchecked") {
+				t.Errorf("Unexpected hook marker %q", msg.Content)
+			}
+			hookLoops = append(hookLoops, msg.Loop)
+		}
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+
+	if len(hookLoops) != 2 || hookLoops[0] != 1 || hookLoops[1] != 2 {
+		t.Errorf("Expected the hook after loops 1 and 2, got %v", hookLoops)
+	}
+}
+
+// TestHookMustPassStopsRunOnFailure tests that a failing hook ends the run
+// after the current iteration when HookMustPass is set.
+func TestHookMustPassStopsRunOnFailure(t *testing.T) {
+	l := loop.New(loop.Config{
+		Iterations:     3,
+		Prompt:         "test",
+		CommandBuilder: mockCommandBuilder,
+		SleepDuration:  10 * time.Millisecond,
+		PostLoopHook:   "exit 1",
+		HookMustPass:   true,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	l.Start(ctx)
+
+	sawStopping := false
+	var completed string
+	for msg := range l.Output() {
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "HOOK FAILED, STOPPING") {
+			sawStopping = true
+		}
+		if msg.Type == "complete" {
+			completed = msg.Content
+			cancel()
+		}
+	}
+
+	if !sawStopping {
+		t.Error("Expected a stopping marker after the failed hook")
+	}
+	if !strings.Contains(completed, "COMPLETED 1 ITERATIONS") {
+		t.Errorf("Expected the run to end after loop 1, got %q", completed)
+	}
+}
+
+// TestPreLoopHookRunsBeforeEachIteration tests that the pre-loop hook runs
+// ahead of every iteration's agent output.
+func TestPreLoopHookRunsBeforeEachIteration(t *testing.T) {
+	l := loop.New(loop.Config{
+		Iterations:     2,
+		Prompt:         "test",
+		CommandBuilder: mockCommandBuilder,
+		SleepDuration:  10 * time.Millisecond,
+		PreLoopHook:    "echo snapshot",
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	l.Start(ctx)
+
+	var hookLoops []int
+	outputs := 0
+	for msg := range l.Output() {
+		switch msg.Type {
+		case "loop_marker":
+			if strings.Contains(msg.Content, "PRE "+loop.HookMarker+" passed: echo snapshot") {
+				if outputs > 0 && len(hookLoops) == 0 {
+					t.Error("Expected the first hook before any agent output")
+				}
+				hookLoops = append(hookLoops, msg.Loop)
+			}
+		case "output":
+			outputs++
+		case "complete":
+			cancel()
+		}
+	}
+
+	if len(hookLoops) != 2 || hookLoops[0] != 1 || hookLoops[1] != 2 {
+		t.Errorf("Expected the hook before loops 1 and 2, got %v", hookLoops)
+	}
+}
+
+// TestPreLoopHookMustPassSkipsIteration tests that a failing pre-loop hook
+// with HookMustPass ends the run before the agent runs.
+func TestPreLoopHookMustPassSkipsIteration(t *testing.T) {
+	l := loop.New(loop.Config{
+		Iterations:     3,
+		Prompt:         "test",
+		CommandBuilder: mockCommandBuilder,
+		SleepDuration:  10 * time.Millisecond,
+		PreLoopHook:    "exit 1",
+		HookMustPass:   true,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	l.Start(ctx)
+
+	sawOutput := false
+	var completed string
+	for msg := range l.Output() {
+		switch msg.Type {
+		case "output":
+			sawOutput = true
+		case "complete":
+			completed = msg.Content
+			cancel()
+		}
+	}
+
+	if sawOutput {
+		t.Error("Expected the agent not to run after the pre-loop hook failed")
+	}
+	if !strings.Contains(completed, "COMPLETED 0 ITERATIONS") {
+		t.Errorf("Expected the run to end before loop 1, got %q", completed)
+	}
+}
+
+// TestSuccessCmdPassesBeforeCompletion tests that a passing success check
+// is reported once the iterations are done and the run completes as usual.
+func TestSuccessCmdPassesBeforeCompletion(t *testing.T) {
+	l := loop.New(loop.Config{
+		Iterations:     2,
+		Prompt:         "test",
+		CommandBuilder: mockCommandBuilder,
+		SleepDuration:  1 * time.Millisecond,
+		SuccessCmd:     "echo green",
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	l.Start(ctx)
+
+	var checks []int
+	var completed string
+	for msg := range l.Output() {
+		switch msg.Type {
+		case "loop_marker":
+			if strings.Contains(msg.Content, "SUCCESS "+loop.HookMarker+" passed: echo green") {
+				checks = append(checks, msg.Loop)
+			}
+		case "complete":
+			completed = msg.Content
+			cancel()
+		}
+	}
+
+	if len(checks) != 1 || checks[0] != 2 {
+		t.Errorf("Expected one success check after loop 2, got %v", checks)
+	}
+	if !strings.Contains(completed, "COMPLETED 2 ITERATIONS") {
+		t.Errorf("Expected the run to complete after 2 iterations, got %q", completed)
+	}
+	if l.SuccessFailed() {
+		t.Error("Expected SuccessFailed to be false after a passing check")
+	}
+}
+
+// TestSuccessCmdFailureRunsRemediation tests that a failing success check
+// adds remediation iterations up to SuccessRetries, each prompted with the
+// failure, and that the run then completes reporting the failure.
+func TestSuccessCmdFailureRunsRemediation(t *testing.T) {
+	dir := t.TempDir()
+
+	calls := 0
+	stdinCaptureBuilder := func(ctx context.Context, prompt string) *exec.Cmd {
+		calls++
+		capturePath := filepath.Join(dir, fmt.Sprintf("iter-%d.txt", calls))
+		cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=TestHelperProcess", "--", "claude-stdin-capture")
+		cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1", "STDIN_CAPTURE_PATH="+capturePath)
+		return cmd
+	}
+
+	l := loop.New(loop.Config{
+		Iterations:     1,
+		Prompt:         "prompt",
+		CommandBuilder: stdinCaptureBuilder,
+		SleepDuration:  1 * time.Millisecond,
+		SuccessCmd:     "echo tests broken; exit 1",
+		SuccessRetries: 2,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	l.Start(ctx)
+
+	checks := 0
+	var remediations []string
+	var completed string
+	for msg := range l.Output() {
+		switch msg.Type {
+		case "loop_marker":
+			if strings.Contains(msg.Content, "SUCCESS "+loop.HookMarker+" failed") {
+				checks++
+			}
+			if strings.Contains(msg.Content, "REMEDIATION") {
+				remediations = append(remediations, msg.Content)
+			}
+		case "complete":
+			completed = msg.Content
+			cancel()
+		}
+	}
+
+	if checks != 3 {
+		t.Errorf("Expected 3 failed checks (after the run and each remediation), got %d", checks)
+	}
+	if len(remediations) != 2 || !strings.Contains(remediations[1], "REMEDIATION 2/2") {
+		t.Errorf("Expected 2 remediation markers, got %v", remediations)
+	}
+	if !strings.Contains(completed, "COMPLETED 3 ITERATIONS") {
+		t.Errorf("Expected the run to complete after 3 iterations, got %q", completed)
+	}
+	if !l.SuccessFailed() {
+		t.Error("Expected SuccessFailed to be true after the remediations failed")
+	}
+	first, _ := os.ReadFile(filepath.Join(dir, "iter-1.txt"))
+	second, _ := os.ReadFile(filepath.Join(dir, "iter-2.txt"))
+	if string(first) != "prompt" {
+		t.Errorf("Expected the first iteration to get the plain prompt, got %q", first)
+	}
+	if want := fmt.Sprintf(loop.SuccessFixPrompt, "echo tests broken; exit 1", "tests broken") + "prompt"; string(second) != want {
+		t.Errorf("Expected the remediation prompt to carry the failure, got %q", second)
+	}
+}
+
+// redoTestRun runs a two-iteration loop, asks for a redo once it completes,
+// and returns the loop markers and the session each iteration started in.
+func redoTestRun(t *testing.T, fresh bool) (markers, sessions []string) {
+	t.Helper()
+	l := loop.New(loop.Config{
+		Iterations:     2,
+		Prompt:         "test",
+		CommandBuilder: mockCommandBuilder,
+		SleepDuration:  1 * time.Millisecond,
+		RedoFresh:      fresh,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+
+	sessionPattern := regexp.MustCompile(`"session_id":"([^"]+)"`)
+	completes := 0
+	for msg := range l.Output() {
+		switch msg.Type {
+		case "loop_marker":
+			markers = append(markers, msg.Content)
+		case "output":
+			if m := sessionPattern.FindStringSubmatch(msg.Content); m != nil {
+				sessions = append(sessions, m[1])
+			}
+		case "complete":
+			completes++
+			if completes == 1 {
+				l.SetSessionID("last-session")
+				if !l.RedoIteration() {
+					t.Error("Expected RedoIteration to be accepted once the loop completed")
+					cancel()
+				}
+			} else {
+				cancel()
+			}
+		}
+	}
+	if completes != 2 {
+		t.Errorf("Expected the loop to complete again after the redo, got %d completions", completes)
+	}
+	return markers, sessions
+}
+
+func TestRedoIterationRerunsLastIteration(t *testing.T) {
+	markers, sessions := redoTestRun(t, false)
+
+	redoAt := -1
+	for i, m := range markers {
+		if strings.Contains(m, "REDO ITERATION 2/2") {
+			redoAt = i
+		}
+	}
+	if redoAt < 0 {
+		t.Fatalf("Expected a REDO ITERATION 2/2 marker, got %v", markers)
+	}
+	if redoAt+1 >= len(markers) || !strings.Contains(markers[redoAt+1], "LOOP 2/2") {
+		t.Errorf("Expected iteration 2 to run again after the redo marker, got %v", markers)
+	}
+	if len(sessions) != 3 || sessions[2] != "last-session" {
+		t.Errorf("Expected the redo to resume the last session, got sessions %v", sessions)
+	}
+}
+
+func TestRedoIterationFresh(t *testing.T) {
+	_, sessions := redoTestRun(t, true)
+	if len(sessions) != 3 || sessions[2] != "fresh-session-001" {
+		t.Errorf("Expected the redo to start a fresh session, got sessions %v", sessions)
+	}
+}
+
+func TestRedoIterationIgnoredWhileRunning(t *testing.T) {
+	l := loop.New(loop.Config{
+		Iterations:     1,
+		Prompt:         "test",
+		CommandBuilder: mockMediumSlowCommandBuilder,
+		SleepDuration:  1 * time.Millisecond,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+
+	for msg := range l.Output() {
+		if msg.Type == "output" && strings.Contains(msg.Content, `"type":"system"`) {
+			if l.RedoIteration() {
+				t.Error("Expected RedoIteration to be a no-op while an iteration runs")
+			}
+		}
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "REDO") {
+			t.Errorf("Did not expect a redo marker, got %q", msg.Content)
+		}
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+}
+
+func TestLoopDoneMarkers(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		l := loop.New(loop.Config{
+			Iterations:     2,
+			Prompt:         "test prompt",
+			CommandBuilder: mockCommandBuilder,
+			SleepDuration:  1 * time.Millisecond,
+			DoneMarkers:    enabled,
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		l.Start(ctx)
+
+		var done []loop.Message
+		sawResult := false
+		for msg := range l.Output() {
+			switch msg.Type {
+			case "output":
+				if strings.Contains(msg.Content, `"type":"result"`) {
+					sawResult = true
+				}
+			case "loop_marker_done":
+				if !sawResult {
+					t.Errorf("loop_marker_done for loop %d arrived before the iteration's result", msg.Loop)
+				}
+				sawResult = false
+				done = append(done, msg)
+			case "complete":
+				cancel()
+			}
+		}
+		cancel()
+
+		if !enabled {
+			if len(done) != 0 {
+				t.Errorf("Expected no loop_marker_done without DoneMarkers, got %d", len(done))
+			}
+			continue
+		}
+		if len(done) != 2 {
+			t.Fatalf("Expected a loop_marker_done per iteration, got %d", len(done))
+		}
+		for n, msg := range done {
+			want := fmt.Sprintf("LOOP %d/2 done — %s", n+1, msg.Elapsed)
+			if msg.Loop != n+1 || msg.Total != 2 || msg.Content != want {
+				t.Errorf("Unexpected done marker %+v, want content %q", msg, want)
+			}
+			if msg.Elapsed < 0 || msg.Elapsed%time.Second != 0 {
+				t.Errorf("Expected a whole-second elapsed time, got %v", msg.Elapsed)
+			}
+		}
+	}
+}
+
+func TestWakingReportsHibernationLength(t *testing.T) {
+	l := loop.New(loop.Config{
+		Iterations:     1,
+		Prompt:         "test",
+		CommandBuilder: mockMediumSlowCommandBuilder,
+		SleepDuration:  10 * time.Millisecond,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	l.Start(ctx)
+
+	// Pace the first attempt and rate limit the second: only the rate
+	// limit's WAKING marker reports a hibernation
+	starts := 0
+	var wakes []time.Duration
+	for msg := range l.Output() {
+		if msg.Type == "output" && strings.Contains(msg.Content, `"type":"system"`) {
+			starts++
+			switch starts {
+			case 1:
+				l.Pace(time.Now().Add(20 * time.Millisecond))
+			case 2:
+				l.Hibernate(time.Now().Add(300 * time.Millisecond))
+			}
+		}
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "WAKING") {
+			wakes = append(wakes, msg.Elapsed)
+		}
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+
+	if len(wakes) != 2 {
+		t.Fatalf("Expected two WAKING markers, got %d", len(wakes))
+	}
+	if wakes[0] != 0 {
+		t.Errorf("Expected pacing not to report a hibernation, got %v", wakes[0])
+	}
+	if wakes[1] < 100*time.Millisecond || wakes[1] > 5*time.Second {
+		t.Errorf("Expected the rate-limit WAKING to report about 300ms, got %v", wakes[1])
+	}
+}
+
+func TestLoopWaitsForStartAt(t *testing.T) {
+	start := time.Now().Add(300 * time.Millisecond)
+	l := loop.New(loop.Config{
+		Iterations:     1,
+		Prompt:         "test",
+		CommandBuilder: mockCommandBuilder,
+		SleepDuration:  1 * time.Millisecond,
+		StartAt:        start,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	l.Start(ctx)
+
+	var markers []string
+	var firstLoop time.Time
+	for msg := range l.Output() {
+		if msg.Type == "loop_marker" {
+			markers = append(markers, msg.Content)
+			if strings.Contains(msg.Content, "SCHEDULED") && !l.IsHibernating() {
+				t.Error("Expected the loop to hibernate until the scheduled start")
+			}
+			if strings.Contains(msg.Content, "LOOP 1/1") {
+				firstLoop = time.Now()
+			}
+		}
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+
+	if len(markers) == 0 || !strings.Contains(markers[0], "SCHEDULED: STARTING AT") {
+		t.Fatalf("Expected a SCHEDULED marker first, got %q", markers)
+	}
+	if firstLoop.Before(start) {
+		t.Errorf("Expected the first iteration to wait for %v, it started at %v", start, firstLoop)
+	}
+}
+
+func TestLoopStartAtInThePastStartsNow(t *testing.T) {
+	l := loop.New(loop.Config{
+		Iterations:     1,
+		Prompt:         "test",
+		CommandBuilder: mockCommandBuilder,
+		SleepDuration:  1 * time.Millisecond,
+		StartAt:        time.Now().Add(-time.Minute),
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	l.Start(ctx)
+	for msg := range l.Output() {
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "SCHEDULED") {
+			t.Error("Did not expect a SCHEDULED marker for a start time in the past")
+		}
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	w, err := loop.ParseWindow("22:00-06:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Start != 22*60 || w.End != 6*60+30 || w.String() != "22:00-06:30" {
+		t.Errorf("Expected 22:00-06:30, got %+v (%s)", w, w)
+	}
+	for _, bad := range []string{"", "22:00", "22:00-", "10pm-6am", "25:00-06:00", "09:00-09:00"} {
+		if _, err := loop.ParseWindow(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestWindowNext(t *testing.T) {
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2026, 3, day, hour, min, 0, 0, time.Local)
+	}
+	overnight := loop.Window{Start: 22 * 60, End: 6 * 60}
+	daytime := loop.Window{Start: 9 * 60, End: 17 * 60}
+	for _, tc := range []struct {
+		w    loop.Window
+		now  time.Time
+		want time.Time
+	}{
+		{overnight, at(10, 23, 15), at(10, 23, 15)}, // inside, before midnight
+		{overnight, at(10, 5, 59), at(10, 5, 59)},   // inside, after midnight
+		{overnight, at(10, 6, 0), at(10, 22, 0)},    // just closed
+		{overnight, at(10, 12, 0), at(10, 22, 0)},
+		{daytime, at(10, 8, 0), at(10, 9, 0)},
+		{daytime, at(10, 17, 30), at(11, 9, 0)}, // closed for today
+		{loop.Window{}, at(10, 3, 0), at(10, 3, 0)},
+	} {
+		if got := tc.w.Next(tc.now); !got.Equal(tc.want) {
+			t.Errorf("%s at %s: expected %s, got %s", tc.w, tc.now.Format("Jan 2 15:04"), tc.want.Format("Jan 2 15:04"), got.Format("Jan 2 15:04"))
+		}
+	}
+}
+
+func TestLoopWaitsForActiveHours(t *testing.T) {
+	// A one-hour window opening two hours from now
+	now := time.Now()
+	open := now.Hour()*60 + now.Minute() + 120
+	window := loop.Window{Start: open % (24 * 60), End: (open + 60) % (24 * 60)}
+	l := loop.New(loop.Config{
+		Iterations:     1,
+		Prompt:         "test",
+		CommandBuilder: mockCommandBuilder,
+		SleepDuration:  1 * time.Millisecond,
+		ActiveHours:    window,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	l.Start(ctx)
+	var markers []string
+	for msg := range l.Output() {
+		if msg.Type == "loop_marker" {
+			markers = append(markers, msg.Content)
+			if strings.Contains(msg.Content, "OUTSIDE ACTIVE HOURS") {
+				if !l.IsHibernating() || msg.Until.Before(now.Add(time.Hour)) {
+					t.Errorf("Expected the loop to hibernate until the window opens, got hibernating=%v until %v", l.IsHibernating(), msg.Until)
+				}
+				// Waking runs the iteration now
+				l.Wake()
+			}
+		}
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+
+	if len(markers) < 2 || !strings.Contains(markers[0], "SCHEDULED: OUTSIDE ACTIVE HOURS "+window.String()) || !strings.Contains(markers[1], "LOOP 1/1") {
+		t.Errorf("Expected a SCHEDULED marker, then the iteration once woken, got %q", markers)
+	}
+}
+
+func TestRunStateSaveLoadRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".ralph", "state.json")
+
+	state := &loop.RunState{
+		Args:      []string{"build", "--iterations", "10"},
+		Iteration: 4,
+		Total:     10,
+		SessionID: "session-4",
+		Paused:    true,
+	}
+	state.Stats.TotalCostUSD = 1.5
+	if err := loop.SaveRunState(path, state); err != nil {
+		t.Fatalf("SaveRunState: %v", err)
+	}
+
+	loaded, err := loop.LoadRunState(path)
+	if err != nil {
+		t.Fatalf("LoadRunState: %v", err)
+	}
+	if loaded.Iteration != 4 || loaded.Total != 10 || loaded.SessionID != "session-4" || !loaded.Paused {
+		t.Errorf("Unexpected state after round trip: %+v", loaded)
+	}
+	if len(loaded.Args) != 3 || loaded.Args[0] != "build" {
+		t.Errorf("Expected the command line to round-trip, got %q", loaded.Args)
+	}
+	if loaded.Stats.TotalCostUSD != 1.5 {
+		t.Errorf("Expected the stats snapshot to round-trip, got %+v", loaded.Stats)
+	}
+
+	if state, err := loop.LoadRunState(filepath.Join(t.TempDir(), "missing.json")); state != nil || err != nil {
+		t.Errorf("Expected (nil, nil) for a missing state file, got (%v, %v)", state, err)
+	}
+}
+
+func TestRunStateCheckSpecs(t *testing.T) {
+	state := &loop.RunState{Specs: []string{"a.md", "b.md"}}
+
+	if err := state.CheckSpecs([]string{"a.md", "b.md"}); err != nil {
+		t.Errorf("Expected unchanged specs to match, got %v", err)
+	}
+	if err := state.CheckSpecs([]string{"a.md"}); err == nil || !strings.Contains(err.Error(), "covered 2, now 1") {
+		t.Errorf("Expected a length mismatch, got %v", err)
+	}
+	if err := state.CheckSpecs([]string{"b.md", "a.md"}); err == nil || !strings.Contains(err.Error(), "position 1") {
+		t.Errorf("Expected a reorder mismatch at position 1, got %v", err)
+	}
+}
+
+// TestLoopWritesRunState tests that the loop records its state after every
+// iteration and marks it complete at the end
+func TestLoopWritesRunState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	l := loop.New(loop.Config{
+		Iterations:     2,
+		Prompt:         "test",
+		CommandBuilder: mockCommandBuilder,
+		SleepDuration:  10 * time.Millisecond,
+		StatePath:      path,
+		StateArgs:      []string{"--iterations", "2"},
+		StateBranch:    "ralph/20261016-150405",
+		StateSpecs:     []string{"auth.md"},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+
+	var afterFirst *loop.RunState
+	for msg := range l.Output() {
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "LOOP 2/2") {
+			afterFirst, _ = loop.LoadRunState(path)
+		}
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+
+	if afterFirst == nil || afterFirst.Iteration != 1 || afterFirst.Complete {
+		t.Errorf("Expected iteration 1 recorded before iteration 2 started, got %+v", afterFirst)
+	}
+	final, err := loop.LoadRunState(path)
+	if err != nil || final == nil {
+		t.Fatalf("Expected a final run state, got %v", err)
+	}
+	if final.Iteration != 2 || final.Total != 2 || !final.Complete || len(final.Args) != 2 || final.Branch != "ralph/20261016-150405" || len(final.Specs) != 1 {
+		t.Errorf("Unexpected final run state %+v", final)
+	}
+}
+
+// TestLoopFirstIteration tests that a resumed loop starts after the
+// iterations already done
+func TestLoopFirstIteration(t *testing.T) {
+	l := loop.New(loop.Config{
+		Iterations:     4,
+		Prompt:         "test",
+		CommandBuilder: mockCommandBuilder,
+		SleepDuration:  10 * time.Millisecond,
+		FirstIteration: 3,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+
+	var markers []string
+	for msg := range l.Output() {
+		if msg.Type == "loop_marker" {
+			markers = append(markers, msg.Content)
+		}
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+	want := []string{"======= LOOP 3/4 =======", "======= LOOP 4/4 ======="}
+	if strings.Join(markers, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected only iterations 3 and 4 to run, got %q", markers)
+	}
+}
+
+// TestSupervise tests that Supervise runs every command, passes on each line
+// with the command it came from, and reports each outcome
+func TestSupervise(t *testing.T) {
+	cmds := []*exec.Cmd{
+		exec.Command("sh", "-c", "echo one; echo two"),
+		exec.Command("sh", "-c", "echo oops >&2; exit 3"),
+		exec.Command("ralph-test-no-such-binary"),
+	}
+	var lines []loop.WorkerLine
+	errs := loop.Supervise(cmds, func(line loop.WorkerLine) {
+		lines = append(lines, line)
+	})
+
+	if len(errs) != 3 || errs[0] != nil || errs[1] == nil || errs[2] == nil {
+		t.Fatalf("Expected success, exit status 3 and a start failure, got %v", errs)
+	}
+	if exitErr, ok := errs[1].(*exec.ExitError); !ok || exitErr.ExitCode() != 3 {
+		t.Errorf("Expected exit status 3, got %v", errs[1])
+	}
+	var got []string
+	for _, l := range lines {
+		got = append(got, fmt.Sprintf("%d:%s:%v", l.Worker, l.Text, l.Stderr))
+	}
+	sort.Strings(got)
+	if want := "0:one:false 0:two:false 1:oops:true"; strings.Join(got, " ") != want {
+		t.Errorf("Lines = %v, want %s", got, want)
+	}
+}
+
+// TestLoopRetryFailedIteration tests that a failing iteration is retried up
+// to RetryFailed times, with a marker counting the attempts, before the loop
+// moves on.
+func TestLoopRetryFailedIteration(t *testing.T) {
+	msgs, _ := crashTestRun(t, "claude-error-result", loop.Config{RetryFailed: 2, RetryBackoff: time.Millisecond})
+
+	var markers []string
+	errors := 0
+	for _, msg := range msgs {
+		switch msg.Type {
+		case "loop_marker":
+			markers = append(markers, msg.Content)
+		case "error":
+			errors++
+		}
+	}
+	want := []string{
+		"======= LOOP 1/1 =======",
+		"======= ITERATION FAILED, RETRY 1/2 IN 0s =======",
+		"======= LOOP 1/1 (RETRY 1/2) =======",
+		"======= ITERATION FAILED, RETRY 2/2 IN 0s =======",
+		"======= LOOP 1/1 (RETRY 2/2) =======",
+	}
+	if strings.Join(markers, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got markers:\n%s\nwant:\n%s", strings.Join(markers, "\n"), strings.Join(want, "\n"))
+	}
+	if errors != 3 {
+		t.Errorf("Expected every failed attempt to report its error, got %d errors", errors)
+	}
+}
+
+// TestLoopRetryFailedRecovers tests that a retry that succeeds ends the
+// iteration without a further error.
+func TestLoopRetryFailedRecovers(t *testing.T) {
+	msgs, _ := crashTestRun(t, "claude-crash-once", loop.Config{RetryFailed: 3, RetryBackoff: time.Millisecond})
+
+	errors, retries := 0, 0
+	for _, msg := range msgs {
+		if msg.Type == "error" {
+			errors++
+		}
+		if msg.Type == "loop_marker" && strings.Contains(msg.Content, "ITERATION FAILED") {
+			retries++
+		}
+	}
+	if errors != 1 || retries != 1 {
+		t.Errorf("Expected one failure and one retry, got %d errors and %d retries", errors, retries)
+	}
+}
+
+// TestLoopNoRetryByDefault tests that without RetryFailed a failed iteration
+// is reported and the loop moves on.
+func TestLoopNoRetryByDefault(t *testing.T) {
+	msgs, _ := crashTestRun(t, "claude-error-result", loop.Config{})
+	for _, msg := range msgs {
+		if strings.Contains(msg.Content, "RETRY") {
+			t.Errorf("Did not expect a retry, got %q", msg.Content)
+		}
+	}
+}
+
+// TestLoopRecordsTranscripts tests that each iteration's raw agent output is
+// written to its own transcript under the run's directory.
+func TestLoopRecordsTranscripts(t *testing.T) {
+	tr := loop.Transcripts{Dir: filepath.Join(t.TempDir(), "transcripts"), RunID: "run-1"}
+	l := loop.New(loop.Config{
+		Iterations:     2,
+		Prompt:         "prompt",
+		CommandBuilder: mockCommandBuilder,
+		SleepDuration:  1 * time.Millisecond,
+		Transcripts:    tr,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+	for msg := range l.Output() {
+		if msg.Type == "error" {
+			t.Errorf("Unexpected error: %s", msg.Content)
+		}
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+
+	for i := 1; i <= 2; i++ {
+		data, err := os.ReadFile(tr.Path(i))
+		if err != nil {
+			t.Fatalf("Expected a transcript for iteration %d: %v", i, err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != 3 || !strings.Contains(lines[0], `"type":"system"`) || !strings.Contains(lines[2], `"type":"result"`) {
+			t.Errorf("Expected the raw stream-json of iteration %d, got:\n%s", i, data)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tr.Dir, ".gitignore")); err != nil {
+		t.Errorf("Expected the transcript directory to be git-ignored: %v", err)
+	}
+}
+
+// TestLoopRedactsTranscripts tests that transcripts are redacted before they
+// are written, so --redact keeps secrets off disk as well as out of the feed.
+func TestLoopRedactsTranscripts(t *testing.T) {
+	tr := loop.Transcripts{
+		Dir:    filepath.Join(t.TempDir(), "transcripts"),
+		RunID:  "run-1",
+		Redact: func(s string) string { return strings.ReplaceAll(s, "session", "[REDACTED]") },
+	}
+	l := loop.New(loop.Config{
+		Iterations:     1,
+		Prompt:         "prompt",
+		CommandBuilder: mockCommandBuilder,
+		SleepDuration:  1 * time.Millisecond,
+		Transcripts:    tr,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+	for msg := range l.Output() {
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+
+	data, err := os.ReadFile(tr.Path(1))
+	if err != nil {
+		t.Fatalf("Expected a transcript: %v", err)
+	}
+	if strings.Contains(string(data), "session") || !strings.Contains(string(data), "[REDACTED]") {
+		t.Errorf("Expected the transcript redacted, got:\n%s", data)
+	}
+}
+
+// writeTranscript writes a transcript of size bytes for iteration, aged by
+// age.
+func writeTranscript(t *testing.T, tr loop.Transcripts, iteration, size int, age time.Duration) string {
+	t.Helper()
+	path := tr.Path(iteration)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	when := time.Now().Add(-age)
+	if err := os.Chtimes(path, when, when); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestTranscriptsPrune tests that pruning deletes the oldest transcripts
+// across runs until both limits are met, keeping the one just written and
+// removing run directories left empty.
+func TestTranscriptsPrune(t *testing.T) {
+	dir := t.TempDir()
+	old := loop.Transcripts{Dir: dir, RunID: "old"}
+	cur := loop.Transcripts{Dir: dir, RunID: "cur"}
+	old1 := writeTranscript(t, old, 1, 10, 3*time.Hour)
+	old2 := writeTranscript(t, old, 2, 10, 2*time.Hour)
+	cur1 := writeTranscript(t, cur, 1, 10, time.Hour)
+	cur2 := writeTranscript(t, cur, 2, 10, 0)
+
+	cur.MaxFiles = 3
+	if err := cur.Prune(cur2); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	for path, want := range map[string]bool{old1: false, old2: true, cur1: true, cur2: true} {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s: expected exists=%v after pruning to 3 files", path, want)
+		}
+	}
+
+	cur.MaxFiles, cur.MaxBytes = 0, 15
+	if err := cur.Prune(cur2); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	for path, want := range map[string]bool{old2: false, cur1: false, cur2: true} {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s: expected exists=%v after pruning to 15 bytes", path, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "old")); !os.IsNotExist(err) {
+		t.Errorf("Expected the emptied run directory to be removed, got %v", err)
+	}
+}
+
+// TestTranscriptFiles tests that a run's transcripts are listed in iteration
+// order, skipping other files.
+func TestTranscriptFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"10.jsonl", "2.jsonl", "1.jsonl", "notes.txt", "x.jsonl"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := loop.TranscriptFiles(dir)
+	if err != nil {
+		t.Fatalf("TranscriptFiles: %v", err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	if strings.Join(names, " ") != "1.jsonl 2.jsonl 10.jsonl" {
+		t.Errorf("Expected transcripts in iteration order, got %v", names)
+	}
+
+	if _, err := loop.TranscriptFiles(t.TempDir()); err == nil {
+		t.Error("Expected an error for a directory without transcripts")
+	}
+}
+
+// TestReplay tests that a replay plays each transcript back as an iteration,
+// record by record, and ignores Hibernate.
+func TestReplay(t *testing.T) {
+	tr := loop.Transcripts{Dir: t.TempDir(), RunID: "run"}
+	records := map[int][]string{
+		1: {`{"type":"system","subtype":"init"}`, `{"type":"result","total_cost_usd":0.5}`},
+		2: {`{"type":"assistant","message":{"content":[{"type":"text","text":"hi"}]}}`},
+	}
+	for i, lines := range records {
+		if err := os.MkdirAll(filepath.Dir(tr.Path(i)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(tr.Path(i), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := loop.TranscriptFiles(filepath.Join(tr.Dir, tr.RunID))
+	if err != nil {
+		t.Fatalf("TranscriptFiles: %v", err)
+	}
+
+	l := loop.NewReplay(files, nil, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+
+	var got []string
+	for msg := range l.Output() {
+		switch msg.Type {
+		case "loop_marker":
+			got = append(got, msg.Content)
+		case "output":
+			got = append(got, fmt.Sprintf("%d: %s", msg.Loop, msg.Content))
+			l.Hibernate(time.Now().Add(time.Hour))
+		case "complete":
+			got = append(got, msg.Content)
+			cancel()
+		}
+	}
+	want := []string{
+		"======= LOOP 1/2 =======",
+		"1: " + records[1][0],
+		"1: " + records[1][1],
+		"======= LOOP 2/2 =======",
+		"2: " + records[2][0],
+		"======= REPLAYED 2 ITERATIONS =======",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got replay:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if l.IsHibernating() {
+		t.Error("Expected a replay to ignore Hibernate")
+	}
+}
+
+// TestReplayPause tests that pausing a replay holds the playback until it
+// is resumed.
+func TestReplayPause(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1.jsonl")
+	if err := os.WriteFile(path, []byte("{\"type\":\"system\"}\n{\"type\":\"result\"}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l := loop.NewReplay([]string{path}, nil, 50*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+
+	outputs, stopped := 0, false
+	for msg := range l.Output() {
+		switch {
+		case msg.Type == "output":
+			outputs++
+			if outputs == 1 {
+				l.Pause()
+			}
+		case msg.Content == "======= LOOP STOPPED =======":
+			if outputs != 1 {
+				t.Errorf("Expected the playback to stop after the first record, got %d", outputs)
+			}
+			stopped = true
+			l.Resume()
+		case msg.Type == "complete":
+			cancel()
+		}
+	}
+	if !stopped || outputs != 2 {
+		t.Errorf("Expected the playback to stop, then finish after resuming; stopped=%v with %d records", stopped, outputs)
+	}
+}
+
+// TestLoopCommitReport tests that each iteration's commits are reported on a
+// GIT marker, and an iteration without one warned about with WarnNoCommit
+// when it left changes uncommitted.
+func TestLoopCommitReport(t *testing.T) {
+	dir := initRepo(t)
+	origDir, _ := os.Getwd()
+	defer os.Chdir(origDir)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// The agent commits a file in the first iteration, changes nothing in the
+	// second and leaves a file uncommitted in the third
+	runs := 0
+	builder := func(ctx context.Context, prompt string) *exec.Cmd {
+		runs++
+		switch runs {
+		case 1:
+			if err := os.WriteFile("notes.txt", []byte("done\n"), 0644); err != nil {
+				t.Error(err)
+			}
+			gitIn(t, dir, "add", "notes.txt")
+			gitIn(t, dir, "commit", "-q", "-m", "Add notes")
+		case 3:
+			if err := os.WriteFile("todo.txt", []byte("later\n"), 0644); err != nil {
+				t.Error(err)
+			}
+		}
+		return mockCommandBuilder(ctx, prompt)
+	}
+	l := loop.New(loop.Config{
+		Iterations:     3,
+		Prompt:         "prompt",
+		CommandBuilder: builder,
+		SleepDuration:  1 * time.Millisecond,
+		CommitReport:   true,
+		WarnNoCommit:   true,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+	var reports []loop.Message
+	for msg := range l.Output() {
+		if msg.Changes != nil {
+			reports = append(reports, msg)
+		}
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+
+	if len(reports) != 2 {
+		t.Fatalf("Expected GIT markers for iterations 1 and 3 only, got %+v", reports)
+	}
+	if got := reports[0].Content; reports[0].Loop != 1 || got != "======= GIT: 1 commit, 1 file changed, +1 -0 =======" {
+		t.Errorf("Unexpected report for iteration 1: %q", got)
+	}
+	if got := reports[1].Content; reports[1].Loop != 3 || got != "======= GIT WARNING: no commit, uncommitted changes left =======" {
+		t.Errorf("Unexpected report for iteration 3: %q", got)
+	}
+}
+
+// TestLoopEditReportOutsideGit tests that outside a git repository the
+// commit report falls back to the lines the agent's edits changed, as their
+// tool results report them.
+func TestLoopEditReportOutsideGit(t *testing.T) {
+	dir := t.TempDir()
+	origDir, _ := os.Getwd()
+	defer os.Chdir(origDir)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	stream := strings.Join([]string{
+		`{"type":"system","subtype":"init","session_id":"s1"}`,
+		`{"type":"assistant","message":{"content":[{"type":"tool_use","id":"e1","name":"Edit","input":{"file_path":"main.go"}},{"type":"tool_use","id":"r1","name":"Read","input":{"file_path":"go.mod"}}]}}`,
+		`{"type":"user","message":{"content":[{"type":"tool_result","tool_use_id":"e1","content":"The file main.go has been updated."}]},"tool_use_result":{"filePath":"main.go","structuredPatch":[{"lines":[" a","-b","+c","+d"]}]}}`,
+		`{"type":"user","message":{"content":[{"type":"tool_result","tool_use_id":"r1","content":"1 file changed, 9 insertions(+)"}]}}`,
+		`{"type":"result","total_cost_usd":0.1}`,
+	}, "\n")
+	if err := os.WriteFile("stream.jsonl", []byte(stream+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l := loop.New(loop.Config{
+		Iterations: 1,
+		Prompt:     "prompt",
+		CommandBuilder: func(ctx context.Context, prompt string) *exec.Cmd {
+			return exec.CommandContext(ctx, "cat", "stream.jsonl")
+		},
+		SleepDuration: 1 * time.Millisecond,
+		CommitReport:  true,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+	var reports []loop.Message
+	for msg := range l.Output() {
+		if msg.Changes != nil {
+			reports = append(reports, msg)
+		}
+		if msg.Type == "complete" {
+			cancel()
+		}
+	}
+
+	if len(reports) != 1 || reports[0].Content != "======= EDITS: 1 file edited, +2 -1 =======" {
+		t.Fatalf("Expected an EDITS marker counting the Edit only, got %+v", reports)
+	}
+	if c := reports[0].Changes; c.Files != 1 || c.Insertions != 2 || c.Deletions != 1 {
+		t.Errorf("Unexpected changes attached: %+v", c)
+	}
+}
+
+// TestLoopAgentTimeoutFailsIteration tests that an agent running past
+// AgentTimeout is killed and its iteration fails, and is retried like any
+// other failure.
+func TestLoopAgentTimeoutFailsIteration(t *testing.T) {
+	l := loop.New(loop.Config{
+		Iterations: 1,
+		Prompt:     "prompt",
+		CommandBuilder: func(ctx context.Context, prompt string) *exec.Cmd {
+			return exec.CommandContext(ctx, "sleep", "10")
+		},
+		SleepDuration: 1 * time.Millisecond,
+		AgentTimeout:  100 * time.Millisecond,
+		RetryFailed:   1,
+		RetryBackoff:  time.Millisecond,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	started := time.Now()
+	l.Start(ctx)
+
+	var markers, errs []string
+	for msg := range l.Output() {
+		switch msg.Type {
+		case "loop_marker":
+			markers = append(markers, msg.Content)
+		case "error":
+			errs = append(errs, msg.Content)
+		case "complete":
+			cancel()
+		}
+	}
+
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Expected the agent to be killed after its timeout, the run took %v", elapsed)
+	}
+	if len(errs) != 2 || !strings.Contains(errs[0], "iteration timed out after 100ms") {
+		t.Errorf("Expected both attempts to fail with a timeout, got %q", errs)
+	}
+	if !strings.Contains(strings.Join(markers, "\n"), "ITERATION FAILED, RETRY 1/1") {
+		t.Errorf("Expected the timed out iteration to be retried, got %q", markers)
+	}
+}
+
+// TestLoopDeadlineCompletesRun tests that the run starts no iteration after
+// its Deadline and completes with the iterations it finished.
+func TestLoopDeadlineCompletesRun(t *testing.T) {
+	l := loop.New(loop.Config{
+		Iterations:     5,
+		Prompt:         "prompt",
+		CommandBuilder: mockMediumSlowCommandBuilder,
+		SleepDuration:  1 * time.Millisecond,
+		Deadline:       time.Now().Add(100 * time.Millisecond),
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+	if l.Deadline().IsZero() {
+		t.Error("Expected the loop to report its deadline")
+	}
+
+	var markers []string
+	var complete string
+	for msg := range l.Output() {
+		switch msg.Type {
+		case "loop_marker":
+			markers = append(markers, msg.Content)
+		case "complete":
+			complete = msg.Content
+			cancel()
+		}
+	}
+
+	want := []string{
+		"======= LOOP 1/5 =======",
+		"======= TIME LIMIT REACHED AFTER 1 ITERATIONS, STOPPING =======",
+	}
+	if strings.Join(markers, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got markers:\n%s\nwant:\n%s", strings.Join(markers, "\n"), strings.Join(want, "\n"))
+	}
+	if !strings.Contains(complete, "COMPLETED 1 ITERATIONS") {
+		t.Errorf("Expected the run to complete after 1 iteration, got %q", complete)
+	}
+	if !l.Deadline().IsZero() {
+		t.Error("Expected the deadline to be spent once reached")
+	}
+}
+
+// TestLoopRestartsStalledAgent tests that an agent writing no output for
+// NoOutputTimeout is killed and restarted once, while one that keeps writing
+// runs to the end.
+func TestLoopRestartsStalledAgent(t *testing.T) {
+	var calls atomic.Int32
+	l := loop.New(loop.Config{
+		Iterations: 2,
+		Prompt:     "prompt",
+		CommandBuilder: func(ctx context.Context, prompt string) *exec.Cmd {
+			switch calls.Add(1) {
+			case 1:
+				return exec.CommandContext(ctx, "sh", "-c", `echo '{"type":"system"}'; exec sleep 10`)
+			case 2:
+				return exec.CommandContext(ctx, "echo", `{"type":"result"}`)
+			}
+			return exec.CommandContext(ctx, "sh", "-c", `for i in 1 2 3 4 5 6; do echo '{"type":"system"}'; sleep 0.1; done`)
+		},
+		SleepDuration:   1 * time.Millisecond,
+		NoOutputTimeout: 300 * time.Millisecond,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	started := time.Now()
+	l.Start(ctx)
+
+	var markers, errs []string
+	for msg := range l.Output() {
+		switch msg.Type {
+		case "loop_marker":
+			markers = append(markers, msg.Content)
+		case "error":
+			errs = append(errs, msg.Content)
+		case "complete":
+			cancel()
+		}
+	}
+
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Expected the stalled agent to be killed, the run took %v", elapsed)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("Expected the stalled agent to be restarted once and the busy one left alone, got %d runs", got)
+	}
+	if !strings.Contains(strings.Join(markers, "\n"), "AGENT STALLED: NO OUTPUT FOR 300ms, RESTARTING") {
+		t.Errorf("Expected a stalled marker, got %q", markers)
+	}
+	if len(errs) != 0 {
+		t.Errorf("Expected the restart to succeed, got errors %q", errs)
+	}
+}
+
+// TestLoopRoutesModels tests that the agent is given Model, that an
+// iteration's retries switch to FallbackModel once it has failed
+// FallbackAfter times, and that the next iteration goes back to Model.
+func TestLoopRoutesModels(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "models")
+	// Each attempt appends its arguments; the first two fail
+	script := fmt.Sprintf(`echo "$@" >> %s; [ $(wc -l < %s) -gt 2 ]`, log, log)
+	l := loop.New(loop.Config{
+		Iterations: 2,
+		Prompt:     "prompt",
+		CommandBuilder: func(ctx context.Context, prompt string) *exec.Cmd {
+			return exec.CommandContext(ctx, "sh", "-c", script, "sh")
+		},
+		SleepDuration: 1 * time.Millisecond,
+		Model:         "cheap",
+		FallbackModel: "premium",
+		FallbackAfter: 2,
+		RetryFailed:   3,
+		RetryBackoff:  time.Millisecond,
+		DoneMarkers:   true,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+
+	var markers []string
+	var done []loop.Message
+	for msg := range l.Output() {
+		switch msg.Type {
+		case "loop_marker":
+			markers = append(markers, msg.Content)
+		case "loop_marker_done":
+			done = append(done, msg)
+		case "complete":
+			cancel()
+		}
+	}
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--model cheap\n--model cheap\n--model premium\n--model cheap\n"
+	if string(data) != want {
+		t.Errorf("Expected the models\n%s\ngot\n%s", want, data)
+	}
+	if !strings.Contains(strings.Join(markers, "\n"), "SWITCHING TO MODEL premium AFTER 2 FAILURES") {
+		t.Errorf("Expected a model switch marker, got %q", markers)
+	}
+	if len(done) != 2 || done[0].Model != "premium" || done[1].Model != "cheap" {
+		t.Errorf("Expected each iteration's done marker to name its model, got %+v", done)
+	}
+}
+
+// TestLoopDrainsStreamAfterFramingError tests that an agent that keeps
+// writing after output the stream format can't frame still finishes: the
+// rest of its stdout is drained rather than left to fill the pipe.
+func TestLoopDrainsStreamAfterFramingError(t *testing.T) {
+	l := loop.New(loop.Config{
+		Iterations: 1,
+		Prompt:     "prompt",
+		CommandBuilder: func(ctx context.Context, prompt string) *exec.Cmd {
+			return exec.CommandContext(ctx, "sh", "-c", `echo 'warning: not json'; head -c 500000 /dev/zero | tr '\0' x`)
+		},
+		SleepDuration: 1 * time.Millisecond,
+		StreamFormat:  loop.StreamFormatConcat,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.Start(ctx)
+
+	var errs []string
+	completed := false
+	for msg := range l.Output() {
+		switch msg.Type {
+		case "error":
+			errs = append(errs, msg.Content)
+		case "complete":
+			completed = true
+			cancel()
+		}
+	}
+	if !completed {
+		t.Fatal("Expected the iteration to finish after the framing error")
+	}
+	if len(errs) == 0 || !strings.Contains(errs[0], "output stream error") {
+		t.Errorf("Expected the framing error to be reported, got %q", errs)
+	}
+}
@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudosai/ralph-go/internal/notify"
+	"github.com/cloudosai/ralph-go/internal/stats"
+)
+
+// webhookRecorder is a webhook endpoint that records the payloads it gets.
+type webhookRecorder struct {
+	mu       sync.Mutex
+	payloads []notify.Payload
+	bodies   []map[string]interface{} // the same requests, undecoded
+	status   int
+}
+
+func (w *webhookRecorder) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	data, _ := io.ReadAll(r.Body)
+	var p notify.Payload
+	var body map[string]interface{}
+	json.Unmarshal(data, &p)
+	json.Unmarshal(data, &body)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.payloads = append(w.payloads, p)
+	w.bodies = append(w.bodies, body)
+	if w.status != 0 {
+		rw.WriteHeader(w.status)
+	}
+}
+
+func TestNotifierSendsPayloadsInOrder(t *testing.T) {
+	rec := &webhookRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	n := notify.New(srv.URL, notify.JSON, nil, "run-1", "owner/repo", "main")
+	n.Send(notify.Payload{Event: notify.RunStart, Message: "/work"})
+	n.Send(notify.Payload{Event: notify.IterationComplete, Iteration: 1, CostUSD: 0.25, Tokens: 1200})
+	n.Send(notify.Payload{Event: notify.RunComplete, Outcome: "success"})
+	if err := n.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	n.Send(notify.Payload{Event: notify.Error}) // after Close: dropped
+
+	if len(rec.payloads) != 3 {
+		t.Fatalf("Expected 3 payloads, got %+v", rec.payloads)
+	}
+	for i, want := range []string{notify.RunStart, notify.IterationComplete, notify.RunComplete} {
+		p := rec.payloads[i]
+		if p.Event != want || p.RunID != "run-1" || p.Repo != "owner/repo" || p.Branch != "main" || p.Time.IsZero() {
+			t.Errorf("Payload %d: got %+v, want a stamped %s", i, p, want)
+		}
+	}
+	if p := rec.payloads[1]; p.Iteration != 1 || p.CostUSD != 0.25 || p.Tokens != 1200 {
+		t.Errorf("Unexpected iteration payload %+v", p)
+	}
+}
+
+func TestNotifierFiltersEvents(t *testing.T) {
+	rec := &webhookRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	n := notify.New(srv.URL, notify.JSON, []string{notify.Error, notify.RunComplete}, "run-1", "/", "")
+	n.Send(notify.Payload{Event: notify.RunStart})
+	n.Send(notify.Payload{Event: notify.Error, Message: "boom"})
+	n.Send(notify.Payload{Event: notify.IterationComplete})
+	n.Close(context.Background())
+
+	if len(rec.payloads) != 1 || rec.payloads[0].Event != notify.Error || rec.payloads[0].Repo != "" {
+		t.Errorf("Expected only the error payload, got %+v", rec.payloads)
+	}
+}
+
+func TestNotifierReportsFailedDeliveries(t *testing.T) {
+	srv := httptest.NewServer(&webhookRecorder{status: http.StatusInternalServerError})
+	defer srv.Close()
+
+	n := notify.New(srv.URL, notify.JSON, nil, "run-1", "", "")
+	n.Send(notify.Payload{Event: notify.RunStart})
+	err := n.Close(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("Expected the 500 to be reported, got %v", err)
+	}
+}
+
+func TestNotifierWaitsNoLongerThanCtx(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { <-release }))
+	defer srv.Close()
+	defer close(release)
+
+	n := notify.New(srv.URL, notify.JSON, nil, "run-1", "", "")
+	n.Send(notify.Payload{Event: notify.RunStart})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := n.Close(ctx); err == nil {
+		t.Error("Expected Close to give up when ctx is done")
+	}
+}
+
+func TestNotifierNil(t *testing.T) {
+	n := notify.New("", notify.JSON, nil, "run-1", "", "")
+	if n != nil {
+		t.Fatal("Expected no notifier without a webhook URL")
+	}
+	n.Send(notify.Payload{Event: notify.RunStart})
+	if err := n.Close(context.Background()); err != nil {
+		t.Errorf("Close on a nil notifier: %v", err)
+	}
+}
+
+// runComplete is a finished run's summary, as main sends it.
+var runComplete = notify.Payload{
+	Event:          notify.RunComplete,
+	Outcome:        "success",
+	CostUSD:        1.5,
+	Tokens:         2_500_000,
+	Iterations:     4,
+	ElapsedSeconds: 754.6,
+}
+
+func TestNotifierSlackSummary(t *testing.T) {
+	rec := &webhookRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	stats.SetCostFormat("€", 2) // --cost-symbol € --cost-decimals 2
+	defer stats.SetCostFormat("$", 6)
+	n := notify.New(srv.URL, notify.Slack, []string{notify.RunComplete}, "run-1", "owner/repo", "main")
+	n.Send(notify.Payload{Event: notify.RunStart})
+	n.Send(runComplete)
+	if err := n.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if len(rec.bodies) != 1 {
+		t.Fatalf("Expected only the run summary, got %+v", rec.bodies)
+	}
+	body, _ := json.Marshal(rec.bodies[0])
+	for _, want := range []string{
+		`"text":"✅ ralph run finished: owner/repo (main)"`,
+		`"color":"#2EB67D"`,
+		`{"short":true,"title":"Cost","value":"€1.50"}`,
+		`{"short":true,"title":"Tokens","value":"2.50m"}`,
+		`{"short":true,"title":"Iterations","value":"4"}`,
+		`{"short":true,"title":"Elapsed","value":"12m34s"}`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Slack message missing %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestNotifierDiscordSummary(t *testing.T) {
+	rec := &webhookRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	failed := runComplete
+	failed.Outcome = "error"
+	n := notify.New(srv.URL, notify.Discord, []string{notify.RunComplete}, "run-1", "owner/repo", "main")
+	n.Send(failed)
+	if err := n.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if len(rec.bodies) != 1 {
+		t.Fatalf("Expected the run summary, got %+v", rec.bodies)
+	}
+	body, _ := json.Marshal(rec.bodies[0])
+	for _, want := range []string{
+		`"title":"❌ ralph run failed: owner/repo (main)"`,
+		`"color":14687834`,
+		`{"inline":true,"name":"Cost","value":"$1.500000"}`,
+		`{"inline":true,"name":"Elapsed","value":"12m34s"}`,
+		`{"inline":true,"name":"Run","value":"run-1"}`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Discord message missing %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestNotifierGroup(t *testing.T) {
+	rec := &webhookRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	failing := httptest.NewServer(&webhookRecorder{status: http.StatusBadGateway})
+	defer failing.Close()
+
+	g := notify.Group{
+		notify.New(srv.URL, notify.JSON, nil, "run-1", "", ""),
+		notify.New("", notify.Slack, nil, "run-1", "", ""), // not configured
+		notify.New(failing.URL, notify.Discord, nil, "run-1", "", ""),
+	}
+	g.Send(runComplete)
+	err := g.Close(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("Expected the failing webhook's 502, got %v", err)
+	}
+	if len(rec.payloads) != 1 || rec.payloads[0].Iterations != 4 {
+		t.Errorf("Expected the JSON webhook to get the payload, got %+v", rec.payloads)
+	}
+}
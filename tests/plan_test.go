@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudosai/ralph-go/internal/plan"
+)
+
+func TestPlanParse(t *testing.T) {
+	p := plan.Parse(`# Implementation Plan
+
+# Phase 1: Foundations
+
+## TASK 1: Add the parser
+**Priority: HIGH**
+**Status: DONE**
+
+## TASK 2: Add flags — **Status: in_progress**
+
+### Notes
+**Status: DONE**
+
+# Phase 2: Polish
+
+## Task 3. Write docs
+Status: not-needed
+
+## TASK 4
+- no status yet
+`)
+	want := []plan.Task{
+		{Number: 1, Title: "Add the parser", Phase: "Phase 1: Foundations", Status: plan.StatusDone},
+		{Number: 2, Title: "Add flags", Phase: "Phase 1: Foundations", Status: plan.StatusInProgress},
+		{Number: 3, Title: "Write docs", Phase: "Phase 2: Polish", Status: plan.StatusNotNeeded},
+		{Number: 4, Phase: "Phase 2: Polish", Status: plan.StatusTodo},
+	}
+	if len(p.Tasks) != len(want) {
+		t.Fatalf("Expected %d tasks, got %+v", len(want), p.Tasks)
+	}
+	for i, task := range p.Tasks {
+		if task != want[i] {
+			t.Errorf("Task %d: expected %+v, got %+v", i+1, want[i], task)
+		}
+	}
+	if completed, total := p.Counts(); completed != 2 || total != 4 {
+		t.Errorf("Expected 2/4 completed, got %d/%d", completed, total)
+	}
+	if cur := p.Current(); cur == nil || cur.Label() != "#2 Add flags" {
+		t.Errorf("Expected the IN PROGRESS task as current, got %+v", cur)
+	}
+}
+
+func TestPlanCurrentFallsBackToNextTask(t *testing.T) {
+	p := plan.Parse("## TASK 1: First\n**Status: DONE**\n\n## TASK 2: Second\n**Status: TODO**\n\n## TASK 3: Third\n")
+	if cur := p.Current(); cur == nil || cur.Number != 2 {
+		t.Errorf("Expected the first task not completed as current, got %+v", cur)
+	}
+	done := plan.Parse("## TASK 1: First\n**Status: DONE**\n")
+	if cur := done.Current(); cur != nil {
+		t.Errorf("Expected no current task once all are done, got %+v", cur)
+	}
+}
+
+func TestPlanWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "IMPLEMENTATION_PLAN.md")
+	if err := os.WriteFile(path, []byte("## TASK 1: First\n**Status: TODO**\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changes := make(chan *plan.Plan, 4)
+	stop := plan.Watch(path, 10*time.Millisecond, func(p *plan.Plan) { changes <- p })
+	defer stop()
+
+	if err := os.WriteFile(path, []byte("## TASK 1: First\n**Status: DONE**\n\n## TASK 2: Second\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case p := <-changes:
+		if completed, total := p.Counts(); completed != 1 || total != 2 {
+			t.Errorf("Expected the edited plan with 1/2 done, got %d/%d", completed, total)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the change to be reported")
+	}
+
+	os.Remove(path)
+	select {
+	case p := <-changes:
+		if len(p.Tasks) != 0 {
+			t.Errorf("Expected a removed plan to have no tasks, got %+v", p.Tasks)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the removal to be reported")
+	}
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudosai/ralph-go/internal/poster"
+)
+
+// TestPosterSendsInOrder tests that queued requests are POSTed one at a time
+// in order, and that the final requests given to Close wait for room in a
+// full queue rather than being dropped
+func TestPosterSendsInOrder(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	p := poster.New(srv.URL, 1)
+	if !p.Queue(poster.Request{What: "first", Body: []byte("1")}) {
+		t.Fatal("Expected the first request queued")
+	}
+	// The first is in flight once the queue has room again
+	deadline := time.Now().Add(5 * time.Second)
+	for !p.Queue(poster.Request{What: "second", Body: []byte("2")}) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected room for the second request")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if p.Queue(poster.Request{What: "third", Body: []byte("3")}) {
+		t.Error("Expected a request beyond the queue size to be dropped")
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Close(ctx, poster.Request{What: "last", Body: []byte("4")}); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(bodies, ","); got != "1,2,4" {
+		t.Errorf("Expected requests 1, 2 and 4 in order, got %s", got)
+	}
+	if p.Queue(poster.Request{What: "late", Body: []byte("5")}) {
+		t.Error("Expected requests after Close to be refused")
+	}
+}
+
+// TestPosterReportsFailure tests that Close returns the first rejected
+// request, naming it
+func TestPosterReportsFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	p := poster.New(srv.URL, 4)
+	p.Queue(poster.Request{What: "error webhook", Body: []byte("{}")})
+	p.Queue(poster.Request{What: "run_complete webhook", Body: []byte("{}")})
+	err := p.Close(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "sending error webhook") || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Expected the first failure reported, got %v", err)
+	}
+}
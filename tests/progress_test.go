@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/cloudosai/ralph-go/internal/progress"
+)
+
+// progressLineRe is the documented progress line format: the prefix, then
+// the fixed keys in order, with space-free values.
+var progressLineRe = regexp.MustCompile(`^RALPH_PROGRESS loop=\d+/\d+ cost=\d+\.\d{4} tokens=\d+ status=(running|paused|hibernating|complete|failed) task=\S+$`)
+
+func TestProgressFormat(t *testing.T) {
+	line := progress.Format(progress.State{Loop: 3, Total: 20, CostUSD: 1.23, Tokens: 450000, Status: progress.StatusRunning, Task: "#6"})
+	if want := "RALPH_PROGRESS loop=3/20 cost=1.2300 tokens=450000 status=running task=#6"; line != want {
+		t.Errorf("Format() = %q, want %q", line, want)
+	}
+	if !progressLineRe.MatchString(line) {
+		t.Errorf("Expected %q to match the documented format", line)
+	}
+
+	line = progress.Format(progress.State{Status: progress.StatusComplete})
+	if want := "RALPH_PROGRESS loop=0/0 cost=0.0000 tokens=0 status=complete task=-"; line != want {
+		t.Errorf("Format() = %q, want %q", line, want)
+	}
+	if !progressLineRe.MatchString(line) {
+		t.Errorf("Expected %q to match the documented format", line)
+	}
+}
+
+func TestProgressWriterSkipsUnchangedState(t *testing.T) {
+	var buf bytes.Buffer
+	w := progress.NewWriter(&buf)
+	state := progress.State{Loop: 1, Total: 5, Status: progress.StatusRunning}
+	w.Update(state)
+	w.Update(state)
+	state.Tokens = 1200
+	w.Update(state)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected one line per state change, got %q", lines)
+	}
+	if !strings.Contains(lines[1], " tokens=1200 ") {
+		t.Errorf("Expected the second line to report the new tokens, got %q", lines[1])
+	}
+
+	var nilWriter *progress.Writer
+	nilWriter.Update(state) // must not panic
+	if err := nilWriter.Close(); err != nil {
+		t.Errorf("Expected a nil writer to close cleanly, got %v", err)
+	}
+}
+
+func TestProgressOpen(t *testing.T) {
+	if w, err := progress.Open(""); w != nil || err != nil {
+		t.Errorf("Expected no writer without a target, got %v, %v", w, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "progress")
+	w, err := progress.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Update(progress.State{Loop: 2, Total: 3, Status: progress.StatusHibernating})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if got := string(data); got != "RALPH_PROGRESS loop=2/3 cost=0.0000 tokens=0 status=hibernating task=-\n" {
+		t.Errorf("Unexpected progress file contents %q", got)
+	}
+
+	if _, err := progress.Open(filepath.Join(t.TempDir(), "missing", "progress")); err == nil {
+		t.Error("Expected an error for an unwritable target")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/cloudosai/ralph-go/internal/prompt"
 )
@@ -622,3 +623,73 @@ func TestLoadEmptyFile(t *testing.T) {
 		t.Errorf("Expected empty content for empty file, got: %q", content)
 	}
 }
+
+func TestLoadFallsBackWhenEmbeddedPromptMissing(t *testing.T) {
+	restore := prompt.SetEmbeddedFSForTest(fstest.MapFS{})
+	defer restore()
+
+	loader := prompt.NewLoader("", "Ship the CLI", "TODO.md")
+	content, err := loader.Load()
+	if err != nil {
+		t.Fatalf("Expected fallback prompt instead of an error, got %v", err)
+	}
+	if !loader.UsedFallback() {
+		t.Error("Expected UsedFallback() to be true")
+	}
+	if !strings.Contains(content, "ULTIMATE GOAL: Ship the CLI.") {
+		t.Errorf("Expected goal substituted into fallback prompt, got:\n%s", content)
+	}
+	if !strings.Contains(content, "TODO.md") || strings.Contains(content, "IMPLEMENTATION_PLAN.md") {
+		t.Errorf("Expected plan file substituted into fallback prompt, got:\n%s", content)
+	}
+
+	planContent, err := prompt.NewPlanLoader("", "", "").Load()
+	if err != nil || planContent != strings.Replace(prompt.FallbackPlanPrompt, "$ultimate_goal_placeholder_sentence. ", "", 1) {
+		t.Errorf("Expected plan fallback prompt, got %q, %v", planContent, err)
+	}
+
+	arContent, err := prompt.NewAutoresearchLoader("", "", "measure latency").Load()
+	if err != nil || !strings.Contains(arContent, "measure latency") {
+		t.Errorf("Expected autoresearch fallback with experiment content, got %q, %v", arContent, err)
+	}
+
+	// An override file is never replaced by the fallback
+	if _, err := prompt.NewLoader("/nonexistent/prompt.md", "", "").Load(); err == nil {
+		t.Error("Expected a missing override file to still be an error")
+	}
+}
+
+func TestLoadUsesEmbeddedPromptNormally(t *testing.T) {
+	loader := prompt.NewLoader("", "", "")
+	content, err := loader.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loader.UsedFallback() || content == prompt.FallbackPrompt {
+		t.Error("Expected the embedded prompt, not the fallback")
+	}
+}
+
+func TestRenderPromptTemplate(t *testing.T) {
+	vars := prompt.NewVars("specs/", "ship it", "ralph/run", map[string]string{"team": "payments"})
+	got, err := prompt.Render("{{.Iteration}}/{{.TotalIterations}} {{.SpecPath}} {{.Goal}} {{.Branch}} {{.team}}", vars, 2, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2/5 specs/ ship it ralph/run payments"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	if _, err := prompt.Render("hello {{.Tema}}", vars, 1, 5); err == nil || !strings.Contains(err.Error(), "Tema") {
+		t.Errorf("Expected an unknown variable to be an error, got %v", err)
+	}
+	if _, err := prompt.Render("hello {{.team", vars, 1, 5); err == nil || !strings.Contains(err.Error(), "parsing") {
+		t.Errorf("Expected a malformed template to be an error, got %v", err)
+	}
+
+	// Prompts without template actions pass through untouched
+	plain := "Study $loop_iteration; keep `}}` as is"
+	if got, err := prompt.Render(plain, nil, 1, 1); err != nil || got != plain {
+		t.Errorf("Expected a plain prompt unchanged, got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/cloudosai/ralph-go/internal/parser"
+	"github.com/cloudosai/ralph-go/internal/render"
+)
+
+func TestRenderText(t *testing.T) {
+	var out, errOut bytes.Buffer
+	r := render.New(render.FormatText, &out, &errOut)
+
+	r.Loop(2, 5, "======= LOOP 2/5 =======")
+	r.Emit(render.FromDisplayLine(parser.DisplayLine{Role: parser.DisplayAssistant, Text: "hello"}))
+	r.Emit(render.FromDisplayLine(parser.DisplayLine{Role: parser.DisplayTool, Text: "Read main.go", ToolName: "Read", Kind: parser.ToolKindRead, Location: "main.go"}))
+	r.Emit(render.FromDisplayLine(parser.DisplayLine{Role: parser.DisplayToolResult, Text: "no such file", IsError: true}))
+	r.Emit(render.FromDisplayLine(parser.DisplayLine{Role: parser.DisplayToolResult, IsError: true}))
+	r.Plain("summary", "ralph cli: done")
+	r.Errorf("error", "Iteration failed: %s", "boom")
+
+	want := "[loop] ======= LOOP 2/5 =======\n" +
+		"[assistant] hello\n" +
+		"[tool] (read) Read main.go\n" +
+		"[tool] failed: no such file\n" +
+		"[tool] failed\n" +
+		"ralph cli: done\n"
+	if out.String() != want {
+		t.Errorf("Unexpected text output:\n%s\nwant:\n%s", out.String(), want)
+	}
+	if errOut.String() != "[error] Iteration failed: boom\n" {
+		t.Errorf("Expected errors on stderr, got %q", errOut.String())
+	}
+}
+
+func TestRenderJSON(t *testing.T) {
+	var out, errOut bytes.Buffer
+	r := render.New(render.FormatJSON, &out, &errOut)
+	if !r.JSON() {
+		t.Error("Expected a JSON renderer")
+	}
+
+	r.Printf("phase", "Planning (%d iteration)", 1)
+	r.Loop(3, 10, "======= LOOP 3/10 =======")
+	r.Emit(render.FromDisplayLine(parser.DisplayLine{Role: parser.DisplayTool, Text: "Edit main.go", ToolName: "Edit", Kind: parser.ToolKindEdit, Location: "main.go", ToolUseID: "t1"}))
+	r.Emit(render.Event{Type: "cost", Content: "Iteration cost: $0.25", CostUSD: 0.25})
+	r.Errorf("error", "boom")
+
+	if errOut.Len() != 0 {
+		t.Errorf("Expected JSON output on stdout only, got stderr %q", errOut.String())
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("Expected 5 JSON lines, got %d:\n%s", len(lines), out.String())
+	}
+	var events []render.Event
+	for _, line := range lines {
+		var e render.Event
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("Expected a JSON object per line, got %q: %v", line, err)
+		}
+		if e.Time.IsZero() {
+			t.Errorf("Expected a timestamp on %q", line)
+		}
+		events = append(events, e)
+	}
+
+	if events[0].Type != "phase" || events[0].Iteration != 0 || events[0].Content != "Planning (1 iteration)" {
+		t.Errorf("Unexpected phase event: %+v", events[0])
+	}
+	if events[1].Type != "loop" || events[1].Iteration != 3 || events[1].Total != 10 {
+		t.Errorf("Unexpected loop event: %+v", events[1])
+	}
+	tool := events[2]
+	if tool.Type != "tool" || tool.Tool != "Edit" || tool.Kind != "edit" || tool.Location != "main.go" || tool.ToolUseID != "t1" || tool.Iteration != 3 {
+		t.Errorf("Unexpected tool event: %+v", tool)
+	}
+	if events[3].Type != "cost" || events[3].CostUSD != 0.25 {
+		t.Errorf("Unexpected cost event: %+v", events[3])
+	}
+	if events[4].Type != "error" || events[4].Content != "boom" {
+		t.Errorf("Unexpected error event: %+v", events[4])
+	}
+	if strings.Contains(lines[2], `"Text"`) || strings.Contains(lines[2], "[tool]") {
+		t.Errorf("Expected the text rendering to stay out of JSON, got %s", lines[2])
+	}
+}
+
+func TestRenderNil(t *testing.T) {
+	var r *render.Renderer
+	r.Printf("loop", "ignored")
+	r.Loop(1, 1, "ignored")
+	r.Errorf("error", "ignored")
+	if r.JSON() {
+		t.Error("A nil renderer is not JSON")
+	}
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudosai/ralph-go/internal/runlog"
+)
+
+var runlogClock = func() time.Time { return time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC) }
+
+func TestRunLogTextFormat(t *testing.T) {
+	var buf bytes.Buffer
+	w := runlog.NewWriter(&buf, runlog.FormatText, "run-1")
+	w.SetClockForTest(runlogClock)
+
+	w.Start("/work")
+	w.Log("assistant", "before any loop")
+	w.Loop(2, "======= LOOP 2/5 =======")
+	w.Log("thinking", "planning\n")
+
+	want := "\n--- ralph run run-1 started 2026-03-01T12:00:00Z in /work ---\n\n" +
+		"2026-03-01T12:00:00Z - [assistant] before any loop\n\n" +
+		"2026-03-01T12:00:00Z #2 [loop] ======= LOOP 2/5 =======\n\n" +
+		"2026-03-01T12:00:00Z #2 [thinking] planning\n\n"
+	if buf.String() != want {
+		t.Errorf("Unexpected text log:\n%q\nwant:\n%q", buf.String(), want)
+	}
+}
+
+func TestRunLogJSONFormat(t *testing.T) {
+	var buf bytes.Buffer
+	w := runlog.NewWriter(&buf, runlog.FormatJSON, "run-1")
+	w.SetClockForTest(runlogClock)
+
+	w.Start("/work")
+	w.Loop(1, "======= LOOP 1/5 =======")
+	w.Log("assistant", "line one\nline two")
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected one JSON object per entry, got %d lines: %q", len(lines), buf.String())
+	}
+	var entries []runlog.Entry
+	for _, line := range lines {
+		var e runlog.Entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("Invalid JSON line %q: %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+	if entries[0].Type != "run_start" || entries[0].Content != "/work" || entries[0].Iteration != 0 {
+		t.Errorf("Unexpected start entry %+v", entries[0])
+	}
+	if e := entries[2]; e.Type != "assistant" || e.Iteration != 1 || e.RunID != "run-1" ||
+		e.Content != "line one\nline two" || !e.Time.Equal(runlogClock()) {
+		t.Errorf("Unexpected assistant entry %+v", e)
+	}
+}
+
+func TestRunLogNilWriterDiscards(t *testing.T) {
+	var w *runlog.Writer
+	w.Start("/work")
+	w.Loop(1, "======= LOOP 1/1 =======")
+	w.Log("assistant", "ignored")
+}
+
+func TestRunLogTap(t *testing.T) {
+	var buf bytes.Buffer
+	w := runlog.NewWriter(&buf, runlog.FormatText, "run-1")
+	var tapped []runlog.Entry
+	w.SetTap(func(e runlog.Entry) { tapped = append(tapped, e) })
+
+	w.Loop(3, "======= LOOP 3/5 =======")
+	w.Log("assistant", "hello\n")
+
+	if len(tapped) != 2 || tapped[1].Type != "assistant" || tapped[1].Content != "hello" || tapped[1].Iteration != 3 || tapped[1].RunID != "run-1" {
+		t.Errorf("Expected every written entry passed to the tap, got %+v", tapped)
+	}
+	var nilWriter *runlog.Writer
+	nilWriter.SetTap(func(runlog.Entry) {})
+}
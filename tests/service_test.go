@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudosai/ralph-go/internal/service"
+)
+
+func TestServiceName(t *testing.T) {
+	for dir, want := range map[string]string{
+		"/home/me/my-app":    "ralph-my-app",
+		"/home/me/My App!":   "ralph-My-App",
+		"/srv/api.v2":        "ralph-api.v2",
+		"/":                  "ralph",
+		"/home/me/проект":    "ralph",
+		"/home/me/web_front": "ralph-web_front",
+	} {
+		if got := service.Name(dir); got != want {
+			t.Errorf("Name(%q) = %q, want %q", dir, got, want)
+		}
+	}
+}
+
+func TestServicePath(t *testing.T) {
+	if got := service.Path("linux", "/home/me", "", "ralph-app"); got != "/home/me/.config/systemd/user/ralph-app.service" {
+		t.Errorf("Expected a systemd user unit, got %s", got)
+	}
+	if got := service.Path("linux", "/home/me", "/xdg", "ralph-app"); got != "/xdg/systemd/user/ralph-app.service" {
+		t.Errorf("Expected the unit under XDG_CONFIG_HOME, got %s", got)
+	}
+	if got := service.Path("darwin", "/Users/me", "/xdg", "ralph-app"); got != "/Users/me/Library/LaunchAgents/ralph-app.plist" {
+		t.Errorf("Expected a launchd agent, got %s", got)
+	}
+}
+
+func TestServiceEnvironment(t *testing.T) {
+	env := service.Environment([]string{"HOME=/home/me", "PATH=/usr/bin:/bin", "RALPH_ITERATIONS=9", "ANTHROPIC_API_KEY=secret"})
+	if got := strings.Join(env, " "); got != "PATH=/usr/bin:/bin RALPH_ITERATIONS=9" {
+		t.Errorf("Expected only PATH and RALPH_* settings, got %s", got)
+	}
+}
+
+func TestServiceSystemd(t *testing.T) {
+	unit := service.Systemd(service.Spec{
+		Name:    "ralph-app",
+		Args:    []string{"/usr/local/bin/ralph", "build", "--goal", `say "hi" at 100%`, "--cli"},
+		Dir:     "/home/me/app",
+		Env:     []string{"PATH=/usr/bin:/bin", "RALPH_NOTE=$HOME"},
+		EnvFile: "/home/me/app/.ralph/service.env",
+	})
+	for _, want := range []string{
+		"WorkingDirectory=/home/me/app\n",
+		"Environment=PATH=/usr/bin:/bin\n",
+		"Environment=RALPH_NOTE=$HOME\n",
+		"EnvironmentFile=-/home/me/app/.ralph/service.env\n",
+		`ExecStart=/usr/local/bin/ralph build --goal "say \"hi\" at 100%%" --cli` + "\n",
+		"ExecReload=/bin/kill -HUP $MAINPID\n",
+		"Restart=on-failure\n",
+		"KillMode=mixed\n",
+		"TimeoutStopSec=" + service.StopTimeout + "\n",
+		"WantedBy=default.target\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("Expected %q in the unit:\n%s", want, unit)
+		}
+	}
+}
+
+// TestServiceSystemdEscaping tests that % is escaped wherever systemd expands
+// specifiers, and $ only in ExecStart, the one line that expands variables
+func TestServiceSystemdEscaping(t *testing.T) {
+	unit := service.Systemd(service.Spec{
+		Name:    "ralph-app",
+		Args:    []string{"/usr/local/bin/ralph", "--goal", "cut $5 of 10%"},
+		Dir:     "/home/me/100%app",
+		Env:     []string{"RALPH_GOAL=cut $5 of 10%"},
+		EnvFile: "/home/me/100%app/.ralph/service.env",
+	})
+	for _, want := range []string{
+		"Description=ralph loop in /home/me/100%%app\n",
+		"WorkingDirectory=/home/me/100%%app\n",
+		`Environment="RALPH_GOAL=cut $5 of 10%%"` + "\n",
+		"EnvironmentFile=-/home/me/100%%app/.ralph/service.env\n",
+		`ExecStart=/usr/local/bin/ralph --goal "cut $$5 of 10%%"` + "\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("Expected %q in the unit:\n%s", want, unit)
+		}
+	}
+}
+
+func TestServiceLaunchd(t *testing.T) {
+	plist := service.Launchd(service.Spec{
+		Name:    "ralph-app",
+		Args:    []string{"/usr/local/bin/ralph", "--goal", "a < b & c", "--cli"},
+		Dir:     "/Users/me/app",
+		Env:     []string{"PATH=/usr/bin:/bin"},
+		LogPath: "/Users/me/app/.ralph/service.log",
+	})
+	for _, want := range []string{
+		"<key>Label</key>\n\t<string>ralph-app</string>",
+		"<string>a &lt; b &amp; c</string>",
+		"<key>WorkingDirectory</key>\n\t<string>/Users/me/app</string>",
+		"<key>PATH</key>\n\t\t<string>/usr/bin:/bin</string>",
+		"<key>SuccessfulExit</key>\n\t\t<false/>",
+		"<key>StandardErrorPath</key>\n\t<string>/Users/me/app/.ralph/service.log</string>",
+	} {
+		if !strings.Contains(plist, want) {
+			t.Errorf("Expected %q in the plist:\n%s", want, plist)
+		}
+	}
+}
+
+func TestServiceWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "systemd", "user", "ralph-app.service")
+	replaced, err := service.Write(path, "one")
+	if err != nil || replaced {
+		t.Fatalf("Expected a new file, got replaced=%v err=%v", replaced, err)
+	}
+	if replaced, err = service.Write(path, "two"); err != nil || !replaced {
+		t.Fatalf("Expected the file to be replaced, got replaced=%v err=%v", replaced, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "two" {
+		t.Errorf("Expected the new content, got %q (%v)", data, err)
+	}
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudosai/ralph-go/internal/specs"
+)
+
+func TestSpecsIndex(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"b.md":         "bee",
+		"a.md":         "a",
+		"api/users.md": "users",
+		".hidden.md":   "skip",
+		".drafts/x.md": "skip",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := specs.Index(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(specs.Names(files), ","); got != "a.md,api/users.md,b.md" {
+		t.Fatalf("Expected the visible files sorted by name, got %s", got)
+	}
+	if files[2].Size != 3 || files[2].Path != filepath.Join(dir, "b.md") || files[2].ModTime.IsZero() {
+		t.Errorf("Expected b.md's size, path and modification time, got %+v", files[2])
+	}
+
+	if _, err := specs.Index(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected a missing folder to be an error")
+	}
+}
+
+func TestSpecsSelect(t *testing.T) {
+	files := []specs.File{{Name: "a.md"}, {Name: "api/users.md"}, {Name: "b.md"}}
+
+	selected, err := specs.Select(files, []string{"b.md", "./api/users.md"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(specs.Names(selected), ","); got != "api/users.md,b.md" {
+		t.Errorf("Expected the named files in index order, got %s", got)
+	}
+
+	if _, err := specs.Select(files, []string{"a.md", "c.md"}); err == nil || !strings.Contains(err.Error(), `"c.md"`) {
+		t.Errorf("Expected an unknown spec to be reported, got %v", err)
+	}
+}
+
+func TestSpecsContext(t *testing.T) {
+	if got := specs.Context("specs/", nil); got != "" {
+		t.Errorf("Expected no prompt section when every spec applies, got %q", got)
+	}
+	got := specs.Context("specs/", []string{"a.md", "api/users.md"})
+	if !strings.Contains(got, "SPECS:") || !strings.Contains(got, "- specs/a.md\n- specs/api/users.md\n") {
+		t.Errorf("Expected the chosen spec paths listed, got %q", got)
+	}
+}
+
+func TestSpecsFormatSize(t *testing.T) {
+	for n, want := range map[int64]string{812: "812 B", 4300: "4.2 KB", 1363149: "1.3 MB"} {
+		if got := specs.FormatSize(n); got != want {
+			t.Errorf("FormatSize(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -334,6 +334,58 @@ func TestPricingForModel(t *testing.T) {
 	}
 }
 
+// TestPriceRules tests that --price rules override the built-in pricing, the
+// last matching one winning, and that "*" also prices usage naming no model
+func TestPriceRules(t *testing.T) {
+	defer stats.SetPriceRules(nil)
+	var rules []stats.PriceRule
+	for _, s := range []string{"gpt-5=1.25/10", "opus=4/20/5/0.4", "GPT-5-mini=0.25/2/0/0.025"} {
+		rule, err := stats.ParsePriceRule(s)
+		if err != nil {
+			t.Fatalf("ParsePriceRule(%q): %v", s, err)
+		}
+		rules = append(rules, rule)
+	}
+	stats.SetPriceRules(rules)
+	// near reports whether a per-token price is perMillion USD per million tokens
+	near := func(price, perMillion float64) bool {
+		d := price*1_000_000 - perMillion
+		return d > -1e-9 && d < 1e-9
+	}
+
+	if p := stats.PricingForModel("gpt-5-codex"); !near(p.Input, 1.25) || !near(p.Output, 10) ||
+		!near(p.CacheCreation, 1.5625) || !near(p.CacheRead, 0.125) {
+		t.Errorf("gpt-5 rates = %+v, want input 1.25/1M, output 10/1M and the default cache rates", p)
+	}
+	if p := stats.PricingForModel("gpt-5-mini"); !near(p.Input, 0.25) || p.CacheCreation != 0 {
+		t.Errorf("Expected the later gpt-5-mini rule to win, got %+v", p)
+	}
+	if p := stats.PricingForModel("claude-opus-4-8"); !near(p.Input, 4) || !near(p.CacheRead, 0.4) {
+		t.Errorf("Expected the opus rule over the built-in tier, got %+v", p)
+	}
+	if p := stats.PricingForModel("claude-haiku-4-5"); !near(p.Input, 1) {
+		t.Errorf("Expected unmatched models to keep the built-in tiers, got %+v", p)
+	}
+	if p := stats.PricingForModel(""); p != stats.DefaultPricing {
+		t.Errorf("Expected usage naming no model to keep DefaultPricing, got %+v", p)
+	}
+
+	star, err := stats.ParsePriceRule("*=2/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats.SetPriceRules(append(rules, star))
+	if got := stats.EstimateCostFromTokens("", 1_000_000, 1_000_000, 0, 0); got < 9.999 || got > 10.001 {
+		t.Errorf("Expected * to price usage naming no model at $10, got %f", got)
+	}
+
+	for _, bad := range []string{"gpt-5", "=1/2", "gpt-5=1", "gpt-5=1/2/3", "gpt-5=1/x", "gpt-5=-1/2"} {
+		if _, err := stats.ParsePriceRule(bad); err == nil {
+			t.Errorf("Expected ParsePriceRule(%q) to fail", bad)
+		}
+	}
+}
+
 func TestReconcileCost(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -394,6 +446,63 @@ func TestFormatTokens(t *testing.T) {
 	}
 }
 
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		d        time.Duration
+		expected string
+	}{
+		{"zero", 0, "00:00:00"},
+		{"negative", -5 * time.Second, "00:00:00"},
+		{"sub-second truncated", 999 * time.Millisecond, "00:00:00"},
+		{"seconds", 42 * time.Second, "00:00:42"},
+		{"minutes", 3*time.Minute + 7*time.Second, "00:03:07"},
+		{"hours", time.Hour + 2*time.Minute + 5*time.Second, "01:02:05"},
+		{"just under a day", 23*time.Hour + 59*time.Minute + 59*time.Second, "23:59:59"},
+		{"exactly a day", 24 * time.Hour, "1d 00:00:00"},
+		{"one day two hours", 26 * time.Hour, "1d 02:00:00"},
+		{"multi-day", 3*24*time.Hour + 4*time.Hour + 5*time.Minute + 6*time.Second, "3d 04:05:06"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := stats.FormatDuration(tt.d)
+			if result != tt.expected {
+				t.Errorf("FormatDuration(%v) = %q, expected %q", tt.d, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatCost(t *testing.T) {
+	defer stats.SetCostFormat("$", 6)
+
+	tests := []struct {
+		name     string
+		symbol   string
+		decimals int
+		usd      float64
+		expected string
+	}{
+		{"default", "$", 6, 0.123456, "$0.123456"},
+		{"two decimals rounds", "$", 2, 1.235, "$1.24"},
+		{"zero decimals", "$", 0, 12.6, "$13"},
+		{"custom symbol", "€", 4, 0.5, "€0.5000"},
+		{"empty symbol", "", 3, 2, "2.000"},
+		{"negative decimals clamped", "$", -1, 3.7, "$4"},
+		{"too many decimals clamped", "$", 20, 1, "$1.0000000000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats.SetCostFormat(tt.symbol, tt.decimals)
+			if result := stats.FormatCost(tt.usd); result != tt.expected {
+				t.Errorf("FormatCost(%v) with %q/%d = %q, expected %q", tt.usd, tt.symbol, tt.decimals, result, tt.expected)
+			}
+		})
+	}
+}
+
 // --- DB Tests ---
 
 // helperInitTestDB creates a temp DB and returns it along with a cleanup function.
@@ -599,6 +708,143 @@ func TestFlushCheckpoint(t *testing.T) {
 	}
 }
 
+func TestFlushCheckpoint_RunID(t *testing.T) {
+	db, cleanup := helperInitTestDB(t)
+	defer cleanup()
+
+	err := stats.FlushCheckpoint(db, stats.CheckpointParams{
+		LoopID:    "abc123-1",
+		SessionID: "abc123",
+		DeltaCost: 0.01,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		RunID:     "run-42",
+	})
+	if err != nil {
+		t.Fatalf("FlushCheckpoint failed: %v", err)
+	}
+	var runID string
+	if err := db.QueryRow("SELECT run_id FROM checkpoints WHERE loop_id = ?", "abc123-1").Scan(&runID); err != nil {
+		t.Fatalf("Failed to query checkpoint: %v", err)
+	}
+	if runID != "run-42" {
+		t.Errorf("Expected run_id 'run-42', got %q", runID)
+	}
+}
+
+func TestInitDB_AddsRunIDToExistingCheckpoints(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "old.db")
+	old, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Schema from before run IDs were recorded
+	_, err = old.Exec(`CREATE TABLE checkpoints (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, loop_id TEXT NOT NULL, session_id TEXT NOT NULL,
+		owner TEXT, repo TEXT, branch TEXT, delta_cost REAL NOT NULL, delta_input_tokens INTEGER,
+		delta_output_tokens INTEGER, delta_cache_creation INTEGER, delta_cache_read INTEGER, timestamp TEXT NOT NULL)`)
+	old.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := stats.InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB on an old database failed: %v", err)
+	}
+	defer db.Close()
+	err = stats.FlushCheckpoint(db, stats.CheckpointParams{
+		LoopID:    "x-1",
+		SessionID: "x",
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		RunID:     "run-1",
+	})
+	if err != nil {
+		t.Fatalf("FlushCheckpoint after migration failed: %v", err)
+	}
+
+	// Opening again must not try to add the column twice
+	db.Close()
+	if db, err = stats.InitDB(dbPath); err != nil {
+		t.Fatalf("Reopening migrated database failed: %v", err)
+	}
+}
+
+func TestQueryCostBreakdown(t *testing.T) {
+	db, cleanup := helperInitTestDB(t)
+	defer cleanup()
+
+	base := time.Now().UTC().Truncate(24 * time.Hour).Add(-24 * time.Hour) // yesterday 00:00
+	for _, c := range []struct {
+		loop      string
+		iteration int
+		at        time.Time
+		cost      float64
+	}{
+		{"s-1", 1, base.Add(10 * time.Minute), 0.10},
+		{"s-1", 1, base.Add(70 * time.Minute), 0.20},
+		{"s-2", 2, base.Add(80 * time.Minute), 0.30},
+		{"s-3", 3, base.Add(25 * time.Hour), 0.40},
+	} {
+		err := stats.FlushCheckpoint(db, stats.CheckpointParams{
+			LoopID: c.loop, SessionID: "s", Iteration: c.iteration,
+			DeltaCost: c.cost, DeltaInputTokens: 100,
+			Timestamp: c.at.Format(time.RFC3339),
+		})
+		if err != nil {
+			t.Fatalf("FlushCheckpoint failed: %v", err)
+		}
+	}
+
+	hours, err := stats.QueryCostBreakdown(db, stats.CostByHour, time.Time{})
+	if err != nil {
+		t.Fatalf("QueryCostBreakdown(hour) failed: %v", err)
+	}
+	if len(hours) != 3 {
+		t.Fatalf("Expected 3 hourly buckets, got %+v", hours)
+	}
+	if want := base.Add(time.Hour).Format("2006-01-02 15:00"); hours[1].Key != want {
+		t.Errorf("Expected second hour %q, got %q", want, hours[1].Key)
+	}
+	if diff := hours[1].Cost - 0.50; diff > 1e-9 || diff < -1e-9 {
+		t.Errorf("Expected second hour to cost 0.50, got %f", hours[1].Cost)
+	}
+	if hours[1].Tokens != 200 || hours[1].SessionID != "" {
+		t.Errorf("Unexpected hourly bucket: %+v", hours[1])
+	}
+
+	days, err := stats.QueryCostBreakdown(db, stats.CostByDay, time.Time{})
+	if err != nil {
+		t.Fatalf("QueryCostBreakdown(day) failed: %v", err)
+	}
+	if len(days) != 2 || days[0].Key != base.Format("2006-01-02") {
+		t.Fatalf("Unexpected daily buckets: %+v", days)
+	}
+	if diff := days[0].Cost - 0.60; diff > 1e-9 || diff < -1e-9 {
+		t.Errorf("Expected first day to cost 0.60, got %f", days[0].Cost)
+	}
+
+	loops, err := stats.QueryCostBreakdown(db, stats.CostByLoop, base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("QueryCostBreakdown(loop) failed: %v", err)
+	}
+	if len(loops) != 3 {
+		t.Fatalf("Expected 3 loops since the window start, got %+v", loops)
+	}
+	if loops[0].Key != "s-1" || loops[0].Iteration != 1 || loops[0].SessionID != "s" || loops[0].Cost != 0.20 {
+		t.Errorf("Expected s-1 to count only its checkpoint in the window, got %+v", loops[0])
+	}
+	if loops[2].Key != "s-3" || loops[2].Iteration != 3 {
+		t.Errorf("Unexpected last loop: %+v", loops[2])
+	}
+
+	if _, err := stats.QueryCostBreakdown(db, "week", time.Time{}); err == nil {
+		t.Error("Expected an error for an unknown breakdown")
+	}
+	if b, err := stats.QueryCostBreakdown(nil, stats.CostByDay, time.Time{}); b != nil || err != nil {
+		t.Errorf("QueryCostBreakdown(nil) = %v, %v; want nil, nil", b, err)
+	}
+}
+
 func TestFlushCheckpoint_NilDB(t *testing.T) {
 	err := stats.FlushCheckpoint(nil, stats.CheckpointParams{
 		LoopID:    "test-1",
@@ -640,7 +886,7 @@ func TestWriteLoopStats(t *testing.T) {
 	var loopID, sessID, owner, repo, branch, desc, startTime, finishTime string
 	var totalCost float64
 	var input, output, cacheCreation, cacheRead, total int64
-	err := db.QueryRow("SELECT * FROM loop_stats WHERE loop_id = ?", "abc123-1").
+	err := db.QueryRow("SELECT loop_id, session_id, owner, repo, branch, description, total_cost, input_tokens, output_tokens, cache_creation_tokens, cache_read_tokens, total_tokens, start_time, finish_time FROM loop_stats WHERE loop_id = ?", "abc123-1").
 		Scan(&loopID, &sessID, &owner, &repo, &branch, &desc, &totalCost,
 			&input, &output, &cacheCreation, &cacheRead, &total, &startTime, &finishTime)
 	if err != nil {
@@ -788,6 +1034,30 @@ func TestQueryRollingHourCost_ExcludesOldRows(t *testing.T) {
 	}
 }
 
+func TestQueryRollingHourCost_AgesOutAtSixtyMinutes(t *testing.T) {
+	db, cleanup := helperInitTestDB(t)
+	defer cleanup()
+
+	now := time.Now().UTC()
+	// Exactly 60 minutes old: its wake time has come, so it is out of the window
+	stats.FlushCheckpoint(db, stats.CheckpointParams{
+		LoopID: "edge-1", SessionID: "aaaaaa", DeltaCost: 5.0,
+		Timestamp: now.Add(-60 * time.Minute).Format(time.RFC3339),
+	})
+	stats.FlushCheckpoint(db, stats.CheckpointParams{
+		LoopID: "edge-2", SessionID: "aaaaaa", DeltaCost: 0.5,
+		Timestamp: now.Add(-59 * time.Minute).Format(time.RFC3339),
+	})
+
+	cost, err := stats.QueryRollingHourCost(db, "", "")
+	if err != nil {
+		t.Fatalf("QueryRollingHourCost failed: %v", err)
+	}
+	if diff := cost - 0.5; diff < -0.0001 || diff > 0.0001 {
+		t.Errorf("Expected a 60-minute-old checkpoint to have aged out (cost ~0.5), got %f", cost)
+	}
+}
+
 func TestConcurrentCheckpointAppends(t *testing.T) {
 	db, cleanup := helperInitTestDB(t)
 	defer cleanup()
@@ -1024,17 +1294,11 @@ func TestSubagentCostNoDoubleCount(t *testing.T) {
 
 	// Phase 1: Token estimates stream in (main + subagent usage messages)
 	// Subagent estimates: $0.20, Main estimates: $3.00
-	var iterEstimate float64
-	var subagentCostAccum float64
-
 	subagentEstimate := 0.20
 	mainEstimate := 3.00
 
-	s.AddCost(subagentEstimate)
-	iterEstimate += subagentEstimate
-
-	s.AddCost(mainEstimate)
-	iterEstimate += mainEstimate
+	s.AddEstimate("toolu_sub", subagentEstimate)
+	s.AddEstimate("", mainEstimate)
 
 	// TotalCostUSD should be $3.20 at this point
 	snap := s.Snapshot()
@@ -1042,14 +1306,18 @@ func TestSubagentCostNoDoubleCount(t *testing.T) {
 		t.Errorf("After estimates: TotalCostUSD = %f, expected 3.20", snap.TotalCostUSD)
 	}
 
-	// Phase 2: Subagent result arrives with total_cost_usd = $0.22
+	// Phase 2: Subagent result arrives with total_cost_usd = $0.22, replacing its estimate
 	subagentActual := 0.22
-	s.AddCost(subagentActual)
-	subagentCostAccum += subagentActual
+	s.AddSubagentCost("toolu_sub", subagentActual)
+
+	snap = s.Snapshot()
+	if diff := snap.TotalCostUSD - 3.22; diff < -tolerance || diff > tolerance {
+		t.Errorf("After subagent result: TotalCostUSD = %f, expected 3.22", snap.TotalCostUSD)
+	}
 
 	// Phase 3: Main result arrives with total_cost_usd = $3.32 (includes subagent)
 	mainActual := 3.32
-	s.ReconcileCost(iterEstimate+subagentCostAccum, mainActual)
+	s.ReconcileIteration(mainActual)
 
 	// Final TotalCostUSD should equal $3.32 (the main result's actual cost)
 	snap = s.Snapshot()
@@ -1064,76 +1332,56 @@ func TestSubagentCostMultipleSubagents(t *testing.T) {
 	s := stats.NewTokenStats()
 	tolerance := 0.0000001
 
-	var iterEstimate float64
-	var subagentCostAccum float64
-
 	// Token estimates stream in
-	estimates := []float64{0.10, 0.15, 2.50} // subagent1, subagent2, main
-	for _, est := range estimates {
-		s.AddCost(est)
-		iterEstimate += est
-	}
+	s.AddEstimate("toolu_sub1", 0.10)
+	s.AddEstimate("toolu_sub2", 0.15)
+	s.AddEstimate("", 2.50)
 
 	// Subagent 1 result: $0.12
-	sub1Actual := 0.12
-	s.AddCost(sub1Actual)
-	subagentCostAccum += sub1Actual
+	s.AddSubagentCost("toolu_sub1", 0.12)
 
 	// Subagent 2 result: $0.18
-	sub2Actual := 0.18
-	s.AddCost(sub2Actual)
-	subagentCostAccum += sub2Actual
+	s.AddSubagentCost("toolu_sub2", 0.18)
+
+	snap := s.Snapshot()
+	if diff := snap.TotalCostUSD - 2.80; diff < -tolerance || diff > tolerance {
+		t.Errorf("Before main result: TotalCostUSD = %f, expected 2.80", snap.TotalCostUSD)
+	}
 
 	// Main result: $3.00 (includes both subagents)
 	mainActual := 3.00
-	s.ReconcileCost(iterEstimate+subagentCostAccum, mainActual)
+	s.ReconcileIteration(mainActual)
 
-	snap := s.Snapshot()
+	snap = s.Snapshot()
 	if diff := snap.TotalCostUSD - mainActual; diff < -tolerance || diff > tolerance {
 		t.Errorf("After reconciliation with 2 subagents: TotalCostUSD = %f, expected %f", snap.TotalCostUSD, mainActual)
 	}
 }
 
-// TestSubagentCostAccumResetsOnNewLoop verifies that resetting subagentCostAccum
-// on new loop start prevents cross-iteration leakage.
+// TestSubagentCostAccumResetsOnNewLoop verifies that each iteration reconciles
+// only its own estimates and subagent costs, so nothing leaks across iterations.
 func TestSubagentCostAccumResetsOnNewLoop(t *testing.T) {
 	s := stats.NewTokenStats()
 	tolerance := 0.0000001
 
 	// --- Iteration 1 ---
-	var iterEstimate float64
-	var subagentCostAccum float64
-
-	s.AddCost(1.00) // main estimate
-	iterEstimate += 1.00
-
-	s.AddCost(0.10) // subagent estimate
-	iterEstimate += 0.10
-
-	s.AddCost(0.12) // subagent result actual
-	subagentCostAccum += 0.12
-
-	s.ReconcileCost(iterEstimate+subagentCostAccum, 1.15) // main result
+	s.AddEstimate("", 1.00)              // main estimate
+	s.AddEstimate("toolu_sub", 0.10)     // subagent estimate
+	s.AddSubagentCost("toolu_sub", 0.12) // subagent result actual
+	s.ReconcileIteration(1.15)           // main result
 	snap := s.Snapshot()
 	if diff := snap.TotalCostUSD - 1.15; diff < -tolerance || diff > tolerance {
 		t.Errorf("After iteration 1: TotalCostUSD = %f, expected 1.15", snap.TotalCostUSD)
 	}
 
-	// --- New loop start: reset accumulators ---
-	iterEstimate = 0
-	subagentCostAccum = 0
-
-	// --- Iteration 2 ---
-	s.AddCost(2.00) // main estimate
-	iterEstimate += 2.00
-
-	s.AddCost(0.20) // subagent estimate
-	iterEstimate += 0.20
-
-	s.AddCost(0.25) // subagent result actual
-	subagentCostAccum += 0.25
+	// --- New loop start ---
+	s.EndIteration()
 
-	s.ReconcileCost(iterEstimate+subagentCostAccum, 2.30) // main result
+	// --- Iteration 2: a subagent with the same ID starts from nothing ---
+	s.AddEstimate("", 2.00)              // main estimate
+	s.AddEstimate("toolu_sub", 0.20)     // subagent estimate
+	s.AddSubagentCost("toolu_sub", 0.25) // subagent result actual
+	s.ReconcileIteration(2.30)           // main result
 	snap = s.Snapshot()
 
 	// Expected: iteration1 (1.15) + iteration2 (2.30) = 3.45
@@ -1143,6 +1391,92 @@ func TestSubagentCostAccumResetsOnNewLoop(t *testing.T) {
 	}
 }
 
+// TestSubagentCostDoubleCount reproduces the double count of adding a
+// subagent's reported cost on top of the estimates for its messages: until the
+// main result reconciles them, both are in the total, and an iteration that
+// never gets a main result (interrupted, or retried after a hibernate) keeps
+// both for good.
+func TestSubagentCostDoubleCount(t *testing.T) {
+	tolerance := 0.0000001
+
+	t.Run("reported_cost_added_on_top", func(t *testing.T) {
+		s := stats.NewTokenStats()
+		s.AddCost(3.00) // main estimate
+		s.AddCost(0.20) // subagent estimate
+		s.AddCost(0.22) // subagent result, counted again on top of its estimate
+		// The iteration ends without a main result, so nothing reconciles it.
+		if got := s.Snapshot().TotalCostUSD; got-3.42 > tolerance || got-3.42 < -tolerance {
+			t.Fatalf("TotalCostUSD = %f, expected the double-counted 3.42", got)
+		}
+	})
+
+	t.Run("reported_cost_replaces_estimate", func(t *testing.T) {
+		s := stats.NewTokenStats()
+		s.AddEstimate("", 3.00)
+		s.AddEstimate("toolu_sub", 0.20)
+		s.AddSubagentCost("toolu_sub", 0.22)
+		s.EndIteration()
+		if got := s.Snapshot().TotalCostUSD; got-3.22 > tolerance || got-3.22 < -tolerance {
+			t.Fatalf("After interrupted iteration: TotalCostUSD = %f, expected 3.22", got)
+		}
+
+		// The next iteration reconciles only its own costs.
+		s.AddEstimate("", 1.00)
+		s.ReconcileIteration(1.10)
+		if got := s.Snapshot().TotalCostUSD; got-4.32 > tolerance || got-4.32 < -tolerance {
+			t.Errorf("After next iteration: TotalCostUSD = %f, expected 4.32", got)
+		}
+	})
+}
+
+// TestSubagentCostReportedTwice verifies a subagent's second result replaces
+// its first rather than adding to it.
+func TestSubagentCostReportedTwice(t *testing.T) {
+	tolerance := 0.0000001
+	s := stats.NewTokenStats()
+
+	s.AddEstimate("toolu_sub", 0.20)
+	s.AddSubagentCost("toolu_sub", 0.22)
+	s.AddEstimate("toolu_sub", 0.05) // a message after its result
+	s.AddSubagentCost("toolu_sub", 0.30)
+
+	if got := s.Snapshot().TotalCostUSD; got-0.30 > tolerance || got-0.30 < -tolerance {
+		t.Errorf("TotalCostUSD = %f, expected 0.30", got)
+	}
+}
+
+// TestReconcileIterationThreadSafe exercises the iteration cost methods
+// concurrently with Snapshot readers under `go test -race`. Each round ends
+// reconciled, so the final total is exact.
+func TestReconcileIterationThreadSafe(t *testing.T) {
+	s := stats.NewTokenStats()
+
+	const rounds = 1000
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			s.AddEstimate("", 0.001)
+			s.AddEstimate("toolu_sub", 0.0005)
+			s.AddSubagentCost("toolu_sub", 0.0006)
+			s.ReconcileIteration(0.002)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			_ = s.Snapshot().TotalCostUSD
+		}
+	}()
+	wg.Wait()
+
+	want := 0.002 * rounds
+	if got := s.Snapshot().TotalCostUSD; got-want > 0.000001 || got-want < -0.000001 {
+		t.Errorf("TotalCostUSD = %f, expected %f", got, want)
+	}
+}
+
 // replayFixture is a helper that replays a fixture file through the cost-tracking
 // logic mirroring handleParsedMessage, with optional message-ID deduplication.
 // Returns the final TokenStats snapshot, the expected cost from the result message,
@@ -1157,8 +1491,6 @@ func replayFixture(t *testing.T, fixturePath string, dedup bool) (snap *stats.Sn
 	p := parser.NewParser()
 	tokenStats := stats.NewTokenStats()
 
-	var iterEstimate float64
-	var subagentCostAccum float64
 	var lastResultCost float64
 	seenMsgIDs := make(map[string]bool)
 
@@ -1198,8 +1530,7 @@ func replayFixture(t *testing.T, fixturePath string, dedup bool) (snap *stats.Sn
 					usage.CacheCreationInputTokens,
 					usage.CacheReadInputTokens,
 				)
-				tokenStats.AddCost(estimate)
-				iterEstimate += estimate
+				tokenStats.AddEstimate(p.GetParentToolUseID(parsed), estimate)
 			}
 		}
 
@@ -1217,14 +1548,11 @@ func replayFixture(t *testing.T, fixturePath string, dedup bool) (snap *stats.Sn
 				if cost >= lastResultCost {
 					iterActualCost = cost - lastResultCost
 				}
-				tokenStats.ReconcileCost(iterEstimate+subagentCostAccum, iterActualCost)
+				tokenStats.ReconcileIteration(iterActualCost)
 				lastResultCost = cost
-				iterEstimate = 0
-				subagentCostAccum = 0
 				expectedCost = iterActualCost
 			} else {
-				tokenStats.AddCost(cost)
-				subagentCostAccum += cost
+				tokenStats.AddSubagentCost(p.GetParentToolUseID(parsed), cost)
 			}
 		}
 	}
@@ -1510,3 +1838,286 @@ func TestProjectKey_NonGit(t *testing.T) {
 		t.Errorf("Expected absolute path, got %q", key)
 	}
 }
+
+func TestQueryTotals(t *testing.T) {
+	db, cleanup := helperInitTestDB(t)
+	defer cleanup()
+
+	totals, err := stats.QueryTotals(db, time.Time{})
+	if err != nil {
+		t.Fatalf("QueryTotals on empty DB: %v", err)
+	}
+	if totals != (stats.Totals{}) {
+		t.Errorf("Expected zero totals for an empty DB, got %+v", totals)
+	}
+
+	now := time.Now()
+	loops := []stats.LoopStatsParams{
+		{LoopID: "a-1", SessionID: "aaaaaa", Owner: "o", Repo: "r1", TotalCost: 1.25, InputTokens: 100, OutputTokens: 10, TotalTokens: 110, StartTime: now.Add(-1 * time.Hour).Format(time.RFC3339)},
+		{LoopID: "a-2", SessionID: "aaaaaa", Owner: "o", Repo: "r1", TotalCost: 0.75, InputTokens: 50, OutputTokens: 5, TotalTokens: 55, StartTime: now.Add(-2 * time.Hour).Format(time.RFC3339)},
+		// Another project, 10 days ago (local offset preserved in the string)
+		{LoopID: "b-1", SessionID: "bbbbbb", Owner: "o", Repo: "r2", TotalCost: 3, InputTokens: 1000, CacheReadTokens: 500, TotalTokens: 1500, StartTime: now.Add(-240 * time.Hour).Format(time.RFC3339)},
+	}
+	for _, l := range loops {
+		if err := stats.WriteLoopStats(db, l); err != nil {
+			t.Fatalf("WriteLoopStats: %v", err)
+		}
+	}
+
+	all, err := stats.QueryTotals(db, time.Time{})
+	if err != nil {
+		t.Fatalf("QueryTotals: %v", err)
+	}
+	if all.Sessions != 2 || all.Loops != 3 || all.TotalCost != 5 || all.TotalTokens != 1665 || all.CacheReadTokens != 500 {
+		t.Errorf("Unexpected all-time totals: %+v", all)
+	}
+
+	recent, err := stats.QueryTotals(db, now.Add(-7*24*time.Hour))
+	if err != nil {
+		t.Fatalf("QueryTotals since: %v", err)
+	}
+	if recent.Sessions != 1 || recent.Loops != 2 || recent.TotalCost != 2 || recent.InputTokens != 150 {
+		t.Errorf("Unexpected 7-day totals: %+v", recent)
+	}
+
+	nilTotals, err := stats.QueryTotals(nil, time.Time{})
+	if err != nil || nilTotals != (stats.Totals{}) {
+		t.Errorf("Expected zero totals for nil DB, got %+v, %v", nilTotals, err)
+	}
+}
+
+func TestCostPerKTokens(t *testing.T) {
+	s := stats.NewTokenStats()
+	if got := s.CostPerKTokens(); got != 0 {
+		t.Errorf("Expected 0 with no tokens, got %f", got)
+	}
+	s.AddCost(0.5)
+	if got := s.CostPerKTokens(); got != 0 {
+		t.Errorf("Expected 0 with cost but no tokens, got %f", got)
+	}
+
+	s.AddUsage(1000, 500, 1000, 2500)
+	if got := s.CostPerKTokens(); got != 0.1 {
+		t.Errorf("Expected $0.10 per 1k tokens, got %f", got)
+	}
+	if got := s.Snapshot().CostPerKTokens(); got != 0.1 {
+		t.Errorf("Expected the snapshot to match, got %f", got)
+	}
+}
+
+func TestEfficiencyTrend(t *testing.T) {
+	iterate := func(s *stats.TokenStats, tokens int64, cost float64) {
+		s.AddUsage(tokens, 0, 0, 0)
+		s.ReconcileCost(0, cost)
+	}
+
+	s := stats.NewTokenStats()
+	iterate(s, 1000, 0.01)
+	iterate(s, 1000, 0.05)
+	if got := s.EfficiencyTrend(); got != stats.TrendSteady {
+		t.Errorf("Expected steady with two iterations, got %s", got)
+	}
+	iterate(s, 1000, 0.10)
+	if got := s.EfficiencyTrend(); got != stats.TrendRising {
+		t.Errorf("Expected rising, got %s", got)
+	}
+	if got := s.Snapshot().EfficiencyTrend; got != stats.TrendRising {
+		t.Errorf("Expected the snapshot to carry the trend, got %s", got)
+	}
+	iterate(s, 1000, 0.01)
+	if got := s.EfficiencyTrend(); got != stats.TrendFalling {
+		t.Errorf("Expected falling, got %s", got)
+	}
+
+	// Iterations older than EfficiencyHistory no longer count
+	s = stats.NewTokenStats()
+	iterate(s, 1000, 1)
+	for i := 0; i < stats.EfficiencyHistory; i++ {
+		iterate(s, 1000, 0.02)
+	}
+	if got := s.EfficiencyTrend(); got != stats.TrendSteady {
+		t.Errorf("Expected steady once the outlier left the window, got %s", got)
+	}
+
+	// A reconcile without tokens records nothing
+	s = stats.NewTokenStats()
+	iterate(s, 1000, 0.01)
+	iterate(s, 1000, 0.01)
+	s.ReconcileCost(0, 0.5)
+	if got := s.EfficiencyTrend(); got != stats.TrendSteady {
+		t.Errorf("Expected a tokenless reconcile to be ignored, got %s", got)
+	}
+}
+
+func TestCacheHitRatio(t *testing.T) {
+	s := stats.NewTokenStats()
+	if got := s.CacheHitRatio(); got != 0 {
+		t.Errorf("Expected 0 with no cache tokens, got %f", got)
+	}
+	if s.Snapshot().HasCacheActivity() {
+		t.Error("Expected no cache activity on empty stats")
+	}
+
+	s.AddUsage(100, 50, 2000, 8000)
+	if got := s.CacheHitRatio(); got != 0.8 {
+		t.Errorf("Expected ratio 0.8, got %f", got)
+	}
+	snap := s.Snapshot()
+	if !snap.HasCacheActivity() || snap.CacheHitRatio() != 0.8 {
+		t.Errorf("Expected snapshot ratio 0.8, got %f", snap.CacheHitRatio())
+	}
+
+	// Writes only: a real 0% hit rate, not the empty case
+	w := stats.NewTokenStats()
+	w.AddUsage(0, 0, 500, 0)
+	if got := w.CacheHitRatio(); got != 0 || !w.Snapshot().HasCacheActivity() {
+		t.Errorf("Expected 0%% hit with cache activity, got %f", got)
+	}
+}
+
+func TestHibernationsPersisted(t *testing.T) {
+	db := newTestDB(t)
+
+	s := stats.NewTokenStats()
+	s.AddHibernation(20 * time.Minute)
+	s.AddHibernation(27 * time.Minute)
+	if snap := s.Snapshot(); snap.Hibernations != 2 || time.Duration(snap.HibernateNs) != 47*time.Minute {
+		t.Fatalf("Expected 2 hibernations for 47m, got %d for %v", snap.Hibernations, time.Duration(snap.HibernateNs))
+	}
+
+	if err := stats.SaveProjectStats(db, "owner/repo", s); err != nil {
+		t.Fatalf("SaveProjectStats failed: %v", err)
+	}
+	loaded, err := stats.LoadProjectStats(db, "owner/repo")
+	if err != nil {
+		t.Fatalf("LoadProjectStats failed: %v", err)
+	}
+	if snap := loaded.Snapshot(); snap.Hibernations != 2 || time.Duration(snap.HibernateNs) != 47*time.Minute {
+		t.Errorf("Expected hibernations to survive a save and load, got %d for %v", snap.Hibernations, time.Duration(snap.HibernateNs))
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	for _, l := range []stats.LoopStatsParams{
+		{LoopID: "a-1", SessionID: "aaaaaa", StartTime: now, Hibernations: 2, HibernateNs: int64(30 * time.Minute)},
+		{LoopID: "a-2", SessionID: "aaaaaa", StartTime: now, Hibernations: 1, HibernateNs: int64(17 * time.Minute)},
+		{LoopID: "a-3", SessionID: "aaaaaa", StartTime: now},
+	} {
+		if err := stats.WriteLoopStats(db, l); err != nil {
+			t.Fatalf("WriteLoopStats: %v", err)
+		}
+	}
+	totals, err := stats.QueryTotals(db, time.Time{})
+	if err != nil {
+		t.Fatalf("QueryTotals: %v", err)
+	}
+	if totals.Hibernations != 3 || time.Duration(totals.HibernateNs) != 47*time.Minute {
+		t.Errorf("Expected 3 hibernations for 47m in the totals, got %d for %v", totals.Hibernations, time.Duration(totals.HibernateNs))
+	}
+}
+
+func TestCommitsTotaled(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now().Format(time.RFC3339)
+	for _, l := range []stats.LoopStatsParams{
+		{LoopID: "a-1", SessionID: "aaaaaa", StartTime: now, Commits: 1, FilesChanged: 3, Insertions: 40, Deletions: 2},
+		{LoopID: "a-2", SessionID: "aaaaaa", StartTime: now, Commits: 2, FilesChanged: 1, Insertions: 5, Deletions: 7},
+		{LoopID: "a-3", SessionID: "aaaaaa", StartTime: now},
+	} {
+		if err := stats.WriteLoopStats(db, l); err != nil {
+			t.Fatalf("WriteLoopStats: %v", err)
+		}
+	}
+	totals, err := stats.QueryTotals(db, time.Time{})
+	if err != nil {
+		t.Fatalf("QueryTotals: %v", err)
+	}
+	if totals.Commits != 3 || totals.FilesChanged != 4 || totals.Insertions != 45 || totals.Deletions != 9 {
+		t.Errorf("Expected 3 commits, 4 files, +45 -9 in the totals, got %+v", totals)
+	}
+}
+
+func TestInitDB_AddsHibernationColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	old, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	// The tables as they were before hibernations were recorded
+	for _, stmt := range []string{
+		`CREATE TABLE loop_stats (loop_id TEXT PRIMARY KEY, session_id TEXT NOT NULL, owner TEXT, repo TEXT, branch TEXT, description TEXT,
+			total_cost REAL, input_tokens INTEGER, output_tokens INTEGER, cache_creation_tokens INTEGER, cache_read_tokens INTEGER,
+			total_tokens INTEGER, start_time TEXT, finish_time TEXT)`,
+		`CREATE TABLE project_stats (project_key TEXT PRIMARY KEY, input_tokens INTEGER DEFAULT 0, output_tokens INTEGER DEFAULT 0,
+			cache_creation_tokens INTEGER DEFAULT 0, cache_read_tokens INTEGER DEFAULT 0, total_cost REAL DEFAULT 0,
+			total_tokens INTEGER DEFAULT 0, elapsed_ns INTEGER DEFAULT 0)`,
+		`INSERT INTO project_stats (project_key, input_tokens) VALUES ('owner/repo', 42)`,
+	} {
+		if _, err := old.Exec(stmt); err != nil {
+			t.Fatalf("creating old schema: %v", err)
+		}
+	}
+	old.Close()
+
+	db, err := stats.InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB on an old database: %v", err)
+	}
+	defer db.Close()
+
+	loaded, err := stats.LoadProjectStats(db, "owner/repo")
+	if err != nil {
+		t.Fatalf("LoadProjectStats: %v", err)
+	}
+	if snap := loaded.Snapshot(); snap.InputTokens != 42 || snap.Hibernations != 0 {
+		t.Errorf("Expected the old row with no hibernations, got %+v", snap)
+	}
+	if err := stats.WriteLoopStats(db, stats.LoopStatsParams{LoopID: "a-1", SessionID: "aaaaaa", Hibernations: 1}); err != nil {
+		t.Errorf("WriteLoopStats after migration: %v", err)
+	}
+}
+
+// TestIterationStats tests that each iteration records the tokens and cost
+// added while it ran, its duration, its tool calls and its model
+func TestIterationStats(t *testing.T) {
+	ts := stats.NewTokenStats()
+	it := stats.NewIterationStats()
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	it.AddToolUses(1) // before any iteration: ignored
+	it.Start(1, ts.Snapshot(), start)
+	ts.AddUsage(1000, 500, 0, 0)
+	ts.AddCost(0.10)
+	it.AddToolUses(3)
+	it.SetModel("claude-sonnet-4-5")
+
+	live := it.Records(ts.Snapshot(), start.Add(30*time.Second))
+	if len(live) != 1 || !live[0].Running || live[0].Tokens != 1500 || live[0].Duration != 30*time.Second {
+		t.Fatalf("Expected the running iteration measured live, got %+v", live)
+	}
+
+	it.Start(2, ts.Snapshot(), start.Add(time.Minute))
+	ts.AddUsage(200, 100, 0, 0)
+	ts.AddCost(0.05)
+	it.AddToolUses(1)
+	it.Finish(ts.Snapshot(), start.Add(90*time.Second))
+	it.AddToolUses(1) // after finishing: ignored
+
+	records := it.Records(ts.Snapshot(), start.Add(time.Hour))
+	want := []stats.IterationRecord{
+		{Iteration: 1, Tokens: 1500, Duration: time.Minute, ToolUses: 3, Model: "claude-sonnet-4-5"},
+		{Iteration: 2, Tokens: 300, Duration: 30 * time.Second, ToolUses: 1},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("Expected %d records, got %+v", len(want), records)
+	}
+	for i, r := range records {
+		cost := r.CostUSD
+		r.CostUSD = 0
+		if r != want[i] {
+			t.Errorf("Record %d: got %+v, want %+v", i, r, want[i])
+		}
+		if wantCost := []float64{0.10, 0.05}[i]; cost < wantCost-1e-9 || cost > wantCost+1e-9 {
+			t.Errorf("Record %d: cost %f, want %f", i, cost, wantCost)
+		}
+	}
+}
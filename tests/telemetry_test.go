@@ -0,0 +1,294 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudosai/ralph-go/internal/telemetry"
+)
+
+// otlpSpan is the part of an exported OTLP/JSON span the tests check.
+type otlpSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Attributes   []struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string   `json:"stringValue"`
+			IntValue    string   `json:"intValue"`
+			DoubleValue *float64 `json:"doubleValue"`
+		} `json:"value"`
+	} `json:"attributes"`
+	Status struct {
+		Code int `json:"code"`
+	} `json:"status"`
+}
+
+func (s otlpSpan) attr(key string) string {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			if a.Value.DoubleValue != nil {
+				return "double"
+			}
+			return a.Value.StringValue + a.Value.IntValue
+		}
+	}
+	return ""
+}
+
+// collectSpans starts an OTLP/HTTP endpoint that records the spans posted
+// to /v1/traces.
+func collectSpans(t *testing.T) (*httptest.Server, func() []otlpSpan) {
+	t.Helper()
+	var mu sync.Mutex
+	var spans []otlpSpan
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []otlpSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []otlpSpan {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]otlpSpan(nil), spans...)
+	}
+}
+
+// TestTracerExportsRunAndIterationSpans tests that a traced run exports one
+// run span with a child span per iteration carrying cost, tokens and outcome,
+// each with a span per tool call
+func TestTracerExportsRunAndIterationSpans(t *testing.T) {
+	srv, spans := collectSpans(t)
+	tracer := telemetry.New(srv.URL, map[string]string{"ralph.run_id": "nightly"})
+
+	tracer.StartIteration(1)
+	tracer.ToolUse("toolu_1", "", "Read", "main.go")
+	tracer.ToolUse("toolu_2", "", "Bash", "go test ./...")
+	tracer.ToolResult("toolu_1", false)
+	tracer.ToolResult("toolu_2", true)
+	tracer.SetOutcome("success")
+	tracer.EndIteration(0.25, telemetry.Tokens{Input: 100, Output: 50})
+	tracer.StartIteration(2)
+	tracer.SetOutcome("error")
+	tracer.EndIteration(0.1, telemetry.Tokens{Input: 10})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracer.Shutdown(ctx, "success"); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	got := spans()
+	if len(got) != 5 {
+		t.Fatalf("Expected 5 spans (2 tool calls, 2 iterations and the run), got %d", len(got))
+	}
+	read, bash, first, second, run := got[0], got[1], got[2], got[3], got[4]
+	if run.Name != "ralph run" || run.ParentSpanID != "" || run.attr("ralph.iterations") != "2" {
+		t.Errorf("Unexpected run span %+v", run)
+	}
+	for _, it := range []otlpSpan{first, second} {
+		if it.ParentSpanID != run.SpanID || it.TraceID != run.TraceID {
+			t.Errorf("Expected iteration %q to be a child of the run span", it.Name)
+		}
+	}
+	if first.attr("ralph.iteration") != "1" || first.attr("ralph.tokens.input") != "100" ||
+		first.attr("ralph.cost_usd") != "double" || first.attr("ralph.outcome") != "success" {
+		t.Errorf("Unexpected attributes on iteration 1: %+v", first.Attributes)
+	}
+	if first.attr("ralph.tool_uses") != "2" {
+		t.Errorf("Expected 2 tool uses counted on iteration 1, got %q", first.attr("ralph.tool_uses"))
+	}
+	for _, tool := range []otlpSpan{read, bash} {
+		if tool.ParentSpanID != first.SpanID {
+			t.Errorf("Expected tool span %q to be a child of iteration 1", tool.Name)
+		}
+	}
+	if read.Name != "tool Read" || read.attr("tool.target") != "main.go" || read.attr("tool.use_id") != "toolu_1" || read.Status.Code != 1 {
+		t.Errorf("Unexpected span for the Read call: %+v", read)
+	}
+	if bash.Name != "tool Bash" || bash.Status.Code != 2 {
+		t.Errorf("Expected the failed Bash call marked as an error, got %+v", bash)
+	}
+	if second.attr("ralph.outcome") != "error" || second.Status.Code != 2 {
+		t.Errorf("Expected iteration 2 marked as an error, got outcome %q, status %d", second.attr("ralph.outcome"), second.Status.Code)
+	}
+}
+
+// TestTracerNestsSubagentToolCalls tests that a subagent's tool calls are
+// children of the call that spawned it, and that calls still without a
+// result end with their iteration
+func TestTracerNestsSubagentToolCalls(t *testing.T) {
+	srv, spans := collectSpans(t)
+	tracer := telemetry.New(srv.URL, nil)
+
+	tracer.StartIteration(1)
+	tracer.ToolUse("toolu_task", "", "Task", "explore the parser")
+	tracer.ToolUse("toolu_sub", "toolu_task", "Grep", "func Parse")
+	tracer.ToolResult("toolu_sub", false)
+	tracer.ToolUse("", "", "Write", "notes.md")
+	tracer.EndIteration(0, telemetry.Tokens{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracer.Shutdown(ctx, "success"); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	byName := make(map[string]otlpSpan)
+	for _, s := range spans() {
+		byName[s.Name] = s
+	}
+	task, grep, write, iter := byName["tool Task"], byName["tool Grep"], byName["tool Write"], byName["iteration 1"]
+	if task.SpanID == "" || grep.SpanID == "" || write.SpanID == "" || iter.SpanID == "" {
+		t.Fatalf("Expected spans for each tool call and the iteration, got %+v", byName)
+	}
+	if grep.ParentSpanID != task.SpanID {
+		t.Error("Expected the subagent's Grep to be a child of the Task call")
+	}
+	if task.ParentSpanID != iter.SpanID || write.ParentSpanID != iter.SpanID {
+		t.Error("Expected the main agent's calls to be children of the iteration")
+	}
+	if task.Status.Code != 0 {
+		t.Errorf("Expected the Task call left without a result to end unset, got status %d", task.Status.Code)
+	}
+}
+
+// TestTracerKeepsIterationSpansWhenBehind tests that a collector too slow
+// to keep up loses tool call spans, which Shutdown reports, but never
+// iteration or run spans
+func TestTracerKeepsIterationSpansWhenBehind(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	counts := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []otlpSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		defer mu.Unlock()
+		counts["requests"]++
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					counts[strings.Fields(s.Name)[0]]++
+				}
+			}
+		}
+	}))
+	defer srv.Close()
+
+	// The collector holds the first request while 100 iterations of 256 tool
+	// calls pile up
+	tracer := telemetry.New(srv.URL, nil)
+	for i := 1; i <= 100; i++ {
+		tracer.StartIteration(i)
+		for j := 0; j < 256; j++ {
+			id := fmt.Sprintf("toolu_%d_%d", i, j)
+			tracer.ToolUse(id, "", "Read", "main.go")
+			tracer.ToolResult(id, false)
+		}
+		tracer.EndIteration(0.01, telemetry.Tokens{})
+	}
+	close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := tracer.Shutdown(ctx, "success")
+	if err == nil || !strings.Contains(err.Error(), "dropped") {
+		t.Errorf("Expected Shutdown to report dropped spans, got %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if counts["iteration"] != 100 || counts["ralph"] != 1 {
+		t.Errorf("Expected all 100 iteration spans and the run span, got %v", counts)
+	}
+	if counts["tool"] == 0 || counts["tool"] >= 100*256 {
+		t.Errorf("Expected some but not all tool spans exported, got %d", counts["tool"])
+	}
+	if counts["requests"] > 66 {
+		t.Errorf("Expected iterations batched into at most 66 requests, got %d", counts["requests"])
+	}
+}
+
+// TestTracerDisabledWithoutEndpoint tests that tracing is a no-op when no
+// endpoint is configured
+func TestTracerDisabledWithoutEndpoint(t *testing.T) {
+	tracer := telemetry.New("", nil)
+	if tracer != nil {
+		t.Fatal("Expected no tracer without an endpoint")
+	}
+	tracer.StartIteration(1)
+	tracer.ToolUse("toolu_1", "", "Read", "main.go")
+	tracer.ToolResult("toolu_1", false)
+	tracer.SetOutcome("success")
+	tracer.EndIteration(1, telemetry.Tokens{})
+	if err := tracer.Shutdown(context.Background(), "success"); err != nil {
+		t.Errorf("Expected a nil tracer to shut down cleanly, got %v", err)
+	}
+}
+
+// TestTracerReportsExportFailure tests that Shutdown returns the collector's
+// rejection
+func TestTracerReportsExportFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	tracer := telemetry.New(srv.URL+"/", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracer.Shutdown(ctx, "success"); err == nil {
+		t.Error("Expected the export failure to be reported")
+	}
+}
+
+func TestTracesURL(t *testing.T) {
+	tests := map[string]string{
+		"http://localhost:4318":              "http://localhost:4318/v1/traces",
+		"http://localhost:4318/":             "http://localhost:4318/v1/traces",
+		"https://otel.example.com/v1/traces": "https://otel.example.com/v1/traces",
+	}
+	for in, want := range tests {
+		if got := telemetry.TracesURL(in); got != want {
+			t.Errorf("TracesURL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
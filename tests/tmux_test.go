@@ -3,7 +3,9 @@ package tests
 import (
 	"os"
 	"os/exec"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/cloudosai/ralph-go/internal/tmux"
 )
@@ -154,7 +156,7 @@ func TestStatusBarNilSafe(t *testing.T) {
 
 // TestFormatStatusRight tests the status bar format string
 func TestFormatStatusRight(t *testing.T) {
-	result := tmux.FormatStatusRight("ralph", "main", "1/5", "07:18:00")
+	result := tmux.FormatStatusRight("ralph", "main", "1/5", "", "07:18:00")
 	expected := "[ralph | main | loop: 1/5, uptime: 07:18:00]"
 	if result != expected {
 		t.Errorf("FormatStatusRight() = %q, want %q", result, expected)
@@ -163,7 +165,7 @@ func TestFormatStatusRight(t *testing.T) {
 
 // TestFormatStatusRight_ZeroValues tests formatting with zero/default values
 func TestFormatStatusRight_ZeroValues(t *testing.T) {
-	result := tmux.FormatStatusRight("", "", "0/0", "00:00:00")
+	result := tmux.FormatStatusRight("", "", "0/0", "", "00:00:00")
 	if result == "" {
 		t.Error("FormatStatusRight should return non-empty string for zero values")
 	}
@@ -172,3 +174,73 @@ func TestFormatStatusRight_ZeroValues(t *testing.T) {
 		t.Errorf("FormatStatusRight() = %q, want %q", result, expected)
 	}
 }
+
+// TestFormatStatusRight_Tasks tests that plan progress is shown with its
+// percentage, and left out when there are no tasks
+func TestFormatStatusRight_Tasks(t *testing.T) {
+	result := tmux.FormatStatusRight("ralph", "main", "1/5", tmux.FormatTasks(8, 14), "07:18:00")
+	expected := "[ralph | main | loop: 1/5, tasks: 8/14 (57%), uptime: 07:18:00]"
+	if result != expected {
+		t.Errorf("FormatStatusRight() = %q, want %q", result, expected)
+	}
+	if got := tmux.FormatTasks(0, 0); got != "" {
+		t.Errorf("Expected no tasks field without a plan, got %q", got)
+	}
+	if got := tmux.FormatTasks(3, 3); got != "3/3 (100%)" {
+		t.Errorf("FormatTasks(3, 3) = %q", got)
+	}
+}
+
+// TestFormatStatusRight_RespectsLengthCap tests that overlong fields are cut
+// with an ellipsis at a word boundary and the whole status fits the cap
+func TestFormatStatusRight_RespectsLengthCap(t *testing.T) {
+	long := strings.Repeat("very-long-branch-name ", 20)
+	result := tmux.FormatStatusRight(strings.Repeat("r", 300), long, strings.Repeat("9", 300), strings.Repeat("8", 300), strings.Repeat("0", 300))
+	if n := utf8.RuneCountInString(result); n > tmux.StatusRightLength {
+		t.Errorf("Expected at most %d characters, got %d: %q", tmux.StatusRightLength, n, result)
+	}
+	if !strings.Contains(result, "| very-long-branch-name very-long-branch… |") {
+		t.Errorf("Expected the branch cut at a word boundary with an ellipsis, got %q", result)
+	}
+	if !strings.HasPrefix(result, "[") || !strings.HasSuffix(result, "…]") || !strings.Contains(result, "| loop: ") || !strings.Contains(result, ", tasks: ") || !strings.Contains(result, ", uptime: ") {
+		t.Errorf("Expected every field kept in the status, got %q", result)
+	}
+
+	if got := tmux.FormatStatusRight("ralph", "main", "1/5", "", "07:18:00"); got != "[ralph | main | loop: 1/5, uptime: 07:18:00]" {
+		t.Errorf("Expected short fields untouched, got %q", got)
+	}
+}
+
+func TestSessionName(t *testing.T) {
+	tests := []struct {
+		runID, want string
+	}{
+		{"", "ralph"},
+		{"nightly", "ralph-nightly"},
+		{"3f2c9a1e-7b4d-4c21-9e0a-5d6f7a8b9c0d", "ralph-3f2c9a1e"},
+	}
+	for _, tt := range tests {
+		if got := tmux.SessionName(tt.runID); got != tt.want {
+			t.Errorf("SessionName(%q) = %q, want %q", tt.runID, got, tt.want)
+		}
+	}
+}
+
+func TestWrappedSession(t *testing.T) {
+	t.Setenv("TMUX", "")
+	t.Setenv(tmux.SessionEnv, "ralph-1a2b3c4d")
+	if got := tmux.WrappedSession(); got != "" {
+		t.Errorf("Expected no wrapped session outside tmux, got %q", got)
+	}
+
+	t.Setenv("TMUX", "/tmp/tmux-1000/default,1234,0")
+	if got := tmux.WrappedSession(); got != "ralph-1a2b3c4d" {
+		t.Errorf("WrappedSession() = %q, want %q", got, "ralph-1a2b3c4d")
+	}
+
+	// A session the user started themselves is not ralph's to close
+	t.Setenv(tmux.SessionEnv, "")
+	if got := tmux.WrappedSession(); got != "" {
+		t.Errorf("Expected no wrapped session without %s, got %q", tmux.SessionEnv, got)
+	}
+}
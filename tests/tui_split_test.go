@@ -175,3 +175,108 @@ func TestSplit_NoWidthOverflow(t *testing.T) {
 		}
 	}
 }
+
+// TestSplit_MaxContentWidthCentersPanes verifies a capped activity panel is
+// centered in a wider terminal while the footer still spans the full width.
+func TestSplit_MaxContentWidthCentersPanes(t *testing.T) {
+	// span returns the columns of the first and last rounded corner on the
+	// first line containing marker
+	span := func(view, marker string) (start, end int) {
+		for _, line := range strings.Split(view, "\n") {
+			if strings.Contains(line, marker) {
+				return lipgloss.Width(line[:strings.Index(line, marker)]),
+					lipgloss.Width(line[:strings.LastIndex(line, "╮")]) + 1
+			}
+		}
+		t.Fatalf("%q not rendered in:\n%s", marker, view)
+		return 0, 0
+	}
+
+	model := tui.NewModel()
+	model.SetMaxContentWidth(100)
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 200, Height: 40})
+	model = sendTo(t, model, tui.Message{Role: tui.RoleThinking, Content: "width check"})
+
+	view := model.View()
+	if start, end := span(view, "╭"); start != 50 || end != 150 {
+		t.Errorf("Expected capped panes at columns 50-150, got %d-%d", start, end)
+	}
+	for _, line := range strings.Split(view, "\n") {
+		if lw := lipgloss.Width(line); lw > 200 {
+			t.Errorf("a rendered line is %d wide (overflow): %q", lw, line)
+		}
+	}
+
+	// The footer is laid out at the full terminal width
+	full := tui.NewModel()
+	full, _ = updateModel(full, tea.WindowSizeMsg{Width: 200, Height: 40})
+	if start, _ := span(full.View(), "╭"); start != 0 {
+		t.Errorf("Expected uncapped panes at the left edge, got column %d", start)
+	}
+	footer := func(v string) string {
+		for _, line := range strings.Split(v, "\n") {
+			if strings.Contains(line, "Total Cost:") {
+				return line
+			}
+		}
+		return ""
+	}
+	if footer(view) != footer(full.View()) {
+		t.Errorf("Expected the footer unchanged by the cap, got %q vs %q", footer(view), footer(full.View()))
+	}
+}
+
+// TestSplit_CompactFeedDropsBlankLines verifies --compact-feed removes the
+// blank separator rows and marks a change of role with a divider instead.
+func TestSplit_CompactFeedDropsBlankLines(t *testing.T) {
+	render := func(compact bool) []string {
+		model := tui.NewModel()
+		model.SetCompactFeed(compact)
+		model, _ = updateModel(model, tea.WindowSizeMsg{Width: 120, Height: 40})
+		model = sendTo(t, model, tui.Message{Role: tui.RoleAssistant, Content: "FEED_ONE"})
+		model = sendTo(t, model, tui.Message{Role: tui.RoleAssistant, Content: "FEED_TWO"})
+		model = sendTo(t, model, tui.Message{Role: tui.RoleThinking, Content: "FEED_THREE"})
+		return strings.Split(model.View(), "\n")
+	}
+	row := func(lines []string, sub string) int {
+		for i, line := range lines {
+			if strings.Contains(line, sub) {
+				return i
+			}
+		}
+		t.Fatalf("no line contains %q", sub)
+		return -1
+	}
+
+	spaced, compact := render(false), render(true)
+	if got := row(spaced, "FEED_THREE") - row(spaced, "FEED_ONE"); got != 4 {
+		t.Errorf("default feed: expected a blank line after each message (4 rows apart), got %d", got)
+	}
+	if got := row(compact, "FEED_TWO") - row(compact, "FEED_ONE"); got != 1 {
+		t.Errorf("compact feed: expected same-role messages on adjacent rows, got %d apart", got)
+	}
+	if got := row(compact, "FEED_THREE") - row(compact, "FEED_TWO"); got != 2 {
+		t.Errorf("compact feed: expected a single divider row at the role change, got %d apart", got)
+	}
+	if divider := compact[row(compact, "FEED_TWO")+1]; !strings.Contains(divider, "╌") {
+		t.Errorf("compact feed: expected a divider between roles, got %q", divider)
+	}
+}
+
+func TestSplit_StripANSIFromFeed(t *testing.T) {
+	model := tui.NewModel()
+	model.SetStripANSI(true)
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 120, Height: 40})
+	colored := "\x1b[1;31mFAIL\x1b[0m pkg/\x1b[32mok\x1b[0m \x1b]8;;https://example.com\x07LINK\x1b]8;;\x07\x1b[2K"
+	model = sendTo(t, model, tui.Message{Role: tui.RoleAssistant, Content: colored})
+
+	view := model.View()
+	if !strings.Contains(view, "FAIL pkg/ok LINK") {
+		t.Errorf("Expected the text without its escape codes in the feed, got:\n%s", view)
+	}
+	for _, code := range []string{"[1;31m", "[32m", "]8;;", "[2K"} {
+		if strings.Contains(view, code) {
+			t.Errorf("Expected %q to be stripped from the feed", code)
+		}
+	}
+}
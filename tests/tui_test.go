@@ -9,7 +9,9 @@ import (
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 	"github.com/cloudosai/ralph-go/internal/loop"
+	"github.com/cloudosai/ralph-go/internal/specs"
 	"github.com/cloudosai/ralph-go/internal/stats"
 	"github.com/cloudosai/ralph-go/internal/tmux"
 	"github.com/cloudosai/ralph-go/internal/tui"
@@ -438,6 +440,50 @@ func TestStatsWithLargeValues(t *testing.T) {
 	}
 }
 
+// TestCostThresholdNotification tests that crossing a cost threshold flashes
+// a notification once, which expires after a few seconds
+func TestCostThresholdNotification(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tui.SetTimeNowForTest(func() time.Time { return now })
+	defer tui.SetTimeNowForTest(time.Now)
+
+	model := tui.NewModel()
+	s := stats.NewTokenStats()
+	model.SetStats(s)
+	model.SetCostThresholds(1, 2)
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 120, Height: 40})
+
+	warn := "cost passed " + stats.FormatCost(1)
+	model, _ = updateModel(model, tui.TickMsgForTest())
+	if strings.Contains(model.View(), warn) {
+		t.Fatal("Expected no notification below the threshold")
+	}
+
+	s.AddCost(1.5)
+	model, _ = updateModel(model, tui.TickMsgForTest())
+	if !strings.Contains(model.View(), warn) {
+		t.Fatalf("Expected %q after crossing the warn threshold", warn)
+	}
+
+	now = now.Add(10 * time.Second)
+	model, _ = updateModel(model, tui.TickMsgForTest())
+	if strings.Contains(model.View(), warn) {
+		t.Error("Expected the notification to expire")
+	}
+
+	s.AddCost(0.1)
+	model, _ = updateModel(model, tui.TickMsgForTest())
+	if strings.Contains(model.View(), warn) {
+		t.Error("Expected a crossed threshold to notify only once")
+	}
+
+	s.AddCost(1)
+	model, _ = updateModel(model, tui.TickMsgForTest())
+	if crit := "cost passed " + stats.FormatCost(2); !strings.Contains(model.View(), crit) {
+		t.Errorf("Expected %q after crossing the crit threshold", crit)
+	}
+}
+
 // TestLoopProgressZeroZero tests loop display with 0/0
 func TestLoopProgressZeroZero(t *testing.T) {
 	model := tui.NewModel()
@@ -1175,6 +1221,115 @@ func TestTUIPauseResumeTimerFreezes(t *testing.T) {
 	}
 }
 
+// TestTUISoftPause tests that 'p' lets the running iteration finish before
+// pausing, and that a second 'p' interrupts it and freezes the timers.
+func TestTUISoftPause(t *testing.T) {
+	mockNow := time.Now()
+	tui.SetTimeNowForTest(func() time.Time { return mockNow })
+	defer tui.SetTimeNowForTest(time.Now)
+
+	l := loop.New(loop.Config{
+		Iterations:     3,
+		Prompt:         "test",
+		CommandBuilder: mockMediumSlowCommandBuilder,
+		SleepDuration:  10 * time.Millisecond,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	model := tui.NewModel()
+	model.SetLoop(l)
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 160, Height: 40})
+	l.Start(ctx)
+	for msg := range l.Output() {
+		if msg.Type == "output" && strings.Contains(msg.Content, `"type":"system"`) {
+			break
+		}
+	}
+	go func() {
+		for range l.Output() {
+		}
+	}()
+
+	keyP := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'p'}}
+	model, _ = updateModel(model, keyP)
+	if !l.IsPausePending() || l.IsPaused() {
+		t.Fatalf("Expected the first p to wait for the iteration, got pending=%v paused=%v", l.IsPausePending(), l.IsPaused())
+	}
+	view := model.View()
+	for _, want := range []string{"PAUSING AFTER THIS ITERATION", "(p) interrupt now", "Press p again"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("Expected %q in the view while the pause is pending", want)
+		}
+	}
+
+	model, _ = updateModel(model, keyP)
+	if l.IsPausePending() || !l.IsPaused() {
+		t.Fatalf("Expected the second p to interrupt the iteration, got pending=%v paused=%v", l.IsPausePending(), l.IsPaused())
+	}
+	view = model.View()
+	if !strings.Contains(view, "STOPPED") {
+		t.Error("Expected STOPPED after the second p")
+	}
+	mockNow = mockNow.Add(time.Minute)
+	if model.View() != view {
+		t.Error("Expected the timers frozen once paused")
+	}
+}
+
+// TestTUIQuitDrains tests that 'q' during an iteration lets it finish and
+// quits once the run has ended, and that a second 'q' quits at once.
+func TestTUIQuitDrains(t *testing.T) {
+	l := loop.New(loop.Config{
+		Iterations:     3,
+		Prompt:         "test",
+		CommandBuilder: mockMediumSlowCommandBuilder,
+		SleepDuration:  10 * time.Millisecond,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	doneChan := make(chan struct{})
+	model := tui.NewModelWithChannels(make(chan tui.Message), doneChan)
+	model.SetLoop(l)
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 160, Height: 40})
+	l.Start(ctx)
+	for msg := range l.Output() {
+		if msg.Type == "output" && strings.Contains(msg.Content, `"type":"system"`) {
+			break
+		}
+	}
+
+	keyQ := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'q'}}
+	model, cmd := updateModel(model, keyQ)
+	if cmd != nil || !l.IsDraining() {
+		t.Fatalf("Expected the first q to drain the loop rather than quit, got cmd=%v draining=%v", cmd != nil, l.IsDraining())
+	}
+	view := model.View()
+	for _, want := range []string{"QUITTING AFTER THIS ITERATION", "(q) quit now", "Press q again"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("Expected %q in the view while draining", want)
+		}
+	}
+
+	// The run ends after the iteration; its done message quits the TUI
+	for range l.Output() {
+	}
+	close(doneChan)
+	if _, cmd = updateModel(model, tui.WaitForDoneForTest(doneChan)()); cmd == nil {
+		t.Error("Expected the TUI to quit once the drained run ended")
+	}
+	if _, cmd = updateModel(model, keyQ); cmd == nil {
+		t.Error("Expected a second q to quit at once")
+	}
+
+	// With nothing running, a drain request quits at once
+	idle := tui.NewModel()
+	if _, cmd = updateModel(idle, tui.SendDrain()()); cmd == nil {
+		t.Error("Expected a drain with no iteration running to quit")
+	}
+}
+
 // TestTUIPauseResumeWithRunningLoop tests the full TUI + loop integration:
 // pressing 'p' pauses a running loop, pressing 'r' resumes it.
 func TestTUIPauseResumeWithRunningLoop(t *testing.T) {
@@ -1760,3 +1915,532 @@ func TestRoleLoopStoppedStyle(t *testing.T) {
 		t.Error("Style for RoleLoopStopped rendered empty string")
 	}
 }
+
+// TestContextWarningFlagsStatusTitle tests that a context warning is shown in the status title
+// and cleared when the next iteration starts
+func TestContextWarningFlagsStatusTitle(t *testing.T) {
+	model := tui.NewModel()
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 120, Height: 40})
+
+	model, _ = updateModel(model, tui.SendContextWarning("Context nearly full (190k/200k tokens)")())
+	if !strings.Contains(model.View(), "CONTEXT NEARLY FULL") {
+		t.Error("Expected status title to flag the context warning")
+	}
+
+	model, _ = updateModel(model, tui.SendLoopStarted()())
+	if strings.Contains(model.View(), "CONTEXT NEARLY FULL") {
+		t.Error("Expected context warning to clear on a new iteration")
+	}
+}
+
+// TestFooterOnTopLayout tests that --tui-layout top renders the footer above the activity panel
+func TestFooterOnTopLayout(t *testing.T) {
+	lineCounts := map[bool]int{}
+	for _, top := range []bool{false, true} {
+		model := tui.NewModel()
+		model.SetFooterOnTop(top)
+		model, _ = updateModel(model, tea.WindowSizeMsg{Width: 120, Height: 40})
+
+		view := model.View()
+		footerIdx := strings.Index(view, "Total Cost:")
+		activityIdx := strings.Index(view, "RUNNING")
+		if footerIdx < 0 || activityIdx < 0 {
+			t.Fatalf("Expected both footer and activity panel in view (top=%v)", top)
+		}
+		if top && footerIdx > activityIdx {
+			t.Error("Expected footer before the activity panel with the top layout")
+		}
+		if !top && footerIdx < activityIdx {
+			t.Error("Expected footer after the activity panel with the bottom layout")
+		}
+		lineCounts[top] = strings.Count(view, "\n") + 1
+	}
+	if lineCounts[true] != lineCounts[false] {
+		t.Errorf("Expected both layouts to use the same height, got top=%d bottom=%d", lineCounts[true], lineCounts[false])
+	}
+}
+
+// TestActivityUpdateFallsBackToCurrentTask tests that activity summaries only fill
+// the current task when no explicit task reference is known for the iteration
+func TestActivityUpdateFallsBackToCurrentTask(t *testing.T) {
+	model := tui.NewModel()
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 160, Height: 40})
+
+	model, _ = updateModel(model, tui.SendActivityUpdate("Implement the parser")())
+	if !strings.Contains(model.View(), "Implement the parser") {
+		t.Error("Expected activity summary as the current task")
+	}
+
+	model, _ = updateModel(model, tui.SendTaskUpdate("#3 Add flags")())
+	model, _ = updateModel(model, tui.SendActivityUpdate("Run the tests")())
+	view := model.View()
+	if !strings.Contains(view, "#3 Add flags") || strings.Contains(view, "Run the tests") {
+		t.Error("Expected explicit task to win over activity summaries")
+	}
+
+	model, _ = updateModel(model, tui.SendLoopStarted()())
+	model, _ = updateModel(model, tui.SendActivityUpdate("Run the tests")())
+	if !strings.Contains(model.View(), "Run the tests") {
+		t.Error("Expected activity summary to apply again after a new iteration starts")
+	}
+}
+
+// TestPlanFileDrivesTasks tests that the plan file's task counts and current
+// task win over tasks guessed from the agent's output while it has tasks
+func TestPlanFileDrivesTasks(t *testing.T) {
+	model := tui.NewModel()
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 160, Height: 40})
+
+	model, _ = updateModel(model, tui.SendPlanFileUpdate(1, 3, "#2 Add flags")())
+	model, _ = updateModel(model, tui.SendTaskUpdate("#7 Something else")())
+	model, _ = updateModel(model, tui.SendActivityUpdate("Run the tests")())
+	model, _ = updateModel(model, tui.SendPlanUpdate([]tui.PlanItem{{Content: "Read the code", Status: "in_progress"}})())
+	view := model.View()
+	if !strings.Contains(view, "1/3") || !strings.Contains(view, "#2 Add flags") || strings.Contains(view, "#7 Something else") {
+		t.Errorf("Expected the plan file's tasks in the footer, got:\n%s", view)
+	}
+
+	model, _ = updateModel(model, tui.SendPlanFileUpdate(2, 3, "#3 Write docs")())
+	if view := model.View(); !strings.Contains(view, "2/3") || !strings.Contains(view, "#3 Write docs") {
+		t.Error("Expected the footer to follow the plan file as it changes")
+	}
+
+	// A plan file without tasks hands the footer back to the agent's output
+	model, _ = updateModel(model, tui.SendPlanFileUpdate(0, 0, "")())
+	model, _ = updateModel(model, tui.SendTaskUpdate("#7 Something else")())
+	if !strings.Contains(model.View(), "#7 Something else") {
+		t.Error("Expected task references to apply again once the plan has no tasks")
+	}
+}
+
+func TestSubagentPanel(t *testing.T) {
+	model := tui.NewModel()
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 160, Height: 40})
+	if strings.Contains(model.ToolContentForTest(), "Subagents") {
+		t.Error("Expected no subagent panel without subagents")
+	}
+
+	model, _ = updateModel(model, tui.SendSubagentUpdate([]tui.SubagentItem{
+		{Label: "Explore the parser", Depth: 1, Status: "running", Tokens: 12000, CostUSD: 0.04, Estimated: true, ToolCalls: 3},
+		{Label: "Find callers", Depth: 2, Status: "completed", Tokens: 3000, CostUSD: 0.01},
+		{Label: "Review", Depth: 1, Status: "failed", Tokens: 500, CostUSD: 0.002},
+	})())
+	panel := model.ToolContentForTest()
+	for _, want := range []string{"Subagents (3, 1 running)", "included in the iteration", "Explore the parser", "~$", "3 tools", "✓ Find callers", "✗"} {
+		if !strings.Contains(panel, want) {
+			t.Errorf("Expected %q in the subagent panel, got:\n%s", want, panel)
+		}
+	}
+	lines := strings.Split(panel, "\n")
+	indentOf := func(label string) int {
+		for _, line := range lines {
+			if strings.Contains(line, label) {
+				return len(line) - len(strings.TrimLeft(line, " "))
+			}
+		}
+		return -1
+	}
+	if indentOf("Find callers") <= indentOf("Review") {
+		t.Error("Expected the nested subagent indented under its parent")
+	}
+	if !strings.Contains(model.View(), "(a) fold subagents") {
+		t.Error("Expected the fold key offered when subagents are nested")
+	}
+
+	model, _ = updateModel(model, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'a'}})
+	panel = model.ToolContentForTest()
+	if strings.Contains(panel, "Find callers") || !strings.Contains(panel, "1 nested") || !strings.Contains(panel, "Review") {
+		t.Errorf("Expected a folds nested subagents, got:\n%s", panel)
+	}
+
+	model, _ = updateModel(model, tui.SendSubagentUpdate(nil)())
+	if strings.Contains(model.ToolContentForTest(), "Subagents") {
+		t.Error("Expected the panel cleared for a new iteration")
+	}
+}
+
+// TestCollapseToolResults tests that the 'c' toggle folds consecutive tool
+// results into one summary line while assistant messages stay expanded
+func TestCollapseToolResults(t *testing.T) {
+	model := tui.NewModel()
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 120, Height: 40})
+
+	model.AddMessage(tui.Message{Role: tui.RoleAssistant, Content: "Reading the sources"})
+	for i := 0; i < 5; i++ {
+		model.AddMessage(tui.Message{Role: tui.RoleTool, Content: fmt.Sprintf("Read file%d.go", i)})
+		model.AddMessage(tui.Message{Role: tui.RoleUser, Content: fmt.Sprintf("contents of file%d\nline two\nline three", i)})
+	}
+	model.AddMessage(tui.Message{Role: tui.RoleAssistant, Content: "Done reading"})
+
+	expanded := model.ThinkingContentForTest()
+	model, _ = updateModel(model, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'c'}})
+	collapsed := model.ThinkingContentForTest()
+
+	if !strings.Contains(collapsed, "▸ 5 tool results — press c to expand") {
+		t.Errorf("Expected a summary line for the collapsed results, got:\n%s", collapsed)
+	}
+	if strings.Contains(collapsed, "contents of file0") {
+		t.Error("Collapsed view should hide tool result content")
+	}
+	for _, want := range []string{"Reading the sources", "Done reading"} {
+		if !strings.Contains(collapsed, want) {
+			t.Errorf("Assistant message %q should stay expanded", want)
+		}
+	}
+	if got, was := strings.Count(collapsed, "\n"), strings.Count(expanded, "\n"); got >= was {
+		t.Errorf("Expected collapsing to reduce rendered lines, got %d (expanded %d)", got, was)
+	}
+
+	model, _ = updateModel(model, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'c'}})
+	if model.ThinkingContentForTest() != expanded {
+		t.Error("Pressing c again should restore the expanded view")
+	}
+}
+
+// TestSubagentMessagesIndentedByDepth tests that subagent messages render
+// indented relative to the main agent's messages
+func TestSubagentMessagesIndentedByDepth(t *testing.T) {
+	model := tui.NewModel()
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 160, Height: 40})
+
+	model.AddMessage(tui.Message{Role: tui.RoleAssistant, Content: "MAIN_AGENT_TEXT"})
+	model.AddMessage(tui.Message{Role: tui.RoleAssistant, Content: "SUBAGENT_TEXT", Depth: 1})
+	model.AddMessage(tui.Message{Role: tui.RoleAssistant, Content: "NESTED_TEXT", Depth: 2})
+
+	content := model.ThinkingContentForTest()
+	column := func(marker string) int {
+		for _, line := range strings.Split(content, "\n") {
+			if idx := strings.Index(line, marker); idx >= 0 {
+				return lipgloss.Width(line[:idx])
+			}
+		}
+		t.Fatalf("%s not rendered in:\n%s", marker, content)
+		return -1
+	}
+
+	main, sub, nested := column("MAIN_AGENT_TEXT"), column("SUBAGENT_TEXT"), column("NESTED_TEXT")
+	if sub <= main {
+		t.Errorf("Expected subagent text indented past main agent text, got columns %d vs %d", sub, main)
+	}
+	if nested <= sub {
+		t.Errorf("Expected depth-2 text indented past depth-1 text, got columns %d vs %d", nested, sub)
+	}
+}
+
+// TestCloseAfterCompletion tests that --close-after quits the TUI once the
+// delay elapses after completion, and that the default stays open
+func TestCloseAfterCompletion(t *testing.T) {
+	model := tui.NewModel()
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 120, Height: 40})
+
+	if _, cmd := updateModel(model, tui.SendDone()()); cmd != nil {
+		t.Fatal("Expected no close timer without --close-after")
+	}
+
+	model.SetCloseAfter(time.Millisecond)
+	model, cmd := updateModel(model, tui.SendDone()())
+	if cmd == nil {
+		t.Fatal("Expected a close timer after completion")
+	}
+	stale := cmd()
+
+	// A second completion reschedules; the earlier timer must not close.
+	model, cmd = updateModel(model, tui.SendDone()())
+	model, _ = updateModel(model, stale)
+	if model.View() == "Goodbye!\n" {
+		t.Fatal("A superseded close timer should be ignored")
+	}
+
+	model, quitCmd := updateModel(model, cmd())
+	if model.View() != "Goodbye!\n" || quitCmd == nil {
+		t.Error("Expected the TUI to quit when the close timer fires")
+	}
+}
+
+// TestWrappedSessionCompletion tests that a tmux-wrapped run explains how to
+// continue or exit on completion, and reports when it closed itself
+func TestWrappedSessionCompletion(t *testing.T) {
+	model := tui.NewModel()
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 160, Height: 40})
+	model.SetWrappedSession("ralph-1a2b3c4d")
+
+	model, _ = updateModel(model, tui.SendDone()())
+	if content := model.ThinkingContentForTest(); !strings.Contains(content, "Run complete.") || !strings.Contains(content, "1a2b3c4d") {
+		t.Errorf("Expected completion instructions for the wrapped tmux session, got:\n%s", model.ThinkingContentForTest())
+	}
+	if model.AutoClosed() {
+		t.Error("Expected AutoClosed to be false until the close timer fires")
+	}
+
+	// With --close-after the run exits on its own instead of instructing
+	auto := tui.NewModel()
+	auto, _ = updateModel(auto, tea.WindowSizeMsg{Width: 160, Height: 40})
+	auto.SetWrappedSession("ralph-1a2b3c4d")
+	auto.SetCloseAfter(time.Millisecond)
+	auto, cmd := updateModel(auto, tui.SendDone()())
+	if strings.Contains(auto.ThinkingContentForTest(), "Run complete.") {
+		t.Error("Expected no instructions when the TUI will close itself")
+	}
+	auto, _ = updateModel(auto, cmd())
+	if !auto.AutoClosed() {
+		t.Error("Expected AutoClosed after the close timer fired")
+	}
+}
+
+// TestActivityRateInFooter tests the rolling messages-per-minute indicator:
+// it counts arrivals in the last minute on tick and freezes once completed
+func TestActivityRateInFooter(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tui.SetTimeNowForTest(func() time.Time { return now })
+	defer tui.SetTimeNowForTest(time.Now)
+
+	model := tui.NewModel()
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 160, Height: 40})
+	if !strings.Contains(model.View(), "Activity:") {
+		t.Fatal("Expected an Activity row in the footer")
+	}
+
+	for i := 0; i < 42; i++ {
+		model.AddMessage(tui.Message{Role: tui.RoleAssistant, Content: fmt.Sprintf("msg %d", i)})
+	}
+	model, _ = updateModel(model, tui.TickMsgForTest())
+	if !strings.Contains(model.View(), "~42 msg/min") {
+		t.Error("Expected ~42 msg/min after 42 messages within a minute")
+	}
+
+	// Messages older than a minute fall out of the window
+	now = now.Add(2 * time.Minute)
+	model.AddMessage(tui.Message{Role: tui.RoleAssistant, Content: "recent"})
+	model, _ = updateModel(model, tui.TickMsgForTest())
+	if !strings.Contains(model.View(), "~1 msg/min") {
+		t.Error("Expected only the last minute's messages to count")
+	}
+
+	// Completed: the rate is frozen at its last value
+	model, _ = updateModel(model, tui.SendDone()())
+	now = now.Add(5 * time.Minute)
+	model, _ = updateModel(model, tui.TickMsgForTest())
+	if !strings.Contains(model.View(), "~1 msg/min") {
+		t.Error("Expected the rate to freeze after completion")
+	}
+}
+
+// TestDetailPaneShowsLatestToolResult tests that 'd' toggles a pane holding
+// the latest tool result in full, without changing the layout's height
+func TestDetailPaneShowsLatestToolResult(t *testing.T) {
+	model := tui.NewModel()
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 120, Height: 40})
+	closedView := model.View()
+
+	var lines []string
+	for i := 0; i < 200; i++ {
+		lines = append(lines, fmt.Sprintf("diff line %d", i))
+	}
+	model.AddMessage(tui.Message{Role: tui.RoleTool, Content: "Bash git diff", ToolUseID: "t1", Status: "in_progress"})
+	model, _ = updateModel(model, tui.SendToolResult("t1", strings.Join(lines, "\n"))())
+
+	model, _ = updateModel(model, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'d'}})
+	view := model.View()
+	for _, want := range []string{"Tool result: Bash git diff", "diff line 0", "hide detail"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("Expected %q in view with the detail pane open", want)
+		}
+	}
+	if strings.Contains(view, "diff line 199") {
+		t.Error("Expected the detail pane to open scrolled to the top of the result")
+	}
+	if !strings.Contains(model.DetailContentForTest(), "diff line 199") {
+		t.Error("Expected the full result to be kept for the detail pane")
+	}
+	if got, want := strings.Count(view, "\n"), strings.Count(closedView, "\n"); got != want {
+		t.Errorf("Detail pane changed the view height: %d lines, want %d", got, want)
+	}
+
+	model, _ = updateModel(model, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'d'}})
+	if strings.Contains(model.View(), "Tool result: Bash git diff") {
+		t.Error("Pressing d again should hide the detail pane")
+	}
+}
+
+// TestDetailPaneCapsLargeResults tests that only a bounded prefix of a huge
+// tool result is kept
+func TestDetailPaneCapsLargeResults(t *testing.T) {
+	model := tui.NewModel()
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 120, Height: 40})
+	model, _ = updateModel(model, tui.SendToolResult("t1", strings.Repeat("x", 200<<10))())
+
+	content := model.DetailContentForTest()
+	if !strings.Contains(content, "more bytes)") {
+		t.Error("Expected a note about the dropped bytes")
+	}
+	if strings.Count(content, "x") > 64<<10 {
+		t.Errorf("Expected the stored result to be capped, kept %d bytes", strings.Count(content, "x"))
+	}
+}
+
+// TestSendDetailOpensPane tests that SendDetail (used for --plan-review)
+// opens the detail pane on the given content
+func TestSendDetailOpensPane(t *testing.T) {
+	model := tui.NewModel()
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 120, Height: 40})
+	model, _ = updateModel(model, tui.SendDetail("IMPLEMENTATION_PLAN.md", "## TASK 1\nWire up the parser")())
+
+	view := model.View()
+	for _, want := range []string{"IMPLEMENTATION_PLAN.md", "Wire up the parser", "hide detail"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("Expected %q in view after SendDetail", want)
+		}
+	}
+}
+
+// TestFooterShowsCacheHitRatio tests the cache-hit ratio next to Cache Read,
+// shown only once cache tokens have been recorded
+func TestFooterShowsCacheHitRatio(t *testing.T) {
+	model := tui.NewModel()
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 160, Height: 40})
+	if strings.Contains(model.View(), "% hit") {
+		t.Error("Expected no cache-hit ratio before any cache activity")
+	}
+
+	s := stats.NewTokenStats()
+	s.AddUsage(100, 100, 250, 750)
+	model.SetStats(s)
+	if !strings.Contains(model.View(), "(75% hit)") {
+		t.Error("Expected (75% hit) in the footer")
+	}
+}
+
+// TestIterationsPaneShowsPerIterationUsage tests that 'i' opens a table of
+// each iteration's tokens, cost, duration and tool calls in the detail pane
+func TestIterationsPaneShowsPerIterationUsage(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tui.SetTimeNowForTest(func() time.Time { return now })
+	defer tui.SetTimeNowForTest(time.Now)
+
+	s := stats.NewTokenStats()
+	model := tui.NewModel()
+	model.SetStats(s)
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 120, Height: 40})
+	closedView := model.View()
+
+	model, _ = updateModel(model, tui.SendLoopUpdate(1, 3)())
+	model, _ = updateModel(model, tui.SendLoopStarted()())
+	s.AddUsage(10000, 2000, 0, 0)
+	s.AddCost(1.25)
+	model, _ = updateModel(model, tui.SendMessage(tui.Message{Role: tui.RoleTool, Content: "Read main.go", ToolUseID: "t1", Status: "in_progress"})())
+	model, _ = updateModel(model, tui.SendMessage(tui.Message{Role: tui.RoleTool, Content: "Edit main.go", ToolUseID: "t2", Status: "in_progress"})())
+	now = now.Add(2 * time.Minute)
+	model, _ = updateModel(model, tui.SendLoopUpdate(2, 3)())
+	model, _ = updateModel(model, tui.SendLoopStarted()())
+	s.AddUsage(500, 0, 0, 0)
+	s.AddCost(0.25)
+	now = now.Add(10 * time.Second)
+
+	model, _ = updateModel(model, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'i'}})
+	view := model.View()
+	for _, want := range []string{"Iterations", "Tokens", "Duration", "Tools", "12k", "$1.25", "00:02:00", "hide iterations", "Total", "$1.50"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("Expected %q in view with the iterations pane open", want)
+		}
+	}
+	if got, want := strings.Count(view, "\n"), strings.Count(closedView, "\n"); got != want {
+		t.Errorf("Iterations pane changed the view height: %d lines, want %d", got, want)
+	}
+
+	if strings.Contains(view, "Model") {
+		t.Error("Expected no Model column before any iteration's model is known")
+	}
+	model, _ = updateModel(model, tui.SendIterationModel("claude-opus-4-5")())
+	if view := model.View(); !strings.Contains(view, "Model") || !strings.Contains(view, "claude-opus-4-5") {
+		t.Errorf("Expected the running iteration's model in the table, got:\n%s", view)
+	}
+
+	// 'd' switches the pane to the latest tool result
+	model, _ = updateModel(model, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'d'}})
+	if view := model.View(); strings.Contains(view, "hide iterations") || !strings.Contains(view, "hide detail") {
+		t.Error("Pressing d should switch the pane to the tool result")
+	}
+
+	model, _ = updateModel(model, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'i'}})
+	model, _ = updateModel(model, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'i'}})
+	if strings.Contains(model.View(), "Duration") {
+		t.Error("Pressing i again should hide the iterations pane")
+	}
+}
+
+func TestSpecPicker(t *testing.T) {
+	modified := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	files := []specs.File{
+		{Name: "auth.md", Size: 812, ModTime: modified},
+		{Name: "billing.md", Size: 4300, ModTime: modified},
+		{Name: "search.md", Size: 90, ModTime: modified},
+	}
+	var m tea.Model = tui.NewSpecPicker("specs/", files)
+	press := func(key tea.KeyMsg) tea.Cmd {
+		var cmd tea.Cmd
+		m, cmd = m.Update(key)
+		return cmd
+	}
+	chosen := func() string { return strings.Join(specs.Names(m.(tui.SpecPicker).Chosen()), ",") }
+
+	view := m.View()
+	if !strings.Contains(view, "specs/") || !strings.Contains(view, "4.2 KB") || !strings.Contains(view, "2026-10-16 09:30") {
+		t.Errorf("Expected the folder and each file's size and date, got:\n%s", view)
+	}
+	if chosen() != "auth.md,billing.md,search.md" {
+		t.Errorf("Expected every spec chosen at first, got %s", chosen())
+	}
+
+	press(tea.KeyMsg{Type: tea.KeyDown})
+	press(tea.KeyMsg{Type: tea.KeySpace})
+	if chosen() != "auth.md,search.md" {
+		t.Errorf("Expected space to drop billing.md, got %s", chosen())
+	}
+	press(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'a'}})
+	if chosen() != "auth.md,billing.md,search.md" {
+		t.Errorf("Expected a to choose every spec when some are not, got %s", chosen())
+	}
+	press(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'a'}})
+	if chosen() != "" {
+		t.Errorf("Expected a to clear the choice when all are chosen, got %s", chosen())
+	}
+	if cmd := press(tea.KeyMsg{Type: tea.KeyEnter}); cmd != nil || m.(tui.SpecPicker).Confirmed() {
+		t.Error("Expected enter with nothing chosen to be refused")
+	}
+	if !strings.Contains(m.View(), "at least one spec") {
+		t.Error("Expected a notice when enter is pressed with nothing chosen")
+	}
+
+	press(tea.KeyMsg{Type: tea.KeyUp})
+	press(tea.KeyMsg{Type: tea.KeySpace})
+	if cmd := press(tea.KeyMsg{Type: tea.KeyEnter}); cmd == nil || !m.(tui.SpecPicker).Confirmed() {
+		t.Fatal("Expected enter to confirm the choice and quit")
+	}
+	if chosen() != "auth.md" {
+		t.Errorf("Expected auth.md alone chosen, got %s", chosen())
+	}
+
+	m = tui.NewSpecPicker("specs/", files)
+	if cmd := press(tea.KeyMsg{Type: tea.KeyEsc}); cmd == nil || m.(tui.SpecPicker).Confirmed() {
+		t.Error("Expected esc to cancel")
+	}
+}
+
+// TestTUIShowsTimeLeft tests that a run with a time limit counts down the
+// time it has left next to its total time.
+func TestTUIShowsTimeLeft(t *testing.T) {
+	l := loop.New(loop.Config{Iterations: 3, Prompt: "test", Deadline: time.Now().Add(2*time.Hour + 30*time.Second)})
+	model := tui.NewModel()
+	model.SetLoop(l)
+	model, _ = updateModel(model, tea.WindowSizeMsg{Width: 160, Height: 40})
+	if view := model.View(); !strings.Contains(view, "(2h1m left)") {
+		t.Errorf("Expected the time left in the view, got:\n%s", view)
+	}
+
+	plain := tui.NewModel()
+	plain.SetLoop(loop.New(loop.Config{Iterations: 3, Prompt: "test"}))
+	plain, _ = updateModel(plain, tea.WindowSizeMsg{Width: 160, Height: 40})
+	if strings.Contains(plain.View(), " left)") {
+		t.Error("Did not expect a time left without a time limit")
+	}
+}
@@ -0,0 +1,325 @@
+package tests
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudosai/ralph-go/internal/vcs"
+)
+
+// initRepo creates a git repository in a temp dir with one commit.
+func initRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"config", "user.email", "ralph@example.com"},
+		{"config", "user.name", "ralph"},
+		{"commit", "-q", "--allow-empty", "-m", "initial"},
+	} {
+		gitIn(t, dir, args...)
+	}
+	return dir
+}
+
+func gitIn(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+}
+
+func TestVCSCleanTree(t *testing.T) {
+	dir := initRepo(t)
+
+	dirty, err := vcs.HasUncommittedChanges(dir, nil)
+	if err != nil {
+		t.Fatalf("HasUncommittedChanges: %v", err)
+	}
+	if dirty {
+		t.Error("Expected a clean tree after the initial commit")
+	}
+
+	// An iteration with nothing to change: HEAD unchanged, tree clean
+	head, err := vcs.HeadCommit(dir)
+	if err != nil || head == "" {
+		t.Fatalf("HeadCommit = %q, %v", head, err)
+	}
+	forgot, err := vcs.ForgotToCommit(dir, head, head, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forgot {
+		t.Error("A clean tree with no new commit should not be reported as a forgotten commit")
+	}
+}
+
+func TestVCSDirtyTree(t *testing.T) {
+	dir := initRepo(t)
+	before, _ := vcs.HeadCommit(dir)
+
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dirty, err := vcs.HasUncommittedChanges(dir, nil)
+	if err != nil {
+		t.Fatalf("HasUncommittedChanges: %v", err)
+	}
+	if !dirty {
+		t.Error("Expected an untracked file to count as an uncommitted change")
+	}
+	forgot, err := vcs.ForgotToCommit(dir, before, before, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !forgot {
+		t.Error("Expected uncommitted work with no new commit to be reported")
+	}
+
+	// Committing the work moves HEAD and cleans the tree
+	gitIn(t, dir, "add", "-A")
+	gitIn(t, dir, "commit", "-q", "-m", "add main")
+	after, _ := vcs.HeadCommit(dir)
+	if !vcs.LastCommitChanged(before, after) {
+		t.Error("Expected HEAD to change after a commit")
+	}
+	if forgot, _ := vcs.ForgotToCommit(dir, before, after, nil); forgot {
+		t.Error("A new commit should not be reported as a forgotten commit")
+	}
+}
+
+func TestVCSOutsideRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	if _, err := vcs.HasUncommittedChanges(dir, nil); err == nil {
+		t.Error("Expected an error outside a git repository")
+	}
+	if _, err := vcs.HeadCommit(dir); err == nil {
+		t.Error("Expected an error outside a git repository")
+	}
+}
+
+func TestVCSHeadCommitUnbornBranch(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	gitIn(t, dir, "init", "-q")
+	head, err := vcs.HeadCommit(dir)
+	if err != nil || head != "" {
+		t.Errorf("HeadCommit in an empty repository = %q, %v; want \"\", nil", head, err)
+	}
+}
+
+func TestVCSCommitsSince(t *testing.T) {
+	dir := initRepo(t)
+	start, _ := vcs.HeadCommit(dir)
+
+	commits, err := vcs.CommitsSince(dir, start)
+	if err != nil || len(commits) != 0 {
+		t.Fatalf("Expected no commits since the start, got %v, %v", commits, err)
+	}
+
+	gitIn(t, dir, "commit", "-q", "--allow-empty", "-m", "Add the parser", "-m", "Body text")
+	gitIn(t, dir, "commit", "-q", "--allow-empty", "-m", "Fix the build")
+
+	commits, err = vcs.CommitsSince(dir, start)
+	if err != nil {
+		t.Fatalf("CommitsSince: %v", err)
+	}
+	if len(commits) != 2 || commits[0].Subject != "Add the parser" || commits[1].Subject != "Fix the build" {
+		t.Fatalf("Expected the two new commits oldest first, got %+v", commits)
+	}
+	if commits[0].Hash == "" {
+		t.Error("Expected an abbreviated hash")
+	}
+
+	// An empty ref (HEAD was unborn at the start) lists every commit
+	all, err := vcs.CommitsSince(dir, "")
+	if err != nil || len(all) != 3 || all[0].Subject != "initial" {
+		t.Errorf("Expected all 3 commits for an empty ref, got %+v, %v", all, err)
+	}
+}
+
+func TestVCSCommitsSinceUnbornHead(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	gitIn(t, dir, "init", "-q")
+	commits, err := vcs.CommitsSince(dir, "")
+	if err != nil || len(commits) != 0 {
+		t.Errorf("Expected no commits in an empty repository, got %v, %v", commits, err)
+	}
+}
+
+func TestVCSAddWorktrees(t *testing.T) {
+	dir := initRepo(t)
+
+	trees, err := vcs.AddWorktrees(dir, "run1", 2)
+	if err != nil {
+		t.Fatalf("AddWorktrees: %v", err)
+	}
+	if len(trees) != 2 {
+		t.Fatalf("Expected 2 worktrees, got %+v", trees)
+	}
+	for i, wt := range trees {
+		if want := filepath.Join(vcs.WorktreeDir, "run1-"+string(rune('1'+i))); !strings.HasSuffix(wt.Dir, want) {
+			t.Errorf("Worktree %d at %s, want it under %s", i, wt.Dir, want)
+		}
+		branch, err := exec.Command("git", "-C", wt.Dir, "branch", "--show-current").Output()
+		if err != nil || strings.TrimSpace(string(branch)) != wt.Branch {
+			t.Errorf("Worktree %d on branch %q (%v), want %s", i, branch, err, wt.Branch)
+		}
+	}
+	if dirty, err := vcs.HasUncommittedChanges(dir, nil); err != nil || dirty {
+		t.Errorf("Expected the worktrees to stay out of git status, dirty=%v err=%v", dirty, err)
+	}
+
+	// The branches exist now, so a second set with the same name fails
+	// without leaving a partial set behind
+	if _, err := vcs.AddWorktrees(dir, "run1", 1); err == nil {
+		t.Error("Expected reusing a branch name to fail")
+	}
+}
+
+func TestVCSAddWorktreesNeedsACommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	gitIn(t, dir, "init", "-q")
+	if _, err := vcs.AddWorktrees(dir, "run1", 2); err == nil || !strings.Contains(err.Error(), "commit") {
+		t.Errorf("Expected an error about the missing commit, got %v", err)
+	}
+}
+
+func TestVCSCheckoutBranch(t *testing.T) {
+	dir := initRepo(t)
+	current := func() string {
+		out, err := exec.Command("git", "-C", dir, "branch", "--show-current").Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	main := current()
+
+	if err := vcs.CheckoutBranch(dir, "ralph/run-1"); err != nil {
+		t.Fatalf("CheckoutBranch: %v", err)
+	}
+	if got := current(); got != "ralph/run-1" {
+		t.Errorf("Expected the new branch checked out, got %q", got)
+	}
+
+	// An existing branch is checked out again, as when resuming a run
+	gitIn(t, dir, "checkout", "-q", main)
+	if err := vcs.CheckoutBranch(dir, "ralph/run-1"); err != nil {
+		t.Fatalf("CheckoutBranch on an existing branch: %v", err)
+	}
+	if got := current(); got != "ralph/run-1" {
+		t.Errorf("Expected the existing branch checked out, got %q", got)
+	}
+
+	if err := vcs.CheckoutBranch(dir, "bad..name"); err == nil {
+		t.Error("Expected an invalid branch name to be rejected")
+	}
+}
+
+func TestVCSChangesSince(t *testing.T) {
+	dir := initRepo(t)
+	start, _ := vcs.HeadCommit(dir)
+
+	changes, err := vcs.ChangesSince(dir, start, nil)
+	if err != nil || changes != (vcs.Changes{}) {
+		t.Fatalf("Expected no changes since the start, got %+v, %v", changes, err)
+	}
+	if got := changes.String(); got != "no commit" {
+		t.Errorf("String() = %q, want \"no commit\"", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("one\ntwo\nthree\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitIn(t, dir, "add", "a.txt")
+	gitIn(t, dir, "commit", "-q", "-m", "Add a")
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("one\n2\nthree\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte("b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitIn(t, dir, "add", "a.txt", "b.txt")
+	gitIn(t, dir, "commit", "-q", "-m", "Change a, add b")
+
+	changes, err = vcs.ChangesSince(dir, start, nil)
+	if err != nil {
+		t.Fatalf("ChangesSince: %v", err)
+	}
+	want := vcs.Changes{Commits: 2, Files: 2, Insertions: 4}
+	if changes != want {
+		t.Errorf("Expected %+v, got %+v", want, changes)
+	}
+	if got := changes.String(); got != "2 commits, 2 files changed, +4 -0" {
+		t.Errorf("String() = %q", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "c.txt"), []byte("c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	head, _ := vcs.HeadCommit(dir)
+	changes, err = vcs.ChangesSince(dir, head, nil)
+	if err != nil || !changes.Uncommitted || changes.String() != "no commit, uncommitted changes left" {
+		t.Errorf("Expected uncommitted changes noted, got %+v (%q), %v", changes, changes, err)
+	}
+}
+
+func TestVCSExcludeDirs(t *testing.T) {
+	dir := initRepo(t)
+	start, _ := vcs.HeadCommit(dir)
+	exclude := []string{"dist"}
+
+	// Build output in an excluded directory leaves the tree clean
+	if err := os.MkdirAll(filepath.Join(dir, "dist"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "dist", "app.js"), []byte("one\ntwo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if dirty, err := vcs.HasUncommittedChanges(dir, exclude); err != nil || dirty {
+		t.Errorf("Expected changes in dist to be ignored, dirty=%v err=%v", dirty, err)
+	}
+	if dirty, _ := vcs.HasUncommittedChanges(dir, nil); !dirty {
+		t.Error("Expected changes in dist to count without excluding it")
+	}
+	if forgot, err := vcs.ForgotToCommit(dir, start, start, exclude); err != nil || forgot {
+		t.Errorf("Expected changes only in dist not to be reported as a forgotten commit, got %v, %v", forgot, err)
+	}
+
+	// A commit touching both counts only the lines outside dist
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitIn(t, dir, "add", "-A")
+	gitIn(t, dir, "commit", "-q", "-m", "Add a and build")
+	if err := os.WriteFile(filepath.Join(dir, "dist", "app.js"), []byte("three\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := vcs.ChangesSince(dir, start, exclude)
+	if err != nil {
+		t.Fatalf("ChangesSince: %v", err)
+	}
+	if want := (vcs.Changes{Commits: 1, Files: 1, Insertions: 1}); changes != want {
+		t.Errorf("Expected %+v, got %+v", want, changes)
+	}
+}